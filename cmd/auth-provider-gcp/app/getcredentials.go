@@ -36,6 +36,10 @@ const (
 	gcrAuthFlow             = "gcr"
 	dockerConfigAuthFlow    = "dockercfg"
 	dockerConfigURLAuthFlow = "dockercfg-url"
+	// workloadIdentityFederationAuthFlow exchanges the credential of the
+	// external account configuration of GOOGLE_APPLICATION_CREDENTIALS for
+	// the access token.
+	workloadIdentityFederationAuthFlow = "workload-identity-federation"
 )
 
 // CredentialOptions contains a representation of the options passed to the credential provider.
@@ -50,7 +54,7 @@ type AuthFlowFlagError struct {
 
 // Error implements error.Error.
 func (a *AuthFlowFlagError) Error() string {
-	return fmt.Sprintf("invalid value %q for authFlow (must be one of %q, %q, or %q)", a.flagValue, gcrAuthFlow, dockerConfigAuthFlow, dockerConfigURLAuthFlow)
+	return fmt.Sprintf("invalid value %q for authFlow (must be one of %q, %q, %q, or %q)", a.flagValue, gcrAuthFlow, dockerConfigAuthFlow, dockerConfigURLAuthFlow, workloadIdentityFederationAuthFlow)
 }
 
 // Is implements the Is function that errors.Is checks for.
@@ -101,6 +105,8 @@ func providerFromFlow(flow string) (credentialconfig.DockerConfigProvider, error
 		return provider.MakeDockerConfigProvider(transport), nil
 	case dockerConfigURLAuthFlow:
 		return provider.MakeDockerConfigURLProvider(transport), nil
+	case workloadIdentityFederationAuthFlow:
+		return provider.MakeWorkloadIdentityFederationProvider(transport), nil
 	default:
 		return nil, &AuthFlowTypeError{requestedFlow: flow}
 	}
@@ -136,11 +142,13 @@ func getCredentials(authFlow string) error {
 }
 
 func defineFlags(credCmd *cobra.Command, options *CredentialOptions) {
-	credCmd.Flags().StringVarP(&options.AuthFlow, "authFlow", "a", gcrAuthFlow, fmt.Sprintf("authentication flow (valid values are %q, %q, and %q)", gcrAuthFlow, dockerConfigAuthFlow, dockerConfigURLAuthFlow))
+	credCmd.Flags().StringVarP(&options.AuthFlow, "authFlow", "a", gcrAuthFlow, fmt.Sprintf("authentication flow (valid values are %q, %q, %q, and %q)", gcrAuthFlow, dockerConfigAuthFlow, dockerConfigURLAuthFlow, workloadIdentityFederationAuthFlow))
 }
 
 func validateFlags(options *CredentialOptions) error {
-	if options.AuthFlow != gcrAuthFlow && options.AuthFlow != dockerConfigAuthFlow && options.AuthFlow != dockerConfigURLAuthFlow {
+	switch options.AuthFlow {
+	case gcrAuthFlow, dockerConfigAuthFlow, dockerConfigURLAuthFlow, workloadIdentityFederationAuthFlow:
+	default:
 		return &AuthFlowFlagError{flagValue: options.AuthFlow}
 	}
 	return nil
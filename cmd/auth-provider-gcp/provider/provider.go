@@ -37,6 +37,9 @@ const (
 	metadataHTTPClientTimeout = time.Second * 10
 	apiKind                   = "CredentialProviderResponse"
 	apiVersion                = "credentialprovider.kubelet.k8s.io/v1"
+	// credentialsFileKey is the environment variable of the external account
+	// credential configuration of WorkloadIdentityFederationProvider.
+	credentialsFileKey = "GOOGLE_APPLICATION_CREDENTIALS"
 )
 
 // MakeRegistryProvider returns a ContainerRegistryProvider with the given transport.
@@ -66,6 +69,17 @@ func MakeDockerConfigURLProvider(transport *http.Transport) *gcpcredential.Docke
 	return provider
 }
 
+// MakeWorkloadIdentityFederationProvider returns a WorkloadIdentityFederationProvider with the given transport,
+// using the external account credential configuration of the GOOGLE_APPLICATION_CREDENTIALS environment variable.
+func MakeWorkloadIdentityFederationProvider(transport *http.Transport) *gcpcredential.WorkloadIdentityFederationProvider {
+	httpClient := makeHTTPClient(transport)
+	provider := &gcpcredential.WorkloadIdentityFederationProvider{
+		CredentialsFile: os.Getenv(credentialsFileKey),
+		Client:          httpClient,
+	}
+	return provider
+}
+
 func makeHTTPClient(transport *http.Transport) *http.Client {
 	return &http.Client{
 		Transport: transport,
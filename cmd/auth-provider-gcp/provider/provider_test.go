@@ -22,8 +22,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/cloud-provider-gcp/pkg/gcpcredential"
@@ -43,9 +46,9 @@ func hasURL(url string, response *credentialproviderapi.CredentialProviderRespon
 	return ok
 }
 
-func TestContainerRegistry(t *testing.T) {
-	// Taken from from pkg/credentialprovider/gcp/metadata_test.go in kubernetes/kubernetes
-	registryURL := "container.cloud.google.com"
+// registryTransport returns a transport which reroutes all traffic to a fake
+// metadata server with a default service account with the storage scope.
+func registryTransport(t *testing.T) *http.Transport {
 	token := &gcpcredential.TokenBlob{AccessToken: dummyToken} // Fake value for testing.
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defaultPrefix := "/computeMetadata/v1/instance/service-accounts/default/"
@@ -73,13 +76,19 @@ func TestContainerRegistry(t *testing.T) {
 			http.Error(w, "", http.StatusNotFound)
 		}
 	}))
-	defer server.Close()
+	t.Cleanup(server.Close)
 	// Make a transport that reroutes all traffic to the example server
-	transport := utilnet.SetTransportDefaults(&http.Transport{
+	return utilnet.SetTransportDefaults(&http.Transport{
 		Proxy: func(req *http.Request) (*url.URL, error) {
 			return url.Parse(server.URL + req.URL.Path)
 		},
 	})
+}
+
+func TestContainerRegistry(t *testing.T) {
+	// Taken from from pkg/credentialprovider/gcp/metadata_test.go in kubernetes/kubernetes
+	registryURL := "container.cloud.google.com"
+	transport := registryTransport(t)
 	provider := MakeRegistryProvider(transport)
 	response, err := GetResponse(dummyImage, provider)
 	if err != nil {
@@ -210,3 +219,122 @@ func TestConfigURLProvider(t *testing.T) {
 		}
 	}
 }
+
+func TestArtifactRegistry(t *testing.T) {
+	transport := registryTransport(t)
+	provider := MakeRegistryProvider(transport)
+	for image, wantURL := range map[string]string{
+		"us-docker.pkg.dev/project/repo/image":                   "us-docker.pkg.dev",
+		"europe-west1-docker.pkg.dev/project/virtual-repo/image": "europe-west1-docker.pkg.dev",
+		"us-central1-docker.pkg.dev:443/project/repo/image:tag":  "us-central1-docker.pkg.dev:443",
+		"psc.us-central1-docker.pkg.dev/project/repo/image":      "psc.us-central1-docker.pkg.dev",
+	} {
+		response, err := GetResponse(image, provider)
+		if err != nil {
+			t.Fatalf("Unexpected error while getting response of %s: %s", image, err.Error())
+		}
+		if !hasURL(wantURL, response) {
+			t.Errorf("URL %s expected in response of %s, not found (response: %s)", wantURL, image, response.Auth)
+		}
+		if auth := response.Auth[wantURL]; auth.Password != dummyToken {
+			t.Errorf("Expected password %s for %s not found (password: %s)", dummyToken, wantURL, auth.Password)
+		}
+	}
+
+	response, err := GetResponse(dummyImage, provider)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting response: %s", err.Error())
+	}
+	if hasURL("registry.k8s.io", response) {
+		t.Errorf("URL registry.k8s.io not expected in response (response: %s)", response.Auth)
+	}
+}
+
+func TestWorkloadIdentityFederation(t *testing.T) {
+	const (
+		subjectToken       = "oidc-token-of-another-cloud"
+		federatedToken     = "federated-token"
+		serviceAccount     = "puller@project.iam.gserviceaccount.com"
+		impersonatedToken  = "ya29.impersonated-token"
+		impersonationPath  = "/v1/projects/-/serviceAccounts/" + serviceAccount + ":generateAccessToken"
+		workloadIdentityID = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+	)
+	exchanges := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/token":
+			if err := r.ParseForm(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got := r.Form.Get("subject_token"); got != subjectToken {
+				t.Errorf("Expected subject token %s (got %s instead)", subjectToken, got)
+			}
+			if got := r.Form.Get("audience"); got != workloadIdentityID {
+				t.Errorf("Expected audience %s (got %s instead)", workloadIdentityID, got)
+			}
+			exchanges++
+			fmt.Fprintf(w, `{"access_token": %q, "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 3600}`, federatedToken)
+		case impersonationPath:
+			if got := r.Header.Get("Authorization"); got != "Bearer "+federatedToken {
+				t.Errorf("Expected the federated token (got %s instead)", got)
+			}
+			fmt.Fprintf(w, `{"accessToken": %q, "expireTime": %q}`, impersonatedToken, time.Now().Add(time.Hour).Format(time.RFC3339))
+		default:
+			http.Error(w, "", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	subjectTokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(subjectTokenFile, []byte(subjectToken), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	config := fmt.Sprintf(`{
+  "type": "external_account",
+  "audience": %q,
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": %q,
+  "service_account_impersonation_url": %q,
+  "credential_source": {"file": %q}
+}`, workloadIdentityID, server.URL+"/v1/token", server.URL+impersonationPath, subjectTokenFile)
+	if err := os.WriteFile(credentialsFile, []byte(config), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialsFile)
+
+	provider := MakeWorkloadIdentityFederationProvider(utilnet.SetTransportDefaults(&http.Transport{}))
+	if !provider.Enabled() {
+		t.Fatalf("Expected the provider to be enabled")
+	}
+	images := []string{"us-docker.pkg.dev/project/repo/image", dummyImage}
+	for _, image := range images {
+		response, err := GetResponse(image, provider)
+		if err != nil {
+			t.Fatalf("Unexpected error while getting response: %s", err.Error())
+		}
+		for _, registryURL := range []string{"container.cloud.google.com", "*.pkg.dev"} {
+			if !hasURL(registryURL, response) {
+				t.Errorf("URL %s expected in response, not found (response: %s)", registryURL, response.Auth)
+			}
+		}
+		for _, auth := range response.Auth {
+			if expectedUsername != auth.Username {
+				t.Errorf("Expected username %s not found (username: %s)", expectedUsername, auth.Username)
+			}
+			if impersonatedToken != auth.Password {
+				t.Errorf("Expected password %s not found (password: %s)", impersonatedToken, auth.Password)
+			}
+		}
+	}
+	if exchanges != len(images) {
+		t.Errorf("Expected the token to be exchanged once per response (exchanged %d times)", exchanges)
+	}
+
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", subjectTokenFile)
+	if MakeWorkloadIdentityFederationProvider(utilnet.SetTransportDefaults(&http.Transport{})).Enabled() {
+		t.Errorf("Expected the provider to be disabled without an external account configuration")
+	}
+}
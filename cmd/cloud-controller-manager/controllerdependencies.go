@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/names"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+)
+
+// controllerDependency is a controller which does not work as expected when
+// another controller is disabled, e.g. in the layered deployments where some
+// controllers run in another component.
+type controllerDependency struct {
+	controller string
+	dependency string
+	// consequence is what goes wrong without the dependency.
+	consequence string
+}
+
+var controllerDependencies = []controllerDependency{
+	{names.ServiceLBController, names.CloudNodeController, "the new nodes are not labeled with their zone, the internal load balancers skip them"},
+	{names.ServiceLBController, names.CloudNodeLifecycleController, "the nodes of the deleted instances are not deleted, they stay in the backends of the load balancers"},
+	{names.NodeRouteController, names.CloudNodeLifecycleController, "the nodes of the deleted instances are not deleted, nor are their routes"},
+	{"firewallconsolidation", names.ServiceLBController, "the firewall rules of the load balancers ensured elsewhere are consolidated"},
+	{"loadbalancerforecast", names.ServiceLBController, "the forecast counts the resources of the load balancers ensured elsewhere"},
+	{"nodelabels", names.CloudNodeController, "the new nodes are not initialized with their provider ID, the labels of their instance are not updated"},
+	{"nodedns", names.CloudNodeLifecycleController, "the nodes of the deleted instances are not deleted, nor are their DNS records"},
+	{"healthnotification", names.CloudNodeLifecycleController, "the nodes of the deleted instances are not deleted, nor are their deletions notified"},
+}
+
+// controllerFlags are the flags enabling the features of the controllers.
+type controllerFlags struct {
+	configureCloudRoutes  bool
+	internalLoadBalancers bool
+	externalLoadBalancers bool
+}
+
+// controllerDependencyWarnings returns a warning for each enabled controller
+// whose dependency is disabled, or whose features are all disabled by flags.
+// controllers are the canonical names of the --controllers flag.
+func controllerDependencyWarnings(controllers []string, disabledByDefault sets.String, flags controllerFlags) []string {
+	enabled := func(name string) bool {
+		return genericcontrollermanager.IsControllerEnabled(name, disabledByDefault, controllers)
+	}
+
+	var warnings []string
+	for _, d := range controllerDependencies {
+		if enabled(d.controller) && !enabled(d.dependency) {
+			warnings = append(warnings, fmt.Sprintf("controller %s is enabled without controller %s: %s", d.controller, d.dependency, d.consequence))
+		}
+	}
+	if enabled(names.NodeRouteController) && !flags.configureCloudRoutes {
+		warnings = append(warnings, fmt.Sprintf("controller %s is enabled with --configure-cloud-routes=false, it does not run", names.NodeRouteController))
+	}
+	if enabled(names.ServiceLBController) && !flags.internalLoadBalancers && !flags.externalLoadBalancers {
+		warnings = append(warnings, fmt.Sprintf("controller %s is enabled with --%s=false and --%s=false, it does not ensure any load balancer", names.ServiceLBController, gce.InternalLoadBalancersFlag, gce.ExternalLoadBalancersFlag))
+	}
+	return warnings
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestControllerDependencyWarnings(t *testing.T) {
+	allFlags := controllerFlags{configureCloudRoutes: true, internalLoadBalancers: true, externalLoadBalancers: true}
+	disabledByDefault := sets.NewString("firewallconsolidation", "loadbalancerforecast", "nodedns", "nodelabels", "healthnotification")
+
+	for _, tc := range []struct {
+		desc        string
+		controllers []string
+		flags       controllerFlags
+		want        []string
+	}{
+		{
+			desc:        "default controllers",
+			controllers: []string{"*"},
+			flags:       allFlags,
+		},
+		{
+			desc:        "service without node lifecycle",
+			controllers: []string{"*", "-cloud-node-lifecycle-controller"},
+			flags:       allFlags,
+			want: []string{
+				"controller service-lb-controller is enabled without controller cloud-node-lifecycle-controller: the nodes of the deleted instances are not deleted, they stay in the backends of the load balancers",
+				"controller node-route-controller is enabled without controller cloud-node-lifecycle-controller: the nodes of the deleted instances are not deleted, nor are their routes",
+			},
+		},
+		{
+			desc:        "node lifecycle without service",
+			controllers: []string{"cloud-node-controller", "cloud-node-lifecycle-controller"},
+			flags:       controllerFlags{},
+		},
+		{
+			desc:        "firewall consolidation without service",
+			controllers: []string{"firewallconsolidation", "cloud-node-controller"},
+			flags:       allFlags,
+			want:        []string{"controller firewallconsolidation is enabled without controller service-lb-controller: the firewall rules of the load balancers ensured elsewhere are consolidated"},
+		},
+		{
+			desc:        "node DNS without node lifecycle",
+			controllers: []string{"nodedns", "cloud-node-controller"},
+			flags:       allFlags,
+			want:        []string{"controller nodedns is enabled without controller cloud-node-lifecycle-controller: the nodes of the deleted instances are not deleted, nor are their DNS records"},
+		},
+		{
+			desc:        "node labels without node controller",
+			controllers: []string{"nodelabels", "cloud-node-lifecycle-controller"},
+			flags:       allFlags,
+			want:        []string{"controller nodelabels is enabled without controller cloud-node-controller: the new nodes are not initialized with their provider ID, the labels of their instance are not updated"},
+		},
+		{
+			desc:        "routes off",
+			controllers: []string{"*"},
+			flags:       controllerFlags{internalLoadBalancers: true},
+			want:        []string{"controller node-route-controller is enabled with --configure-cloud-routes=false, it does not run"},
+		},
+		{
+			desc:        "all load balancers off",
+			controllers: []string{"*", "-node-route-controller"},
+			flags:       controllerFlags{},
+			want:        []string{"controller service-lb-controller is enabled with --cloud-provider-gce-lb-internal=false and --cloud-provider-gce-lb-external=false, it does not ensure any load balancer"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := controllerDependencyWarnings(tc.controllers, disabledByDefault, tc.flags)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("controllerDependencyWarnings(%v) = %q, want %q", tc.controllers, got, tc.want)
+			}
+		})
+	}
+}
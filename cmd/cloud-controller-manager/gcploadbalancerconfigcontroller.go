@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cloudprovider "k8s.io/cloud-provider"
+	lbconfigclientset "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/clientset/versioned"
+	lbconfiginformers "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/informers/externalversions"
+	gcploadbalancerconfigcontroller "k8s.io/cloud-provider-gcp/pkg/controller/gcploadbalancerconfig"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+func startGCPLoadBalancerConfigControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, c cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return startGCPLoadBalancerConfigController(config, controllerCtx, c)
+	}
+}
+
+func startGCPLoadBalancerConfigController(ccmConfig *cloudcontrollerconfig.CompletedConfig, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("GCPLoadBalancerConfigController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+
+	kubeConfig := ccmConfig.Complete().Kubeconfig
+	kubeConfig.ContentType = jsonContentType // required to serialize GCPLoadBalancerConfig to json
+
+	lbConfigClient, err := lbconfigclientset.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, false, err
+	}
+
+	informerFactory := lbconfiginformers.NewSharedInformerFactory(lbConfigClient, 30*time.Second)
+	lbConfigController := gcploadbalancerconfigcontroller.NewGCPLoadBalancerConfigController(
+		informerFactory.Networking().V1().GCPLoadBalancerConfigs(),
+		gceCloud,
+		informerFactory,
+	)
+
+	go lbConfigController.Run(controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
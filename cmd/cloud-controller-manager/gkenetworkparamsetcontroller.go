@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/spf13/pflag"
 	cloudprovider "k8s.io/cloud-provider"
 	networkclientset "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned"
 	networkinformers "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions"
@@ -20,19 +21,37 @@ import (
 
 const jsonContentType = "application/json"
 
-func startGkeNetworkParamSetControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, c cloudprovider.Interface) app.InitFunc {
+// gkeNetworkParamSetController validates the GKENetworkParamSets and the
+// Networks referencing them.
+type gkeNetworkParamSetController struct {
+	// removeStalePodRangeLabels removes the node labels referencing the Pod
+	// ranges of the deleted GKENetworkParamSets.
+	removeStalePodRangeLabels bool
+	// workers is the number of GKENetworkParamSets reconciled concurrently.
+	workers int
+}
+
+func (c *gkeNetworkParamSetController) addFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&c.removeStalePodRangeLabels, "gkenetworkparamset-remove-stale-pod-range-labels", false, "Remove the Pod range labels of the nodes referencing the Pod ranges of the deleted GKENetworkParamSets, instead of only reporting them with events.")
+	fs.IntVar(&c.workers, "gkenetworkparamset-workers", 1, "The number of GKENetworkParamSets the gkenetworkparamset controller reconciles concurrently. Each GKENetworkParamSet is reconciled by one worker at a time.")
+}
+
+func (c *gkeNetworkParamSetController) startGkeNetworkParamSetControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
 	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
-		return startGkeNetworkParamsController(config, controllerCtx, c)
+		return c.startGkeNetworkParamsController(config, controllerCtx, cloud)
 	}
 }
 
-func startGkeNetworkParamsController(ccmConfig *cloudcontrollerconfig.CompletedConfig, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+func (c *gkeNetworkParamSetController) startGkeNetworkParamsController(ccmConfig *cloudcontrollerconfig.CompletedConfig, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
 
 	gceCloud, ok := cloud.(*gce.Cloud)
 	if !ok {
 		err := fmt.Errorf("GkeNetworkParamsController does not support %v provider", cloud.ProviderName())
 		return nil, false, err
 	}
+	if c.workers < 1 {
+		return nil, false, fmt.Errorf("GkeNetworkParamsController requires a positive --gkenetworkparamset-workers, got %d", c.workers)
+	}
 
 	kubeConfig := ccmConfig.Complete().Kubeconfig
 	kubeConfig.ContentType = jsonContentType //required to serialize GKENetworkParamSet to json
@@ -45,7 +64,7 @@ func startGkeNetworkParamsController(ccmConfig *cloudcontrollerconfig.CompletedC
 	// the CloudAllocator needs cluster cidrs for default GNP
 	clusterCIDRs := []*net.IPNet{}
 	if ipam.CIDRAllocatorType(ccmConfig.ComponentConfig.KubeCloudShared.CIDRAllocatorType) == ipam.CloudAllocatorType {
-		clusterCIDRs, err = validClusterCIDR(ccmConfig.ComponentConfig.KubeCloudShared.ClusterCIDR)
+		clusterCIDRs, err = gkenetworkparamsetcontroller.ParseClusterCIDRs(ccmConfig.ComponentConfig.KubeCloudShared.ClusterCIDR)
 		if err != nil {
 			return nil, false, err
 		}
@@ -56,6 +75,7 @@ func startGkeNetworkParamsController(ccmConfig *cloudcontrollerconfig.CompletedC
 	gnpInformer := nwInfFactory.Networking().V1().GKENetworkParamSets()
 
 	gkeNetworkParamsetController := gkenetworkparamsetcontroller.NewGKENetworkParamSetController(
+		controllerCtx.ClientBuilder.ClientOrDie("gkenetworkparamset-controller"),
 		controllerCtx.InformerFactory.Core().V1().Nodes(),
 		networkClient,
 		gnpInformer,
@@ -63,29 +83,9 @@ func startGkeNetworkParamsController(ccmConfig *cloudcontrollerconfig.CompletedC
 		gceCloud,
 		nwInfFactory,
 		clusterCIDRs,
+		c.removeStalePodRangeLabels,
 	)
 
-	go gkeNetworkParamsetController.Run(1, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	go gkeNetworkParamsetController.Run(c.workers, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
 	return nil, true, nil
 }
-
-// validClusterCIDR process CIDR form config and validates the cluster CIDR
-// with stack type and returns a list of typed cidrs and error
-func validClusterCIDR(clusterCIDRFromFlag string) ([]*net.IPNet, error) {
-	// failure: bad cidrs in config
-	clusterCIDRs, dualStack, err := processCIDRs(clusterCIDRFromFlag)
-	if err != nil {
-		return nil, err
-	}
-
-	// failure: more than one cidr but they are not configured as dual stack
-	if len(clusterCIDRs) > 1 && !dualStack {
-		return nil, fmt.Errorf("len of ClusterCIDRs==%v and they are not configured as dual stack (at least one from each IPFamily", len(clusterCIDRs))
-	}
-
-	// failure: more than cidrs is not allowed even with dual stack
-	if len(clusterCIDRs) > 2 {
-		return nil, fmt.Errorf("len of clusters is:%v > more than max allowed of 2", len(clusterCIDRs))
-	}
-	return clusterCIDRs, nil
-}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	cloudprovider "k8s.io/cloud-provider"
+	healthnotificationcontroller "k8s.io/cloud-provider-gcp/pkg/controller/healthnotification"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+// healthNotificationController posts the health transitions of the load
+// balancers and the deletions of the nodes of the deleted instances to a
+// webhook.
+type healthNotificationController struct {
+	webhookURL     string
+	lbHealthPeriod time.Duration
+}
+
+func (c *healthNotificationController) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.webhookURL, "healthnotification-webhook-url", "", "The URL to which the healthnotification controller posts the JSON notifications of the health transitions of the L4 load balancers and of the deletions of the nodes whose instance is gone. Required by the healthnotification controller.")
+	fs.DurationVar(&c.lbHealthPeriod, "healthnotification-lb-health-period", time.Minute, "The period at which the healthnotification controller polls the health of the L4 load balancers.")
+}
+
+func (c *healthNotificationController) startHealthNotificationControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return c.startHealthNotificationController(config, controllerCtx, cloud)
+	}
+}
+
+func (c *healthNotificationController) startHealthNotificationController(ccmConfig *cloudcontrollerconfig.CompletedConfig, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("HealthNotificationController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+	if c.webhookURL == "" {
+		return nil, false, fmt.Errorf("HealthNotificationController requires --healthnotification-webhook-url")
+	}
+	if c.lbHealthPeriod <= 0 {
+		return nil, false, fmt.Errorf("HealthNotificationController requires a positive --healthnotification-lb-health-period, got %v", c.lbHealthPeriod)
+	}
+
+	healthNotificationController := healthnotificationcontroller.NewHealthNotificationController(
+		controllerCtx.InformerFactory.Core().V1().Nodes(),
+		controllerCtx.InformerFactory.Core().V1().Services(),
+		gceCloud,
+		ccmConfig.ComponentConfig.KubeCloudShared.ClusterName,
+		c.webhookURL,
+		c.lbHealthPeriod,
+	)
+
+	go healthNotificationController.Run(1, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	cloudprovider "k8s.io/cloud-provider"
+	loadbalancerforecastcontroller "k8s.io/cloud-provider-gcp/pkg/controller/loadbalancerforecast"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+// loadBalancerForecastController serves the forecast of the GCE resources of
+// the L4 load balancers.
+type loadBalancerForecastController struct {
+	// bindAddress is the address of the forecast endpoint, the endpoint is
+	// not authenticated so it listens on localhost by default.
+	bindAddress string
+}
+
+func (c *loadBalancerForecastController) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.bindAddress, "loadbalancer-forecast-bind-address", "127.0.0.1:10290", "The address on which the loadbalancerforecast controller serves the forecast of the GCE resources of the L4 load balancers, at "+loadbalancerforecastcontroller.Path+".")
+}
+
+func (c *loadBalancerForecastController) startLoadBalancerForecastControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return c.startLoadBalancerForecastController(config, controllerCtx, cloud)
+	}
+}
+
+func (c *loadBalancerForecastController) startLoadBalancerForecastController(ccmConfig *cloudcontrollerconfig.CompletedConfig, controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("LoadBalancerForecastController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+
+	loadBalancerForecastController := loadbalancerforecastcontroller.NewLoadBalancerForecastController(
+		controllerCtx.InformerFactory.Core().V1().Services(),
+		controllerCtx.InformerFactory.Core().V1().Nodes(),
+		gceCloud,
+		ccmConfig.ComponentConfig.KubeCloudShared.ClusterName,
+		c.bindAddress,
+	)
+
+	go loadBalancerForecastController.Run(controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
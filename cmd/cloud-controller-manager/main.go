@@ -20,19 +20,22 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
-	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
-	_ "k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider-gcp/providers/gce"
 	"k8s.io/cloud-provider/app"
 	"k8s.io/cloud-provider/app/config"
 	"k8s.io/cloud-provider/names"
 	"k8s.io/cloud-provider/options"
 	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/cli/globalflag"
 	"k8s.io/component-base/logs"
 	_ "k8s.io/component-base/metrics/prometheus/clientgo" // load all the prometheus client-go plugins
 	_ "k8s.io/component-base/metrics/prometheus/version"  // for version metric registration
@@ -57,36 +60,119 @@ func main() {
 	nodeIpamController.nodeIPAMControllerOptions.NodeIPAMControllerConfiguration = &nodeIpamController.nodeIPAMControllerConfiguration
 	fss := cliflag.NamedFlagSets{}
 	nodeIpamController.nodeIPAMControllerOptions.AddFlags(fss.FlagSet("nodeipam controller"))
+	// The cloud-provider library only exposes the source ranges flags of the
+	// GCE provider.
+	globalflag.Register(fss.FlagSet("GCE load balancer"), "cloud-provider-gce-lb-nodes-health-check-port")
+	globalflag.Register(fss.FlagSet("GCE load balancer"), "cloud-provider-gce-lb-nodes-health-check-path")
+	globalflag.Register(fss.FlagSet("GCE load balancer"), "cloud-provider-gce-lb-quarantine-period")
+	globalflag.Register(fss.FlagSet("GCE load balancer"), "cloud-provider-gce-lb-nodes-stabilization-window")
+	globalflag.Register(fss.FlagSet("GCE load balancer"), gce.InternalLoadBalancersFlag)
+	globalflag.Register(fss.FlagSet("GCE load balancer"), gce.ExternalLoadBalancersFlag)
+	globalflag.Register(fss.FlagSet("GCE"), "cloud-provider-gce-provider-id-prefixes")
+	gkeNetworkParamSet := gkeNetworkParamSetController{}
+	gkeNetworkParamSet.addFlags(fss.FlagSet("gkenetworkparamset controller"))
+	nodeDNS := nodeDNSController{}
+	nodeDNS.addFlags(fss.FlagSet("nodedns controller"))
+	nodeLabels := nodeLabelsController{}
+	nodeLabels.addFlags(fss.FlagSet("nodelabels controller"))
+	healthNotification := healthNotificationController{}
+	healthNotification.addFlags(fss.FlagSet("healthnotification controller"))
+	loadBalancerForecast := loadBalancerForecastController{}
+	loadBalancerForecast.addFlags(fss.FlagSet("loadbalancerforecast controller"))
 	controllerInitializers[kcmnames.NodeIpamController] = app.ControllerInitFuncConstructor{
 		Constructor: nodeIpamController.startNodeIpamControllerWrapper,
 	}
 
 	controllerInitializers["gkenetworkparamset"] = app.ControllerInitFuncConstructor{
-		Constructor: startGkeNetworkParamSetControllerWrapper,
+		Constructor: gkeNetworkParamSet.startGkeNetworkParamSetControllerWrapper,
+	}
+
+	controllerInitializers["gcploadbalancerconfig"] = app.ControllerInitFuncConstructor{
+		Constructor: startGCPLoadBalancerConfigControllerWrapper,
+	}
+
+	controllerInitializers["nodeproviderid"] = app.ControllerInitFuncConstructor{
+		Constructor: startNodeProviderIDControllerWrapper,
+	}
+
+	controllerInitializers["firewallconsolidation"] = app.ControllerInitFuncConstructor{
+		Constructor: startFirewallConsolidationControllerWrapper,
+	}
+
+	controllerInitializers["noderegion"] = app.ControllerInitFuncConstructor{
+		Constructor: startNodeRegionControllerWrapper,
+	}
+
+	controllerInitializers["nodegroup"] = app.ControllerInitFuncConstructor{
+		Constructor: startNodeGroupControllerWrapper,
+	}
+
+	controllerInitializers["loadbalancerforecast"] = app.ControllerInitFuncConstructor{
+		Constructor: loadBalancerForecast.startLoadBalancerForecastControllerWrapper,
+	}
+
+	controllerInitializers["nodedns"] = app.ControllerInitFuncConstructor{
+		Constructor: nodeDNS.startNodeDNSControllerWrapper,
+	}
+
+	controllerInitializers["nodelabels"] = app.ControllerInitFuncConstructor{
+		Constructor: nodeLabels.startNodeLabelsControllerWrapper,
+	}
+
+	controllerInitializers["healthnotification"] = app.ControllerInitFuncConstructor{
+		Constructor: healthNotification.startHealthNotificationControllerWrapper,
 	}
 
 	// add controllers disabled by default
 	app.ControllersDisabledByDefault.Insert("gkenetworkparamset")
+	app.ControllersDisabledByDefault.Insert("gcploadbalancerconfig")
+	app.ControllersDisabledByDefault.Insert("nodeproviderid")
+	app.ControllersDisabledByDefault.Insert("firewallconsolidation")
+	app.ControllersDisabledByDefault.Insert("noderegion")
+	app.ControllersDisabledByDefault.Insert("nodegroup")
+	app.ControllersDisabledByDefault.Insert("loadbalancerforecast")
+	app.ControllersDisabledByDefault.Insert("nodedns")
+	app.ControllersDisabledByDefault.Insert("nodelabels")
+	app.ControllersDisabledByDefault.Insert("healthnotification")
 	aliasMap := names.CCMControllerAliases()
 	aliasMap["nodeipam"] = kcmnames.NodeIpamController
-	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, controllerInitializers, aliasMap, fss, wait.NeverStop)
+	// Stop the controllers on SIGTERM, so that the load balancer deletions in
+	// progress are checkpointed before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, controllerInitializers, aliasMap, fss, ctx.Done())
 
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
-	if err := command.Execute(); err != nil {
+	err = command.Execute()
+	stop()
+	checkpointLoadBalancerCleanups()
+	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// initializedCloud is the cloud provider created by cloudInitializer.
+var initializedCloud cloudprovider.Interface
+
+// checkpointLoadBalancerCleanups persists the load balancer deletions which
+// were interrupted by the shutdown, they are resumed at the next startup.
+func checkpointLoadBalancerCleanups() {
+	gceCloud, ok := initializedCloud.(*gce.Cloud)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := gceCloud.CheckpointLoadBalancerCleanups(ctx); err != nil {
+		klog.Errorf("Failed to checkpoint the pending load balancer cleanups: %v", err)
+	}
+}
+
 func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 	cloudConfig := config.ComponentConfig.KubeCloudShared.CloudProvider
 
-	// initialize cloud provider with the cloud provider name and config file provided
-	cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
-	if err != nil {
-		klog.Fatalf("Cloud provider with name: %v and configFile: %v could not be initialized: %v", cloudConfig.Name, cloudConfig.CloudConfigFile, err)
-	}
+	cloud := initCloudProvider(config)
 	if cloud == nil {
 		klog.Fatalf("Cloud provider with name: %v and configFile: %v is nil", cloudConfig.Name, cloudConfig.CloudConfigFile)
 	}
@@ -98,5 +184,44 @@ func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 			klog.Fatalf("no ClusterID found.  A ClusterID is required for the cloud provider to function properly.  This check can be bypassed by setting the allow-untagged-cloud option")
 		}
 	}
+	flags := controllerFlags{
+		configureCloudRoutes:  config.ComponentConfig.KubeCloudShared.ConfigureCloudRoutes,
+		internalLoadBalancers: gce.InternalLoadBalancersEnabled(),
+		externalLoadBalancers: gce.ExternalLoadBalancersEnabled(),
+	}
+	for _, warning := range controllerDependencyWarnings(config.ComponentConfig.Generic.Controllers, app.ControllersDisabledByDefault, flags) {
+		klog.Warning(warning)
+	}
+	initializedCloud = cloud
+	return cloud
+}
+
+// initCloudProvider initializes the cloud provider from the --cloud-config file
+// or, for GCE, from the ConfigMap or Secret it references. A referenced object
+// is watched so that rotated credentials are picked up without a restart.
+func initCloudProvider(config *config.CompletedConfig) cloudprovider.Interface {
+	cloudConfig := config.ComponentConfig.KubeCloudShared.CloudProvider
+
+	ref, isRef, err := gce.ParseConfigReference(cloudConfig.CloudConfigFile)
+	if err != nil {
+		klog.Fatalf("Invalid --cloud-config: %v", err)
+	}
+	if !isRef {
+		// initialize cloud provider with the cloud provider name and config file provided
+		cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
+		if err != nil {
+			klog.Fatalf("Cloud provider with name: %v and configFile: %v could not be initialized: %v", cloudConfig.Name, cloudConfig.CloudConfigFile, err)
+		}
+		return cloud
+	}
+
+	if cloudConfig.Name != gce.ProviderName {
+		klog.Fatalf("Cloud provider with name: %v does not support cloud config reference %v", cloudConfig.Name, ref)
+	}
+	client := config.ClientBuilder.ClientOrDie("cloud-config-watcher")
+	cloud, err := gce.NewCloudFromConfigReference(context.Background(), client, ref)
+	if err != nil {
+		klog.Fatalf("Cloud provider with name: %v and config %v could not be initialized: %v", cloudConfig.Name, ref, err)
+	}
 	return cloud
 }
@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	cloudprovider "k8s.io/cloud-provider"
+	nodednscontroller "k8s.io/cloud-provider-gcp/pkg/controller/nodedns"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+// nodeDNSController deletes the Cloud DNS records of the deleted nodes.
+type nodeDNSController struct {
+	// managedZone is the private managed zone of the records of the nodes.
+	managedZone string
+	// project is the project of the managed zone, the project of the
+	// cluster if empty.
+	project string
+}
+
+func (c *nodeDNSController) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.managedZone, "nodedns-managed-zone", "", "The Cloud DNS managed zone whose A and AAAA records named after the nodes are deleted by the nodedns controller once the nodes are deleted. Required by the nodedns controller.")
+	fs.StringVar(&c.project, "nodedns-project", "", "The project of the --nodedns-managed-zone, the project of the cluster if empty.")
+}
+
+func (c *nodeDNSController) startNodeDNSControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return c.startNodeDNSController(controllerCtx, cloud)
+	}
+}
+
+func (c *nodeDNSController) startNodeDNSController(controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("NodeDNSController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+	if c.managedZone == "" {
+		return nil, false, fmt.Errorf("NodeDNSController requires --nodedns-managed-zone")
+	}
+	project := c.project
+	if project == "" {
+		project = gceCloud.ProjectID()
+	}
+
+	nodeDNSController := nodednscontroller.NewNodeDNSController(
+		controllerCtx.InformerFactory.Core().V1().Nodes(),
+		gceCloud,
+		project,
+		c.managedZone,
+	)
+
+	go nodeDNSController.Run(1, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
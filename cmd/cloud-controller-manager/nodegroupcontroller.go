@@ -0,0 +1,53 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	cloudprovider "k8s.io/cloud-provider"
+	nodegroupcontroller "k8s.io/cloud-provider-gcp/pkg/controller/nodegroup"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+func startNodeGroupControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, c cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return startNodeGroupController(controllerCtx, c)
+	}
+}
+
+func startNodeGroupController(controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("NodeGroupController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+
+	nodeGroupController := nodegroupcontroller.NewNodeGroupController(
+		controllerCtx.ClientBuilder.ClientOrDie("node-group-controller"),
+		controllerCtx.InformerFactory.Core().V1().Nodes(),
+		gceCloud,
+	)
+
+	go nodeGroupController.Run(1, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
@@ -71,6 +71,7 @@ func startNodeIpamController(ccmConfig *cloudcontrollerconfig.CompletedConfig, n
 	var serviceCIDR *net.IPNet
 	var secondaryServiceCIDR *net.IPNet
 	var clusterCIDRs []*net.IPNet
+	var zoneClusterCIDRs map[string][]*net.IPNet
 	var nodeCIDRMaskSizes []int
 
 	// should we start nodeIPAM
@@ -95,6 +96,17 @@ func startNodeIpamController(ccmConfig *cloudcontrollerconfig.CompletedConfig, n
 		return nil, false, fmt.Errorf("len of clusters cidrs is:%v > more than max allowed of 2", len(clusterCIDRs))
 	}
 
+	// zone cluster cidrs processing, only the range allocator allocates from them
+	if len(strings.TrimSpace(nodeIPAMConfig.ZoneClusterCIDRs)) != 0 {
+		if ipam.CIDRAllocatorType(ccmConfig.ComponentConfig.KubeCloudShared.CIDRAllocatorType) != ipam.RangeAllocatorType {
+			return nil, false, fmt.Errorf("zone cluster CIDRs require the %v CIDR allocator type, got %v", ipam.RangeAllocatorType, ccmConfig.ComponentConfig.KubeCloudShared.CIDRAllocatorType)
+		}
+		zoneClusterCIDRs, err = processZoneCIDRs(nodeIPAMConfig.ZoneClusterCIDRs, clusterCIDRs)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
 	// service cidr processing
 	if len(strings.TrimSpace(nodeIPAMConfig.ServiceCIDR)) != 0 {
 		_, serviceCIDR, err = net.ParseCIDR(nodeIPAMConfig.ServiceCIDR)
@@ -144,6 +156,7 @@ func startNodeIpamController(ccmConfig *cloudcontrollerconfig.CompletedConfig, n
 		nwInformer,
 		gnpInformer,
 		clusterCIDRs,
+		zoneClusterCIDRs,
 		serviceCIDR,
 		secondaryServiceCIDR,
 		nodeCIDRMaskSizes,
@@ -176,6 +189,49 @@ func processCIDRs(cidrsList string) ([]*net.IPNet, bool, error) {
 	return cidrs, dualstack, nil
 }
 
+// processZoneCIDRs is a helper function that works on a comma separated <zone>=<cidr> list and returns
+// the cidrs of each zone, ordered like the clusterCIDRs of their IP family
+// error if failed to parse any of the entries or if a zone does not have exactly one cidr of each IP family of clusterCIDRs
+func processZoneCIDRs(zoneCIDRsList string, clusterCIDRs []*net.IPNet) (map[string][]*net.IPNet, error) {
+	zoneCIDRs := make(map[string][]*net.IPNet)
+	for _, entry := range strings.Split(strings.TrimSpace(zoneCIDRsList), ",") {
+		zone, cidr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || zone == "" {
+			return nil, fmt.Errorf("zone cluster CIDR %q is not of the form <zone>=<cidr>", entry)
+		}
+		_, zoneCIDR, err := netutils.ParseCIDRSloppy(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("unsuccessful parsing of cluster CIDR %q of zone %s: %v", cidr, zone, err)
+		}
+
+		familyIdx := -1
+		for idx, clusterCIDR := range clusterCIDRs {
+			if netutils.IsIPv6CIDR(clusterCIDR) == netutils.IsIPv6CIDR(zoneCIDR) {
+				familyIdx = idx
+			}
+		}
+		if familyIdx < 0 {
+			return nil, fmt.Errorf("cluster CIDR %v of zone %s is not of the IP family of any of the cluster CIDRs", zoneCIDR, zone)
+		}
+		if zoneCIDRs[zone] == nil {
+			zoneCIDRs[zone] = make([]*net.IPNet, len(clusterCIDRs))
+		}
+		if zoneCIDRs[zone][familyIdx] != nil {
+			return nil, fmt.Errorf("zone %s has more than one cluster CIDR of the IP family of %v", zone, zoneCIDR)
+		}
+		zoneCIDRs[zone][familyIdx] = zoneCIDR
+	}
+
+	for zone, cidrs := range zoneCIDRs {
+		for idx, cidr := range cidrs {
+			if cidr == nil {
+				return nil, fmt.Errorf("zone %s has no cluster CIDR of the IP family of %v", zone, clusterCIDRs[idx])
+			}
+		}
+	}
+	return zoneCIDRs, nil
+}
+
 // setNodeCIDRMaskSizes returns the IPv4 and IPv6 node cidr mask sizes to the value provided
 // for --node-cidr-mask-size-ipv4 and --node-cidr-mask-size-ipv6 respectively. If value not provided,
 // then it will return default IPv4 and IPv6 cidr mask sizes.
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"net"
+	"reflect"
 	"testing"
 
 	cloudprovider "k8s.io/cloud-provider"
 	nodeipamconfig "k8s.io/cloud-provider-gcp/pkg/controller/nodeipam/config"
+	"k8s.io/cloud-provider-gcp/pkg/controller/nodeipam/ipam"
 	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
 	"k8s.io/cloud-provider/config"
 	genericcontrollermanager "k8s.io/controller-manager/app"
@@ -195,6 +198,54 @@ func TestStartNodeIpamController(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			desc: "Zone cluster CIDRs with the cloud allocator",
+			ccmConfig: &cloudcontrollerconfig.Config{
+				ComponentConfig: config.CloudControllerManagerConfiguration{
+					KubeCloudShared: config.KubeCloudSharedConfiguration{
+						AllocateNodeCIDRs: true,
+						ClusterCIDR:       "10.0.0.0/16",
+						CIDRAllocatorType: string(ipam.CloudAllocatorType),
+					},
+				},
+			},
+			nodeIPAMConfig: nodeipamconfig.NodeIPAMControllerConfiguration{
+				ZoneClusterCIDRs: "us-central1-a=10.1.0.0/16",
+			},
+			wantErr: true,
+		},
+		{
+			desc: "Unparseable zone cluster CIDRs",
+			ccmConfig: &cloudcontrollerconfig.Config{
+				ComponentConfig: config.CloudControllerManagerConfiguration{
+					KubeCloudShared: config.KubeCloudSharedConfiguration{
+						AllocateNodeCIDRs: true,
+						ClusterCIDR:       "10.0.0.0/16",
+						CIDRAllocatorType: string(ipam.RangeAllocatorType),
+					},
+				},
+			},
+			nodeIPAMConfig: nodeipamconfig.NodeIPAMControllerConfiguration{
+				ZoneClusterCIDRs: "us-central1-a=invalid",
+			},
+			wantErr: true,
+		},
+		{
+			desc: "Zone cluster CIDRs missing an IP family of a dual stack cluster",
+			ccmConfig: &cloudcontrollerconfig.Config{
+				ComponentConfig: config.CloudControllerManagerConfiguration{
+					KubeCloudShared: config.KubeCloudSharedConfiguration{
+						AllocateNodeCIDRs: true,
+						ClusterCIDR:       "10.0.0.0/16,2001:aa::/112",
+						CIDRAllocatorType: string(ipam.RangeAllocatorType),
+					},
+				},
+			},
+			nodeIPAMConfig: nodeipamconfig.NodeIPAMControllerConfiguration{
+				ZoneClusterCIDRs: "us-central1-a=10.1.0.0/16",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -211,3 +262,76 @@ func TestStartNodeIpamController(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessZoneCIDRs(t *testing.T) {
+	mustParseCIDRs := func(cidrs ...string) []*net.IPNet {
+		var ipNets []*net.IPNet
+		for _, cidr := range cidrs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatalf("net.ParseCIDR(%q): %v", cidr, err)
+			}
+			ipNets = append(ipNets, ipNet)
+		}
+		return ipNets
+	}
+
+	testCases := []struct {
+		desc          string
+		zoneCIDRsList string
+		clusterCIDRs  []*net.IPNet
+		want          map[string][]*net.IPNet
+		wantErr       bool
+	}{
+		{
+			desc:          "Single stack zones",
+			zoneCIDRsList: "us-central1-a=10.1.0.0/16, us-central1-b=10.2.0.0/16",
+			clusterCIDRs:  mustParseCIDRs("10.0.0.0/16"),
+			want: map[string][]*net.IPNet{
+				"us-central1-a": mustParseCIDRs("10.1.0.0/16"),
+				"us-central1-b": mustParseCIDRs("10.2.0.0/16"),
+			},
+		},
+		{
+			desc:          "Dual stack zone ordered like the cluster CIDRs",
+			zoneCIDRsList: "us-central1-a=10.1.0.0/16,us-central1-a=2001:ab::/112",
+			clusterCIDRs:  mustParseCIDRs("2001:aa::/112", "10.0.0.0/16"),
+			want: map[string][]*net.IPNet{
+				"us-central1-a": mustParseCIDRs("2001:ab::/112", "10.1.0.0/16"),
+			},
+		},
+		{
+			desc:          "Missing zone",
+			zoneCIDRsList: "=10.1.0.0/16",
+			clusterCIDRs:  mustParseCIDRs("10.0.0.0/16"),
+			wantErr:       true,
+		},
+		{
+			desc:          "IP family not in the cluster CIDRs",
+			zoneCIDRsList: "us-central1-a=2001:ab::/112",
+			clusterCIDRs:  mustParseCIDRs("10.0.0.0/16"),
+			wantErr:       true,
+		},
+		{
+			desc:          "More than one CIDR of an IP family",
+			zoneCIDRsList: "us-central1-a=10.1.0.0/16,us-central1-a=10.2.0.0/16",
+			clusterCIDRs:  mustParseCIDRs("10.0.0.0/16"),
+			wantErr:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := processZoneCIDRs(tc.zoneCIDRsList, tc.clusterCIDRs)
+			if err == nil && tc.wantErr {
+				t.Fatalf("processZoneCIDRs() succeeded, want error")
+			}
+			if err != nil && !tc.wantErr {
+				t.Fatalf("processZoneCIDRs(): %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("processZoneCIDRs() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	cloudprovider "k8s.io/cloud-provider"
+	nodelabelscontroller "k8s.io/cloud-provider-gcp/pkg/controller/nodelabels"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/cloud-provider/app"
+	cloudcontrollerconfig "k8s.io/cloud-provider/app/config"
+	genericcontrollermanager "k8s.io/controller-manager/app"
+	"k8s.io/controller-manager/controller"
+)
+
+// nodeLabelsController propagates the allowed labels of the nodes to the GCE
+// labels of their instance.
+type nodeLabelsController struct {
+	// allowedLabels are the keys of the node labels propagated.
+	allowedLabels []string
+}
+
+func (c *nodeLabelsController) addFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&c.allowedLabels, "nodelabels-allowed-labels", nil, "The keys of the node labels propagated to the GCE labels of the instances of the nodes by the nodelabels controller, e.g. to group the instances by team in the billing reports. The keys and values are converted to the format of the GCE labels: lowercase, with the characters other than the letters, the digits, the underscores and the dashes replaced by underscores, and truncated to 63 characters. The GCE labels of these keys are removed from the instances of the nodes without the label. Required by the nodelabels controller.")
+}
+
+func (c *nodeLabelsController) startNodeLabelsControllerWrapper(initCtx app.ControllerInitContext, config *cloudcontrollerconfig.CompletedConfig, cloud cloudprovider.Interface) app.InitFunc {
+	return func(ctx context.Context, controllerCtx genericcontrollermanager.ControllerContext) (controller.Interface, bool, error) {
+		return c.startNodeLabelsController(controllerCtx, cloud)
+	}
+}
+
+func (c *nodeLabelsController) startNodeLabelsController(controllerCtx genericcontrollermanager.ControllerContext, cloud cloudprovider.Interface) (controller.Interface, bool, error) {
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		err := fmt.Errorf("NodeLabelsController does not support %v provider", cloud.ProviderName())
+		return nil, false, err
+	}
+	if len(c.allowedLabels) == 0 {
+		return nil, false, fmt.Errorf("NodeLabelsController requires --nodelabels-allowed-labels")
+	}
+	if err := nodelabelscontroller.ValidateAllowedLabels(c.allowedLabels); err != nil {
+		return nil, false, fmt.Errorf("invalid --nodelabels-allowed-labels: %v", err)
+	}
+
+	nodeLabelsController := nodelabelscontroller.NewNodeLabelsController(
+		controllerCtx.InformerFactory.Core().V1().Nodes(),
+		gceCloud,
+		c.allowedLabels,
+	)
+
+	go nodeLabelsController.Run(1, controllerCtx.Stop, controllerCtx.ControllerManagerMetrics)
+	return nil, true, nil
+}
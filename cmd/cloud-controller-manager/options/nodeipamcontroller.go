@@ -36,6 +36,7 @@ func (o *NodeIPAMControllerOptions) AddFlags(fs *pflag.FlagSet) {
 		return
 	}
 	fs.StringVar(&o.ServiceCIDR, "service-cluster-ip-range", o.ServiceCIDR, "CIDR Range for Services in cluster. Requires --allocate-node-cidrs to be true")
+	fs.StringVar(&o.ZoneClusterCIDRs, "zone-cluster-cidrs", o.ZoneClusterCIDRs, "Comma separated list of <zone>=<cidr> CIDR Ranges for Pods of the nodes of each zone of a stretched cluster, with a CIDR of each IP family of --cluster-cidr for a zone. The nodes of the other zones get their CIDRs from --cluster-cidr. Requires --allocate-node-cidrs to be true and --cidr-allocator-type to be RangeAllocator")
 	fs.Int32Var(&o.NodeCIDRMaskSize, "node-cidr-mask-size", o.NodeCIDRMaskSize, "Mask size for node cidr in cluster. Default is 24 for IPv4 and 64 for IPv6.")
 	fs.Int32Var(&o.NodeCIDRMaskSizeIPv4, "node-cidr-mask-size-ipv4", o.NodeCIDRMaskSizeIPv4, "Mask size for IPv4 node cidr in dual-stack cluster. Default is 24.")
 	fs.Int32Var(&o.NodeCIDRMaskSizeIPv6, "node-cidr-mask-size-ipv6", o.NodeCIDRMaskSizeIPv6, "Mask size for IPv6 node cidr in dual-stack cluster. Default is 64.")
@@ -56,6 +57,7 @@ func (o *NodeIPAMControllerOptions) ApplyTo(cfg *nodeipamconfig.NodeIPAMControll
 		cfg.SecondaryServiceCIDR = serviceCIDRList[1]
 	}
 
+	cfg.ZoneClusterCIDRs = o.ZoneClusterCIDRs
 	cfg.NodeCIDRMaskSize = o.NodeCIDRMaskSize
 	cfg.NodeCIDRMaskSizeIPv4 = o.NodeCIDRMaskSizeIPv4
 	cfg.NodeCIDRMaskSizeIPv6 = o.NodeCIDRMaskSizeIPv6
@@ -75,5 +77,13 @@ func (o *NodeIPAMControllerOptions) Validate() []error {
 		errs = append(errs, fmt.Errorf("--service-cluster-ip-range can not contain more than two entries"))
 	}
 
+	if len(strings.TrimSpace(o.ZoneClusterCIDRs)) != 0 {
+		for _, zoneCIDR := range strings.Split(o.ZoneClusterCIDRs, ",") {
+			if zone, cidr, ok := strings.Cut(strings.TrimSpace(zoneCIDR), "="); !ok || zone == "" || cidr == "" {
+				errs = append(errs, fmt.Errorf("--zone-cluster-cidrs entry %q must be of the form <zone>=<cidr>", zoneCIDR))
+			}
+		}
+	}
+
 	return errs
 }
@@ -38,6 +38,9 @@ const (
 type caCache struct {
 	rootCertURL string
 	interPrefix string
+	// client fetches the certificates and the CRLs, http.DefaultClient if
+	// nil.
+	client *http.Client
 
 	mu    sync.RWMutex
 	certs map[string]*x509.Certificate
@@ -114,7 +117,7 @@ func (c *caCache) getCert(url string) (*x509.Certificate, error) {
 	}
 
 	// Fetch and cache the cert.
-	crt, err := fetchCert(url)
+	crt, err := fetchCert(c.httpClient(), url)
 	if err != nil {
 		return nil, err
 	}
@@ -162,7 +165,7 @@ func (c *caCache) getCRL(url string, cert *x509.Certificate) (*pkix.CertificateL
 		return crl.crl, nil
 	}
 
-	crlRaw, err := fetchCRL(url)
+	crlRaw, err := fetchCRL(c.httpClient(), url)
 	if err != nil {
 		return nil, fmt.Errorf("fetching %q: %v", url, err)
 	}
@@ -173,8 +176,15 @@ func (c *caCache) getCRL(url string, cert *x509.Certificate) (*pkix.CertificateL
 	return crlRaw, nil
 }
 
-func fetchCert(url string) (*x509.Certificate, error) {
-	resp, err := http.Get(url)
+func (c *caCache) httpClient() *http.Client {
+	if c.client == nil {
+		return http.DefaultClient
+	}
+	return c.client
+}
+
+func fetchCert(client *http.Client, url string) (*x509.Certificate, error) {
+	resp, err := client.Get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -186,8 +196,8 @@ func fetchCert(url string) (*x509.Certificate, error) {
 	return x509.ParseCertificate(raw)
 }
 
-func fetchCRL(url string) (*pkix.CertificateList, error) {
-	resp, err := http.Get(url)
+func fetchCRL(client *http.Client, url string) (*pkix.CertificateList, error) {
+	resp, err := client.Get(url)
 	if err != nil {
 		return nil, err
 	}
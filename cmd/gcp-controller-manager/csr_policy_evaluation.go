@@ -0,0 +1,439 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	betacompute "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/option"
+	authorization "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	testclient "k8s.io/client-go/testing"
+	certutil "k8s.io/kubernetes/pkg/apis/certificates/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// csrDecision is the decision of the node CSR approver evaluated offline.
+type csrDecision string
+
+const (
+	csrDecisionApproved csrDecision = "Approved"
+	csrDecisionDenied   csrDecision = "Denied"
+	// csrDecisionIgnored is the decision for the CSRs no validator matched,
+	// or rejected by the SubjectAccessReview, which are left pending.
+	csrDecisionIgnored csrDecision = "Ignored"
+	// csrDecisionError is the decision for the CSRs retried by the approver.
+	csrDecisionError csrDecision = "Error"
+)
+
+// csrPolicy is the policy of the node CSR approver evaluated by
+// --csr-policy-evaluation-fixtures: the cluster of gce.conf and the csr-*
+// flags of the approver.
+type csrPolicy struct {
+	ProjectID   string   `json:"projectID"`
+	ClusterName string   `json:"clusterName"`
+	Location    string   `json:"location"`
+	Zones       []string `json:"zones"`
+
+	// AllowLegacyKubelet and VerifyClusterMembership default to true, as
+	// their flags.
+	AllowLegacyKubelet       *bool  `json:"allowLegacyKubelet,omitempty"`
+	VerifyClusterMembership  *bool  `json:"verifyClusterMembership,omitempty"`
+	InstanceIdentityAudience string `json:"instanceIdentityAudience,omitempty"`
+	RequireInstanceIdentity  bool   `json:"requireInstanceIdentity,omitempty"`
+	// GoogleCertsFile is the JSON Web Key Set of the Google signing keys of
+	// the instance identity tokens, which are not fetched offline.
+	GoogleCertsFile string `json:"googleCertsFile,omitempty"`
+	// TPMEndorsementCAsFile maps the URLs of the TPM endorsement CAs of the
+	// attestations and of their CRLs to their PEM, which are not fetched
+	// offline either.
+	TPMEndorsementCAsFile string `json:"tpmEndorsementCAsFile,omitempty"`
+}
+
+// csrFixture is a CSR evaluated offline, with the facts of the cluster and of
+// GCE the approver looks up.
+type csrFixture struct {
+	CSR *capi.CertificateSigningRequest `json:"csr"`
+	// SubjectAccessReviewAllowed is the result of the SubjectAccessReviews,
+	// true by default.
+	SubjectAccessReviewAllowed *bool `json:"subjectAccessReviewAllowed,omitempty"`
+	// Instances are the GCE instances of the cluster, returned by name in
+	// their zone, or in any zone of the policy if unset.
+	Instances []*compute.Instance `json:"instances,omitempty"`
+	Nodes     []*v1.Node          `json:"nodes,omitempty"`
+	// APIResponses are the responses of the other GCE and GKE API calls, by
+	// URL path, e.g. the shielded instance identities of the TPM attestations
+	// or the clusters of the cluster membership checks.
+	APIResponses map[string]json.RawMessage `json:"apiResponses,omitempty"`
+	// ExpectedDecision fails the evaluation if set and not matching.
+	ExpectedDecision csrDecision `json:"expectedDecision,omitempty"`
+}
+
+// csrPolicyResult is the decision of the approver for a fixture.
+type csrPolicyResult struct {
+	Fixture   string      `json:"fixture"`
+	CSR       string      `json:"csr"`
+	Decision  csrDecision `json:"decision"`
+	Validator string      `json:"validator,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	Expected  csrDecision `json:"expected,omitempty"`
+}
+
+// unexpected returns true if the decision does not match the expected one.
+func (r csrPolicyResult) unexpected() bool {
+	return r.Expected != "" && r.Expected != r.Decision
+}
+
+func loadCSRPolicy(path string) (*csrPolicy, error) {
+	policy := &csrPolicy{}
+	if path == "" {
+		return policy, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %v", path, err)
+	}
+	if policy.RequireInstanceIdentity && policy.InstanceIdentityAudience == "" {
+		return nil, fmt.Errorf("invalid policy %s: requireInstanceIdentity requires instanceIdentityAudience", path)
+	}
+	return policy, nil
+}
+
+// loadTPMEndorsementCAs returns the DER of the certificates and CRLs of the
+// file by URL, see csrPolicy.TPMEndorsementCAsFile.
+func loadTPMEndorsementCAs(path string) (map[string][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pems := map[string]string{}
+	if err := yaml.UnmarshalStrict(data, &pems); err != nil {
+		return nil, fmt.Errorf("invalid TPM endorsement CAs %s: %v", path, err)
+	}
+	cas := map[string][]byte{}
+	for url, p := range pems {
+		block, _ := pem.Decode([]byte(p))
+		if block == nil {
+			return nil, fmt.Errorf("invalid TPM endorsement CAs %s: no PEM for %s", path, url)
+		}
+		cas[url] = block.Bytes
+	}
+	return cas, nil
+}
+
+func loadCSRFixture(path string) (*csrFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &csrFixture{}
+	if err := yaml.UnmarshalStrict(data, fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", path, err)
+	}
+	if fixture.CSR == nil {
+		return nil, fmt.Errorf("invalid fixture %s: no csr", path)
+	}
+	switch fixture.ExpectedDecision {
+	case "", csrDecisionApproved, csrDecisionDenied, csrDecisionIgnored, csrDecisionError:
+	default:
+		return nil, fmt.Errorf("invalid fixture %s: unknown expectedDecision %q", path, fixture.ExpectedDecision)
+	}
+	return fixture, nil
+}
+
+// evaluateCSRPolicy returns the decisions of the node CSR approver with the
+// policy for the fixtures of the directory, the YAML or JSON files sorted by
+// name. Nothing is called outside of the process.
+func evaluateCSRPolicy(policy *csrPolicy, dir string) ([]csrPolicyResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var googleCerts json.RawMessage
+	if policy.GoogleCertsFile != "" {
+		if googleCerts, err = os.ReadFile(policy.GoogleCertsFile); err != nil {
+			return nil, err
+		}
+	}
+	var tpmCAs map[string][]byte
+	if policy.TPMEndorsementCAsFile != "" {
+		if tpmCAs, err = loadTPMEndorsementCAs(policy.TPMEndorsementCAsFile); err != nil {
+			return nil, err
+		}
+	}
+	var results []csrPolicyResult
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+		fixture, err := loadCSRFixture(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		result, err := evaluateCSRFixture(policy, fixture, googleCerts, tpmCAs)
+		if err != nil {
+			return nil, fmt.Errorf("evaluating fixture %s: %v", e.Name(), err)
+		}
+		result.Fixture = e.Name()
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func evaluateCSRFixture(policy *csrPolicy, fixture *csrFixture, googleCerts json.RawMessage, tpmCAs map[string][]byte) (csrPolicyResult, error) {
+	csr := fixture.CSR.DeepCopy()
+	result := csrPolicyResult{CSR: csr.Name, Expected: fixture.ExpectedDecision}
+
+	objects := []runtime.Object{csr.DeepCopy()}
+	for _, n := range fixture.Nodes {
+		objects = append(objects, n)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	allowed := fixture.SubjectAccessReviewAllowed == nil || *fixture.SubjectAccessReviewAllowed
+	var sarRejected bool
+	client.PrependReactor("create", "subjectaccessreviews", func(action testclient.Action) (bool, runtime.Object, error) {
+		sar := action.(testclient.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+		sar.Status.Allowed = allowed
+		sarRejected = !allowed
+		return true, sar, nil
+	})
+
+	api := &fixtureTransport{instances: fixture.Instances, responses: map[string]json.RawMessage{}, urls: tpmCAs}
+	for p, resp := range fixture.APIResponses {
+		api.responses[p] = resp
+	}
+	if googleCerts != nil {
+		api.responses["/oauth2/v3/certs"] = googleCerts
+	}
+	httpClient := &http.Client{Transport: api}
+	ctx, err := newCSRPolicyContext(policy, client, httpClient)
+	if err != nil {
+		return result, err
+	}
+
+	approver := newNodeApprover(ctx)
+	if x509cr, err := certutil.ParseCSR(csr.Spec.Request); err == nil {
+		for _, r := range approver.validators {
+			if r.recognize(csr, x509cr) {
+				result.Validator = r.name
+				break
+			}
+		}
+	}
+	if err := approver.handle(context.TODO(), csr); err != nil {
+		// The CSRs rejected by the SubjectAccessReview are retried until
+		// their RBAC is fixed, but not decided.
+		result.Decision, result.Reason = csrDecisionError, err.Error()
+		if sarRejected {
+			result.Decision = csrDecisionIgnored
+		}
+		return result, nil
+	}
+
+	updated, err := client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), csr.Name, metav1.GetOptions{})
+	if err != nil {
+		return result, err
+	}
+	for _, c := range updated.Status.Conditions {
+		switch c.Type {
+		case capi.CertificateApproved:
+			result.Decision, result.Reason = csrDecisionApproved, c.Message
+		case capi.CertificateDenied:
+			result.Decision, result.Reason = csrDecisionDenied, c.Message
+			if c.Message == "" {
+				result.Reason = fmt.Sprintf("denied by validator %q", result.Validator)
+			}
+		}
+	}
+	if result.Decision == "" {
+		result.Decision = csrDecisionIgnored
+		if result.Validator == "" {
+			result.Reason = "no validator matched the CSR"
+		}
+	}
+	return result, nil
+}
+
+// newCSRPolicyContext returns the context of the node CSR approver with the
+// policy, calling the fake clientset and the fixture GCE and GKE APIs.
+func newCSRPolicyContext(policy *csrPolicy, client *fake.Clientset, httpClient *http.Client) (*controllerContext, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(httpClient)}
+	computeService, err := compute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	betaComputeService, err := betacompute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	containerService, err := container.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &controllerContext{
+		client: client,
+		gcpCfg: gcpConfig{
+			ClusterName: policy.ClusterName,
+			ProjectID:   policy.ProjectID,
+			Location:    policy.Location,
+			Zones:       policy.Zones,
+			TPMEndorsementCACache: &caCache{
+				rootCertURL: rootCertURL,
+				interPrefix: intermediateCAPrefix,
+				client:      httpClient,
+				certs:       make(map[string]*x509.Certificate),
+				crls:        make(map[string]*cachedCRL),
+			},
+			Compute:     computeService,
+			BetaCompute: betaComputeService,
+			Container:   containerService,
+		},
+		csrApproverVerifyClusterMembership: policy.VerifyClusterMembership == nil || *policy.VerifyClusterMembership,
+		csrApproverAllowLegacyKubelet:      policy.AllowLegacyKubelet == nil || *policy.AllowLegacyKubelet,
+	}
+	if policy.InstanceIdentityAudience != "" {
+		ctx.csrApproverInstanceIdentity = newInstanceIdentityVerifier(policy.InstanceIdentityAudience, policy.RequireInstanceIdentity, httpClient)
+	}
+	return ctx, nil
+}
+
+// fixtureInstancePath matches the GA and beta paths of the instances.
+var fixtureInstancePath = regexp.MustCompile(`^/compute/(?:v1|beta)/projects/[^/]+/zones/([^/]+)/instances/([^/]+)$`)
+
+// fixtureTransport answers the GCE and GKE API calls from a fixture, the
+// calls without response are not found.
+type fixtureTransport struct {
+	instances []*compute.Instance
+	responses map[string]json.RawMessage
+	// urls are the responses of the calls outside of the APIs by URL, e.g.
+	// the TPM endorsement CAs.
+	urls map[string][]byte
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if body, ok := t.urls[req.URL.String()]; ok {
+		return fixtureResponse(req, http.StatusOK, body), nil
+	}
+	if resp, ok := t.responses[req.URL.Path]; ok {
+		return fixtureResponse(req, http.StatusOK, resp), nil
+	}
+	if m := fixtureInstancePath.FindStringSubmatch(req.URL.Path); m != nil && req.Method == http.MethodGet {
+		for _, inst := range t.instances {
+			if inst.Name != m[2] {
+				continue
+			}
+			if inst.Zone != "" && filepath.Base(inst.Zone) != m[1] {
+				continue
+			}
+			data, err := json.Marshal(inst)
+			if err != nil {
+				return nil, err
+			}
+			return fixtureResponse(req, http.StatusOK, data), nil
+		}
+	}
+	notFound := fmt.Sprintf(`{"error":{"code":404,"message":"%s not found in the fixture"}}`, req.URL.Path)
+	return fixtureResponse(req, http.StatusNotFound, []byte(notFound)), nil
+}
+
+func fixtureResponse(req *http.Request, code int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// writeCSRPolicyReport writes the decisions as a table, or as JSON if
+// jsonOutput, and returns the number of unexpected decisions.
+func writeCSRPolicyReport(w io.Writer, results []csrPolicyResult, jsonOutput bool) (int, error) {
+	var unexpected int
+	for _, r := range results {
+		if r.unexpected() {
+			unexpected++
+		}
+	}
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return unexpected, enc.Encode(results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIXTURE\tCSR\tDECISION\tEXPECTED\tVALIDATOR\tREASON")
+	for _, r := range results {
+		expected := string(r.Expected)
+		if r.unexpected() {
+			expected += " (UNEXPECTED)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Fixture, r.CSR, r.Decision, expected, r.Validator, strings.ReplaceAll(r.Reason, "\n", " "))
+	}
+	return unexpected, tw.Flush()
+}
+
+// runCSRPolicyEvaluation evaluates the fixtures of the directory with the
+// policy file and writes the report to stdout. It fails if a decision is not
+// the expected one.
+func runCSRPolicyEvaluation(fixturesDir, policyPath, output string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid --csr-policy-evaluation-output %q, must be text or json", output)
+	}
+	policy, err := loadCSRPolicy(policyPath)
+	if err != nil {
+		return err
+	}
+	results, err := evaluateCSRPolicy(policy, fixturesDir)
+	if err != nil {
+		return err
+	}
+	unexpected, err := writeCSRPolicyReport(os.Stdout, results, output == "json")
+	if err != nil {
+		return err
+	}
+	if unexpected > 0 {
+		return fmt.Errorf("%d of %d CSR fixtures have an unexpected decision", unexpected, len(results))
+	}
+	return nil
+}
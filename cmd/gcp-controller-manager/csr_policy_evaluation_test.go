@@ -0,0 +1,238 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	capi "k8s.io/api/certificates/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func writeCSRFixture(t *testing.T, dir, name string, fixture *csrFixture) {
+	t.Helper()
+	data, err := yaml.Marshal(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluateCSRPolicy(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P224(), insecureRand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCSR := makeFancyTestCSR(t, csrBuilder{
+		cn:         "system:node:i0",
+		orgs:       []string{"system:nodes"},
+		requestor:  "system:node:i0",
+		signerName: capi.KubeletServingSignerName,
+		usages:     kubeletServerUsages,
+		dns:        []string{"i0.c.p0.internal"},
+		ips:        []net.IP{net.ParseIP("1.2.3.4")},
+		key:        pk,
+	})
+	serverCSR.Name = "server"
+	legacyCSR := makeFancyTestCSR(t, csrBuilder{
+		cn:         "system:node:i0",
+		orgs:       []string{"system:nodes"},
+		requestor:  legacyKubeletUsername,
+		signerName: capi.KubeAPIServerClientKubeletSignerName,
+		usages:     kubeletClientUsages,
+		key:        pk,
+	})
+	legacyCSR.Name = "legacy"
+	instance := func(ip string) []*compute.Instance {
+		return []*compute.Instance{{
+			Name:              "i0",
+			Zone:              "https://www.googleapis.com/compute/v1/projects/p0/zones/z0",
+			NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: ip}},
+		}}
+	}
+	rejected := false
+
+	dir := t.TempDir()
+	writeCSRFixture(t, dir, "1-server.yaml", &csrFixture{
+		CSR:              serverCSR,
+		Instances:        instance("1.2.3.4"),
+		ExpectedDecision: csrDecisionApproved,
+	})
+	writeCSRFixture(t, dir, "2-server-other-ip.yaml", &csrFixture{
+		CSR:              serverCSR,
+		Instances:        instance("1.2.3.5"),
+		ExpectedDecision: csrDecisionApproved,
+	})
+	writeCSRFixture(t, dir, "3-legacy.yaml", &csrFixture{
+		CSR:              legacyCSR,
+		ExpectedDecision: csrDecisionApproved,
+	})
+	writeCSRFixture(t, dir, "4-legacy-sar-rejected.yaml", &csrFixture{
+		CSR:                        legacyCSR,
+		SubjectAccessReviewAllowed: &rejected,
+		ExpectedDecision:           csrDecisionIgnored,
+	})
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a fixture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &csrPolicy{ProjectID: "p0", ClusterName: "c0", Location: "z0", Zones: []string{"z1", "z0"}}
+	results, err := evaluateCSRPolicy(policy, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		fixture    string
+		decision   csrDecision
+		unexpected bool
+	}{
+		{"1-server.yaml", csrDecisionApproved, false},
+		{"2-server-other-ip.yaml", csrDecisionDenied, true},
+		{"3-legacy.yaml", csrDecisionApproved, false},
+		{"4-legacy-sar-rejected.yaml", csrDecisionIgnored, false},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Fixture != w.fixture || r.Decision != w.decision || r.unexpected() != w.unexpected {
+			t.Errorf("got %+v, want fixture %q, decision %q, unexpected %v", r, w.fixture, w.decision, w.unexpected)
+		}
+		if r.Validator == "" || r.Reason == "" {
+			t.Errorf("fixture %q: got no validator or reason: %+v", r.Fixture, r)
+		}
+	}
+
+	// The legacy kubelet CSRs are not recognized once disallowed.
+	disallowed := false
+	policy.AllowLegacyKubelet = &disallowed
+	results, err = evaluateCSRPolicy(policy, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[2]; r.Decision != csrDecisionIgnored || r.Validator != "" || !r.unexpected() {
+		t.Errorf("got %+v, want unexpected %q without validator", r, csrDecisionIgnored)
+	}
+
+	var text bytes.Buffer
+	unexpected, err := writeCSRPolicyReport(&text, results, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unexpected != 2 {
+		t.Errorf("got %d unexpected decisions, want 2", unexpected)
+	}
+	if got := strings.Count(text.String(), "(UNEXPECTED)"); got != 2 {
+		t.Errorf("got %d unexpected decisions in the report, want 2:\n%s", got, text.String())
+	}
+	var out bytes.Buffer
+	if _, err := writeCSRPolicyReport(&out, results, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []csrPolicyResult
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(results) || decoded[1].Decision != csrDecisionDenied {
+		t.Errorf("got JSON report %s", out.String())
+	}
+}
+
+func TestLoadCSRFixtureInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"no-csr.yaml":           "expectedDecision: Approved\n",
+		"unknown-field.yaml":    "csr: {}\nunknown: true\n",
+		"unknown-decision.yaml": "csr: {}\nexpectedDecision: Approve\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCSRFixture(path); err == nil {
+			t.Errorf("fixture %s: got no error", name)
+		}
+	}
+}
+
+func TestLoadTPMEndorsementCAs(t *testing.T) {
+	const base = "http://pki.example/cloud_integrity"
+	ca := initFakeCA(t, base)
+	pems := map[string]string{}
+	for name, der := range map[string][]byte{
+		"root.crt":         ca.rootCert,
+		"intermediate.crt": ca.intermediateCertRaw,
+	} {
+		pems[base+"/"+name] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	for name, der := range map[string][]byte{
+		"root.crl":         ca.rootCRL,
+		"intermediate.crl": ca.intermediateCRL,
+	} {
+		pems[base+"/"+name] = string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+	}
+	data, err := yaml.Marshal(pems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "tpm-cas.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cas, err := loadTPMEndorsementCAs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CAs and their CRLs are answered by the fixture transport, nothing
+	// is fetched.
+	c := &caCache{
+		rootCertURL: base + "/root.crt",
+		interPrefix: base,
+		client:      &http.Client{Transport: &fixtureTransport{urls: cas}},
+		certs:       make(map[string]*x509.Certificate),
+		crls:        make(map[string]*cachedCRL),
+	}
+	if err := c.verify(ca.validCert); err != nil {
+		t.Errorf("verifying valid certificate: got %v, want nil", err)
+	}
+	if err := c.verify(ca.invalidCerts["revoked"]); err == nil {
+		t.Errorf("verifying revoked certificate: got nil, want non-nil error")
+	}
+
+	if err := os.WriteFile(path, []byte(base+"/root.crt: not a PEM\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTPMEndorsementCAs(path); err == nil {
+		t.Errorf("got no error for a certificate without PEM")
+	}
+}
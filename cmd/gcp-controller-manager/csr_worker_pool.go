@@ -0,0 +1,295 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	certificatesinformers "k8s.io/client-go/informers/certificates/v1"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/csrmetrics"
+	"k8s.io/cloud-provider-gcp/pkg/workqueuemetrics"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/controller/certificates"
+)
+
+const (
+	// defaultCSRWorkerPool is the metric label of the pool serving signers
+	// without a dedicated pool.
+	defaultCSRWorkerPool = "default"
+)
+
+// ignorableErrorType is the type of the errors returned by
+// certificates.IgnorableError, which are retried but only logged at V(4).
+var ignorableErrorType = reflect.TypeOf(certificates.IgnorableError(""))
+
+// csrWorkerPoolConfig configures the worker pools of the CSR controllers.
+type csrWorkerPoolConfig struct {
+	// defaultWorkers is the number of workers of the pool serving signers
+	// without a dedicated pool.
+	defaultWorkers int
+	// signerWorkers maps signer names to the number of workers of their
+	// dedicated pool.
+	signerWorkers map[string]int
+}
+
+func (c csrWorkerPoolConfig) validate() error {
+	if c.defaultWorkers <= 0 {
+		return fmt.Errorf("number of CSR workers must be positive, got %d", c.defaultWorkers)
+	}
+	for signer, workers := range c.signerWorkers {
+		if signer == "" {
+			return fmt.Errorf("CSR signer worker pools require a signer name")
+		}
+		if workers <= 0 {
+			return fmt.Errorf("number of CSR workers for signer %q must be positive, got %d", signer, workers)
+		}
+	}
+	return nil
+}
+
+// csrWorkerPoolController handles CSRs like certificates.CertificateController,
+// but queues them per signer. Signers with a dedicated pool are processed by
+// their own workers, so a burst of CSRs for one signer (e.g. kubelet client
+// certificates during a large node scale-up) does not delay the others.
+type csrWorkerPoolController struct {
+	// name is an identifier for this particular controller instance.
+	name string
+
+	csrLister  certificateslisters.CertificateSigningRequestLister
+	csrsSynced cache.InformerSynced
+
+	handler func(context.Context, *capi.CertificateSigningRequest) error
+
+	// pools holds the dedicated pools, keyed by signer name. defaultPool
+	// serves every other signer.
+	pools       map[string]*csrWorkerPool
+	defaultPool *csrWorkerPool
+}
+
+// csrWorkerPool is a queue of CSR names and the number of workers draining it.
+type csrWorkerPool struct {
+	name    string
+	workers int
+	queue   workqueue.RateLimitingInterface
+
+	// enqueued records when the CSRs waiting in the queue were added, to
+	// measure how long they wait for a worker.
+	lock     sync.Mutex
+	enqueued map[string]time.Time
+}
+
+func newCSRWorkerPoolController(
+	name string,
+	csrInformer certificatesinformers.CertificateSigningRequestInformer,
+	handler func(context.Context, *capi.CertificateSigningRequest) error,
+	cfg csrWorkerPoolConfig,
+) *csrWorkerPoolController {
+	c := &csrWorkerPoolController{
+		name:        name,
+		handler:     handler,
+		pools:       map[string]*csrWorkerPool{},
+		defaultPool: newCSRWorkerPool(name, defaultCSRWorkerPool, cfg.defaultWorkers),
+	}
+	for signer, workers := range cfg.signerWorkers {
+		c.pools[signer] = newCSRWorkerPool(name, signer, workers)
+	}
+
+	csrInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueue(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			c.enqueue(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			csr, ok := obj.(*capi.CertificateSigningRequest)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					klog.V(2).Infof("Couldn't get object from tombstone %#v", obj)
+					return
+				}
+				csr, ok = tombstone.Obj.(*capi.CertificateSigningRequest)
+				if !ok {
+					klog.V(2).Infof("Tombstone contained object that is not a CSR: %#v", obj)
+					return
+				}
+			}
+			c.enqueue(csr)
+		},
+	})
+	c.csrLister = csrInformer.Lister()
+	c.csrsSynced = csrInformer.Informer().HasSynced
+	return c
+}
+
+// newCSRWorkerPool returns the worker pool name of the controller, whose queue
+// metrics are labeled by both names.
+func newCSRWorkerPool(controller, name string, workers int) *csrWorkerPool {
+	return &csrWorkerPool{
+		name:    name,
+		workers: workers,
+		queue: workqueue.NewRateLimitingQueueWithConfig(workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(200*time.Millisecond, 1000*time.Second),
+			// 10 qps, 100 bucket size. This is only for retry speed and it's
+			// only the overall factor (not per item).
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		), workqueue.RateLimitingQueueConfig{
+			Name:            controller + "/" + name,
+			MetricsProvider: workqueuemetrics.Provider(),
+		}),
+		enqueued: map[string]time.Time{},
+	}
+}
+
+// poolFor returns the pool processing the CSRs of the given signer.
+func (c *csrWorkerPoolController) poolFor(signerName string) *csrWorkerPool {
+	if p, ok := c.pools[signerName]; ok {
+		return p
+	}
+	return c.defaultPool
+}
+
+// allPools returns the dedicated pools, sorted by signer name, followed by the
+// default pool.
+func (c *csrWorkerPoolController) allPools() []*csrWorkerPool {
+	var pools []*csrWorkerPool
+	for _, p := range c.pools {
+		pools = append(pools, p)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].name < pools[j].name })
+	return append(pools, c.defaultPool)
+}
+
+func (c *csrWorkerPoolController) enqueue(obj interface{}) {
+	csr, ok := obj.(*capi.CertificateSigningRequest)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("couldn't get CSR from object %#v", obj))
+		return
+	}
+	klog.V(4).Infof("Queueing certificate request %q of signer %q", csr.Name, csr.Spec.SignerName)
+	// The signer name of a CSR is immutable, so every event of a CSR lands
+	// in the same pool.
+	p := c.poolFor(csr.Spec.SignerName)
+	p.lock.Lock()
+	if _, ok := p.enqueued[csr.Name]; !ok {
+		p.enqueued[csr.Name] = time.Now()
+	}
+	p.lock.Unlock()
+	p.queue.Add(csr.Name)
+	csrmetrics.WorkerPoolQueueDepth(c.name, p.name, p.queue.Len())
+}
+
+// Run starts the workers of every pool and blocks until ctx is done.
+func (c *csrWorkerPoolController) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	pools := c.allPools()
+	for _, p := range pools {
+		defer p.queue.ShutDown()
+	}
+
+	klog.Infof("Starting certificate controller %q", c.name)
+	defer klog.Infof("Shutting down certificate controller %q", c.name)
+
+	if !cache.WaitForNamedCacheSync(fmt.Sprintf("certificate-%s", c.name), ctx.Done(), c.csrsSynced) {
+		return
+	}
+
+	for _, p := range pools {
+		klog.Infof("Starting %d workers for CSR pool %q of certificate controller %q", p.workers, p.name, c.name)
+		for i := 0; i < p.workers; i++ {
+			p := p
+			go wait.UntilWithContext(ctx, func(ctx context.Context) {
+				for c.processNextWorkItem(ctx, p) {
+				}
+			}, time.Second)
+		}
+	}
+
+	<-ctx.Done()
+}
+
+// processNextWorkItem deals with one key off the queue of the pool. It returns
+// false when it's time to quit.
+func (c *csrWorkerPoolController) processNextWorkItem(ctx context.Context, p *csrWorkerPool) bool {
+	cKey, quit := p.queue.Get()
+	if quit {
+		return false
+	}
+	defer p.queue.Done(cKey)
+	key := cKey.(string)
+
+	p.lock.Lock()
+	if added, ok := p.enqueued[key]; ok {
+		csrmetrics.WorkerPoolQueueLatency(c.name, p.name, time.Since(added))
+		delete(p.enqueued, key)
+	}
+	p.lock.Unlock()
+	csrmetrics.WorkerPoolQueueDepth(c.name, p.name, p.queue.Len())
+
+	workDone := csrmetrics.WorkerPoolWorkStartRecorder(c.name, p.name)
+	defer workDone()
+
+	if err := c.syncFunc(ctx, key); err != nil {
+		p.queue.AddRateLimited(cKey)
+		if reflect.TypeOf(err) != ignorableErrorType {
+			utilruntime.HandleError(fmt.Errorf("Sync %v failed with : %v", key, err))
+		} else {
+			klog.V(4).Infof("Sync certificate request %q failed: %v", key, err)
+		}
+		return true
+	}
+
+	p.queue.Forget(cKey)
+	return true
+}
+
+func (c *csrWorkerPoolController) syncFunc(ctx context.Context, key string) error {
+	startTime := time.Now()
+	defer func() {
+		klog.V(4).Infof("Finished syncing certificate request %q (%v)", key, time.Since(startTime))
+	}()
+	csr, err := c.csrLister.Get(key)
+	if errors.IsNotFound(err) {
+		klog.V(3).Infof("csr %q has been deleted", key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(csr.Status.Certificate) > 0 {
+		// no need to do anything because it already has a cert
+		return nil
+	}
+
+	// need to operate on a copy so we don't mutate the csr in the shared cache
+	return c.handler(ctx, csr.DeepCopy())
+}
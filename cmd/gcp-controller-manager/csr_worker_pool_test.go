@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/controller/certificates"
+)
+
+func TestCSRWorkerPoolConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		cfg     csrWorkerPoolConfig
+		wantErr bool
+	}{
+		{
+			desc: "valid",
+			cfg:  csrWorkerPoolConfig{defaultWorkers: 20, signerWorkers: map[string]int{"kubernetes.io/kubelet-serving": 5}},
+		},
+		{
+			desc: "no dedicated pools",
+			cfg:  csrWorkerPoolConfig{defaultWorkers: 1},
+		},
+		{
+			desc:    "no default workers",
+			cfg:     csrWorkerPoolConfig{defaultWorkers: 0},
+			wantErr: true,
+		},
+		{
+			desc:    "no signer workers",
+			cfg:     csrWorkerPoolConfig{defaultWorkers: 20, signerWorkers: map[string]int{"kubernetes.io/kubelet-serving": 0}},
+			wantErr: true,
+		},
+		{
+			desc:    "empty signer name",
+			cfg:     csrWorkerPoolConfig{defaultWorkers: 20, signerWorkers: map[string]int{"": 5}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.cfg.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("validate() = %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSRWorkerPoolControllerIsolatesSigners(t *testing.T) {
+	const (
+		busySigner  = "kubernetes.io/kube-apiserver-client-kubelet"
+		otherSigner = "example.com/other"
+	)
+	newCSR := func(name, signer string) *capi.CertificateSigningRequest {
+		return &capi.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       capi.CertificateSigningRequestSpec{SignerName: signer},
+		}
+	}
+	signed := newCSR("signed", otherSigner)
+	signed.Status.Certificate = []byte("cert")
+	client := fake.NewSimpleClientset(
+		newCSR("busy", busySigner),
+		newCSR("other", otherSigner),
+		newCSR("retried", otherSigner),
+		signed,
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+
+	release := make(chan struct{})
+	handled := make(chan string, 10)
+	retried := false
+	handler := func(ctx context.Context, csr *capi.CertificateSigningRequest) error {
+		switch csr.Name {
+		case "busy":
+			// Hold the only worker of the busy signer's pool.
+			<-release
+		case "retried":
+			if !retried {
+				retried = true
+				return certificates.IgnorableError("not yet")
+			}
+		}
+		handled <- csr.Name
+		return nil
+	}
+	c := newCSRWorkerPoolController("test", informerFactory.Certificates().V1().CertificateSigningRequests(), handler, csrWorkerPoolConfig{
+		defaultWorkers: 1,
+		signerWorkers:  map[string]int{busySigner: 1},
+	})
+	if got := c.poolFor(busySigner).name; got != busySigner {
+		t.Errorf("poolFor(%q) = %q, want %q", busySigner, got, busySigner)
+	}
+	if got := c.poolFor(otherSigner).name; got != defaultCSRWorkerPool {
+		t.Errorf("poolFor(%q) = %q, want %q", otherSigner, got, defaultCSRWorkerPool)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	go c.Run(ctx)
+
+	waitHandled := func(want map[string]bool) {
+		t.Helper()
+		for len(want) > 0 {
+			select {
+			case name := <-handled:
+				if !want[name] {
+					t.Fatalf("unexpected CSR %q handled", name)
+				}
+				delete(want, name)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timed out waiting for CSRs %v", want)
+			}
+		}
+	}
+	// The CSRs of the other signer are handled while the busy signer's pool
+	// is blocked, and the one failing once is retried.
+	waitHandled(map[string]bool{"other": true, "retried": true})
+	close(release)
+	waitHandled(map[string]bool{"busy": true})
+
+	select {
+	case name := <-handled:
+		t.Errorf("unexpected CSR %q handled", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
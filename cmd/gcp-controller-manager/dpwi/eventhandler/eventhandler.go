@@ -26,6 +26,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/cloud-provider-gcp/cmd/gcp-controller-manager/dpwi/ctxlog"
+	"k8s.io/cloud-provider-gcp/pkg/workqueuemetrics"
 	"k8s.io/klog/v2"
 )
 
@@ -38,9 +39,12 @@ type EventHandler struct {
 // InitEventHandler initializes an exponential backoff queue.
 // It accepts a process function, which process a single event.
 func (eh *EventHandler) InitEventHandler(name string, process func(context.Context, string) error) {
-	eh.queue = workqueue.NewNamedRateLimitingQueue(workqueue.NewMaxOfRateLimiter(
+	eh.queue = workqueue.NewRateLimitingQueueWithConfig(workqueue.NewMaxOfRateLimiter(
 		workqueue.NewItemExponentialFailureRateLimiter(200*time.Millisecond, 1000*time.Second),
-	), name)
+	), workqueue.RateLimitingQueueConfig{
+		Name:            name,
+		MetricsProvider: workqueuemetrics.Provider(),
+	})
 	eh.process = process
 }
 
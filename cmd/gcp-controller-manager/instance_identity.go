@@ -0,0 +1,352 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/cloud-provider-gcp/pkg/csrmetrics"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// instanceIdentityPEMBlock is the PEM block of the CSR request carrying
+	// the GCE instance identity token of the requesting instance.
+	instanceIdentityPEMBlock = "INSTANCE IDENTITY TOKEN"
+	// instanceIdentityAnnotationKey is the CSR annotation carrying the GCE
+	// instance identity token, for the kubelets which cannot add PEM blocks
+	// to their CSR request.
+	instanceIdentityAnnotationKey = "cloud.gke.io/instance-identity-token"
+
+	googleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+	// googleCertsMinRefreshInterval rate limits the refreshes of the Google
+	// signing keys triggered by tokens signed by unknown keys.
+	googleCertsMinRefreshInterval = time.Minute
+	// instanceIdentityClockSkew is the tolerated clock skew between the
+	// controller manager and Google when validating the token lifetime.
+	instanceIdentityClockSkew = time.Minute
+)
+
+// errGoogleKeysUnavailable is returned when the Google signing keys cannot be
+// fetched, the CSRs are retried rather than denied.
+var errGoogleKeysUnavailable = errors.New("fetching Google signing keys")
+
+// instanceIdentityVerifier verifies the GCE instance identity tokens of the
+// kubelet client CSRs, see
+// https://cloud.google.com/compute/docs/instances/verifying-instance-identity.
+//
+// The token binds the CSR to the instance: its audience must be the configured
+// audience followed by the hex SHA-256 of the public key of the CSR, so that a
+// token cannot be replayed with another key. The token must be requested with
+// format=full for the claims of the instance to be present.
+type instanceIdentityVerifier struct {
+	audience string
+	// required denies the legacy kubelet client CSRs without token.
+	required bool
+	keys     *googleKeySet
+}
+
+func newInstanceIdentityVerifier(audience string, required bool, client *http.Client) *instanceIdentityVerifier {
+	return &instanceIdentityVerifier{
+		audience: audience,
+		required: required,
+		keys:     &googleKeySet{url: googleCertsURL, client: client},
+	}
+}
+
+// instanceIdentityAudience returns the audience the token of a CSR with the
+// public key spki must be issued for.
+func instanceIdentityAudience(audience string, spki []byte) string {
+	sum := sha256.Sum256(spki)
+	return audience + "/" + hex.EncodeToString(sum[:])
+}
+
+type instanceIdentityClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+	Google   struct {
+		ComputeEngine struct {
+			ProjectID    string `json:"project_id"`
+			Zone         string `json:"zone"`
+			InstanceID   string `json:"instance_id"`
+			InstanceName string `json:"instance_name"`
+		} `json:"compute_engine"`
+	} `json:"google"`
+}
+
+// verify checks the signature and the lifetime of the token, and returns its
+// claims. Failures to fetch the signing keys wrap errGoogleKeysUnavailable.
+func (v *instanceIdentityVerifier) verify(token string, now time.Time) (*instanceIdentityClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("parsing token header: %v", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Algorithm)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token signature: %v", err)
+	}
+	key, err := v.keys.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("verifying token signature: %v", err)
+	}
+
+	claims := new(instanceIdentityClaims)
+	if err := decodeJWTSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("parsing token claims: %v", err)
+	}
+	if claims.Issuer != "https://accounts.google.com" && claims.Issuer != "accounts.google.com" {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if now.After(time.Unix(claims.Expiry, 0).Add(instanceIdentityClockSkew)) {
+		return nil, fmt.Errorf("token expired at %v", time.Unix(claims.Expiry, 0))
+	}
+	if now.Add(instanceIdentityClockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, fmt.Errorf("token issued in the future at %v", time.Unix(claims.IssuedAt, 0))
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// googleKeySet caches the public keys Google signs the instance identity
+// tokens with. The keys are rotated, so they are refreshed when a token is
+// signed by an unknown key.
+type googleKeySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func (s *googleKeySet) key(kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if s.keys != nil && time.Since(s.fetched) < googleCertsMinRefreshInterval {
+		return nil, fmt.Errorf("token signed by unknown key %q", kid)
+	}
+	keys, err := s.fetch()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errGoogleKeysUnavailable, err)
+	}
+	s.keys = keys
+	s.fetched = time.Now()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("token signed by unknown key %q", kid)
+}
+
+func (s *googleKeySet) fetch() (map[string]*rsa.PublicKey, error) {
+	recordMetric := csrmetrics.OutboundRPCStartRecorder("oauth2.Certs.Get")
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		recordMetric(csrmetrics.OutboundRPCStatusError)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		recordMetric(csrmetrics.OutboundRPCStatusError)
+		return nil, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		recordMetric(csrmetrics.OutboundRPCStatusError)
+		return nil, err
+	}
+	recordMetric(csrmetrics.OutboundRPCStatusOK)
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding modulus of key %q: %v", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding exponent of key %q: %v", k.KeyID, err)
+		}
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// instanceIdentityToken returns the instance identity token of the CSR, from
+// its request or from its annotation.
+func instanceIdentityToken(csr *capi.CertificateSigningRequest) string {
+	if blocks, err := parsePEMBlocks(csr.Spec.Request); err == nil {
+		if b, ok := blocks[instanceIdentityPEMBlock]; ok {
+			return strings.TrimSpace(string(b.Bytes))
+		}
+	}
+	return strings.TrimSpace(csr.Annotations[instanceIdentityAnnotationKey])
+}
+
+func isNodeClientCertWithInstanceIdentity(csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) bool {
+	if !isNodeClientCert(csr, x509cr) {
+		return false
+	}
+	if csr.Spec.Username != tpmKubeletUsername && csr.Spec.Username != legacyKubeletUsername {
+		return false
+	}
+	return instanceIdentityToken(csr) != ""
+}
+
+// validateInstanceIdentity approves the kubelet client CSRs whose instance
+// identity token was issued by Google to the instance named by the CSR, for
+// the public key of the CSR.
+func validateInstanceIdentity(ctx *controllerContext, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) (bool, error) {
+	v := ctx.csrApproverInstanceIdentity
+	claims, err := v.verify(instanceIdentityToken(csr), time.Now())
+	if err != nil {
+		if errors.Is(err, errGoogleKeysUnavailable) {
+			return false, err
+		}
+		klog.Infof("deny CSR %q: verifying instance identity token: %v", csr.Name, err)
+		return false, nil
+	}
+	if want := instanceIdentityAudience(v.audience, x509cr.RawSubjectPublicKeyInfo); claims.Audience != want {
+		klog.Infof("deny CSR %q: instance identity token audience %q doesn't match the CSR public key, want %q", csr.Name, claims.Audience, want)
+		return false, nil
+	}
+
+	gce := claims.Google.ComputeEngine
+	hostname := strings.TrimPrefix(x509cr.Subject.CommonName, "system:node:")
+	if !isInstanceHostname(hostname, gce.InstanceName, gce.Zone, gce.ProjectID) {
+		klog.Infof("deny CSR %q: instance name in instance identity token (%q) doesn't match CommonName in x509 CSR (%q)", csr.Name, gce.InstanceName, x509cr.Subject.CommonName)
+		return false, nil
+	}
+	if gce.ProjectID != ctx.gcpCfg.ProjectID {
+		klog.Infof("deny CSR %q: instance identity token issued in a different project (%q)", csr.Name, gce.ProjectID)
+		return false, nil
+	}
+	if !hasZone(ctx.gcpCfg.Zones, gce.Zone) {
+		klog.Infof("deny CSR %q: instance identity token issued in a zone (%q) outside of the cluster", csr.Name, gce.Zone)
+		return false, nil
+	}
+
+	recordMetric := csrmetrics.OutboundRPCStartRecorder("compute.InstancesService.Get")
+	srv := compute.NewInstancesService(ctx.gcpCfg.Compute)
+	inst, err := srv.Get(ctx.gcpCfg.ProjectID, gce.Zone, gce.InstanceName).Do()
+	if err != nil {
+		if isNotFound(err) {
+			klog.Infof("deny CSR %q: VM doesn't exist in GCE API: %v", csr.Name, err)
+			recordMetric(csrmetrics.OutboundRPCStatusNotFound)
+			return false, nil
+		}
+		recordMetric(csrmetrics.OutboundRPCStatusError)
+		return false, fmt.Errorf("fetching VM data from GCE API: %v", err)
+	}
+	recordMetric(csrmetrics.OutboundRPCStatusOK)
+	// The instance names are reused, e.g. by the MIGs recreating preempted
+	// VMs, the token of a deleted VM must not be accepted for its successor.
+	if strconv.FormatUint(inst.Id, 10) != gce.InstanceID {
+		klog.Infof("deny CSR %q: instance ID in instance identity token (%q) doesn't match VM %q (%d)", csr.Name, gce.InstanceID, inst.Name, inst.Id)
+		return false, nil
+	}
+	if isAutoApproveBlocked(inst) {
+		klog.Infof("deny CSR %q: auto-approval is blocked by the metadata of VM %q", csr.Name, inst.Name)
+		return false, nil
+	}
+	if ctx.csrApproverVerifyClusterMembership {
+		ok, err := clusterHasInstance(ctx, inst, getInstanceMetadata(inst, createdByInstanceMetadataKey))
+		if err != nil {
+			return false, fmt.Errorf("checking VM membership in cluster: %v", err)
+		}
+		if !ok {
+			klog.Infof("deny CSR %q: VM %q doesn't belong to cluster %q", csr.Name, inst.Name, ctx.gcpCfg.ClusterName)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isInstanceHostname returns true if the hostname is the name of the
+// instance or its fully qualified internal DNS name, either zonal or global.
+func isInstanceHostname(hostname, instanceName, zone, projectID string) bool {
+	switch hostname {
+	case instanceName,
+		fmt.Sprintf("%s.%s.c.%s.internal", instanceName, zone, projectID),
+		fmt.Sprintf("%s.c.%s.internal", instanceName, projectID):
+		return true
+	}
+	return false
+}
+
+func hasZone(zones []string, zone string) bool {
+	for _, z := range zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
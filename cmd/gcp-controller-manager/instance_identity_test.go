@@ -0,0 +1,248 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	certsv1 "k8s.io/api/certificates/v1"
+)
+
+const testInstanceIdentityAudience = "https://gcp-controller-manager.test"
+
+func fakeGoogleCerts(t *testing.T, keys map[string]*rsa.PublicKey) *httptest.Server {
+	type jwk struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		jwks.Keys = append(jwks.Keys, jwk{
+			KeyType: "RSA",
+			KeyID:   kid,
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(rw).Encode(jwks)
+	}))
+}
+
+func makeInstanceIdentityToken(t *testing.T, key *rsa.PrivateKey, kid string, claims *instanceIdentityClaims) string {
+	segment := func(v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(insecureRand, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestValidateInstanceIdentity(t *testing.T) {
+	googleKey, err := rsa.GenerateKey(insecureRand, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(insecureRand, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := fakeGoogleCerts(t, map[string]*rsa.PublicKey{"k0": &googleKey.PublicKey})
+	defer certs.Close()
+	client, srv := fakeGCPAPI(t, nil)
+	defer srv.Close()
+	cs, err := compute.New(client)
+	if err != nil {
+		t.Fatalf("creating GCE API client: %v", err)
+	}
+
+	goodClaims := func(b *csrBuilder) *instanceIdentityClaims {
+		spki, err := x509.MarshalPKIXPublicKey(b.key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := &instanceIdentityClaims{
+			Issuer:   "https://accounts.google.com",
+			Audience: instanceIdentityAudience(testInstanceIdentityAudience, spki),
+			IssuedAt: time.Now().Unix(),
+			Expiry:   time.Now().Add(time.Hour).Unix(),
+		}
+		claims.Google.ComputeEngine.ProjectID = "p0"
+		claims.Google.ComputeEngine.Zone = "z0"
+		claims.Google.ComputeEngine.InstanceID = "1"
+		claims.Google.ComputeEngine.InstanceName = "i0"
+		return claims
+	}
+	withToken := func(key *rsa.PrivateKey, kid string, mutate func(*instanceIdentityClaims)) func(*csrBuilder, *controllerContext) {
+		return func(b *csrBuilder, c *controllerContext) {
+			b.cn = "system:node:i0"
+			b.requestor = tpmKubeletUsername
+			b.signerName = certsv1.KubeAPIServerClientKubeletSignerName
+			c.gcpCfg.ProjectID = "p0"
+			c.gcpCfg.Zones = []string{"z1", "z0"}
+			c.gcpCfg.Compute = cs
+			c.csrApproverInstanceIdentity = newInstanceIdentityVerifier(testInstanceIdentityAudience, false, certs.Client())
+			c.csrApproverInstanceIdentity.keys.url = certs.URL + "/"
+			claims := goodClaims(b)
+			if mutate != nil {
+				mutate(claims)
+			}
+			b.extraPEM[instanceIdentityPEMBlock] = []byte(makeInstanceIdentityToken(t, key, kid, claims))
+		}
+	}
+
+	goodCases := []func(*csrBuilder, *controllerContext){
+		withToken(googleKey, "k0", nil),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Issuer = "accounts.google.com" }),
+		// The node is named after the internal DNS name of the instance.
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.cn = "system:node:i0.z0.c.p0.internal"
+		},
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.cn = "system:node:i0.c.p0.internal"
+		},
+	}
+	testRecognizer(t, "recognize", goodCases, isNodeClientCertWithInstanceIdentity, true)
+	testValidator(t, "good", goodCases, validateInstanceIdentity, true, false)
+
+	badCases := []func(*csrBuilder, *controllerContext){
+		// Signed by another key.
+		withToken(otherKey, "k0", nil),
+		// Signed by an unknown key.
+		withToken(otherKey, "k1", nil),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Issuer = "https://evil.example.com" }),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Expiry = time.Now().Add(-time.Hour).Unix() }),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.IssuedAt = time.Now().Add(time.Hour).Unix() }),
+		// Issued for the public key of another CSR.
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) {
+			c.Audience = instanceIdentityAudience(testInstanceIdentityAudience, []byte("other key"))
+		}),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Audience = testInstanceIdentityAudience }),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Google.ComputeEngine.InstanceName = "i1" }),
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.cn = "system:node:i0.z1.c.p0.internal"
+		},
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.cn = "system:node:i0.example.com"
+		},
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Google.ComputeEngine.ProjectID = "p1" }),
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Google.ComputeEngine.Zone = "z2" }),
+		// Issued to a deleted VM with the same name.
+		withToken(googleKey, "k0", func(c *instanceIdentityClaims) { c.Google.ComputeEngine.InstanceID = "42" }),
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.extraPEM[instanceIdentityPEMBlock] = []byte("not a token")
+		},
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", func(c *instanceIdentityClaims) {
+				c.Google.ComputeEngine.InstanceName = "blocked"
+				c.Google.ComputeEngine.InstanceID = "5"
+			})(b, c)
+			b.cn = "system:node:blocked"
+		},
+	}
+	testValidator(t, "bad", badCases, validateInstanceIdentity, false, false)
+
+	unrecognizedCases := []func(*csrBuilder, *controllerContext){
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			delete(b.extraPEM, instanceIdentityPEMBlock)
+		},
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.requestor = "system:node:i0"
+		},
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			b.signerName = certsv1.KubeletServingSignerName
+		},
+	}
+	testRecognizer(t, "unrecognized", unrecognizedCases, isNodeClientCertWithInstanceIdentity, false)
+
+	errorCases := []func(*csrBuilder, *controllerContext){
+		// The Google signing keys are unavailable.
+		func(b *csrBuilder, c *controllerContext) {
+			withToken(googleKey, "k0", nil)(b, c)
+			c.csrApproverInstanceIdentity.keys.url = certs.URL + "/unavailable"
+		},
+	}
+	testValidator(t, "error", errorCases, validateInstanceIdentity, false, true)
+}
+
+func TestInstanceIdentityTokenAnnotation(t *testing.T) {
+	csr := makeTestCSR(t)
+	if got := instanceIdentityToken(csr); got != "" {
+		t.Errorf("instanceIdentityToken() = %q, want no token", got)
+	}
+	csr.Annotations = map[string]string{instanceIdentityAnnotationKey: " a.b.c\n"}
+	if got, want := instanceIdentityToken(csr), "a.b.c"; got != want {
+		t.Errorf("instanceIdentityToken() = %q, want %q", got, want)
+	}
+}
+
+func TestValidateLegacyNodeClientCertRequiresInstanceIdentity(t *testing.T) {
+	client, srv := fakeGCPAPI(t, nil)
+	defer srv.Close()
+	cs, err := compute.New(client)
+	if err != nil {
+		t.Fatalf("creating GCE API client: %v", err)
+	}
+	legacyCase := func(required bool) func(*csrBuilder, *controllerContext) {
+		return func(b *csrBuilder, c *controllerContext) {
+			b.cn = "system:node:i0"
+			b.requestor = legacyKubeletUsername
+			b.signerName = certsv1.KubeAPIServerClientKubeletSignerName
+			c.gcpCfg.ProjectID = "p0"
+			c.gcpCfg.Zones = []string{"z0"}
+			c.gcpCfg.Compute = cs
+			c.csrApproverInstanceIdentity = newInstanceIdentityVerifier(testInstanceIdentityAudience, required, nil)
+		}
+	}
+	testValidator(t, "optional", []func(*csrBuilder, *controllerContext){legacyCase(false)}, validateLegacyNodeClientCert, true, false)
+	testValidator(t, "required", []func(*csrBuilder, *controllerContext){legacyCase(true)}, validateLegacyNodeClientCert, false, false)
+}
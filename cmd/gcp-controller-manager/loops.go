@@ -32,7 +32,6 @@ import (
 	"k8s.io/cloud-provider-gcp/cmd/gcp-controller-manager/dpwi/pods"
 	"k8s.io/cloud-provider-gcp/cmd/gcp-controller-manager/dpwi/serviceaccounts"
 	"k8s.io/klog/v2"
-	"k8s.io/kubernetes/pkg/controller/certificates"
 )
 
 type controllerContext struct {
@@ -44,11 +43,14 @@ type controllerContext struct {
 	csrApproverVerifyClusterMembership    bool
 	csrApproverAllowLegacyKubelet         bool
 	csrApproverListReferrersConfig        gceInstanceListReferrersConfig
+	csrApproverInstanceIdentity           *instanceIdentityVerifier
 	authAuthorizeServiceAccountMappingURL string
 	authSyncNodeURL                       string
 	hmsAuthorizeSAMappingURL              string
 	hmsSyncNodeURL                        string
 	clearStalePodsOnNodeRegistration      bool
+	csrWorkerPools                        csrWorkerPoolConfig
+	nodeKubeEnvDrift                      *kubeEnvDriftConfig
 }
 
 // loops returns all the control loops that the GCPControllerManager can start.
@@ -58,35 +60,35 @@ func loops() map[string]func(context.Context, *controllerContext) error {
 	ll := map[string]func(context.Context, *controllerContext) error{
 		"node-certificate-approver": func(ctx context.Context, controllerCtx *controllerContext) error {
 			approver := newNodeApprover(controllerCtx)
-			approveController := certificates.NewCertificateController(ctx,
+			approveController := newCSRWorkerPoolController(
 				"node-certificate-approver",
-				controllerCtx.client,
 				controllerCtx.sharedInformers.Certificates().V1().CertificateSigningRequests(),
 				approver.handle,
+				controllerCtx.csrWorkerPools,
 			)
-			go approveController.Run(ctx, 20)
+			go approveController.Run(ctx)
 			return nil
 		},
 		"istiod-certificate-approver": func(ctx context.Context, controllerCtx *controllerContext) error {
 			approver := newIstiodApprover(controllerCtx)
-			approveController := certificates.NewCertificateController(ctx,
+			approveController := newCSRWorkerPoolController(
 				"istiod-certificate-approver",
-				controllerCtx.client,
 				controllerCtx.sharedInformers.Certificates().V1().CertificateSigningRequests(),
 				approver.handle,
+				controllerCtx.csrWorkerPools,
 			)
-			go approveController.Run(ctx, 20)
+			go approveController.Run(ctx)
 			return nil
 		},
 		"oidc-certificate-approver": func(ctx context.Context, controllerCtx *controllerContext) error {
 			approver := newOIDCApprover(controllerCtx)
-			approveController := certificates.NewCertificateController(ctx,
+			approveController := newCSRWorkerPoolController(
 				"oidc-certificate-approver",
-				controllerCtx.client,
 				controllerCtx.sharedInformers.Certificates().V1().CertificateSigningRequests(),
 				approver.handle,
+				controllerCtx.csrWorkerPools,
 			)
-			go approveController.Run(ctx, 20)
+			go approveController.Run(ctx)
 			return nil
 		},
 		"certificate-signer": func(ctx context.Context, controllerCtx *controllerContext) error {
@@ -94,14 +96,14 @@ func loops() map[string]func(context.Context, *controllerContext) error {
 			if err != nil {
 				return err
 			}
-			signController := certificates.NewCertificateController(ctx,
+			signController := newCSRWorkerPoolController(
 				"signer",
-				controllerCtx.client,
 				controllerCtx.sharedInformers.Certificates().V1().CertificateSigningRequests(),
 				signer.handle,
+				controllerCtx.csrWorkerPools,
 			)
 
-			go signController.Run(ctx, 20)
+			go signController.Run(ctx)
 			return nil
 		},
 		"node-annotator": func(ctx context.Context, controllerCtx *controllerContext) error {
@@ -109,6 +111,7 @@ func loops() map[string]func(context.Context, *controllerContext) error {
 				controllerCtx.client,
 				controllerCtx.sharedInformers.Core().V1().Nodes(),
 				controllerCtx.gcpCfg.BetaCompute,
+				controllerCtx.nodeKubeEnvDrift,
 			)
 			if err != nil {
 				return err
@@ -123,13 +126,13 @@ func loops() map[string]func(context.Context, *controllerContext) error {
 	if *kubeletReadOnlyCSRApprover {
 		ll["kubelet-readonly-approver"] = func(ctx context.Context, controllerCtx *controllerContext) error {
 			approver := newKubeletReadonlyCSRApprover(controllerCtx)
-			approveController := certificates.NewCertificateController(ctx,
+			approveController := newCSRWorkerPoolController(
 				"kubelet-readonly-approver",
-				controllerCtx.client,
 				controllerCtx.sharedInformers.Certificates().V1().CertificateSigningRequests(),
 				approver.handle,
+				controllerCtx.csrWorkerPools,
 			)
-			go approveController.Run(ctx, 20)
+			go approveController.Run(ctx)
 			return nil
 		}
 	}
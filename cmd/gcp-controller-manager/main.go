@@ -71,6 +71,11 @@ var (
 	csrApproverUseGCEInstanceListReferrers  = pflag.Bool("csr-use-gce-instance-list-referrers", false, "If true use https://cloud.google.com/compute/docs/reference/rest/v1/instances/listReferrers to validate instance cluster membership.")
 	csrApproverListReferrersInitialInterval = pflag.Duration("csr-gce-list-referrers-initial-interval", 5*time.Second, "Initial interval of the exponential back-off retries for calls to listReferrers, exponential factor is set to 1.5, defaults to 5s.")
 	csrApproverListReferrersRetryCount      = pflag.Int("csr-gce-list-referrers-retry-count", 10, "Maximal number of retries in exponential back-off for calls to listReferrers, defaults to 10")
+	csrApproverInstanceIdentityAudience     = pflag.String("csr-instance-identity-audience", "", "If set, approve the kubelet client CSRs carrying a GCE instance identity token issued to the instance named by the CSR, for the audience <value>/<hex SHA-256 of the CSR public key>.")
+	csrApproverRequireInstanceIdentity      = pflag.Bool("csr-require-instance-identity", false, "If true, deny the legacy kubelet client CSRs without GCE instance identity token. Requires --csr-instance-identity-audience.")
+	csrPolicyEvaluationFixtures             = pflag.String("csr-policy-evaluation-fixtures", "", "If set, evaluate offline the node CSR approver for the CSR fixtures of the directory, print the approve and deny decisions and exit, without connecting to the cluster. The evaluation fails if a fixture has an unexpected decision.")
+	csrPolicyEvaluationConfig               = pflag.String("csr-policy-evaluation-config", "", "Path to the policy of the node CSR approver evaluated by --csr-policy-evaluation-fixtures, the cluster and the csr-* flags of the approver.")
+	csrPolicyEvaluationOutput               = pflag.String("csr-policy-evaluation-output", "text", "Format of the report of --csr-policy-evaluation-fixtures, text or json.")
 	gceAPIEndpointOverride                  = pflag.String("gce-api-endpoint-override", "", "If set, talks to a different GCE API Endpoint. By default it talks to https://www.googleapis.com/compute/v1/projects/")
 	directPath                              = pflag.Bool("direct-path", false, "Enable Direct Path.")
 	authAuthorizeServiceAccountMappingURL   = pflag.String("auth-authorize-service-account-mapping-url", "", "URL for reaching the Auth Service AuthorizeServiceAccountMapping API.")
@@ -82,6 +87,14 @@ var (
 	clearStalePodsOnNodeRegistration        = pflag.Bool("clearStalePodsOnNodeRegistration", false, "If true, after node registration, delete pods bound to old node.")
 	kubeconfigQPS                           = pflag.Float32("kubeconfig-qps", 100, "QPS to use while talking with kube-apiserver.")
 	kubeconfigBurst                         = pflag.Int("kubeconfig-burst", 200, "Burst to use while talking with kube-apiserver.")
+	nodeKubeEnvDriftDetection               = pflag.Bool("node-kube-env-drift-detection", false, "If true, the node-annotator sets the KubeEnvDrift condition of the nodes whose kube-env instance metadata drifts from the cluster: node_labels not agreeing with the kube-labels metadata, or cluster_dns and node_taints not matching --node-kube-env-cluster-dns and --node-kube-env-required-taints.")
+	nodeKubeEnvClusterDNS                   = pflag.StringSlice("node-kube-env-cluster-dns", nil, "The cluster DNS servers expected in the cluster_dns of kube-env by --node-kube-env-drift-detection, not checked if empty.")
+	nodeKubeEnvRequiredTaints               = pflag.StringSlice("node-kube-env-required-taints", nil, "The taints, formatted as key=value:effect, expected in the node_taints of kube-env by --node-kube-env-drift-detection.")
+	csrWorkers                              = pflag.Int("csr-workers", 20, "Number of workers of each CSR controller processing the CSRs of signers without a dedicated pool set by --csr-signer-workers.")
+	csrSignerWorkers                        = pflag.StringToInt("csr-signer-workers", map[string]int{
+		"kubernetes.io/kube-apiserver-client-kubelet": 20,
+		"kubernetes.io/kubelet-serving":               20,
+	}, "Signers whose CSRs are processed by a dedicated pool of workers in each CSR controller, with the number of workers of the pool, e.g. kubernetes.io/kubelet-serving=20.")
 )
 
 func main() {
@@ -105,6 +118,14 @@ func main() {
 	// InitLogs should be called after parsing flags.
 	logs.InitLogs()
 
+	if *csrPolicyEvaluationFixtures != "" {
+		if err := runCSRPolicyEvaluation(*csrPolicyEvaluationFixtures, *csrPolicyEvaluationConfig, *csrPolicyEvaluationOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	s := &controllerManager{
 		clusterSigningGKEKubeconfig:        *clusterSigningGKEKubeconfig,
 		gceConfigPath:                      *gceConfigPath,
@@ -126,6 +147,28 @@ func main() {
 		kubeletReadOnlyCSRApprover:            *kubeletReadOnlyCSRApprover,
 		autopilotEnabled:                      *autopilotEnabled,
 		clearStalePodsOnNodeRegistration:      *clearStalePodsOnNodeRegistration,
+		csrWorkerPools: csrWorkerPoolConfig{
+			defaultWorkers: *csrWorkers,
+			signerWorkers:  *csrSignerWorkers,
+		},
+	}
+	if err := s.csrWorkerPools.validate(); err != nil {
+		klog.Exitf("invalid CSR worker pool flags: %v", err)
+	}
+	if *nodeKubeEnvDriftDetection {
+		s.nodeKubeEnvDrift = &kubeEnvDriftConfig{clusterDNS: *nodeKubeEnvClusterDNS}
+		if len(*nodeKubeEnvRequiredTaints) > 0 {
+			taints, err := parseTaints(strings.Join(*nodeKubeEnvRequiredTaints, ","))
+			if err != nil {
+				klog.Exitf("invalid --node-kube-env-required-taints: %v", err)
+			}
+			s.nodeKubeEnvDrift.requiredTaints = taints
+		}
+	}
+	if *csrApproverInstanceIdentityAudience != "" {
+		s.csrApproverInstanceIdentity = newInstanceIdentityVerifier(*csrApproverInstanceIdentityAudience, *csrApproverRequireInstanceIdentity, &http.Client{Timeout: kubeconfigTimeout})
+	} else if *csrApproverRequireInstanceIdentity {
+		klog.Exitf("--csr-require-instance-identity requires --csr-instance-identity-audience")
 	}
 	var err error
 	s.informerKubeconfig, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
@@ -179,6 +222,7 @@ type controllerManager struct {
 	csrApproverVerifyClusterMembership    bool
 	csrApproverAllowLegacyKubelet         bool
 	csrApproverListReferrersConfig        gceInstanceListReferrersConfig
+	csrApproverInstanceIdentity           *instanceIdentityVerifier
 	leaderElectionConfig                  componentbaseconfig.LeaderElectionConfiguration
 	authAuthorizeServiceAccountMappingURL string
 	authSyncNodeURL                       string
@@ -186,6 +230,8 @@ type controllerManager struct {
 	hmsSyncNodeURL                        string
 	autopilotEnabled                      bool
 	clearStalePodsOnNodeRegistration      bool
+	csrWorkerPools                        csrWorkerPoolConfig
+	nodeKubeEnvDrift                      *kubeEnvDriftConfig
 
 	// Kubelet Readonly CSR Approver
 	kubeletReadOnlyCSRApprover bool
@@ -258,11 +304,14 @@ func run(s *controllerManager) error {
 				csrApproverVerifyClusterMembership:    s.csrApproverVerifyClusterMembership,
 				csrApproverAllowLegacyKubelet:         s.csrApproverAllowLegacyKubelet,
 				csrApproverListReferrersConfig:        s.csrApproverListReferrersConfig,
+				csrApproverInstanceIdentity:           s.csrApproverInstanceIdentity,
 				authAuthorizeServiceAccountMappingURL: s.authAuthorizeServiceAccountMappingURL,
 				authSyncNodeURL:                       s.authSyncNodeURL,
 				hmsAuthorizeSAMappingURL:              s.hmsAuthorizeSAMappingURL,
 				hmsSyncNodeURL:                        s.hmsSyncNodeURL,
 				clearStalePodsOnNodeRegistration:      s.clearStalePodsOnNodeRegistration,
+				csrWorkerPools:                        s.csrWorkerPools,
+				nodeKubeEnvDrift:                      s.nodeKubeEnvDrift,
 			}); err != nil {
 				klog.Fatalf("Failed to start %q: %v", name, err)
 			}
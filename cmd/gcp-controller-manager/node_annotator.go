@@ -39,6 +39,7 @@ import (
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/workqueuemetrics"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/controller"
 )
@@ -59,11 +60,14 @@ type nodeAnnotator struct {
 	hasSynced  func() bool
 	queue      workqueue.RateLimitingInterface
 	annotators []annotator
+	// kubeEnvDrift enables the nodeConditionKubeEnvDrift condition when set.
+	kubeEnvDrift *kubeEnvDriftConfig
 	// for testing
 	getInstance func(nodeURL string) (*compute.Instance, error)
+	now         func() metav1.Time
 }
 
-func newNodeAnnotator(client clientset.Interface, nodeInformer coreinformers.NodeInformer, cs *compute.Service) (*nodeAnnotator, error) {
+func newNodeAnnotator(client clientset.Interface, nodeInformer coreinformers.NodeInformer, cs *compute.Service, kubeEnvDrift *kubeEnvDriftConfig) (*nodeAnnotator, error) {
 	gce := compute.NewInstancesService(cs)
 
 	// TODO(mikedanese): create a registry for the labels that GKE uses. This was
@@ -89,9 +93,14 @@ func newNodeAnnotator(client clientset.Interface, nodeInformer coreinformers.Nod
 		c:         client,
 		ns:        nodeInformer.Lister(),
 		hasSynced: nodeInformer.Informer().HasSynced,
-		queue: workqueue.NewNamedRateLimitingQueue(workqueue.NewMaxOfRateLimiter(
+		queue: workqueue.NewRateLimitingQueueWithConfig(workqueue.NewMaxOfRateLimiter(
 			workqueue.NewItemExponentialFailureRateLimiter(200*time.Millisecond, 1000*time.Second),
-		), "node-annotator"),
+		), workqueue.RateLimitingQueueConfig{
+			Name:            "node-annotator",
+			MetricsProvider: workqueuemetrics.Provider(),
+		}),
+		kubeEnvDrift: kubeEnvDrift,
+		now:          metav1.Now,
 		getInstance: func(nodeURL string) (*compute.Instance, error) {
 			project, zone, instance, err := parseNodeURL(nodeURL)
 			if err != nil {
@@ -274,11 +283,35 @@ func (na *nodeAnnotator) sync(key string) error {
 		}
 		update = update || modified
 	}
-	if !update {
-		return nil
+	if update {
+		if node, err = na.c.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
 	}
+	return na.syncKubeEnvDrift(node, instance)
+}
 
-	_, err = na.c.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+// syncKubeEnvDrift updates the nodeConditionKubeEnvDrift condition of the
+// node if the drift detection is enabled and the instance has kube-env
+// metadata.
+func (na *nodeAnnotator) syncKubeEnvDrift(node *core.Node, instance *compute.Instance) error {
+	if na.kubeEnvDrift == nil || instance == nil {
+		return nil
+	}
+	drifts, err := kubeEnvDrifts(instance, na.kubeEnvDrift)
+	if err != nil {
+		if err != errNoMetadata {
+			klog.Errorf("Error checking the kube-env drift of node %q: %v", node.Name, err)
+		}
+		return nil
+	}
+	if !setKubeEnvDriftCondition(node, drifts, na.now()) {
+		return nil
+	}
+	if len(drifts) > 0 {
+		klog.Warningf("The kube-env metadata of node %q drifts from the cluster: %v", node.Name, drifts)
+	}
+	_, err = na.c.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
 	return err
 }
 
@@ -359,34 +392,39 @@ func findValue(items []*compute.MetadataItems, key, selfLink string) (string, bo
 	return "", false
 }
 
-func extractNodeTaints(instance *compute.Instance) ([]core.Taint, error) {
-	const kubeEnvKey = "kube-env"
+const kubeEnvKey = "kube-env"
 
+// extractKubeEnv returns the kube-env metadata of the instance, and
+// errNoMetadata if it has none.
+func extractKubeEnv(instance *compute.Instance) (string, error) {
 	if instance.Metadata == nil {
-		return nil, errNoMetadata
+		return "", errNoMetadata
 	}
 
-	var kubeEnv *string
 	for _, item := range instance.Metadata.Items {
 		if item == nil || item.Key != kubeEnvKey {
 			continue
 		}
 		if item.Value == nil {
-			return nil, fmt.Errorf("instance %q had nil %q", instance.SelfLink, kubeEnvKey)
+			return "", fmt.Errorf("instance %q had nil %q", instance.SelfLink, kubeEnvKey)
 		}
-		kubeEnv = item.Value
-		break
+		return *item.Value, nil
 	}
-	if kubeEnv == nil {
-		return nil, errNoMetadata
+	return "", errNoMetadata
+}
+
+func extractNodeTaints(instance *compute.Instance) ([]core.Taint, error) {
+	kubeEnv, err := extractKubeEnv(instance)
+	if err != nil {
+		return nil, err
 	}
-	if len(*kubeEnv) == 0 {
+	if len(kubeEnv) == 0 {
 		klog.Infof("Node taints not found in instance metadata, %s is empty", kubeEnvKey)
 		return nil, nil
 	}
 
 	var taintsEnv string
-	for _, env := range strings.Split(*kubeEnv, ";") {
+	for _, env := range strings.Split(kubeEnv, ";") {
 		if strings.HasPrefix(env, "node_taints") {
 			taintsEnv = env
 			break
@@ -66,6 +66,11 @@ const (
 	authFlowLabelNone = "unknown"
 
 	createdByInstanceMetadataKey = "created-by"
+	// blockAutoApproveInstanceMetadataKey is the instance metadata key that,
+	// when set to true, blocks the auto-approval of the kubelet certificates
+	// of the instance, e.g. to quarantine a compromised node without changing
+	// the cluster RBAC.
+	blockAutoApproveInstanceMetadataKey = "block-kubelet-cert-autoapprove"
 )
 
 var (
@@ -108,7 +113,21 @@ func csrValidators(ctx *controllerContext) []csrValidator {
 
 			preApproveHook: ensureNodeMatchesMetadataOrDelete,
 		},
-		{
+	}
+	if ctx.csrApproverInstanceIdentity != nil {
+		validators = append(validators, csrValidator{
+			name:          "kubelet client certificate with instance identity token and SubjectAccessReview",
+			authFlowLabel: "kubelet_client_instance_identity",
+			recognize:     isNodeClientCertWithInstanceIdentity,
+			validate:      validateInstanceIdentity,
+			permission:    authorization.ResourceAttributes{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "create", Subresource: "nodeclient"},
+			approveMsg:    "Auto approving kubelet client certificate with instance identity token after SubjectAccessReview.",
+
+			preApproveHook: ensureNodeMatchesMetadataOrDelete,
+		})
+	}
+	validators = append(validators,
+		csrValidator{
 			name:          "kubelet server certificate SubjectAccessReview",
 			authFlowLabel: "kubelet_server_self",
 			recognize:     isNodeServerCert,
@@ -116,12 +135,13 @@ func csrValidators(ctx *controllerContext) []csrValidator {
 			permission:    authorization.ResourceAttributes{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "create", Subresource: "selfnodeclient"},
 			approveMsg:    "Auto approving kubelet server certificate after SubjectAccessReview.",
 		},
-	}
+	)
 	if ctx.csrApproverAllowLegacyKubelet {
 		validators = append(validators, csrValidator{
 			name:          "kubelet client certificate SubjectAccessReview",
 			authFlowLabel: "kubelet_client_legacy",
 			recognize:     isLegacyNodeClientCert,
+			validate:      validateLegacyNodeClientCert,
 			permission:    authorization.ResourceAttributes{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "create", Subresource: "nodeclient"},
 			approveMsg:    "Auto approving kubelet client certificate after SubjectAccessReview.",
 
@@ -391,6 +411,10 @@ func validateNodeServerCert(ctx *controllerContext, csr *capi.CertificateSigning
 			}
 			return false, err
 		}
+		if isAutoApproveBlocked(inst) {
+			klog.Infof("deny CSR %q: auto-approval is blocked by the metadata of instance %q", csr.Name, instanceName)
+			return false, nil
+		}
 
 		// Format the Domain-scoped projectID before validating the DNS name, e.g. example.com:my-project-123456789012
 		projectID := ctx.gcpCfg.ProjectID
@@ -476,6 +500,39 @@ func isNodeClientCertWithAttestation(csr *capi.CertificateSigningRequest, x509cr
 	return true
 }
 
+// isAutoApproveBlocked returns true if the metadata of the instance blocks the
+// auto-approval of its kubelet certificates.
+func isAutoApproveBlocked(inst *compute.Instance) bool {
+	blocked, _ := strconv.ParseBool(getInstanceMetadata(inst, blockAutoApproveInstanceMetadataKey))
+	return blocked
+}
+
+// validateLegacyNodeClientCert denies the legacy kubelet client certificates
+// of instances blocking auto-approval. The instance is not required to exist,
+// as legacy certificates are only validated by SubjectAccessReview.
+//
+// The CSRs carrying an instance identity token are matched by the preceding
+// validator, the remaining ones are denied when the token is required.
+func validateLegacyNodeClientCert(ctx *controllerContext, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) (bool, error) {
+	if ctx.csrApproverInstanceIdentity != nil && ctx.csrApproverInstanceIdentity.required {
+		klog.Infof("deny CSR %q: no instance identity token", csr.Name)
+		return false, nil
+	}
+	instanceName := strings.TrimPrefix(x509cr.Subject.CommonName, "system:node:")
+	inst, err := getInstanceByName(ctx, instanceName)
+	if err == errInstanceNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if isAutoApproveBlocked(inst) {
+		klog.Infof("deny CSR %q: auto-approval is blocked by the metadata of instance %q", csr.Name, instanceName)
+		return false, nil
+	}
+	return true, nil
+}
+
 func getInstanceMetadata(inst *compute.Instance, key string) string {
 	if inst == nil || inst.Metadata == nil || inst.Metadata.Items == nil {
 		return ""
@@ -532,6 +589,10 @@ func validateTPMAttestation(ctx *controllerContext, csr *capi.CertificateSigning
 		return false, fmt.Errorf("fetching VM data from GCE API: %v", err)
 	}
 	recordMetric(csrmetrics.OutboundRPCStatusOK)
+	if isAutoApproveBlocked(inst) {
+		klog.Infof("deny CSR %q: auto-approval is blocked by the metadata of VM %q", csr.Name, inst.Name)
+		return false, nil
+	}
 	if ctx.csrApproverVerifyClusterMembership {
 		// get the instance group of this instance from the metadata.
 		// the metadata is user controlled, clusterHasInstance verifies
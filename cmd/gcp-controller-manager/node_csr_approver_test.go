@@ -486,6 +486,48 @@ func TestValidators(t *testing.T) {
 				c.gcpCfg.ProjectID = "p0:p1:p2"
 				b.dns = []string{"i0.z0.c.p0.internal", "i0.c.p1.p2.p0.internal", "i0"}
 			},
+			// Auto-approval blocked by the instance metadata.
+			func(b *csrBuilder, c *controllerContext) {
+				goodCase(b, c)
+				b.requestor = "system:node:blocked"
+				b.dns = []string{"blocked.z0.c.p0.internal", "blocked.c.p0.internal", "blocked"}
+			},
+		}
+		testValidator(t, "bad", cases, fn, false, false)
+	})
+	t.Run("validateLegacyNodeClientCert", func(t *testing.T) {
+		client, srv := fakeGCPAPI(t, nil)
+		defer srv.Close()
+		fn := func(ctx *controllerContext, csr *capi.CertificateSigningRequest, x509cr *x509.CertificateRequest) (bool, error) {
+			cs, err := compute.New(client)
+			if err != nil {
+				t.Fatalf("creating GCE API client: %v", err)
+			}
+			ctx.gcpCfg.Compute = cs
+			return validateLegacyNodeClientCert(ctx, csr, x509cr)
+		}
+		goodCase := func(b *csrBuilder, c *controllerContext) {
+			c.gcpCfg.ProjectID = "p0"
+			c.gcpCfg.Zones = []string{"z1", "z0"}
+			b.requestor = legacyKubeletUsername
+			b.cn = "system:node:i0"
+		}
+		cases := []func(*csrBuilder, *controllerContext){
+			goodCase,
+			// The instance is not required to exist.
+			func(b *csrBuilder, c *controllerContext) {
+				goodCase(b, c)
+				b.cn = "system:node:i99"
+			},
+		}
+		testValidator(t, "good", cases, fn, true, false)
+
+		cases = []func(*csrBuilder, *controllerContext){
+			// Auto-approval blocked by the instance metadata.
+			func(b *csrBuilder, c *controllerContext) {
+				goodCase(b, c)
+				b.cn = "system:node:blocked"
+			},
 		}
 		testValidator(t, "bad", cases, fn, false, false)
 	})
@@ -1120,6 +1162,16 @@ func fakeGCPAPI(t *testing.T, ekPub *rsa.PublicKey) (*http.Client, *httptest.Ser
 					Id: 4,
 				}},
 			})
+		case "/compute/v1/projects/p0/zones/z0/instances/blocked":
+			json.NewEncoder(rw).Encode(compute.Instance{
+				Id:   5,
+				Name: "blocked",
+				Zone: formatInstanceZone("p0", "z0"),
+				Metadata: &compute.Metadata{
+					Items: []*compute.MetadataItems{{Key: blockAutoApproveInstanceMetadataKey, Value: stringPointer("true")}},
+				},
+				NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "1.2.3.4"}},
+			})
 		case "/compute/v1/projects/p0/zones/z0/instances/ds0":
 			json.NewEncoder(rw).Encode(compute.Instance{
 				Id:                1,
@@ -0,0 +1,164 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v0.beta"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// nodeConditionKubeEnvDrift is the Node condition which is true when the
+	// kubelet configuration of the kube-env metadata of the instance drifts
+	// from what the cluster expects, e.g. because of a misconfigured instance
+	// template.
+	nodeConditionKubeEnvDrift core.NodeConditionType = "KubeEnvDrift"
+
+	kubeEnvClusterDNSKey = "cluster_dns"
+	kubeEnvNodeLabelsKey = "node_labels"
+	kubeEnvNodeTaintsKey = "node_taints"
+	kubeEnvDriftReason   = "KubeEnvDrift"
+	kubeEnvNoDriftReason = "KubeEnvConsistent"
+)
+
+// kubeEnvDriftConfig is what the cluster expects from the kube-env metadata of
+// the instances of its nodes. The node_labels of kube-env are always expected
+// to agree with the kube-labels metadata of the instance.
+type kubeEnvDriftConfig struct {
+	// clusterDNS are the expected cluster_dns servers, not checked when
+	// empty.
+	clusterDNS []string
+	// requiredTaints are the taints node_taints must have.
+	requiredTaints []core.Taint
+}
+
+// parseKubeEnv returns the fields of kube-env, formatted as
+// "key1=value1;key2=value2".
+func parseKubeEnv(kubeEnv string) map[string]string {
+	fields := map[string]string{}
+	for _, env := range strings.Split(kubeEnv, ";") {
+		key, value, found := strings.Cut(env, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// kubeEnvDrifts returns the drifts of the kube-env metadata of the instance
+// from config, and errNoMetadata if the instance has no kube-env metadata.
+func kubeEnvDrifts(instance *compute.Instance, config *kubeEnvDriftConfig) ([]string, error) {
+	kubeEnv, err := extractKubeEnv(instance)
+	if err != nil {
+		return nil, err
+	}
+	fields := parseKubeEnv(kubeEnv)
+
+	var drifts []string
+	if len(config.clusterDNS) > 0 {
+		got := sets.NewString()
+		if dns, ok := fields[kubeEnvClusterDNSKey]; ok && dns != "" {
+			got.Insert(strings.Split(dns, ",")...)
+		}
+		if want := sets.NewString(config.clusterDNS...); !got.Equal(want) {
+			drifts = append(drifts, fmt.Sprintf("%s is %q, want %q", kubeEnvClusterDNSKey, strings.Join(got.List(), ","), strings.Join(want.List(), ",")))
+		}
+	}
+
+	if labelsEnv, ok := fields[kubeEnvNodeLabelsKey]; ok && labelsEnv != "" {
+		nodeLabels, err := parseLabels(labelsEnv)
+		if err != nil {
+			drifts = append(drifts, fmt.Sprintf("%s is invalid: %v", kubeEnvNodeLabelsKey, err))
+		} else if kubeLabels, err := extractKubeLabels(instance); err == nil {
+			var keys []string
+			for key := range kubeLabels {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if value, ok := nodeLabels[key]; !ok || value != kubeLabels[key] {
+					drifts = append(drifts, fmt.Sprintf("%s does not set label %s=%s of kube-labels", kubeEnvNodeLabelsKey, key, kubeLabels[key]))
+				}
+			}
+		}
+	}
+
+	var taints []core.Taint
+	if taintsEnv, ok := fields[kubeEnvNodeTaintsKey]; ok && taintsEnv != "" {
+		if taints, err = parseTaints(taintsEnv); err != nil {
+			drifts = append(drifts, fmt.Sprintf("%s is invalid: %v", kubeEnvNodeTaintsKey, err))
+		}
+	}
+	for _, required := range config.requiredTaints {
+		found := false
+		for _, taint := range taints {
+			if taint.MatchTaint(&required) && taint.Value == required.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			drifts = append(drifts, fmt.Sprintf("%s does not have taint %s", kubeEnvNodeTaintsKey, required.ToString()))
+		}
+	}
+	return drifts, nil
+}
+
+// setKubeEnvDriftCondition sets the nodeConditionKubeEnvDrift condition of the
+// node for the drifts, and returns true if it changed.
+func setKubeEnvDriftCondition(node *core.Node, drifts []string, now metav1.Time) bool {
+	condition := core.NodeCondition{
+		Type:    nodeConditionKubeEnvDrift,
+		Status:  core.ConditionFalse,
+		Reason:  kubeEnvNoDriftReason,
+		Message: "The kube-env metadata of the instance is consistent with the cluster",
+	}
+	if len(drifts) > 0 {
+		condition.Status = core.ConditionTrue
+		condition.Reason = kubeEnvDriftReason
+		condition.Message = "The kube-env metadata of the instance drifts from the cluster: " + strings.Join(drifts, "; ")
+	}
+
+	for i := range node.Status.Conditions {
+		existing := &node.Status.Conditions[i]
+		if existing.Type != nodeConditionKubeEnvDrift {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+			return false
+		}
+		condition.LastHeartbeatTime = now
+		condition.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != condition.Status {
+			condition.LastTransitionTime = now
+		}
+		*existing = condition
+		return true
+	}
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+	return true
+}
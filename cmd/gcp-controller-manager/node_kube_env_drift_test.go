@@ -0,0 +1,163 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v0.beta"
+	core "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func instanceWithMetadata(items map[string]string) *compute.Instance {
+	instance := &compute.Instance{Metadata: &compute.Metadata{}}
+	for key, value := range items {
+		value := value
+		instance.Metadata.Items = append(instance.Metadata.Items, &compute.MetadataItems{Key: key, Value: &value})
+	}
+	return instance
+}
+
+func TestKubeEnvDrifts(t *testing.T) {
+	config := &kubeEnvDriftConfig{
+		clusterDNS:     []string{"10.0.0.10"},
+		requiredTaints: []core.Taint{{Key: "dedicated", Value: "gpu", Effect: core.TaintEffectNoSchedule}},
+	}
+	for desc, tc := range map[string]struct {
+		metadata   map[string]string
+		config     *kubeEnvDriftConfig
+		wantDrifts []string
+		wantErr    error
+	}{
+		"no kube-env": {
+			metadata: map[string]string{"kube-labels": "a=1"},
+			config:   config,
+			wantErr:  errNoMetadata,
+		},
+		"consistent": {
+			metadata: map[string]string{
+				"kube-env":    "cluster_dns=10.0.0.10;node_labels=a=1,b=2;node_taints=dedicated=gpu:NoSchedule,k=v:NoExecute",
+				"kube-labels": "a=1",
+			},
+			config: config,
+		},
+		"nothing expected": {
+			metadata: map[string]string{"kube-env": "cluster_dns=10.0.0.53"},
+			config:   &kubeEnvDriftConfig{},
+		},
+		"cluster DNS drift": {
+			metadata:   map[string]string{"kube-env": "cluster_dns=10.0.0.53;node_taints=dedicated=gpu:NoSchedule"},
+			config:     config,
+			wantDrifts: []string{`cluster_dns is "10.0.0.53", want "10.0.0.10"`},
+		},
+		"labels drift from kube-labels": {
+			metadata: map[string]string{
+				"kube-env":    "cluster_dns=10.0.0.10;node_labels=a=2;node_taints=dedicated=gpu:NoSchedule",
+				"kube-labels": "a=1,b=2",
+			},
+			config: config,
+			wantDrifts: []string{
+				"node_labels does not set label a=1 of kube-labels",
+				"node_labels does not set label b=2 of kube-labels",
+			},
+		},
+		"missing required taint": {
+			metadata:   map[string]string{"kube-env": "cluster_dns=10.0.0.10;node_taints=dedicated=cpu:NoSchedule"},
+			config:     config,
+			wantDrifts: []string{"node_taints does not have taint dedicated=gpu:NoSchedule"},
+		},
+		"invalid taints": {
+			metadata:   map[string]string{"kube-env": "cluster_dns=10.0.0.10;node_taints=a=b,c"},
+			config:     &kubeEnvDriftConfig{clusterDNS: config.clusterDNS},
+			wantDrifts: []string{"node_taints is invalid"},
+		},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			drifts, err := kubeEnvDrifts(instanceWithMetadata(tc.metadata), tc.config)
+			if err != tc.wantErr {
+				t.Fatalf("kubeEnvDrifts() = %v, want %v", err, tc.wantErr)
+			}
+			if len(drifts) != len(tc.wantDrifts) {
+				t.Fatalf("kubeEnvDrifts() = %q, want %q", drifts, tc.wantDrifts)
+			}
+			for i := range drifts {
+				if !strings.HasPrefix(drifts[i], tc.wantDrifts[i]) {
+					t.Errorf("kubeEnvDrifts() = %q, want %q", drifts, tc.wantDrifts)
+				}
+			}
+		})
+	}
+}
+
+func TestSetKubeEnvDriftCondition(t *testing.T) {
+	t0 := v1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	t1 := v1.NewTime(t0.Add(time.Hour))
+	node := &core.Node{}
+
+	if !setKubeEnvDriftCondition(node, nil, t0) {
+		t.Fatalf("setKubeEnvDriftCondition() did not add the condition")
+	}
+	if setKubeEnvDriftCondition(node, nil, t1) {
+		t.Errorf("setKubeEnvDriftCondition() changed the condition without drifts")
+	}
+	if !setKubeEnvDriftCondition(node, []string{"drift"}, t1) {
+		t.Fatalf("setKubeEnvDriftCondition() did not report the drift")
+	}
+	want := []core.NodeCondition{{
+		Type:               nodeConditionKubeEnvDrift,
+		Status:             core.ConditionTrue,
+		Reason:             kubeEnvDriftReason,
+		Message:            "The kube-env metadata of the instance drifts from the cluster: drift",
+		LastHeartbeatTime:  t1,
+		LastTransitionTime: t1,
+	}}
+	if !reflect.DeepEqual(node.Status.Conditions, want) {
+		t.Errorf("got conditions %+v, want %+v", node.Status.Conditions, want)
+	}
+}
+
+func TestNodeAnnotatorSyncKubeEnvDrift(t *testing.T) {
+	node := &core.Node{ObjectMeta: v1.ObjectMeta{Name: "test-node"}}
+	c := fake.NewSimpleClientset(node)
+	instance := instanceWithMetadata(map[string]string{"kube-env": "cluster_dns=10.0.0.53"})
+	na := &nodeAnnotator{
+		c:            c,
+		ns:           fakeNodeLister{node: node.DeepCopy()},
+		getInstance:  func(nodeURL string) (*compute.Instance, error) { return instance, nil },
+		kubeEnvDrift: &kubeEnvDriftConfig{clusterDNS: []string{"10.0.0.10"}},
+		now:          v1.Now,
+		queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	if err := na.sync("test-node"); err != nil {
+		t.Fatalf("sync() = %v", err)
+	}
+
+	got, err := c.CoreV1().Nodes().Get(context.TODO(), "test-node", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get node: %v", err)
+	}
+	if len(got.Status.Conditions) != 1 || got.Status.Conditions[0].Type != nodeConditionKubeEnvDrift || got.Status.Conditions[0].Status != core.ConditionTrue {
+		t.Errorf("got conditions %+v, want a true %s condition", got.Status.Conditions, nodeConditionKubeEnvDrift)
+	}
+}
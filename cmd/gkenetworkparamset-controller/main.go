@@ -0,0 +1,262 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The GKENetworkParamSet controller validates the GKENetworkParamSets and the
+// Networks of the multi-network clusters. It runs standalone, for platforms
+// which do not need the other controllers of the cloud-controller-manager.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-gcp/cmd/gcp-controller-manager/healthz"
+	networkclientset "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned"
+	networkinformers "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions"
+	gkenetworkparamsetcontroller "k8s.io/cloud-provider-gcp/pkg/controller/gkenetworkparamset"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	componentbaseconfig "k8s.io/component-base/config"
+	"k8s.io/component-base/config/options"
+	"k8s.io/component-base/logs"
+	"k8s.io/component-base/metrics/legacyregistry"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	_ "k8s.io/component-base/metrics/prometheus/workqueue" // register the workqueue metrics
+	"k8s.io/component-base/version/verflag"
+	"k8s.io/klog/v2"
+)
+
+const (
+	componentName = "gkenetworkparamset-controller"
+
+	// jsonContentType is required to serialize the GKENetworkParamSets.
+	jsonContentType = "application/json"
+)
+
+var (
+	port            = pflag.Int("port", 10270, "Port to serve status endpoints on (such as /healthz and /metrics).")
+	kubeconfig      = pflag.String("kubeconfig", "", "Path to kubeconfig file with authorization and master location information.")
+	cloudConfig     = pflag.String("cloud-config", "", "Path to the GCE provider config file, e.g. /etc/gce.conf.")
+	clusterCIDR     = pflag.String("cluster-cidr", "", "CIDRs of the Pods of the cluster, comma separated, at most one per IP family. The IPv4 CIDR is the CIDR of the default GKENetworkParamSet, which is not populated without it.")
+	kubeconfigQPS   = pflag.Float32("kubeconfig-qps", 20, "QPS to use while talking with kube-apiserver.")
+	kubeconfigBurst = pflag.Int("kubeconfig-burst", 30, "Burst to use while talking with kube-apiserver.")
+
+	removeStalePodRangeLabels = pflag.Bool("remove-stale-pod-range-labels", false, "Remove the Pod range labels of the nodes referencing the Pod ranges of the deleted GKENetworkParamSets, instead of only reporting them with events.")
+)
+
+func main() {
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+
+	logs.AddFlags(pflag.CommandLine)
+
+	leConfig := &componentbaseconfig.LeaderElectionConfiguration{
+		LeaderElect:       true,
+		LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
+		RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
+		RetryPeriod:       metav1.Duration{Duration: 2 * time.Second},
+		ResourceLock:      resourcelock.LeasesResourceLock,
+		ResourceName:      componentName,
+		ResourceNamespace: "kube-system",
+	}
+	options.BindLeaderElectionFlags(leConfig, pflag.CommandLine)
+
+	pflag.Parse()
+	verflag.PrintAndExitIfRequested()
+
+	// InitLogs should be called after parsing flags.
+	logs.InitLogs()
+
+	// The cluster CIDRs are only needed to populate the default
+	// GKENetworkParamSet.
+	clusterCIDRs := []*net.IPNet{}
+	if *clusterCIDR != "" {
+		var err error
+		clusterCIDRs, err = gkenetworkparamsetcontroller.ParseClusterCIDRs(*clusterCIDR)
+		if err != nil {
+			klog.Exitf("invalid --cluster-cidr %q: %v", *clusterCIDR, err)
+		}
+	}
+	kubeConfig, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		klog.Exitf("failed loading kubeconfig: %v", err)
+	}
+	kubeConfig.QPS = *kubeconfigQPS
+	kubeConfig.Burst = *kubeconfigBurst
+
+	// The controller only calls the compute API, the cloud provider is not
+	// initialized with a kube client, which would start its load balancer
+	// routines.
+	cloud, err := cloudprovider.InitCloudProvider(gce.ProviderName, *cloudConfig)
+	if err != nil {
+		klog.Exitf("failed initializing the GCE cloud provider: %v", err)
+	}
+	gceCloud, ok := cloud.(*gce.Cloud)
+	if !ok {
+		klog.Exitf("unexpected cloud provider %T", cloud)
+	}
+
+	controllersmetrics.Register()
+	hz := healthz.NewHandler()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", legacyregistry.Handler())
+	mux.Handle("/healthz", hz)
+	go func() {
+		klog.Exit(http.ListenAndServe(fmt.Sprintf(":%d", *port), mux))
+	}()
+
+	if err := run(context.Background(), kubeConfig, gceCloud, clusterCIDRs, *leConfig, hz); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the controller. This should never exit.
+func run(ctx context.Context, kubeConfig *restclient.Config, gceCloud *gce.Cloud, clusterCIDRs []*net.IPNet, leConfig componentbaseconfig.LeaderElectionConfiguration, hz *healthz.Handler) error {
+	client, err := clientset.NewForConfig(restclient.AddUserAgent(kubeConfig, componentName))
+	if err != nil {
+		return err
+	}
+	networkConfig := restclient.CopyConfig(kubeConfig)
+	networkConfig.ContentType = jsonContentType
+	networkClient, err := networkclientset.NewForConfig(networkConfig)
+	if err != nil {
+		return err
+	}
+
+	sharedInformers := informers.NewSharedInformerFactory(client, 12*time.Hour)
+	nwInfFactory := networkinformers.NewSharedInformerFactory(networkClient, 30*time.Second)
+	controller := gkenetworkparamsetcontroller.NewGKENetworkParamSetController(
+		client,
+		sharedInformers.Core().V1().Nodes(),
+		networkClient,
+		nwInfFactory.Networking().V1().GKENetworkParamSets(),
+		nwInfFactory.Networking().V1().Networks(),
+		gceCloud,
+		nwInfFactory,
+		clusterCIDRs,
+		*removeStalePodRangeLabels,
+	)
+	hz.Checks["shared informers"] = informersCheck(sharedInformers)
+
+	startController := func(ctx context.Context) {
+		sharedInformers.Start(ctx.Done())
+		controller.Run(1, ctx.Done(), controllersmetrics.NewControllerManagerMetrics(componentName))
+	}
+
+	if !leConfig.LeaderElect {
+		startController(ctx)
+		return fmt.Errorf("should never reach this point")
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	leaderElectionClient, err := clientset.NewForConfig(restclient.AddUserAgent(kubeConfig, "leader-election"))
+	if err != nil {
+		return err
+	}
+	leaderElectionConfig, err := makeLeaderElectionConfig(leConfig, leaderElectionClient, eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{
+		Component: componentName + "-leader-election",
+	}))
+	if err != nil {
+		return err
+	}
+	leaderElectionConfig.Callbacks = leaderelection.LeaderCallbacks{
+		OnStartedLeading: startController,
+		OnStoppedLeading: func() {
+			klog.Fatalf("lost leader election, exiting")
+		},
+	}
+
+	leaderElector, err := leaderelection.NewLeaderElector(*leaderElectionConfig)
+	if err != nil {
+		return err
+	}
+	hz.Checks["leader election"] = leaderElectorCheck(leaderElector)
+	leaderElector.Run(ctx)
+	return fmt.Errorf("should never reach this point")
+}
+
+func makeLeaderElectionConfig(config componentbaseconfig.LeaderElectionConfiguration, client clientset.Interface, recorder record.EventRecorder) (*leaderelection.LeaderElectionConfig, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get hostname: %v", err)
+	}
+
+	rl, err := resourcelock.New(
+		config.ResourceLock,
+		config.ResourceNamespace,
+		config.ResourceName,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      hostname,
+			EventRecorder: recorder,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create resource lock: %v", err)
+	}
+	return &leaderelection.LeaderElectionConfig{
+		Lock:          rl,
+		LeaseDuration: config.LeaseDuration.Duration,
+		RenewDeadline: config.RenewDeadline.Duration,
+		RetryPeriod:   config.RetryPeriod.Duration,
+		Name:          config.ResourceName,
+	}, nil
+}
+
+func informersCheck(s informers.SharedInformerFactory) healthz.Check {
+	return func(ctx context.Context) error {
+		res := s.WaitForCacheSync(ctx.Done())
+		var notSynced []string
+		for t, ok := range res {
+			if !ok {
+				notSynced = append(notSynced, t.String())
+			}
+		}
+		if len(notSynced) > 0 {
+			return fmt.Errorf("cache not synced for watchers: %q", notSynced)
+		}
+		return nil
+	}
+}
+
+func leaderElectorCheck(le *leaderelection.LeaderElector) healthz.Check {
+	return func(_ context.Context) error {
+		// 10s is lease expiry threshold, not a timeout for le.Check.
+		if err := le.Check(10 * time.Second); err != nil {
+			return fmt.Errorf("leader election unhealthy: %v", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultGCPLoadBalancerConfigName is the name of the GCPLoadBalancerConfig
+// applied by the cloud provider. Objects with other names are ignored.
+const DefaultGCPLoadBalancerConfigName = "default"
+
+// NetworkTier is the network tier of a load balancer.
+type NetworkTier string
+
+const (
+	// NetworkTierPremium is the Premium network tier.
+	NetworkTierPremium NetworkTier = "Premium"
+	// NetworkTierStandard is the Standard network tier.
+	NetworkTierStandard NetworkTier = "Standard"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +kubebuilder:storageversion
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=gcplbconfig,scope=Cluster
+
+// GCPLoadBalancerConfig holds the cluster-wide defaults of the GCP load
+// balancers created for Services of type LoadBalancer. Each setting applies to
+// all Services which do not override it with the corresponding annotation.
+// Changing NetworkTier or InternalLoadBalancerSubnet affects existing Services
+// the same way as changing the annotation would, see HealthCheck for the health
+// checks.
+type GCPLoadBalancerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the desired configuration of the load balancers.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	Spec GCPLoadBalancerConfigSpec `json:"spec,omitempty"`
+}
+
+// GCPLoadBalancerConfigSpec provides the specification of a GCPLoadBalancerConfig.
+type GCPLoadBalancerConfigSpec struct {
+	// NetworkTier is the network tier of external load balancers. It is
+	// overridden by the cloud.google.com/network-tier annotation. If not
+	// specified, the Premium tier is used.
+	// +optional
+	// +kubebuilder:validation:Enum=Premium;Standard
+	NetworkTier NetworkTier `json:"networkTier,omitempty"`
+
+	// InternalLoadBalancerSubnet is the name of the subnet internal load
+	// balancer IPs are allocated from. It is overridden by the
+	// networking.gke.io/internal-load-balancer-subnet annotation. If not
+	// specified, the cluster subnet is used.
+	// +optional
+	InternalLoadBalancerSubnet string `json:"internalLoadBalancerSubnet,omitempty"`
+
+	// HealthCheck tunes the health checks of the load balancers created
+	// after it is set. Existing health checks are only updated to its larger
+	// values, use the health check annotations of a Service to lower them.
+	// +optional
+	HealthCheck *HealthCheckConfig `json:"healthCheck,omitempty"`
+}
+
+// HealthCheckConfig tunes the health checks of the load balancers. Unset
+// fields keep the provider defaults. A value lower than that of an existing
+// health check does not update it.
+type HealthCheckConfig struct {
+	// CheckIntervalSeconds is how often to send a health check.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	CheckIntervalSeconds *int64 `json:"checkIntervalSeconds,omitempty"`
+
+	// TimeoutSeconds is how long to wait before claiming failure. It must not
+	// be greater than CheckIntervalSeconds.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=300
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+
+	// HealthyThreshold is the number of consecutive successes required to
+	// mark a node healthy.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	HealthyThreshold *int64 `json:"healthyThreshold,omitempty"`
+
+	// UnhealthyThreshold is the number of consecutive failures required to
+	// mark a node unhealthy.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	UnhealthyThreshold *int64 `json:"unhealthyThreshold,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// GCPLoadBalancerConfigList contains a list of GCPLoadBalancerConfig resources.
+type GCPLoadBalancerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	// Items is a list of GCPLoadBalancerConfig.
+	Items []GCPLoadBalancerConfig `json:"items"`
+}
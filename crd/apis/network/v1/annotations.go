@@ -48,6 +48,8 @@ const (
 	AutoGenAnnotationValTrue = "true"
 	// NorthInterfacesAnnotationKey is the annotation key used to hold interfaces data per node.
 	NorthInterfacesAnnotationKey = "networking.gke.io/north-interfaces"
+	// PodRangesAnnotationKey is the annotation key used to hold the Pod CIDR of each secondary range per node.
+	PodRangesAnnotationKey = "networking.gke.io/pod-ranges"
 	// NICInfoAnnotationKey specifies the mapping between the fist IP addresse and the PCI BDF number on the node.
 	NICInfoAnnotationKey = "networking.gke.io/nic-info"
 )
@@ -118,6 +120,10 @@ type MultiNetworkAnnotation []NodeNetwork
 // +kubebuilder:object:generate:=false
 type NorthInterfacesAnnotation []NorthInterface
 
+// PodRangesAnnotation is the value of pod-ranges annotation.
+// +kubebuilder:object:generate:=false
+type PodRangesAnnotation []NodePodRange
+
 // NodeNetworkStatus specifies the status of a network.
 // +kubebuilder:object:generate:=false
 type NodeNetworkStatus struct {
@@ -153,6 +159,17 @@ type NodeNetwork struct {
 	Scope string `json:"scope"`
 }
 
+// NodePodRange specifies the Pod CIDR allocated to a node from a secondary range of a network.
+// +kubebuilder:object:generate:=false
+type NodePodRange struct {
+	// Network is the name of the network of the secondary range.
+	Network string `json:"network"`
+	// RangeName is the name of the secondary range in the subnet.
+	RangeName string `json:"rangeName"`
+	// Cidr is the Pod CIDR allocated to the node from the secondary range.
+	Cidr string `json:"cidr"`
+}
+
 // NorthInterface specifies interface data on a node.
 // +kubebuilder:object:generate:=false
 type NorthInterface struct {
@@ -190,6 +207,13 @@ func ParseNorthInterfacesAnnotation(annotation string) (NorthInterfacesAnnotatio
 	return *ret, err
 }
 
+// ParsePodRangesAnnotation parses given annotation to PodRangesAnnotation.
+func ParsePodRangesAnnotation(annotation string) (PodRangesAnnotation, error) {
+	ret := &PodRangesAnnotation{}
+	err := json.Unmarshal([]byte(annotation), ret)
+	return *ret, err
+}
+
 // ParseNICInfoAnnotation parses given annotation to NicInfoAnnotation
 func ParseNICInfoAnnotation(annotation string) (NICInfoAnnotation, error) {
 	ret := &NICInfoAnnotation{}
@@ -207,6 +231,11 @@ func MarshalNorthInterfacesAnnotation(a NorthInterfacesAnnotation) (string, erro
 	return MarshalAnnotation(a)
 }
 
+// MarshalPodRangesAnnotation marshals a PodRangesAnnotation into string.
+func MarshalPodRangesAnnotation(a PodRangesAnnotation) (string, error) {
+	return MarshalAnnotation(a)
+}
+
 // MarshalNICInfoAnnotation marshals a NICInfoAnnotation into string.
 func MarshalNICInfoAnnotation(a NICInfoAnnotation) (string, error) {
 	return MarshalAnnotation(a)
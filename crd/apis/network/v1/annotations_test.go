@@ -220,6 +220,54 @@ func TestParseNorthInterfacesAnnotation(t *testing.T) {
 	}
 }
 
+func TestParsePodRangesAnnotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    PodRangesAnnotation
+		expected string
+	}{
+		{
+			name:     "nil",
+			input:    nil,
+			expected: "null",
+		},
+		{
+			name:     "empty list",
+			input:    PodRangesAnnotation{},
+			expected: "[]",
+		},
+		{
+			name: "list with items",
+			input: PodRangesAnnotation{
+				{Network: "network-a", RangeName: "range-a", Cidr: "10.1.0.0/24"},
+				{Network: "network-a", RangeName: "range-b", Cidr: "10.2.0.0/24"},
+			},
+			expected: `[{"network":"network-a","rangeName":"range-a","cidr":"10.1.0.0/24"},{"network":"network-a","rangeName":"range-b","cidr":"10.2.0.0/24"}]`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			marshalled, err := MarshalPodRangesAnnotation(tc.input)
+			if err != nil {
+				t.Fatalf("MarshalPodRangesAnnotation(%+v) failed with error: %v", tc.input, err)
+			}
+			if marshalled != tc.expected {
+				t.Fatalf("MarshalPodRangesAnnotation(%+v) returns %q but want %q", tc.input, marshalled, tc.expected)
+			}
+
+			parsed, err := ParsePodRangesAnnotation(marshalled)
+			if err != nil {
+				t.Fatalf("ParsePodRangesAnnotation(%s) failed with error: %v", marshalled, err)
+			}
+
+			if diff := cmp.Diff(parsed, tc.input); diff != "" {
+				t.Fatalf("ParsePodRangesAnnotation(%s) returns diff: (-got +want): %s", marshalled, diff)
+			}
+		})
+	}
+}
+
 func TestParseNICInfoAnnotation(t *testing.T) {
 	tests := []struct {
 		name     string
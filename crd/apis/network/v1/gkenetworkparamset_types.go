@@ -41,8 +41,9 @@ type SecondaryRanges struct {
 // GKENetworkParamSetSpec contains the specifications for network object
 type GKENetworkParamSetSpec struct {
 	// VPC speficies the VPC to which the network belongs.
-	// +required
-	VPC string `json:"vpc"`
+	// Defaults to the VPC of the cluster, in the network project of the cluster.
+	// +optional
+	VPC string `json:"vpc,omitempty"`
 
 	// VPCSubnet is the path of the VPC subnet
 	// +required
@@ -58,6 +59,13 @@ type GKENetworkParamSetSpec struct {
 	// This field is required and valid only for L3 typed network
 	// +optional
 	PodIPv4Ranges *SecondaryRanges `json:"podIPv4Ranges,omitempty"`
+
+	// PodIPv6Ranges specify the IPv6 CIDR blocks, within the internal IPv6 range
+	// of the VPC subnet, used to allocate pod IPv6 addresses for the network.
+	// This field is valid only along with PodIPv4Ranges, and required for dual-stack
+	// L3 typed network
+	// +optional
+	PodIPv6Ranges *NetworkRanges `json:"podIPv6Ranges,omitempty"`
 }
 
 // NetworkRanges represents ranges of network addresses.
@@ -90,6 +98,16 @@ const (
 	SecondaryRangeAndDeviceModeUnspecified GKENetworkParamSetConditionReason = "SecondaryRangeAndDeviceModeUnspecified"
 	// SecondaryRangeNotFound indicates that the specified secondary range was not found.
 	SecondaryRangeNotFound GKENetworkParamSetConditionReason = "SecondaryRangeNotFound"
+	// SecondaryRangeInPeeredVPC indicates that the specified secondary range belongs to a subnet of a VPC peered with the specified VPC.
+	SecondaryRangeInPeeredVPC GKENetworkParamSetConditionReason = "SecondaryRangeInPeeredVPC"
+	// PodIPv6RangesWithoutIPv4Ranges indicates that IPv6 ranges were specified without IPv4 secondary ranges.
+	PodIPv6RangesWithoutIPv4Ranges GKENetworkParamSetConditionReason = "PodIPv6RangesWithoutIPv4Ranges"
+	// SubnetInternalIPv6RangeNotFound indicates that IPv6 ranges were specified but the subnet has no internal IPv6 range.
+	SubnetInternalIPv6RangeNotFound GKENetworkParamSetConditionReason = "SubnetInternalIPv6RangeNotFound"
+	// PodIPv6RangeNotInSubnet indicates that a specified IPv6 range is not within the internal IPv6 range of the subnet.
+	PodIPv6RangeNotInSubnet GKENetworkParamSetConditionReason = "PodIPv6RangeNotInSubnet"
+	// SecondaryRangePeeringLookupFailed indicates that the specified secondary range was not found, and the subnets of a VPC peered with the specified VPC could not be looked up.
+	SecondaryRangePeeringLookupFailed GKENetworkParamSetConditionReason = "SecondaryRangePeeringLookupFailed"
 	// DeviceModeCantBeUsedWithSecondaryRange indicates that device mode was used with a secondary range.
 	DeviceModeCantBeUsedWithSecondaryRange GKENetworkParamSetConditionReason = "DeviceModeCantBeUsedWithSecondaryRange"
 	// DeviceModeVPCAlreadyInUse indicates that the VPC is already in use by another GKENetworkParamSet resource.
@@ -112,9 +130,15 @@ const (
 	// L3SecondaryMissing indicates that the L3 type Network resource is
 	// referencing a GKENetworkParamSet with secondary range unspecified.
 	L3SecondaryMissing GNPNetworkParamsReadyConditionReason = "L3SecondaryMissing"
+	// L3IPv6RangesMissing indicates that the dual-stack L3 type Network resource is
+	// referencing a GKENetworkParamSet with IPv6 ranges unspecified.
+	L3IPv6RangesMissing GNPNetworkParamsReadyConditionReason = "L3IPv6RangesMissing"
 	// DeviceModeMissing indicates that the Device type Network resource is
 	// referencing a GKENetworkParamSet with device mode unspecified.
 	DeviceModeMissing GNPNetworkParamsReadyConditionReason = "DeviceModeMissing"
+	// DeviceModeMTUUnsupported indicates that the Device type Network resource is
+	// referencing a GKENetworkParamSet whose VPC MTU is not supported by its device mode.
+	DeviceModeMTUUnsupported GNPNetworkParamsReadyConditionReason = "DeviceModeMTUUnsupported"
 	// GNPDeleted indicates that the referenced GNP resource was deleted
 	GNPDeleted GNPNetworkParamsReadyConditionReason = "GNPDeleted"
 	// GNPParamsReady indicates that the referenced GNP resource
@@ -131,6 +155,10 @@ type GKENetworkParamSetStatus struct {
 	// +optional
 	PodCIDRs *NetworkRanges `json:"podCIDRs,omitempty"`
 
+	// PodIPv6CIDRs specifies the IPv6 CIDRs from which IPs will be used for Pod interfaces
+	// +optional
+	PodIPv6CIDRs *NetworkRanges `json:"podIPv6CIDRs,omitempty"`
+
 	// Conditions is a field representing the current conditions of the GKENetworkParamSet.
 	//
 	// Known condition types are:
@@ -147,6 +175,10 @@ type GKENetworkParamSetStatus struct {
 	// NetworkName specifies which Network object is currently referencing this GKENetworkParamSet
 	// +optional
 	NetworkName string `json:"networkName"`
+
+	// NodeCount is the number of Nodes which currently have Pod ranges from this GKENetworkParamSet
+	// +optional
+	NodeCount int32 `json:"nodeCount"`
 }
 
 // +genclient
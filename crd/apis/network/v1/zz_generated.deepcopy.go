@@ -117,6 +117,11 @@ func (in *GKENetworkParamSetSpec) DeepCopyInto(out *GKENetworkParamSetSpec) {
 		*out = new(SecondaryRanges)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodIPv6Ranges != nil {
+		in, out := &in.PodIPv6Ranges, &out.PodIPv6Ranges
+		*out = new(NetworkRanges)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GKENetworkParamSetSpec.
@@ -137,6 +142,11 @@ func (in *GKENetworkParamSetStatus) DeepCopyInto(out *GKENetworkParamSetStatus)
 		*out = new(NetworkRanges)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodIPv6CIDRs != nil {
+		in, out := &in.PodIPv6CIDRs, &out.PodIPv6CIDRs
+		*out = new(NetworkRanges)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -38,8 +38,9 @@ type SecondaryRanges struct {
 // GKENetworkParamSetSpec contains the specifications for network object
 type GKENetworkParamSetSpec struct {
 	// VPC speficies the VPC to which the network belongs.
-	// +required
-	VPC string `json:"vpc"`
+	// Defaults to the VPC of the cluster, in the network project of the cluster.
+	// +optional
+	VPC string `json:"vpc,omitempty"`
 
 	// VPCSubnet is the path of the VPC subnet
 	// +required
@@ -51,6 +51,13 @@ echo "Generating firewall CRD clientset"
   "gcpfirewall:v1beta1,v1" \
   --go-header-file "${SCRIPT_ROOT}/hack/boilerplate.go.txt"
 
+echo "Generating load balancer config CRD clientset"
+"${SCRIPT_ROOT}/hack/generate-groups.sh" all \
+  k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig \
+  k8s.io/cloud-provider-gcp/crd/apis \
+  "gcploadbalancerconfig:v1" \
+  --go-header-file "${SCRIPT_ROOT}/hack/boilerplate.go.txt"
+
 
 echo "Generating CRD artifacts"
 go run sigs.k8s.io/controller-tools/cmd/controller-gen crd \
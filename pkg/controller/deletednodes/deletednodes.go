@@ -0,0 +1,178 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deletednodes queues the deleted nodes for the controllers cleaning
+// up after them.
+package deletednodes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
+	"k8s.io/klog/v2"
+)
+
+// maxRetries is the number of times the sync of a deleted node is retried
+// before it is dropped.
+const maxRetries = 5
+
+// SyncFunc cleans up after a deleted node, it is retried on error.
+type SyncFunc func(ctx context.Context, node *v1.Node) error
+
+// Queue queues the nodes deleted from a node informer, and syncs them until
+// their sync succeeds, unless a node of the same name registers again.
+//
+// The nodes deleted while the controller is not running are not synced.
+type Queue struct {
+	name       string
+	nodeLister corelisters.NodeLister
+	sync       SyncFunc
+	queue      workqueue.RateLimitingInterface
+
+	lock sync.Mutex
+	// nodes are the deleted nodes pending a sync.
+	nodes map[string]*v1.Node
+}
+
+// NewQueue returns a queue of the nodes deleted from the node informer, synced
+// by sync. The name is the name of the workqueue.
+func NewQueue(name string, nodeInformer coreinformers.NodeInformer, sync SyncFunc) *Queue {
+	q := &Queue{
+		name:       name,
+		nodeLister: nodeInformer.Lister(),
+		sync:       sync,
+		queue:      workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: name}),
+		nodes:      map[string]*v1.Node{},
+	}
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: q.Enqueue,
+	})
+	return q
+}
+
+// Enqueue queues a deleted node.
+func (q *Queue) Enqueue(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if node, ok = tombstone.Obj.(*v1.Node); !ok {
+			return
+		}
+	}
+	q.lock.Lock()
+	q.nodes[node.Name] = node
+	q.lock.Unlock()
+	q.queue.Add(node.Name)
+}
+
+// Len returns the number of deleted nodes pending a sync.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.nodes)
+}
+
+// Run starts the workers syncing the deleted nodes, until the context is
+// done. The node informer must have synced.
+func (q *Queue) Run(ctx context.Context, numWorkers int) {
+	for i := 0; i < numWorkers; i++ {
+		go wait.UntilWithContext(ctx, q.runWorker, time.Second)
+	}
+	go func() {
+		<-ctx.Done()
+		q.queue.ShutDown()
+	}()
+}
+
+func (q *Queue) runWorker(ctx context.Context) {
+	for q.processNextItem(ctx) {
+	}
+}
+
+func (q *Queue) processNextItem(ctx context.Context) bool {
+	key, quit := q.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer q.queue.Done(key)
+
+	err := q.Sync(ctx, key.(string))
+	q.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (q *Queue) handleErr(err error, key interface{}) {
+	if err == nil {
+		q.queue.Forget(key)
+		return
+	}
+
+	if q.queue.NumRequeues(key) < maxRetries {
+		klog.Warningf("%s: error while syncing deleted node %v, retrying: %v", q.name, key, err)
+		q.queue.AddRateLimited(key)
+		return
+	}
+
+	q.queue.Forget(key)
+	q.forget(key.(string))
+	utilruntime.HandleError(err)
+	klog.Errorf("%s: dropping node %q out of the queue: %v", q.name, key, err)
+	controllermetrics.WorkqueueDroppedObjects.WithLabelValues(q.name).Inc()
+}
+
+func (q *Queue) forget(name string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.nodes, name)
+}
+
+// Sync syncs the deleted node of the name, unless a node of the same name
+// registered again since.
+func (q *Queue) Sync(ctx context.Context, name string) error {
+	q.lock.Lock()
+	node := q.nodes[name]
+	q.lock.Unlock()
+	if node == nil {
+		return nil
+	}
+	if _, err := q.nodeLister.Get(name); err == nil {
+		klog.V(2).Infof("%s: node %q was recreated, skipping its deletion", q.name, name)
+		q.forget(name)
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	if err := q.sync(ctx, node); err != nil {
+		return err
+	}
+	q.forget(name)
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deletednodes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestQueueSync(t *testing.T) {
+	ctx := context.Background()
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0*time.Second)
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	var synced []string
+	failing := true
+	q := NewQueue("test", nodeInformer, func(ctx context.Context, node *v1.Node) error {
+		if node.Name == "failing" && failing {
+			return errors.New("injected error")
+		}
+		synced = append(synced, node.Name)
+		return nil
+	})
+
+	recreated := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "recreated"}}
+	if err := nodeInformer.Informer().GetStore().Add(recreated); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	for _, obj := range []interface{}{
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "deleted"}},
+		cache.DeletedFinalStateUnknown{Key: "tombstone", Obj: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "tombstone"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "failing"}},
+		recreated,
+	} {
+		q.Enqueue(obj)
+	}
+	if err := q.Sync(ctx, "unknown"); err != nil {
+		t.Errorf("Sync(unknown) = %v, want nil", err)
+	}
+	for _, name := range []string{"deleted", "tombstone", "recreated"} {
+		if err := q.Sync(ctx, name); err != nil {
+			t.Errorf("Sync(%q) = %v, want nil", name, err)
+		}
+	}
+	if err := q.Sync(ctx, "failing"); err == nil {
+		t.Errorf("Sync(failing) = nil, want the error of the sync")
+	}
+	if n := q.Len(); n != 1 {
+		t.Errorf("Len() = %d after the failed sync, want 1", n)
+	}
+
+	// The failed sync is retried.
+	failing = false
+	if err := q.Sync(ctx, "failing"); err != nil {
+		t.Errorf("Sync(failing) = %v, want nil", err)
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+	want := []string{"deleted", "tombstone", "failing"}
+	if len(synced) != len(want) {
+		t.Fatalf("Synced nodes %v, want %v", synced, want)
+	}
+	for i := range want {
+		if synced[i] != want[i] {
+			t.Errorf("Synced nodes %v, want %v", synced, want)
+			break
+		}
+	}
+}
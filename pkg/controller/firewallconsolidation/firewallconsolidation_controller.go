@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewallconsolidation
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "firewallconsolidation"
+
+	// syncKey is the single key of the queue, all the consolidated firewall
+	// rules are synced together from the Services.
+	syncKey = "firewalls"
+)
+
+// Controller programs firewall rules shared by the external load balancers,
+// instead of a firewall rule per load balancer, so that clusters with many
+// LoadBalancer Services stay below the firewall rule quota of the network.
+// The load balancers with the same source ranges and ports share a rule,
+// each keeps its own rule until then.
+type Controller struct {
+	serviceLister         corelisters.ServiceLister
+	serviceInformerSynced cache.InformerSynced
+	nodeLister            corelisters.NodeLister
+	nodeInformerSynced    cache.InformerSynced
+	gceCloud              *gce.Cloud
+	queue                 workqueue.RateLimitingInterface
+}
+
+// NewFirewallConsolidationController returns a new firewall consolidation
+// controller, and enables the consolidation of the load balancer firewalls.
+func NewFirewallConsolidationController(
+	serviceInformer coreinformers.ServiceInformer,
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+) *Controller {
+	c := &Controller{
+		serviceLister:         serviceInformer.Lister(),
+		serviceInformerSynced: serviceInformer.Informer().HasSynced,
+		nodeLister:            nodeInformer.Lister(),
+		nodeInformerSynced:    nodeInformer.Informer().HasSynced,
+		gceCloud:              gceCloud,
+		queue:                 workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
+	}
+	gceCloud.EnableFirewallConsolidation()
+
+	enqueue := func(interface{}) { c.queue.Add(syncKey) }
+	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			enqueue(new)
+		},
+		DeleteFunc: enqueue,
+	})
+	// The target tags of the rules only depend on the set of nodes.
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		DeleteFunc: enqueue,
+	})
+	return c
+}
+
+// Run starts an asynchronous loop that syncs the consolidated firewall rules.
+func (c *Controller) Run(stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting firewallconsolidation controller")
+	defer klog.Infof("Shutting down firewallconsolidation controller")
+	controllerManagerMetrics.ControllerStarted("firewallconsolidation")
+	defer controllerManagerMetrics.ControllerStopped("firewallconsolidation")
+
+	if !cache.WaitForNamedCacheSync("firewallconsolidation", stopCh, c.serviceInformerSynced, c.nodeInformerSynced) {
+		return
+	}
+
+	// The rules are synced by a single worker, as they depend on all the
+	// Services.
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	<-stopCh
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.queue.Done(key)
+
+	err := c.sync(ctx)
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+// The key is never dropped, as it covers all the rules.
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	klog.Warningf("Error while syncing the consolidated firewall rules, retrying: %v", err)
+	c.queue.AddRateLimited(key)
+}
+
+func (c *Controller) sync(ctx context.Context) error {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	return c.gceCloud.EnsureConsolidatedFirewalls(ctx, services, nodes)
+}
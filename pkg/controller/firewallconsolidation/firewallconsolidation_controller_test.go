@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewallconsolidation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/onsi/gomega"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+)
+
+func testService(name, ip string, port int32) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: port}},
+		},
+		Status: v1.ServiceStatus{
+			LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: ip}}},
+		},
+	}
+}
+
+func TestFirewallConsolidationController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, &compute.Instance{Name: "node-1", Tags: &compute.Tags{Items: []string{"node"}}}); err != nil {
+		t.Fatalf("Failed to insert instance: %v", err)
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		testService("svc-a", "1.1.1.1", 80),
+		testService("svc-b", "1.1.1.2", 80),
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewFirewallConsolidationController(informerFactory.Core().V1().Services(), informerFactory.Core().V1().Nodes(), fakeGCE)
+	if !fakeGCE.FirewallConsolidationEnabled() {
+		t.Errorf("FirewallConsolidationEnabled() = false, want true once the controller is created")
+	}
+	informerFactory.Start(ctx.Done())
+	go controller.Run(ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	firewalls := func() []*compute.Firewall {
+		firewalls, err := fakeGCE.Compute().Firewalls().List(ctx, filter.None)
+		if err != nil {
+			t.Fatalf("Failed to list firewalls: %v", err)
+		}
+		return firewalls
+	}
+	g.Eventually(firewalls).Should(gomega.ConsistOf(gomega.And(
+		gomega.HaveField("TargetTags", []string{"node"}),
+		gomega.HaveField("DestinationRanges", []string{"1.1.1.1", "1.1.1.2"}),
+		gomega.HaveField("Allowed", []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"80"}}}),
+	)))
+
+	// Deleting the last Service deletes the rule.
+	for _, name := range []string{"svc-a", "svc-b"} {
+		if err := client.CoreV1().Services("default").Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Failed to delete Service %s: %v", name, err)
+		}
+	}
+	g.Eventually(firewalls).Should(gomega.BeEmpty())
+}
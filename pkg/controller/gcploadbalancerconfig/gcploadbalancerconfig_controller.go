@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcploadbalancerconfig
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	lbconfigv1 "k8s.io/cloud-provider-gcp/crd/apis/gcploadbalancerconfig/v1"
+	lbconfiginformers "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/informers/externalversions"
+	lbconfiginformer "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/informers/externalversions/gcploadbalancerconfig/v1"
+	lbconfiglister "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/listers/gcploadbalancerconfig/v1"
+	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	workqueueName = "gcploadbalancerconfig"
+)
+
+// Controller applies the default GCPLoadBalancerConfig to the load balancers
+// managed by the cloud provider.
+type Controller struct {
+	lister          lbconfiglister.GCPLoadBalancerConfigLister
+	informerSynced  cache.InformerSynced
+	informerFactory lbconfiginformers.SharedInformerFactory
+	gceCloud        *gce.Cloud
+	queue           workqueue.RateLimitingInterface
+}
+
+// NewGCPLoadBalancerConfigController returns a new GCPLoadBalancerConfig controller.
+func NewGCPLoadBalancerConfigController(
+	informer lbconfiginformer.GCPLoadBalancerConfigInformer,
+	gceCloud *gce.Cloud,
+	informerFactory lbconfiginformers.SharedInformerFactory,
+) *Controller {
+	c := &Controller{
+		lister:          informer.Lister(),
+		informerSynced:  informer.Informer().HasSynced,
+		informerFactory: informerFactory,
+		gceCloud:        gceCloud,
+		queue:           workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
+	}
+
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			c.enqueue(new)
+		},
+		DeleteFunc: c.enqueue,
+	})
+	return c
+}
+
+// enqueue queues the changes of the default config. Configs with other names
+// are ignored, so that there is a single set of defaults.
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	if key != lbconfigv1.DefaultGCPLoadBalancerConfigName {
+		klog.Warningf("Ignoring GCPLoadBalancerConfig %q, only %q is applied", key, lbconfigv1.DefaultGCPLoadBalancerConfigName)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run starts an asynchronous loop that applies the GCPLoadBalancerConfig in the cluster.
+func (c *Controller) Run(stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting gcploadbalancerconfig controller")
+	defer klog.Infof("Shutting down gcploadbalancerconfig controller")
+	controllerManagerMetrics.ControllerStarted("gcploadbalancerconfig")
+	defer controllerManagerMetrics.ControllerStopped("gcploadbalancerconfig")
+
+	c.informerFactory.Start(stopCh)
+
+	if !cache.WaitForNamedCacheSync("gcploadbalancerconfig", stopCh, c.informerSynced) {
+		return
+	}
+
+	// A single worker, as there is a single config.
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	<-stopCh
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.queue.Done(key)
+
+	err := c.sync(key.(string))
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	if c.queue.NumRequeues(key) < 5 {
+		klog.Warningf("Error while applying GCPLoadBalancerConfig, retrying %v: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	c.queue.Forget(key)
+	utilruntime.HandleError(err)
+	klog.Errorf("Dropping GCPLoadBalancerConfig %q out of the queue: %v", key, err)
+	controllermetrics.WorkqueueDroppedObjects.WithLabelValues(workqueueName).Inc()
+}
+
+func (c *Controller) sync(name string) error {
+	config, err := c.lister.Get(name)
+	if errors.IsNotFound(err) {
+		klog.Infof("GCPLoadBalancerConfig %q was deleted, resetting the load balancer defaults", name)
+		c.gceCloud.SetLoadBalancerDefaults(gce.LoadBalancerDefaults{})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defaults := loadBalancerDefaults(config)
+	if err := defaults.Validate(); err != nil {
+		// Retrying does not help, the next change of the config is synced.
+		klog.Errorf("Ignoring invalid GCPLoadBalancerConfig %q, keeping the previous load balancer defaults: %v", name, err)
+		return nil
+	}
+	klog.Infof("Applying GCPLoadBalancerConfig %q: %+v", name, defaults)
+	c.gceCloud.SetLoadBalancerDefaults(defaults)
+	return nil
+}
+
+// loadBalancerDefaults converts the config to the provider defaults.
+func loadBalancerDefaults(config *lbconfigv1.GCPLoadBalancerConfig) gce.LoadBalancerDefaults {
+	defaults := gce.LoadBalancerDefaults{
+		NetworkTier: cloud.NetworkTier(config.Spec.NetworkTier),
+		ILBSubnet:   config.Spec.InternalLoadBalancerSubnet,
+	}
+	if hc := config.Spec.HealthCheck; hc != nil {
+		defaults.HealthCheck = gce.HealthCheckDefaults{
+			CheckIntervalSec:   ptr.Deref(hc.CheckIntervalSeconds, 0),
+			TimeoutSec:         ptr.Deref(hc.TimeoutSeconds, 0),
+			HealthyThreshold:   ptr.Deref(hc.HealthyThreshold, 0),
+			UnhealthyThreshold: ptr.Deref(hc.UnhealthyThreshold, 0),
+		}
+	}
+	return defaults
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcploadbalancerconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	lbconfigv1 "k8s.io/cloud-provider-gcp/crd/apis/gcploadbalancerconfig/v1"
+	lbconfigfake "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/clientset/versioned/fake"
+	lbconfiginformers "k8s.io/cloud-provider-gcp/crd/client/gcploadbalancerconfig/informers/externalversions"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/utils/ptr"
+)
+
+func TestGCPLoadBalancerConfigController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	client := lbconfigfake.NewSimpleClientset()
+	informerFactory := lbconfiginformers.NewSharedInformerFactory(client, 0*time.Second)
+	fakeGCE := gce.NewFakeGCECloud(gce.DefaultTestClusterValues())
+	controller := NewGCPLoadBalancerConfigController(
+		informerFactory.Networking().V1().GCPLoadBalancerConfigs(),
+		fakeGCE,
+		informerFactory,
+	)
+	go controller.Run(ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	config := &lbconfigv1.GCPLoadBalancerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: lbconfigv1.DefaultGCPLoadBalancerConfigName},
+		Spec: lbconfigv1.GCPLoadBalancerConfigSpec{
+			NetworkTier:                lbconfigv1.NetworkTierStandard,
+			InternalLoadBalancerSubnet: "ilb-subnet",
+			HealthCheck: &lbconfigv1.HealthCheckConfig{
+				CheckIntervalSeconds: ptr.To[int64](5),
+				UnhealthyThreshold:   ptr.To[int64](4),
+			},
+		},
+	}
+	config, err := client.NetworkingV1().GCPLoadBalancerConfigs().Create(ctx, config, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create GCPLoadBalancerConfig: %v", err)
+	}
+	want := gce.LoadBalancerDefaults{
+		NetworkTier: cloud.NetworkTierStandard,
+		ILBSubnet:   "ilb-subnet",
+		HealthCheck: gce.HealthCheckDefaults{CheckIntervalSec: 5, UnhealthyThreshold: 4},
+	}
+	g.Eventually(fakeGCE.LoadBalancerDefaults).Should(gomega.Equal(want), "defaults should be applied")
+
+	// Configs with other names are ignored.
+	other := config.DeepCopy()
+	other.Name = "other"
+	other.Spec.NetworkTier = lbconfigv1.NetworkTierPremium
+	if _, err := client.NetworkingV1().GCPLoadBalancerConfigs().Create(ctx, other, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create GCPLoadBalancerConfig: %v", err)
+	}
+	g.Consistently(fakeGCE.LoadBalancerDefaults, 500*time.Millisecond).Should(gomega.Equal(want), "other configs should be ignored")
+
+	// An invalid config keeps the previous defaults.
+	config.Spec.HealthCheck.TimeoutSeconds = ptr.To[int64](10)
+	if _, err := client.NetworkingV1().GCPLoadBalancerConfigs().Update(ctx, config, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update GCPLoadBalancerConfig: %v", err)
+	}
+	g.Consistently(fakeGCE.LoadBalancerDefaults, 500*time.Millisecond).Should(gomega.Equal(want), "invalid config should be ignored")
+
+	if err := client.NetworkingV1().GCPLoadBalancerConfigs().Delete(ctx, config.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete GCPLoadBalancerConfig: %v", err)
+	}
+	g.Eventually(fakeGCE.LoadBalancerDefaults).Should(gomega.Equal(gce.LoadBalancerDefaults{}), "defaults should be reset")
+}
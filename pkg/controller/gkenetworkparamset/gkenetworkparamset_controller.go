@@ -23,11 +23,16 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"github.com/hashicorp/go-multierror"
 	"google.golang.org/api/compute/v1"
 	v1 "k8s.io/api/core/v1"
@@ -35,12 +40,16 @@ import (
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	networkv1 "k8s.io/cloud-provider-gcp/crd/apis/network/v1"
 	networkclientset "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned"
+	networkscheme "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned/scheme"
 	networkinformers "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions"
 	networkinformer "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions/network/v1"
 	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
@@ -64,6 +73,7 @@ const (
 	componentName             = "cloud-controller-manager"
 	ensureExistsMode          = "EnsureExists"
 	reconcileMode             = "Reconcile"
+	gnpKindName               = "GKENetworkParamSet"
 )
 
 // Controller manages GKENetworkParamSet status.
@@ -78,10 +88,34 @@ type Controller struct {
 	nodeLister                corelisters.NodeLister
 	nodeInformerSynced        cache.InformerSynced
 	clusterDefaultIPv4PodCIDR string
+
+	kubeClient clientset.Interface
+	recorder   record.EventRecorder
+	// removeStalePodRangeLabels removes the Pod range labels of the nodes
+	// referencing the Pod ranges of the deleted GKENetworkParamSets, which are
+	// otherwise only reported.
+	removeStalePodRangeLabels bool
+	// crossGNPLock serializes the reconciles depending on the other
+	// GKENetworkParamSets: the validation of the VPC and the subnet claimed
+	// in device mode, the sync of the Pod ranges of the default
+	// GKENetworkParamSet from the node labels, and the audit of the node
+	// labels of the deleted ones. The other reconciles run concurrently, the
+	// queue never hands a key to two workers at once.
+	crossGNPLock sync.Mutex
+}
+
+// eventScheme is the scheme of the objects the controller records events on:
+// the nodes, and the GKENetworkParamSets and the Networks failing validation.
+var eventScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(scheme.AddToScheme(eventScheme))
+	utilruntime.Must(networkscheme.AddToScheme(eventScheme))
 }
 
 // NewGKENetworkParamSetController returns a new
 func NewGKENetworkParamSetController(
+	kubeClient clientset.Interface,
 	nodeInformer coreinformers.NodeInformer,
 	networkClientset networkclientset.Interface,
 	gkeNetworkParamsInformer networkinformer.GKENetworkParamSetInformer,
@@ -89,20 +123,28 @@ func NewGKENetworkParamSetController(
 	gceCloud *gce.Cloud,
 	networkInformerFactory networkinformers.SharedInformerFactory,
 	clusterCIDRs []*net.IPNet,
+	removeStalePodRangeLabels bool,
 ) *Controller {
 
 	// register GNP metrics
 	registerGKENetworkParamSetMetrics()
 
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
 	c := &Controller{
-		networkClientset:         networkClientset,
-		gkeNetworkParamsInformer: gkeNetworkParamsInformer,
-		networkInformer:          networkInformer,
-		gceCloud:                 gceCloud,
-		queue:                    workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
-		networkInformerFactory:   networkInformerFactory,
-		nodeLister:               nodeInformer.Lister(),
-		nodeInformerSynced:       nodeInformer.Informer().HasSynced,
+		networkClientset:          networkClientset,
+		gkeNetworkParamsInformer:  gkeNetworkParamsInformer,
+		networkInformer:           networkInformer,
+		gceCloud:                  gceCloud,
+		queue:                     workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
+		networkInformerFactory:    networkInformerFactory,
+		nodeLister:                nodeInformer.Lister(),
+		nodeInformerSynced:        nodeInformer.Informer().HasSynced,
+		kubeClient:                kubeClient,
+		recorder:                  eventBroadcaster.NewRecorder(eventScheme, v1.EventSource{Component: "gkenetworkparamset-controller"}),
+		removeStalePodRangeLabels: removeStalePodRangeLabels,
 	}
 
 	gkeNetworkParamsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -169,12 +211,31 @@ func NewGKENetworkParamSetController(
 			if c.nonDefaultParamsPodRanges(node) {
 				c.queue.Add(networkv1.DefaultPodNetworkName)
 			}
+			c.enqueueGNPsOfNetworks(nodePodNetworks(node))
+		},
+		// only changes of the networks a node has Pod ranges from update the node counts
+		UpdateFunc: func(old, new interface{}) {
+			oldNetworks := nodePodNetworks(old.(*v1.Node))
+			newNetworks := nodePodNetworks(new.(*v1.Node))
+			if !oldNetworks.Equal(newNetworks) {
+				c.enqueueGNPsOfNetworks(oldNetworks.SymmetricDifference(newNetworks))
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			node := obj.(*v1.Node)
+			node, ok := obj.(*v1.Node)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
+				if node, ok = tombstone.Obj.(*v1.Node); !ok {
+					return
+				}
+			}
 			if v, ok := node.Labels[utilnode.NodePoolPodRangeLabelPrefix]; ok && v != "" {
 				c.queue.Add(networkv1.DefaultPodNetworkName)
 			}
+			c.enqueueGNPsOfNetworks(nodePodNetworks(node))
 		},
 	})
 
@@ -184,12 +245,33 @@ func NewGKENetworkParamSetController(
 		}
 	}
 	if c.clusterDefaultIPv4PodCIDR == "" {
-		klog.Fatal("Controller: Must specify --cluster-cidr for GKE VPC native cluster")
+		klog.Info("Controller: no IPv4 --cluster-cidr, the default GKENetworkParamSet is not populated")
 	}
 
 	return c
 }
 
+// ParseClusterCIDRs parses the comma separated --cluster-cidr of the cluster,
+// at most one CIDR per IP family, to pass to NewGKENetworkParamSetController.
+func ParseClusterCIDRs(clusterCIDRs string) ([]*net.IPNet, error) {
+	// failure: bad cidrs in config
+	cidrs, err := netutils.ParseCIDRs(strings.Split(strings.TrimSpace(clusterCIDRs), ","))
+	if err != nil {
+		return nil, err
+	}
+
+	// failure: more than cidrs is not allowed even with dual stack
+	if len(cidrs) > 2 {
+		return nil, fmt.Errorf("len of clusters is:%v > more than max allowed of 2", len(cidrs))
+	}
+
+	// failure: more than one cidr but they are not configured as dual stack
+	if dualStack, _ := netutils.IsDualStackCIDRs(cidrs); len(cidrs) > 1 && !dualStack {
+		return nil, fmt.Errorf("len of ClusterCIDRs==%v and they are not configured as dual stack (at least one from each IPFamily", len(cidrs))
+	}
+	return cidrs, nil
+}
+
 // Run starts an asynchronous loop that monitors and updates GKENetworkParamSet in the cluster.
 func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
 	defer utilruntime.HandleCrash()
@@ -274,6 +356,22 @@ func addFinalizerInPlace(params *networkv1.GKENetworkParamSet) {
 	params.SetFinalizers(append(gnpFinalizers, GNPFinalizer))
 }
 
+// defaultVPCInPlace sets the VPC of params to the VPC of the cluster by
+// mutating params when only its subnet is specified. The subnet and the VPC
+// are then both looked up in the network project of the cluster.
+func (c *Controller) defaultVPCInPlace(params *networkv1.GKENetworkParamSet) {
+	if params.Spec.VPC != "" || params.Spec.VPCSubnet == "" {
+		return
+	}
+	networkResource, err := cloud.ParseResourceURL(c.gceCloud.NetworkURL())
+	if err != nil {
+		klog.Warningf("Not defaulting the VPC of GKENetworkParamSet %q, failed to parse the network URL %q of the cluster: %v", params.Name, c.gceCloud.NetworkURL(), err)
+		return
+	}
+	klog.V(2).Infof("Defaulting the VPC of GKENetworkParamSet %q to %s of project %s", params.Name, networkResource.Key.Name, c.gceCloud.NetworkProjectID())
+	params.Spec.VPC = networkResource.Key.Name
+}
+
 // removeFinalizerInPlace removes a finalizer by mutating params if the finalizer exists
 func removeFinalizerInPlace(params *networkv1.GKENetworkParamSet) {
 	finalizers := params.GetFinalizers()
@@ -298,10 +396,15 @@ func (c *Controller) reconcile(ctx context.Context, key string) error {
 		return err
 	}
 
+	if dependsOnOtherGNPs(originalParams) {
+		c.crossGNPLock.Lock()
+		defer c.crossGNPLock.Unlock()
+	}
+
 	params := originalParams.DeepCopy()
 
 	// always re-create "default" paramset to ensure the valid vpc, subnet and cluster-default pod range
-	if params.Name == networkv1.DefaultPodNetworkName {
+	if params.Name == networkv1.DefaultPodNetworkName && c.clusterDefaultIPv4PodCIDR != "" {
 		// should make sure the addon manager is not on reconcile mode
 		if v := params.Labels[labelsAddonManagerMode]; v != reconcileMode {
 			if err = c.populateDesiredDefaultParamSet(ctx, params); err != nil {
@@ -311,6 +414,9 @@ func (c *Controller) reconcile(ctx context.Context, key string) error {
 	}
 
 	err = c.syncGNP(ctx, params)
+	if countErr := c.syncNodeCount(params); countErr != nil {
+		err = multierror.Append(countErr, err)
+	}
 
 	// if the "default" paramset updates PodIPv4Range, marks the default Network not ready.
 	// This will trigger NCM to update Network routes.
@@ -341,6 +447,12 @@ func (c *Controller) reconcile(ctx context.Context, key string) error {
 	return nil
 }
 
+// dependsOnOtherGNPs returns true if the reconcile of params depends on the
+// other GKENetworkParamSets, see crossGNPLock.
+func dependsOnOtherGNPs(params *networkv1.GKENetworkParamSet) bool {
+	return params.Name == networkv1.DefaultPodNetworkName || params.Spec.DeviceMode != "" || params.DeletionTimestamp != nil
+}
+
 // populateDesiredDefaultParamSet set the "default" params to desired state
 func (c *Controller) populateDesiredDefaultParamSet(ctx context.Context, params *networkv1.GKENetworkParamSet) error {
 	// get vpc
@@ -406,8 +518,11 @@ func (c *Controller) syncGNP(ctx context.Context, params *networkv1.GKENetworkPa
 	}
 
 	addFinalizerInPlace(params)
+	c.defaultVPCInPlace(params)
+	originalCondition := meta.FindStatusCondition(params.Status.Conditions, string(networkv1.GKENetworkParamSetStatusReady)).DeepCopy()
+	defer c.recordValidationConditionChange(params, originalCondition)
 	subnet, subnetValidation := c.getAndValidateSubnet(ctx, params)
-	meta.SetStatusCondition(&params.Status.Conditions, subnetValidation.toCondition())
+	setValidationCondition(params, subnetValidation)
 	if !subnetValidation.IsValid {
 		return nil
 	}
@@ -416,7 +531,7 @@ func (c *Controller) syncGNP(ctx context.Context, params *networkv1.GKENetworkPa
 	if err != nil {
 		return err
 	}
-	meta.SetStatusCondition(&params.Status.Conditions, paramsValidation.toCondition())
+	setValidationCondition(params, paramsValidation)
 	if !paramsValidation.IsValid {
 		return nil
 	}
@@ -435,6 +550,12 @@ func (c *Controller) syncGNP(ctx context.Context, params *networkv1.GKENetworkPa
 	params.Status.PodCIDRs = &networkv1.NetworkRanges{
 		CIDRBlocks: cidrs,
 	}
+	params.Status.PodIPv6CIDRs = nil
+	if ipv6Cidrs := extractRelevantIPv6Cidrs(params); len(ipv6Cidrs) > 0 {
+		params.Status.PodIPv6CIDRs = &networkv1.NetworkRanges{
+			CIDRBlocks: ipv6Cidrs,
+		}
+	}
 
 	network, err := c.getNetworkReferringToGNP(params.Name)
 	if err != nil {
@@ -444,6 +565,12 @@ func (c *Controller) syncGNP(ctx context.Context, params *networkv1.GKENetworkPa
 		return nil
 	}
 
+	if params.Name == networkv1.DefaultPodNetworkName && network.Name == networkv1.DefaultPodNetworkName {
+		if network, err = c.ensureGNPOwnerReference(ctx, network, params); err != nil {
+			return err
+		}
+	}
+
 	if err = c.syncNetworkWithGNP(ctx, network, params); err != nil {
 		return err
 	}
@@ -470,9 +597,22 @@ func (c *Controller) getNetworkReferringToGNP(gnpName string) (*networkv1.Networ
 func (c *Controller) syncNetworkWithGNP(ctx context.Context, network *networkv1.Network, params *networkv1.GKENetworkParamSet) error {
 	newNetwork := network.DeepCopy()
 
+	// the VPC constraints of the device mode are validated before Pods attach to the Network
+	var vpc *compute.Network
+	if newNetwork.Spec.Type == networkv1.DeviceNetworkType && params.Spec.DeviceMode != "" && !c.gceCloud.OnXPN() {
+		var err error
+		vpc, err = c.gceCloud.GetNetwork(params.Spec.VPC)
+		if err != nil {
+			return err
+		}
+	}
+
 	// update the copy of old Network with new conditions to be new Network basing on the change of the GNP
-	networkCrossValidation := crossValidateNetworkAndGnp(newNetwork, params)
-	meta.SetStatusCondition(&newNetwork.Status.Conditions, networkCrossValidation.toCondition())
+	networkCrossValidation := crossValidateNetworkAndGnp(newNetwork, params, vpc)
+	condition := networkCrossValidation.toCondition()
+	if meta.SetStatusCondition(&newNetwork.Status.Conditions, condition) && !networkCrossValidation.IsValid {
+		c.recordValidationFailure(params, network, condition)
+	}
 
 	if !reflect.DeepEqual(newNetwork.Status.Conditions, network.Status.Conditions) {
 		_, err := c.networkClientset.NetworkingV1().Networks().UpdateStatus(ctx, newNetwork, metav1.UpdateOptions{})
@@ -517,6 +657,10 @@ func (c *Controller) handleGNPDelete(ctx context.Context, params *networkv1.GKEN
 }
 
 func (c *Controller) executeGNPDelete(ctx context.Context, params *networkv1.GKENetworkParamSet, network *networkv1.Network) error {
+	// The Pod ranges are only known until the finalizer is removed.
+	if err := c.auditPodRangeLabels(params); err != nil {
+		return err
+	}
 	removeFinalizerInPlace(params)
 
 	return nil
@@ -524,6 +668,8 @@ func (c *Controller) executeGNPDelete(ctx context.Context, params *networkv1.GKE
 
 // cleanupGNPDeletion is called post GNP deletion
 func (c *Controller) cleanupGNPDeletion(ctx context.Context, gnpName string) error {
+	gnpNodes.DeleteLabelValues(gnpName)
+
 	network, err := c.getNetworkReferringToGNP(gnpName)
 	if err != nil {
 		return err
@@ -532,6 +678,16 @@ func (c *Controller) cleanupGNPDeletion(ctx context.Context, gnpName string) err
 		return nil
 	}
 
+	// The default Network derived from the default GNP is garbage collected
+	// with it, so that no orphan Network is left behind for the CNI.
+	if isOwnedByGNP(network, gnpName) && !network.InUse() {
+		klog.Infof("Deleting Network %q derived from deleted GKENetworkParamSet %q", network.Name, gnpName)
+		if err := c.networkClientset.NetworkingV1().Networks().Delete(ctx, network.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
 	newNetwork := network.DeepCopy()
 	meta.SetStatusCondition(&newNetwork.Status.Conditions, metav1.Condition{
 		Type:    string(networkv1.NetworkConditionStatusParamsReady),
@@ -546,6 +702,57 @@ func (c *Controller) cleanupGNPDeletion(ctx context.Context, gnpName string) err
 	return nil
 }
 
+// ensureGNPOwnerReference makes params the controller owner of the Network
+// derived from it, and returns the updated Network.
+func (c *Controller) ensureGNPOwnerReference(ctx context.Context, network *networkv1.Network, params *networkv1.GKENetworkParamSet) (*networkv1.Network, error) {
+	ownerRef := gnpOwnerReference(params)
+	var ownerRefs []metav1.OwnerReference
+	for _, ref := range network.OwnerReferences {
+		if reflect.DeepEqual(ref, ownerRef) {
+			return network, nil
+		}
+		// Drop references to a previous incarnation of the GNP.
+		if ref.Kind == gnpKindName && ref.Name == params.Name {
+			continue
+		}
+		ownerRefs = append(ownerRefs, ref)
+	}
+
+	newNetwork := network.DeepCopy()
+	newNetwork.OwnerReferences = append(ownerRefs, ownerRef)
+	updated, err := c.networkClientset.NetworkingV1().Networks().Update(ctx, newNetwork, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set owner reference of Network %q: %w", network.Name, err)
+	}
+	return updated, nil
+}
+
+// gnpOwnerReference returns the owner reference set by the controller on
+// Networks derived from params. It does not block the deletion of params,
+// which is already held by GNPFinalizer while the Network is in use.
+func gnpOwnerReference(params *networkv1.GKENetworkParamSet) metav1.OwnerReference {
+	controller := true
+	blockOwnerDeletion := false
+	return metav1.OwnerReference{
+		APIVersion:         networkv1.SchemeGroupVersion.String(),
+		Kind:               gnpKindName,
+		Name:               params.Name,
+		UID:                params.UID,
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
+
+// isOwnedByGNP returns true if the Network is owned by the GNP with the given name.
+func isOwnedByGNP(network *networkv1.Network, gnpName string) bool {
+	for _, ref := range network.OwnerReferences {
+		if ref.Kind == gnpKindName && ref.Name == gnpName && ref.APIVersion == networkv1.SchemeGroupVersion.String() {
+			return true
+		}
+	}
+	return false
+}
+
 // extractRelevantCidrs returns the CIDRS of the named ranges in paramset
 func extractRelevantCidrs(subnet *compute.Subnetwork, paramset *networkv1.GKENetworkParamSet) []string {
 	cidrs := []string{}
@@ -567,6 +774,21 @@ func extractRelevantCidrs(subnet *compute.Subnetwork, paramset *networkv1.GKENet
 	return cidrs
 }
 
+// extractRelevantIPv6Cidrs returns the canonical IPv6 CIDRs of the paramset,
+// which are validated to be within the internal IPv6 range of its subnet
+func extractRelevantIPv6Cidrs(paramset *networkv1.GKENetworkParamSet) []string {
+	if !hasIPv6Ranges(paramset) {
+		return nil
+	}
+	cidrs := []string{}
+	for _, cidr := range paramset.Spec.PodIPv6Ranges.CIDRBlocks {
+		if _, ipNet, err := netutils.ParseCIDRSloppy(cidr); err == nil {
+			cidrs = append(cidrs, ipNet.String())
+		}
+	}
+	return cidrs
+}
+
 func paramSetIncludesRange(params *networkv1.GKENetworkParamSet, secondaryRangeName string) bool {
 	for _, rn := range params.Spec.PodIPv4Ranges.RangeNames {
 		if rn == secondaryRangeName {
@@ -637,3 +859,62 @@ func (c *Controller) updateNetworkConditionForPodRanges(ctx context.Context, par
 	}
 	return nil
 }
+
+// syncNodeCount sets the number of Nodes with Pod ranges from the Network
+// referencing params in params status.
+func (c *Controller) syncNodeCount(params *networkv1.GKENetworkParamSet) error {
+	count := 0
+	if params.Status.NetworkName != "" {
+		nodes, err := c.nodeLister.List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("failed to list node from cache: %w", err)
+		}
+		for _, node := range nodes {
+			if nodePodNetworks(node).Has(params.Status.NetworkName) {
+				count++
+			}
+		}
+	}
+	params.Status.NodeCount = int32(count)
+	gnpNodes.WithLabelValues(params.Name).Set(float64(count))
+	return nil
+}
+
+// nodePodNetworks returns the names of the Networks the node has Pod ranges
+// from: the default Network for the node PodCIDRs, and the Networks of the
+// multi-network annotation.
+func nodePodNetworks(node *v1.Node) sets.String {
+	networks := sets.NewString()
+	if len(node.Spec.PodCIDRs) > 0 {
+		networks.Insert(networkv1.DefaultPodNetworkName)
+	}
+	annotation, ok := node.Annotations[networkv1.MultiNetworkAnnotationKey]
+	if !ok {
+		return networks
+	}
+	nodeNetworks, err := networkv1.ParseMultiNetworkAnnotation(annotation)
+	if err != nil {
+		klog.Warningf("Failed to parse the %s annotation of node %q: %v", networkv1.MultiNetworkAnnotationKey, node.Name, err)
+		return networks
+	}
+	for _, nodeNetwork := range nodeNetworks {
+		if len(nodeNetwork.Cidrs) > 0 {
+			networks.Insert(nodeNetwork.Name)
+		}
+	}
+	return networks
+}
+
+// enqueueGNPsOfNetworks queues the GKENetworkParamSets referenced by the
+// given Networks.
+func (c *Controller) enqueueGNPsOfNetworks(networkNames sets.String) {
+	for _, name := range networkNames.UnsortedList() {
+		network, err := c.networkInformer.Lister().Get(name)
+		if err != nil {
+			continue
+		}
+		if network.Spec.ParametersRef != nil && strings.EqualFold(network.Spec.ParametersRef.Kind, gnpKind) {
+			c.queue.Add(network.Spec.ParametersRef.Name)
+		}
+	}
+}
@@ -4,22 +4,31 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	"github.com/google/go-cmp/cmp"
 	"github.com/onsi/gomega"
 	"github.com/onsi/gomega/types"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	condmeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
 	networkv1 "k8s.io/cloud-provider-gcp/crd/apis/network/v1"
 	networkfake "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned/fake"
 	networkinformers "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions"
@@ -36,6 +45,7 @@ type testGKENetworkParamSetController struct {
 	metrics         *controllers.ControllerManagerMetrics
 	cloud           *gce.Cloud
 	nodeStore       cache.Store
+	kubeClient      *fake.Clientset
 }
 
 const (
@@ -67,7 +77,9 @@ func setupGKENetworkParamSetController(ctx context.Context) *testGKENetworkParam
 
 	_, ipnet, _ := net.ParseCIDR(defaultPodCIDR)
 
+	kubeClient := fake.NewSimpleClientset()
 	controller := NewGKENetworkParamSetController(
+		kubeClient,
 		fakeNodeInformer,
 		fakeNetworking,
 		gnpInformer,
@@ -75,6 +87,7 @@ func setupGKENetworkParamSetController(ctx context.Context) *testGKENetworkParam
 		fakeGCE,
 		nwInfFactory,
 		[]*net.IPNet{ipnet},
+		false,
 	)
 	controller.nodeInformerSynced = func() bool { return true }
 
@@ -101,6 +114,7 @@ func setupGKENetworkParamSetController(ctx context.Context) *testGKENetworkParam
 		metrics:         metrics,
 		cloud:           fakeGCE,
 		nodeStore:       fakeNodeInformer.Informer().GetStore(),
+		kubeClient:      kubeClient,
 	}
 }
 
@@ -115,6 +129,120 @@ func TestControllerRuns(t *testing.T) {
 	testVals.runGKENetworkParamSetController(ctx)
 }
 
+func TestConcurrentWorkers(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	testVals := setupGKENetworkParamSetController(ctx)
+
+	// The reconciles of the GKENetworkParamSets in device mode, validating
+	// that no other GKENetworkParamSet claims their VPC, are serialized
+	// across the workers.
+	var inFlight, maxInFlight int32
+	testVals.cloud.Compute().(*cloud.MockGCE).MockNetworks.GetHook = func(_ context.Context, key *meta.Key, _ *cloud.MockNetworks, _ ...cloud.Option) (bool, *compute.Network, error) {
+		if !strings.HasPrefix(key.Name, "test-device-vpc-") {
+			return false, nil, nil
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return false, nil, nil
+	}
+
+	const paramSets = 6
+	var names []string
+	for i := 0; i < paramSets; i++ {
+		vpcName, subnetName := fmt.Sprintf("test-vpc-%d", i), fmt.Sprintf("test-subnet-%d", i)
+		if i%2 == 0 {
+			vpcName = fmt.Sprintf("test-device-vpc-%d", i)
+		}
+		if err := testVals.cloud.Compute().Networks().Insert(ctx, meta.GlobalKey(vpcName), &compute.Network{Name: vpcName}); err != nil {
+			t.Fatal(err)
+		}
+		subnet := &compute.Subnetwork{
+			Name: subnetName,
+			SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+				{IpCidrRange: fmt.Sprintf("10.0.%d.0/24", i), RangeName: "test-secondary-range"},
+			},
+		}
+		if err := testVals.cloud.Compute().Subnetworks().Insert(ctx, meta.RegionalKey(subnetName, testVals.clusterValues.Region), subnet); err != nil {
+			t.Fatal(err)
+		}
+		paramSet := &networkv1.GKENetworkParamSet{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-paramset-%d", i)},
+			Spec:       networkv1.GKENetworkParamSetSpec{VPC: vpcName, VPCSubnet: subnetName},
+		}
+		if i%2 == 0 {
+			paramSet.Spec.DeviceMode = networkv1.NetDevice
+		} else {
+			paramSet.Spec.PodIPv4Ranges = &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}}
+		}
+		if _, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Create(ctx, paramSet, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, paramSet.Name)
+	}
+
+	go testVals.controller.Run(4, ctx.Done(), testVals.metrics)
+
+	for _, name := range names {
+		g.Eventually(func() (bool, error) {
+			paramSet, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return condmeta.IsStatusConditionTrue(paramSet.Status.Conditions, string(networkv1.GKENetworkParamSetStatusReady)), nil
+		}).Should(gomega.BeTrue(), "GKENetworkParamSet %s should be ready", name)
+	}
+	g.Expect(atomic.LoadInt32(&maxInFlight)).To(gomega.Equal(int32(1)))
+}
+
+func TestDependsOnOtherGNPs(t *testing.T) {
+	now := metav1.Now()
+	for _, tc := range []struct {
+		desc   string
+		params *networkv1.GKENetworkParamSet
+		want   bool
+	}{
+		{
+			desc:   "secondary ranges",
+			params: newL3GNP("test-paramset", []string{newPodRange1}, nil),
+		},
+		{
+			desc:   "default",
+			params: newL3GNP(networkv1.DefaultPodNetworkName, []string{defaultPodRange}, nil),
+			want:   true,
+		},
+		{
+			desc: "device mode",
+			params: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"},
+				Spec:       networkv1.GKENetworkParamSetSpec{DeviceMode: networkv1.NetDevice},
+			},
+			want: true,
+		},
+		{
+			desc: "deleted",
+			params: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset", DeletionTimestamp: &now},
+			},
+			want: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := dependsOnOtherGNPs(tc.params); got != tc.want {
+				t.Errorf("dependsOnOtherGNPs() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestAddValidParamSetSingleSecondaryRange(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 	ctx, stop := context.WithCancel(context.Background())
@@ -179,6 +307,60 @@ func TestAddValidParamSetSingleSecondaryRange(t *testing.T) {
 
 }
 
+func TestAddParamSetDefaultsVPC(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	testVals := setupGKENetworkParamSetController(ctx)
+
+	subnetName := "test-subnet"
+	subnetSecondaryRangeName := "test-secondary-range"
+	subnetKey := meta.RegionalKey(subnetName, testVals.clusterValues.Region)
+	subnet := &compute.Subnetwork{
+		Name: subnetName,
+		SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+			{
+				IpCidrRange: "10.0.0.0/24",
+				RangeName:   subnetSecondaryRangeName,
+			},
+		},
+	}
+
+	err := testVals.cloud.Compute().Subnetworks().Insert(ctx, subnetKey, subnet)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testVals.runGKENetworkParamSetController(ctx)
+
+	gkeNetworkParamSetName := "test-paramset"
+	paramSet := &networkv1.GKENetworkParamSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: gkeNetworkParamSetName,
+		},
+		Spec: networkv1.GKENetworkParamSetSpec{
+			VPCSubnet: subnetName,
+			PodIPv4Ranges: &networkv1.SecondaryRanges{
+				RangeNames: []string{
+					subnetSecondaryRangeName,
+				},
+			},
+		},
+	}
+	_, err = testVals.networkClient.NetworkingV1().GKENetworkParamSets().Create(ctx, paramSet, metav1.CreateOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	g.Eventually(func() (bool, error) {
+		paramSet, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, gkeNetworkParamSetName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return paramSet.Spec.VPC == defaultTestNetworkName && condmeta.IsStatusConditionTrue(paramSet.Status.Conditions, string(networkv1.GKENetworkParamSetStatusReady)), nil
+	}).Should(gomega.BeTrue(), "GKENetworkParamSet VPC should default to the VPC of the cluster.")
+}
+
 func TestAddValidParamSetMultipleSecondaryRange(t *testing.T) {
 	g := gomega.NewGomegaWithT(t)
 	ctx, stop := context.WithCancel(context.Background())
@@ -642,7 +824,7 @@ func TestGKENetworkParamSetValidations(t *testing.T) {
 			},
 		},
 		{
-			name: "GNP with VPC unspecified",
+			name: "GNP with VPC unspecified defaults to the default VPC",
 			paramSet: &networkv1.GKENetworkParamSet{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: gkeNetworkParamSetName,
@@ -655,7 +837,7 @@ func TestGKENetworkParamSetValidations(t *testing.T) {
 			expectedCondition: metav1.Condition{
 				Type:   "Ready",
 				Status: metav1.ConditionFalse,
-				Reason: "VPCNotFound",
+				Reason: "DeviceModeCantUseDefaultVPC",
 			},
 		},
 		{
@@ -805,26 +987,498 @@ func TestGKENetworkParamSetValidations(t *testing.T) {
 	}
 }
 
+func TestSecondaryRangeOfPeeredVPC(t *testing.T) {
+	peeredVPCName := "peered-vpc"
+	peerNetworkURL := "https://www.googleapis.com/compute/v1/projects/peer-project/global/networks/peer-vpc"
+
+	tests := []struct {
+		name           string
+		vpc            string
+		rangeName      string
+		listError      error
+		expectedReason networkv1.GKENetworkParamSetConditionReason
+	}{
+		{
+			name:           "range in a subnet of the peered VPC",
+			vpc:            peeredVPCName,
+			rangeName:      "peer-range",
+			expectedReason: networkv1.SecondaryRangeInPeeredVPC,
+		},
+		{
+			name:           "range in none of the peered VPCs",
+			vpc:            peeredVPCName,
+			rangeName:      "nonexistent-secondary-range",
+			expectedReason: networkv1.SecondaryRangeNotFound,
+		},
+		{
+			name:           "range in a subnet of another VPC of the peer project",
+			vpc:            peeredVPCName,
+			rangeName:      "other-vpc-range",
+			expectedReason: networkv1.SecondaryRangeNotFound,
+		},
+		{
+			name:           "subnets of the peered VPC not listable",
+			vpc:            peeredVPCName,
+			rangeName:      "peer-range",
+			listError:      fmt.Errorf("googleapi: Error 403: Required 'compute.subnetworks.list' permission for 'projects/peer-project', forbidden"),
+			expectedReason: networkv1.SecondaryRangePeeringLookupFailed,
+		},
+		{
+			name:           "VPC without peering",
+			vpc:            nonDefaultTestNetworkName,
+			rangeName:      "peer-range",
+			expectedReason: networkv1.SecondaryRangeNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			testVals := setupGKENetworkParamSetController(ctx)
+
+			peeredVPC := &compute.Network{
+				Name: peeredVPCName,
+				Peerings: []*compute.NetworkPeering{
+					{Name: "to-peer-vpc", Network: peerNetworkURL, State: "ACTIVE"},
+				},
+			}
+			if err := testVals.cloud.Compute().Networks().Insert(ctx, meta.GlobalKey(peeredVPCName), peeredVPC); err != nil {
+				t.Fatal(err)
+			}
+			for _, subnet := range []*compute.Subnetwork{
+				{
+					Name:              "peer-subnet",
+					Network:           peerNetworkURL,
+					SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{{IpCidrRange: "10.10.0.0/16", RangeName: "peer-range"}},
+				},
+				{
+					Name:              "other-vpc-subnet",
+					Network:           "https://www.googleapis.com/compute/v1/projects/peer-project/global/networks/other-vpc",
+					SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{{IpCidrRange: "10.20.0.0/16", RangeName: "other-vpc-range"}},
+				},
+			} {
+				if err := testVals.cloud.Compute().Subnetworks().Insert(ctx, meta.RegionalKey(subnet.Name, testVals.clusterValues.Region), subnet); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.listError != nil {
+				testVals.cloud.Compute().(*cloud.MockGCE).MockSubnetworks.ListHook = func(ctx context.Context, region string, fl *filter.F, m *cloud.MockSubnetworks, options ...cloud.Option) (bool, []*compute.Subnetwork, error) {
+					return true, nil, test.listError
+				}
+			}
+
+			params := &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           test.vpc,
+					VPCSubnet:     "test-subnet",
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{test.rangeName}},
+				},
+			}
+			subnet := &compute.Subnetwork{Name: "test-subnet"}
+			validation, err := testVals.controller.validateGKENetworkParamSet(ctx, params, subnet)
+			if err != nil {
+				t.Fatalf("validateGKENetworkParamSet() = %v", err)
+			}
+			if validation.IsValid || validation.ErrorReason != test.expectedReason {
+				t.Errorf("validateGKENetworkParamSet() = %+v, want reason %s", validation, test.expectedReason)
+			}
+		})
+	}
+}
+
+func TestValidateHostProjectVPC(t *testing.T) {
+	vpcURL := "https://www.googleapis.com/compute/v1/projects/host-project/global/networks/" + nonDefaultTestNetworkName
+	otherVPCURL := "https://www.googleapis.com/compute/v1/projects/host-project/global/networks/other-vpc"
+
+	tests := []struct {
+		name           string
+		vpc            string
+		subnetNetwork  string
+		getError       error
+		expectedReason networkv1.GKENetworkParamSetConditionReason
+		expectedErr    bool
+	}{
+		{
+			name:          "subnet of the VPC",
+			vpc:           nonDefaultTestNetworkName,
+			subnetNetwork: vpcURL,
+		},
+		{
+			name:           "VPC not found",
+			vpc:            "VPC-DOES-NOT-EXIST",
+			subnetNetwork:  vpcURL,
+			expectedReason: networkv1.VPCNotFound,
+		},
+		{
+			name:           "subnet of another VPC",
+			vpc:            nonDefaultTestNetworkName,
+			subnetNetwork:  otherVPCURL,
+			expectedReason: networkv1.SubnetNotFound,
+		},
+		{
+			name:          "VPC not gettable, subnet of the VPC",
+			vpc:           nonDefaultTestNetworkName,
+			subnetNetwork: vpcURL,
+			getError:      &googleapi.Error{Code: http.StatusForbidden},
+		},
+		{
+			name:           "VPC not gettable, subnet of another VPC",
+			vpc:            nonDefaultTestNetworkName,
+			subnetNetwork:  otherVPCURL,
+			getError:       &googleapi.Error{Code: http.StatusForbidden},
+			expectedReason: networkv1.SubnetNotFound,
+		},
+		{
+			name:          "VPC lookup failure",
+			vpc:           nonDefaultTestNetworkName,
+			subnetNetwork: vpcURL,
+			getError:      &googleapi.Error{Code: http.StatusInternalServerError},
+			expectedErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			testVals := setupGKENetworkParamSetController(ctx)
+			xpnValues := testVals.clusterValues
+			xpnValues.OnXPN = true
+			gce.UpdateFakeGCECloud(testVals.cloud, xpnValues)
+			if test.getError != nil {
+				testVals.cloud.Compute().(*cloud.MockGCE).MockNetworks.GetHook = func(ctx context.Context, key *meta.Key, m *cloud.MockNetworks, options ...cloud.Option) (bool, *compute.Network, error) {
+					return true, nil, test.getError
+				}
+			}
+
+			params := &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           test.vpc,
+					VPCSubnet:     "test-subnet",
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+				},
+			}
+			subnet := &compute.Subnetwork{
+				Name:              "test-subnet",
+				Network:           test.subnetNetwork,
+				SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{{IpCidrRange: "10.0.0.0/24", RangeName: "test-secondary-range"}},
+			}
+			validation, err := testVals.controller.validateGKENetworkParamSet(ctx, params, subnet)
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("validateGKENetworkParamSet() = %+v, want an error", validation)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateGKENetworkParamSet() = %v", err)
+			}
+			if test.expectedReason == "" {
+				if !validation.IsValid {
+					t.Errorf("validateGKENetworkParamSet() = %+v, want valid", validation)
+				}
+				return
+			}
+			if validation.IsValid || validation.ErrorReason != test.expectedReason {
+				t.Errorf("validateGKENetworkParamSet() = %+v, want reason %s", validation, test.expectedReason)
+			}
+		})
+	}
+}
+
+func TestPodIPv6Ranges(t *testing.T) {
+	tests := []struct {
+		name           string
+		subnet         *compute.Subnetwork
+		podIPv4Ranges  *networkv1.SecondaryRanges
+		podIPv6Ranges  []string
+		deviceMode     networkv1.DeviceModeType
+		expectedReason networkv1.GKENetworkParamSetConditionReason
+	}{
+		{
+			name:          "ranges within the internal IPv6 range of the subnet",
+			subnet:        &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges: []string{"fd20:1:2:3:0:1::/96", "fd20:1:2:3:0:2::/96"},
+		},
+		{
+			name:          "range within the IPv6 range of an internal subnet",
+			subnet:        &compute.Subnetwork{Ipv6AccessType: "INTERNAL", Ipv6CidrRange: "fd20:1:2:3::/64"},
+			podIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges: []string{"fd20:1:2:3:0:1::/96"},
+		},
+		{
+			name:           "ranges without IPv4 ranges",
+			subnet:         &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv6Ranges:  []string{"fd20:1:2:3:0:1::/96"},
+			expectedReason: networkv1.PodIPv6RangesWithoutIPv4Ranges,
+		},
+		{
+			name:           "ranges with deviceMode",
+			subnet:         &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv6Ranges:  []string{"fd20:1:2:3:0:1::/96"},
+			deviceMode:     networkv1.NetDevice,
+			expectedReason: networkv1.DeviceModeCantBeUsedWithSecondaryRange,
+		},
+		{
+			name:           "subnet with an external IPv6 range only",
+			subnet:         &compute.Subnetwork{Ipv6AccessType: "EXTERNAL", ExternalIpv6Prefix: "2600:1900:1:2::/64"},
+			podIPv4Ranges:  &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges:  []string{"2600:1900:1:2:0:1::/96"},
+			expectedReason: networkv1.SubnetInternalIPv6RangeNotFound,
+		},
+		{
+			name:           "range outside of the internal IPv6 range of the subnet",
+			subnet:         &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv4Ranges:  &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges:  []string{"fd20:1:2:4:0:1::/96"},
+			expectedReason: networkv1.PodIPv6RangeNotInSubnet,
+		},
+		{
+			name:           "range larger than the internal IPv6 range of the subnet",
+			subnet:         &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv4Ranges:  &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges:  []string{"fd20:1:2::/48"},
+			expectedReason: networkv1.PodIPv6RangeNotInSubnet,
+		},
+		{
+			name:           "IPv4 range",
+			subnet:         &compute.Subnetwork{InternalIpv6Prefix: "fd20:1:2:3::/64"},
+			podIPv4Ranges:  &networkv1.SecondaryRanges{RangeNames: []string{"test-secondary-range"}},
+			podIPv6Ranges:  []string{"10.1.0.0/16"},
+			expectedReason: networkv1.PodIPv6RangeNotInSubnet,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			testVals := setupGKENetworkParamSetController(ctx)
+
+			params := &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           nonDefaultTestNetworkName,
+					VPCSubnet:     "test-subnet",
+					PodIPv4Ranges: test.podIPv4Ranges,
+					PodIPv6Ranges: &networkv1.NetworkRanges{CIDRBlocks: test.podIPv6Ranges},
+					DeviceMode:    test.deviceMode,
+				},
+			}
+			test.subnet.Name = "test-subnet"
+			test.subnet.SecondaryIpRanges = []*compute.SubnetworkSecondaryRange{{IpCidrRange: "10.0.0.0/24", RangeName: "test-secondary-range"}}
+			validation, err := testVals.controller.validateGKENetworkParamSet(ctx, params, test.subnet)
+			if err != nil {
+				t.Fatalf("validateGKENetworkParamSet() = %v", err)
+			}
+			if test.expectedReason == "" {
+				if !validation.IsValid {
+					t.Errorf("validateGKENetworkParamSet() = %+v, want valid", validation)
+				}
+				return
+			}
+			if validation.IsValid || validation.ErrorReason != test.expectedReason {
+				t.Errorf("validateGKENetworkParamSet() = %+v, want reason %s", validation, test.expectedReason)
+			}
+		})
+	}
+}
+
+func TestAddValidParamSetIPv6Ranges(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	testVals := setupGKENetworkParamSetController(ctx)
+
+	subnetName := "test-subnet"
+	subnetSecondaryRangeName := "test-secondary-range"
+	subnetKey := meta.RegionalKey(subnetName, testVals.clusterValues.Region)
+	subnet := &compute.Subnetwork{
+		Name:               subnetName,
+		InternalIpv6Prefix: "fd20:1:2:3::/64",
+		SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+			{
+				IpCidrRange: "10.0.0.0/24",
+				RangeName:   subnetSecondaryRangeName,
+			},
+		},
+	}
+	if err := testVals.cloud.Compute().Subnetworks().Insert(ctx, subnetKey, subnet); err != nil {
+		t.Error(err)
+	}
+
+	testVals.runGKENetworkParamSetController(ctx)
+
+	gkeNetworkParamSetName := "test-paramset"
+	paramSet := &networkv1.GKENetworkParamSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: gkeNetworkParamSetName,
+		},
+		Spec: networkv1.GKENetworkParamSetSpec{
+			VPC:       defaultTestNetworkName,
+			VPCSubnet: subnetName,
+			PodIPv4Ranges: &networkv1.SecondaryRanges{
+				RangeNames: []string{subnetSecondaryRangeName},
+			},
+			PodIPv6Ranges: &networkv1.NetworkRanges{
+				CIDRBlocks: []string{"fd20:1:2:3:0:0001::/96"},
+			},
+		},
+	}
+	if _, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Create(ctx, paramSet, metav1.CreateOptions{}); err != nil {
+		t.Error(err)
+	}
+
+	g.Eventually(func() (bool, error) {
+		paramSet, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, gkeNetworkParamSetName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if paramSet.Status.PodIPv6CIDRs != nil && len(paramSet.Status.PodIPv6CIDRs.CIDRBlocks) > 0 {
+			g.Ω(paramSet.Status.PodIPv6CIDRs.CIDRBlocks).Should(gomega.ConsistOf("fd20:1:2:3:0:1::/96"))
+			return true, nil
+		}
+
+		return false, nil
+	}).Should(gomega.BeTrue(), "GKENetworkParamSet Status should be updated with the IPv6 cidrs.")
+}
+
 func TestCrossValidateNetworkAndGnp(t *testing.T) {
 	gkeNetworkParamSetName := "test-paramset"
 	subnetName := "test-subnet"
 	subnetSecondaryRangeName := "test-secondary-range"
 	networkName := "network-name"
+	internalMode := networkv1.InternalMode
 
 	tests := []struct {
 		name              string
 		network           *networkv1.Network
 		paramSet          *networkv1.GKENetworkParamSet
+		vpcMTU            int64
 		expectedCondition metav1.Condition
 	}{
 		{
-			name: "L3NetworkType with missing PodIPv4Ranges",
+			name: "L3NetworkType with missing PodIPv4Ranges",
+			network: &networkv1.Network{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: networkName,
+				},
+				Spec: networkv1.NetworkSpec{
+					Type:          networkv1.L3NetworkType,
+					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
+				},
+			},
+			paramSet: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: gkeNetworkParamSetName,
+				},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:        nonDefaultTestNetworkName,
+					VPCSubnet:  subnetName,
+					DeviceMode: networkv1.NetDevice,
+				},
+			},
+			expectedCondition: metav1.Condition{
+				Type:   "ParamsReady",
+				Status: metav1.ConditionFalse,
+				Reason: "L3SecondaryMissing",
+			},
+		},
+		{
+			name: "DeviceNetworkType with missing DeviceMode",
+			network: &networkv1.Network{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: networkName,
+				},
+				Spec: networkv1.NetworkSpec{
+					Type:          networkv1.DeviceNetworkType,
+					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
+				},
+			},
+			paramSet: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: gkeNetworkParamSetName,
+				},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           nonDefaultTestNetworkName,
+					VPCSubnet:     subnetName,
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{subnetSecondaryRangeName}},
+				},
+			},
+			expectedCondition: metav1.Condition{
+				Type:   "ParamsReady",
+				Status: metav1.ConditionFalse,
+				Reason: "DeviceModeMissing",
+			},
+		},
+		{
+			name: "Valid L3NetworkType",
+			network: &networkv1.Network{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: networkName,
+				},
+				Spec: networkv1.NetworkSpec{
+					Type:          networkv1.L3NetworkType,
+					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
+				},
+			},
+			paramSet: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: gkeNetworkParamSetName,
+				},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           nonDefaultTestNetworkName,
+					VPCSubnet:     subnetName,
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{subnetSecondaryRangeName}},
+				},
+			},
+			expectedCondition: metav1.Condition{
+				Type:   "ParamsReady",
+				Status: metav1.ConditionTrue,
+				Reason: "GNPParamsReady",
+			},
+		},
+		{
+			name: "Dual-stack L3NetworkType with missing PodIPv6Ranges",
 			network: &networkv1.Network{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: networkName,
 				},
 				Spec: networkv1.NetworkSpec{
 					Type:          networkv1.L3NetworkType,
+					IPAMMode6:     &internalMode,
+					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
+				},
+			},
+			paramSet: &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: gkeNetworkParamSetName,
+				},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           nonDefaultTestNetworkName,
+					VPCSubnet:     subnetName,
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{subnetSecondaryRangeName}},
+				},
+			},
+			expectedCondition: metav1.Condition{
+				Type:   "ParamsReady",
+				Status: metav1.ConditionFalse,
+				Reason: "L3IPv6RangesMissing",
+			},
+		},
+		{
+			name: "Valid DeviceNetworkType",
+			network: &networkv1.Network{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: networkName,
+				},
+				Spec: networkv1.NetworkSpec{
+					Type:          networkv1.DeviceNetworkType,
 					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
 				},
 			},
@@ -840,12 +1494,12 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 			},
 			expectedCondition: metav1.Condition{
 				Type:   "ParamsReady",
-				Status: metav1.ConditionFalse,
-				Reason: "L3SecondaryMissing",
+				Status: metav1.ConditionTrue,
+				Reason: "GNPParamsReady",
 			},
 		},
 		{
-			name: "DeviceNetworkType with missing DeviceMode",
+			name: "DeviceNetworkType with DPDK-VFIO DeviceMode and jumbo frames VPC",
 			network: &networkv1.Network{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: networkName,
@@ -860,25 +1514,26 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 					Name: gkeNetworkParamSetName,
 				},
 				Spec: networkv1.GKENetworkParamSetSpec{
-					VPC:           nonDefaultTestNetworkName,
-					VPCSubnet:     subnetName,
-					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{subnetSecondaryRangeName}},
+					VPC:        nonDefaultTestNetworkName,
+					VPCSubnet:  subnetName,
+					DeviceMode: networkv1.DPDKVFIO,
 				},
 			},
+			vpcMTU: 8896,
 			expectedCondition: metav1.Condition{
 				Type:   "ParamsReady",
 				Status: metav1.ConditionFalse,
-				Reason: "DeviceModeMissing",
+				Reason: "DeviceModeMTUUnsupported",
 			},
 		},
 		{
-			name: "Valid L3NetworkType",
+			name: "Valid DeviceNetworkType with DPDK-VFIO DeviceMode",
 			network: &networkv1.Network{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: networkName,
 				},
 				Spec: networkv1.NetworkSpec{
-					Type:          networkv1.L3NetworkType,
+					Type:          networkv1.DeviceNetworkType,
 					ParametersRef: &networkv1.NetworkParametersReference{Name: gkeNetworkParamSetName, Kind: gnpKind},
 				},
 			},
@@ -887,11 +1542,12 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 					Name: gkeNetworkParamSetName,
 				},
 				Spec: networkv1.GKENetworkParamSetSpec{
-					VPC:           nonDefaultTestNetworkName,
-					VPCSubnet:     subnetName,
-					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{subnetSecondaryRangeName}},
+					VPC:        nonDefaultTestNetworkName,
+					VPCSubnet:  subnetName,
+					DeviceMode: networkv1.DPDKVFIO,
 				},
 			},
+			vpcMTU: 1460,
 			expectedCondition: metav1.Condition{
 				Type:   "ParamsReady",
 				Status: metav1.ConditionTrue,
@@ -899,7 +1555,7 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 			},
 		},
 		{
-			name: "Valid DeviceNetworkType",
+			name: "Valid DeviceNetworkType with NetDevice DeviceMode and jumbo frames VPC",
 			network: &networkv1.Network{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: networkName,
@@ -919,6 +1575,7 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 					DeviceMode: networkv1.NetDevice,
 				},
 			},
+			vpcMTU: 8896,
 			expectedCondition: metav1.Condition{
 				Type:   "ParamsReady",
 				Status: metav1.ConditionTrue,
@@ -977,6 +1634,16 @@ func TestCrossValidateNetworkAndGnp(t *testing.T) {
 				t.Error(err)
 			}
 
+			if test.vpcMTU != 0 {
+				networkKey := meta.GlobalKey(nonDefaultTestNetworkName)
+				if err := testVals.cloud.Compute().Networks().Delete(ctx, networkKey); err != nil {
+					t.Fatal(err)
+				}
+				if err := testVals.cloud.Compute().Networks().Insert(ctx, networkKey, &compute.Network{Name: nonDefaultTestNetworkName, Mtu: test.vpcMTU}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
 			testVals.runGKENetworkParamSetController(ctx)
 
 			_, err = testVals.networkClient.NetworkingV1().Networks().Create(ctx, test.network, metav1.CreateOptions{})
@@ -1167,6 +1834,403 @@ func TestHandleGKENetworkParamSetDelete_NetworkInUse(t *testing.T) {
 	}
 }
 
+func TestDefaultNetworkOwnedAndGarbageCollectedWithGNP(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	testVals := setupGKENetworkParamSetController(ctx)
+
+	subnetKey := meta.RegionalKey(defaultTestSubnetworkName, testVals.clusterValues.Region)
+	subnet := &compute.Subnetwork{
+		Name: defaultTestSubnetworkName,
+		SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+			{
+				IpCidrRange: defaultPodCIDR,
+				RangeName:   defaultPodRange,
+			},
+		},
+	}
+	err := testVals.cloud.Compute().Subnetworks().Insert(ctx, subnetKey, subnet)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testVals.runGKENetworkParamSetController(ctx)
+
+	_, err = testVals.networkClient.NetworkingV1().Networks().Create(ctx, newL3Network(networkv1.DefaultPodNetworkName), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create Network: %v", err)
+	}
+	// The addon manager reconciles the default paramset, so the controller
+	// does not populate it.
+	paramSet := newL3GNP(networkv1.DefaultPodNetworkName, []string{defaultPodRange}, &gnpOptions{testAddonMode: reconcileMode})
+	paramSet.UID = "gnp-uid"
+	_, err = testVals.networkClient.NetworkingV1().GKENetworkParamSets().Create(ctx, paramSet, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create default GKENetworkParamSet: %v", err)
+	}
+
+	g.Eventually(func() ([]metav1.OwnerReference, error) {
+		network, err := testVals.networkClient.NetworkingV1().Networks().Get(ctx, networkv1.DefaultPodNetworkName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return network.OwnerReferences, nil
+	}).Should(gomega.ConsistOf(gnpOwnerReference(paramSet)), "default Network should be owned by the default GKENetworkParamSet")
+
+	g.Eventually(func() (bool, error) {
+		return testVals.doesGNPFinalizerExist(ctx, networkv1.DefaultPodNetworkName)
+	}).Should(gomega.BeTrue(), "finalizer should exist on GKENetworkParamSet")
+
+	newParamset, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, networkv1.DefaultPodNetworkName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get GKENetworkParamSet: %v", err)
+	}
+	// simulate a delete on GNP resource
+	now := metav1.Now()
+	newParamset.SetDeletionTimestamp(&now)
+	_, err = testVals.networkClient.NetworkingV1().GKENetworkParamSets().Update(ctx, newParamset, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to update GKENetworkParamSet: %v", err)
+	}
+	g.Eventually(func() (bool, error) {
+		return testVals.doesGNPFinalizerExist(ctx, networkv1.DefaultPodNetworkName)
+	}).Should(gomega.BeFalse(), "finalizer should be removed from GKENetworkParamSet")
+
+	// The finalizer was removed, we need to manually handle the deletion
+	err = testVals.networkClient.NetworkingV1().GKENetworkParamSets().Delete(ctx, networkv1.DefaultPodNetworkName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to delete GKENetworkParamSet: %v", err)
+	}
+
+	g.Eventually(func() bool {
+		_, err := testVals.networkClient.NetworkingV1().Networks().Get(ctx, networkv1.DefaultPodNetworkName, metav1.GetOptions{})
+		return errors.IsNotFound(err)
+	}).Should(gomega.BeTrue(), "default Network should be deleted with the default GKENetworkParamSet")
+}
+
+func TestNodeCountOfParamSet(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	testVals := setupGKENetworkParamSetController(ctx)
+
+	subnetName := "test-subnet"
+	subnetSecondaryRangeName := "test-secondary-range"
+	subnetKey := meta.RegionalKey(subnetName, testVals.clusterValues.Region)
+	subnet := &compute.Subnetwork{
+		Name: subnetName,
+		SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+			{
+				IpCidrRange: "10.0.0.0/24",
+				RangeName:   subnetSecondaryRangeName,
+			},
+		},
+	}
+	if err := testVals.cloud.Compute().Subnetworks().Insert(ctx, subnetKey, subnet); err != nil {
+		t.Error(err)
+	}
+
+	networkName := "test-network"
+	for _, node := range []*v1.Node{
+		nodeWithNetworks("node-with-network", `[{"name":"test-network","cidrs":["10.0.0.0/28"],"scope":"host-local"}]`),
+		nodeWithNetworks("node-with-networks", `[{"name":"other-network","cidrs":["10.1.0.0/28"]},{"name":"test-network","cidrs":["10.0.0.16/28"]}]`),
+		nodeWithNetworks("node-without-range", `[{"name":"test-network","cidrs":[]}]`),
+		nodeWithNetworks("node-without-network", ""),
+	} {
+		if err := testVals.nodeStore.Add(node); err != nil {
+			t.Fatalf("Failed to add node %q: %v", node.Name, err)
+		}
+	}
+
+	testVals.runGKENetworkParamSetController(ctx)
+
+	_, err := testVals.networkClient.NetworkingV1().Networks().Create(ctx, newL3Network(networkName), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create Network: %v", err)
+	}
+	paramSet := newL3GNP(networkName, []string{subnetSecondaryRangeName}, &gnpOptions{subnet: subnetName})
+	_, err = testVals.networkClient.NetworkingV1().GKENetworkParamSets().Create(ctx, paramSet, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create GKENetworkParamSet: %v", err)
+	}
+
+	g.Eventually(func() (int32, error) {
+		paramSet, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, networkName, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return paramSet.Status.NodeCount, nil
+	}).Should(gomega.Equal(int32(2)), "GKENetworkParamSet Status should count the nodes with Pod ranges from its Network")
+}
+
+func TestNodePodNetworks(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		node     *v1.Node
+		expected []string
+	}{
+		{
+			desc:     "no Pod ranges",
+			node:     nodeWithNetworks("node", ""),
+			expected: []string{},
+		},
+		{
+			desc: "default network PodCIDRs",
+			node: func() *v1.Node {
+				node := nodeWithNetworks("node", `[{"name":"test-network","cidrs":["10.0.0.0/28"]}]`)
+				node.Spec.PodCIDRs = []string{"10.100.0.0/24"}
+				return node
+			}(),
+			expected: []string{networkv1.DefaultPodNetworkName, "test-network"},
+		},
+		{
+			desc:     "invalid annotation",
+			node:     nodeWithNetworks("node", `{`),
+			expected: []string{},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := nodePodNetworks(tc.node).List(); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("nodePodNetworks() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAuditPodRangeLabelsOfDeletedParamSet(t *testing.T) {
+	for _, tc := range []struct {
+		desc          string
+		remove        bool
+		deleted       *networkv1.GKENetworkParamSet
+		wantEvents    []string
+		wantPodRanges map[string]string
+	}{
+		{
+			desc:       "stale labels are reported",
+			deleted:    newL3GNP("deleted", []string{newPodRange1, newPodRange2}, nil),
+			wantEvents: []string{"Warning " + reasonStalePodRangeLabel},
+			wantPodRanges: map[string]string{
+				"stale-node":   newPodRange1,
+				"shared-node":  defaultPodRange,
+				"default-node": defaultPodRange,
+			},
+		},
+		{
+			desc:       "stale labels are removed",
+			remove:     true,
+			deleted:    newL3GNP("deleted", []string{newPodRange1, newPodRange2}, nil),
+			wantEvents: []string{"Normal " + reasonStalePodRangeLabelRemoved},
+			wantPodRanges: map[string]string{
+				"stale-node":   "",
+				"shared-node":  defaultPodRange,
+				"default-node": defaultPodRange,
+			},
+		},
+		{
+			desc:    "default paramset is not audited",
+			remove:  true,
+			deleted: newL3GNP(networkv1.DefaultPodNetworkName, []string{defaultPodRange}, nil),
+			wantPodRanges: map[string]string{
+				"stale-node":   newPodRange1,
+				"shared-node":  defaultPodRange,
+				"default-node": defaultPodRange,
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			testVals := setupGKENetworkParamSetController(ctx)
+			recorder := record.NewFakeRecorder(10)
+			testVals.controller.recorder = recorder
+			testVals.controller.removeStalePodRangeLabels = tc.remove
+
+			gnpStore := testVals.informerFactory.Networking().V1().GKENetworkParamSets().Informer().GetStore()
+			for _, params := range []*networkv1.GKENetworkParamSet{
+				tc.deleted,
+				newL3GNP("other", []string{defaultPodRange, newPodRange2}, nil),
+			} {
+				if err := gnpStore.Add(params); err != nil {
+					t.Fatalf("Failed to add GKENetworkParamSet %q: %v", params.Name, err)
+				}
+			}
+			nodes := map[string]string{
+				"stale-node":   newPodRange1,
+				"shared-node":  defaultPodRange,
+				"default-node": defaultPodRange,
+			}
+			for name, rangeName := range nodes {
+				node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{utilnode.NodePoolPodRangeLabelPrefix: rangeName}}}
+				if err := testVals.nodeStore.Add(node); err != nil {
+					t.Fatalf("Failed to add node %q: %v", name, err)
+				}
+				if _, err := testVals.kubeClient.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+					t.Fatalf("Failed to create node %q: %v", name, err)
+				}
+			}
+
+			if err := testVals.controller.auditPodRangeLabels(tc.deleted); err != nil {
+				t.Fatalf("auditPodRangeLabels() = %v", err)
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != len(tc.wantEvents) {
+				t.Fatalf("got events %v, want %v", events, tc.wantEvents)
+			}
+			for i, want := range tc.wantEvents {
+				if !strings.HasPrefix(events[i], want) {
+					t.Errorf("got event %q, want %q", events[i], want)
+				}
+			}
+			for name, want := range tc.wantPodRanges {
+				node, err := testVals.kubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Failed to get node %q: %v", name, err)
+				}
+				if got := node.Labels[utilnode.NodePoolPodRangeLabelPrefix]; got != want {
+					t.Errorf("node %q has Pod range label %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// nodeWithNetworks returns a node with the given multi-network annotation, if not empty.
+func nodeWithNetworks(name, networks string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+	if networks != "" {
+		node.Annotations[networkv1.MultiNetworkAnnotationKey] = networks
+	}
+	return node
+}
+
+func TestValidationFailureEvents(t *testing.T) {
+	for _, tc := range []struct {
+		desc        string
+		networkType networkv1.NetworkType
+		subnetName  string
+		rangeName   string
+		wantReason  string
+	}{
+		{
+			desc:        "subnet validation",
+			networkType: networkv1.L3NetworkType,
+			subnetName:  "missing-subnet",
+			rangeName:   "test-secondary-range",
+			wantReason:  string(networkv1.SubnetNotFound),
+		},
+		{
+			// the subnet validation succeeding before does not make the
+			// failure look new
+			desc:        "paramset validation",
+			networkType: networkv1.L3NetworkType,
+			subnetName:  "test-subnet",
+			rangeName:   "missing-range",
+			wantReason:  string(networkv1.SecondaryRangeNotFound),
+		},
+		{
+			desc:        "network cross validation",
+			networkType: networkv1.DeviceNetworkType,
+			subnetName:  "test-subnet",
+			rangeName:   "test-secondary-range",
+			wantReason:  string(networkv1.DeviceModeMissing),
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			testVals := setupGKENetworkParamSetController(ctx)
+			recorder := record.NewFakeRecorder(10)
+			testVals.controller.recorder = recorder
+
+			subnet := &compute.Subnetwork{
+				Name: "test-subnet",
+				SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+					{IpCidrRange: "10.0.0.0/24", RangeName: "test-secondary-range"},
+				},
+			}
+			if err := testVals.cloud.Compute().Subnetworks().Insert(ctx, meta.RegionalKey(subnet.Name, testVals.clusterValues.Region), subnet); err != nil {
+				t.Fatal(err)
+			}
+			params := &networkv1.GKENetworkParamSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"},
+				Spec: networkv1.GKENetworkParamSetSpec{
+					VPC:           defaultTestNetworkName,
+					VPCSubnet:     tc.subnetName,
+					PodIPv4Ranges: &networkv1.SecondaryRanges{RangeNames: []string{tc.rangeName}},
+				},
+			}
+			network := &networkv1.Network{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-network"},
+				Spec: networkv1.NetworkSpec{
+					Type:          tc.networkType,
+					ParametersRef: &networkv1.NetworkParametersReference{Name: params.Name, Kind: gnpKind},
+				},
+			}
+			network, err := testVals.networkClient.NetworkingV1().Networks().Create(ctx, network, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			networkStore := testVals.informerFactory.Networking().V1().Networks().Informer().GetStore()
+			if err := networkStore.Add(network); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := testVals.controller.syncGNP(ctx, params); err != nil {
+				t.Fatalf("syncGNP() = %v", err)
+			}
+			// The failure is only recorded once it newly fails.
+			if tc.networkType == networkv1.DeviceNetworkType {
+				network, err = testVals.networkClient.NetworkingV1().Networks().Get(ctx, network.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := networkStore.Update(network); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := testVals.controller.syncGNP(ctx, params); err != nil {
+				t.Fatalf("syncGNP() = %v", err)
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			want := []string{
+				"Warning " + tc.wantReason + " ",
+				"Warning " + tc.wantReason + " GKENetworkParamSet " + params.Name + ": ",
+			}
+			if len(events) != len(want) {
+				t.Fatalf("got events %v, want %v", events, want)
+			}
+			for i := range want {
+				if !strings.HasPrefix(events[i], want[i]) {
+					t.Errorf("got event %q, want %q", events[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEventScheme(t *testing.T) {
+	for _, obj := range []runtime.Object{
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}},
+		&networkv1.GKENetworkParamSet{ObjectMeta: metav1.ObjectMeta{Name: "test-paramset"}},
+		&networkv1.Network{ObjectMeta: metav1.ObjectMeta{Name: "test-network"}},
+	} {
+		if _, err := reference.GetReference(eventScheme, obj); err != nil {
+			t.Errorf("GetReference(%T) = %v", obj, err)
+		}
+	}
+}
+
 func (testVals *testGKENetworkParamSetController) doesGNPFinalizerExist(ctx context.Context, gkeNetworkParamSetName string) (bool, error) {
 	paramSet, err := testVals.networkClient.NetworkingV1().GKENetworkParamSets().Get(ctx, gkeNetworkParamSetName, metav1.GetOptions{})
 	if err != nil {
@@ -1552,3 +2616,53 @@ func TestSameStringSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestParseClusterCIDRs(t *testing.T) {
+	tests := []struct {
+		name         string
+		clusterCIDRs string
+		want         []string
+		wantErr      bool
+	}{
+		{
+			name:         "single stack",
+			clusterCIDRs: "10.0.0.0/16",
+			want:         []string{"10.0.0.0/16"},
+		},
+		{
+			name:         "dual stack",
+			clusterCIDRs: " 10.0.0.0/16,fd00::/64 ",
+			want:         []string{"10.0.0.0/16", "fd00::/64"},
+		},
+		{
+			name:         "empty",
+			clusterCIDRs: "",
+			wantErr:      true,
+		},
+		{
+			name:         "not dual stack",
+			clusterCIDRs: "10.0.0.0/16,10.1.0.0/16",
+			wantErr:      true,
+		},
+		{
+			name:         "more than one per IP family",
+			clusterCIDRs: "10.0.0.0/16,fd00::/64,10.1.0.0/16",
+			wantErr:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cidrs, err := ParseClusterCIDRs(tc.clusterCIDRs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseClusterCIDRs(%q) returns error %v but want error %v", tc.clusterCIDRs, err, tc.wantErr)
+			}
+			var got []string
+			for _, cidr := range cidrs {
+				got = append(got, cidr.String())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("ParseClusterCIDRs(%q) returns unexpected CIDRs (-want +got):\n%s", tc.clusterCIDRs, diff)
+			}
+		})
+	}
+}
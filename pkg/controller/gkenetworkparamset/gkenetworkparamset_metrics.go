@@ -20,6 +20,15 @@ var (
 		},
 		[]string{"status", "type"},
 	)
+	gnpNodes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      GKENetworkParamSetSubsystem,
+			Name:           "gnp_nodes",
+			Help:           "Gauge measuring number of Nodes with Pod ranges from each GKENetworkParamSet.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"name"},
+	)
 )
 
 var registerGNPMetrics sync.Once
@@ -27,6 +36,6 @@ var registerGNPMetrics sync.Once
 // registerGKENetworkParamSetMetrics registers GKENetworkParamSet metrics.
 func registerGKENetworkParamSetMetrics() {
 	registerGNPMetrics.Do(func() {
-		legacyregistry.MustRegister(gnpObjects)
+		legacyregistry.MustRegister(gnpObjects, gnpNodes)
 	})
 }
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gkenetworkparamset
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	networkv1 "k8s.io/cloud-provider-gcp/crd/apis/network/v1"
+	utilnode "k8s.io/cloud-provider-gcp/pkg/util/node"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// reasonStalePodRangeLabel is the reason of the events of the nodes
+	// whose Pod range label references a Pod range of a deleted
+	// GKENetworkParamSet.
+	reasonStalePodRangeLabel = "StalePodRangeLabel"
+	// reasonStalePodRangeLabelRemoved is the reason of the events of the
+	// nodes whose stale Pod range label was removed.
+	reasonStalePodRangeLabelRemoved = "StalePodRangeLabelRemoved"
+)
+
+// auditPodRangeLabels reports the nodes whose Pod range label references one
+// of the Pod ranges of params, which is being deleted, unless another
+// GKENetworkParamSet still has the range. The labels are removed if
+// removeStalePodRangeLabels is set, so that IPAM does not allocate from the
+// ranges anymore.
+//
+// The default GKENetworkParamSet is recreated with the Pod ranges of the node
+// labels, so its deletion is not audited.
+func (c *Controller) auditPodRangeLabels(params *networkv1.GKENetworkParamSet) error {
+	if params.Name == networkv1.DefaultPodNetworkName || !hasRangeNames(params) {
+		return nil
+	}
+
+	staleRanges := sets.NewString(params.Spec.PodIPv4Ranges.RangeNames...)
+	allParams, err := c.gkeNetworkParamsInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list GKENetworkParamSets from cache: %w", err)
+	}
+	for _, other := range allParams {
+		if other.Name == params.Name || other.DeletionTimestamp != nil || !hasRangeNames(other) {
+			continue
+		}
+		staleRanges.Delete(other.Spec.PodIPv4Ranges.RangeNames...)
+	}
+	if staleRanges.Len() == 0 {
+		return nil
+	}
+
+	selector, err := labels.Parse(utilnode.NodePoolPodRangeLabelPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to parse label selector %v: %w", utilnode.NodePoolPodRangeLabelPrefix, err)
+	}
+	nodesWithLabel, err := c.nodeLister.List(selector)
+	if err != nil {
+		return fmt.Errorf("failed to list node from cache: %w", err)
+	}
+	var errs error
+	for _, node := range nodesWithLabel {
+		rangeName := node.Labels[utilnode.NodePoolPodRangeLabelPrefix]
+		if !staleRanges.Has(rangeName) {
+			continue
+		}
+		if !c.removeStalePodRangeLabels {
+			klog.Warningf("Label %s of node %q references Pod range %q of deleted GKENetworkParamSet %q", utilnode.NodePoolPodRangeLabelPrefix, node.Name, rangeName, params.Name)
+			c.recorder.Eventf(node, v1.EventTypeWarning, reasonStalePodRangeLabel, "Label %s references Pod range %q of deleted GKENetworkParamSet %q", utilnode.NodePoolPodRangeLabelPrefix, rangeName, params.Name)
+			continue
+		}
+		if err := utilnode.RemoveNodeLabel(c.kubeClient, types.NodeName(node.Name), utilnode.NodePoolPodRangeLabelPrefix); err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		klog.Infof("Removed label %s of node %q referencing Pod range %q of deleted GKENetworkParamSet %q", utilnode.NodePoolPodRangeLabelPrefix, node.Name, rangeName, params.Name)
+		c.recorder.Eventf(node, v1.EventTypeNormal, reasonStalePodRangeLabelRemoved, "Removed label %s referencing Pod range %q of deleted GKENetworkParamSet %q", utilnode.NodePoolPodRangeLabelPrefix, rangeName, params.Name)
+	}
+	return errs
+}
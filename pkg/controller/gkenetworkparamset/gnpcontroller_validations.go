@@ -19,14 +19,18 @@ package gkenetworkparamset
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"google.golang.org/api/compute/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	networkv1 "k8s.io/cloud-provider-gcp/crd/apis/network/v1"
 	utilnode "k8s.io/cloud-provider-gcp/pkg/util/node"
+	"k8s.io/cloud-provider-gcp/providers/gce"
 	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
 	"k8s.io/utils/strings/slices"
 )
 
@@ -53,6 +57,41 @@ func (val *gnpValidation) toCondition() metav1.Condition {
 	return condition
 }
 
+// setValidationCondition sets the condition of the validation of params.
+func setValidationCondition(params *networkv1.GKENetworkParamSet, validation *gnpValidation) {
+	meta.SetStatusCondition(&params.Status.Conditions, validation.toCondition())
+}
+
+// recordValidationConditionChange records a validation of params newly failing
+// with events, see recordValidationFailure. The validations set the condition
+// in turn, so the final condition is compared with original, the condition
+// before the sync, rather than each condition set.
+func (c *Controller) recordValidationConditionChange(params *networkv1.GKENetworkParamSet, original *metav1.Condition) {
+	condition := meta.FindStatusCondition(params.Status.Conditions, string(networkv1.GKENetworkParamSetStatusReady))
+	if condition == nil || condition.Status != metav1.ConditionFalse {
+		return
+	}
+	if original != nil && original.Status == condition.Status && original.Reason == condition.Reason && original.Message == condition.Message {
+		return
+	}
+	network, err := c.getNetworkReferringToGNP(params.Name)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get the Network referencing GKENetworkParamSet", "gnp", params.Name)
+	}
+	c.recordValidationFailure(params, network, *condition)
+}
+
+// recordValidationFailure records the failed validation reported by
+// condition with a warning event on params and on network, the Network
+// referencing params if not nil, so that the failure shows in their
+// descriptions. The reason of the events is the reason of the condition.
+func (c *Controller) recordValidationFailure(params *networkv1.GKENetworkParamSet, network *networkv1.Network, condition metav1.Condition) {
+	c.recorder.Event(params, v1.EventTypeWarning, condition.Reason, condition.Message)
+	if network != nil {
+		c.recorder.Eventf(network, v1.EventTypeWarning, condition.Reason, "GKENetworkParamSet %s: %s", params.Name, condition.Message)
+	}
+}
+
 // getAndValidateSubnet validates that the subnet is present in params and exists in GCP.
 func (c *Controller) getAndValidateSubnet(ctx context.Context, params *networkv1.GKENetworkParamSet) (*compute.Subnetwork, *gnpValidation) {
 	if params.Spec.VPCSubnet == "" {
@@ -66,10 +105,14 @@ func (c *Controller) getAndValidateSubnet(ctx context.Context, params *networkv1
 	// Check if Subnet exists
 	subnet, err := c.gceCloud.GetSubnetwork(c.gceCloud.Region(), params.Spec.VPCSubnet)
 	if err != nil || subnet == nil {
+		message := fmt.Sprintf("subnet: %s not found in VPC: %s", params.Spec.VPCSubnet, params.Spec.VPC)
+		if c.gceCloud.OnXPN() {
+			message = fmt.Sprintf("subnet: %s not found in VPC: %s of host project: %s", params.Spec.VPCSubnet, params.Spec.VPC, c.gceCloud.NetworkProjectID())
+		}
 		return nil, &gnpValidation{
 			IsValid:      false,
 			ErrorReason:  networkv1.SubnetNotFound,
-			ErrorMessage: fmt.Sprintf("subnet: %s not found in VPC: %s", params.Spec.VPCSubnet, params.Spec.VPC),
+			ErrorMessage: message,
 		}
 	}
 
@@ -87,7 +130,12 @@ func (c *Controller) validateGKENetworkParamSet(ctx context.Context, params *net
 		}, nil
 	}
 
-	if !c.gceCloud.OnXPN() {
+	if c.gceCloud.OnXPN() {
+		validation, err := c.validateHostProjectVPC(params, subnet)
+		if err != nil || !validation.IsValid {
+			return validation, err
+		}
+	} else {
 		network, err := c.gceCloud.GetNetwork(params.Spec.VPC)
 		if err != nil || network == nil {
 			return &gnpValidation{
@@ -101,7 +149,7 @@ func (c *Controller) validateGKENetworkParamSet(ctx context.Context, params *net
 	// check if both deviceMode and secondary ranges are unspecified
 	isSecondaryRangeSpecified := hasRangeNames(params)
 	isDeviceModeSpecified := params.Spec.DeviceMode != ""
-	if !isSecondaryRangeSpecified && !isDeviceModeSpecified {
+	if !isSecondaryRangeSpecified && !isDeviceModeSpecified && !hasIPv6Ranges(params) {
 		return &gnpValidation{
 			IsValid:      false,
 			ErrorReason:  networkv1.SecondaryRangeAndDeviceModeUnspecified,
@@ -120,11 +168,7 @@ func (c *Controller) validateGKENetworkParamSet(ctx context.Context, params *net
 				}
 			}
 			if !found {
-				return &gnpValidation{
-					IsValid:      false,
-					ErrorReason:  networkv1.SecondaryRangeNotFound,
-					ErrorMessage: fmt.Sprintf("secondary range: %s not found in subnet: %s", rangeName, params.Spec.VPCSubnet),
-				}, nil
+				return c.validateSecondaryRangeNotFound(params, rangeName), nil
 			}
 		}
 	}
@@ -138,6 +182,13 @@ func (c *Controller) validateGKENetworkParamSet(ctx context.Context, params *net
 		}, nil
 	}
 
+	// Check if the IPv6 ranges are within the internal IPv6 range of the subnet
+	if hasIPv6Ranges(params) {
+		if validation := validatePodIPv6Ranges(params, subnet); !validation.IsValid {
+			return validation, nil
+		}
+	}
+
 	//if GNP with deviceMode and The referencing VPC is the default VPC
 	if isDeviceModeSpecified {
 		networkResource, err := cloud.ParseResourceURL(c.gceCloud.NetworkURL())
@@ -186,6 +237,156 @@ func (c *Controller) validateGKENetworkParamSet(ctx context.Context, params *net
 	return &gnpValidation{IsValid: true}, nil
 }
 
+// validateHostProjectVPC validates the VPC of params on a Shared VPC cluster,
+// where the networks and the subnetworks are looked up in the host project
+// with the credentials of the service project. A service project is allowed
+// to use the subnets of its host project, not necessarily to get its VPCs: the
+// VPC is then only validated through the network of the subnet.
+func (c *Controller) validateHostProjectVPC(params *networkv1.GKENetworkParamSet, subnet *compute.Subnetwork) (*gnpValidation, error) {
+	hostProject := c.gceCloud.NetworkProjectID()
+	network, err := c.gceCloud.GetNetwork(params.Spec.VPC)
+	switch {
+	case gce.IsHTTPErrorCode(err, http.StatusNotFound), err == nil && network == nil:
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.VPCNotFound,
+			ErrorMessage: fmt.Sprintf("VPC: %s not found in host project: %s", params.Spec.VPC, hostProject),
+		}, nil
+	case gce.IsHTTPErrorCode(err, http.StatusForbidden):
+		klog.V(4).Infof("Not allowed to get VPC %s of host project %s, validating it through subnet %s: %v", params.Spec.VPC, hostProject, subnet.Name, err)
+	case err != nil:
+		return nil, err
+	}
+
+	subnetNetwork, err := cloud.ParseResourceURL(subnet.Network)
+	if err != nil {
+		klog.V(4).Infof("Not validating the VPC of subnet %s of host project %s with network URL %q: %v", subnet.Name, hostProject, subnet.Network, err)
+		return &gnpValidation{IsValid: true}, nil
+	}
+	if subnetNetwork.Key.Name != params.Spec.VPC {
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.SubnetNotFound,
+			ErrorMessage: fmt.Sprintf("subnet: %s of host project: %s belongs to VPC: %s, not to VPC: %s", subnet.Name, hostProject, subnetNetwork.Key.Name, params.Spec.VPC),
+		}, nil
+	}
+	return &gnpValidation{IsValid: true}, nil
+}
+
+// validatePodIPv6Ranges validates the PodIPv6Ranges of params, which are only
+// valid along with PodIPv4Ranges: the Pods of an L3 network are dual-stack or
+// IPv4 single-stack. The ranges must be within the internal IPv6 range of the
+// subnet, GCE subnets have no named IPv6 secondary ranges.
+func validatePodIPv6Ranges(params *networkv1.GKENetworkParamSet, subnet *compute.Subnetwork) *gnpValidation {
+	if params.Spec.DeviceMode != "" {
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.DeviceModeCantBeUsedWithSecondaryRange,
+			ErrorMessage: "deviceMode and IPv6 ranges can not be specified at the same time",
+		}
+	}
+	if !hasRangeNames(params) {
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.PodIPv6RangesWithoutIPv4Ranges,
+			ErrorMessage: "IPv6 ranges can only be specified along with IPv4 secondary ranges",
+		}
+	}
+
+	subnetRange := subnetInternalIPv6Range(subnet)
+	_, subnetCIDR, err := netutils.ParseCIDRSloppy(subnetRange)
+	if subnetRange == "" || err != nil {
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.SubnetInternalIPv6RangeNotFound,
+			ErrorMessage: fmt.Sprintf("subnet: %s has no internal IPv6 range", params.Spec.VPCSubnet),
+		}
+	}
+	subnetPrefix, _ := subnetCIDR.Mask.Size()
+	for _, cidr := range params.Spec.PodIPv6Ranges.CIDRBlocks {
+		_, podCIDR, err := netutils.ParseCIDRSloppy(cidr)
+		if err == nil && netutils.IsIPv6CIDR(podCIDR) && subnetCIDR.Contains(podCIDR.IP) {
+			if podPrefix, _ := podCIDR.Mask.Size(); podPrefix >= subnetPrefix {
+				continue
+			}
+		}
+		return &gnpValidation{
+			IsValid:      false,
+			ErrorReason:  networkv1.PodIPv6RangeNotInSubnet,
+			ErrorMessage: fmt.Sprintf("IPv6 range: %s is not within internal IPv6 range: %s of subnet: %s", cidr, subnetRange, params.Spec.VPCSubnet),
+		}
+	}
+	return &gnpValidation{IsValid: true}
+}
+
+// subnetInternalIPv6Range returns the internal IPv6 range of the subnet, empty
+// if it has none.
+func subnetInternalIPv6Range(subnet *compute.Subnetwork) string {
+	if subnet.InternalIpv6Prefix != "" {
+		return subnet.InternalIpv6Prefix
+	}
+	if subnet.Ipv6AccessType == "INTERNAL" {
+		return subnet.Ipv6CidrRange
+	}
+	return ""
+}
+
+// validateSecondaryRangeNotFound returns the validation of a secondary range
+// not found in the subnet. The VPC is resolved in the network project, e.g.
+// the host project of a shared VPC, and the secondary ranges of the subnets
+// of its peered VPCs are reported as such: the Pod ranges are allocated from
+// the subnet of the nodes, the ranges of the peered VPCs can't be used.
+func (c *Controller) validateSecondaryRangeNotFound(params *networkv1.GKENetworkParamSet, rangeName string) *gnpValidation {
+	notFound := &gnpValidation{
+		IsValid:      false,
+		ErrorReason:  networkv1.SecondaryRangeNotFound,
+		ErrorMessage: fmt.Sprintf("secondary range: %s not found in subnet: %s", rangeName, params.Spec.VPCSubnet),
+	}
+	vpc, err := c.gceCloud.GetNetwork(params.Spec.VPC)
+	if err != nil || vpc == nil {
+		return notFound
+	}
+
+	var lookupFailure *gnpValidation
+	for _, peering := range vpc.Peerings {
+		peer, err := cloud.ParseResourceURL(peering.Network)
+		if err != nil {
+			klog.V(4).Infof("Ignoring peering %s of VPC %s with an invalid network URL %q: %v", peering.Name, params.Spec.VPC, peering.Network, err)
+			continue
+		}
+		subnets, err := c.gceCloud.ListSubnetworksInProject(peer.ProjectID, c.gceCloud.Region())
+		if err != nil {
+			if lookupFailure == nil {
+				lookupFailure = &gnpValidation{
+					IsValid:      false,
+					ErrorReason:  networkv1.SecondaryRangePeeringLookupFailed,
+					ErrorMessage: fmt.Sprintf("secondary range: %s not found in subnet: %s, and the subnets of VPC: %s of project: %s peered by: %s could not be listed: %v", rangeName, params.Spec.VPCSubnet, peer.Key.Name, peer.ProjectID, peering.Name, err),
+				}
+			}
+			continue
+		}
+		for _, subnet := range subnets {
+			network, err := cloud.ParseResourceURL(subnet.Network)
+			if err != nil || network.ProjectID != peer.ProjectID || network.Key.Name != peer.Key.Name {
+				continue
+			}
+			for _, sr := range subnet.SecondaryIpRanges {
+				if sr.RangeName == rangeName {
+					return &gnpValidation{
+						IsValid:      false,
+						ErrorReason:  networkv1.SecondaryRangeInPeeredVPC,
+						ErrorMessage: fmt.Sprintf("secondary range: %s belongs to subnet: %s of VPC: %s of project: %s peered by: %s (%s), not to subnet: %s", rangeName, subnet.Name, peer.Key.Name, peer.ProjectID, peering.Name, peering.State, params.Spec.VPCSubnet),
+					}
+				}
+			}
+		}
+	}
+	if lookupFailure != nil {
+		return lookupFailure
+	}
+	return notFound
+}
+
 type gnpNetworkCrossValidation struct {
 	IsValid      bool
 	ErrorReason  networkv1.GNPNetworkParamsReadyConditionReason
@@ -209,8 +410,30 @@ func (val *gnpNetworkCrossValidation) toCondition() metav1.Condition {
 	return condition
 }
 
-// crossValidateNetworkAndGnp validates a given network and GNP object are compatible
-func crossValidateNetworkAndGnp(network *networkv1.Network, params *networkv1.GKENetworkParamSet) *gnpNetworkCrossValidation {
+const (
+	// defaultVPCMTU is the MTU of the VPCs created without an explicit MTU.
+	defaultVPCMTU = 1460
+)
+
+// deviceModeMaxMTU is the maximum VPC MTU supported by the device modes, for
+// the device modes that do not support all the VPC MTUs. The gVNIC DPDK poll
+// mode driver does not support jumbo frames.
+var deviceModeMaxMTU = map[networkv1.DeviceModeType]int64{
+	networkv1.DPDKVFIO: defaultVPCMTU,
+}
+
+// vpcMTU returns the MTU of the VPC.
+func vpcMTU(vpc *compute.Network) int64 {
+	if vpc.Mtu == 0 {
+		return defaultVPCMTU
+	}
+	return vpc.Mtu
+}
+
+// crossValidateNetworkAndGnp validates a given network and GNP object are compatible.
+// vpc is the VPC referenced by the GNP, nil if it is not known, e.g. on XPN
+// clusters, in which case the VPC constraints of the device mode are not validated.
+func crossValidateNetworkAndGnp(network *networkv1.Network, params *networkv1.GKENetworkParamSet, vpc *compute.Network) *gnpNetworkCrossValidation {
 	isSecondaryRangeSpecified := hasRangeNames(params)
 
 	if network.Spec.Type == networkv1.L3NetworkType {
@@ -221,6 +444,13 @@ func crossValidateNetworkAndGnp(network *networkv1.Network, params *networkv1.GK
 				ErrorMessage: "L3 type network requires secondary range to be specified in params",
 			}
 		}
+		if requiresIPv6Ranges(network) && !hasIPv6Ranges(params) {
+			return &gnpNetworkCrossValidation{
+				IsValid:      false,
+				ErrorReason:  networkv1.L3IPv6RangesMissing,
+				ErrorMessage: "dual-stack L3 type network requires IPv6 ranges to be specified in params",
+			}
+		}
 	}
 
 	if network.Spec.Type == networkv1.DeviceNetworkType {
@@ -231,6 +461,13 @@ func crossValidateNetworkAndGnp(network *networkv1.Network, params *networkv1.GK
 				ErrorMessage: "Device type network requires device mode to be specified in params",
 			}
 		}
+		if maxMTU, ok := deviceModeMaxMTU[params.Spec.DeviceMode]; ok && vpc != nil && vpcMTU(vpc) > maxMTU {
+			return &gnpNetworkCrossValidation{
+				IsValid:      false,
+				ErrorReason:  networkv1.DeviceModeMTUUnsupported,
+				ErrorMessage: fmt.Sprintf("MTU %d of VPC: %s exceeds the maximum MTU %d supported by device mode %s", vpcMTU(vpc), params.Spec.VPC, maxMTU, params.Spec.DeviceMode),
+			}
+		}
 	}
 
 	return &gnpNetworkCrossValidation{
@@ -276,6 +513,17 @@ func hasRangeNames(params *networkv1.GKENetworkParamSet) bool {
 	return false
 }
 
+// hasIPv6Ranges returns true if PodIPv6Ranges is specified with CIDR blocks
+func hasIPv6Ranges(params *networkv1.GKENetworkParamSet) bool {
+	return params.Spec.PodIPv6Ranges != nil && len(params.Spec.PodIPv6Ranges.CIDRBlocks) > 0
+}
+
+// requiresIPv6Ranges returns true if the network is dual-stack with the IPv6
+// Pod IPs allocated internally, from the IPv6 ranges of its params
+func requiresIPv6Ranges(network *networkv1.Network) bool {
+	return network.Spec.IPAMMode6 != nil && *network.Spec.IPAMMode6 == networkv1.InternalMode
+}
+
 // samePodIPv4Ranges returns true if both PodIPv4Rangess are nil or have the same RangeNames,
 // returns false if either one is nil or has differnent element in the RangeNames list
 func samePodIPv4Ranges(params *networkv1.GKENetworkParamSet, originalParams *networkv1.GKENetworkParamSet) bool {
@@ -0,0 +1,244 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthnotification
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-gcp/pkg/controller/deletednodes"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "healthnotification"
+
+	// reasonInstanceNotFound is the reason of the nodes deleted because
+	// their instance is gone.
+	reasonInstanceNotFound = "InstanceNotFound"
+
+	// The health states of the load balancers.
+	lbStateHealthy    = "Healthy"
+	lbStateUnhealthy  = "Unhealthy"
+	lbStateNoBackends = "NoBackends"
+)
+
+// Controller posts notifications to a webhook, for the incident management
+// tooling, on the health transitions of the L4 load balancers and on the
+// deletions of the nodes whose instance is gone.
+//
+// The health of the load balancers is polled, a transition is notified when the
+// state differs from the state at the previous poll, so the first poll after a
+// restart only records the states.
+type Controller struct {
+	nodeInformerSynced    cache.InformerSynced
+	serviceLister         corelisters.ServiceLister
+	serviceInformerSynced cache.InformerSynced
+	gceCloud              *gce.Cloud
+	clusterName           string
+	sink                  *webhookSink
+	lbHealthPeriod        time.Duration
+	now                   func() time.Time
+
+	// deletedNodes are the deleted nodes pending a check of their instance.
+	deletedNodes *deletednodes.Queue
+
+	// lbStates are the health states of the load balancers at the last poll,
+	// by Service key. Only used by the poll loop.
+	lbStates map[string]string
+}
+
+// NewHealthNotificationController returns a new health notification
+// controller, posting the notifications to webhookURL and polling the health
+// of the load balancers every lbHealthPeriod.
+func NewHealthNotificationController(
+	nodeInformer coreinformers.NodeInformer,
+	serviceInformer coreinformers.ServiceInformer,
+	gceCloud *gce.Cloud,
+	clusterName string,
+	webhookURL string,
+	lbHealthPeriod time.Duration,
+) *Controller {
+	c := &Controller{
+		nodeInformerSynced:    nodeInformer.Informer().HasSynced,
+		serviceLister:         serviceInformer.Lister(),
+		serviceInformerSynced: serviceInformer.Informer().HasSynced,
+		gceCloud:              gceCloud,
+		clusterName:           clusterName,
+		sink:                  newWebhookSink(webhookURL),
+		lbHealthPeriod:        lbHealthPeriod,
+		now:                   time.Now,
+		lbStates:              map[string]string{},
+	}
+	c.deletedNodes = deletednodes.NewQueue(workqueueName, nodeInformer, c.syncDeletedNode)
+	return c
+}
+
+// Run starts an asynchronous loop that notifies the deletions of the nodes,
+// and polls the health of the load balancers.
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	klog.Infof("Starting healthnotification controller")
+	defer klog.Infof("Shutting down healthnotification controller")
+	controllerManagerMetrics.ControllerStarted("healthnotification")
+	defer controllerManagerMetrics.ControllerStopped("healthnotification")
+
+	if !cache.WaitForNamedCacheSync("healthnotification", stopCh, c.nodeInformerSynced, c.serviceInformerSynced) {
+		return
+	}
+
+	c.deletedNodes.Run(ctx, numWorkers)
+	go wait.UntilWithContext(ctx, c.pollLoadBalancers, c.lbHealthPeriod)
+
+	<-stopCh
+}
+
+// syncDeletedNode notifies the deletion of the node if its instance is gone,
+// i.e. if the node was deleted by the cloud node lifecycle controller.
+func (c *Controller) syncDeletedNode(ctx context.Context, node *v1.Node) error {
+	name := node.Name
+	if node.Spec.ProviderID == "" {
+		return nil
+	}
+
+	exists, err := c.gceCloud.InstanceExistsByProviderID(ctx, node.Spec.ProviderID)
+	if err != nil {
+		return fmt.Errorf("failed to check the instance %s of node %q: %w", node.Spec.ProviderID, name, err)
+	}
+	if exists {
+		// The node was deleted by another client, e.g. on a scale down.
+		return nil
+	}
+	err = c.sink.post(ctx, &Notification{
+		Type:    NotificationTypeNodeDeleted,
+		Time:    c.now(),
+		Cluster: c.clusterName,
+		Kind:    "Node",
+		Name:    name,
+		Message: fmt.Sprintf("Node %s was deleted, its instance %s is gone", name, node.Spec.ProviderID),
+		Node:    &NodeDetails{ProviderID: node.Spec.ProviderID, Reason: reasonInstanceNotFound},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to notify the deletion of node %q: %w", name, err)
+	}
+	klog.Infof("Notified the deletion of node %q, its instance %s is gone", name, node.Spec.ProviderID)
+	return nil
+}
+
+// pollLoadBalancers notifies the health transitions of the load balancers of
+// the Services since the last poll.
+func (c *Controller) pollLoadBalancers(ctx context.Context) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list the Services: %v", err)
+		return
+	}
+	polled := map[string]bool{}
+	for _, svc := range services {
+		if !hasLoadBalancer(svc) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil {
+			continue
+		}
+		polled[key] = true
+		if err := c.pollLoadBalancer(ctx, key, svc); err != nil {
+			klog.Warningf("Failed to check the health of the load balancer of Service %s: %v", key, err)
+		}
+	}
+	for key := range c.lbStates {
+		if !polled[key] {
+			delete(c.lbStates, key)
+		}
+	}
+}
+
+// pollLoadBalancer polls the health summary of the load balancer, which is
+// enough to tell its state, and only fetches its full health to notify a
+// transition.
+func (c *Controller) pollLoadBalancer(ctx context.Context, key string, svc *v1.Service) error {
+	health, err := c.gceCloud.GetLoadBalancerHealthSummary(ctx, c.clusterName, svc)
+	if err != nil || health == nil {
+		return err
+	}
+	state := lbState(health)
+	previous, ok := c.lbStates[key]
+	if !ok || previous == state {
+		c.lbStates[key] = state
+		return nil
+	}
+	if health, err = c.gceCloud.GetLoadBalancerHealth(ctx, c.clusterName, svc); err != nil || health == nil {
+		return err
+	}
+	if state = lbState(health); previous == state {
+		return nil
+	}
+	err = c.sink.post(ctx, &Notification{
+		Type:      NotificationTypeLoadBalancerHealthChanged,
+		Time:      c.now(),
+		Cluster:   c.clusterName,
+		Kind:      "Service",
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+		Message:   fmt.Sprintf("Load balancer of Service %s is %s, it was %s: %d of %d backends are healthy", key, state, previous, health.HealthyBackends, health.Backends),
+		LoadBalancer: &LoadBalancerDetails{
+			PreviousState:   previous,
+			State:           state,
+			HealthyBackends: health.HealthyBackends,
+			Backends:        health.Backends,
+		},
+	})
+	if err != nil {
+		// The previous state is kept, the transition is notified at the
+		// next poll.
+		return fmt.Errorf("failed to notify the health transition from %s to %s: %w", previous, state, err)
+	}
+	klog.Infof("Notified the health transition of the load balancer of Service %s from %s to %s", key, previous, state)
+	c.lbStates[key] = state
+	return nil
+}
+
+// hasLoadBalancer returns true if the Service has a load balancer of the
+// provider.
+func hasLoadBalancer(svc *v1.Service) bool {
+	return svc.Spec.Type == v1.ServiceTypeLoadBalancer && svc.Spec.LoadBalancerClass == nil && len(svc.Status.LoadBalancer.Ingress) > 0
+}
+
+func lbState(health *gce.LoadBalancerHealth) string {
+	switch {
+	case health.Backends == 0:
+		return lbStateNoBackends
+	case health.HealthyBackends == 0:
+		return lbStateUnhealthy
+	}
+	return lbStateHealthy
+}
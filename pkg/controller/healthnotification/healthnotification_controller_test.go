@@ -0,0 +1,182 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthnotification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+)
+
+// fakeWebhook records the notifications, and fails the posts while failing
+// is set.
+type fakeWebhook struct {
+	lock          sync.Mutex
+	failing       bool
+	notifications []Notification
+}
+
+func (f *fakeWebhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failing {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var n Notification
+	if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.notifications = append(f.notifications, n)
+}
+
+func (f *fakeWebhook) take() []Notification {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	notifications := f.notifications
+	f.notifications = nil
+	return notifications
+}
+
+func newTestController(t *testing.T, webhook *fakeWebhook, objects ...*v1.Service) (*Controller, *gce.Cloud, gce.TestClusterValues) {
+	srv := httptest.NewServer(webhook)
+	t.Cleanup(srv.Close)
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0*time.Second)
+	controller := NewHealthNotificationController(informerFactory.Core().V1().Nodes(), informerFactory.Core().V1().Services(), fakeGCE, vals.ClusterName, srv.URL, time.Minute)
+	for _, svc := range objects {
+		if err := informerFactory.Core().V1().Services().Informer().GetStore().Add(svc); err != nil {
+			t.Fatalf("Failed to add Service: %v", err)
+		}
+	}
+	return controller, fakeGCE, vals
+}
+
+func TestSyncDeletedNode(t *testing.T) {
+	ctx := context.Background()
+	webhook := &fakeWebhook{}
+	controller, fakeGCE, vals := newTestController(t, webhook)
+	err := fakeGCE.Compute().Instances().Insert(ctx, meta.ZonalKey("scaled-down", vals.ZoneName), &compute.Instance{Name: "scaled-down", Zone: vals.ZoneName})
+	if err != nil {
+		t.Fatalf("Failed to insert instance: %v", err)
+	}
+
+	for _, name := range []string{"gone", "scaled-down"} {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{ProviderID: "gce://" + vals.ProjectID + "/" + vals.ZoneName + "/" + name},
+		}
+		controller.deletedNodes.Enqueue(node)
+		if err := controller.deletedNodes.Sync(ctx, name); err != nil {
+			t.Fatalf("Sync(%q) = %v", name, err)
+		}
+	}
+
+	notifications := webhook.take()
+	if len(notifications) != 1 {
+		t.Fatalf("Got notifications %+v, want one for node gone", notifications)
+	}
+	n := notifications[0]
+	if n.Type != NotificationTypeNodeDeleted || n.Kind != "Node" || n.Name != "gone" || n.Cluster != vals.ClusterName || n.Node == nil || n.Node.Reason != reasonInstanceNotFound {
+		t.Errorf("Got notification %+v, want a NodeDeleted notification of node gone", n)
+	}
+	if n := controller.deletedNodes.Len(); n != 0 {
+		t.Errorf("%d deleted nodes are still pending", n)
+	}
+}
+
+func TestPollLoadBalancers(t *testing.T) {
+	ctx := context.Background()
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "uid-svc"},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{{IP: "10.0.0.10"}}}},
+	}
+	webhook := &fakeWebhook{}
+	controller, fakeGCE, vals := newTestController(t, webhook, svc)
+
+	lbName := fakeGCE.GetLoadBalancerName(ctx, vals.ClusterName, svc)
+	mockGCE := fakeGCE.Compute().(*cloud.MockGCE)
+	if err := mockGCE.RegionBackendServices().Insert(ctx, meta.RegionalKey(lbName, vals.Region), &compute.BackendService{
+		Name:     lbName,
+		Backends: []*compute.Backend{{Group: "ig"}},
+	}); err != nil {
+		t.Fatalf("Failed to insert backend service: %v", err)
+	}
+	if err := mockGCE.ForwardingRules().Insert(ctx, meta.RegionalKey(lbName, vals.Region), &compute.ForwardingRule{
+		Name:           lbName,
+		BackendService: "regions/" + vals.Region + "/backendServices/" + lbName,
+	}); err != nil {
+		t.Fatalf("Failed to insert forwarding rule: %v", err)
+	}
+	healthState := "HEALTHY"
+	mockGCE.MockRegionBackendServices.GetHealthHook = func(_ context.Context, _ *meta.Key, _ *compute.ResourceGroupReference, _ *cloud.MockRegionBackendServices, _ ...cloud.Option) (*compute.BackendServiceGroupHealth, error) {
+		return &compute.BackendServiceGroupHealth{HealthStatus: []*compute.HealthStatus{{Instance: "node-1", HealthState: healthState}}}, nil
+	}
+
+	for _, step := range []struct {
+		desc        string
+		healthState string
+		failing     bool
+		wantStates  []string
+	}{
+		{desc: "the first poll records the state", healthState: "HEALTHY"},
+		{desc: "no transition", healthState: "HEALTHY"},
+		{desc: "failing webhook", healthState: "UNHEALTHY", failing: true},
+		{desc: "the failed notification is retried", healthState: "UNHEALTHY", wantStates: []string{lbStateHealthy, lbStateUnhealthy}},
+		{desc: "recovery", healthState: "HEALTHY", wantStates: []string{lbStateUnhealthy, lbStateHealthy}},
+	} {
+		healthState = step.healthState
+		webhook.lock.Lock()
+		webhook.failing = step.failing
+		webhook.lock.Unlock()
+		controller.pollLoadBalancers(ctx)
+
+		notifications := webhook.take()
+		if step.wantStates == nil {
+			if len(notifications) != 0 {
+				t.Errorf("%s: got notifications %+v, want none", step.desc, notifications)
+			}
+			continue
+		}
+		if len(notifications) != 1 {
+			t.Errorf("%s: got notifications %+v, want one", step.desc, notifications)
+			continue
+		}
+		n := notifications[0]
+		if n.Type != NotificationTypeLoadBalancerHealthChanged || n.Namespace != "default" || n.Name != "svc" || n.LoadBalancer == nil ||
+			n.LoadBalancer.PreviousState != step.wantStates[0] || n.LoadBalancer.State != step.wantStates[1] {
+			t.Errorf("%s: got notification %+v, want a transition from %s to %s", step.desc, n, step.wantStates[0], step.wantStates[1])
+		}
+	}
+}
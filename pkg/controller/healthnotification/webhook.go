@@ -0,0 +1,107 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthnotification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// NotificationTypeNodeDeleted is the type of the notifications of the
+	// nodes deleted because their instance is gone.
+	NotificationTypeNodeDeleted = "NodeDeleted"
+	// NotificationTypeLoadBalancerHealthChanged is the type of the
+	// notifications of the health transitions of the load balancers.
+	NotificationTypeLoadBalancerHealthChanged = "LoadBalancerHealthChanged"
+
+	// webhookTimeout bounds the posts to the webhook.
+	webhookTimeout = 10 * time.Second
+)
+
+// Notification is the JSON body posted to the webhook.
+type Notification struct {
+	// Type is the type of the notification, e.g. NodeDeleted.
+	Type string `json:"type"`
+	// Time is the time the controller observed the change.
+	Time time.Time `json:"time"`
+	// Cluster is the name of the cluster.
+	Cluster string `json:"cluster"`
+	// Kind, Namespace and Name identify the object of the notification.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Message describes the change.
+	Message string `json:"message"`
+
+	Node         *NodeDetails         `json:"node,omitempty"`
+	LoadBalancer *LoadBalancerDetails `json:"loadBalancer,omitempty"`
+}
+
+// NodeDetails are the details of the NodeDeleted notifications.
+type NodeDetails struct {
+	ProviderID string `json:"providerID"`
+	// Reason is why the node was deleted, InstanceNotFound.
+	Reason string `json:"reason"`
+}
+
+// LoadBalancerDetails are the details of the LoadBalancerHealthChanged
+// notifications.
+type LoadBalancerDetails struct {
+	PreviousState   string `json:"previousState"`
+	State           string `json:"state"`
+	HealthyBackends int    `json:"healthyBackends"`
+	Backends        int    `json:"backends"`
+}
+
+// webhookSink posts the notifications to a webhook.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// post posts the notification, the webhook must respond with a 2xx status.
+func (s *webhookSink) post(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
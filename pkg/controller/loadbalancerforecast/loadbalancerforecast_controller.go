@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancerforecast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Path is the path of the forecast endpoint.
+	Path = "/debug/loadbalancer-forecast"
+
+	// forecastTimeout bounds the GCE calls of a forecast.
+	forecastTimeout = 30 * time.Second
+)
+
+// Controller serves a debug endpoint reporting how many GCE resources of each
+// kind the provider maintains for the L4 load balancers of the current
+// Services and nodes, and how close each resource is to its quota, for
+// capacity planning.
+type Controller struct {
+	serviceLister         corelisters.ServiceLister
+	serviceInformerSynced cache.InformerSynced
+	nodeLister            corelisters.NodeLister
+	nodeInformerSynced    cache.InformerSynced
+	gceCloud              *gce.Cloud
+	clusterName           string
+	bindAddress           string
+}
+
+// NewLoadBalancerForecastController returns a new load balancer forecast
+// controller, serving the forecast on bindAddress.
+func NewLoadBalancerForecastController(
+	serviceInformer coreinformers.ServiceInformer,
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+	clusterName string,
+	bindAddress string,
+) *Controller {
+	return &Controller{
+		serviceLister:         serviceInformer.Lister(),
+		serviceInformerSynced: serviceInformer.Informer().HasSynced,
+		nodeLister:            nodeInformer.Lister(),
+		nodeInformerSynced:    nodeInformer.Informer().HasSynced,
+		gceCloud:              gceCloud,
+		clusterName:           clusterName,
+		bindAddress:           bindAddress,
+	}
+}
+
+// Run serves the forecast endpoint until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	klog.Infof("Starting loadbalancerforecast controller")
+	defer klog.Infof("Shutting down loadbalancerforecast controller")
+	controllerManagerMetrics.ControllerStarted("loadbalancerforecast")
+	defer controllerManagerMetrics.ControllerStopped("loadbalancerforecast")
+
+	if !cache.WaitForNamedCacheSync("loadbalancerforecast", stopCh, c.serviceInformerSynced, c.nodeInformerSynced) {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, c)
+	server := &http.Server{Addr: c.bindAddress, Handler: mux}
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+	klog.Infof("Serving the load balancer resource forecast on %s%s", c.bindAddress, Path)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("Failed to serve the load balancer resource forecast: %v", err)
+	}
+}
+
+// ServeHTTP responds with the forecast of the load balancer resources in JSON.
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nodes, err := c.loadBalancerNodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), forecastTimeout)
+	defer cancel()
+	forecast, err := c.gceCloud.ForecastLoadBalancerResources(ctx, c.clusterName, services, nodes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(forecast); err != nil {
+		klog.Errorf("Failed to write the load balancer resource forecast: %v", err)
+	}
+}
+
+// loadBalancerNodes returns the nodes which the service controller adds to
+// the load balancers, i.e. the nodes without the exclusion label.
+func (c *Controller) loadBalancerNodes() ([]*v1.Node, error) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*v1.Node
+	for _, node := range nodes {
+		if _, ok := node.Labels[v1.LabelNodeExcludeBalancers]; ok {
+			continue
+		}
+		filtered = append(filtered, node)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancerforecast
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+)
+
+func testNode(name, zone string, labels map[string]string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}}}
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+	return node
+}
+
+func TestServeHTTP(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	mockGCE := fakeGCE.Compute().(*cloud.MockGCE)
+	mockGCE.MockRegions.Objects[*meta.GlobalKey(vals.Region)] = &cloud.MockRegionsObj{Obj: &compute.Region{
+		Name:   vals.Region,
+		Quotas: []*compute.Quota{{Metric: "INSTANCE_GROUPS", Limit: 100, Usage: 10}},
+	}}
+	mockGCE.MockProjects.Objects[*meta.GlobalKey(vals.ProjectID)] = &cloud.MockProjectsObj{Obj: &compute.Project{Name: vals.ProjectID}}
+
+	client := fake.NewSimpleClientset(
+		testNode("node-1", vals.ZoneName, nil),
+		// The nodes excluded from the load balancers have no instance group.
+		testNode("node-2", vals.SecondaryZoneName, map[string]string{v1.LabelNodeExcludeBalancers: ""}),
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "svc",
+				Namespace:   "default",
+				UID:         "uid-svc",
+				Annotations: map[string]string{gce.ServiceAnnotationLoadBalancerType: string(gce.LBTypeInternal)},
+			},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80}},
+			},
+		},
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewLoadBalancerForecastController(informerFactory.Core().V1().Services(), informerFactory.Core().V1().Nodes(), fakeGCE, vals.ClusterName, "")
+	informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), controller.serviceInformerSynced, controller.nodeInformerSynced) {
+		t.Fatalf("Failed to sync the informers")
+	}
+
+	rec := httptest.NewRecorder()
+	controller.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() = %d %q, want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	var forecast []gce.LoadBalancerResourceForecast
+	if err := json.Unmarshal(rec.Body.Bytes(), &forecast); err != nil {
+		t.Fatalf("Failed to decode the forecast %q: %v", rec.Body.String(), err)
+	}
+	counts := map[gce.LoadBalancerResource]int{}
+	for _, f := range forecast {
+		counts[f.Resource] = f.Count
+		if f.Resource == gce.LoadBalancerResourceInstanceGroups && (f.Quota == nil || f.Quota.Limit != 100) {
+			t.Errorf("Quota of %s = %+v, want a limit of 100", f.Resource, f.Quota)
+		}
+	}
+	want := map[gce.LoadBalancerResource]int{
+		gce.LoadBalancerResourceForwardingRules:  1,
+		gce.LoadBalancerResourceBackendServices:  1,
+		gce.LoadBalancerResourceTargetPools:      0,
+		gce.LoadBalancerResourceHealthChecks:     1,
+		gce.LoadBalancerResourceHTTPHealthChecks: 0,
+		gce.LoadBalancerResourceFirewalls:        2,
+		gce.LoadBalancerResourceInstanceGroups:   1,
+	}
+	for resource, count := range want {
+		if counts[resource] != count {
+			t.Errorf("Count of %s = %d, want %d", resource, counts[resource], count)
+		}
+	}
+
+	// The quotas cannot be reported without the project.
+	delete(mockGCE.MockProjects.Objects, *meta.GlobalKey(vals.ProjectID))
+	rec = httptest.NewRecorder()
+	controller.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() without project = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodedns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/cloud-provider-gcp/pkg/controller/deletednodes"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "nodedns"
+)
+
+// Controller deletes the Cloud DNS records of the deleted nodes, for the
+// deployments which create a record per node in a private managed zone. The
+// A and AAAA records named after the node are deleted once the node is
+// deleted, if they only resolve to the addresses of the node, so that a new
+// instance reusing the name does not resolve to a stale address.
+//
+// The nodes deleted while the controller is not running are not cleaned up.
+type Controller struct {
+	nodeInformerSynced cache.InformerSynced
+	gceCloud           *gce.Cloud
+	// deletedNodes are the deleted nodes whose records are pending cleanup.
+	deletedNodes *deletednodes.Queue
+
+	// project and managedZone are the Cloud DNS managed zone of the records.
+	project     string
+	managedZone string
+
+	lock sync.Mutex
+	// dnsName is the DNS name of the managed zone, e.g. "example.com.", it is
+	// fetched at the first cleanup.
+	dnsName string
+}
+
+// NewNodeDNSController returns a new node DNS controller, cleaning up the
+// records of the managed zone of the project.
+func NewNodeDNSController(
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+	project string,
+	managedZone string,
+) *Controller {
+	c := &Controller{
+		nodeInformerSynced: nodeInformer.Informer().HasSynced,
+		gceCloud:           gceCloud,
+		project:            project,
+		managedZone:        managedZone,
+	}
+	c.deletedNodes = deletednodes.NewQueue(workqueueName, nodeInformer, c.sync)
+	return c
+}
+
+// Run starts an asynchronous loop that deletes the records of the deleted
+// nodes.
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	klog.Infof("Starting nodedns controller")
+	defer klog.Infof("Shutting down nodedns controller")
+	controllerManagerMetrics.ControllerStarted("nodedns")
+	defer controllerManagerMetrics.ControllerStopped("nodedns")
+
+	if !cache.WaitForNamedCacheSync("nodedns", stopCh, c.nodeInformerSynced) {
+		return
+	}
+
+	c.deletedNodes.Run(ctx, numWorkers)
+
+	<-stopCh
+}
+
+// sync deletes the records of the deleted node.
+func (c *Controller) sync(ctx context.Context, node *v1.Node) error {
+	name := node.Name
+	dnsName, err := c.zoneDNSName(ctx)
+	if err != nil {
+		return err
+	}
+	addresses := nodeAddresses(node)
+	if addresses.Len() == 0 {
+		klog.Warningf("Deleted node %q had no address, keeping its DNS records", name)
+		return nil
+	}
+
+	recordName := nodeRecordName(name, dnsName)
+	rrsets, err := c.gceCloud.ListDNSRecordSets(ctx, c.project, c.managedZone, recordName)
+	if err != nil {
+		return fmt.Errorf("failed to list the DNS records %s of managed zone %s: %w", recordName, c.managedZone, err)
+	}
+	for _, rrset := range rrsets {
+		if rrset.Name != recordName || (rrset.Type != "A" && rrset.Type != "AAAA") {
+			continue
+		}
+		if !addresses.HasAll(rrset.Rrdatas...) {
+			klog.Infof("DNS record %s %s of deleted node %q resolves to %v, not only to the addresses of the node, keeping it", rrset.Type, recordName, name, rrset.Rrdatas)
+			continue
+		}
+		if err := c.gceCloud.DeleteDNSRecordSet(ctx, c.project, c.managedZone, recordName, rrset.Type); err != nil {
+			return fmt.Errorf("failed to delete the DNS record %s %s of managed zone %s: %w", rrset.Type, recordName, c.managedZone, err)
+		}
+		klog.Infof("Deleted DNS record %s %s of deleted node %q", rrset.Type, recordName, name)
+	}
+	return nil
+}
+
+// zoneDNSName returns the DNS name of the managed zone.
+func (c *Controller) zoneDNSName(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	dnsName := c.dnsName
+	c.lock.Unlock()
+	if dnsName != "" {
+		return dnsName, nil
+	}
+
+	zone, err := c.gceCloud.GetDNSManagedZone(ctx, c.project, c.managedZone)
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed zone %s of project %s: %w", c.managedZone, c.project, err)
+	}
+	if zone.Visibility != "private" {
+		klog.Warningf("Managed zone %s of project %s has visibility %q, the DNS records of the deleted nodes are deleted from it", c.managedZone, c.project, zone.Visibility)
+	}
+	c.lock.Lock()
+	c.dnsName = zone.DnsName
+	c.lock.Unlock()
+	return zone.DnsName, nil
+}
+
+// nodeRecordName returns the DNS name of the records of the node in the
+// managed zone: the name of the node if it is in the zone, or else the name of
+// its instance in the zone.
+func nodeRecordName(nodeName, dnsName string) string {
+	fqdn := strings.TrimSuffix(nodeName, ".") + "."
+	if strings.HasSuffix(fqdn, "."+dnsName) {
+		return fqdn
+	}
+	return gce.MapNodeNameToInstanceName(types.NodeName(nodeName)) + "." + dnsName
+}
+
+// nodeAddresses returns the IP addresses of the node.
+func nodeAddresses(node *v1.Node) sets.String {
+	addresses := sets.NewString()
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP || addr.Type == v1.NodeExternalIP {
+			addresses.Insert(addr.Address)
+		}
+	}
+	return addresses
+}
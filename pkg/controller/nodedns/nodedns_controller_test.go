@@ -0,0 +1,168 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodedns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dnsapi "google.golang.org/api/dns/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+)
+
+const (
+	testProject     = "dns-project"
+	testManagedZone = "nodes"
+	testDNSName     = "example.com."
+)
+
+// fakeDNS serves the record sets of the test managed zone.
+type fakeDNS struct {
+	lock   sync.Mutex
+	rrsets map[string]*dnsapi.ResourceRecordSet
+}
+
+func (f *fakeDNS) add(name, recordType string, rrdatas ...string) {
+	f.rrsets[name+"/"+recordType] = &dnsapi.ResourceRecordSet{Name: name, Type: recordType, Rrdatas: rrdatas}
+}
+
+func (f *fakeDNS) has(name, recordType string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.rrsets[name+"/"+recordType]
+	return ok
+}
+
+func (f *fakeDNS) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	zonePath := "/dns/v1/projects/" + testProject + "/managedZones/" + testManagedZone
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == zonePath:
+		json.NewEncoder(rw).Encode(&dnsapi.ManagedZone{Name: testManagedZone, DnsName: testDNSName, Visibility: "private"})
+	case req.Method == http.MethodGet && req.URL.Path == zonePath+"/rrsets":
+		resp := &dnsapi.ResourceRecordSetsListResponse{}
+		for _, rrset := range f.rrsets {
+			if rrset.Name == req.URL.Query().Get("name") {
+				resp.Rrsets = append(resp.Rrsets, rrset)
+			}
+		}
+		json.NewEncoder(rw).Encode(resp)
+	case req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, zonePath+"/rrsets/"):
+		key := strings.TrimPrefix(req.URL.Path, zonePath+"/rrsets/")
+		if _, ok := f.rrsets[key]; !ok {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		delete(f.rrsets, key)
+		json.NewEncoder(rw).Encode(&dnsapi.ResourceRecordSetsDeleteResponse{})
+	default:
+		http.Error(rw, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func testNode(name string, addresses ...string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	for _, addr := range addresses {
+		node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: addr})
+	}
+	node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: name})
+	return node
+}
+
+func TestNodeDNSController(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	dns := &fakeDNS{rrsets: map[string]*dnsapi.ResourceRecordSet{}}
+	dns.add("stale.example.com.", "A", "10.0.0.1")
+	dns.add("stale.example.com.", "AAAA", "fd00::1")
+	dns.add("stale.example.com.", "TXT", "owner")
+	dns.add("fqdn.example.com.", "A", "10.0.0.2")
+	dns.add("reused.example.com.", "A", "10.0.0.9")
+	dns.add("recreated.example.com.", "A", "10.0.0.4")
+	srv := httptest.NewServer(dns)
+	defer srv.Close()
+
+	fakeGCE := gce.NewFakeGCECloud(gce.DefaultTestClusterValues())
+	gce.SetFakeDNSEndpoint(fakeGCE, srv.URL+"/")
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0*time.Second)
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	controller := NewNodeDNSController(nodeInformer, fakeGCE, testProject, testManagedZone)
+
+	recreated := testNode("recreated", "10.0.0.4")
+	if err := nodeInformer.Informer().GetStore().Add(recreated); err != nil {
+		t.Fatalf("Failed to add node: %v", err)
+	}
+	for _, node := range []*v1.Node{
+		testNode("stale", "10.0.0.1", "fd00::1"),
+		testNode("fqdn.c.project.internal", "10.0.0.2"),
+		testNode("reused", "10.0.0.3"),
+		recreated,
+	} {
+		controller.deletedNodes.Enqueue(node)
+		if err := controller.deletedNodes.Sync(ctx, node.Name); err != nil {
+			t.Fatalf("Sync(%q) = %v", node.Name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, recordType string
+		want             bool
+		desc             string
+	}{
+		{"stale.example.com.", "A", false, "the records of the deleted nodes are deleted"},
+		{"stale.example.com.", "AAAA", false, "the records of the deleted nodes are deleted"},
+		{"stale.example.com.", "TXT", true, "only the address records are deleted"},
+		{"fqdn.example.com.", "A", false, "the records of the FQDN nodes are named after their instance"},
+		{"reused.example.com.", "A", true, "the records resolving to other addresses are kept"},
+		{"recreated.example.com.", "A", true, "the records of the recreated nodes are kept"},
+	} {
+		if got := dns.has(tc.name, tc.recordType); got != tc.want {
+			t.Errorf("record %s %s exists = %v, want %v: %s", tc.recordType, tc.name, got, tc.want, tc.desc)
+		}
+	}
+	if n := controller.deletedNodes.Len(); n != 0 {
+		t.Errorf("%d deleted nodes are still pending cleanup", n)
+	}
+}
+
+func TestNodeRecordName(t *testing.T) {
+	for _, tc := range []struct {
+		nodeName string
+		want     string
+	}{
+		{"node-1", "node-1.example.com."},
+		{"node-1.c.project.internal", "node-1.example.com."},
+		{"node-1.example.com", "node-1.example.com."},
+		{"node-1.zone.example.com", "node-1.zone.example.com."},
+	} {
+		if got := nodeRecordName(tc.nodeName, testDNSName); got != tc.want {
+			t.Errorf("nodeRecordName(%q) = %q, want %q", tc.nodeName, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroup
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-gcp/pkg/controller/nodesync"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "nodegroup"
+
+	// resyncPeriod is the period the group of the instances of all the nodes
+	// is checked again at, listing the instances of each zone, as the
+	// instances move between groups without any node update.
+	resyncPeriod = 10 * time.Minute
+)
+
+// Controller keeps the gce.InstanceGroupManagerLabelKey label of the nodes up
+// to date with the managed instance group of their instance. The label is set
+// when the nodes are initialized, and changes when the instances move between
+// groups, e.g. when they are abandoned by a group during a resize or a
+// rebalancing, as the nodes do not register again.
+type Controller struct {
+	kubeClient         clientset.Interface
+	nodeLister         corelisters.NodeLister
+	nodeInformerSynced cache.InformerSynced
+	gceCloud           *gce.Cloud
+	queue              *nodesync.Queue
+	resyncPeriod       time.Duration
+}
+
+// NewNodeGroupController returns a new node group controller.
+func NewNodeGroupController(
+	kubeClient clientset.Interface,
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+) *Controller {
+	c := &Controller{
+		kubeClient:         kubeClient,
+		nodeLister:         nodeInformer.Lister(),
+		nodeInformerSynced: nodeInformer.Informer().HasSynced,
+		gceCloud:           gceCloud,
+		resyncPeriod:       resyncPeriod,
+	}
+	c.queue = nodesync.NewQueue(workqueueName, c.sync)
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			// The providerID is set when the node is initialized.
+			if old.(*v1.Node).Spec.ProviderID != new.(*v1.Node).Spec.ProviderID {
+				c.enqueue(new)
+			}
+		},
+	})
+	return c
+}
+
+// enqueue queues the nodes with a providerID.
+func (c *Controller) enqueue(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok || node.Spec.ProviderID == "" {
+		return
+	}
+	c.queue.Add(node.Name)
+}
+
+// resync queues the nodes whose label is not the managed instance group of
+// their instance. The instances of all the nodes are listed by zone instead
+// of being got one by one.
+func (c *Controller) resync(ctx context.Context) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	providerIDs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID != "" {
+			providerIDs = append(providerIDs, node.Spec.ProviderID)
+		}
+	}
+	groups, err := c.gceCloud.InstanceGroupManagers(ctx, providerIDs)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, node := range nodes {
+		// The nodes without an instance are deleted by the cloud node
+		// lifecycle controller.
+		if group, ok := groups[node.Spec.ProviderID]; ok && !hasGroupLabel(node, group) {
+			c.enqueue(node)
+		}
+	}
+}
+
+// hasGroupLabel returns true if the label of the node is group, or if it has
+// no label and group is "".
+func hasGroupLabel(node *v1.Node, group string) bool {
+	current, ok := node.Labels[gce.InstanceGroupManagerLabelKey]
+	return current == group && (ok || group == "")
+}
+
+// Run starts an asynchronous loop that updates the group label of the nodes.
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	c.queue.Run(numWorkers, stopCh, controllerManagerMetrics, c.resync, c.resyncPeriod, c.nodeInformerSynced)
+}
+
+func (c *Controller) sync(ctx context.Context, name string) error {
+	node, err := c.nodeLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if node.Spec.ProviderID == "" {
+		return nil
+	}
+
+	group, err := c.gceCloud.InstanceGroupManager(ctx, node.Spec.ProviderID)
+	if err == cloudprovider.InstanceNotFound {
+		// The node is deleted by the cloud node lifecycle controller.
+		klog.V(2).Infof("Node %q has no instance, skipping the group label update", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hasGroupLabel(node, group) {
+		return nil
+	}
+	current := node.Labels[gce.InstanceGroupManagerLabelKey]
+
+	// A null label value removes the label of the instances out of a group.
+	value := &group
+	if group == "" {
+		value = nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]*string{gce.InstanceGroupManagerLabelKey: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.kubeClient.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Updated label %s of node %q from %q to %q", gce.InstanceGroupManagerLabelKey, name, current, group)
+	return nil
+}
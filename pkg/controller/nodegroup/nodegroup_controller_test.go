@@ -0,0 +1,144 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+)
+
+func testInstance(vals gce.TestClusterValues, name, group string) *compute.Instance {
+	instance := &compute.Instance{Name: name, Metadata: &compute.Metadata{}}
+	if group != "" {
+		createdBy := fmt.Sprintf("projects/%s/zones/%s/instanceGroupManagers/%s", vals.ProjectID, vals.ZoneName, group)
+		instance.Metadata.Items = []*compute.MetadataItems{{Key: "created-by", Value: &createdBy}}
+	}
+	return instance
+}
+
+func testNode(vals gce.TestClusterValues, name, group string) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/%s", vals.ProjectID, vals.ZoneName, name)},
+	}
+	if group != "" {
+		node.Labels[gce.InstanceGroupManagerLabelKey] = group
+	}
+	return node
+}
+
+func TestNodeGroupController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	for name, group := range map[string]string{
+		"unlabeled": "pool-a",
+		"moved":     "pool-b",
+		"abandoned": "",
+		"unchanged": "pool-a",
+	} {
+		if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, testInstance(vals, name, group)); err != nil {
+			t.Fatalf("Failed to insert instance %q: %v", name, err)
+		}
+	}
+
+	unregistered := testNode(vals, "unregistered", "")
+	unregistered.Spec.ProviderID = ""
+	client := fake.NewSimpleClientset(
+		testNode(vals, "unlabeled", ""),
+		testNode(vals, "moved", "pool-a"),
+		testNode(vals, "abandoned", "pool-a"),
+		testNode(vals, "unchanged", "pool-a"),
+		testNode(vals, "no-instance", "pool-a"),
+		unregistered,
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewNodeGroupController(client, informerFactory.Core().V1().Nodes(), fakeGCE)
+	informerFactory.Start(ctx.Done())
+	go controller.Run(1, ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	group := func(name string) func() string {
+		return func() string {
+			node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err.Error()
+			}
+			group, ok := node.Labels[gce.InstanceGroupManagerLabelKey]
+			if !ok {
+				return "<none>"
+			}
+			return group
+		}
+	}
+	g.Eventually(group("unlabeled")).Should(gomega.Equal("pool-a"))
+	g.Eventually(group("moved")).Should(gomega.Equal("pool-b"))
+	g.Eventually(group("abandoned")).Should(gomega.Equal("<none>"), "the label is removed from the instances out of a group")
+	g.Consistently(group("no-instance"), 500*time.Millisecond).Should(gomega.Equal("pool-a"), "nodes without an instance are left to the cloud node lifecycle controller")
+	g.Expect(group("unchanged")()).To(gomega.Equal("pool-a"))
+	g.Expect(group("unregistered")()).To(gomega.Equal("<none>"))
+}
+
+func TestNodeGroupControllerResync(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, testInstance(vals, "node", "pool-a")); err != nil {
+		t.Fatalf("Failed to insert instance: %v", err)
+	}
+
+	client := fake.NewSimpleClientset(testNode(vals, "node", "pool-a"))
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewNodeGroupController(client, informerFactory.Core().V1().Nodes(), fakeGCE)
+	controller.resyncPeriod = 100 * time.Millisecond
+	informerFactory.Start(ctx.Done())
+	go controller.Run(1, ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	labels := func() map[string]string {
+		node, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return node.Labels
+	}
+	g.Consistently(labels, 300*time.Millisecond).Should(gomega.HaveKeyWithValue(gce.InstanceGroupManagerLabelKey, "pool-a"))
+
+	// The instance moves to another group without any node update.
+	if err := fakeGCE.DeleteInstance(vals.ProjectID, vals.ZoneName, "node"); err != nil {
+		t.Fatalf("Failed to delete instance: %v", err)
+	}
+	if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, testInstance(vals, "node", "pool-b")); err != nil {
+		t.Fatalf("Failed to insert instance: %v", err)
+	}
+	g.Eventually(labels).Should(gomega.HaveKeyWithValue(gce.InstanceGroupManagerLabelKey, "pool-b"))
+}
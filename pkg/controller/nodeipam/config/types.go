@@ -22,6 +22,9 @@ type NodeIPAMControllerConfiguration struct {
 	ServiceCIDR string
 	// SecondaryServiceCIDR is CIDR Range for Services in cluster. This is used in dual stack clusters. SecondaryServiceCIDR must be of different IP family than ServiceCIDR
 	SecondaryServiceCIDR string
+	// ZoneClusterCIDRs is the comma separated list of the <zone>=<cidr> cluster CIDRs of the nodes of
+	// each zone of a stretched cluster. A zone has a CIDR of each IP family of the cluster CIDRs.
+	ZoneClusterCIDRs string
 	// NodeCIDRMaskSize is the mask size for node cidr in single-stack cluster.
 	// This can be used only with single stack clusters and is incompatible with dual stack clusters.
 	NodeCIDRMaskSize int32
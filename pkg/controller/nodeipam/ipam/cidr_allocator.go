@@ -99,6 +99,10 @@ type CIDRAllocator interface {
 type CIDRAllocatorParams struct {
 	// ClusterCIDRs is list of cluster cidrs
 	ClusterCIDRs []*net.IPNet
+	// ZoneClusterCIDRs are the cluster cidrs of the nodes of each zone of a
+	// stretched cluster, of the IP families of ClusterCIDRs. The nodes of the
+	// other zones get their cidrs from ClusterCIDRs.
+	ZoneClusterCIDRs map[string][]*net.IPNet
 	// ServiceCIDR is primary service cidr for cluster
 	ServiceCIDR *net.IPNet
 	// SecondaryServiceCIDR is secondary service cidr for cluster
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// The reasons of the failed CIDR allocations.
+const (
+	// allocationFailureNoAliasRange is the failure of the nodes whose instance
+	// has no alias IP range or IPv6 address to take the CIDRs from.
+	allocationFailureNoAliasRange = "no_alias_range"
+	// allocationFailureRangeExhausted is the failure of the nodes for which
+	// the cluster CIDR has no free CIDR left.
+	allocationFailureRangeExhausted = "range_exhausted"
+	// allocationFailureUpdateConflict is the failure of the node updates
+	// rejected because of a conflicting change, e.g. a podCIDR already set.
+	allocationFailureUpdateConflict = "update_conflict"
+	// allocationFailureUpdateFailed is the failure of the other node updates.
+	allocationFailureUpdateFailed = "update_failed"
+)
+
+// unallocatedNodesReportPeriod is the period at which the number of nodes
+// without podCIDR is reported.
+const unallocatedNodesReportPeriod = 30 * time.Second
+
+var (
+	cidrAllocationLatency = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Subsystem:      nodeIpamSubsystem,
+			Name:           "cidr_allocation_latency_seconds",
+			Help:           "Histogram measuring the time from the node creation to the assignment of its podCIDR.",
+			StabilityLevel: metrics.ALPHA,
+			Buckets:        metrics.ExponentialBuckets(0.5, 2, 12),
+		},
+	)
+	cidrAllocationFailures = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      nodeIpamSubsystem,
+			Name:           "cidr_allocation_failures_total",
+			Help:           "Counter measuring the failed CIDR allocations by reason.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+	unallocatedNodes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      nodeIpamSubsystem,
+			Name:           "unallocated_nodes",
+			Help:           "Gauge measuring the number of nodes without podCIDR.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+var registerAllocationMetricsOnce sync.Once
+
+// registerAllocationMetrics registers the CIDR allocation metrics, shared by
+// the CIDR allocators.
+func registerAllocationMetrics() {
+	registerAllocationMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(cidrAllocationLatency)
+		legacyregistry.MustRegister(cidrAllocationFailures)
+		legacyregistry.MustRegister(unallocatedNodes)
+	})
+}
+
+// recordCIDRAllocated records the latency of the first podCIDR assignment of
+// the node.
+func recordCIDRAllocated(node *v1.Node) {
+	if node.CreationTimestamp.IsZero() {
+		return
+	}
+	cidrAllocationLatency.Observe(time.Since(node.CreationTimestamp.Time).Seconds())
+}
+
+// recordCIDRAllocationFailure records a failed CIDR allocation.
+func recordCIDRAllocationFailure(reason string) {
+	cidrAllocationFailures.WithLabelValues(reason).Inc()
+}
+
+// updateFailureReason returns the reason of the failed update of a node.
+func updateFailureReason(err error) string {
+	if apierrors.IsConflict(err) || apierrors.IsInvalid(err) {
+		return allocationFailureUpdateConflict
+	}
+	return allocationFailureUpdateFailed
+}
+
+// reportUnallocatedNodes sets the number of nodes without podCIDR.
+func reportUnallocatedNodes(nodeLister corelisters.NodeLister) {
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list the nodes to report the unallocated ones: %v", err)
+		return
+	}
+	count := 0
+	for _, node := range nodes {
+		if len(node.Spec.PodCIDRs) == 0 && node.Spec.PodCIDR == "" {
+			count++
+		}
+	}
+	unallocatedNodes.Set(float64(count))
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	clSetFake "k8s.io/cloud-provider-gcp/crd/client/network/clientset/versioned/fake"
+	networkinformers "k8s.io/cloud-provider-gcp/crd/client/network/informers/externalversions"
+	"k8s.io/cloud-provider-gcp/pkg/controller/testutil"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	metricsUtil "k8s.io/component-base/metrics/testutil"
+)
+
+func TestUpdateFailureReason(t *testing.T) {
+	nodes := schema.GroupResource{Resource: "nodes"}
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{
+			desc: "conflict",
+			err:  fmt.Errorf("failed to patch node CIDR: %w", apierrors.NewConflict(nodes, "test", errors.New("conflict"))),
+			want: allocationFailureUpdateConflict,
+		},
+		{
+			desc: "podCIDR already set",
+			err:  apierrors.NewInvalid(schema.GroupKind{Kind: "Node"}, "test", field.ErrorList{field.Forbidden(field.NewPath("spec", "podCIDRs"), "node updates may not change podCIDR except from \"\" to valid")}),
+			want: allocationFailureUpdateConflict,
+		},
+		{
+			desc: "other error",
+			err:  apierrors.NewServerTimeout(nodes, "patch", 1),
+			want: allocationFailureUpdateFailed,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := updateFailureReason(tc.err); got != tc.want {
+				t.Errorf("updateFailureReason(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReportUnallocatedNodes(t *testing.T) {
+	registerAllocationMetrics()
+	fakeNodeInformer := getFakeNodeInformer(&testutil.FakeNodeHandler{
+		Existing: []*v1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "allocated"}, Spec: v1.NodeSpec{PodCIDR: "10.0.0.0/24", PodCIDRs: []string{"10.0.0.0/24"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "new-1"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "new-2"}},
+		},
+	})
+
+	reportUnallocatedNodes(fakeNodeInformer.Lister())
+	got, err := metricsUtil.GetGaugeMetricValue(unallocatedNodes)
+	if err != nil {
+		t.Fatalf("failed to get %s value: %v", unallocatedNodes.Name, err)
+	}
+	if got != 2 {
+		t.Errorf("unallocated nodes = %v, want 2", got)
+	}
+}
+
+func TestCloudCIDRAllocatorAllocationMetrics(t *testing.T) {
+	registerAllocationMetrics()
+	cidrAllocationFailures.Reset()
+	initialCount, err := metricsUtil.GetHistogramMetricCount(cidrAllocationLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("failed to get %s count: %v", cidrAllocationLatency.Name, err)
+	}
+	initialSum, err := metricsUtil.GetHistogramMetricValue(cidrAllocationLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("failed to get %s value: %v", cidrAllocationLatency.Name, err)
+	}
+
+	ctx := context.Background()
+	testClusterValues := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(testClusterValues)
+	instances := map[string]*compute.Instance{
+		"no-range": {Name: "no-range", NetworkInterfaces: []*compute.NetworkInterface{{}}},
+		"ranged":   {Name: "ranged", NetworkInterfaces: []*compute.NetworkInterface{{AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "192.168.1.0/24"}}}}},
+	}
+	nodeHandler := &testutil.FakeNodeHandler{Clientset: fake.NewSimpleClientset()}
+	for name, inst := range instances {
+		if err := fakeGCE.Compute().Instances().Insert(ctx, meta.ZonalKey(name, testClusterValues.ZoneName), inst); err != nil {
+			t.Fatalf("error setting up the test for fakeGCE: %v", err)
+		}
+		nodeHandler.Existing = append(nodeHandler.Existing, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute))},
+			Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/%s", testClusterValues.ProjectID, testClusterValues.ZoneName, name)},
+		})
+	}
+	fakeNodeInformer := getFakeNodeInformer(nodeHandler)
+	nwInfFactory := networkinformers.NewSharedInformerFactory(clSetFake.NewSimpleClientset(), 1*time.Second).Networking()
+	ca := &cloudCIDRAllocator{
+		client:         nodeHandler,
+		cloud:          fakeGCE,
+		recorder:       testutil.NewFakeRecorder(),
+		nodeLister:     fakeNodeInformer.Lister(),
+		nodesSynced:    fakeNodeInformer.Informer().HasSynced,
+		networksLister: nwInfFactory.V1().Networks().Lister(),
+		gnpLister:      nwInfFactory.V1().GKENetworkParamSets().Lister(),
+		stackType:      stackIPv4,
+	}
+
+	if err := ca.updateCIDRAllocation("no-range"); err == nil {
+		t.Fatalf("updateCIDRAllocation(no-range) = nil, want an error")
+	}
+	failures, err := metricsUtil.GetCounterMetricValue(cidrAllocationFailures.WithLabelValues(allocationFailureNoAliasRange))
+	if err != nil {
+		t.Fatalf("failed to get %s value: %v", cidrAllocationFailures.Name, err)
+	}
+	if failures != 1 {
+		t.Errorf("%s failures = %v, want 1", allocationFailureNoAliasRange, failures)
+	}
+
+	if err := ca.updateCIDRAllocation("ranged"); err != nil {
+		t.Fatalf("updateCIDRAllocation(ranged) = %v, want nil", err)
+	}
+	count, err := metricsUtil.GetHistogramMetricCount(cidrAllocationLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("failed to get %s count: %v", cidrAllocationLatency.Name, err)
+	}
+	if count != initialCount+1 {
+		t.Errorf("allocation latency observations = %v, want 1", count-initialCount)
+	}
+	latency, err := metricsUtil.GetHistogramMetricValue(cidrAllocationLatency.ObserverMetric)
+	if err != nil {
+		t.Fatalf("failed to get %s value: %v", cidrAllocationLatency.Name, err)
+	}
+	if latency-initialSum < time.Minute.Seconds() {
+		t.Errorf("allocation latency = %vs, want at least the node age", latency-initialSum)
+	}
+}
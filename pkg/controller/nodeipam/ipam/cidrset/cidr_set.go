@@ -174,6 +174,62 @@ func (s *CidrSet) AllocateNext() (*net.IPNet, error) {
 	return s.indexToCIDRBlock(candidate), nil
 }
 
+// AllocateNextWithMaskSize allocates the next free CIDR range with the given
+// mask size, which must not be greater than the node mask size of the set. A
+// larger range occupies several aligned node ranges of the set.
+func (s *CidrSet) AllocateNextWithMaskSize(maskSize int) (*net.IPNet, error) {
+	if maskSize == s.nodeMaskSize {
+		return s.AllocateNext()
+	}
+	if maskSize > s.nodeMaskSize || maskSize < s.clusterMaskSize {
+		return nil, fmt.Errorf("mask size %d is out of the range [%d, %d] of CIDR set %v", maskSize, s.clusterMaskSize, s.nodeMaskSize, s.clusterCIDR)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	blocks := 1 << uint(s.nodeMaskSize-maskSize)
+	candidates := s.maxCIDRs / blocks
+	candidate := (s.nextCandidate + blocks - 1) / blocks % candidates
+	var i int
+	for i = 0; i < candidates; i++ {
+		if s.blocksFree(candidate*blocks, blocks) {
+			break
+		}
+		candidate = (candidate + 1) % candidates
+	}
+	if i == candidates {
+		return nil, ErrCIDRRangeNoCIDRsRemaining
+	}
+
+	begin := candidate * blocks
+	for idx := begin; idx < begin+blocks; idx++ {
+		s.used.SetBit(&s.used, idx, 1)
+	}
+	s.nextCandidate = (begin + blocks) % s.maxCIDRs
+	s.allocatedCIDRs += blocks
+	// Update metrics
+	cidrSetAllocations.WithLabelValues(s.label).Inc()
+	cidrSetAllocationTriesPerRequest.WithLabelValues(s.label).Observe(float64(i))
+	cidrSetUsage.WithLabelValues(s.label).Set(float64(s.allocatedCIDRs) / float64(s.maxCIDRs))
+
+	_, bits := s.nodeMask.Size()
+	return &net.IPNet{
+		IP:   s.indexToCIDRBlock(begin).IP,
+		Mask: net.CIDRMask(maskSize, bits),
+	}, nil
+}
+
+// blocksFree returns true if the count node ranges from begin are not used.
+func (s *CidrSet) blocksFree(begin, count int) bool {
+	for idx := begin; idx < begin+count; idx++ {
+		if s.used.Bit(idx) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *CidrSet) getBeginingAndEndIndices(cidr *net.IPNet) (begin, end int, err error) {
 	if cidr == nil {
 		return -1, -1, fmt.Errorf("error getting indices for cluster cidr %v, cidr is nil", s.clusterCIDR)
@@ -343,6 +343,73 @@ func TestCIDRSet_AllocationOccupied(t *testing.T) {
 	}
 }
 
+func TestCIDRSet_AllocateNextWithMaskSize(t *testing.T) {
+	_, clusterCIDR, _ := net.ParseCIDR("10.0.0.0/22")
+	a, err := NewCIDRSet(clusterCIDR, 26)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		maskSize int
+		expected string
+	}{
+		{maskSize: 26, expected: "10.0.0.0/26"},
+		// Larger ranges are aligned on their mask size.
+		{maskSize: 24, expected: "10.0.1.0/24"},
+		{maskSize: 26, expected: "10.0.2.0/26"},
+		{maskSize: 24, expected: "10.0.3.0/24"},
+		{maskSize: 26, expected: "10.0.0.64/26"},
+	} {
+		cidr, err := a.AllocateNextWithMaskSize(tc.maskSize)
+		if err != nil {
+			t.Fatalf("unexpected error allocating a /%d: %v", tc.maskSize, err)
+		}
+		if cidr.String() != tc.expected {
+			t.Errorf("AllocateNextWithMaskSize(%d) = %v, want %v", tc.maskSize, cidr, tc.expected)
+		}
+	}
+	if _, err := a.AllocateNextWithMaskSize(24); err != ErrCIDRRangeNoCIDRsRemaining {
+		t.Errorf("expected %v without any free aligned /24, got %v", ErrCIDRRangeNoCIDRsRemaining, err)
+	}
+	if a.allocatedCIDRs != 11 {
+		t.Errorf("expected 11 allocated /26 ranges, got %d", a.allocatedCIDRs)
+	}
+
+	// Releasing a larger range frees all its node ranges.
+	_, released, _ := net.ParseCIDR("10.0.1.0/24")
+	if err := a.Release(released); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cidr, err := a.AllocateNextWithMaskSize(24); err != nil || cidr.String() != "10.0.1.0/24" {
+		t.Errorf("AllocateNextWithMaskSize(24) = %v, %v, want 10.0.1.0/24", cidr, err)
+	}
+
+	for _, maskSize := range []int{21, 27} {
+		if _, err := a.AllocateNextWithMaskSize(maskSize); err == nil {
+			t.Errorf("expected an error allocating a /%d", maskSize)
+		}
+	}
+}
+
+func TestCIDRSet_AllocateNextWithMaskSizeIPv6(t *testing.T) {
+	_, clusterCIDR, _ := net.ParseCIDR("beef:1234::/48")
+	a, err := NewCIDRSet(clusterCIDR, 64)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.AllocateNext(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cidr, err := a.AllocateNextWithMaskSize(62)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cidr.String() != "beef:1234:0:4::/62" {
+		t.Errorf("AllocateNextWithMaskSize(62) = %v, want beef:1234:0:4::/62", cidr)
+	}
+}
+
 func TestDoubleOccupyRelease(t *testing.T) {
 	// Run a sequence of operations and check the number of occupied CIDRs
 	// after each one.
@@ -235,6 +235,7 @@ func NewCloudCIDRAllocator(client clientset.Interface, cloud cloudprovider.Inter
 
 	// register Cloud CIDR Allocator metrics
 	registerCloudCidrAllocatorMetrics()
+	registerAllocationMetrics()
 
 	klog.V(0).Infof("Using cloud CIDR allocator (provider: %v)", cloud.ProviderName())
 	return ca, nil
@@ -257,6 +258,7 @@ func (ca *cloudCIDRAllocator) Run(stopCh <-chan struct{}) {
 	for i := 0; i < cidrUpdateWorkers; i++ {
 		go wait.UntilWithContext(ctx, ca.runWorker, time.Second)
 	}
+	go wait.Until(func() { reportUnallocatedNodes(ca.nodeLister) }, unallocatedNodesReportPeriod, stopCh)
 
 	<-stopCh
 }
@@ -349,6 +351,7 @@ func (ca *cloudCIDRAllocator) updateCIDRAllocation(nodeName string) error {
 			ca.cloud.GetIPV6Address(instance.NetworkInterfaces[0]) == nil) {
 
 		nodeutil.RecordNodeStatusChange(ca.recorder, node, "CIDRNotAvailable")
+		recordCIDRAllocationFailure(allocationFailureNoAliasRange)
 		return fmt.Errorf("failed to allocate cidr: Node %v has no ranges from which CIDRs can be allocated", node.Name)
 	}
 
@@ -382,6 +385,7 @@ func (ca *cloudCIDRAllocator) updateCIDRAllocation(nodeName string) error {
 		case ca.stackType == stackIPv6 && ipv6CIDR != "":
 			cidrStrings = []string{ipv6CIDR}
 		default:
+			recordCIDRAllocationFailure(allocationFailureNoAliasRange)
 			return fmt.Errorf("failed to allocate cidr: Node %v has no ranges from which CIDRs can be allocated for the cluster stack family %s", node.Name, ca.stackType)
 		}
 	} else {
@@ -415,6 +419,7 @@ func (ca *cloudCIDRAllocator) updateCIDRAllocation(nodeName string) error {
 
 		if err = utilnode.PatchNodeMultiNetwork(ca.client, node); err != nil {
 			nodeutil.RecordNodeStatusChange(ca.recorder, node, "CIDRAssignmentFailed")
+			recordCIDRAllocationFailure(updateFailureReason(err))
 			klog.ErrorS(err, "Failed to update the node annotations and capacity for multi-networking", "nodeName", node.Name)
 			return err
 		}
@@ -442,6 +447,7 @@ func (ca *cloudCIDRAllocator) updateCIDRAllocation(nodeName string) error {
 func (ca *cloudCIDRAllocator) updateNodePodCIDRWithCidrStrings(oldNode *v1.Node, node *v1.Node, cidrStrings []string) error {
 	if len(cidrStrings) == 0 {
 		nodeutil.RecordNodeStatusChange(ca.recorder, node, "CIDRNotAvailable")
+		recordCIDRAllocationFailure(allocationFailureNoAliasRange)
 		return fmt.Errorf("failed to allocate cidr: Node %v has no CIDRs", node.Name)
 	}
 	// Can have at most 2 ips (one for v4 and one for v6)
@@ -494,10 +500,14 @@ func (ca *cloudCIDRAllocator) updateNodeCIDR(node, oldNode *v1.Node) error {
 		err = utilnode.PatchNodeCIDRs(ca.client, types.NodeName(node.Name), node.Spec.PodCIDRs)
 		if err != nil {
 			nodeutil.RecordNodeStatusChange(ca.recorder, node, "CIDRAssignmentFailed")
+			recordCIDRAllocationFailure(updateFailureReason(err))
 			klog.ErrorS(err, "Failed to update the node PodCIDR after multiple attempts", "nodeName", node.Name, "cidrStrings", node.Spec.PodCIDRs)
 			return err
 		}
 		klog.InfoS("Set the node PodCIDRs", "nodeName", node.Name, "cidrStrings", node.Spec.PodCIDRs)
+		if len(oldNode.Spec.PodCIDRs) == 0 {
+			recordCIDRAllocated(node)
+		}
 	}
 
 	// Update Conditions
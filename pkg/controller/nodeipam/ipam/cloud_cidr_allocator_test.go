@@ -626,6 +626,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 				node.Annotations = map[string]string{
 					networkv1.NorthInterfacesAnnotationKey: "[]",
 					networkv1.MultiNetworkAnnotationKey:    "[]",
+					networkv1.PodRangesAnnotationKey:       "[]",
 				}
 			},
 			expectedUpdate:  true,
@@ -684,6 +685,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 				node.Annotations = map[string]string{
 					networkv1.NorthInterfacesAnnotationKey: "[]",
 					networkv1.MultiNetworkAnnotationKey:    "[]",
+					networkv1.PodRangesAnnotationKey:       "[]",
 				}
 			},
 			expectedUpdate:  true,
@@ -744,6 +746,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 				}
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -753,6 +756,72 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 				redNetworkName: float64(1),
 			},
 		},
+		{
+			name: "[mn] one additional network with multiple secondary ranges",
+			networks: []*networkv1.Network{
+				network(networkv1.DefaultPodNetworkName, defaultGKENetworkParamsName, true),
+				network(redNetworkName, redGKENetworkParamsName, true),
+			},
+			gkeNwParams: []*networkv1.GKENetworkParamSet{
+				gkeNetworkParams(defaultGKENetworkParamsName, defaultVPCName, defaultVPCSubnetName, []string{defaultSecondaryRangeA, defaultSecondaryRangeB}),
+				gkeNetworkParams(redGKENetworkParamsName, redVPCName, redVPCSubnetName, []string{redSecondaryRangeA, redSecondaryRangeB}),
+			},
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "test",
+							Annotations: map[string]string{
+								networkv1.NodeNetworkAnnotationKey: fmt.Sprintf("[{\"name\":\"%s\"},{\"name\":\"%s\"}]", networkv1.DefaultPodNetworkName, redNetworkName),
+							},
+						},
+						Spec: v1.NodeSpec{
+							ProviderID: "gce://test-project/us-central1-b/test",
+						},
+						Status: v1.NodeStatus{
+							Capacity: v1.ResourceList{},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			gceInstance: []*compute.Instance{
+				{
+					Name: "test",
+					NetworkInterfaces: []*compute.NetworkInterface{
+						interfaces(defaultVPCName, defaultVPCSubnetName, "80.1.172.1", []*compute.AliasIpRange{
+							{IpCidrRange: "192.168.1.0/24", SubnetworkRangeName: defaultSecondaryRangeA},
+						}),
+						interfaces(redVPCName, redVPCSubnetName, "10.1.1.1", []*compute.AliasIpRange{
+							{IpCidrRange: "172.11.1.0/24", SubnetworkRangeName: redSecondaryRangeA},
+							{IpCidrRange: "172.12.1.0/25", SubnetworkRangeName: redSecondaryRangeB},
+						}),
+					},
+				},
+			},
+			nodeChanges: func(node *v1.Node) {
+				node.Spec.PodCIDR = "192.168.1.0/24"
+				node.Spec.PodCIDRs = []string{"192.168.1.0/24"}
+				node.Status.Conditions = []v1.NodeCondition{
+					{
+						Type:    "NetworkUnavailable",
+						Status:  "False",
+						Reason:  "RouteCreated",
+						Message: "NodeController create implicit route",
+					},
+				}
+				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
+				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\",\"172.12.1.0/25\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"},{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.12.1.0/25\"}]", redNetworkName, redSecondaryRangeA, redNetworkName, redSecondaryRangeB)
+				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
+					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(192, resource.DecimalSI),
+				}
+			},
+			expectedUpdate: true,
+			expectedMetrics: map[string]float64{
+				redNetworkName: float64(1),
+			},
+		},
 		{
 			// this is incorrect configuration, Network should be Device type in such situation
 			// no annotation change for such network
@@ -815,6 +884,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -881,6 +951,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -951,6 +1022,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"},{\"network\":\"%s\",\"ipAddress\":\"84.1.2.1\"}]", redNetworkName, blueNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"},{\"name\":\"%s\",\"cidrs\":[\"20.28.1.0/26\"],\"scope\":\"host-local\"}]", redNetworkName, blueNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"},{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"20.28.1.0/26\"}]", redNetworkName, redSecondaryRangeA, blueNetworkName, blueSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP":  *resource.NewQuantity(128, resource.DecimalSI),
 					"networking.gke.io.networks/Blue-Network.IP": *resource.NewQuantity(32, resource.DecimalSI),
@@ -1020,6 +1092,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -1140,6 +1213,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/32\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/32\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(1, resource.DecimalSI),
 				}
@@ -1170,6 +1244,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 								networkv1.NodeNetworkAnnotationKey:     fmt.Sprintf("[{\"name\":\"%s\"},{\"name\":\"%s\"},{\"name\":\"%s\"}]", networkv1.DefaultPodNetworkName, redNetworkName, blueNetworkName),
 								networkv1.NorthInterfacesAnnotationKey: fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"},{\"network\":\"%s\",\"ipAddress\":\"84.1.2.1\"}]", redNetworkName, blueNetworkName),
 								networkv1.MultiNetworkAnnotationKey:    fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"},{\"name\":\"%s\",\"cidrs\":[\"20.28.1.0/26\"],\"scope\":\"host-local\"}]", redNetworkName, blueNetworkName),
+								networkv1.PodRangesAnnotationKey:       fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"},{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"20.28.1.0/26\"}]", redNetworkName, redSecondaryRangeA, blueNetworkName, blueSecondaryRangeA),
 							},
 						},
 						Spec: v1.NodeSpec{
@@ -1234,6 +1309,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 								networkv1.NodeNetworkAnnotationKey:     fmt.Sprintf("[{\"name\":\"%s\"},{\"name\":\"%s\"}]", networkv1.DefaultPodNetworkName, redNetworkName),
 								networkv1.NorthInterfacesAnnotationKey: fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"},{\"network\":\"%s\",\"ipAddress\":\"84.1.2.1\"}]", redNetworkName, blueNetworkName),
 								networkv1.MultiNetworkAnnotationKey:    fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"},{\"name\":\"%s\",\"cidrs\":[\"20.28.1.0/26\"],\"scope\":\"host-local\"}]", redNetworkName, blueNetworkName),
+								networkv1.PodRangesAnnotationKey:       fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"},{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"20.28.1.0/26\"}]", redNetworkName, redSecondaryRangeA, blueNetworkName, blueSecondaryRangeA),
 							},
 						},
 						Spec: v1.NodeSpec{
@@ -1277,6 +1353,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			},
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -1304,6 +1381,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 								networkv1.NodeNetworkAnnotationKey:     fmt.Sprintf("[{\"name\":\"%s\"},{\"name\":\"%s\"},{\"name\":\"%s\"}]", networkv1.DefaultPodNetworkName, redNetworkName, blueNetworkName),
 								networkv1.NorthInterfacesAnnotationKey: fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"},{\"network\":\"%s\",\"ipAddress\":\"84.1.2.1\"}]", redNetworkName, blueNetworkName),
 								networkv1.MultiNetworkAnnotationKey:    fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"},{\"name\":\"%s\",\"cidrs\":[\"20.28.1.0/26\"],\"scope\":\"host-local\"}]", redNetworkName, blueNetworkName),
+								networkv1.PodRangesAnnotationKey:       fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"},{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"20.28.1.0/26\"}]", redNetworkName, redSecondaryRangeA, blueNetworkName, blueSecondaryRangeA),
 							},
 						},
 						Spec: v1.NodeSpec{
@@ -1348,6 +1426,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"172.11.1.0/24\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"rangeName\":\"%s\",\"cidr\":\"172.11.1.0/24\"}]", redNetworkName, redSecondaryRangeA)
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(128, resource.DecimalSI),
 				}
@@ -1409,6 +1488,7 @@ func TestUpdateCIDRAllocation(t *testing.T) {
 				}
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = fmt.Sprintf("[{\"network\":\"%s\",\"ipAddress\":\"10.1.1.1\"}]", redNetworkName)
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = fmt.Sprintf("[{\"name\":\"%s\",\"cidrs\":[\"10.1.1.1/32\"],\"scope\":\"host-local\"}]", redNetworkName)
+				node.Annotations[networkv1.PodRangesAnnotationKey] = "[]"
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/Red-Network.IP": *resource.NewQuantity(1, resource.DecimalSI),
 				}
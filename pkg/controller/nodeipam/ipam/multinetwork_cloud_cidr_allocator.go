@@ -18,14 +18,17 @@ import (
 
 // performMultiNetworkCIDRAllocation receives the existing Node object and its
 // GCE interfaces, and is updated with the corresponding annotations for
-// MultiNetwork and NorthInterfaces and the capacity for the additional networks.
+// MultiNetwork, NorthInterfaces and PodRanges and the capacity for the additional networks.
 // It also returns calculated cidrs for default Network if there're no node labels.
+// An additional network gets the CIDRs of all the secondary ranges of its GKENetworkParamSet
+// allocated to the interface, the default Network the CIDR of the first one.
 //
 // NorthInterfacesAnnotationKey is modified on Network Ready condition changes.
-// MultiNetworkAnnotationKey is modified on Node's NodeNetworkAnnotationKey changes.
+// MultiNetworkAnnotationKey and PodRangesAnnotationKey are modified on Node's NodeNetworkAnnotationKey changes.
 func (ca *cloudCIDRAllocator) performMultiNetworkCIDRAllocation(node *v1.Node, interfaces []*compute.NetworkInterface, hasNodeLabels bool) (defaultNwCIDRs []string, err error) {
 	northInterfaces := networkv1.NorthInterfacesAnnotation{}
 	additionalNodeNetworks := networkv1.MultiNetworkAnnotation{}
+	podRanges := networkv1.PodRangesAnnotation{}
 
 	k8sNetworksList, err := ca.networksLister.List(labels.Everything())
 	if err != nil {
@@ -89,34 +92,43 @@ func (ca *cloudCIDRAllocator) performMultiNetworkCIDRAllocation(node *v1.Node, i
 			}
 
 			// Each secondary range in a subnet corresponds to a pod-network. AliasIPRanges list on a node interface consists of IP ranges that belong to multiple secondary ranges (pod-networks).
-			// Match the secondary range names of interface and GKENetworkParams and set the right IpCidrRange for current network.
+			// Match the secondary range names of interface and GKENetworkParams and set the right IpCidrRange(s) for current network.
+			var cidrs []string
+			var networkPodRanges networkv1.PodRangesAnnotation
 			for _, secondaryRangeName := range secondaryRangeNames {
 				ipRange, ok := rangeNameAliasIPMap[secondaryRangeName]
 				if !ok {
 					continue
 				}
-				klog.V(2).InfoS("found an allocatable secondary range for the interface on network", "nodeName", node.Name, "networkName", network.Name)
-				processedNetworks[network.Name] = struct{}{}
-				// for defaultNwCIDRs, if there're no NodeLabels keep this,
-				// otherwise get the CIDR with labels
-				if networkv1.IsDefaultNetwork(network.Name) && !hasNodeLabels {
-					defaultNwCIDRs = append(defaultNwCIDRs, ipRange.IpCidrRange)
-					ipv6Addr := ca.cloud.GetIPV6Address(inf)
-					if ipv6Addr != nil {
-						defaultNwCIDRs = append(defaultNwCIDRs, ipv6Addr.String())
-					}
-				}
-				if !networkv1.IsDefaultNetwork(network.Name) {
-					northInterfaces = append(northInterfaces, networkv1.NorthInterface{Network: network.Name, IpAddress: inf.NetworkIP})
-					if _, ok := upStatusNetworks[network.Name]; ok {
-						additionalNodeNetworks = append(additionalNodeNetworks, networkv1.NodeNetwork{Name: network.Name, Scope: "host-local", Cidrs: []string{ipRange.IpCidrRange}})
+				klog.V(2).InfoS("found an allocatable secondary range for the interface on network", "nodeName", node.Name, "networkName", network.Name, "rangeName", secondaryRangeName)
+				if networkv1.IsDefaultNetwork(network.Name) {
+					processedNetworks[network.Name] = struct{}{}
+					// for defaultNwCIDRs, if there're no NodeLabels keep this,
+					// otherwise get the CIDR with labels
+					if !hasNodeLabels {
+						defaultNwCIDRs = append(defaultNwCIDRs, ipRange.IpCidrRange)
+						ipv6Addr := ca.cloud.GetIPV6Address(inf)
+						if ipv6Addr != nil {
+							defaultNwCIDRs = append(defaultNwCIDRs, ipv6Addr.String())
+						}
 					}
+					break
 				}
-				break
+				cidrs = append(cidrs, ipRange.IpCidrRange)
+				networkPodRanges = append(networkPodRanges, networkv1.NodePodRange{Network: network.Name, RangeName: secondaryRangeName, Cidr: ipRange.IpCidrRange})
+			}
+			if len(cidrs) == 0 {
+				continue
+			}
+			processedNetworks[network.Name] = struct{}{}
+			northInterfaces = append(northInterfaces, networkv1.NorthInterface{Network: network.Name, IpAddress: inf.NetworkIP})
+			if _, ok := upStatusNetworks[network.Name]; ok {
+				additionalNodeNetworks = append(additionalNodeNetworks, networkv1.NodeNetwork{Name: network.Name, Scope: "host-local", Cidrs: cidrs})
+				podRanges = append(podRanges, networkPodRanges...)
 			}
 		}
 	}
-	if err = updateAnnotations(node, northInterfaces, additionalNodeNetworks); err != nil {
+	if err = updateAnnotations(node, northInterfaces, additionalNodeNetworks, podRanges); err != nil {
 		return nil, err
 	}
 	return defaultNwCIDRs, nil
@@ -158,7 +170,7 @@ out:
 	return defaultNwCIDRs
 }
 
-func updateAnnotations(node *v1.Node, northInterfaces networkv1.NorthInterfacesAnnotation, additionalNodeNetworks networkv1.MultiNetworkAnnotation) error {
+func updateAnnotations(node *v1.Node, northInterfaces networkv1.NorthInterfacesAnnotation, additionalNodeNetworks networkv1.MultiNetworkAnnotation, podRanges networkv1.PodRangesAnnotation) error {
 	northInterfaceAnn, err := networkv1.MarshalNorthInterfacesAnnotation(northInterfaces)
 	if err != nil {
 		klog.ErrorS(err, "Failed to marshal the north interfaces annotation for multi-networking", "nodeName", node.Name)
@@ -169,6 +181,11 @@ func updateAnnotations(node *v1.Node, northInterfaces networkv1.NorthInterfacesA
 		klog.ErrorS(err, "Failed to marshal the additional node networks annotation for multi-networking", "nodeName", node.Name)
 		return err
 	}
+	podRangesAnn, err := networkv1.MarshalPodRangesAnnotation(podRanges)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal the pod ranges annotation for multi-networking", "nodeName", node.Name)
+		return err
+	}
 	if node.Annotations == nil {
 		node.Annotations = make(map[string]string)
 	}
@@ -179,6 +196,7 @@ func updateAnnotations(node *v1.Node, northInterfaces networkv1.NorthInterfacesA
 	}
 	node.Status.Capacity = capacity
 	node.Annotations[networkv1.MultiNetworkAnnotationKey] = additionalNodeNwAnn
+	node.Annotations[networkv1.PodRangesAnnotationKey] = podRangesAnn
 	return nil
 }
 
@@ -209,21 +227,27 @@ func resourceName(name string) string {
 	return parts[len(parts)-1]
 }
 
+// getNodeCapacity returns the number of IPs of the network on the node, summed
+// over the CIDRs of its secondary ranges.
 func getNodeCapacity(nw networkv1.NodeNetwork) (int64, error) {
 	if len(nw.Cidrs) < 1 {
 		return -1, fmt.Errorf("network %s is missing CIDRs", nw.Name)
 	}
-	_, ipNet, err := net.ParseCIDR(nw.Cidrs[0])
-	if err != nil {
-		return -1, err
-	}
-	var ipCount int64 = 1
-	size := netutils.RangeSize(ipNet)
-	if size > 1 {
-		// The number of IPs supported are halved and returned for overprovisioning purposes.
-		ipCount = size >> 1
+	var capacity int64
+	for _, cidr := range nw.Cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return -1, err
+		}
+		var ipCount int64 = 1
+		size := netutils.RangeSize(ipNet)
+		if size > 1 {
+			// The number of IPs supported are halved and returned for overprovisioning purposes.
+			ipCount = size >> 1
+		}
+		capacity += ipCount
 	}
-	return ipCount, nil
+	return capacity, nil
 }
 
 func getUpNetworks(node *v1.Node) (map[string]struct{}, error) {
@@ -17,8 +17,10 @@ limitations under the License.
 package ipam
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 
 	"k8s.io/api/core/v1"
@@ -28,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	informers "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -38,6 +41,8 @@ import (
 	"k8s.io/cloud-provider-gcp/pkg/controller/nodeipam/ipam/cidrset"
 	nodeutil "k8s.io/cloud-provider-gcp/pkg/util"
 	utilnode "k8s.io/cloud-provider-gcp/pkg/util/node"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	netutils "k8s.io/utils/net"
 )
 
 // cidrs are reserved, then node resource is patched with them
@@ -45,6 +50,8 @@ import (
 type nodeReservedCIDRs struct {
 	allocatedCIDRs []*net.IPNet
 	nodeName       string
+	// cidrSets are the cidrSets the cidrs are reserved from, by index
+	cidrSets []*cidrset.CidrSet
 }
 
 type rangeAllocator struct {
@@ -53,6 +60,12 @@ type rangeAllocator struct {
 	clusterCIDRs []*net.IPNet
 	// for each entry in clusterCIDRs we maintain a list of what is used and what is not
 	cidrSets []*cidrset.CidrSet
+	// zoneClusterCIDRs are the cluster cidrs of the nodes of each zone of a stretched cluster
+	zoneClusterCIDRs map[string][]*net.IPNet
+	// for each zone of zoneClusterCIDRs we maintain the cidrSets of its cluster cidrs
+	zoneCIDRSets map[string][]*cidrset.CidrSet
+	// nodeCIDRMaskSizes are the default mask sizes of the node CIDRs, by clusterCIDRs index
+	nodeCIDRMaskSizes []int
 	// nodeLister is able to list/get nodes and is populated by the shared informer passed to controller
 	nodeLister corelisters.NodeLister
 	// nodesSynced returns true if the node shared informer has been synced at least once.
@@ -64,6 +77,10 @@ type rangeAllocator struct {
 	// Keep a set of nodes that are currently being processed to avoid races in CIDR allocation
 	lock              sync.Mutex
 	nodesInProcessing sets.String
+	// nodeCIDRSets are the cidrSets the CIDRs of the nodes of a stretched cluster
+	// are occupied or allocated from, by node name, so that they are released
+	// to the same cidrSets whatever the zone of the node when it is deleted
+	nodeCIDRSets map[string][]*cidrset.CidrSet
 }
 
 // NewCIDRRangeAllocator returns a CIDRAllocator to allocate CIDRs for node (one from each of clusterCIDRs)
@@ -93,16 +110,42 @@ func NewCIDRRangeAllocator(client clientset.Interface, nodeInformer informers.No
 		cidrSets[idx] = cidrSet
 	}
 
+	// the cidrSets of a zone are mapped to the cluster cidrs of the zone by
+	// index, which have the IP families of the ClusterCIDRs so that they share
+	// the node cidr mask sizes
+	zoneCIDRSets := make(map[string][]*cidrset.CidrSet, len(allocatorParams.ZoneClusterCIDRs))
+	for zone, cidrs := range allocatorParams.ZoneClusterCIDRs {
+		if len(cidrs) != len(allocatorParams.ClusterCIDRs) {
+			return nil, fmt.Errorf("zone %s has %d cluster cidrs, expected %d like the cluster cidrs", zone, len(cidrs), len(allocatorParams.ClusterCIDRs))
+		}
+		zoneCIDRSets[zone] = make([]*cidrset.CidrSet, len(cidrs))
+		for idx, cidr := range cidrs {
+			if netutils.IsIPv6CIDR(cidr) != netutils.IsIPv6CIDR(allocatorParams.ClusterCIDRs[idx]) {
+				return nil, fmt.Errorf("cluster cidr %v of zone %s at index:%v is not of the IP family of the cluster cidr %v", cidr, zone, idx, allocatorParams.ClusterCIDRs[idx])
+			}
+			cidrSet, err := cidrset.NewCIDRSet(cidr, allocatorParams.NodeCIDRMaskSizes[idx])
+			if err != nil {
+				return nil, err
+			}
+			zoneCIDRSets[zone][idx] = cidrSet
+		}
+	}
+
 	ra := &rangeAllocator{
 		client:                client,
 		clusterCIDRs:          allocatorParams.ClusterCIDRs,
 		cidrSets:              cidrSets,
+		zoneClusterCIDRs:      allocatorParams.ZoneClusterCIDRs,
+		zoneCIDRSets:          zoneCIDRSets,
+		nodeCIDRMaskSizes:     allocatorParams.NodeCIDRMaskSizes,
 		nodeLister:            nodeInformer.Lister(),
 		nodesSynced:           nodeInformer.Informer().HasSynced,
 		nodeCIDRUpdateChannel: make(chan nodeReservedCIDRs, cidrUpdateQueueSize),
 		recorder:              recorder,
 		nodesInProcessing:     sets.NewString(),
+		nodeCIDRSets:          make(map[string][]*cidrset.CidrSet),
 	}
+	registerAllocationMetrics()
 
 	if allocatorParams.ServiceCIDR != nil {
 		ra.filterOutServiceRange(allocatorParams.ServiceCIDR)
@@ -179,6 +222,7 @@ func (r *rangeAllocator) Run(stopCh <-chan struct{}) {
 	for i := 0; i < cidrUpdateWorkers; i++ {
 		go r.worker(stopCh)
 	}
+	go wait.Until(func() { reportUnallocatedNodes(r.nodeLister) }, unallocatedNodesReportPeriod, stopCh)
 
 	<-stopCh
 }
@@ -223,6 +267,8 @@ func (r *rangeAllocator) occupyCIDRs(node *v1.Node) error {
 	if len(node.Spec.PodCIDRs) == 0 {
 		return nil
 	}
+	_, cidrSets := r.cidrsFor(node)
+	r.recordNodeCIDRSets(node.Name, cidrSets)
 	for idx, cidr := range node.Spec.PodCIDRs {
 		_, podCIDR, err := net.ParseCIDR(cidr)
 		if err != nil {
@@ -231,11 +277,11 @@ func (r *rangeAllocator) occupyCIDRs(node *v1.Node) error {
 		// If node has a pre allocate cidr that does not exist in our cidrs.
 		// This will happen if cluster went from dualstack(multi cidrs) to non-dualstack
 		// then we have now way of locking it
-		if idx >= len(r.cidrSets) {
+		if idx >= len(cidrSets) {
 			return fmt.Errorf("node:%s has an allocated cidr: %v at index:%v that does not exist in cluster cidrs configuration", node.Name, cidr, idx)
 		}
 
-		if err := r.cidrSets[idx].Occupy(podCIDR); err != nil {
+		if err := cidrSets[idx].Occupy(podCIDR); err != nil {
 			return fmt.Errorf("failed to mark cidr[%v] at idx [%v] as occupied for node: %v: %v", podCIDR, idx, node.Name, err)
 		}
 	}
@@ -257,21 +303,36 @@ func (r *rangeAllocator) AllocateOrOccupyCIDR(node *v1.Node) error {
 	if len(node.Spec.PodCIDRs) > 0 {
 		return r.occupyCIDRs(node)
 	}
+	// the CIDRs of the nodes of a stretched cluster are allocated from the
+	// cluster cidrs of their zone, wait for the zone to be known. The node is
+	// allocated on its next update.
+	if len(r.zoneCIDRSets) > 0 && nodeZone(node) == "" {
+		klog.V(2).Infof("Node %v has no zone yet, waiting for it to allocate its CIDRs.", node.Name)
+		r.removeNodeFromProcessing(node.Name)
+		return nil
+	}
 	// allocate and queue the assignment
+	clusterCIDRs, cidrSets := r.cidrsFor(node)
 	allocated := nodeReservedCIDRs{
 		nodeName:       node.Name,
-		allocatedCIDRs: make([]*net.IPNet, len(r.cidrSets)),
+		allocatedCIDRs: make([]*net.IPNet, len(cidrSets)),
+		cidrSets:       cidrSets,
 	}
 
-	for idx := range r.cidrSets {
-		podCIDR, err := r.cidrSets[idx].AllocateNext()
+	maskSizes := r.nodeCIDRMaskSizesFor(node, clusterCIDRs)
+	for idx := range cidrSets {
+		podCIDR, err := cidrSets[idx].AllocateNextWithMaskSize(maskSizes[idx])
 		if err != nil {
 			r.removeNodeFromProcessing(node.Name)
 			nodeutil.RecordNodeStatusChange(r.recorder, node, "CIDRNotAvailable")
+			if errors.Is(err, cidrset.ErrCIDRRangeNoCIDRsRemaining) {
+				recordCIDRAllocationFailure(allocationFailureRangeExhausted)
+			}
 			return fmt.Errorf("failed to allocate cidr from cluster cidr at idx:%v: %v", idx, err)
 		}
 		allocated.allocatedCIDRs[idx] = podCIDR
 	}
+	r.recordNodeCIDRSets(node.Name, cidrSets)
 
 	//queue the assignment
 	klog.V(4).Infof("Putting node %s with CIDR %v into the work queue", node.Name, allocated.allocatedCIDRs)
@@ -279,12 +340,88 @@ func (r *rangeAllocator) AllocateOrOccupyCIDR(node *v1.Node) error {
 	return nil
 }
 
+// cidrsFor returns the cluster cidrs the CIDRs of the node are allocated from
+// and their cidrSets: the ones of the zone of the node if the cluster is
+// stretched over the zone, the cluster cidrs otherwise.
+func (r *rangeAllocator) cidrsFor(node *v1.Node) ([]*net.IPNet, []*cidrset.CidrSet) {
+	zone := nodeZone(node)
+	if cidrSets, ok := r.zoneCIDRSets[zone]; ok {
+		return r.zoneClusterCIDRs[zone], cidrSets
+	}
+	return r.clusterCIDRs, r.cidrSets
+}
+
+// nodeZone returns the zone of the node from its zone label, or from its
+// providerID before the label is set. It returns "" if the zone is unknown.
+func nodeZone(node *v1.Node) string {
+	if zone := node.Labels[v1.LabelTopologyZone]; zone != "" {
+		return zone
+	}
+	if _, zone, _, err := gce.SplitProviderID(node.Spec.ProviderID); err == nil {
+		return zone
+	}
+	return ""
+}
+
+// recordNodeCIDRSets records the cidrSets the CIDRs of the node are occupied
+// or allocated from, if the cluster is stretched over zones.
+func (r *rangeAllocator) recordNodeCIDRSets(nodeName string, cidrSets []*cidrset.CidrSet) {
+	if len(r.zoneCIDRSets) == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nodeCIDRSets[nodeName] = cidrSets
+}
+
+// forgetNodeCIDRSets forgets the cidrSets recorded for the node and returns
+// them, or nil if there are none.
+func (r *rangeAllocator) forgetNodeCIDRSets(nodeName string) []*cidrset.CidrSet {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cidrSets := r.nodeCIDRSets[nodeName]
+	delete(r.nodeCIDRSets, nodeName)
+	return cidrSets
+}
+
+// nodeCIDRMaskSizesFor returns the mask sizes of the CIDRs to allocate to the
+// node from clusterCIDRs. The IPv4 mask size can be lowered for the node with the
+// NodePodCIDRMaskSizeLabel label, so that large nodes get larger CIDRs than
+// the default ones. Invalid label values are ignored with an event.
+func (r *rangeAllocator) nodeCIDRMaskSizesFor(node *v1.Node, clusterCIDRs []*net.IPNet) []int {
+	maskSizes := append([]int(nil), r.nodeCIDRMaskSizes...)
+	value, ok := node.Labels[utilnode.NodePodCIDRMaskSizeLabel]
+	if !ok {
+		return maskSizes
+	}
+	maskSize, err := strconv.Atoi(value)
+	for idx, cidr := range clusterCIDRs {
+		if cidr.IP.To4() == nil {
+			continue
+		}
+		clusterMaskSize, _ := cidr.Mask.Size()
+		if err != nil || maskSize < clusterMaskSize || maskSize > maskSizes[idx] {
+			klog.Warningf("Node %v has invalid %s label %q, must be a mask size between %d and %d. Using the default mask size.", node.Name, utilnode.NodePodCIDRMaskSizeLabel, value, clusterMaskSize, maskSizes[idx])
+			nodeutil.RecordNodeStatusChange(r.recorder, node, "CIDRMaskSizeInvalid")
+			continue
+		}
+		maskSizes[idx] = maskSize
+	}
+	return maskSizes
+}
+
 // ReleaseCIDR marks node.podCIDRs[...] as unused in our tracked cidrSets
 func (r *rangeAllocator) ReleaseCIDR(node *v1.Node) error {
 	if node == nil || len(node.Spec.PodCIDRs) == 0 {
 		return nil
 	}
 
+	// release the CIDRs to the cidrSets they were occupied or allocated from,
+	// the zone label of the node may have changed since
+	cidrSets := r.forgetNodeCIDRSets(node.Name)
+	if cidrSets == nil {
+		_, cidrSets = r.cidrsFor(node)
+	}
 	for idx, cidr := range node.Spec.PodCIDRs {
 		_, podCIDR, err := net.ParseCIDR(cidr)
 		if err != nil {
@@ -294,34 +431,43 @@ func (r *rangeAllocator) ReleaseCIDR(node *v1.Node) error {
 		// If node has a pre allocate cidr that does not exist in our cidrs.
 		// This will happen if cluster went from dualstack(multi cidrs) to non-dualstack
 		// then we have now way of locking it
-		if idx >= len(r.cidrSets) {
+		if idx >= len(cidrSets) {
 			return fmt.Errorf("node:%s has an allocated cidr: %v at index:%v that does not exist in cluster cidrs configuration", node.Name, cidr, idx)
 		}
 
 		klog.V(4).Infof("release CIDR %s for node:%v", cidr, node.Name)
-		if err = r.cidrSets[idx].Release(podCIDR); err != nil {
+		if err = cidrSets[idx].Release(podCIDR); err != nil {
 			return fmt.Errorf("error when releasing CIDR %v: %v", cidr, err)
 		}
 	}
 	return nil
 }
 
-// Marks all CIDRs with subNetMaskSize that belongs to serviceCIDR as used across all cidrs
-// so that they won't be assignable.
+// Marks all CIDRs with subNetMaskSize that belongs to serviceCIDR as used across all cidrs,
+// including the ones of the zones, so that they won't be assignable.
 func (r *rangeAllocator) filterOutServiceRange(serviceCIDR *net.IPNet) {
+	filterOutServiceRangeFrom(serviceCIDR, r.clusterCIDRs, r.cidrSets)
+	for zone, cidrSets := range r.zoneCIDRSets {
+		filterOutServiceRangeFrom(serviceCIDR, r.zoneClusterCIDRs[zone], cidrSets)
+	}
+}
+
+// filterOutServiceRangeFrom marks the CIDRs of serviceCIDR as used in the
+// cidrSets of clusterCIDRs.
+func filterOutServiceRangeFrom(serviceCIDR *net.IPNet, clusterCIDRs []*net.IPNet, cidrSets []*cidrset.CidrSet) {
 	// Checks if service CIDR has a nonempty intersection with cluster
 	// CIDR. It is the case if either clusterCIDR contains serviceCIDR with
 	// clusterCIDR's Mask applied (this means that clusterCIDR contains
 	// serviceCIDR) or vice versa (which means that serviceCIDR contains
 	// clusterCIDR).
-	for idx, cidr := range r.clusterCIDRs {
+	for idx, cidr := range clusterCIDRs {
 		// if they don't overlap then ignore the filtering
 		if !cidr.Contains(serviceCIDR.IP.Mask(cidr.Mask)) && !serviceCIDR.Contains(cidr.IP.Mask(serviceCIDR.Mask)) {
 			continue
 		}
 
 		// at this point, len(cidrSet) == len(clusterCidr)
-		if err := r.cidrSets[idx].Occupy(serviceCIDR); err != nil {
+		if err := cidrSets[idx].Occupy(serviceCIDR); err != nil {
 			klog.Errorf("Error filtering out service cidr out cluster cidr:%v (index:%v) %v: %v", cidr, idx, serviceCIDR, err)
 		}
 	}
@@ -359,8 +505,9 @@ func (r *rangeAllocator) updateCIDRsAllocation(data nodeReservedCIDRs) error {
 	// node has cidrs, release the reserved
 	if len(node.Spec.PodCIDRs) != 0 {
 		klog.Errorf("Node %v already has a CIDR allocated %v. Releasing the new one.", node.Name, node.Spec.PodCIDRs)
+		r.forgetNodeCIDRSets(node.Name)
 		for idx, cidr := range data.allocatedCIDRs {
-			if releaseErr := r.cidrSets[idx].Release(cidr); releaseErr != nil {
+			if releaseErr := data.cidrSets[idx].Release(cidr); releaseErr != nil {
 				klog.Errorf("Error when releasing CIDR idx:%v value: %v err:%v", idx, cidr, releaseErr)
 			}
 		}
@@ -371,9 +518,11 @@ func (r *rangeAllocator) updateCIDRsAllocation(data nodeReservedCIDRs) error {
 	for i := 0; i < cidrUpdateRetries; i++ {
 		if err = utilnode.PatchNodeCIDRs(r.client, types.NodeName(node.Name), cidrsString); err == nil {
 			klog.Infof("Set node %v PodCIDR to %v", node.Name, cidrsString)
+			recordCIDRAllocated(node)
 			return nil
 		}
 	}
+	recordCIDRAllocationFailure(updateFailureReason(err))
 	// failed release back to the pool
 	klog.Errorf("Failed to update node %v PodCIDR to %v after multiple attempts: %v", node.Name, cidrsString, err)
 	nodeutil.RecordNodeStatusChange(r.recorder, node, "CIDRAssignmentFailed")
@@ -382,8 +531,9 @@ func (r *rangeAllocator) updateCIDRsAllocation(data nodeReservedCIDRs) error {
 	// NodeController restart will return all falsely allocated CIDRs to the pool.
 	if !apierrors.IsServerTimeout(err) {
 		klog.Errorf("CIDR assignment for node %v failed: %v. Releasing allocated CIDR", node.Name, err)
+		r.forgetNodeCIDRSets(node.Name)
 		for idx, cidr := range data.allocatedCIDRs {
-			if releaseErr := r.cidrSets[idx].Release(cidr); releaseErr != nil {
+			if releaseErr := data.cidrSets[idx].Release(cidr); releaseErr != nil {
 				klog.Errorf("Error releasing allocated CIDR for node %v: %v", node.Name, releaseErr)
 			}
 		}
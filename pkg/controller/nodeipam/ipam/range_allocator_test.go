@@ -29,6 +29,7 @@ import (
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/cloud-provider-gcp/pkg/controller/testutil"
+	utilnode "k8s.io/cloud-provider-gcp/pkg/util/node"
 )
 
 const testNodePollInterval = 10 * time.Millisecond
@@ -267,6 +268,101 @@ func TestOccupyPreExistingCIDR(t *testing.T) {
 			expectedAllocatedCIDR: nil,
 			ctrlCreateFail:        true,
 		},
+		{
+			description: "success, node allocation from the cluster CIDRs of its zone",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{v1.LabelTopologyZone: "us-central1-a"},
+						},
+						Spec: v1.NodeSpec{
+							PodCIDRs: []string{"10.20.0.0/24"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDRv4, _ := net.ParseCIDR("10.10.0.0/16")
+					return []*net.IPNet{clusterCIDRv4}
+				}(),
+				ZoneClusterCIDRs: func() map[string][]*net.IPNet {
+					_, zoneCIDRv4, _ := net.ParseCIDR("10.20.0.0/16")
+					return map[string][]*net.IPNet{"us-central1-a": {zoneCIDRv4}}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{24},
+			},
+			allocatedCIDRs:        nil,
+			expectedAllocatedCIDR: nil,
+			ctrlCreateFail:        false,
+		},
+		{
+			description: "fail, node allocation out of the cluster CIDRs of its zone",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{v1.LabelTopologyZone: "us-central1-a"},
+						},
+						Spec: v1.NodeSpec{
+							PodCIDRs: []string{"10.10.0.0/24"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDRv4, _ := net.ParseCIDR("10.10.0.0/16")
+					return []*net.IPNet{clusterCIDRv4}
+				}(),
+				ZoneClusterCIDRs: func() map[string][]*net.IPNet {
+					_, zoneCIDRv4, _ := net.ParseCIDR("10.20.0.0/16")
+					return map[string][]*net.IPNet{"us-central1-a": {zoneCIDRv4}}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{24},
+			},
+			allocatedCIDRs:        nil,
+			expectedAllocatedCIDR: nil,
+			ctrlCreateFail:        true,
+		},
+		{
+			description: "fail, zone cluster CIDRs of another IP family than the cluster CIDRs",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "node0",
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDRv4, _ := net.ParseCIDR("10.10.0.0/16")
+					return []*net.IPNet{clusterCIDRv4}
+				}(),
+				ZoneClusterCIDRs: func() map[string][]*net.IPNet {
+					_, zoneCIDRv6, _ := net.ParseCIDR("ace:cab:deca::/112")
+					return map[string][]*net.IPNet{"us-central1-a": {zoneCIDRv6}}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{120},
+			},
+			allocatedCIDRs:        nil,
+			expectedAllocatedCIDR: nil,
+			ctrlCreateFail:        true,
+		},
 		{
 			description: "fail, dualstack node allocating bad v6",
 
@@ -531,6 +627,157 @@ func TestAllocateOrOccupyCIDRSuccess(t *testing.T) {
 				0: "10.10.1.0/24",
 			},
 		},
+		{
+			description: "Allocate a larger CIDR to a node with the mask size label",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{utilnode.NodePodCIDRMaskSizeLabel: "24"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDR, _ := net.ParseCIDR("10.10.0.0/22")
+					return []*net.IPNet{clusterCIDR}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{26},
+			},
+			allocatedCIDRs: map[int][]string{
+				0: {"10.10.0.0/26"},
+			},
+			expectedAllocatedCIDR: map[int]string{
+				0: "10.10.1.0/24",
+			},
+		},
+		{
+			description: "Allocate a larger IPv4 CIDR to a dual stack node with the mask size label",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{utilnode.NodePodCIDRMaskSizeLabel: "24"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDRv4, _ := net.ParseCIDR("10.10.0.0/22")
+					_, clusterCIDRv6, _ := net.ParseCIDR("ace:cab:deca::/112")
+					return []*net.IPNet{clusterCIDRv4, clusterCIDRv6}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{26, 120},
+			},
+			expectedAllocatedCIDR: map[int]string{
+				0: "10.10.0.0/24",
+				1: "ace:cab:deca::/120",
+			},
+		},
+		{
+			description: "Allocate the CIDR of a node from the cluster CIDRs of its zone",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{v1.LabelTopologyZone: "us-central1-b"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDR, _ := net.ParseCIDR("10.10.0.0/22")
+					return []*net.IPNet{clusterCIDR}
+				}(),
+				ZoneClusterCIDRs: func() map[string][]*net.IPNet {
+					_, zoneCIDRA, _ := net.ParseCIDR("10.20.0.0/22")
+					_, zoneCIDRB, _ := net.ParseCIDR("10.30.0.0/22")
+					return map[string][]*net.IPNet{"us-central1-a": {zoneCIDRA}, "us-central1-b": {zoneCIDRB}}
+				}(),
+				// the service range is filtered out of the cluster CIDRs of the zones too
+				ServiceCIDR: func() *net.IPNet {
+					_, serviceCIDR, _ := net.ParseCIDR("10.30.0.0/24")
+					return serviceCIDR
+				}(),
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{24},
+			},
+			expectedAllocatedCIDR: map[int]string{
+				0: "10.30.1.0/24",
+			},
+		},
+		{
+			description: "Allocate the CIDRs of a node of a zone without cluster CIDRs from the cluster CIDRs",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{v1.LabelTopologyZone: "us-central1-c"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDRv4, _ := net.ParseCIDR("10.10.0.0/22")
+					_, clusterCIDRv6, _ := net.ParseCIDR("ace:cab:deca::/112")
+					return []*net.IPNet{clusterCIDRv4, clusterCIDRv6}
+				}(),
+				ZoneClusterCIDRs: func() map[string][]*net.IPNet {
+					_, zoneCIDRv4, _ := net.ParseCIDR("10.20.0.0/22")
+					_, zoneCIDRv6, _ := net.ParseCIDR("ace:cab:decb::/112")
+					return map[string][]*net.IPNet{"us-central1-a": {zoneCIDRv4, zoneCIDRv6}}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{24, 120},
+			},
+			expectedAllocatedCIDR: map[int]string{
+				0: "10.10.0.0/24",
+				1: "ace:cab:deca::/120",
+			},
+		},
+		{
+			description: "Ignore an invalid mask size label",
+			fakeNodeHandler: &testutil.FakeNodeHandler{
+				Existing: []*v1.Node{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:   "node0",
+							Labels: map[string]string{utilnode.NodePodCIDRMaskSizeLabel: "20"},
+						},
+					},
+				},
+				Clientset: fake.NewSimpleClientset(),
+			},
+			allocatorParams: CIDRAllocatorParams{
+				ClusterCIDRs: func() []*net.IPNet {
+					_, clusterCIDR, _ := net.ParseCIDR("10.10.0.0/22")
+					return []*net.IPNet{clusterCIDR}
+				}(),
+				ServiceCIDR:          nil,
+				SecondaryServiceCIDR: nil,
+				NodeCIDRMaskSizes:    []int{26},
+			},
+			expectedAllocatedCIDR: map[int]string{
+				0: "10.10.0.0/26",
+			},
+		},
 	}
 
 	// test function
@@ -865,3 +1112,58 @@ func TestReleaseCIDRSuccess(t *testing.T) {
 		testFunc(tc)
 	}
 }
+
+func TestZoneCIDRSetsAllocation(t *testing.T) {
+	_, clusterCIDR, _ := net.ParseCIDR("10.10.0.0/22")
+	_, zoneCIDRA, _ := net.ParseCIDR("10.20.0.0/22")
+	_, zoneCIDRB, _ := net.ParseCIDR("10.30.0.0/22")
+	fakeNodeHandler := &testutil.FakeNodeHandler{Clientset: fake.NewSimpleClientset()}
+	allocatorParams := CIDRAllocatorParams{
+		ClusterCIDRs:      []*net.IPNet{clusterCIDR},
+		ZoneClusterCIDRs:  map[string][]*net.IPNet{"us-central1-a": {zoneCIDRA}, "us-central1-b": {zoneCIDRB}},
+		NodeCIDRMaskSizes: []int{24},
+	}
+	allocator, err := NewCIDRRangeAllocator(fakeNodeHandler, getFakeNodeInformer(fakeNodeHandler), allocatorParams, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating the allocator: %v", err)
+	}
+	rangeAllocator := allocator.(*rangeAllocator)
+	rangeAllocator.recorder = testutil.NewFakeRecorder()
+
+	// the node without zone waits for it
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node0"}}
+	if err := allocator.AllocateOrOccupyCIDR(node); err != nil {
+		t.Fatalf("unexpected error in AllocateOrOccupyCIDR: %v", err)
+	}
+	if n := len(rangeAllocator.nodeCIDRUpdateChannel); n != 0 {
+		t.Fatalf("expected no allocation for the node without zone, got %d", n)
+	}
+
+	// the zone is known from the providerID before the zone label is set, in
+	// any of the formats of the provider
+	node.Spec.ProviderID = "gce://projects/test-project/zones/us-central1-a/instances/node0"
+	if err := allocator.AllocateOrOccupyCIDR(node); err != nil {
+		t.Fatalf("unexpected error in AllocateOrOccupyCIDR: %v", err)
+	}
+	allocated := <-rangeAllocator.nodeCIDRUpdateChannel
+	if got, want := allocated.allocatedCIDRs[0].String(), "10.20.0.0/24"; got != want {
+		t.Fatalf("expected CIDR %s from the cluster CIDRs of the zone, got %s", want, got)
+	}
+	rangeAllocator.removeNodeFromProcessing(node.Name)
+
+	// the CIDR is released to the cidrSet it was allocated from when the zone
+	// label of the node changed since
+	node.Labels = map[string]string{v1.LabelTopologyZone: "us-central1-b"}
+	node.Spec.PodCIDRs = cidrsAsString(allocated.allocatedCIDRs)
+	if err := allocator.ReleaseCIDR(node); err != nil {
+		t.Fatalf("unexpected error in ReleaseCIDR: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := rangeAllocator.zoneCIDRSets["us-central1-a"][0].AllocateNext(); err != nil {
+			t.Fatalf("expected the 4 CIDRs of the zone to be free after the release, allocation %d failed: %v", i, err)
+		}
+	}
+	if len(rangeAllocator.nodeCIDRSets) != 0 {
+		t.Errorf("expected the cidrSets of the released node to be forgotten, got %v", rangeAllocator.nodeCIDRSets)
+	}
+}
@@ -78,6 +78,7 @@ func NewNodeIpamController(
 	nwInformer networkinformer.NetworkInformer,
 	gnpInformer networkinformer.GKENetworkParamSetInformer,
 	clusterCIDRs []*net.IPNet,
+	zoneClusterCIDRs map[string][]*net.IPNet,
 	serviceCIDR *net.IPNet,
 	secondaryServiceCIDR *net.IPNet,
 	nodeCIDRMaskSizes []int,
@@ -108,6 +109,14 @@ func NewNodeIpamController(
 				klog.Fatal("Controller: Invalid --cluster-cidr, mask size of cluster CIDR must be less than or equal to --node-cidr-mask-size configured for CIDR family")
 			}
 		}
+
+		for zone, cidrs := range zoneClusterCIDRs {
+			for idx, cidr := range cidrs {
+				if maskSize, _ := cidr.Mask.Size(); maskSize > nodeCIDRMaskSizes[idx] {
+					klog.Fatalf("Controller: Invalid --zone-cluster-cidrs, mask size of cluster CIDR %v of zone %s must be less than or equal to --node-cidr-mask-size configured for CIDR family", cidr, zone)
+				}
+			}
+		}
 	}
 
 	ic := &Controller{
@@ -128,6 +137,7 @@ func NewNodeIpamController(
 
 		allocatorParams := ipam.CIDRAllocatorParams{
 			ClusterCIDRs:         clusterCIDRs,
+			ZoneClusterCIDRs:     zoneClusterCIDRs,
 			ServiceCIDR:          ic.serviceCIDR,
 			SecondaryServiceCIDR: ic.secondaryServiceCIDR,
 			NodeCIDRMaskSizes:    nodeCIDRMaskSizes,
@@ -0,0 +1,250 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabels
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider-gcp/pkg/controller/nodesync"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "nodelabels"
+
+	// resyncPeriod is the period the labels of the instances of all the
+	// nodes are checked again at, to restore the labels changed outside of
+	// the cluster.
+	resyncPeriod = 10 * time.Minute
+
+	// maxGCELabelLength is the maximum length of the keys and values of the
+	// GCE labels.
+	maxGCELabelLength = 63
+)
+
+// Controller propagates the allowed labels of the nodes to the GCE labels of
+// their instance, so that the billing and inventory tools of GCP group the
+// instances by the attributes of the workloads of the cluster. The GCE labels
+// of the allowed labels are owned by the controller: they are removed from
+// the instances of the nodes without the label. The other GCE labels of the
+// instances are kept.
+type Controller struct {
+	nodeLister         corelisters.NodeLister
+	nodeInformerSynced cache.InformerSynced
+	gceCloud           *gce.Cloud
+	queue              *nodesync.Queue
+	resyncPeriod       time.Duration
+
+	// allowedLabels are the GCE label keys by node label key.
+	allowedLabels map[string]string
+}
+
+// NewNodeLabelsController returns a new node labels controller, propagating
+// the allowedLabels of the nodes. They must be valid, see
+// ValidateAllowedLabels.
+func NewNodeLabelsController(
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+	allowedLabels []string,
+) *Controller {
+	c := &Controller{
+		nodeLister:         nodeInformer.Lister(),
+		nodeInformerSynced: nodeInformer.Informer().HasSynced,
+		gceCloud:           gceCloud,
+		resyncPeriod:       resyncPeriod,
+		allowedLabels:      map[string]string{},
+	}
+	c.queue = nodesync.NewQueue(workqueueName, c.sync)
+	for _, key := range allowedLabels {
+		c.allowedLabels[key] = toGCELabel(key)
+	}
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			// The providerID is set when the node is initialized.
+			oldNode, newNode := old.(*v1.Node), new.(*v1.Node)
+			if oldNode.Spec.ProviderID != newNode.Spec.ProviderID || !reflect.DeepEqual(c.desiredLabels(oldNode), c.desiredLabels(newNode)) {
+				c.enqueue(new)
+			}
+		},
+	})
+	return c
+}
+
+// ValidateAllowedLabels returns an error if the GCE label key of a node label
+// of allowedLabels is invalid, or is the one of another node label.
+func ValidateAllowedLabels(allowedLabels []string) error {
+	nodeLabels := map[string]string{}
+	for _, key := range allowedLabels {
+		gceKey := toGCELabel(key)
+		if gceKey == "" || gceKey[0] < 'a' || gceKey[0] > 'z' {
+			return fmt.Errorf("node label %q is not a valid GCE label key, it must start with a letter", key)
+		}
+		if other, ok := nodeLabels[gceKey]; ok && other != key {
+			return fmt.Errorf("node labels %q and %q have the same GCE label key %q", other, key, gceKey)
+		}
+		nodeLabels[gceKey] = key
+	}
+	return nil
+}
+
+// toGCELabel returns the key or the value of a node label in the format of
+// the GCE labels: lowercase, with the characters other than the letters, the
+// digits, the underscores and the dashes replaced by underscores, and
+// truncated to 63 characters.
+func toGCELabel(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, value)
+	if len(value) > maxGCELabelLength {
+		value = value[:maxGCELabelLength]
+	}
+	return value
+}
+
+// desiredLabels returns the GCE labels of the allowed labels of the node.
+func (c *Controller) desiredLabels(node *v1.Node) map[string]string {
+	desired := map[string]string{}
+	for key, gceKey := range c.allowedLabels {
+		if value, ok := node.Labels[key]; ok {
+			desired[gceKey] = toGCELabel(value)
+		}
+	}
+	return desired
+}
+
+// enqueue queues the nodes with a providerID.
+func (c *Controller) enqueue(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok || node.Spec.ProviderID == "" {
+		return
+	}
+	c.queue.Add(node.Name)
+}
+
+// resync queues the nodes whose instance labels are not the desired ones.
+// The instances of all the nodes are listed by zone instead of being got one
+// by one.
+func (c *Controller) resync(ctx context.Context) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	providerIDs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID != "" {
+			providerIDs = append(providerIDs, node.Spec.ProviderID)
+		}
+	}
+	instanceLabels, err := c.gceCloud.InstancesLabels(ctx, providerIDs)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, node := range nodes {
+		// The nodes without an instance are deleted by the cloud node
+		// lifecycle controller.
+		if current, ok := instanceLabels[node.Spec.ProviderID]; ok {
+			if _, changed := c.updatedLabels(node, current); changed {
+				c.enqueue(node)
+			}
+		}
+	}
+}
+
+// updatedLabels returns the current GCE labels of the instance of the node
+// with the GCE labels of its allowed labels replaced by the desired ones, and
+// whether they differ from the current labels.
+func (c *Controller) updatedLabels(node *v1.Node, current map[string]string) (map[string]string, bool) {
+	updated := map[string]string{}
+	for key, value := range current {
+		updated[key] = value
+	}
+	for _, gceKey := range c.allowedLabels {
+		delete(updated, gceKey)
+	}
+	for key, value := range c.desiredLabels(node) {
+		updated[key] = value
+	}
+	if reflect.DeepEqual(updated, current) || (len(updated) == 0 && len(current) == 0) {
+		return updated, false
+	}
+	return updated, true
+}
+
+// Run starts an asynchronous loop that propagates the labels of the nodes to
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	c.queue.Run(numWorkers, stopCh, controllerManagerMetrics, c.resync, c.resyncPeriod, c.nodeInformerSynced)
+}
+
+func (c *Controller) sync(ctx context.Context, name string) error {
+	node, err := c.nodeLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if node.Spec.ProviderID == "" {
+		return nil
+	}
+
+	current, fingerprint, err := c.gceCloud.InstanceLabels(ctx, node.Spec.ProviderID)
+	if err == cloudprovider.InstanceNotFound {
+		// The node is deleted by the cloud node lifecycle controller.
+		klog.V(2).Infof("Node %q has no instance, skipping the instance labels update", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, changed := c.updatedLabels(node, current)
+	if !changed {
+		return nil
+	}
+
+	// The update fails if the labels changed since they were fetched, and
+	// is retried.
+	if err := c.gceCloud.SetInstanceLabels(ctx, node.Spec.ProviderID, updated, fingerprint); err != nil {
+		return err
+	}
+	klog.Infof("Updated the labels of the instance of node %q from %v to %v", name, current, updated)
+	return nil
+}
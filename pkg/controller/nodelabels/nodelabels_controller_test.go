@@ -0,0 +1,229 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodelabels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+)
+
+var testAllowedLabels = []string{"team", "example.com/cost-center"}
+
+// fakeCompute serves the label updates of the instances of the fake GCE
+// cloud.
+type fakeCompute struct {
+	vals    gce.TestClusterValues
+	fakeGCE *gce.Cloud
+
+	lock    sync.Mutex
+	updates map[string]int
+}
+
+func (f *fakeCompute) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	instancesPath := fmt.Sprintf("/projects/%s/zones/%s/instances/", f.vals.ProjectID, f.vals.ZoneName)
+	if req.Method != http.MethodPost || !strings.HasPrefix(req.URL.Path, instancesPath) || !strings.HasSuffix(req.URL.Path, "/setLabels") {
+		http.Error(rw, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, instancesPath), "/setLabels")
+	setLabels := &compute.InstancesSetLabelsRequest{}
+	if err := json.NewDecoder(req.Body).Decode(setLabels); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.updates[name]++
+	if err := f.fakeGCE.DeleteInstance(f.vals.ProjectID, f.vals.ZoneName, name); err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	instance := testInstance(name, setLabels.Labels)
+	instance.LabelFingerprint = fmt.Sprintf("%s-%d", name, f.updates[name])
+	if err := f.fakeGCE.InsertInstance(f.vals.ProjectID, f.vals.ZoneName, instance); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(rw).Encode(&compute.Operation{Name: "set-labels-" + name, Status: "DONE"})
+}
+
+func (f *fakeCompute) updateCount(name string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.updates[name]
+}
+
+func testInstance(name string, labels map[string]string) *compute.Instance {
+	return &compute.Instance{Name: name, Labels: labels}
+}
+
+func testNode(vals gce.TestClusterValues, name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/%s", vals.ProjectID, vals.ZoneName, name)},
+	}
+}
+
+func TestNodeLabelsController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	for name, labels := range map[string]map[string]string{
+		"unlabeled": {"env": "prod"},
+		"removed":   {"env": "prod", "team": "ads"},
+		"unchanged": {"team": "billing"},
+	} {
+		if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, testInstance(name, labels)); err != nil {
+			t.Fatalf("Failed to insert instance %q: %v", name, err)
+		}
+	}
+	fakeCompute := &fakeCompute{vals: vals, fakeGCE: fakeGCE, updates: map[string]int{}}
+	srv := httptest.NewServer(fakeCompute)
+	defer srv.Close()
+	gce.SetFakeComputeEndpoint(fakeGCE, srv.URL+"/")
+
+	unregistered := testNode(vals, "unregistered", map[string]string{"team": "billing"})
+	unregistered.Spec.ProviderID = ""
+	client := fake.NewSimpleClientset(
+		testNode(vals, "unlabeled", map[string]string{"team": "Billing", "example.com/cost-center": "CC 42", "other": "ignored"}),
+		testNode(vals, "removed", map[string]string{"other": "ignored"}),
+		testNode(vals, "unchanged", map[string]string{"team": "billing"}),
+		testNode(vals, "no-instance", map[string]string{"team": "billing"}),
+		unregistered,
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewNodeLabelsController(informerFactory.Core().V1().Nodes(), fakeGCE, testAllowedLabels)
+	informerFactory.Start(ctx.Done())
+	go controller.Run(1, ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	instanceLabels := func(name string) func() map[string]string {
+		return func() map[string]string {
+			labels, _, err := fakeGCE.InstanceLabels(ctx, fmt.Sprintf("gce://%s/%s/%s", vals.ProjectID, vals.ZoneName, name))
+			if err != nil {
+				return map[string]string{"error": err.Error()}
+			}
+			return labels
+		}
+	}
+	g.Eventually(instanceLabels("unlabeled")).Should(gomega.Equal(map[string]string{"env": "prod", "team": "billing", "example_com_cost-center": "cc_42"}))
+	g.Eventually(instanceLabels("removed")).Should(gomega.Equal(map[string]string{"env": "prod"}), "the GCE labels of the allowed labels are removed")
+	g.Consistently(fakeCompute.updateCount, 500*time.Millisecond).WithArguments("unchanged").Should(gomega.BeZero())
+	g.Expect(fakeCompute.updateCount("unlabeled")).To(gomega.Equal(1))
+	g.Expect(fakeCompute.updateCount("no-instance")).To(gomega.BeZero())
+	g.Expect(fakeCompute.updateCount("unregistered")).To(gomega.BeZero())
+
+	// The label of the node changes.
+	node, err := client.CoreV1().Nodes().Get(ctx, "unchanged", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get node: %v", err)
+	}
+	node.Labels["team"] = "ads"
+	if _, err := client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update node: %v", err)
+	}
+	g.Eventually(instanceLabels("unchanged")).Should(gomega.Equal(map[string]string{"team": "ads"}))
+}
+
+func TestResync(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	for name, labels := range map[string]map[string]string{
+		"drifted":   {"env": "prod", "team": "ads"},
+		"unchanged": {"env": "prod", "team": "billing"},
+		"unlabeled": {"env": "prod"},
+	} {
+		if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, testInstance(name, labels)); err != nil {
+			t.Fatalf("Failed to insert instance %q: %v", name, err)
+		}
+	}
+	informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0*time.Second)
+	controller := NewNodeLabelsController(informerFactory.Core().V1().Nodes(), fakeGCE, testAllowedLabels)
+	for _, node := range []*v1.Node{
+		testNode(vals, "drifted", map[string]string{"team": "billing"}),
+		testNode(vals, "unchanged", map[string]string{"team": "billing"}),
+		testNode(vals, "unlabeled", map[string]string{"other": "ignored"}),
+		testNode(vals, "no-instance", map[string]string{"team": "billing"}),
+	} {
+		informerFactory.Core().V1().Nodes().Informer().GetIndexer().Add(node)
+	}
+
+	controller.resync(ctx)
+	if got := controller.queue.Len(); got != 1 {
+		t.Fatalf("resync queued %d nodes, want 1", got)
+	}
+	if key, _ := controller.queue.Get(); key != "drifted" {
+		t.Errorf("resync queued node %q, want %q", key, "drifted")
+	}
+}
+
+func TestValidateAllowedLabels(t *testing.T) {
+	for _, tc := range []struct {
+		desc          string
+		allowedLabels []string
+		wantErr       bool
+	}{
+		{desc: "no labels"},
+		{desc: "valid labels", allowedLabels: testAllowedLabels},
+		{desc: "duplicate label", allowedLabels: []string{"team", "team"}},
+		{desc: "not starting with a letter", allowedLabels: []string{"1team"}, wantErr: true},
+		{desc: "empty label", allowedLabels: []string{""}, wantErr: true},
+		{desc: "same GCE label key", allowedLabels: []string{"example.com/team", "example_com/team"}, wantErr: true},
+	} {
+		err := ValidateAllowedLabels(tc.allowedLabels)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%s: ValidateAllowedLabels(%v) = %v, want error: %t", tc.desc, tc.allowedLabels, err, tc.wantErr)
+		}
+	}
+}
+
+func TestToGCELabel(t *testing.T) {
+	for value, want := range map[string]string{
+		"billing":                 "billing",
+		"Billing":                 "billing",
+		"example.com/cost-center": "example_com_cost-center",
+		"node_pool-1":             "node_pool-1",
+		"":                        "",
+		strings.Repeat("a", 70):   strings.Repeat("a", 63),
+		"équipe":                  "_quipe",
+	} {
+		if got := toGCELabel(value); got != want {
+			t.Errorf("toGCELabel(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
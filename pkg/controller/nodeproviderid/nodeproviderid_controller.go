@@ -0,0 +1,208 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeproviderid
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "nodeproviderid"
+)
+
+// Controller backfills spec.providerID of the nodes which registered before
+// the cloud provider was available. Those nodes are not initialized by the
+// cloud node controller, and without a providerID they are never managed by
+// the cloud routines.
+type Controller struct {
+	kubeClient         clientset.Interface
+	nodeLister         corelisters.NodeLister
+	nodeInformerSynced cache.InformerSynced
+	gceCloud           *gce.Cloud
+	queue              workqueue.RateLimitingInterface
+}
+
+// NewNodeProviderIDController returns a new node providerID controller.
+func NewNodeProviderIDController(
+	kubeClient clientset.Interface,
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+) *Controller {
+	c := &Controller{
+		kubeClient:         kubeClient,
+		nodeLister:         nodeInformer.Lister(),
+		nodeInformerSynced: nodeInformer.Informer().HasSynced,
+		gceCloud:           gceCloud,
+		queue:              workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
+	}
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			c.enqueue(new)
+		},
+	})
+	return c
+}
+
+// enqueue queues the nodes missing a providerID.
+func (c *Controller) enqueue(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok || !needsProviderID(node) {
+		return
+	}
+	c.queue.Add(node.Name)
+}
+
+// needsProviderID returns true for the nodes without a providerID that are not
+// pending initialization by the cloud node controller, which sets it.
+func needsProviderID(node *v1.Node) bool {
+	if node.Spec.ProviderID != "" {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == cloudproviderapi.TaintExternalCloudProvider {
+			return false
+		}
+	}
+	return true
+}
+
+// Run starts an asynchronous loop that backfills the providerID of the nodes.
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting nodeproviderid controller")
+	defer klog.Infof("Shutting down nodeproviderid controller")
+	controllerManagerMetrics.ControllerStarted("nodeproviderid")
+	defer controllerManagerMetrics.ControllerStopped("nodeproviderid")
+
+	if !cache.WaitForNamedCacheSync("nodeproviderid", stopCh, c.nodeInformerSynced) {
+		return
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-stopCh
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.queue.Done(key)
+
+	err := c.sync(ctx, key.(string))
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	if c.queue.NumRequeues(key) < 5 {
+		klog.Warningf("Error while backfilling the providerID of node %v, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	c.queue.Forget(key)
+	utilruntime.HandleError(err)
+	klog.Errorf("Dropping node %q out of the queue: %v", key, err)
+	controllermetrics.WorkqueueDroppedObjects.WithLabelValues(workqueueName).Inc()
+}
+
+func (c *Controller) sync(ctx context.Context, name string) error {
+	node, err := c.nodeLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !needsProviderID(node) {
+		return nil
+	}
+
+	zone := nodeZone(node)
+	if zone == "" {
+		// The node is queued again when its labels are updated.
+		klog.V(2).Infof("Node %q has no zone label, skipping the providerID backfill", name)
+		return nil
+	}
+	instanceName := gce.MapNodeNameToInstanceName(types.NodeName(name))
+	providerID := fmt.Sprintf("%s://%s/%s/%s", gce.ProviderName, c.gceCloud.ProjectID(), zone, instanceName)
+	exists, err := c.gceCloud.InstanceExistsByProviderID(ctx, providerID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		klog.Warningf("Node %q has no matching instance in zone %q, skipping the providerID backfill", name, zone)
+		return nil
+	}
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"providerID":%q}}`, providerID))
+	if _, err := c.kubeClient.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Backfilled providerID %q of node %q", providerID, name)
+	return nil
+}
+
+// nodeZone returns the zone of the node from the topology labels.
+func nodeZone(node *v1.Node) string {
+	if zone := node.Labels[v1.LabelTopologyZone]; zone != "" {
+		return zone
+	}
+	return node.Labels[v1.LabelFailureDomainBetaZone]
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeproviderid
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+)
+
+func testNode(name, zone, providerID string, taints ...v1.Taint) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Spec:       v1.NodeSpec{ProviderID: providerID, Taints: taints},
+	}
+	if zone != "" {
+		node.Labels[v1.LabelTopologyZone] = zone
+	}
+	return node
+}
+
+func TestNodeProviderIDController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	for _, name := range []string{"registered-early", "fqdn", "uninitialized", "initialized"} {
+		if err := fakeGCE.InsertInstance(vals.ProjectID, vals.ZoneName, &compute.Instance{Name: name}); err != nil {
+			t.Fatalf("Failed to insert instance %q: %v", name, err)
+		}
+	}
+	initializedID := fmt.Sprintf("gce://%s/%s/initialized", vals.ProjectID, vals.ZoneName)
+
+	client := fake.NewSimpleClientset(
+		testNode("registered-early", vals.ZoneName, ""),
+		testNode("fqdn.c.project.internal", vals.ZoneName, ""),
+		testNode("no-instance", vals.ZoneName, ""),
+		testNode("no-zone", "", ""),
+		testNode("uninitialized", vals.ZoneName, "", v1.Taint{Key: cloudproviderapi.TaintExternalCloudProvider, Effect: v1.TaintEffectNoSchedule}),
+		testNode("initialized", vals.ZoneName, initializedID),
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewNodeProviderIDController(client, informerFactory.Core().V1().Nodes(), fakeGCE)
+	informerFactory.Start(ctx.Done())
+	go controller.Run(1, ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	providerID := func(name string) func() string {
+		return func() string {
+			node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err.Error()
+			}
+			return node.Spec.ProviderID
+		}
+	}
+	g.Eventually(providerID("registered-early")).Should(gomega.Equal(fmt.Sprintf("gce://%s/%s/registered-early", vals.ProjectID, vals.ZoneName)))
+	g.Eventually(providerID("fqdn.c.project.internal")).Should(gomega.Equal(fmt.Sprintf("gce://%s/%s/fqdn", vals.ProjectID, vals.ZoneName)), "FQDN nodes are backfilled with their instance name")
+	g.Consistently(providerID("no-instance"), 500*time.Millisecond).Should(gomega.BeEmpty(), "nodes without an instance are not backfilled")
+	g.Consistently(providerID("no-zone"), 500*time.Millisecond).Should(gomega.BeEmpty(), "nodes without a zone are not backfilled")
+	g.Consistently(providerID("uninitialized"), 500*time.Millisecond).Should(gomega.BeEmpty(), "uninitialized nodes are left to the cloud node controller")
+	g.Expect(providerID("initialized")()).To(gomega.Equal(initializedID))
+}
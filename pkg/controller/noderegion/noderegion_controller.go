@@ -0,0 +1,216 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package noderegion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/klog/v2"
+)
+
+const (
+	workqueueName = "noderegion"
+
+	// reasonZoneOutsideRegion is the reason of the condition of the nodes
+	// outside the region.
+	reasonZoneOutsideRegion = "ZoneOutsideRegion"
+	// reasonZoneInRegion is the reason of the condition of the nodes back in
+	// the region, or expected outside the region in multi-region mode.
+	reasonZoneInRegion = "ZoneInRegion"
+)
+
+// Controller sets the gce.NodeConditionZoneOutsideRegion condition of the
+// nodes whose zone is outside the region of the cluster. Such nodes are
+// excluded from the load balancers, and the condition explains why, unless
+// the cluster intentionally spans several regions.
+type Controller struct {
+	kubeClient         clientset.Interface
+	nodeLister         corelisters.NodeLister
+	nodeInformerSynced cache.InformerSynced
+	gceCloud           *gce.Cloud
+	queue              workqueue.RateLimitingInterface
+}
+
+// NewNodeRegionController returns a new node region controller.
+func NewNodeRegionController(
+	kubeClient clientset.Interface,
+	nodeInformer coreinformers.NodeInformer,
+	gceCloud *gce.Cloud,
+) *Controller {
+	c := &Controller{
+		kubeClient:         kubeClient,
+		nodeLister:         nodeInformer.Lister(),
+		nodeInformerSynced: nodeInformer.Informer().HasSynced,
+		gceCloud:           gceCloud,
+		queue:              workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: workqueueName}),
+	}
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old interface{}, new interface{}) {
+			c.enqueue(new)
+		},
+	})
+	return c
+}
+
+// enqueue queues the nodes whose condition needs to be set or updated.
+func (c *Controller) enqueue(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return
+	}
+	if _, ok := c.desiredCondition(node); !ok {
+		return
+	}
+	c.queue.Add(node.Name)
+}
+
+// desiredCondition returns the condition of the node, and false if it is
+// already up to date. The condition is only added to the nodes outside the
+// region, and is then kept up to date.
+func (c *Controller) desiredCondition(node *v1.Node) (v1.NodeCondition, bool) {
+	_, current := nodeutil.GetNodeCondition(&node.Status, gce.NodeConditionZoneOutsideRegion)
+	condition := v1.NodeCondition{
+		Type:   gce.NodeConditionZoneOutsideRegion,
+		Status: v1.ConditionFalse,
+		Reason: reasonZoneInRegion,
+	}
+	zone := c.gceCloud.NodeOutsideRegion(node)
+	switch {
+	case zone == "":
+		condition.Message = fmt.Sprintf("Node is in region %s of the cluster", c.gceCloud.Region())
+	case c.gceCloud.MultiRegion():
+		condition.Message = fmt.Sprintf("Zone %s is outside region %s of the cluster, which spans several regions", zone, c.gceCloud.Region())
+	default:
+		condition.Status = v1.ConditionTrue
+		condition.Reason = reasonZoneOutsideRegion
+		condition.Message = fmt.Sprintf("Zone %s is outside region %s of the cluster, the node is excluded from the load balancers", zone, c.gceCloud.Region())
+	}
+
+	if current == nil {
+		return condition, condition.Status == v1.ConditionTrue
+	}
+	if current.Status == condition.Status && current.Reason == condition.Reason && current.Message == condition.Message {
+		return condition, false
+	}
+	condition.LastTransitionTime = current.LastTransitionTime
+	if current.Status != condition.Status {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	return condition, true
+}
+
+// Run starts an asynchronous loop that sets the region condition of the nodes.
+func (c *Controller) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	defer c.queue.ShutDown()
+
+	klog.Infof("Starting noderegion controller")
+	defer klog.Infof("Shutting down noderegion controller")
+	controllerManagerMetrics.ControllerStarted("noderegion")
+	defer controllerManagerMetrics.ControllerStopped("noderegion")
+
+	if !cache.WaitForNamedCacheSync("noderegion", stopCh, c.nodeInformerSynced) {
+		return
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-stopCh
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.queue.Done(key)
+
+	err := c.sync(ctx, key.(string))
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	if c.queue.NumRequeues(key) < 5 {
+		klog.Warningf("Error while setting the region condition of node %v, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	c.queue.Forget(key)
+	utilruntime.HandleError(err)
+	klog.Errorf("Dropping node %q out of the queue: %v", key, err)
+	controllermetrics.WorkqueueDroppedObjects.WithLabelValues(workqueueName).Inc()
+}
+
+func (c *Controller) sync(ctx context.Context, name string) error {
+	node, err := c.nodeLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	condition, ok := c.desiredCondition(node)
+	if !ok {
+		return nil
+	}
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	if err := nodeutil.SetNodeCondition(c.kubeClient, types.NodeName(name), condition); err != nil {
+		return err
+	}
+	klog.Infof("Set condition %s=%s of node %q: %s", condition.Type, condition.Status, name, condition.Message)
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package noderegion
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/cloud-provider-gcp/providers/gce"
+	"k8s.io/component-base/metrics/prometheus/controllers"
+	nodeutil "k8s.io/component-helpers/node/util"
+)
+
+func testNode(name, zone string, conditions ...v1.NodeCondition) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{v1.LabelTopologyZone: zone}},
+		Status:     v1.NodeStatus{Conditions: conditions},
+	}
+}
+
+func TestNodeRegionController(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	vals := gce.DefaultTestClusterValues()
+	fakeGCE := gce.NewFakeGCECloud(vals)
+	outsideRegion := v1.NodeCondition{Type: gce.NodeConditionZoneOutsideRegion, Status: v1.ConditionTrue, Reason: reasonZoneOutsideRegion}
+	client := fake.NewSimpleClientset(
+		testNode("in-region", vals.ZoneName),
+		testNode("outside-region", "europe-west1-b"),
+		testNode("back-in-region", vals.ZoneName, outsideRegion),
+	)
+	informerFactory := informers.NewSharedInformerFactory(client, 0*time.Second)
+	controller := NewNodeRegionController(client, informerFactory.Core().V1().Nodes(), fakeGCE)
+	informerFactory.Start(ctx.Done())
+	go controller.Run(1, ctx.Done(), controllers.NewControllerManagerMetrics("test"))
+
+	condition := func(name string) *v1.NodeCondition {
+		node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Failed to get node %q: %v", name, err)
+		}
+		_, condition := nodeutil.GetNodeCondition(&node.Status, gce.NodeConditionZoneOutsideRegion)
+		return condition
+	}
+	// status returns the status and the reason of the condition of a node.
+	status := func(name string) func() string {
+		return func() string {
+			if c := condition(name); c != nil {
+				return string(c.Status) + "/" + c.Reason
+			}
+			return ""
+		}
+	}
+	g.Eventually(status("outside-region")).Should(gomega.Equal("True/" + reasonZoneOutsideRegion))
+	g.Expect(condition("outside-region").Message).To(gomega.ContainSubstring("europe-west1-b is outside region " + vals.Region))
+	g.Eventually(status("back-in-region")).Should(gomega.Equal("False/" + reasonZoneInRegion))
+	g.Consistently(status("in-region"), 500*time.Millisecond).Should(gomega.BeEmpty(), "the nodes in the region get no condition")
+}
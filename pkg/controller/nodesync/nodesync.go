@@ -0,0 +1,119 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodesync runs the controllers which sync the nodes one by one with
+// their GCE instance. The compute API has no watch, so that the controllers
+// also queue the nodes whose instance changed by a periodic resync.
+package nodesync
+
+import (
+	"context"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/cloud-provider-gcp/pkg/controllermetrics"
+	controllersmetrics "k8s.io/component-base/metrics/prometheus/controllers"
+	"k8s.io/klog/v2"
+)
+
+// maxRetries is the number of times the sync of a node is retried before it
+// is dropped out of the queue, until it is queued again.
+const maxRetries = 5
+
+// Queue is the queue of the names of the nodes to sync of a controller.
+type Queue struct {
+	workqueue.RateLimitingInterface
+
+	// name is the name of the controller and of its queue.
+	name string
+	// sync syncs the node of a name.
+	sync func(ctx context.Context, name string) error
+}
+
+// NewQueue returns the queue of the controller name, whose nodes are synced by
+// sync.
+func NewQueue(name string, sync func(ctx context.Context, name string) error) *Queue {
+	return &Queue{
+		RateLimitingInterface: workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: name}),
+		name:                  name,
+		sync:                  sync,
+	}
+}
+
+// Run waits for the informers to sync, and then runs numWorkers workers
+// syncing the queued nodes and calls resync every resyncPeriod, until stopCh
+// is closed.
+func (q *Queue) Run(numWorkers int, stopCh <-chan struct{}, controllerManagerMetrics *controllersmetrics.ControllerManagerMetrics, resync func(ctx context.Context), resyncPeriod time.Duration, informersSynced ...cache.InformerSynced) {
+	defer utilruntime.HandleCrash()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	defer q.ShutDown()
+
+	klog.Infof("Starting %s controller", q.name)
+	defer klog.Infof("Shutting down %s controller", q.name)
+	controllerManagerMetrics.ControllerStarted(q.name)
+	defer controllerManagerMetrics.ControllerStopped(q.name)
+
+	if !cache.WaitForNamedCacheSync(q.name, stopCh, informersSynced...) {
+		return
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		go wait.UntilWithContext(ctx, q.runWorker, time.Second)
+	}
+	go wait.UntilWithContext(ctx, resync, resyncPeriod)
+
+	<-stopCh
+}
+
+func (q *Queue) runWorker(ctx context.Context) {
+	for q.processNextItem(ctx) {
+	}
+}
+
+func (q *Queue) processNextItem(ctx context.Context) bool {
+	key, quit := q.Get()
+	if quit {
+		return false
+	}
+	defer q.Done(key)
+
+	q.handleErr(q.sync(ctx, key.(string)), key)
+	return true
+}
+
+// handleErr retries the failed syncs of the node key up to maxRetries times.
+func (q *Queue) handleErr(err error, key interface{}) {
+	if err == nil {
+		q.Forget(key)
+		return
+	}
+
+	if q.NumRequeues(key) < maxRetries {
+		klog.Warningf("Error while syncing node %v in the %s controller, retrying: %v", key, q.name, err)
+		q.AddRateLimited(key)
+		return
+	}
+
+	q.Forget(key)
+	utilruntime.HandleError(err)
+	klog.Errorf("Dropping node %q out of the %s queue: %v", key, q.name, err)
+	controllermetrics.WorkqueueDroppedObjects.WithLabelValues(q.name).Inc()
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodesync
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQueueRetries(t *testing.T) {
+	syncs := map[string]int{}
+	q := NewQueue("test", func(_ context.Context, name string) error {
+		syncs[name]++
+		if name == "failing" {
+			return errors.New("sync failed")
+		}
+		return nil
+	})
+	defer q.ShutDown()
+
+	q.Add("synced")
+	q.Add("failing")
+	// The rate limited retries are not waited for, the requeues are counted.
+	for i := 0; i < 2; i++ {
+		q.processNextItem(context.TODO())
+	}
+	if got := q.NumRequeues("synced"); got != 0 {
+		t.Errorf("synced node requeued %d times, want 0", got)
+	}
+	if got := q.NumRequeues("failing"); got != 1 {
+		t.Errorf("failing node requeued %d times, want 1", got)
+	}
+
+	// The node is dropped once its retries are exhausted.
+	for i := 1; i < maxRetries; i++ {
+		q.handleErr(errors.New("sync failed"), "failing")
+	}
+	if got := q.NumRequeues("failing"); got != maxRetries {
+		t.Errorf("failing node requeued %d times, want %d", got, maxRetries)
+	}
+	q.handleErr(errors.New("sync failed"), "failing")
+	if got := q.NumRequeues("failing"); got != 0 {
+		t.Errorf("failing node requeued %d times after it was dropped, want 0", got)
+	}
+	if syncs["synced"] != 1 || syncs["failing"] != 1 {
+		t.Errorf("syncs = %v, want one sync of each node", syncs)
+	}
+}
@@ -81,6 +81,18 @@ var (
 		Name: "outbound_rpc_latency",
 		Help: "Latency of outbound RPCs to GCE and GKE, in seconds",
 	}, []string{"status", "kind"})
+	workerPoolQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csr_worker_pool_queue_depth",
+		Help: "Number of CSRs waiting for a worker of a CSR controller pool",
+	}, []string{"controller", "pool"})
+	workerPoolQueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "csr_worker_pool_queue_latencies",
+		Help: "Time CSRs waited for a worker of a CSR controller pool, in seconds",
+	}, []string{"controller", "pool"})
+	workerPoolBusyWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csr_worker_pool_busy_workers",
+		Help: "Number of workers of a CSR controller pool processing a CSR",
+	}, []string{"controller", "pool"})
 )
 
 func init() {
@@ -91,6 +103,9 @@ func init() {
 		approvalLatency,
 		outboundRPCCount,
 		outboundRPCLatency,
+		workerPoolQueueDepth,
+		workerPoolQueueLatency,
+		workerPoolBusyWorkers,
 	)
 }
 
@@ -126,3 +141,24 @@ func OutboundRPCStartRecorder(kind string) func(status OutboundRPCStatus) {
 		outboundRPCLatency.WithLabelValues(string(status), kind).Observe(time.Since(start).Seconds())
 	}
 }
+
+// WorkerPoolQueueDepth records the number of CSRs waiting in the queue of a
+// CSR controller worker pool.
+func WorkerPoolQueueDepth(controller, pool string, depth int) {
+	workerPoolQueueDepth.WithLabelValues(controller, pool).Set(float64(depth))
+}
+
+// WorkerPoolQueueLatency records how long a CSR waited in the queue of a CSR
+// controller worker pool before a worker picked it up.
+func WorkerPoolQueueLatency(controller, pool string, latency time.Duration) {
+	workerPoolQueueLatency.WithLabelValues(controller, pool).Observe(latency.Seconds())
+}
+
+// WorkerPoolWorkStartRecorder marks a worker of a CSR controller pool as busy.
+// Caller is responsible for calling the returned function once the worker is
+// done with the CSR.
+func WorkerPoolWorkStartRecorder(controller, pool string) func() {
+	g := workerPoolBusyWorkers.WithLabelValues(controller, pool)
+	g.Inc()
+	return g.Dec
+}
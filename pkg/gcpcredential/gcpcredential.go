@@ -54,6 +54,10 @@ var GCEProductNameFile = "/sys/class/dmi/id/product_name"
 // "foo.gcr.io" and "bar.gcr.io".
 var containerRegistryUrls = []string{"container.cloud.google.com", "gcr.io", "*.gcr.io", "*.pkg.dev"}
 
+// artifactRegistryDomain is the domain of the Artifact Registry hostnames, e.g.
+// "us-docker.pkg.dev" or "europe-west1-docker.pkg.dev".
+const artifactRegistryDomain = ".pkg.dev"
+
 var metadataHeader = &http.Header{
 	"Metadata-Flavor": []string{"Google"},
 }
@@ -253,15 +257,48 @@ func (g *ContainerRegistryProvider) Provide(image string) credentialconfig.Docke
 		return cfg
 	}
 
-	entry := credentialconfig.DockerConfigEntry{
+	return registryConfig(image, credentialconfig.DockerConfigEntry{
 		Username: "_token",
 		Password: parsedBlob.AccessToken,
 		Email:    string(email),
-	}
+	})
+}
 
-	// Add our entry for each of the supported container registry URLs
+// registryConfig returns a dockercfg with the entry for each of the supported
+// container registry URLs, and for the Artifact Registry host of the image.
+// The globs only match a single part of the host name, the host of the image
+// also matches the Artifact Registry hostnames with more parts or a port.
+func registryConfig(image string, entry credentialconfig.DockerConfigEntry) credentialconfig.DockerConfig {
+	cfg := credentialconfig.DockerConfig{}
 	for _, k := range containerRegistryUrls {
 		cfg[k] = entry
 	}
+	if host := registryHost(image); isArtifactRegistryHost(host) {
+		cfg[host] = entry
+	}
 	return cfg
 }
+
+// registryHost returns the registry host name of the image, with its port if
+// any, or "" if the image has no registry, e.g. "nginx" or "library/nginx".
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return ""
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return ""
+	}
+	return host
+}
+
+// isArtifactRegistryHost returns true if host is an Artifact Registry host
+// name, regional, multi-regional, or of a virtual repository, all of them are
+// subdomains of pkg.dev. The port of host is ignored.
+func isArtifactRegistryHost(host string) bool {
+	if i := strings.LastIndex(host, ":"); i != -1 {
+		host = host[:i]
+	}
+	return strings.HasSuffix(strings.ToLower(host), artifactRegistryDomain)
+}
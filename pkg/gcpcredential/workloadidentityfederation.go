@@ -0,0 +1,139 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcpcredential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"k8s.io/cloud-provider-gcp/pkg/credentialconfig"
+	"k8s.io/klog/v2"
+)
+
+const (
+	externalAccountType = "external_account"
+	// generateAccessTokenSuffix is the suffix of the service account
+	// impersonation URL of the external accounts, after the email of the
+	// service account.
+	generateAccessTokenSuffix = ":generateAccessToken"
+)
+
+// WorkloadIdentityFederationProvider is a DockerConfigProvider that provides
+// a dockercfg with:
+//
+//	Username: "_token"
+//	Password: "{access token exchanged with Workload Identity Federation}"
+//
+// The access token is exchanged for the credential of the external account
+// configuration of CredentialsFile, e.g. an OIDC token of another cloud, by
+// the Security Token Service, rather than read from the metadata server. It
+// is used on the nodes outside of GCE, or without a service account.
+type WorkloadIdentityFederationProvider struct {
+	// CredentialsFile is the path of the external account credential
+	// configuration, as generated by
+	// `gcloud iam workload-identity-pools create-cred-config`.
+	CredentialsFile string
+	Client          *http.Client
+}
+
+// externalAccountConfig is the part of the external account credential
+// configuration used by WorkloadIdentityFederationProvider.
+type externalAccountConfig struct {
+	Type                           string `json:"type"`
+	Audience                       string `json:"audience"`
+	ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+}
+
+func (g *WorkloadIdentityFederationProvider) readConfig() ([]byte, *externalAccountConfig, error) {
+	data, err := os.ReadFile(g.CredentialsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &externalAccountConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, nil, fmt.Errorf("while parsing %s: %v", g.CredentialsFile, err)
+	}
+	if cfg.Type != externalAccountType {
+		return nil, nil, fmt.Errorf("%s has type %q instead of %q", g.CredentialsFile, cfg.Type, externalAccountType)
+	}
+	return data, cfg, nil
+}
+
+// Enabled implements DockerConfigProvider, it returns true if CredentialsFile
+// is an external account credential configuration.
+func (g *WorkloadIdentityFederationProvider) Enabled() bool {
+	if g.CredentialsFile == "" {
+		klog.V(2).Infof("Workload Identity Federation is disabled, no credential configuration")
+		return false
+	}
+	if _, _, err := g.readConfig(); err != nil {
+		klog.Warningf("Workload Identity Federation is disabled: %v", err)
+		return false
+	}
+	return true
+}
+
+// Provide implements DockerConfigProvider
+func (g *WorkloadIdentityFederationProvider) Provide(image string) credentialconfig.DockerConfig {
+	data, cfg, err := g.readConfig()
+	if err != nil {
+		klog.Errorf("while reading the credential configuration: %v", err)
+		return credentialconfig.DockerConfig{}
+	}
+
+	ctx := context.Background()
+	if g.Client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, g.Client)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, cloudPlatformScopePrefix)
+	if err != nil {
+		klog.Errorf("while loading the credential configuration %s: %v", g.CredentialsFile, err)
+		return credentialconfig.DockerConfig{}
+	}
+	// The token is not cached, the credential provider runs once per image
+	// pull and the kubelet caches the responses, see
+	// KUBE_SIDECAR_CACHE_DURATION.
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		klog.Errorf("while exchanging the access token of audience %s: %v", cfg.Audience, err)
+		return credentialconfig.DockerConfig{}
+	}
+
+	return registryConfig(image, credentialconfig.DockerConfigEntry{
+		Username: "_token",
+		Password: token.AccessToken,
+		Email:    impersonatedServiceAccount(cfg.ServiceAccountImpersonationURL),
+	})
+}
+
+// impersonatedServiceAccount returns the email of the service account of the
+// impersonation URL, e.g.
+// "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@project.iam.gserviceaccount.com:generateAccessToken",
+// or "" if none.
+func impersonatedServiceAccount(url string) string {
+	if !strings.HasSuffix(url, generateAccessTokenSuffix) {
+		return ""
+	}
+	url = strings.TrimSuffix(url, generateAccessTokenSuffix)
+	return url[strings.LastIndex(url, "/")+1:]
+}
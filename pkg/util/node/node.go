@@ -38,6 +38,10 @@ const (
 	// NodePoolSubnetLabelPrefix is the prefix for the default subnet
 	// name for the node
 	NodePoolSubnetLabelPrefix = "cloud.google.com/gke-np-default-subnet"
+	// NodePodCIDRMaskSizeLabel is the mask size of the IPv4 Pod CIDR to
+	// allocate to the node with the range allocator, e.g. "24" for the large
+	// nodes of a cluster allocating /26 ranges by default
+	NodePodCIDRMaskSizeLabel = "cloud.google.com/gke-pod-cidr-mask-size"
 )
 
 type nodeForConditionPatch struct {
@@ -98,7 +102,23 @@ func PatchNodeCIDRs(c clientset.Interface, node types.NodeName, cidrs []string)
 	}
 	klog.V(4).Infof("cidrs patch bytes are:%s", string(patchBytes))
 	if _, err := c.CoreV1().Nodes().Patch(context.TODO(), string(node), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
-		return fmt.Errorf("failed to patch node CIDR: %v", err)
+		return fmt.Errorf("failed to patch node CIDR: %w", err)
+	}
+	return nil
+}
+
+// RemoveNodeLabel removes the label from the node, if it has it.
+func RemoveNodeLabel(c clientset.Interface, node types.NodeName, key string) error {
+	patchBytes, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{key: nil},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build patch bytes to remove label %s: %w", key, err)
+	}
+	if _, err := c.CoreV1().Nodes().Patch(context.TODO(), string(node), types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to remove label %s of node %q: %w", key, node, err)
 	}
 	return nil
 }
@@ -112,6 +132,9 @@ func PatchNodeMultiNetwork(c clientset.Interface, node *v1.Node) error {
 	if val, ok := node.Annotations[networkv1.MultiNetworkAnnotationKey]; ok {
 		annotation[networkv1.MultiNetworkAnnotationKey] = val
 	}
+	if val, ok := node.Annotations[networkv1.PodRangesAnnotationKey]; ok {
+		annotation[networkv1.PodRangesAnnotationKey] = val
+	}
 
 	if len(annotation) > 0 {
 		raw, err := json.Marshal(annotation)
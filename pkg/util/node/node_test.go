@@ -76,6 +76,7 @@ func TestPatchNodeMultiNetwork(t *testing.T) {
 			nodeChanges: func(node *v1.Node) {
 				node.Annotations[networkv1.NorthInterfacesAnnotationKey] = "[{\"network\":\"test\",\"ipAddress\":\"10.1.1.1\"}]"
 				node.Annotations[networkv1.MultiNetworkAnnotationKey] = "[{\"name\":\"test\",\"cidrs\":[\"172.11.1.0/32\"],\"scope\":\"host-local\"}]"
+				node.Annotations[networkv1.PodRangesAnnotationKey] = "[{\"network\":\"test\",\"rangeName\":\"range-a\",\"cidr\":\"172.11.1.0/32\"}]"
 				node.Status.Capacity = map[v1.ResourceName]resource.Quantity{
 					"networking.gke.io.networks/test.IP": *resource.NewQuantity(1, resource.DecimalSI),
 				}
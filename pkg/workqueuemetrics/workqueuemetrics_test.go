@@ -0,0 +1,93 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workqueuemetrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestProvider(t *testing.T) {
+	p := newProvider(prometheus.NewRegistry())
+	queue := workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{
+		Name:            "test-controller",
+		MetricsProvider: p,
+	})
+	defer queue.ShutDown()
+
+	queue.Add("a")
+	queue.Add("b")
+	if got := testutil.ToFloat64(p.depth.WithLabelValues("test-controller")); got != 2 {
+		t.Errorf("depth = %v, want 2", got)
+	}
+	item, _ := queue.Get()
+	queue.AddRateLimited(item)
+	queue.Done(item)
+
+	for desc, tc := range map[string]struct {
+		metric prometheus.Collector
+		want   float64
+	}{
+		"depth":   {metric: p.depth.WithLabelValues("test-controller"), want: 1},
+		"adds":    {metric: p.adds.WithLabelValues("test-controller"), want: 2},
+		"retries": {metric: p.retries.WithLabelValues("test-controller"), want: 1},
+	} {
+		if got := testutil.ToFloat64(tc.metric); got != tc.want {
+			t.Errorf("%s = %v, want %v", desc, got, tc.want)
+		}
+	}
+	if got := testutil.CollectAndCount(p.workDuration); got != 1 {
+		t.Errorf("work duration series = %d, want 1", got)
+	}
+}
+
+func TestProviderServed(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{
+		Name:            "served-controller",
+		MetricsProvider: Provider(),
+	})
+	defer queue.ShutDown()
+	queue.Add("a")
+
+	// The gcp-controller-manager serves the default Prometheus registry.
+	server := httptest.NewServer(promhttp.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() = %v", err)
+	}
+	for _, want := range []string{
+		`workqueue_adds_total{name="served-controller"} 1`,
+		`workqueue_depth{name="served-controller"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -24,10 +24,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gcfg "gopkg.in/gcfg.v1"
@@ -39,6 +41,7 @@ import (
 	computebeta "google.golang.org/api/compute/v0.beta"
 	compute "google.golang.org/api/compute/v1"
 	container "google.golang.org/api/container/v1"
+	dnsapi "google.golang.org/api/dns/v1"
 	"google.golang.org/api/option"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
@@ -50,12 +53,14 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/pkg/version"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/flowcontrol"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 )
 
 const (
@@ -68,6 +73,14 @@ const (
 	gceAffinityTypeNone = "NONE"
 	// AffinityTypeClientIP - affinity based on Client IP.
 	gceAffinityTypeClientIP = "CLIENT_IP"
+	// gceAffinityTypeClientIPProto - affinity based on Client IP and protocol.
+	gceAffinityTypeClientIPProto = "CLIENT_IP_PROTO"
+	// gceAffinityTypeClientIPPortProto - affinity based on Client IP, port and
+	// protocol.
+	gceAffinityTypeClientIPPortProto = "CLIENT_IP_PORT_PROTO"
+	// gceAffinityTypeGeneratedCookie - affinity based on a cookie of the load
+	// balancer, only supported by the proxy load balancers.
+	gceAffinityTypeGeneratedCookie = "GENERATED_COOKIE"
 
 	operationPollInterval           = time.Second
 	maxTargetPoolCreateInstances    = 200
@@ -135,6 +148,7 @@ type Cloud struct {
 	serviceAlpha     *computealpha.Service
 	containerService *container.Service
 	tpuService       *tpuService
+	dnsService       *dnsapi.Service
 	client           clientset.Interface
 	clientBuilder    cloudprovider.ControllerClientBuilder
 	eventBroadcaster record.EventBroadcaster
@@ -166,17 +180,39 @@ type Cloud struct {
 	useMetadataServer        bool
 	operationPollRateLimiter flowcontrol.RateLimiter
 	manager                  diskServiceManager
+	// lbDefaults are the cluster-wide settings of the load balancers, see
+	// SetLoadBalancerDefaults.
+	lbDefaultsLock sync.RWMutex
+	lbDefaults     LoadBalancerDefaults
 	// Lock for access to nodeZones
 	nodeZonesLock sync.Mutex
 	// nodeZones is a mapping from Zone to a sets.String of Node's names in the Zone
 	// it is updated by the nodeInformer
 	nodeZones          map[string]sets.String
 	nodeInformerSynced cache.InformerSynced
+	// nodeInstances maps the nodes to their instances, it is updated by the
+	// nodeInformer.
+	nodeInstances nodeInstanceCache
+	// routerCache caches the Cloud Routers used to check the Cloud NAT
+	// configuration of registering nodes.
+	routerCache routerCache
+	// subnetRangesCache caches the subnet ranges of the network used to
+	// validate the source ranges of the internal load balancers.
+	subnetRangesCache subnetRangesCache
+	// retainedILBIPsLister gets the ConfigMap of the retained internal load
+	// balancer IPs, it is set by Initialize.
+	retainedILBIPsLister corelisters.ConfigMapNamespaceLister
+	retainedILBIPsSynced cache.InformerSynced
 	// sharedResourceLock is used to serialize GCE operations that may mutate shared state to
 	// prevent inconsistencies. For example, load balancers manipulation methods will take the
 	// lock to prevent shared resources from being prematurely deleted while the operation is
 	// in progress.
 	sharedResourceLock sync.Mutex
+	// sharedIPLock is read locked by the external load balancers from the
+	// reservation of their shared IP until their forwarding rules use it,
+	// and locked to release the unused shared IPs, so that a shared address
+	// is not released while a load balancer adopts it.
+	sharedIPLock sync.RWMutex
 	// AlphaFeatureGate gates gce alpha features in Cloud instance.
 	// Related wrapper functions that interacts with gce alpha api should examine whether
 	// the corresponding api is enabled.
@@ -198,6 +234,71 @@ type Cloud struct {
 	stackType StackType
 
 	externalInstanceGroupsPrefix string // If non-"", finds prefixed instance groups for ILB.
+	managedInstanceGroupsPrefix  string // If non-"", finds prefixed managed instance groups for the LBs.
+
+	// maxTargetPoolInstances, when positive, limits the number of instances
+	// added to each target pool, selected with targetPoolSubsettingStrategy.
+	maxTargetPoolInstances       int
+	targetPoolSubsettingStrategy TargetPoolSubsettingStrategy
+
+	// ilbSubsetting is set when the backends of the internal load balancers
+	// are zonal GCE_VM_IP network endpoint groups of a subset of the nodes.
+	ilbSubsetting bool
+
+	// multiRegion is set for the clusters intentionally spanning several
+	// regions, whose nodes outside the region are expected.
+	multiRegion bool
+
+	// xpnFirewalls is set when the cluster creates the firewall rules of its
+	// load balancers in the host project of its Shared VPC network, with
+	// hostFirewalls if it has credentials of the host project.
+	xpnFirewalls  bool
+	hostFirewalls cloud.Firewalls
+
+	// retryPolicies are the retry policies of the operations by resource.
+	retryPolicies map[string]*retryPolicy
+
+	// missingInstances confirms the instances of the Nodes missing before
+	// they are reported missing.
+	missingInstances missingInstanceConfirmation
+
+	// lbCleanups tracks the load balancer deletions in progress, which are
+	// checkpointed at shutdown.
+	lbCleanups lbCleanupTracker
+
+	// staleSubnetInstanceGroups are the emptied subnetwork instance groups,
+	// deleted once the backend services no longer use them.
+	staleSubnetInstanceGroups staleZonalResources
+	// staleNEGs are the network endpoint groups which are no longer backends
+	// of their internal load balancer, deleted once the backend services no
+	// longer use them.
+	staleNEGs staleZonalResources
+
+	// lbNodes suppresses the churn of the nodes of the load balancers when
+	// the nodes flap cluster-wide.
+	lbNodes lbNodeStabilizer
+
+	// routeBackoff tracks the failed route creations, which are retried with
+	// an exponential back-off.
+	routeBackoff routeCreationBackoff
+
+	// routeOps batches the route creations and deletions.
+	routeOps routeOperations
+
+	// apiVersions records the Compute API versions found not available, for
+	// the calls falling back to the GA API.
+	apiVersions apiVersionNegotiator
+
+	// firewallConsolidation is set once the firewall consolidation
+	// controller runs. The external load balancers then share the firewall
+	// rules of the controller, instead of getting a firewall rule each.
+	firewallConsolidation atomic.Bool
+	// consolidatedFirewallsReleased is set once no consolidated firewall
+	// rule is left while the firewall consolidation is disabled.
+	consolidatedFirewallsReleased atomic.Bool
+
+	// clock is used to check the load balancer maintenance windows.
+	clock clock.PassiveClock
 }
 
 // ConfigGlobal is the in memory representation of the gce.conf config data
@@ -222,7 +323,8 @@ type ConfigGlobal struct {
 	NodeInstancePrefix string   `gcfg:"node-instance-prefix"`
 	Regional           bool     `gcfg:"regional"`
 	Multizone          bool     `gcfg:"multizone"`
-	// APIEndpoint is the GCE compute API endpoint to use. If this is blank,
+	// APIEndpoint is the GCE compute API endpoint to use, e.g. a Private Service
+	// Connect endpoint for clusters without public egress. If this is blank,
 	// then the default endpoint is used.
 	APIEndpoint string `gcfg:"api-endpoint"`
 	// ContainerAPIEndpoint is the GCE container API endpoint to use. If this is blank,
@@ -238,6 +340,63 @@ type ConfigGlobal struct {
 	// ExternalInstanceGroupsPrefix, when not-empty, is used to filter instance groups
 	// and include them in the backend for ILB.
 	ExternalInstanceGroupsPrefix string `gcfg:"external-instance-groups-prefix"`
+	// ManagedInstanceGroupsPrefix, when not-empty, is used to filter the
+	// managed instance groups of the cluster, e.g. those of the machine pools
+	// of the cluster-api provider, whose instance groups are then the backends
+	// of the load balancers of their nodes, instead of the instance groups of
+	// the provider. The prefix must only match the groups of the cluster, as
+	// their instances which are not nodes, e.g. still booting, are load
+	// balanced too. Default to none.
+	ManagedInstanceGroupsPrefix string `gcfg:"managed-instance-groups-prefix"`
+	// MaxTargetPoolInstances, when positive, limits the number of instances in
+	// the target pools of external load balancers. Default to no limit.
+	MaxTargetPoolInstances int `gcfg:"max-target-pool-instances"`
+	// TargetPoolSubsettingStrategy selects the instances of the target pools
+	// limited by MaxTargetPoolInstances. Default to "zone-balanced".
+	TargetPoolSubsettingStrategy string `gcfg:"target-pool-subsetting-strategy"`
+	// ILBSubsetting, when set, load balances the internal LoadBalancer
+	// Services to zonal GCE_VM_IP network endpoint groups of up to 25 nodes
+	// per zone, or of all the nodes with externalTrafficPolicy=Local, instead
+	// of the instance groups of the nodes, which are limited to 250 nodes per
+	// backend service. The Services override it with the annotation
+	// networking.gke.io/internal-load-balancer-subsetting. Default to false.
+	ILBSubsetting bool `gcfg:"ilb-subsetting"`
+	// MultiRegion is set for the clusters intentionally spanning several
+	// regions. Their nodes outside the region are still excluded from the load
+	// balancers, but are not reported as misconfigured. Default to false.
+	MultiRegion bool `gcfg:"multi-region"`
+	// XPNFirewalls, when set, creates, updates and deletes the firewall rules
+	// of the load balancers in the host project of the Shared VPC network of
+	// the cluster, instead of raising LoadBalancerManualChange events asking a
+	// security admin to. The descriptions of these rules have the
+	// "kubernetes.io/owner-project" field, the other rules of the host project,
+	// e.g. created by the admins after the events, are not changed and still
+	// raise the events. Default to false.
+	XPNFirewalls bool `gcfg:"xpn-firewalls"`
+	// XPNCredentialsFile is the credential file of the firewall rules of the
+	// host project with XPNFirewalls, the JSON key of a service account or an
+	// external account configuration. Default to the credentials of the
+	// cluster.
+	XPNCredentialsFile string `gcfg:"xpn-credentials-file"`
+	// MutationEvents, when set, records an Event for each GCE resource
+	// created, updated or deleted by the provider, as an audit trail of the
+	// infrastructure changes of the cluster. Default to false.
+	MutationEvents bool `gcfg:"mutation-events"`
+	// MissingInstanceConfirmations is the number of consecutive checks which
+	// must not find the instance of a Node before it is reported missing to
+	// the node lifecycle controller, which then deletes the Node. The checks
+	// look the instance up with a get and a list, so that a transient
+	// inconsistency of the API does not delete the Node. Default to 1, the
+	// instance is reported missing as soon as a get does not find it.
+	MissingInstanceConfirmations int `gcfg:"missing-instance-confirmations"`
+	// MissingInstanceGracePeriod is the minimum duration, e.g. "5m", between
+	// the first and the last of these checks. Default to none.
+	MissingInstanceGracePeriod string `gcfg:"missing-instance-grace-period"`
+	// RouteOperationsParallelism is the maximum number of route creations and
+	// deletions in flight. The route operations pending at once are batched,
+	// and run concurrently up to this limit. Default to no limit, the route
+	// controller issuing up to 200 operations at once.
+	RouteOperationsParallelism int `gcfg:"route-operations-parallelism"`
 }
 
 // ConfigFile is the struct used to parse the /etc/gce.conf configuration file.
@@ -248,6 +407,9 @@ type ConfigGlobal struct {
 // for more details.
 type ConfigFile struct {
 	Global ConfigGlobal `gcfg:"global"`
+	// RetryPolicy is the retry policy of the operations of the resources, see
+	// ConfigRetryPolicy.
+	RetryPolicy map[string]*ConfigRetryPolicy `gcfg:"retry-policy"`
 }
 
 // CloudConfig includes all the necessary configuration for creating Cloud
@@ -273,6 +435,18 @@ type CloudConfig struct {
 	AlphaFeatureGate             *AlphaFeatureGate
 	StackType                    string
 	ExternalInstanceGroupsPrefix string
+	ManagedInstanceGroupsPrefix  string
+	MaxTargetPoolInstances       int
+	TargetPoolSubsettingStrategy TargetPoolSubsettingStrategy
+	ILBSubsetting                bool
+	MultiRegion                  bool
+	XPNFirewalls                 bool
+	XPNTokenSource               oauth2.TokenSource
+	MutationEvents               bool
+	RetryPolicies                map[string]*ConfigRetryPolicy
+	MissingInstanceConfirmations int
+	MissingInstanceGracePeriod   time.Duration
+	RouteOperationsParallelism   int
 }
 
 func init() {
@@ -335,6 +509,17 @@ func readConfig(reader io.Reader) (*ConfigFile, error) {
 	return cfg, nil
 }
 
+// configFileTokenSource returns the token source of the token-url of the
+// config, which must be set.
+func configFileTokenSource(configFile *ConfigFile) oauth2.TokenSource {
+	// if tokenURL is nil, set tokenSource to nil. This will force the OAuth client to fall
+	// back to use DefaultTokenSource. This allows running gceCloud remotely.
+	if configFile.Global.TokenURL == "nil" {
+		return nil
+	}
+	return NewAltTokenSource(configFile.Global.TokenURL, configFile.Global.TokenBody)
+}
+
 func generateCloudConfig(configFile *ConfigFile) (cloudConfig *CloudConfig, err error) {
 	cloudConfig = &CloudConfig{}
 	// By default, fetch token from GCE metadata server
@@ -350,19 +535,52 @@ func generateCloudConfig(configFile *ConfigFile) (cloudConfig *CloudConfig, err
 			cloudConfig.ContainerAPIEndpoint = configFile.Global.ContainerAPIEndpoint
 		}
 
+		if err := validateAPIEndpoint("api-endpoint", cloudConfig.APIEndpoint); err != nil {
+			return nil, err
+		}
+		if err := validateAPIEndpoint("container-api-endpoint", cloudConfig.ContainerAPIEndpoint); err != nil {
+			return nil, err
+		}
+
 		if configFile.Global.TokenURL != "" {
-			// if tokenURL is nil, set tokenSource to nil. This will force the OAuth client to fall
-			// back to use DefaultTokenSource. This allows running gceCloud remotely.
-			if configFile.Global.TokenURL == "nil" {
-				cloudConfig.TokenSource = nil
-			} else {
-				cloudConfig.TokenSource = NewAltTokenSource(configFile.Global.TokenURL, configFile.Global.TokenBody)
-			}
+			cloudConfig.TokenSource = configFileTokenSource(configFile)
 		}
 
 		cloudConfig.NodeTags = configFile.Global.NodeTags
 		cloudConfig.NodeInstancePrefix = configFile.Global.NodeInstancePrefix
 		cloudConfig.ExternalInstanceGroupsPrefix = configFile.Global.ExternalInstanceGroupsPrefix
+		cloudConfig.ManagedInstanceGroupsPrefix = configFile.Global.ManagedInstanceGroupsPrefix
+		cloudConfig.MaxTargetPoolInstances = configFile.Global.MaxTargetPoolInstances
+		cloudConfig.TargetPoolSubsettingStrategy = TargetPoolSubsettingStrategy(configFile.Global.TargetPoolSubsettingStrategy)
+		if err := validateTargetPoolSubsetting(cloudConfig.MaxTargetPoolInstances, cloudConfig.TargetPoolSubsettingStrategy); err != nil {
+			return nil, err
+		}
+		cloudConfig.ILBSubsetting = configFile.Global.ILBSubsetting
+		cloudConfig.MultiRegion = configFile.Global.MultiRegion
+		cloudConfig.XPNFirewalls = configFile.Global.XPNFirewalls
+		if configFile.Global.XPNCredentialsFile != "" {
+			if !cloudConfig.XPNFirewalls {
+				return nil, fmt.Errorf("xpn-credentials-file requires xpn-firewalls")
+			}
+			if cloudConfig.XPNTokenSource, err = xpnTokenSource(configFile.Global.XPNCredentialsFile); err != nil {
+				return nil, err
+			}
+		}
+		cloudConfig.MutationEvents = configFile.Global.MutationEvents
+		cloudConfig.MissingInstanceConfirmations = configFile.Global.MissingInstanceConfirmations
+		if configFile.Global.MissingInstanceGracePeriod != "" {
+			if cloudConfig.MissingInstanceGracePeriod, err = time.ParseDuration(configFile.Global.MissingInstanceGracePeriod); err != nil {
+				return nil, fmt.Errorf("invalid missing-instance-grace-period %q: %v", configFile.Global.MissingInstanceGracePeriod, err)
+			}
+		}
+		if err := validateMissingInstanceConfirmation(cloudConfig.MissingInstanceConfirmations, cloudConfig.MissingInstanceGracePeriod); err != nil {
+			return nil, err
+		}
+		cloudConfig.RouteOperationsParallelism = configFile.Global.RouteOperationsParallelism
+		if err := validateRouteOperationsParallelism(cloudConfig.RouteOperationsParallelism); err != nil {
+			return nil, err
+		}
+		cloudConfig.RetryPolicies = configFile.RetryPolicy
 		cloudConfig.AlphaFeatureGate = NewAlphaFeatureGate(configFile.Global.AlphaFeatures)
 	}
 
@@ -451,24 +669,41 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 	// clients, to identify Kubernetes as the origin of the GCP API calls.
 	userAgent := fmt.Sprintf("Kubernetes/%s (%s %s)", version, runtime.GOOS, runtime.GOARCH)
 
+	retryPolicies, err := newRetryPolicies(config.RetryPolicies)
+	if err != nil {
+		return nil, err
+	}
+
 	// Use ProjectID for NetworkProjectID, if it wasn't explicitly set.
 	if config.NetworkProjectID == "" {
 		config.NetworkProjectID = config.ProjectID
 	}
 
-	service, err := compute.NewService(context.Background(), option.WithTokenSource(config.TokenSource))
+	client, err := newOauthClient(config.TokenSource)
+	if err != nil {
+		return nil, err
+	}
+	var computeTransport http.RoundTripper = &responseFieldsTransport{base: client.Transport}
+	var mutations *mutationEventTransport
+	if config.MutationEvents {
+		mutations = &mutationEventTransport{base: computeTransport}
+		computeTransport = mutations
+	}
+	computeOption := option.WithHTTPClient(&http.Client{Transport: computeTransport})
+
+	service, err := compute.NewService(context.Background(), computeOption)
 	if err != nil {
 		return nil, err
 	}
 	service.UserAgent = userAgent
 
-	serviceBeta, err := computebeta.NewService(context.Background(), option.WithTokenSource(config.TokenSource))
+	serviceBeta, err := computebeta.NewService(context.Background(), computeOption)
 	if err != nil {
 		return nil, err
 	}
 	serviceBeta.UserAgent = userAgent
 
-	serviceAlpha, err := computealpha.NewService(context.Background(), option.WithTokenSource(config.TokenSource))
+	serviceAlpha, err := computealpha.NewService(context.Background(), computeOption)
 	if err != nil {
 		return nil, err
 	}
@@ -477,12 +712,12 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 	if config.APIEndpoint != "" {
 		if strings.HasSuffix(service.BasePath, "/projects/") {
 			service.BasePath = getProjectsBasePath(config.APIEndpoint)
-			serviceBeta.BasePath = getProjectsBasePath(strings.Replace(config.APIEndpoint, "v1", "beta", -1))
-			serviceAlpha.BasePath = getProjectsBasePath(strings.Replace(config.APIEndpoint, "v1", "alpha", -1))
+			serviceBeta.BasePath = getProjectsBasePath(versionedAPIEndpoint(config.APIEndpoint, "beta"))
+			serviceAlpha.BasePath = getProjectsBasePath(versionedAPIEndpoint(config.APIEndpoint, "alpha"))
 		} else {
 			service.BasePath = config.APIEndpoint
-			serviceBeta.BasePath = strings.Replace(config.APIEndpoint, "v1", "beta", -1)
-			serviceAlpha.BasePath = strings.Replace(config.APIEndpoint, "v1", "alpha", -1)
+			serviceBeta.BasePath = versionedAPIEndpoint(config.APIEndpoint, "beta")
+			serviceAlpha.BasePath = versionedAPIEndpoint(config.APIEndpoint, "alpha")
 		}
 	}
 
@@ -495,11 +730,11 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 		containerService.BasePath = config.ContainerAPIEndpoint
 	}
 
-	client, err := newOauthClient(config.TokenSource)
+	tpuService, err := newTPUService(client)
 	if err != nil {
 		return nil, err
 	}
-	tpuService, err := newTPUService(client)
+	dnsService, err := newDNSService(client, userAgent)
 	if err != nil {
 		return nil, err
 	}
@@ -551,6 +786,7 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 		serviceBeta:                  serviceBeta,
 		containerService:             containerService,
 		tpuService:                   tpuService,
+		dnsService:                   dnsService,
 		projectID:                    projID,
 		networkProjectID:             netProjID,
 		onXPN:                        onXPN,
@@ -572,9 +808,28 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 		projectsBasePath:             getProjectsBasePath(service.BasePath),
 		stackType:                    StackType(config.StackType),
 		externalInstanceGroupsPrefix: config.ExternalInstanceGroupsPrefix,
+		managedInstanceGroupsPrefix:  config.ManagedInstanceGroupsPrefix,
+		maxTargetPoolInstances:       config.MaxTargetPoolInstances,
+		targetPoolSubsettingStrategy: config.TargetPoolSubsettingStrategy,
+		ilbSubsetting:                config.ILBSubsetting,
+		multiRegion:                  config.MultiRegion,
+		retryPolicies:                retryPolicies,
+		missingInstances: missingInstanceConfirmation{
+			confirmations: config.MissingInstanceConfirmations,
+			gracePeriod:   config.MissingInstanceGracePeriod,
+		},
+		routeOps: routeOperations{
+			parallelism: config.RouteOperationsParallelism,
+			window:      routeBatchWindow,
+			clock:       clock.RealClock{},
+		},
+		clock: clock.RealClock{},
 	}
 
 	gce.manager = &gceServiceManager{gce}
+	if mutations != nil {
+		mutations.cloud = gce
+	}
 	gce.s = &cloud.Service{
 		GA:            service,
 		Alpha:         serviceAlpha,
@@ -584,6 +839,19 @@ func CreateGCECloud(config *CloudConfig) (*Cloud, error) {
 	}
 	gce.c = cloud.NewGCE(gce.s)
 
+	if config.XPNFirewalls {
+		if !onXPN {
+			klog.Warningf("Ignoring xpn-firewalls, the network of the cluster is in its project %s", projID)
+		} else {
+			gce.xpnFirewalls = true
+			if config.XPNTokenSource != nil {
+				if gce.hostFirewalls, err = newHostFirewalls(gce, config.XPNTokenSource, service.BasePath, userAgent, config.MutationEvents); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
 	return gce, nil
 }
 
@@ -697,7 +965,10 @@ func (g *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder,
 	g.eventRecorder = g.eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "g-cloudprovider"})
 
 	go g.watchClusterID(stop)
+	g.watchRetainedILBIPs(stop)
 	go g.metricsCollector.Run(stop)
+	go g.resumeLoadBalancerCleanupsWhenReady(stop)
+	go g.runQuarantinedAddressesGC(stop)
 }
 
 // LoadBalancer returns an implementation of LoadBalancer for Google Compute Engine.
@@ -791,10 +1062,14 @@ func (g *Cloud) SetInformers(informerFactory informers.SharedInformerFactory) {
 		AddFunc: func(obj interface{}) {
 			node := obj.(*v1.Node)
 			g.updateNodeZones(nil, node)
+			g.nodeInstances.update(nil, node)
 		},
 		UpdateFunc: func(prev, obj interface{}) {
 			prevNode := prev.(*v1.Node)
 			newNode := obj.(*v1.Node)
+			if newNode.Spec.ProviderID != prevNode.Spec.ProviderID {
+				g.nodeInstances.update(prevNode, newNode)
+			}
 			if getZone(newNode) == getZone(prevNode) {
 				return
 			}
@@ -817,6 +1092,9 @@ func (g *Cloud) SetInformers(informerFactory informers.SharedInformerFactory) {
 				}
 			}
 			g.updateNodeZones(node, nil)
+			g.nodeInstances.update(node, nil)
+			g.lbNodes.forget(node.Name)
+			g.forgetMissingInstance(node.Spec.ProviderID)
 		},
 	})
 	g.nodeInformerSynced = nodeInformer.HasSynced
@@ -862,6 +1140,34 @@ func getProjectsBasePath(basePath string) string {
 	return basePath
 }
 
+// versionedAPIEndpoint returns the compute API endpoint of the given version.
+// Only the last "v1" is replaced, which is the version element of the path, so
+// that hosts of custom endpoints (e.g. Private Service Connect) are kept.
+func versionedAPIEndpoint(endpoint, version string) string {
+	i := strings.LastIndex(endpoint, "v1")
+	if i < 0 {
+		return endpoint
+	}
+	return endpoint[:i] + version + endpoint[i+len("v1"):]
+}
+
+// validateAPIEndpoint returns an error if the configured endpoint is not an
+// absolute http(s) URL, so that misconfigured endpoint overrides fail at
+// startup instead of on the first API call.
+func validateAPIEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, endpoint, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an absolute http(s) URL", name, endpoint)
+	}
+	return nil
+}
+
 // Project IDs cannot have a digit for the first characeter. If the id contains a digit,
 // then it must be a project number.
 func isProjectNumber(idOrNumber string) bool {
@@ -77,7 +77,9 @@ func (g *Cloud) ReserveRegionAddress(addr *compute.Address, region string) error
 	defer cancel()
 
 	mc := newAddressMetricContext("reserve", region)
-	return mc.Observe(g.c.Addresses().Insert(ctx, meta.RegionalKey(addr.Name, region), addr))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyAddress, "reserve", func() error {
+		return g.c.Addresses().Insert(ctx, meta.RegionalKey(addr.Name, region), addr)
+	}))
 }
 
 // ReserveBetaRegionAddress creates a beta region address
@@ -86,7 +88,9 @@ func (g *Cloud) ReserveBetaRegionAddress(addr *computebeta.Address, region strin
 	defer cancel()
 
 	mc := newAddressMetricContext("reserve", region)
-	return mc.Observe(g.c.BetaAddresses().Insert(ctx, meta.RegionalKey(addr.Name, region), addr))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyAddress, "reserve", func() error {
+		return g.c.BetaAddresses().Insert(ctx, meta.RegionalKey(addr.Name, region), addr)
+	}))
 }
 
 // DeleteRegionAddress deletes a region address by name.
@@ -95,7 +99,9 @@ func (g *Cloud) DeleteRegionAddress(name, region string) error {
 	defer cancel()
 
 	mc := newAddressMetricContext("delete", region)
-	return mc.Observe(g.c.Addresses().Delete(ctx, meta.RegionalKey(name, region)))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyAddress, "delete", func() error {
+		return g.c.Addresses().Delete(ctx, meta.RegionalKey(name, region))
+	}))
 }
 
 // GetRegionAddress returns the region address by name
@@ -27,6 +27,10 @@ const (
 	// AlphaFeatureSkipIGsManagement enabled L4 Regional Backend Services and
 	// disables instance group management in service controller
 	AlphaFeatureSkipIGsManagement = "SkipIGsManagement"
+
+	// AlphaFeatureCloudNATCheck enables checking, when nodes register, that
+	// nodes without an external IP have a Cloud NAT on their subnetwork.
+	AlphaFeatureCloudNATCheck = "CloudNATCheck"
 )
 
 // AlphaFeatureGate contains a mapping of alpha features to whether they are enabled
@@ -20,12 +20,15 @@ limitations under the License.
 package gce
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"k8s.io/klog/v2"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 // LoadBalancerType defines a specific type for holding load balancer types (eg. Internal)
@@ -64,6 +67,187 @@ const (
 	// cluster is created in.
 	ServiceAnnotationILBSubnet = "networking.gke.io/internal-load-balancer-subnet"
 
+	// ServiceAnnotationLoadBalancerRegion is annotated on an internal
+	// LoadBalancer Service with the name of the region of its load balancer,
+	// by default the region of the cluster. Only the nodes of the region are
+	// its backends, e.g. the node pools of a region of a multi-region VPC,
+	// and the Service must name a subnetwork of the region with
+	// ServiceAnnotationILBSubnet. Changing the region recreates the load
+	// balancer, and changes its IP.
+	ServiceAnnotationLoadBalancerRegion = "networking.gke.io/load-balancer-region"
+
+	// ServiceAnnotationILBAllowPSCPacketInjection is annotated on an internal
+	// LoadBalancer Service with "true" to allow Private Service Connect packet
+	// injection on its forwarding rule. The field is only available in the
+	// beta API, and is not set when the beta API is not available.
+	ServiceAnnotationILBAllowPSCPacketInjection = "networking.gke.io/internal-load-balancer-allow-psc-packet-injection"
+
+	// ServiceAnnotationILBHealthCheckLogging is annotated on an internal
+	// LoadBalancer Service with "true" to enable the logging of the probes
+	// of its health check, e.g. to debug flapping backends, and is removed or
+	// set to "false" to disable it again. It only applies to the health checks
+	// dedicated to the Service, i.e. with externalTrafficPolicy=Local, the
+	// health checks shared with other Services are left as is.
+	ServiceAnnotationILBHealthCheckLogging = "networking.gke.io/internal-load-balancer-health-check-logging"
+
+	// ServiceAnnotationILBRetainIP is annotated on an internal LoadBalancer
+	// Service with "true" to remember the IP of its load balancer, and to
+	// request it again when the Service is deleted and recreated with the
+	// same namespace and name, e.g. by GitOps tools re-applying all objects.
+	// The IP is not reserved while the Service does not exist, and a new IP
+	// is allocated if it was taken in the meantime. The IP is forgotten when
+	// the annotation is removed from the Service.
+	ServiceAnnotationILBRetainIP = "networking.gke.io/internal-load-balancer-retain-ip"
+
+	// ServiceAnnotationILBSubsetting is annotated on an internal LoadBalancer
+	// Service with "true" to load balance it to zonal GCE_VM_IP network
+	// endpoint groups of a subset of the nodes instead of their instance
+	// groups, or with "false" to keep the instance groups when the subsetting
+	// is enabled for the cluster.
+	ServiceAnnotationILBSubsetting = "networking.gke.io/internal-load-balancer-subsetting"
+
+	// ServiceAnnotationILBPreserveClientIP is annotated on an internal UDP
+	// LoadBalancer Service with "true" to require the client IPs of its flows
+	// to be preserved. The nodes SNAT the flows they forward to the endpoints
+	// of other nodes, so it requires externalTrafficPolicy=Local, and the
+	// flows of the nodes losing their endpoints are then never kept on them.
+	ServiceAnnotationILBPreserveClientIP = "networking.gke.io/internal-load-balancer-preserve-client-ip"
+
+	// ServiceAnnotationLoadBalancerSessionAffinity is annotated on a
+	// LoadBalancer Service implemented with a backend service, internal or
+	// external with ServiceAnnotationLoadBalancerBackendService, with the
+	// session affinity of its backend service, overriding the one of
+	// spec.sessionAffinity: "CLIENT_IP_PROTO" or "CLIENT_IP_PORT_PROTO", or
+	// "NONE" and "CLIENT_IP". The backend service is updated in place when the
+	// affinity changes, except the shared backend services, named after the
+	// affinity: the load balancer is moved to the backend service of the new
+	// affinity. "GENERATED_COOKIE" is only supported by the proxy load
+	// balancers, and the target pools keep the affinity of the Service.
+	ServiceAnnotationLoadBalancerSessionAffinity = "networking.gke.io/load-balancer-session-affinity"
+
+	// ServiceAnnotationLoadBalancerForwardingRulePerProtocol is annotated on
+	// an external LoadBalancer Service with "true" to allow ports of different
+	// protocols, e.g. TCP 443 and UDP 443. A forwarding rule has a single
+	// protocol, the ports of the protocols other than the one of the first
+	// port get forwarding rules of their own, sharing the IP and the target
+	// pool of the load balancer. The IP then stays reserved as a static IP.
+	// The forwarding rules of the protocols removed from the ports are deleted,
+	// and all of them once the annotation is removed or with the load balancer.
+	ServiceAnnotationLoadBalancerForwardingRulePerProtocol = "networking.gke.io/load-balancer-forwarding-rule-per-protocol"
+
+	// ServiceAnnotationLoadBalancerBackendService is annotated on an external
+	// LoadBalancer Service with "true" to implement its load balancer with a
+	// regional backend service, whose backends are the instance groups of the
+	// nodes, instead of a target pool. The load balancer of an existing
+	// Service is migrated in place, keeping its IP: the forwarding rule is
+	// recreated with the backend service, which interrupts the traffic and
+	// waits for the maintenance window of the Service if any, and the target
+	// pool is only deleted once the backends of the backend service are
+	// healthy. The migration is not reverted when the annotation is removed.
+	// The load balancers of the dual-stack Services always use a backend
+	// service, the target pools do not serve IPv6.
+	ServiceAnnotationLoadBalancerBackendService = "networking.gke.io/load-balancer-backend-service"
+
+	// ServiceAnnotationLoadBalancerSecurityPolicy is annotated on an external
+	// LoadBalancer Service implemented with a backend service, see
+	// ServiceAnnotationLoadBalancerBackendService, with the name of a Cloud
+	// Armor security policy of the region of the cluster, which is attached to
+	// the backend service. The policy is detached when the annotation is
+	// removed. The target pools do not support security policies.
+	ServiceAnnotationLoadBalancerSecurityPolicy = "cloud.google.com/security-policy"
+
+	// ServiceAnnotationLoadBalancerReserveIP is annotated on an external
+	// LoadBalancer Service with "true" to reserve the IP of its load balancer
+	// as a static IP named after the load balancer for the lifetime of the
+	// Service, instead of only while its load balancer is updated. The
+	// description of the address records the Service and the cluster owning
+	// it, and the address is released when the load balancer is deleted, or
+	// demoted to an ephemeral IP once the annotation is removed. It is
+	// ignored for the Services requesting an IP or sharing one.
+	ServiceAnnotationLoadBalancerReserveIP = "networking.gke.io/load-balancer-reserve-ip"
+
+	// ServiceAnnotationLoadBalancerStandardTierFallback is annotated on an
+	// external LoadBalancer Service of the Premium network tier with "true"
+	// to reserve a Standard Tier IP for its new load balancer when the
+	// Premium Tier IPs or the address quota of the region are exhausted. The
+	// load balancer keeps its Standard Tier IP while the annotation is set.
+	// It is ignored for the Services requesting an IP, sharing one, or
+	// requesting an IPv6 address.
+	ServiceAnnotationLoadBalancerStandardTierFallback = "networking.gke.io/load-balancer-standard-tier-fallback"
+
+	// ServiceAnnotationLoadBalancerHealthCheck is annotated on a LoadBalancer
+	// Service implemented with a backend service, i.e. an internal one or an
+	// external one annotated with ServiceAnnotationLoadBalancerBackendService,
+	// with the name of an existing health check managed outside of the
+	// cluster, e.g. by an external health checking system. The health check is
+	// attached to the backend service instead of the health check of the
+	// provider, and is never updated nor deleted by the provider, which only
+	// allows the probes to the port it checks. The target pools do not support
+	// it.
+	ServiceAnnotationLoadBalancerHealthCheck = "networking.gke.io/load-balancer-health-check"
+
+	// ServiceAnnotationLoadBalancerSharedIP is annotated on an external
+	// LoadBalancer Service with the name of a group of Services sharing an
+	// IP. The Services of a group get a single static IP, reserved on the
+	// first one, their forwarding rules partition the ports of the IP so
+	// their port ranges must not overlap. The IP is released once the last
+	// forwarding rule using it is deleted.
+	ServiceAnnotationLoadBalancerSharedIP = "networking.gke.io/load-balancer-shared-ip"
+
+	// ServiceAnnotationLoadBalancerAdoptForwardingRule is annotated on an
+	// external LoadBalancer Service with "true" to adopt a forwarding rule
+	// created outside of the cluster, e.g. by Terraform, holding the IP or
+	// the name of its load balancer. The forwarding rule is replaced by the
+	// one of the load balancer, recording its name in the description, if
+	// its scheme, network tier, protocol and port range match the Service.
+	// Its target pool and health checks are left to their owner. The
+	// annotation is ignored unless the cloud provider runs with
+	// --cloud-provider-gce-lb-adopt-forwarding-rules.
+	ServiceAnnotationLoadBalancerAdoptForwardingRule = "networking.gke.io/load-balancer-adopt-forwarding-rule"
+
+	// ServiceAnnotationLoadBalancerNodesHealthCheckPort and
+	// ServiceAnnotationLoadBalancerNodesHealthCheckPath are annotated on a
+	// LoadBalancer Service with externalTrafficPolicy=Cluster to override the
+	// port and the path of the health check of its nodes, e.g. when its nodes
+	// run a dataplane replacing kube-proxy. The Service then gets a health
+	// check of its own instead of the one shared by the other Services.
+	ServiceAnnotationLoadBalancerNodesHealthCheckPort = "networking.gke.io/load-balancer-nodes-health-check-port"
+	ServiceAnnotationLoadBalancerNodesHealthCheckPath = "networking.gke.io/load-balancer-nodes-health-check-path"
+
+	// ServiceAnnotationILBHealthCheckType is annotated on an internal
+	// LoadBalancer Service with "TCP" to health check the node port of its
+	// first TCP port with TCP instead of the HTTP health check of the nodes, for
+	// the dataplanes which do not serve the health check path. The Service
+	// then gets a health check of its own. "HTTP" is the default. The target
+	// pools of the external load balancers only support HTTP health checks.
+	ServiceAnnotationILBHealthCheckType = "networking.gke.io/internal-load-balancer-health-check-type"
+
+	// ServiceAnnotationLoadBalancerHealthCheckInterval,
+	// ServiceAnnotationLoadBalancerHealthCheckTimeout,
+	// ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold and
+	// ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold are annotated
+	// on a LoadBalancer Service to override the cluster-wide settings of the
+	// health check of its load balancer: the check interval and the timeout in
+	// seconds, between 1 and 300, the timeout not exceeding the interval, and
+	// the number of consecutive probes marking a node healthy or unhealthy,
+	// between 1 and 10. The Service then gets a health check of its own, set
+	// to these values even if the existing health check has larger ones. Its
+	// port and path are the ones of the Service for local traffic, or of the
+	// nodes health check, see ServiceAnnotationLoadBalancerNodesHealthCheckPort.
+	ServiceAnnotationLoadBalancerHealthCheckInterval           = "networking.gke.io/load-balancer-health-check-interval"
+	ServiceAnnotationLoadBalancerHealthCheckTimeout            = "networking.gke.io/load-balancer-health-check-timeout"
+	ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold   = "networking.gke.io/load-balancer-health-check-healthy-threshold"
+	ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold = "networking.gke.io/load-balancer-health-check-unhealthy-threshold"
+
+	// ServiceAnnotationLoadBalancerFirewallMergeAllowed is annotated on a
+	// LoadBalancer Service with "true" to keep the allowed entries added
+	// outside of Kubernetes to the firewall of its load balancer, e.g. a
+	// port opened manually. The entries of the provider are recorded in the
+	// description of the firewall, the other entries are kept when the
+	// provider updates it, instead of being removed. The entries of the
+	// firewall when the annotation is set are all kept.
+	ServiceAnnotationLoadBalancerFirewallMergeAllowed = "networking.gke.io/load-balancer-firewall-merge-allowed"
+
 	// NetworkTierAnnotationKey is annotated on a Service object to indicate which
 	// network tier a GCP LB should use. The valid values are "Standard" and
 	// "Premium" (default).
@@ -81,6 +265,61 @@ const (
 
 	// RBSEnabled is an annotation to indicate the Service is opt-in for RBS
 	RBSEnabled = "enabled"
+
+	// ServiceAnnotationResourceDescription is annotated on a Service with a JSON
+	// object of string fields, e.g. {"ticket":"OPS-123","owner":"team-a"}, which
+	// are added to the JSON description of the forwarding rules and target pools
+	// created for the Service. The fields are applied whenever these resources
+	// are created or recreated, existing resources are not recreated when only
+	// the annotation changes.
+	ServiceAnnotationResourceDescription = "networking.gke.io/resource-description"
+
+	// reservedDescriptionFieldPrefix is the prefix of the description fields
+	// managed by the provider, which cannot be set through annotations.
+	reservedDescriptionFieldPrefix = "kubernetes.io/"
+
+	// ServiceAnnotationILBPreservedBackendServiceFields is annotated on an
+	// internal LoadBalancer Service with a comma separated list of backend
+	// service settings enabled outside of Kubernetes which must be kept, e.g.
+	// "iap,cdn". Such settings which are not listed are reverted, with an
+	// event on the Service. Supported values are iap, cdn and
+	// securitySettings.
+	ServiceAnnotationILBPreservedBackendServiceFields = "networking.gke.io/internal-load-balancer-preserved-backend-service-fields"
+
+	// ServiceAnnotationLoadBalancerMaintenanceWindow is annotated on a
+	// LoadBalancer Service with a window, in UTC, formatted as
+	// "[DAYS ]HH:MM-HH:MM", e.g. "02:00-04:00" daily or "Sat,Sun 02:00-04:00".
+	// The changes recreating the forwarding rule or the target pool of its
+	// load balancer, which interrupt the traffic, are deferred until the
+	// window, while the other changes are applied immediately.
+	ServiceAnnotationLoadBalancerMaintenanceWindow = "networking.gke.io/load-balancer-maintenance-window"
+
+	// ServiceAnnotationPSCServiceAttachment is annotated on an internal
+	// LoadBalancer Service with a JSON PSCServiceAttachmentConfig, e.g.
+	// {"consumerAcceptList":[{"project":"consumer-project"}]}, or "{}" for the
+	// defaults, to publish its IPv4 forwarding rule with a Private Service
+	// Connect service attachment. The attachment is deleted when the annotation
+	// is removed, and its state is reported with the
+	// ServiceConditionPSCServiceAttachmentReady condition of the Service.
+	ServiceAnnotationPSCServiceAttachment = "networking.gke.io/psc-service-attachment"
+
+	// ServiceAnnotationReconcile is annotated on a LoadBalancer Service with
+	// ReconcilePaused to stop all changes to its load balancer resources,
+	// e.g. while they are modified manually during an incident. The drift
+	// from the Service is reported with events instead.
+	ServiceAnnotationReconcile = "networking.gke.io/reconcile"
+
+	// ReconcilePaused is the ServiceAnnotationReconcile value pausing the
+	// reconciliation of the load balancer.
+	ReconcilePaused = "paused"
+
+	// ServiceAnnotationLoadBalancerResources is annotated by the provider on
+	// the LoadBalancer Services with a JSON LoadBalancerResourcesCheckpoint recording
+	// the GCE resources of their load balancer. These resources are deleted
+	// with the load balancer even if the provider no longer names them this
+	// way, and their changes outside of the provider are reported while the
+	// reconciliation is paused. It must not be set by users.
+	ServiceAnnotationLoadBalancerResources = "networking.gke.io/load-balancer-resources"
 )
 
 // GetLoadBalancerAnnotationType returns the type of GCP load balancer which should be assembled.
@@ -147,6 +386,9 @@ type ILBOptions struct {
 	AllowGlobalAccess bool
 	// SubnetName indicates which subnet the LoadBalancer VIPs should be assigned from
 	SubnetName string
+	// AllowPSCPacketInjection indicates whether Private Service Connect packet
+	// injection is allowed on the forwarding rule
+	AllowPSCPacketInjection bool
 }
 
 // GetLoadBalancerAnnotationAllowGlobalAccess returns if global access is enabled
@@ -155,6 +397,225 @@ func GetLoadBalancerAnnotationAllowGlobalAccess(service *v1.Service) bool {
 	return service.Annotations[ServiceAnnotationILBAllowGlobalAccess] == "true"
 }
 
+// GetLoadBalancerAnnotationAllowPSCPacketInjection returns if Private Service
+// Connect packet injection is allowed for the given loadbalancer service.
+func GetLoadBalancerAnnotationAllowPSCPacketInjection(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationILBAllowPSCPacketInjection] == "true"
+}
+
+// GetLoadBalancerAnnotationHealthCheckLogging returns if the logging of the
+// health check probes is enabled for the given loadbalancer service.
+func GetLoadBalancerAnnotationHealthCheckLogging(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationILBHealthCheckLogging] == "true"
+}
+
+// GetLoadBalancerAnnotationRetainIP returns if the IP of the internal load
+// balancer is reused when the given loadbalancer service is recreated.
+func GetLoadBalancerAnnotationRetainIP(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationILBRetainIP] == "true"
+}
+
+// GetLoadBalancerAnnotationILBSubsetting returns if the internal load
+// balancer of the given loadbalancer service uses network endpoint groups,
+// and if the annotation is set.
+func GetLoadBalancerAnnotationILBSubsetting(service *v1.Service) (subsetting, ok bool) {
+	switch service.Annotations[ServiceAnnotationILBSubsetting] {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// GetLoadBalancerAnnotationILBPreserveClientIP returns if the client IPs of
+// the flows of the given internal UDP loadbalancer service must be preserved.
+func GetLoadBalancerAnnotationILBPreserveClientIP(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationILBPreserveClientIP] == "true"
+}
+
+// GetLoadBalancerAnnotationForwardingRulePerProtocol returns if the ports of
+// the given external loadbalancer service get a forwarding rule per protocol.
+func GetLoadBalancerAnnotationForwardingRulePerProtocol(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerForwardingRulePerProtocol] == "true"
+}
+
+// GetLoadBalancerAnnotationBackendService returns if the given external
+// loadbalancer service is implemented with a backend service.
+func GetLoadBalancerAnnotationBackendService(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerBackendService] == "true"
+}
+
+// GetLoadBalancerAnnotationSecurityPolicy returns the name of the security
+// policy of the backend service of the given external loadbalancer service,
+// empty if it has none.
+func GetLoadBalancerAnnotationSecurityPolicy(service *v1.Service) string {
+	return service.Annotations[ServiceAnnotationLoadBalancerSecurityPolicy]
+}
+
+// GetLoadBalancerAnnotationHealthCheck returns the name of the health check
+// managed outside of the cluster of the backend service of the given
+// loadbalancer service, empty if the provider manages it.
+func GetLoadBalancerAnnotationHealthCheck(service *v1.Service) string {
+	return service.Annotations[ServiceAnnotationLoadBalancerHealthCheck]
+}
+
+// GetLoadBalancerAnnotationStandardTierFallback returns if the given external
+// loadbalancer service may fall back to a Standard Tier IP.
+func GetLoadBalancerAnnotationStandardTierFallback(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerStandardTierFallback] == "true"
+}
+
+// GetLoadBalancerAnnotationReserveIP returns if the IP of the given external
+// loadbalancer service is reserved for the lifetime of the service.
+func GetLoadBalancerAnnotationReserveIP(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerReserveIP] == "true"
+}
+
+// GetLoadBalancerAnnotationAdoptForwardingRule returns if the given external
+// loadbalancer service adopts the forwarding rule created outside of the
+// cluster holding its IP or name.
+func GetLoadBalancerAnnotationAdoptForwardingRule(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] == "true"
+}
+
+// GetLoadBalancerAnnotationFirewallMergeAllowed returns if the firewall of the
+// given loadbalancer service keeps the allowed entries not managed by the
+// provider.
+func GetLoadBalancerAnnotationFirewallMergeAllowed(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationLoadBalancerFirewallMergeAllowed] == "true"
+}
+
+// GetLoadBalancerAnnotationSharedIP returns the name of the group of Services
+// sharing the IP of the given external loadbalancer service, empty if the IP
+// is not shared.
+func GetLoadBalancerAnnotationSharedIP(service *v1.Service) string {
+	return service.Annotations[ServiceAnnotationLoadBalancerSharedIP]
+}
+
+// hasNodesHealthCheckOverride returns true if the given loadbalancer service
+// overrides the port or the path of the nodes health check.
+func hasNodesHealthCheckOverride(service *v1.Service) bool {
+	_, port := service.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort]
+	_, path := service.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPath]
+	return port || path
+}
+
+// GetLoadBalancerAnnotationNodesHealthCheck returns the path and the port of
+// the nodes health check of the given loadbalancer service, which default to
+// the cluster-wide ones when not overridden.
+func GetLoadBalancerAnnotationNodesHealthCheck(service *v1.Service) (string, int32, error) {
+	path, port := GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
+	if val, ok := service.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPath]; ok {
+		if err := validateHealthCheckPath(val); err != nil {
+			return "", 0, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationLoadBalancerNodesHealthCheckPath, err)
+		}
+		path = val
+	}
+	if val, ok := service.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort]; ok {
+		var err error
+		if port, err = parseHealthCheckPort(val); err != nil {
+			return "", 0, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationLoadBalancerNodesHealthCheckPort, err)
+		}
+	}
+	return path, port, nil
+}
+
+// healthCheckSettingAnnotations are the annotations overriding the settings of
+// the health check of a loadbalancer service.
+var healthCheckSettingAnnotations = []string{
+	ServiceAnnotationLoadBalancerHealthCheckInterval,
+	ServiceAnnotationLoadBalancerHealthCheckTimeout,
+	ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold,
+	ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold,
+}
+
+// hasHealthCheckSettingsOverride returns true if the given loadbalancer
+// service overrides a setting of its health check.
+func hasHealthCheckSettingsOverride(service *v1.Service) bool {
+	for _, key := range healthCheckSettingAnnotations {
+		if _, ok := service.Annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLoadBalancerAnnotationHealthCheckSettings returns the settings of the
+// health check of the given loadbalancer service overriding the cluster-wide
+// ones, zero when not overridden, and an error if an annotation is not a valid
+// setting.
+func GetLoadBalancerAnnotationHealthCheckSettings(service *v1.Service) (HealthCheckDefaults, error) {
+	var settings HealthCheckDefaults
+	for _, s := range []struct {
+		key   string
+		max   int64
+		value *int64
+	}{
+		{ServiceAnnotationLoadBalancerHealthCheckInterval, maxHealthCheckSeconds, &settings.CheckIntervalSec},
+		{ServiceAnnotationLoadBalancerHealthCheckTimeout, maxHealthCheckSeconds, &settings.TimeoutSec},
+		{ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold, maxHealthCheckThreshold, &settings.HealthyThreshold},
+		{ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold, maxHealthCheckThreshold, &settings.UnhealthyThreshold},
+	} {
+		val, ok := service.Annotations[s.key]
+		if !ok {
+			continue
+		}
+		v, err := parseHealthCheckSetting(val, s.max)
+		if err != nil {
+			return HealthCheckDefaults{}, fmt.Errorf("failed to parse annotation %q: %v", s.key, err)
+		}
+		*s.value = v
+	}
+	if settings.CheckIntervalSec != 0 && settings.TimeoutSec > settings.CheckIntervalSec {
+		return HealthCheckDefaults{}, fmt.Errorf("annotation %q: health check timeout %ds is greater than the check interval %ds", ServiceAnnotationLoadBalancerHealthCheckTimeout, settings.TimeoutSec, settings.CheckIntervalSec)
+	}
+	return settings, nil
+}
+
+// HealthCheckType is the protocol of the health checks of a load balancer.
+type HealthCheckType string
+
+const (
+	// HealthCheckTypeHTTP health checks the nodes with the HTTP health check
+	// of the nodes, or of the Service for local traffic.
+	HealthCheckTypeHTTP HealthCheckType = "HTTP"
+	// HealthCheckTypeTCP health checks the node port of the Service with TCP.
+	HealthCheckTypeTCP HealthCheckType = "TCP"
+)
+
+// GetLoadBalancerAnnotationHealthCheckType returns the type of the health
+// check of the given loadbalancer service, and an error if the annotation is
+// not a supported type.
+func GetLoadBalancerAnnotationHealthCheckType(service *v1.Service) (HealthCheckType, error) {
+	val, ok := service.Annotations[ServiceAnnotationILBHealthCheckType]
+	if !ok {
+		return HealthCheckTypeHTTP, nil
+	}
+	switch t := HealthCheckType(val); t {
+	case HealthCheckTypeHTTP, HealthCheckTypeTCP:
+		return t, nil
+	}
+	return "", fmt.Errorf("failed to parse annotation %q: %q is not one of %q or %q", ServiceAnnotationILBHealthCheckType, val, HealthCheckTypeHTTP, HealthCheckTypeTCP)
+}
+
+// GetLoadBalancerAnnotationSessionAffinity returns the GCE session affinity of
+// the backend service of the given loadbalancer service, empty if not
+// overridden, and an error if the annotation is not a supported affinity.
+func GetLoadBalancerAnnotationSessionAffinity(service *v1.Service) (string, error) {
+	val, ok := service.Annotations[ServiceAnnotationLoadBalancerSessionAffinity]
+	if !ok {
+		return "", nil
+	}
+	switch val {
+	case gceAffinityTypeNone, gceAffinityTypeClientIP, gceAffinityTypeClientIPProto, gceAffinityTypeClientIPPortProto:
+		return val, nil
+	case gceAffinityTypeGeneratedCookie:
+		return "", fmt.Errorf("annotation %q: %q is only supported by the proxy load balancers, not by the passthrough load balancers of the Services", ServiceAnnotationLoadBalancerSessionAffinity, val)
+	}
+	return "", fmt.Errorf("failed to parse annotation %q: %q is not one of %q, %q, %q or %q", ServiceAnnotationLoadBalancerSessionAffinity, val, gceAffinityTypeNone, gceAffinityTypeClientIP, gceAffinityTypeClientIPProto, gceAffinityTypeClientIPPortProto)
+}
+
 // GetLoadBalancerAnnotationSubnet returns the configured subnet to assign LoadBalancer IP from.
 func GetLoadBalancerAnnotationSubnet(service *v1.Service) string {
 	if val, exists := service.Annotations[ServiceAnnotationILBSubnet]; exists {
@@ -162,3 +623,167 @@ func GetLoadBalancerAnnotationSubnet(service *v1.Service) string {
 	}
 	return ""
 }
+
+// GetServiceAnnotationResourceDescription returns the custom description fields
+// of the resources created for the given Service, and an error if the annotation
+// is not a valid JSON object of strings or sets a field reserved for the provider.
+func GetServiceAnnotationResourceDescription(service *v1.Service) (map[string]string, error) {
+	val, ok := service.Annotations[ServiceAnnotationResourceDescription]
+	if !ok {
+		return nil, nil
+	}
+	fields := map[string]string{}
+	if err := json.Unmarshal([]byte(val), &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationResourceDescription, err)
+	}
+	for key := range fields {
+		if strings.HasPrefix(key, reservedDescriptionFieldPrefix) {
+			return nil, fmt.Errorf("annotation %q must not set field %q, fields prefixed with %q are reserved", ServiceAnnotationResourceDescription, key, reservedDescriptionFieldPrefix)
+		}
+	}
+	return fields, nil
+}
+
+// GetServiceAnnotationILBPreservedBackendServiceFields returns the backend
+// service settings to preserve for the given Service, and an error if the
+// annotation lists an unsupported setting.
+func GetServiceAnnotationILBPreservedBackendServiceFields(service *v1.Service) (sets.String, error) {
+	fields := sets.NewString()
+	val, ok := service.Annotations[ServiceAnnotationILBPreservedBackendServiceFields]
+	if !ok {
+		return fields, nil
+	}
+	for _, field := range strings.Split(val, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := guardedBackendServiceFields[field]; !ok {
+			return nil, fmt.Errorf("annotation %q has unsupported backend service field %q, supported fields are %v", ServiceAnnotationILBPreservedBackendServiceFields, field, guardedBackendServiceFieldNames())
+		}
+		fields.Insert(field)
+	}
+	return fields, nil
+}
+
+// PSCServiceAttachmentConfig is the configuration of the Private Service
+// Connect service attachment of ServiceAnnotationPSCServiceAttachment.
+type PSCServiceAttachmentConfig struct {
+	// NatSubnets are the names of the PRIVATE_SERVICE_CONNECT subnetworks of
+	// the region translating the addresses of the consumers. All the
+	// PRIVATE_SERVICE_CONNECT subnetworks of the network in the region are
+	// used by default.
+	NatSubnets []string `json:"natSubnets,omitempty"`
+	// ConnectionPreference is PSCAcceptAutomatic, the default, to accept the
+	// connections of all the consumers which are not rejected, or
+	// PSCAcceptManual to only accept the consumers of ConsumerAcceptList.
+	ConnectionPreference string `json:"connectionPreference,omitempty"`
+	// ConsumerAcceptList are the consumer projects accepted with
+	// PSCAcceptManual.
+	ConsumerAcceptList []PSCConsumer `json:"consumerAcceptList,omitempty"`
+	// ConsumerRejectList are the IDs or numbers of the rejected consumer
+	// projects.
+	ConsumerRejectList []string `json:"consumerRejectList,omitempty"`
+	// EnableProxyProtocol prepends the PROXY protocol header with the
+	// consumer information to the connections. Changing it recreates the
+	// service attachment, which disconnects the consumers.
+	EnableProxyProtocol bool `json:"enableProxyProtocol,omitempty"`
+}
+
+// PSCConsumer is an accepted consumer project of a service attachment.
+type PSCConsumer struct {
+	// Project is the ID or the number of the project.
+	Project string `json:"project"`
+	// ConnectionLimit is the maximum number of the endpoints of the project
+	// connected to the service attachment.
+	ConnectionLimit int64 `json:"connectionLimit,omitempty"`
+}
+
+const (
+	// PSCAcceptAutomatic accepts the connections of all the consumers.
+	PSCAcceptAutomatic = "ACCEPT_AUTOMATIC"
+	// PSCAcceptManual accepts the connections of the listed consumers.
+	PSCAcceptManual = "ACCEPT_MANUAL"
+)
+
+// GetLoadBalancerAnnotationRegion returns the region the load balancer of the
+// given service is pinned to, empty if none.
+func GetLoadBalancerAnnotationRegion(service *v1.Service) string {
+	return service.Annotations[ServiceAnnotationLoadBalancerRegion]
+}
+
+// GetLoadBalancerAnnotationPSCServiceAttachment returns the configuration of
+// the service attachment of the given Service, nil if it is not published,
+// and an error if the annotation is not a valid configuration.
+func GetLoadBalancerAnnotationPSCServiceAttachment(service *v1.Service) (*PSCServiceAttachmentConfig, error) {
+	val, ok := service.Annotations[ServiceAnnotationPSCServiceAttachment]
+	if !ok {
+		return nil, nil
+	}
+	config := &PSCServiceAttachmentConfig{}
+	decoder := json.NewDecoder(strings.NewReader(val))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationPSCServiceAttachment, err)
+	}
+	switch config.ConnectionPreference {
+	case "":
+		config.ConnectionPreference = PSCAcceptAutomatic
+	case PSCAcceptAutomatic, PSCAcceptManual:
+	default:
+		return nil, fmt.Errorf("failed to parse annotation %q: connectionPreference %q is not one of %q or %q", ServiceAnnotationPSCServiceAttachment, config.ConnectionPreference, PSCAcceptAutomatic, PSCAcceptManual)
+	}
+	for _, consumer := range config.ConsumerAcceptList {
+		if consumer.Project == "" {
+			return nil, fmt.Errorf("failed to parse annotation %q: consumerAcceptList has an entry without project", ServiceAnnotationPSCServiceAttachment)
+		}
+		if consumer.ConnectionLimit < 0 {
+			return nil, fmt.Errorf("failed to parse annotation %q: connectionLimit %d of project %q is negative", ServiceAnnotationPSCServiceAttachment, consumer.ConnectionLimit, consumer.Project)
+		}
+	}
+	return config, nil
+}
+
+// LoadBalancerResourcesCheckpoint is the checkpoint of the GCE resources of
+// the load balancer of a Service recorded in
+// ServiceAnnotationLoadBalancerResources.
+type LoadBalancerResourcesCheckpoint struct {
+	// Region is the region of the regional resources.
+	Region string `json:"region"`
+	// Hash is the hash of the Service and of the region the resources were
+	// recorded for, the resources are only recorded again once it changes.
+	Hash string `json:"hash,omitempty"`
+	// Resources are the resources of the load balancer, in the order of
+	// their deletion.
+	Resources []CheckpointedLoadBalancerResource `json:"resources"`
+}
+
+// CheckpointedLoadBalancerResource is a GCE resource of a load balancer.
+type CheckpointedLoadBalancerResource struct {
+	Kind LoadBalancerResource `json:"kind"`
+	Name string               `json:"name"`
+	// Fingerprint is the fingerprint of the resource when it was recorded,
+	// for the kinds of resources which have one.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// GetLoadBalancerAnnotationResources returns the checkpoint of the resources
+// of the load balancer of the given Service, nil if it was not recorded, and
+// an error if the annotation is not a valid checkpoint.
+func GetLoadBalancerAnnotationResources(service *v1.Service) (*LoadBalancerResourcesCheckpoint, error) {
+	val, ok := service.Annotations[ServiceAnnotationLoadBalancerResources]
+	if !ok {
+		return nil, nil
+	}
+	checkpoint := &LoadBalancerResourcesCheckpoint{}
+	if err := json.Unmarshal([]byte(val), checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationLoadBalancerResources, err)
+	}
+	return checkpoint, nil
+}
+
+// IsServiceReconcilePaused returns true if the reconciliation of the load
+// balancer of the Service is paused by ServiceAnnotationReconcile.
+func IsServiceReconcilePaused(service *v1.Service) bool {
+	return service.Annotations[ServiceAnnotationReconcile] == ReconcilePaused
+}
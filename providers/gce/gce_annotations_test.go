@@ -72,3 +72,298 @@ func TestServiceNetworkTierAnnotationKey(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceAnnotationResourceDescription(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations    map[string]string
+		expectedFields map[string]string
+		expectErr      bool
+	}{
+		"No annotation": {
+			annotations: nil,
+		},
+		"Custom fields": {
+			annotations:    map[string]string{ServiceAnnotationResourceDescription: `{"ticket":"OPS-123","owner":"team-a"}`},
+			expectedFields: map[string]string{"ticket": "OPS-123", "owner": "team-a"},
+		},
+		"Report an error on invalid JSON": {
+			annotations: map[string]string{ServiceAnnotationResourceDescription: `ticket=OPS-123`},
+			expectErr:   true,
+		},
+		"Report an error on non string values": {
+			annotations: map[string]string{ServiceAnnotationResourceDescription: `{"ticket":123}`},
+			expectErr:   true,
+		},
+		"Report an error on reserved fields": {
+			annotations: map[string]string{ServiceAnnotationResourceDescription: `{"kubernetes.io/service-name":"other/svc"}`},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			fields, err := GetServiceAnnotationResourceDescription(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			if !testCase.expectErr {
+				assert.Equal(t, testCase.expectedFields, fields)
+			}
+		})
+	}
+}
+
+func TestServiceAnnotationILBPreservedBackendServiceFields(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations    map[string]string
+		expectedFields []string
+		expectErr      bool
+	}{
+		"No annotation": {
+			annotations: nil,
+		},
+		"Preserved fields": {
+			annotations:    map[string]string{ServiceAnnotationILBPreservedBackendServiceFields: "iap, cdn,"},
+			expectedFields: []string{"cdn", "iap"},
+		},
+		"Report an error on unsupported fields": {
+			annotations: map[string]string{ServiceAnnotationILBPreservedBackendServiceFields: "iap,backends"},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			fields, err := GetServiceAnnotationILBPreservedBackendServiceFields(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			if !testCase.expectErr {
+				assert.ElementsMatch(t, testCase.expectedFields, fields.List())
+			}
+		})
+	}
+}
+
+func TestIsServiceReconcilePaused(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations map[string]string
+		expected    bool
+	}{
+		"No annotation": {
+			annotations: nil,
+		},
+		"Paused": {
+			annotations: map[string]string{ServiceAnnotationReconcile: ReconcilePaused},
+			expected:    true,
+		},
+		"Other values do not pause": {
+			annotations: map[string]string{ServiceAnnotationReconcile: "enabled"},
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			assert.Equal(t, testCase.expected, IsServiceReconcilePaused(svc))
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationNodesHealthCheck(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations  map[string]string
+		expectedPath string
+		expectedPort int32
+		expectErr    bool
+	}{
+		"No annotation": {
+			annotations:  nil,
+			expectedPath: GetNodesHealthCheckPath(),
+			expectedPort: GetNodesHealthCheckPort(),
+		},
+		"Port and path": {
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerNodesHealthCheckPort: "10257",
+				ServiceAnnotationLoadBalancerNodesHealthCheckPath: "/ready",
+			},
+			expectedPath: "/ready",
+			expectedPort: 10257,
+		},
+		"Port only": {
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerNodesHealthCheckPort: "10257"},
+			expectedPath: GetNodesHealthCheckPath(),
+			expectedPort: 10257,
+		},
+		"Report an error on invalid ports": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNodesHealthCheckPort: "65536"},
+			expectErr:   true,
+		},
+		"Report an error on relative paths": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerNodesHealthCheckPath: "ready"},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			assert.Equal(t, testCase.annotations != nil, hasNodesHealthCheckOverride(svc))
+			path, port, err := GetLoadBalancerAnnotationNodesHealthCheck(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			if !testCase.expectErr {
+				assert.Equal(t, testCase.expectedPath, path)
+				assert.Equal(t, testCase.expectedPort, port)
+			}
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationSessionAffinity(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations      map[string]string
+		expectedAffinity string
+		expectErr        bool
+	}{
+		"No annotation": {},
+		"CLIENT_IP_PROTO": {
+			annotations:      map[string]string{ServiceAnnotationLoadBalancerSessionAffinity: "CLIENT_IP_PROTO"},
+			expectedAffinity: gceAffinityTypeClientIPProto,
+		},
+		"CLIENT_IP_PORT_PROTO": {
+			annotations:      map[string]string{ServiceAnnotationLoadBalancerSessionAffinity: "CLIENT_IP_PORT_PROTO"},
+			expectedAffinity: gceAffinityTypeClientIPPortProto,
+		},
+		"NONE": {
+			annotations:      map[string]string{ServiceAnnotationLoadBalancerSessionAffinity: "NONE"},
+			expectedAffinity: gceAffinityTypeNone,
+		},
+		"Report an error on the cookie affinity of the proxy load balancers": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionAffinity: "GENERATED_COOKIE"},
+			expectErr:   true,
+		},
+		"Report an error on unsupported affinities": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionAffinity: "ClientIP"},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			affinity, err := GetLoadBalancerAnnotationSessionAffinity(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			assert.Equal(t, testCase.expectedAffinity, affinity)
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationHealthCheckType(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations  map[string]string
+		expectedType HealthCheckType
+		expectErr    bool
+	}{
+		"No annotation": {
+			expectedType: HealthCheckTypeHTTP,
+		},
+		"HTTP": {
+			annotations:  map[string]string{ServiceAnnotationILBHealthCheckType: "HTTP"},
+			expectedType: HealthCheckTypeHTTP,
+		},
+		"TCP": {
+			annotations:  map[string]string{ServiceAnnotationILBHealthCheckType: "TCP"},
+			expectedType: HealthCheckTypeTCP,
+		},
+		"Report an error on unsupported types": {
+			annotations: map[string]string{ServiceAnnotationILBHealthCheckType: "HTTPS"},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			hcType, err := GetLoadBalancerAnnotationHealthCheckType(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			assert.Equal(t, testCase.expectedType, hcType)
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationHealthCheckSettings(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations      map[string]string
+		expectedSettings HealthCheckDefaults
+		expectErr        bool
+	}{
+		"No annotation": {},
+		"All settings": {
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerHealthCheckInterval:           "5",
+				ServiceAnnotationLoadBalancerHealthCheckTimeout:            "5",
+				ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold:   "2",
+				ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold: "10",
+			},
+			expectedSettings: HealthCheckDefaults{CheckIntervalSec: 5, TimeoutSec: 5, HealthyThreshold: 2, UnhealthyThreshold: 10},
+		},
+		"Timeout only": {
+			annotations:      map[string]string{ServiceAnnotationLoadBalancerHealthCheckTimeout: "3"},
+			expectedSettings: HealthCheckDefaults{TimeoutSec: 3},
+		},
+		"Report an error on settings out of range": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthCheckInterval: "301"},
+			expectErr:   true,
+		},
+		"Report an error on zero thresholds": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold: "0"},
+			expectErr:   true,
+		},
+		"Report an error on timeouts greater than the interval": {
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerHealthCheckInterval: "5",
+				ServiceAnnotationLoadBalancerHealthCheckTimeout:  "6",
+			},
+			expectErr: true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			assert.Equal(t, testCase.annotations != nil, hasHealthCheckSettingsOverride(svc))
+			settings, err := GetLoadBalancerAnnotationHealthCheckSettings(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			assert.Equal(t, testCase.expectedSettings, settings)
+		})
+	}
+}
+
+func TestGetLoadBalancerAnnotationPSCServiceAttachment(t *testing.T) {
+	for testName, testCase := range map[string]struct {
+		annotations    map[string]string
+		expectedConfig *PSCServiceAttachmentConfig
+		expectErr      bool
+	}{
+		"No annotation": {},
+		"Defaults": {
+			annotations:    map[string]string{ServiceAnnotationPSCServiceAttachment: `{}`},
+			expectedConfig: &PSCServiceAttachmentConfig{ConnectionPreference: PSCAcceptAutomatic},
+		},
+		"Accept list": {
+			annotations: map[string]string{ServiceAnnotationPSCServiceAttachment: `{"natSubnets":["psc-nat"],"connectionPreference":"ACCEPT_MANUAL","consumerAcceptList":[{"project":"consumer","connectionLimit":5}]}`},
+			expectedConfig: &PSCServiceAttachmentConfig{
+				NatSubnets:           []string{"psc-nat"},
+				ConnectionPreference: PSCAcceptManual,
+				ConsumerAcceptList:   []PSCConsumer{{Project: "consumer", ConnectionLimit: 5}},
+			},
+		},
+		"Report an error on invalid JSON": {
+			annotations: map[string]string{ServiceAnnotationPSCServiceAttachment: `true`},
+			expectErr:   true,
+		},
+		"Report an error on unknown fields": {
+			annotations: map[string]string{ServiceAnnotationPSCServiceAttachment: `{"natSubnet":"psc-nat"}`},
+			expectErr:   true,
+		},
+		"Report an error on unsupported connection preferences": {
+			annotations: map[string]string{ServiceAnnotationPSCServiceAttachment: `{"connectionPreference":"ACCEPT_ALL"}`},
+			expectErr:   true,
+		},
+		"Report an error on consumers without project": {
+			annotations: map[string]string{ServiceAnnotationPSCServiceAttachment: `{"consumerAcceptList":[{"connectionLimit":5}]}`},
+			expectErr:   true,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test-ns", Annotations: testCase.annotations}}
+			config, err := GetLoadBalancerAnnotationPSCServiceAttachment(svc)
+			assert.Equal(t, testCase.expectErr, err != nil)
+			assert.Equal(t, testCase.expectedConfig, config)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+)
+
+// versionedCall is a Compute API call implemented for several API versions,
+// so that the features only available in the beta or alpha API are used when
+// requested, and the call falls back to the GA behavior otherwise. The
+// versions without an implementation fall back to the next lower version.
+type versionedCall struct {
+	// name identifies the call in the logs.
+	name  string
+	ga    func() error
+	beta  func() error
+	alpha func() error
+}
+
+func (c versionedCall) implementation(version meta.Version) func() error {
+	switch version {
+	case meta.VersionAlpha:
+		return c.alpha
+	case meta.VersionBeta:
+		return c.beta
+	default:
+		return c.ga
+	}
+}
+
+// fallbackAPIVersions returns the API versions to try for a call requesting
+// version, in order.
+func fallbackAPIVersions(version meta.Version) []meta.Version {
+	switch version {
+	case meta.VersionAlpha:
+		return []meta.Version{meta.VersionAlpha, meta.VersionBeta, meta.VersionGA}
+	case meta.VersionBeta:
+		return []meta.Version{meta.VersionBeta, meta.VersionGA}
+	default:
+		return []meta.Version{meta.VersionGA}
+	}
+}
+
+// apiVersionNegotiator records the Compute API versions which are not served,
+// e.g. by a custom API endpoint, so that the calls fall back to a lower
+// version without trying them again.
+type apiVersionNegotiator struct {
+	lock        sync.Mutex
+	unavailable map[meta.Version]bool
+}
+
+func (n *apiVersionNegotiator) available(version meta.Version) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return !n.unavailable[version]
+}
+
+func (n *apiVersionNegotiator) markUnavailable(version meta.Version) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.unavailable == nil {
+		n.unavailable = map[meta.Version]bool{}
+	}
+	n.unavailable[version] = true
+}
+
+// callVersioned runs the implementation of call for the requested version,
+// falling back to the lower versions which are implemented when the version
+// is not available. It returns the version which ran.
+func (g *Cloud) callVersioned(version meta.Version, call versionedCall) (meta.Version, error) {
+	for _, v := range fallbackAPIVersions(version) {
+		fn := call.implementation(v)
+		if fn == nil || (v != meta.VersionGA && !g.apiVersions.available(v)) {
+			continue
+		}
+		err := fn()
+		if v != meta.VersionGA && isAPIVersionUnavailable(err) {
+			klog.Warningf("%s: Compute API version %s is not available, falling back to a lower version: %v", call.name, v, err)
+			g.apiVersions.markUnavailable(v)
+			continue
+		}
+		return v, err
+	}
+	return "", fmt.Errorf("%s: no implementation available for Compute API version %s", call.name, version)
+}
+
+// isAPIVersionUnavailable returns true for the errors of the endpoints which
+// do not serve an API version. Unlike missing resources, such errors have no
+// details since the response is not returned by the Compute API.
+func isAPIVersionUnavailable(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound && apiErr.Message == "" && len(apiErr.Errors) == 0
+}
+
+// toBetaForwardingRule converts a GA forwarding rule to the beta API, to set
+// the fields only available in beta.
+func toBetaForwardingRule(rule *compute.ForwardingRule) (*computebeta.ForwardingRule, error) {
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	betaRule := &computebeta.ForwardingRule{}
+	if err := json.Unmarshal(b, betaRule); err != nil {
+		return nil, err
+	}
+	return betaRule, nil
+}
@@ -0,0 +1,116 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCallVersioned(t *testing.T) {
+	unavailableErr := &googleapi.Error{Code: http.StatusNotFound, Body: "<html>Not Found</html>"}
+	notFoundErr := makeGoogleAPINotFoundError("resource not found")
+
+	for _, tc := range []struct {
+		desc            string
+		version         meta.Version
+		betaErr         error
+		noBeta          bool
+		expectedVersion meta.Version
+		expectedErr     error
+	}{
+		{
+			desc:            "GA requested",
+			version:         meta.VersionGA,
+			expectedVersion: meta.VersionGA,
+		},
+		{
+			desc:            "Beta requested",
+			version:         meta.VersionBeta,
+			expectedVersion: meta.VersionBeta,
+		},
+		{
+			desc:            "Alpha falls back to the beta implementation",
+			version:         meta.VersionAlpha,
+			expectedVersion: meta.VersionBeta,
+		},
+		{
+			desc:            "Missing beta implementation falls back to GA",
+			version:         meta.VersionBeta,
+			noBeta:          true,
+			expectedVersion: meta.VersionGA,
+		},
+		{
+			desc:            "Unavailable beta API falls back to GA",
+			version:         meta.VersionBeta,
+			betaErr:         unavailableErr,
+			expectedVersion: meta.VersionGA,
+		},
+		{
+			desc:            "Beta errors are returned",
+			version:         meta.VersionBeta,
+			betaErr:         notFoundErr,
+			expectedVersion: meta.VersionBeta,
+			expectedErr:     notFoundErr,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := &Cloud{}
+			call := versionedCall{
+				name: "test",
+				ga:   func() error { return nil },
+				beta: func() error { return tc.betaErr },
+			}
+			if tc.noBeta {
+				call.beta = nil
+			}
+			version, err := g.callVersioned(tc.version, call)
+			assert.Equal(t, tc.expectedVersion, version)
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func TestCallVersionedNegotiation(t *testing.T) {
+	g := &Cloud{}
+	betaCalls := 0
+	call := versionedCall{
+		name: "test",
+		ga:   func() error { return nil },
+		beta: func() error {
+			betaCalls++
+			return &googleapi.Error{Code: http.StatusNotFound}
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		version, err := g.callVersioned(meta.VersionBeta, call)
+		assert.NoError(t, err)
+		assert.Equal(t, meta.VersionGA, version)
+	}
+	assert.Equal(t, 1, betaCalls, "the unavailable beta API is not called again")
+
+	_, err := g.callVersioned(meta.VersionBeta, versionedCall{name: "test", beta: call.beta})
+	assert.Error(t, err, "calls without a GA implementation fail")
+}
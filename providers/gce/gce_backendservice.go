@@ -236,3 +236,18 @@ func (g *Cloud) SetSecurityPolicyForAlphaGlobalBackendService(backendServiceName
 	mc := newBackendServiceMetricContextWithVersion("set_security_policy", "", computeAlphaVersion)
 	return mc.Observe(g.c.AlphaBackendServices().SetSecurityPolicy(ctx, meta.GlobalKey(backendServiceName), securityPolicyReference))
 }
+
+// SetSecurityPolicyForRegionBackendService sets the security policy link
+// securityPolicy on the regional BackendService identified by the given name,
+// detaching its security policy if securityPolicy is empty. k8s-cloud-provider
+// does not implement the call for the regional backend services.
+func (g *Cloud) SetSecurityPolicyForRegionBackendService(name, region, securityPolicy string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newBackendServiceMetricContext("set_security_policy", region)
+	ref := &compute.SecurityPolicyReference{SecurityPolicy: securityPolicy}
+	return mc.Observe(g.doComputeOperation(ctx, "RegionBackendServices", "SetSecurityPolicy", func(projectID string) (*compute.Operation, error) {
+		return g.s.GA.RegionBackendServices.SetSecurityPolicy(projectID, region, name, ref).Context(ctx).Do()
+	}))
+}
@@ -0,0 +1,101 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// backendServiceField is a backend service setting which the provider does not
+// manage, but which users may enable outside of Kubernetes.
+type backendServiceField struct {
+	// isSet returns true if the setting is enabled on the backend service.
+	isSet func(bs *compute.BackendService) bool
+	// copy copies the setting from one backend service to another.
+	copy func(from, to *compute.BackendService)
+}
+
+// guardedBackendServiceFields are the settings which are either preserved,
+// when listed in ServiceAnnotationILBPreservedBackendServiceFields, or
+// reverted by the provider. Backend services are updated as a whole, so they
+// would otherwise be silently cleared on the next update. The security policy
+// is not one of them: the updates neither clear nor revert it, it is only set
+// with SetSecurityPolicy.
+var guardedBackendServiceFields = map[string]backendServiceField{
+	"iap": {
+		isSet: func(bs *compute.BackendService) bool { return bs.Iap != nil && bs.Iap.Enabled },
+		copy:  func(from, to *compute.BackendService) { to.Iap = from.Iap },
+	},
+	"cdn": {
+		isSet: func(bs *compute.BackendService) bool { return bs.EnableCDN || bs.CdnPolicy != nil },
+		copy: func(from, to *compute.BackendService) {
+			to.EnableCDN = from.EnableCDN
+			to.CdnPolicy = from.CdnPolicy
+		},
+	},
+	"securitySettings": {
+		isSet: func(bs *compute.BackendService) bool { return bs.SecuritySettings != nil },
+		copy:  func(from, to *compute.BackendService) { to.SecuritySettings = from.SecuritySettings },
+	},
+}
+
+func guardedBackendServiceFieldNames() []string {
+	var names []string
+	for name := range guardedBackendServiceFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reconcileBackendServiceFields copies the guarded settings of the existing
+// backend service listed in preserved onto expected. It returns the other
+// guarded settings enabled on the existing backend service, which are reverted
+// by updating it to expected. The settings listed in managed are set on
+// expected by the provider, they are neither preserved nor reverted.
+func reconcileBackendServiceFields(existing, expected *compute.BackendService, preserved, managed sets.String) []string {
+	var reverted []string
+	for _, name := range guardedBackendServiceFieldNames() {
+		field := guardedBackendServiceFields[name]
+		if managed.Has(name) || !field.isSet(existing) {
+			continue
+		}
+		if preserved.Has(name) {
+			field.copy(existing, expected)
+			continue
+		}
+		reverted = append(reverted, name)
+	}
+	return reverted
+}
+
+func (g *Cloud) raiseBackendServiceFieldsRevertedEvent(svc *v1.Service, name string, reverted []string) {
+	msg := fmt.Sprintf("Reverted settings %s of backend service %s which are not managed by Kubernetes. List them in annotation %q to keep them.",
+		strings.Join(reverted, ","), name, ServiceAnnotationILBPreservedBackendServiceFields)
+	if g.eventRecorder != nil && svc != nil {
+		g.eventRecorder.Event(svc, v1.EventTypeWarning, "BackendServiceSettingsReverted", msg)
+	}
+}
@@ -0,0 +1,278 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ConfigReferenceKindConfigMap and ConfigReferenceKindSecret are the
+	// prefixes of a --cloud-config value referencing an in-cluster object,
+	// e.g. "secret:kube-system/gce-config".
+	ConfigReferenceKindConfigMap = "configmap"
+	ConfigReferenceKindSecret    = "secret"
+
+	// ConfigReferenceConfigKey is the key holding the gce.conf contents in
+	// the referenced object.
+	ConfigReferenceConfigKey = "gce.conf"
+	// ConfigReferenceCredentialsKey is the key holding an optional service
+	// account key in a referenced Secret. When set, it is used instead of the
+	// token source configured in gce.conf.
+	ConfigReferenceCredentialsKey = "credentials.json"
+)
+
+// ConfigReference points at a ConfigMap or Secret holding the provider config.
+type ConfigReference struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (r *ConfigReference) String() string {
+	return fmt.Sprintf("%s:%s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// ParseConfigReference parses a --cloud-config value of the form
+// "configmap:<namespace>/<name>" or "secret:<namespace>/<name>". It returns
+// false if the value does not reference an in-cluster object, e.g. because it
+// is a file path.
+func ParseConfigReference(s string) (*ConfigReference, bool, error) {
+	kind, ref, found := strings.Cut(s, ":")
+	if !found || (kind != ConfigReferenceKindConfigMap && kind != ConfigReferenceKindSecret) {
+		return nil, false, nil
+	}
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return nil, true, fmt.Errorf("invalid cloud config reference %q, want %s:<namespace>/<name>", s, kind)
+	}
+	return &ConfigReference{Kind: kind, Namespace: namespace, Name: name}, true, nil
+}
+
+// configReferenceData is the contents of a referenced config object.
+type configReferenceData struct {
+	config      []byte
+	credentials []byte
+}
+
+// dataFromObject extracts the config from a ConfigMap or Secret.
+func (r *ConfigReference) dataFromObject(obj interface{}) (*configReferenceData, error) {
+	switch o := obj.(type) {
+	case *v1.ConfigMap:
+		config, ok := o.Data[ConfigReferenceConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("%v has no %q key", r, ConfigReferenceConfigKey)
+		}
+		return &configReferenceData{config: []byte(config)}, nil
+	case *v1.Secret:
+		config, ok := o.Data[ConfigReferenceConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("%v has no %q key", r, ConfigReferenceConfigKey)
+		}
+		return &configReferenceData{config: config, credentials: o.Data[ConfigReferenceCredentialsKey]}, nil
+	}
+	return nil, fmt.Errorf("unexpected object %T for %v", obj, r)
+}
+
+func (r *ConfigReference) get(ctx context.Context, client clientset.Interface) (*configReferenceData, error) {
+	var obj interface{}
+	var err error
+	if r.Kind == ConfigReferenceKindSecret {
+		obj, err = client.CoreV1().Secrets(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+	} else {
+		obj, err = client.CoreV1().ConfigMaps(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %v: %w", r, err)
+	}
+	return r.dataFromObject(obj)
+}
+
+// rotatingTokenSource is a token source whose underlying source is swapped when
+// the credentials in the referenced config change. Every GCE client of the
+// Cloud shares it, so rotating it rotates the credentials of all of them.
+type rotatingTokenSource struct {
+	lock   sync.RWMutex
+	source oauth2.TokenSource
+}
+
+// Token implements oauth2.TokenSource.
+func (r *rotatingTokenSource) Token() (*oauth2.Token, error) {
+	r.lock.RLock()
+	source := r.source
+	r.lock.RUnlock()
+	return source.Token()
+}
+
+func (r *rotatingTokenSource) set(source oauth2.TokenSource) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.source = oauth2.ReuseTokenSource(nil, source)
+}
+
+// configTokenSource returns the token source for the given config, preferring
+// the service account key if one is set.
+func configTokenSource(configFile *ConfigFile, credentials []byte) (oauth2.TokenSource, error) {
+	if len(credentials) != 0 {
+		creds, err := google.CredentialsFromJSON(context.Background(), credentials, compute.CloudPlatformScope, compute.ComputeScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", ConfigReferenceCredentialsKey, err)
+		}
+		return creds.TokenSource, nil
+	}
+	if configFile.Global.TokenURL == "" {
+		return google.ComputeTokenSource(""), nil
+	}
+	// The clients of the Cloud share the rotating token source, which has
+	// to wrap a token source instead of leaving the fallback to the OAuth
+	// client.
+	if source := configFileTokenSource(configFile); source != nil {
+		return source, nil
+	}
+	return google.DefaultTokenSource(context.Background(), compute.CloudPlatformScope, compute.ComputeScope)
+}
+
+// onlyCredentialsChanged returns true if the configs only differ in the fields
+// configuring the token source, which can be applied without a restart.
+func onlyCredentialsChanged(a, b *ConfigFile) bool {
+	ac, bc := *a, *b
+	ac.Global.TokenURL, ac.Global.TokenBody = "", ""
+	bc.Global.TokenURL, bc.Global.TokenBody = "", ""
+	return reflect.DeepEqual(ac, bc)
+}
+
+// NewCloudFromConfigReference creates a Cloud from the config held by a
+// ConfigMap or Secret, and watches the object until ctx is done. Changes to
+// the credentials are applied to the existing clients; any other change is
+// logged, as it only takes effect after a restart.
+func NewCloudFromConfigReference(ctx context.Context, client clientset.Interface, ref *ConfigReference) (*Cloud, error) {
+	data, err := ref.get(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	configFile, err := readConfig(bytes.NewReader(data.config))
+	if err != nil {
+		return nil, err
+	}
+	// The config is not logged, its token-body can hold a credential.
+	klog.Infof("Using GCE provider config from %v", ref)
+
+	source, err := configTokenSource(configFile, data.credentials)
+	if err != nil {
+		return nil, err
+	}
+	ts := &rotatingTokenSource{}
+	ts.set(source)
+
+	cloudConfig, err := generateCloudConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cloudConfig.TokenSource = ts
+	gce, err := CreateGCECloud(cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &configReferenceWatcher{ref: ref, tokenSource: ts, configFile: configFile, data: data}
+	w.run(ctx, client)
+	return gce, nil
+}
+
+// configReferenceWatcher applies the changes of a referenced config object.
+type configReferenceWatcher struct {
+	ref         *ConfigReference
+	tokenSource *rotatingTokenSource
+
+	// configFile and data are the config last applied.
+	lock       sync.Mutex
+	configFile *ConfigFile
+	data       *configReferenceData
+}
+
+func (w *configReferenceWatcher) run(ctx context.Context, client clientset.Interface) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(w.ref.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.ref.Name).String()
+		}))
+	var informer cache.SharedIndexInformer
+	if w.ref.Kind == ConfigReferenceKindSecret {
+		informer = factory.Core().V1().Secrets().Informer()
+	} else {
+		informer = factory.Core().V1().ConfigMaps().Informer()
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: w.update,
+		UpdateFunc: func(_, obj interface{}) {
+			w.update(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			klog.Warningf("Cloud config %v was deleted, keeping the last config", w.ref)
+		},
+	})
+	factory.Start(ctx.Done())
+}
+
+func (w *configReferenceWatcher) update(obj interface{}) {
+	data, err := w.ref.dataFromObject(obj)
+	if err != nil {
+		klog.Errorf("Ignoring change to cloud config: %v", err)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if bytes.Equal(data.config, w.data.config) && bytes.Equal(data.credentials, w.data.credentials) {
+		return
+	}
+	configFile, err := readConfig(bytes.NewReader(data.config))
+	if err != nil {
+		klog.Errorf("Ignoring change to cloud config %v: %v", w.ref, err)
+		return
+	}
+	if !onlyCredentialsChanged(w.configFile, configFile) {
+		klog.Warningf("Cloud config %v changed, changes other than credentials take effect after a restart", w.ref)
+	}
+	source, err := configTokenSource(configFile, data.credentials)
+	if err != nil {
+		klog.Errorf("Ignoring change to cloud config %v: %v", w.ref, err)
+		return
+	}
+	w.tokenSource.set(source)
+	w.configFile, w.data = configFile, data
+	klog.Infof("Rotated the credentials of cloud config %v", w.ref)
+}
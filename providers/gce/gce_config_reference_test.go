@@ -0,0 +1,151 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseConfigReference(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    *ConfigReference
+		wantRef bool
+		wantErr bool
+	}{
+		{in: "/etc/gce.conf"},
+		{in: ""},
+		{in: "other:kube-system/gce"},
+		{
+			in:      "secret:kube-system/gce-config",
+			want:    &ConfigReference{Kind: ConfigReferenceKindSecret, Namespace: "kube-system", Name: "gce-config"},
+			wantRef: true,
+		},
+		{
+			in:      "configmap:kube-system/gce-config",
+			want:    &ConfigReference{Kind: ConfigReferenceKindConfigMap, Namespace: "kube-system", Name: "gce-config"},
+			wantRef: true,
+		},
+		{in: "secret:gce-config", wantRef: true, wantErr: true},
+		{in: "secret:/gce-config", wantRef: true, wantErr: true},
+		{in: "configmap:kube-system/", wantRef: true, wantErr: true},
+		{in: "configmap:kube-system/a/b", wantRef: true, wantErr: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			got, gotRef, err := ParseConfigReference(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseConfigReference(%q) = %v, want error: %v", tc.in, err, tc.wantErr)
+			}
+			if gotRef != tc.wantRef || !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseConfigReference(%q) = %v, %v, want %v, %v", tc.in, got, gotRef, tc.want, tc.wantRef)
+			}
+		})
+	}
+}
+
+func TestOnlyCredentialsChanged(t *testing.T) {
+	base := &ConfigFile{}
+	base.Global.ProjectID = "my-project"
+	base.Global.TokenURL = "https://token"
+
+	tokenChange := *base
+	tokenChange.Global.TokenURL = "https://other-token"
+	tokenChange.Global.TokenBody = "body"
+	if !onlyCredentialsChanged(base, &tokenChange) {
+		t.Errorf("onlyCredentialsChanged() = false for token URL change, want true")
+	}
+
+	projectChange := *base
+	projectChange.Global.ProjectID = "other-project"
+	if onlyCredentialsChanged(base, &projectChange) {
+		t.Errorf("onlyCredentialsChanged() = true for project change, want false")
+	}
+}
+
+func TestConfigReferenceWatcherRotatesCredentials(t *testing.T) {
+	const (
+		config = `[Global]
+project-id = my-project
+token-url = https://token
+`
+		credentials = `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`
+	)
+	ref := &ConfigReference{Kind: ConfigReferenceKindSecret, Namespace: "kube-system", Name: "gce-config"}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name},
+		Data:       map[string][]byte{ConfigReferenceConfigKey: []byte(config)},
+	}
+	client := fake.NewSimpleClientset(secret)
+
+	data, err := ref.get(context.Background(), client)
+	if err != nil {
+		t.Fatalf("ref.get() = %v", err)
+	}
+	configFile, err := readConfig(bytes.NewReader(data.config))
+	if err != nil {
+		t.Fatalf("readConfig() = %v", err)
+	}
+	source, err := configTokenSource(configFile, data.credentials)
+	if err != nil {
+		t.Fatalf("configTokenSource() = %v", err)
+	}
+	ts := &rotatingTokenSource{}
+	ts.set(source)
+	initial := ts.source
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &configReferenceWatcher{ref: ref, tokenSource: ts, configFile: configFile, data: data}
+	w.run(ctx, client)
+
+	rotated := secret.DeepCopy()
+	rotated.Data[ConfigReferenceCredentialsKey] = []byte(credentials)
+	if _, err := client.CoreV1().Secrets(ref.Namespace).Update(ctx, rotated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		return string(w.data.credentials) == credentials, nil
+	}); err != nil {
+		t.Fatalf("credentials were not rotated: %v", err)
+	}
+	ts.lock.RLock()
+	current := ts.source
+	ts.lock.RUnlock()
+	if current == initial {
+		t.Errorf("token source was not rotated")
+	}
+
+	// An invalid change is ignored.
+	w.update(&v1.Secret{Data: map[string][]byte{ConfigReferenceConfigKey: []byte(config), ConfigReferenceCredentialsKey: []byte("{")}})
+	if string(w.data.credentials) != credentials {
+		t.Errorf("invalid credentials were applied")
+	}
+}
@@ -552,7 +552,7 @@ func (g *Cloud) getDiskByNameAndOptionalLabelZones(name, labelZone string) (*Dis
 // AttachDisk attaches given disk to the node with the specified NodeName.
 // Current instance is used when instanceID is empty string.
 func (g *Cloud) AttachDisk(diskName string, nodeName types.NodeName, readOnly bool, regional bool) error {
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	instance, err := g.getInstanceByName(instanceName)
 	if err != nil {
 		return fmt.Errorf("error getting instance %q", instanceName)
@@ -586,7 +586,7 @@ func (g *Cloud) AttachDisk(diskName string, nodeName types.NodeName, readOnly bo
 // DetachDisk detaches given disk to the node with the specified NodeName.
 // Current instance is used when nodeName is empty string.
 func (g *Cloud) DetachDisk(devicePath string, nodeName types.NodeName) error {
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	inst, err := g.getInstanceByName(instanceName)
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
@@ -607,7 +607,7 @@ func (g *Cloud) DetachDisk(devicePath string, nodeName types.NodeName) error {
 
 // DiskIsAttached checks if a disk is attached to the node with the specified NodeName.
 func (g *Cloud) DiskIsAttached(diskName string, nodeName types.NodeName) (bool, error) {
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	instance, err := g.getInstanceByName(instanceName)
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
@@ -639,7 +639,7 @@ func (g *Cloud) DisksAreAttached(diskNames []string, nodeName types.NodeName) (m
 	for _, diskName := range diskNames {
 		attached[diskName] = false
 	}
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	instance, err := g.getInstanceByName(instanceName)
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
@@ -671,7 +671,7 @@ func (g *Cloud) DisksAreAttached(diskNames []string, nodeName types.NodeName) (m
 func (g *Cloud) BulkDisksAreAttached(diskByNodes map[types.NodeName][]string) (map[types.NodeName]map[string]bool, error) {
 	instanceNames := []string{}
 	for nodeName := range diskByNodes {
-		instanceNames = append(instanceNames, mapNodeNameToInstanceName(nodeName))
+		instanceNames = append(instanceNames, MapNodeNameToInstanceName(nodeName))
 	}
 
 	// List all instances with the given instance names
@@ -689,7 +689,7 @@ func (g *Cloud) BulkDisksAreAttached(diskByNodes map[types.NodeName][]string) (m
 
 	// For each node and its desired attached disks that needs to be verified
 	for nodeName, disksToVerify := range diskByNodes {
-		instanceName := canonicalizeInstanceName(mapNodeNameToInstanceName(nodeName))
+		instanceName := MapNodeNameToInstanceName(nodeName)
 		disksActuallyAttached := listedInstanceNamesToDisks[instanceName]
 		verifyDisksAttached[nodeName] = verifyDisksAttachedToNode(disksToVerify, disksActuallyAttached)
 	}
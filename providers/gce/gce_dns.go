@@ -0,0 +1,73 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+
+	dnsapi "google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+// newDNSService returns a new Cloud DNS service using the client to
+// communicate with the Cloud DNS API.
+func newDNSService(client *http.Client, userAgent string) (*dnsapi.Service, error) {
+	s, err := dnsapi.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	s.UserAgent = userAgent
+	return s, nil
+}
+
+// GetDNSManagedZone returns the Cloud DNS managed zone of the project.
+func (g *Cloud) GetDNSManagedZone(ctx context.Context, project, managedZone string) (*dnsapi.ManagedZone, error) {
+	mc := newDNSMetricContext("get_managed_zone")
+	zone, err := g.dnsService.ManagedZones.Get(project, managedZone).Context(ctx).Do()
+	return zone, mc.Observe(err)
+}
+
+// ListDNSRecordSets returns the record sets of the Cloud DNS managed zone of
+// the project with the DNS name, of all types.
+func (g *Cloud) ListDNSRecordSets(ctx context.Context, project, managedZone, name string) ([]*dnsapi.ResourceRecordSet, error) {
+	mc := newDNSMetricContext("list_record_sets")
+	var rrsets []*dnsapi.ResourceRecordSet
+	err := g.dnsService.ResourceRecordSets.List(project, managedZone).Name(name).Pages(ctx, func(page *dnsapi.ResourceRecordSetsListResponse) error {
+		rrsets = append(rrsets, page.Rrsets...)
+		return nil
+	})
+	return rrsets, mc.Observe(err)
+}
+
+// DeleteDNSRecordSet deletes the record set of the Cloud DNS managed zone of
+// the project with the DNS name and type. A record set which does not exist
+// is not an error.
+func (g *Cloud) DeleteDNSRecordSet(ctx context.Context, project, managedZone, name, recordType string) error {
+	mc := newDNSMetricContext("delete_record_set")
+	_, err := g.dnsService.ResourceRecordSets.Delete(project, managedZone, name, recordType).Context(ctx).Do()
+	return mc.Observe(ignoreNotFound(err))
+}
+
+// newDNSMetricContext returns a new metricContext used for recording metrics
+// of Cloud DNS API calls.
+func newDNSMetricContext(request string) *metricContext {
+	return newGenericMetricContext("dns", request, unusedMetricLabel, unusedMetricLabel, "v1")
+}
@@ -24,8 +24,10 @@ import (
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	compute "google.golang.org/api/compute/v1"
+	dnsapi "google.golang.org/api/dns/v1"
 	option "google.golang.org/api/option"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
 )
 
 // TestClusterValues holds the config values for the fake/test gce cloud object.
@@ -87,6 +89,8 @@ func NewFakeGCECloud(vals TestClusterValues) *Cloud {
 		projectsBasePath: getProjectsBasePath(service.BasePath),
 		regional:         vals.Regional,
 		networkURL:       vals.NetworkURL,
+		routeOps:         routeOperations{clock: clock.RealClock{}},
+		clock:            clock.RealClock{},
 	}
 	c := cloud.NewMockGCE(&gceProjectRouter{gce})
 	gce.c = c
@@ -102,3 +106,28 @@ func UpdateFakeGCECloud(g *Cloud, vals TestClusterValues) {
 func SetFakeStackType(g *Cloud, stackType StackType) {
 	g.stackType = stackType
 }
+
+// SetFakeDNSEndpoint makes the fake GCE cloud call the Cloud DNS API at the
+// endpoint.
+func SetFakeDNSEndpoint(g *Cloud, endpoint string) {
+	s, err := dnsapi.NewService(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(endpoint))
+	if err != nil {
+		panic(err)
+	}
+	g.dnsService = s
+}
+
+// SetFakeComputeEndpoint makes the fake GCE cloud call the compute API at the
+// endpoint, for the calls which are not implemented by the mocks.
+func SetFakeComputeEndpoint(g *Cloud, endpoint string) {
+	s, err := compute.NewService(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(endpoint))
+	if err != nil {
+		panic(err)
+	}
+	g.service = s
+	g.s = &cloud.Service{
+		GA:            s,
+		ProjectRouter: &gceProjectRouter{g},
+		RateLimiter:   &cloud.NopRateLimiter{},
+	}
+}
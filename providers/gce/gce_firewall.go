@@ -36,7 +36,10 @@ func (g *Cloud) GetFirewall(name string) (*compute.Firewall, error) {
 	defer cancel()
 
 	mc := newFirewallMetricContext("get")
-	v, err := g.c.Firewalls().Get(ctx, meta.GlobalKey(name))
+	v, err := g.firewalls().Get(ctx, meta.GlobalKey(name))
+	if err == nil && g.managesXPNFirewalls() {
+		v = g.fromHostFirewall(v)
+	}
 	return v, mc.Observe(err)
 }
 
@@ -46,7 +49,10 @@ func (g *Cloud) CreateFirewall(f *compute.Firewall) error {
 	defer cancel()
 
 	mc := newFirewallMetricContext("create")
-	return mc.Observe(g.c.Firewalls().Insert(ctx, meta.GlobalKey(f.Name), f))
+	if g.managesXPNFirewalls() {
+		f = g.toHostFirewall(f)
+	}
+	return mc.Observe(g.firewalls().Insert(ctx, meta.GlobalKey(f.Name), f))
 }
 
 // DeleteFirewall deletes the given firewall rule.
@@ -55,7 +61,12 @@ func (g *Cloud) DeleteFirewall(name string) error {
 	defer cancel()
 
 	mc := newFirewallMetricContext("delete")
-	return mc.Observe(g.c.Firewalls().Delete(ctx, meta.GlobalKey(name)))
+	if g.managesXPNFirewalls() {
+		if err := g.ensureXPNFirewallOwned(ctx, name); err != nil {
+			return mc.Observe(err)
+		}
+	}
+	return mc.Observe(g.firewalls().Delete(ctx, meta.GlobalKey(name)))
 }
 
 // UpdateFirewall applies the given firewall as an update to an existing service.
@@ -64,7 +75,13 @@ func (g *Cloud) UpdateFirewall(f *compute.Firewall) error {
 	defer cancel()
 
 	mc := newFirewallMetricContext("update")
-	return mc.Observe(g.c.Firewalls().Update(ctx, meta.GlobalKey(f.Name), f))
+	if g.managesXPNFirewalls() {
+		if err := g.ensureXPNFirewallOwned(ctx, f.Name); err != nil {
+			return mc.Observe(err)
+		}
+		f = g.toHostFirewall(f)
+	}
+	return mc.Observe(g.firewalls().Update(ctx, meta.GlobalKey(f.Name), f))
 }
 
 // PatchFirewall applies the given firewall as an update to an existing service.
@@ -73,5 +90,11 @@ func (g *Cloud) PatchFirewall(f *compute.Firewall) error {
 	defer cancel()
 
 	mc := newFirewallMetricContext("Patch")
-	return mc.Observe(g.c.Firewalls().Patch(ctx, meta.GlobalKey(f.Name), f))
+	if g.managesXPNFirewalls() {
+		if err := g.ensureXPNFirewallOwned(ctx, f.Name); err != nil {
+			return mc.Observe(err)
+		}
+		f = g.toHostFirewall(f)
+	}
+	return mc.Observe(g.firewalls().Patch(ctx, meta.GlobalKey(f.Name), f))
 }
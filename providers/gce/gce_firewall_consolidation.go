@@ -0,0 +1,424 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+const (
+	// consolidatedFirewallPrefix is the name prefix of the firewall rules
+	// shared by the external load balancers.
+	consolidatedFirewallPrefix = "k8s-fw-shared-"
+
+	// maxFirewallPorts is the maximum number of ports and port ranges of a
+	// firewall rule.
+	maxFirewallPorts = 100
+)
+
+// EnableFirewallConsolidation stops the creation of a firewall rule per
+// external load balancer once its traffic is allowed by the rules of
+// EnsureConsolidatedFirewalls.
+func (g *Cloud) EnableFirewallConsolidation() {
+	g.firewallConsolidation.Store(true)
+}
+
+// FirewallConsolidationEnabled returns true if the external load balancers
+// share consolidated firewall rules.
+func (g *Cloud) FirewallConsolidationEnabled() bool {
+	return g.firewallConsolidation.Load()
+}
+
+// consolidatedFirewall is a firewall rule shared by the external load
+// balancers with the same source ranges and ports.
+type consolidatedFirewall struct {
+	sourceRanges []string
+	targetTags   []string
+	allowed      []*compute.FirewallAllowed
+	destinations sets.String
+	// loadBalancers are the names of the load balancers using the rule, which
+	// are its references.
+	loadBalancers sets.String
+}
+
+// consolidatedFirewallDescription is the description of consolidated
+// firewall rules, with the number of load balancers referencing them.
+type consolidatedFirewallDescription struct {
+	ClusterID  string `json:"kubernetes.io/cluster-id"`
+	References int    `json:"kubernetes.io/load-balancer-count"`
+}
+
+// consolidatesFirewall returns true if the firewall of the load balancer of
+// the Service is consolidated, i.e. for target pool based external load
+// balancers with an IPv4 address.
+func consolidatesFirewall(svc *v1.Service) bool {
+	return svc.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		svc.Spec.LoadBalancerClass == nil &&
+		svc.DeletionTimestamp == nil &&
+		getSvcScheme(svc) == cloud.SchemeExternal &&
+		!usesL4RBS(svc, nil) &&
+		loadBalancerIPv4(svc) != ""
+}
+
+// loadBalancerIPv4 returns the IPv4 address of the load balancer of the Service.
+func loadBalancerIPv4(svc *v1.Service) string {
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if netutils.IsIPv4String(ingress.IP) {
+			return ingress.IP
+		}
+	}
+	return ""
+}
+
+// consolidatedFirewallAllowed returns the protocols and ports allowed by the
+// consolidated firewall rule of the Service ports.
+func consolidatedFirewallAllowed(ports []v1.ServicePort) []*compute.FirewallAllowed {
+	byProtocol := map[string]sets.Int{}
+	for _, port := range ports {
+		protocol := strings.ToLower(string(port.Protocol))
+		if byProtocol[protocol] == nil {
+			byProtocol[protocol] = sets.NewInt()
+		}
+		byProtocol[protocol].Insert(int(port.Port))
+	}
+	protocols := make([]string, 0, len(byProtocol))
+	for protocol := range byProtocol {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	var allowed []*compute.FirewallAllowed
+	for _, protocol := range protocols {
+		allowed = append(allowed, &compute.FirewallAllowed{IPProtocol: protocol, Ports: consolidatePortRanges(byProtocol[protocol].List())})
+	}
+	return allowed
+}
+
+// consolidatedFirewallNamePrefix returns the name prefix of the consolidated
+// firewall rules of the cluster. The cluster ID is truncated so that the names
+// fit the 63 characters of GCE resource names.
+func consolidatedFirewallNamePrefix(clusterID string) string {
+	// The prefix is followed by a dash and the 16 characters of the hash.
+	const maxClusterIDLen = 63 - len(consolidatedFirewallPrefix) - 17
+	if len(clusterID) > maxClusterIDLen {
+		clusterID = clusterID[:maxClusterIDLen]
+	}
+	return consolidatedFirewallPrefix + clusterID
+}
+
+// makeConsolidatedFirewallName returns the name of the consolidated firewall
+// rule of the given source ranges and allowed ports. Only the load balancers
+// with the same ports share a rule, so that each is only reachable on its own
+// ports.
+func makeConsolidatedFirewallName(clusterID string, sourceRanges []string, allowed []*compute.FirewallAllowed) string {
+	key := clusterID + "/" + strings.Join(sourceRanges, ",") + "/" + strings.Join(firewallAllowedEntries(allowed).List(), ",")
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%x", consolidatedFirewallNamePrefix(clusterID), hash[:8])
+}
+
+// EnsureConsolidatedFirewalls ensures the firewall rules shared by the
+// external load balancers of the Services, grouped by source ranges and
+// ports. Each rule allows the ports of its load balancers, to their IPs. The
+// rules which are not referenced anymore are deleted, as well as the firewall
+// rule of each load balancer once covered by a shared rule. The load
+// balancers keep their own firewall rule until then.
+func (g *Cloud) EnsureConsolidatedFirewalls(ctx context.Context, services []*v1.Service, nodes []*v1.Node) error {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 && len(g.nodeTags) == 0 {
+		klog.V(2).Infof("EnsureConsolidatedFirewalls: no nodes, skipping")
+		return nil
+	}
+	targetTags, err := g.GetNodeTags(nodeNames(nodes))
+	if err != nil {
+		return err
+	}
+	targetTags = sets.NewString(targetTags...).List()
+
+	firewalls := map[string]*consolidatedFirewall{}
+	paused := sets.NewString()
+	for _, svc := range services {
+		if !consolidatesFirewall(svc) {
+			continue
+		}
+		// GCE firewalls cannot mix address families, as for the firewall
+		// rules of the load balancers.
+		ranges, err := ipv4SourceRanges(svc)
+		if err != nil {
+			klog.Warningf("EnsureConsolidatedFirewalls: ignoring Service %s/%s: %v", svc.Namespace, svc.Name, err)
+			continue
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+		sort.Strings(ranges)
+		allowed := consolidatedFirewallAllowed(svc.Spec.Ports)
+		name := makeConsolidatedFirewallName(clusterID, ranges, allowed)
+		fw, ok := firewalls[name]
+		if !ok {
+			fw = &consolidatedFirewall{
+				sourceRanges:  ranges,
+				targetTags:    targetTags,
+				allowed:       allowed,
+				destinations:  sets.NewString(),
+				loadBalancers: sets.NewString(),
+			}
+			firewalls[name] = fw
+		}
+		fw.destinations.Insert(loadBalancerIPv4(svc))
+		lbName := g.GetLoadBalancerName(ctx, "", svc)
+		fw.loadBalancers.Insert(lbName)
+		if IsServiceReconcilePaused(svc) {
+			paused.Insert(lbName)
+		}
+	}
+
+	// Only the firewall rules of the load balancers which still exist are
+	// deleted, most of them are deleted at a previous sync.
+	lbFirewalls, err := g.listLoadBalancerFirewallNames()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for name, fw := range firewalls {
+		if err := g.ensureConsolidatedFirewall(name, clusterID, fw); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// The firewall rules of the paused load balancers are kept.
+		for _, lbName := range fw.loadBalancers.Difference(paused).List() {
+			if !lbFirewalls.Has(MakeFirewallName(lbName)) {
+				continue
+			}
+			if err := ignoreNotFound(g.DeleteFirewall(MakeFirewallName(lbName))); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete the firewall rule of load balancer %s: %w", lbName, err))
+			}
+		}
+	}
+
+	existing, err := g.listConsolidatedFirewalls(clusterID)
+	if err != nil {
+		return err
+	}
+	for _, fw := range existing {
+		if _, ok := firewalls[fw.Name]; ok {
+			continue
+		}
+		klog.Infof("EnsureConsolidatedFirewalls: deleting unreferenced firewall rule %s", fw.Name)
+		if err := ignoreNotFound(g.DeleteFirewall(fw.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to ensure the consolidated firewall rules: %v", errs)
+	}
+	return nil
+}
+
+// ensureConsolidatedFirewall creates or updates the consolidated firewall rule.
+func (g *Cloud) ensureConsolidatedFirewall(name, clusterID string, fw *consolidatedFirewall) error {
+	desc, err := json.Marshal(consolidatedFirewallDescription{ClusterID: clusterID, References: fw.loadBalancers.Len()})
+	if err != nil {
+		return err
+	}
+	expected := &compute.Firewall{
+		Name:              name,
+		Description:       string(desc),
+		Network:           g.networkURL,
+		SourceRanges:      fw.sourceRanges,
+		DestinationRanges: fw.destinations.List(),
+		TargetTags:        fw.targetTags,
+		Allowed:           fw.allowed,
+	}
+	numPorts := 0
+	for _, allowed := range fw.allowed {
+		numPorts += len(allowed.Ports)
+	}
+	if numPorts > maxFirewallPorts {
+		// The load balancers keep their own firewall rules.
+		return fmt.Errorf("consolidated firewall rule %s would allow %d ports or port ranges, more than the maximum %d", name, numPorts, maxFirewallPorts)
+	}
+
+	existing, err := g.GetFirewall(name)
+	if isNotFound(err) {
+		klog.Infof("ensureConsolidatedFirewall: creating firewall rule %s for %d load balancers", name, fw.loadBalancers.Len())
+		return g.CreateFirewall(expected)
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Description == expected.Description &&
+		equalStringSets(existing.SourceRanges, expected.SourceRanges) &&
+		equalStringSets(existing.DestinationRanges, expected.DestinationRanges) &&
+		equalStringSets(existing.TargetTags, expected.TargetTags) &&
+		reflect.DeepEqual(existing.Allowed, expected.Allowed) {
+		return nil
+	}
+	klog.Infof("ensureConsolidatedFirewall: updating firewall rule %s for %d load balancers", name, fw.loadBalancers.Len())
+	return g.UpdateFirewall(expected)
+}
+
+// listConsolidatedFirewalls returns the consolidated firewall rules of the
+// cluster. The rules of the clusters with the same truncated cluster ID are
+// told apart by their description.
+func (g *Cloud) listConsolidatedFirewalls(clusterID string) ([]*compute.Firewall, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallMetricContext("list")
+	v, err := g.firewalls().List(ctx, filter.Regexp("name", consolidatedFirewallNamePrefix(clusterID)+"-.*"))
+	if err != nil {
+		return nil, mc.Observe(err)
+	}
+	var firewalls []*compute.Firewall
+	for _, fw := range v {
+		var desc consolidatedFirewallDescription
+		if err := json.Unmarshal([]byte(fw.Description), &desc); err == nil && desc.ClusterID != clusterID {
+			continue
+		}
+		if g.managesXPNFirewalls() {
+			fw = g.fromHostFirewall(fw)
+		}
+		firewalls = append(firewalls, fw)
+	}
+	return firewalls, mc.Observe(nil)
+}
+
+// consolidatedFirewallAllows returns true if a consolidated firewall rule
+// allows the traffic of the sourceRanges to the ports of svc at ipAddress.
+// The load balancer of svc keeps its own firewall rule until then.
+func (g *Cloud) consolidatedFirewallAllows(svc *v1.Service, sourceRanges []string, ipAddress string) (bool, error) {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return false, err
+	}
+	ranges := append([]string(nil), sourceRanges...)
+	sort.Strings(ranges)
+	fw, err := g.GetFirewall(makeConsolidatedFirewallName(clusterID, ranges, consolidatedFirewallAllowed(svc.Spec.Ports)))
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sets.NewString(fw.DestinationRanges...).Has(ipAddress), nil
+}
+
+// releaseConsolidatedFirewalls removes ipAddress from the consolidated
+// firewall rules left by a previous run of the firewall consolidation, now
+// disabled, once its load balancer has its own firewall rule again. The rules
+// are deleted with their last load balancer.
+func (g *Cloud) releaseConsolidatedFirewalls(ipAddress string) error {
+	if ipAddress == "" || g.consolidatedFirewallsReleased.Load() {
+		return nil
+	}
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return err
+	}
+	existing, err := g.listConsolidatedFirewalls(clusterID)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		g.consolidatedFirewallsReleased.Store(true)
+		return nil
+	}
+	var errs []error
+	for _, fw := range existing {
+		destinations := sets.NewString(fw.DestinationRanges...)
+		if !destinations.Has(ipAddress) {
+			continue
+		}
+		destinations.Delete(ipAddress)
+		if destinations.Len() == 0 {
+			klog.Infof("releaseConsolidatedFirewalls: deleting firewall rule %s of the last load balancer %s", fw.Name, ipAddress)
+			if err := ignoreNotFound(g.DeleteFirewall(fw.Name)); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		desc, err := json.Marshal(consolidatedFirewallDescription{ClusterID: clusterID, References: destinations.Len()})
+		if err != nil {
+			return err
+		}
+		fw.Description = string(desc)
+		fw.DestinationRanges = destinations.List()
+		klog.Infof("releaseConsolidatedFirewalls: removing load balancer %s from firewall rule %s", ipAddress, fw.Name)
+		if err := g.UpdateFirewall(fw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// listLoadBalancerFirewallNames returns the names of the firewall rules of
+// the load balancers, named by MakeFirewallName after their load balancer.
+func (g *Cloud) listLoadBalancerFirewallNames() (sets.String, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newFirewallMetricContext("list")
+	v, err := g.firewalls().List(ctx, filter.Regexp("name", MakeFirewallName("a[a-z0-9]+")))
+	if err != nil {
+		return nil, mc.Observe(err)
+	}
+	names := sets.NewString()
+	for _, fw := range v {
+		names.Insert(fw.Name)
+	}
+	return names, mc.Observe(nil)
+}
+
+// consolidatePortRanges returns the sorted ports as ranges of consecutive
+// ports, e.g. [80, 443, 444] as ["80", "443-444"].
+func consolidatePortRanges(ports []int) []string {
+	var ranges []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(ports[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		}
+		i = j + 1
+	}
+	return ranges
+}
@@ -0,0 +1,217 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestConsolidatePortRanges(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		ports []int
+		want  []string
+	}{
+		{ports: nil, want: nil},
+		{ports: []int{80}, want: []string{"80"}},
+		{ports: []int{80, 81, 82, 443, 8080, 8081}, want: []string{"80-82", "443", "8080-8081"}},
+	} {
+		assert.Equal(t, tc.want, consolidatePortRanges(tc.ports), "consolidatePortRanges(%v)", tc.ports)
+	}
+}
+
+// consolidatedFirewallService returns an external LoadBalancer Service with
+// the given IP and ports.
+func consolidatedFirewallService(name, ip string, protocol v1.Protocol, ports ...int32) *v1.Service {
+	svc := fakeLoadbalancerService("")
+	svc.Name = name
+	svc.UID = types.UID("uid-" + name)
+	svc.Spec.Ports = nil
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: protocol, Port: port})
+	}
+	if ip != "" {
+		svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: ip}}
+	}
+	return svc
+}
+
+func TestMakeConsolidatedFirewallName(t *testing.T) {
+	t.Parallel()
+
+	allowed := []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"80"}}}
+	ranges := []string{"0.0.0.0/0"}
+	longID := strings.Repeat("a", 60)
+	name := makeConsolidatedFirewallName(longID, ranges, allowed)
+	assert.LessOrEqual(t, len(name), 63)
+	assert.True(t, strings.HasPrefix(name, consolidatedFirewallNamePrefix(longID)+"-"))
+	assert.NotEqual(t, name, makeConsolidatedFirewallName(longID+"b", ranges, allowed), "the hash should cover the truncated cluster ID")
+	assert.NotEqual(t, name, makeConsolidatedFirewallName(longID, ranges, []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"443"}}}), "the load balancers with different ports should not share a rule")
+}
+
+func TestEnsureConsolidatedFirewalls(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.nodeTags = []string{"test-node-tag"}
+	nodes := []*v1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "test-node-1"}}}
+
+	svcA := consolidatedFirewallService("svc-a", "1.1.1.1", v1.ProtocolTCP, 80)
+	svcB := consolidatedFirewallService("svc-b", "1.1.1.2", v1.ProtocolTCP, 81, 443)
+	svcC := consolidatedFirewallService("svc-c", "1.1.1.3", v1.ProtocolUDP, 53)
+	svcC.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	svcD := consolidatedFirewallService("svc-d", "1.1.1.4", v1.ProtocolTCP, 80)
+	pending := consolidatedFirewallService("pending", "", v1.ProtocolTCP, 8080)
+	internal := consolidatedFirewallService("internal", "10.1.1.1", v1.ProtocolTCP, 8080)
+	internal.Annotations[ServiceAnnotationLoadBalancerType] = string(LBTypeInternal)
+
+	// The firewall rule of svc-a predates the consolidation.
+	dedicatedName := MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svcA))
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: dedicatedName}))
+	staleName := consolidatedFirewallPrefix + vals.ClusterID + "-stale"
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: staleName}))
+	// The rule of another cluster whose ID has the same prefix is kept.
+	otherName := consolidatedFirewallPrefix + vals.ClusterID + "-other"
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: otherName, Description: `{"kubernetes.io/cluster-id":"test-cluster-id-other","kubernetes.io/load-balancer-count":1}`}))
+
+	require.NoError(t, gce.EnsureConsolidatedFirewalls(context.TODO(), []*v1.Service{svcA, svcB, svcC, svcD, pending, internal}, nodes))
+
+	http := []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"80"}}}
+	httpName := makeConsolidatedFirewallName(vals.ClusterID, []string{"0.0.0.0/0"}, http)
+	fw, err := gce.GetFirewall(httpName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.0.0.0/0"}, fw.SourceRanges)
+	assert.Equal(t, []string{"1.1.1.1", "1.1.1.4"}, fw.DestinationRanges)
+	assert.Equal(t, []string{"test-node-tag"}, fw.TargetTags)
+	assert.Equal(t, http, fw.Allowed)
+	assert.Equal(t, `{"kubernetes.io/cluster-id":"test-cluster-id","kubernetes.io/load-balancer-count":2}`, fw.Description)
+
+	// The load balancers with other ports get another rule, so that svc-a is
+	// not reachable on the ports of svc-b.
+	otherPorts := []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"81", "443"}}}
+	otherPortsName := makeConsolidatedFirewallName(vals.ClusterID, []string{"0.0.0.0/0"}, otherPorts)
+	fw, err = gce.GetFirewall(otherPortsName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.2"}, fw.DestinationRanges)
+	assert.Equal(t, otherPorts, fw.Allowed)
+
+	dns := []*compute.FirewallAllowed{{IPProtocol: "udp", Ports: []string{"53"}}}
+	restrictedName := makeConsolidatedFirewallName(vals.ClusterID, []string{"10.0.0.0/8"}, dns)
+	fw, err = gce.GetFirewall(restrictedName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.3"}, fw.DestinationRanges)
+	assert.Equal(t, dns, fw.Allowed)
+
+	_, err = gce.GetFirewall(dedicatedName)
+	assert.True(t, isNotFound(err), "the firewall rule of a consolidated load balancer should be deleted")
+	_, err = gce.GetFirewall(staleName)
+	assert.True(t, isNotFound(err), "unreferenced consolidated firewall rules should be deleted")
+	_, err = gce.GetFirewall(otherName)
+	assert.NoError(t, err, "the consolidated firewall rules of other clusters should be kept")
+
+	// Removing the last reference deletes the rule.
+	require.NoError(t, gce.EnsureConsolidatedFirewalls(context.TODO(), []*v1.Service{svcA}, nodes))
+	fw, err = gce.GetFirewall(httpName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, fw.DestinationRanges)
+	for _, name := range []string{otherPortsName, restrictedName} {
+		_, err = gce.GetFirewall(name)
+		assert.True(t, isNotFound(err), "firewall rule %s should be deleted", name)
+	}
+
+	// The firewall rules of the load balancers already deleted are not
+	// deleted again.
+	deleted := []string{}
+	gce.c.(*cloud.MockGCE).MockFirewalls.DeleteHook = func(_ context.Context, key *meta.Key, _ *cloud.MockFirewalls, _ ...cloud.Option) (bool, error) {
+		deleted = append(deleted, key.Name)
+		return false, nil
+	}
+	require.NoError(t, gce.EnsureConsolidatedFirewalls(context.TODO(), []*v1.Service{svcA}, nodes))
+	assert.Empty(t, deleted)
+}
+
+func TestEnsureExternalLoadBalancerFirewallConsolidation(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.EnableFirewallConsolidation()
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := consolidatedFirewallService("svc", "", v1.ProtocolTCP, 80)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	fwName := MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svc))
+	_, err = gce.GetFirewall(fwName)
+	require.NoError(t, err, "the load balancer should keep its firewall rule until it is consolidated")
+
+	svc.Status.LoadBalancer = *status
+	require.NoError(t, gce.EnsureConsolidatedFirewalls(context.TODO(), []*v1.Service{svc}, nodes))
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetFirewall(fwName)
+	assert.True(t, isNotFound(err), "no firewall rule should be created once the load balancer is consolidated")
+}
+
+func TestReleaseConsolidatedFirewalls(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	name := makeConsolidatedFirewallName(vals.ClusterID, []string{"0.0.0.0/0"}, nil)
+	require.NoError(t, gce.CreateFirewall(&compute.Firewall{
+		Name:              name,
+		Description:       `{"kubernetes.io/cluster-id":"test-cluster-id","kubernetes.io/load-balancer-count":2}`,
+		DestinationRanges: []string{"1.1.1.1", "1.1.1.2"},
+	}))
+
+	require.NoError(t, gce.releaseConsolidatedFirewalls("1.1.1.1"))
+	fw, err := gce.GetFirewall(name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.2"}, fw.DestinationRanges)
+	assert.Equal(t, `{"kubernetes.io/cluster-id":"test-cluster-id","kubernetes.io/load-balancer-count":1}`, fw.Description)
+
+	require.NoError(t, gce.releaseConsolidatedFirewalls("1.1.1.2"))
+	_, err = gce.GetFirewall(name)
+	assert.True(t, isNotFound(err), "the rule of the last load balancer should be deleted")
+
+	// The rules are not listed anymore once all of them are released.
+	require.NoError(t, gce.releaseConsolidatedFirewalls("1.1.1.3"))
+	assert.True(t, gce.consolidatedFirewallsReleased.Load())
+}
@@ -0,0 +1,178 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	utilnet "k8s.io/utils/net"
+)
+
+// managedAllowedDescriptionField is the field of the description of the
+// firewalls of the load balancers merging their allowed entries, listing the
+// entries managed by the provider, e.g. "tcp:80,tcp:8080-8081,udp:53".
+const managedAllowedDescriptionField = reservedDescriptionFieldPrefix + "managed-allowed"
+
+// mergeFirewallAllowed returns the allowed entries and the description of the
+// firewall of a load balancer merging the allowed entries of the existing
+// firewall: the entries of the provider, allowed, are applied and recorded in
+// the description, the entries of the existing firewall which the provider
+// did not record are kept. All the entries of an existing firewall without the
+// record, e.g. before the merge is enabled, are kept.
+func mergeFirewallAllowed(desc string, allowed []*compute.FirewallAllowed, existing *compute.Firewall) ([]*compute.FirewallAllowed, string, error) {
+	fields := map[string]string{}
+	if err := json.Unmarshal([]byte(desc), &fields); err != nil {
+		return nil, "", fmt.Errorf("failed to parse firewall description %q: %w", desc, err)
+	}
+	managed := firewallAllowedEntries(allowed)
+	fields[managedAllowedDescriptionField] = strings.Join(managed.List(), ",")
+	mergedDesc, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing == nil {
+		return allowed, string(mergedDesc), nil
+	}
+
+	previouslyManaged := sets.NewString()
+	existingFields := map[string]string{}
+	if err := json.Unmarshal([]byte(existing.Description), &existingFields); err == nil && existingFields[managedAllowedDescriptionField] != "" {
+		previouslyManaged.Insert(strings.Split(existingFields[managedAllowedDescriptionField], ",")...)
+	}
+	preserved := firewallAllowedEntries(existing.Allowed).Difference(previouslyManaged).Difference(managed)
+	if preserved.Len() > 0 {
+		klog.V(2).Infof("Preserving the allowed entries %v of firewall %s not managed by the provider", preserved.List(), existing.Name)
+	}
+	return append(allowed, firewallAllowedFromEntries(preserved)...), string(mergedDesc), nil
+}
+
+// firewallAllowedEntries returns the allowed entries as "protocol:port"
+// strings, or "protocol" for the entries allowing all the ports.
+func firewallAllowedEntries(allowed []*compute.FirewallAllowed) sets.String {
+	entries := sets.NewString()
+	for _, a := range allowed {
+		if len(a.Ports) == 0 {
+			entries.Insert(a.IPProtocol)
+			continue
+		}
+		for _, port := range a.Ports {
+			entries.Insert(a.IPProtocol + ":" + port)
+		}
+	}
+	return entries
+}
+
+// firewallAllowedFromEntries returns the allowed entries of the strings of
+// firewallAllowedEntries, an entry per protocol.
+func firewallAllowedFromEntries(entries sets.String) []*compute.FirewallAllowed {
+	byProtocol := map[string]*compute.FirewallAllowed{}
+	var protocols []string
+	for _, entry := range entries.List() {
+		protocol, port, hasPort := strings.Cut(entry, ":")
+		a, ok := byProtocol[protocol]
+		if !ok {
+			a = &compute.FirewallAllowed{IPProtocol: protocol}
+			byProtocol[protocol] = a
+			protocols = append(protocols, protocol)
+		}
+		if hasPort {
+			a.Ports = append(a.Ports, port)
+		}
+	}
+	sort.Strings(protocols)
+	var allowed []*compute.FirewallAllowed
+	for _, protocol := range protocols {
+		a := byProtocol[protocol]
+		if entries.Has(protocol) {
+			// All the ports of the protocol are allowed.
+			a.Ports = nil
+		}
+		allowed = append(allowed, a)
+	}
+	return allowed
+}
+
+// equalFirewallAllowed returns true if the allowed entries allow the same
+// protocols and ports.
+func equalFirewallAllowed(a, b []*compute.FirewallAllowed) bool {
+	return firewallAllowedEntries(a).Equal(firewallAllowedEntries(b))
+}
+
+// ensureMergedFirewall ensures the firewall of an external load balancer
+// merging the allowed entries of the existing firewall, see
+// ServiceAnnotationLoadBalancerFirewallMergeAllowed.
+func (g *Cloud) ensureMergedFirewall(svc *v1.Service, name, desc, destinationIP string, sourceRanges utilnet.IPNetSet, ports []v1.ServicePort, hosts []*gceInstance) error {
+	existing, err := g.GetFirewall(name)
+	if err != nil {
+		if !isHTTPErrorCode(err, http.StatusNotFound) {
+			return fmt.Errorf("error getting load balancer's firewall: %v", err)
+		}
+		existing = nil
+	}
+	firewall, err := g.firewallObject(name, desc, destinationIP, sourceRanges, ports, hosts)
+	if err != nil {
+		return err
+	}
+	if firewall.Allowed, firewall.Description, err = mergeFirewallAllowed(desc, firewall.Allowed, existing); err != nil {
+		return err
+	}
+
+	if existing == nil {
+		klog.Infof("ensureMergedFirewall(%v): creating firewall", name)
+		if err := g.CreateFirewall(firewall); err != nil {
+			if isHTTPErrorCode(err, http.StatusConflict) {
+				return nil
+			} else if isForbidden(err) && g.OnXPN() {
+				klog.V(4).Infof("ensureMergedFirewall(%v): do not have permission to create firewall rule (on XPN). Raising event.", name)
+				g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudCreateCmd(firewall, g.NetworkProjectID()))
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+	if existing.Description == firewall.Description &&
+		equalFirewallAllowed(existing.Allowed, firewall.Allowed) &&
+		equalStringSets(existing.SourceRanges, firewall.SourceRanges) &&
+		reflect.DeepEqual(existing.DestinationRanges, firewall.DestinationRanges) {
+		return nil
+	}
+	klog.Infof("ensureMergedFirewall(%v): updating firewall", name)
+	if err := g.PatchFirewall(firewall); err != nil {
+		if isHTTPErrorCode(err, http.StatusConflict) {
+			return nil
+		} else if isForbidden(err) && g.OnXPN() {
+			klog.V(4).Infof("ensureMergedFirewall(%v): do not have permission to update firewall rule (on XPN). Raising event.", name)
+			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudUpdateCmd(firewall, g.NetworkProjectID()))
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/utils/net"
+)
+
+func TestMergeFirewallAllowed(t *testing.T) {
+	desc := makeFirewallDescription("default/svc", "10.0.0.1")
+	tcp := func(ports ...string) *compute.FirewallAllowed {
+		return &compute.FirewallAllowed{IPProtocol: "tcp", Ports: ports}
+	}
+
+	for _, tc := range []struct {
+		desc        string
+		allowed     []*compute.FirewallAllowed
+		existing    *compute.Firewall
+		wantEntries []string
+	}{
+		{
+			desc:        "new firewall",
+			allowed:     []*compute.FirewallAllowed{tcp("80")},
+			wantEntries: []string{"tcp:80"},
+		},
+		{
+			desc:    "existing firewall without record keeps all its entries",
+			allowed: []*compute.FirewallAllowed{tcp("80")},
+			existing: &compute.Firewall{
+				Description: desc,
+				Allowed:     []*compute.FirewallAllowed{tcp("80", "22"), {IPProtocol: "icmp"}},
+			},
+			wantEntries: []string{"icmp", "tcp:22", "tcp:80"},
+		},
+		{
+			desc:    "the entries removed from the Service are removed",
+			allowed: []*compute.FirewallAllowed{tcp("443")},
+			existing: &compute.Firewall{
+				Description: `{"kubernetes.io/managed-allowed":"tcp:80","kubernetes.io/service-ip":"10.0.0.1","kubernetes.io/service-name":"default/svc"}`,
+				Allowed:     []*compute.FirewallAllowed{tcp("80", "22"), {IPProtocol: "udp", Ports: []string{"53"}}},
+			},
+			wantEntries: []string{"tcp:22", "tcp:443", "udp:53"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			allowed, mergedDesc, err := mergeFirewallAllowed(desc, tc.allowed, tc.existing)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantEntries, firewallAllowedEntries(allowed).List())
+			assert.Equal(t, `{"kubernetes.io/managed-allowed":"`+firewallAllowedEntries(tc.allowed).List()[0]+`","kubernetes.io/service-ip":"10.0.0.1","kubernetes.io/service-name":"default/svc"}`, mergedDesc)
+		})
+	}
+}
+
+func TestEnsureMergedFirewallExternal(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerFirewallMergeAllowed] = "true"
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	hosts, err := gce.getInstancesByNames(nodeNames(nodes))
+	require.NoError(t, err)
+	sourceRanges, err := utilnet.ParseIPNets("0.0.0.0/0")
+	require.NoError(t, err)
+	fwName := MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svc))
+	desc := makeFirewallDescription("default/"+svc.Name, "1.2.3.4")
+
+	require.NoError(t, gce.ensureMergedFirewall(svc, fwName, desc, "1.2.3.4", sourceRanges, svc.Spec.Ports, hosts))
+	// An entry is added manually.
+	fw, err := gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	fw.Allowed = append(fw.Allowed, &compute.FirewallAllowed{IPProtocol: "tcp", Ports: []string{"22"}})
+	require.NoError(t, gce.PatchFirewall(fw))
+
+	svc.Spec.Ports = []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 456}}
+	require.NoError(t, gce.ensureMergedFirewall(svc, fwName, desc, "1.2.3.4", sourceRanges, svc.Spec.Ports, hosts))
+	fw, err = gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp:22", "tcp:456"}, firewallAllowedEntries(fw.Allowed).List())
+}
+
+func TestEnsureInternalFirewallMergeAllowed(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerFirewallMergeAllowed] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	fwName := MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svc))
+	desc := makeFirewallDescription(svc.Namespace+"/"+svc.Name, "10.1.2.3")
+	sourceRanges := []string{"10.0.0.0/20"}
+
+	require.NoError(t, gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", sourceRanges, []string{"123"}, v1.ProtocolTCP, nodes, ""))
+	fw, err := gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	fw.Allowed = append(fw.Allowed, &compute.FirewallAllowed{IPProtocol: "tcp", Ports: []string{"22"}})
+	require.NoError(t, gce.PatchFirewall(fw))
+
+	require.NoError(t, gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", sourceRanges, []string{"456"}, v1.ProtocolTCP, nodes, ""))
+	fw, err = gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp:22", "tcp:456"}, firewallAllowedEntries(fw.Allowed).List())
+
+	// Without the annotation, the manual entries are removed.
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerFirewallMergeAllowed)
+	require.NoError(t, gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", sourceRanges, []string{"456"}, v1.ProtocolTCP, nodes, ""))
+	fw, err = gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp:456"}, firewallAllowedEntries(fw.Allowed).List())
+}
@@ -0,0 +1,201 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// xpnFirewallOwnerField is the field of the descriptions of the firewall rules
+// created by the cluster in the host project of its Shared VPC network, whose
+// value is the project of the cluster. The other firewall rules of the host
+// project, e.g. created by the security admins after the
+// LoadBalancerManualChange events, are not changed.
+const xpnFirewallOwnerField = "kubernetes.io/owner-project"
+
+// xpnTokenSource returns the token source of the credentials file of the
+// host project, the JSON key of a service account or an external account
+// configuration.
+func xpnTokenSource(credentialsFile string) (oauth2.TokenSource, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpn-credentials-file: %v", err)
+	}
+	creds, err := google.CredentialsFromJSON(context.Background(), data, compute.ComputeScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xpn-credentials-file %s: %v", credentialsFile, err)
+	}
+	return creds.TokenSource, nil
+}
+
+// newHostFirewalls returns the firewall rules of the host project called with
+// the token source of its credentials, see ConfigGlobal.XPNCredentialsFile.
+func newHostFirewalls(g *Cloud, tokenSource oauth2.TokenSource, basePath, userAgent string, mutationEvents bool) (cloud.Firewalls, error) {
+	client, err := newOauthClient(tokenSource)
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = &responseFieldsTransport{base: client.Transport}
+	if mutationEvents {
+		transport = &mutationEventTransport{base: transport, cloud: g}
+	}
+	service, err := compute.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, err
+	}
+	service.UserAgent = userAgent
+	service.BasePath = basePath
+	// Only the GA firewall rules are called.
+	return cloud.NewGCE(&cloud.Service{
+		GA:            service,
+		ProjectRouter: &gceProjectRouter{g},
+		RateLimiter:   &gceRateLimiter{g},
+	}).Firewalls(), nil
+}
+
+// firewalls returns the firewall rules of the network project.
+func (g *Cloud) firewalls() cloud.Firewalls {
+	if g.hostFirewalls != nil {
+		return g.hostFirewalls
+	}
+	return g.c.Firewalls()
+}
+
+// managesXPNFirewalls returns true if the cluster creates the firewall rules of
+// its load balancers in the host project of its Shared VPC network, instead of
+// raising LoadBalancerManualChange events.
+func (g *Cloud) managesXPNFirewalls() bool {
+	return g.xpnFirewalls
+}
+
+// xpnFirewallOwner returns the description field of the firewall rules owned
+// by the cluster, see xpnFirewallOwnerField.
+func (g *Cloud) xpnFirewallOwner() string {
+	return fmt.Sprintf(`"%s":"%s"`, xpnFirewallOwnerField, g.projectID)
+}
+
+// withXPNFirewallOwner returns the description of a firewall rule of the host
+// project owned by the cluster. The field is appended to the JSON description,
+// so that withoutXPNFirewallOwner restores the description as is.
+func (g *Cloud) withXPNFirewallOwner(desc string) string {
+	if desc == "" {
+		return "{" + g.xpnFirewallOwner() + "}"
+	}
+	if !strings.HasSuffix(desc, "}") {
+		return desc
+	}
+	return strings.TrimSuffix(desc, "}") + ", " + g.xpnFirewallOwner() + "}"
+}
+
+// withoutXPNFirewallOwner returns the description of a firewall rule without
+// the owner field, and true if it is owned by the cluster.
+func (g *Cloud) withoutXPNFirewallOwner(desc string) (string, bool) {
+	if desc == "{"+g.xpnFirewallOwner()+"}" {
+		return "", true
+	}
+	if owner := ", " + g.xpnFirewallOwner() + "}"; strings.HasSuffix(desc, owner) {
+		return strings.TrimSuffix(desc, owner) + "}", true
+	}
+	return desc, false
+}
+
+// fromHostFirewall returns the firewall rule of the host project as the
+// cluster expects it, without the owner field.
+func (g *Cloud) fromHostFirewall(fw *compute.Firewall) *compute.Firewall {
+	desc, owned := g.withoutXPNFirewallOwner(fw.Description)
+	if !owned {
+		return fw
+	}
+	copied := *fw
+	copied.Description = desc
+	return &copied
+}
+
+// toHostFirewall returns the firewall rule to write in the host project, with
+// the owner field.
+func (g *Cloud) toHostFirewall(fw *compute.Firewall) *compute.Firewall {
+	copied := *fw
+	copied.Description = g.withXPNFirewallOwner(fw.Description)
+	return &copied
+}
+
+// ensureXPNFirewallOwned returns a forbidden error if the firewall rule of the
+// host project is not owned by the cluster, the callers then raise the
+// LoadBalancerManualChange events as if the cluster could not change it. The
+// rules created by the cluster before it managed the firewall rules of the
+// host project have no owner field, they are owned if they are the ones of
+// the cluster, see isClusterFirewall, and get the field once updated.
+func (g *Cloud) ensureXPNFirewallOwned(ctx context.Context, name string) error {
+	fw, err := g.firewalls().Get(ctx, meta.GlobalKey(name))
+	if err != nil {
+		return err
+	}
+	if _, owned := g.withoutXPNFirewallOwner(fw.Description); owned || g.isClusterFirewall(ctx, fw) {
+		return nil
+	}
+	return &googleapi.Error{
+		Code:    http.StatusForbidden,
+		Message: fmt.Sprintf("firewall rule %s of host project %s is not owned by project %s", name, g.NetworkProjectID(), g.projectID),
+	}
+}
+
+// isClusterFirewall returns true if the firewall rule is named after the
+// cluster, e.g. the firewall rules of the shared health checks, or after the
+// load balancer of the Service of the cluster its description names, see
+// makeFirewallDescription. The load balancer names are made of the UIDs of
+// the Services, which the other clusters do not share.
+func (g *Cloud) isClusterFirewall(ctx context.Context, fw *compute.Firewall) bool {
+	if clusterID, err := g.ClusterID.GetID(); err == nil && strings.HasPrefix(fw.Name, fmt.Sprintf("k8s-%s-", clusterID)) {
+		return true
+	}
+	desc := struct {
+		ServiceName string `json:"kubernetes.io/service-name"`
+	}{}
+	if err := json.Unmarshal([]byte(fw.Description), &desc); err != nil || g.client == nil {
+		return false
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(desc.ServiceName)
+	if err != nil || name == "" {
+		return false
+	}
+	svc, err := g.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("isClusterFirewall(%v): failed to get service %s: %v", fw.Name, desc.ServiceName, err)
+		return false
+	}
+	return strings.Contains(fw.Name, cloudprovider.DefaultLoadBalancerName(svc))
+}
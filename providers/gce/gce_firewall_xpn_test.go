@@ -0,0 +1,198 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestXPNFirewallOwner(t *testing.T) {
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+
+	for _, desc := range []string{
+		"",
+		makeFirewallDescription("default/svc", "10.0.0.1"),
+		`{"kubernetes.io/cluster-id":"uid","references":2}`,
+	} {
+		owned := gce.withXPNFirewallOwner(desc)
+		assert.Contains(t, owned, xpnFirewallOwnerField)
+		got, ok := gce.withoutXPNFirewallOwner(owned)
+		assert.True(t, ok, "description %q", owned)
+		assert.Equal(t, desc, got)
+	}
+
+	// The rules of the other projects, or created by the admins.
+	for _, desc := range []string{
+		"",
+		makeFirewallDescription("default/svc", "10.0.0.1"),
+		`{"kubernetes.io/service-name":"default/svc", "kubernetes.io/owner-project":"other-project"}`,
+	} {
+		got, ok := gce.withoutXPNFirewallOwner(desc)
+		assert.False(t, ok, "description %q", desc)
+		assert.Equal(t, desc, got)
+	}
+}
+
+func TestEnsureInternalLoadBalancerXPNFirewalls(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.onXPN = true
+	gce.xpnFirewalls = true
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	c := gce.c.(*cloud.MockGCE)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.NotEmpty(t, status.Ingress)
+	wantDesc := makeFirewallDescription(svc.Namespace+"/"+svc.Name, status.Ingress[0].IP)
+
+	raw, err := c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	require.NoError(t, err)
+	assert.Equal(t, gce.withXPNFirewallOwner(wantDesc), raw.Description)
+	fw, err := gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	assert.Equal(t, wantDesc, fw.Description)
+	hcFw, err := c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)))
+	require.NoError(t, err)
+	assert.Contains(t, hcFw.Description, xpnFirewallOwnerField)
+
+	// The firewall rule is updated in the host project.
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.1.0.0/16"}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fw, err = gce.GetFirewall(fwName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.0.0/16"}, fw.SourceRanges)
+	assert.Equal(t, wantDesc, fw.Description)
+	checkEvent(t, recorder, FirewallChangeMsg, false)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	_, err = c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	assert.True(t, isNotFound(err), "firewall rule of the host project: %v", err)
+}
+
+func TestEnsureInternalFirewallXPNNotOwned(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.onXPN = true
+	gce.xpnFirewalls = true
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	c := gce.c.(*cloud.MockGCE)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	desc := makeFirewallDescription(svc.Namespace+"/"+svc.Name, "10.1.2.3")
+
+	// The firewall rule was created by a security admin.
+	adminFw := &compute.Firewall{
+		Name:              fwName,
+		Description:       "Managed by the security admins",
+		SourceRanges:      []string{"10.0.0.0/20"},
+		DestinationRanges: []string{"10.1.2.3"},
+		Allowed:           []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"123"}}},
+	}
+	require.NoError(t, c.MockFirewalls.Insert(context.TODO(), meta.GlobalKey(fwName), adminFw))
+
+	err = gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", []string{"10.0.0.0/16"}, []string{"123"}, v1.ProtocolTCP, nodes, lbName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, FirewallChangeMsg, true)
+	raw, err := c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/20"}, raw.SourceRanges, "the firewall rule of the admin is not changed")
+
+	require.NoError(t, gce.deleteInternalFirewall(svc, lbName, fwName))
+	checkEvent(t, recorder, FirewallChangeMsg, true)
+	_, err = c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	assert.NoError(t, err, "the firewall rule of the admin is not deleted")
+}
+
+func TestEnsureInternalFirewallXPNCreatedByCluster(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.onXPN = true
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	c := gce.c.(*cloud.MockGCE)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwName := MakeFirewallName(lbName)
+	desc := makeFirewallDescription(svc.Namespace+"/"+svc.Name, "10.1.2.3")
+
+	// The firewall rule was created by the cluster before it managed the
+	// firewall rules of the host project, without the owner field.
+	err = gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", []string{"10.0.0.0/16"}, []string{"123"}, v1.ProtocolTCP, nodes, lbName)
+	require.NoError(t, err)
+	raw, err := c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	require.NoError(t, err)
+	require.NotContains(t, raw.Description, xpnFirewallOwnerField)
+
+	gce.xpnFirewalls = true
+	err = gce.ensureInternalFirewall(svc, fwName, desc, "10.1.2.3", []string{"10.1.0.0/16"}, []string{"123"}, v1.ProtocolTCP, nodes, lbName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, FirewallChangeMsg, false)
+	raw, err = c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(fwName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.0.0/16"}, raw.SourceRanges)
+	assert.Equal(t, gce.withXPNFirewallOwner(desc), raw.Description, "the owner field was not added")
+
+	// The firewall rules of the shared health checks are named after the
+	// cluster.
+	hcFwName := makeHealthCheckFirewallName(lbName, vals.ClusterID, true)
+	require.NoError(t, c.MockFirewalls.Insert(context.TODO(), meta.GlobalKey(hcFwName), &compute.Firewall{Name: hcFwName}))
+	require.NoError(t, gce.deleteInternalFirewall(svc, lbName, hcFwName))
+	require.NoError(t, gce.deleteInternalFirewall(svc, lbName, fwName))
+	checkEvent(t, recorder, FirewallChangeMsg, false)
+	for _, name := range []string{fwName, hcFwName} {
+		_, err = c.MockFirewalls.Get(context.TODO(), meta.GlobalKey(name))
+		assert.True(t, isNotFound(err), "firewall rule %s of the host project: %v", name, err)
+	}
+}
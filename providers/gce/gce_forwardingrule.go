@@ -151,7 +151,9 @@ func (g *Cloud) CreateRegionForwardingRule(rule *compute.ForwardingRule, region
 	defer cancel()
 
 	mc := newForwardingRuleMetricContext("create", region)
-	return mc.Observe(g.c.ForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyForwardingRule, "create", func() error {
+		return g.c.ForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule)
+	}))
 }
 
 // CreateAlphaRegionForwardingRule creates and returns an Alpha
@@ -161,7 +163,9 @@ func (g *Cloud) CreateAlphaRegionForwardingRule(rule *computealpha.ForwardingRul
 	defer cancel()
 
 	mc := newForwardingRuleMetricContextWithVersion("create", region, computeAlphaVersion)
-	return mc.Observe(g.c.AlphaForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyForwardingRule, "create", func() error {
+		return g.c.AlphaForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule)
+	}))
 }
 
 // CreateBetaRegionForwardingRule creates and returns a Beta
@@ -171,7 +175,9 @@ func (g *Cloud) CreateBetaRegionForwardingRule(rule *computebeta.ForwardingRule,
 	defer cancel()
 
 	mc := newForwardingRuleMetricContextWithVersion("create", region, computeBetaVersion)
-	return mc.Observe(g.c.BetaForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyForwardingRule, "create", func() error {
+		return g.c.BetaForwardingRules().Insert(ctx, meta.RegionalKey(rule.Name, region), rule)
+	}))
 }
 
 // DeleteRegionForwardingRule deletes the RegionalForwardingRule by name & region.
@@ -180,7 +186,9 @@ func (g *Cloud) DeleteRegionForwardingRule(name, region string) error {
 	defer cancel()
 
 	mc := newForwardingRuleMetricContext("delete", region)
-	return mc.Observe(g.c.ForwardingRules().Delete(ctx, meta.RegionalKey(name, region)))
+	return mc.Observe(g.withRetryPolicy(RetryPolicyForwardingRule, "delete", func() error {
+		return g.c.ForwardingRules().Delete(ctx, meta.RegionalKey(name, region))
+	}))
 }
 
 func (g *Cloud) getNetworkTierFromForwardingRule(name, region string) (string, error) {
@@ -20,6 +20,11 @@ limitations under the License.
 package gce
 
 import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
 	computealpha "google.golang.org/api/compute/v0.alpha"
 	computebeta "google.golang.org/api/compute/v0.beta"
 	compute "google.golang.org/api/compute/v1"
@@ -30,14 +35,104 @@ import (
 )
 
 const (
-	nodesHealthCheckPath = "/healthz"
+	defaultNodesHealthCheckPath = "/healthz"
 	// NOTE: Please keep the following port in sync with ProxyHealthzPort in pkg/cluster/ports/ports.go
 	// ports.ProxyHealthzPort was not used here to avoid dependencies to k8s.io/kubernetes in the
 	// GCE cloud provider which is required as part of the out-of-tree cloud provider efforts.
 	// TODO: use a shared constant once ports in pkg/cluster/ports are in a common external repo.
-	lbNodesHealthCheckPort = 10256
+	defaultLBNodesHealthCheckPort = 10256
+)
+
+var (
+	// nodesHealthCheckPath and lbNodesHealthCheckPort are the endpoint of the
+	// nodes health check, served by kube-proxy by default. They are set by
+	// flags for the dataplanes replacing kube-proxy.
+	nodesHealthCheckPath   = healthCheckPathValue(defaultNodesHealthCheckPath)
+	lbNodesHealthCheckPort = healthCheckPortValue(defaultLBNodesHealthCheckPort)
+)
+
+func init() {
+	flag.Var(&lbNodesHealthCheckPort, "cloud-provider-gce-lb-nodes-health-check-port", "Port of the nodes health check of the L4 LBs, for dataplanes replacing kube-proxy")
+	flag.Var(&nodesHealthCheckPath, "cloud-provider-gce-lb-nodes-health-check-path", "Path of the nodes health check of the L4 LBs, for dataplanes replacing kube-proxy")
+}
+
+// healthCheckPortValue is a health check port flag.
+type healthCheckPortValue int32
+
+// String is the method to format the flag's value, part of the flag.Value interface.
+func (v *healthCheckPortValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+// Set supports a value of a port between 1 and 65535, part of the flag.Value interface.
+func (v *healthCheckPortValue) Set(val string) error {
+	port, err := parseHealthCheckPort(val)
+	if err != nil {
+		return err
+	}
+	*v = healthCheckPortValue(port)
+	return nil
+}
+
+// Type is the type of the flag, part of the pflag.Value interface.
+func (v *healthCheckPortValue) Type() string {
+	return "int"
+}
+
+// healthCheckPathValue is a health check path flag.
+type healthCheckPathValue string
+
+// String is the method to format the flag's value, part of the flag.Value interface.
+func (v *healthCheckPathValue) String() string {
+	return string(*v)
+}
+
+// Set supports an absolute path, part of the flag.Value interface.
+func (v *healthCheckPathValue) Set(val string) error {
+	if err := validateHealthCheckPath(val); err != nil {
+		return err
+	}
+	*v = healthCheckPathValue(val)
+	return nil
+}
+
+// Type is the type of the flag, part of the pflag.Value interface.
+func (v *healthCheckPathValue) Type() string {
+	return "string"
+}
+
+func parseHealthCheckPort(val string) (int32, error) {
+	port, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid health check port %q, expected a port between 1 and 65535", val)
+	}
+	return int32(port), nil
+}
+
+func validateHealthCheckPath(val string) error {
+	if !strings.HasPrefix(val, "/") {
+		return fmt.Errorf("invalid health check path %q, expected an absolute path", val)
+	}
+	return nil
+}
+
+const (
+	// maxHealthCheckSeconds is the limit of the check interval and of the
+	// timeout of the health checks.
+	maxHealthCheckSeconds = 300
+	// maxHealthCheckThreshold is the limit of the healthy and unhealthy
+	// thresholds of the health checks.
+	maxHealthCheckThreshold = 10
 )
 
+func parseHealthCheckSetting(val string, max int64) (int64, error) {
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || v < 1 || v > max {
+		return 0, fmt.Errorf("invalid health check setting %q, expected an integer between 1 and %d", val, max)
+	}
+	return v, nil
+}
+
 func newHealthcheckMetricContext(request string) *metricContext {
 	return newHealthcheckMetricContextWithVersion(request, computeV1Version)
 }
@@ -248,13 +343,15 @@ func (g *Cloud) ListHealthChecks() ([]*compute.HealthCheck, error) {
 }
 
 // GetNodesHealthCheckPort returns the health check port used by the GCE load
-// balancers (l4) for performing health checks on nodes.
+// balancers (l4) for performing health checks on nodes, unless overridden by
+// the Service, see GetLoadBalancerAnnotationNodesHealthCheck.
 func GetNodesHealthCheckPort() int32 {
-	return lbNodesHealthCheckPort
+	return int32(lbNodesHealthCheckPort)
 }
 
 // GetNodesHealthCheckPath returns the health check path used by the GCE load
-// balancers (l4) for performing health checks on nodes.
+// balancers (l4) for performing health checks on nodes, unless overridden by
+// the Service, see GetLoadBalancerAnnotationNodesHealthCheck.
 func GetNodesHealthCheckPath() string {
-	return nodesHealthCheckPath
+	return string(nodesHealthCheckPath)
 }
@@ -89,6 +89,22 @@ func (g *Cloud) ListInstanceGroupsWithPrefix(zone string, prefix string) ([]*com
 	return v, mc.Observe(err)
 }
 
+// ListInstanceGroupManagersWithPrefix lists all the managed instance groups
+// in the project and zone with given prefix.
+// When the prefix is empty it lists all the managed instance groups.
+func (g *Cloud) ListInstanceGroupManagersWithPrefix(zone string, prefix string) ([]*compute.InstanceGroupManager, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newInstanceGroupMetricContext("list_managers", zone)
+	f := filter.None
+	if prefix != "" {
+		f = filter.Regexp("name", fmt.Sprintf("%s.*", prefix))
+	}
+	v, err := g.c.InstanceGroupManagers().List(ctx, zone, f)
+	return v, mc.Observe(err)
+}
+
 // ListInstancesInInstanceGroup lists all the instances in a given
 // instance group and state.
 func (g *Cloud) ListInstancesInInstanceGroup(name string, zone string, state string) ([]*compute.InstanceWithNamedPorts, error) {
@@ -97,7 +113,7 @@ func (g *Cloud) ListInstancesInInstanceGroup(name string, zone string, state str
 
 	mc := newInstanceGroupMetricContext("list_instances", zone)
 	req := &compute.InstanceGroupsListInstancesRequest{InstanceState: state}
-	v, err := g.c.InstanceGroups().ListInstances(ctx, meta.ZonalKey(name, zone), req, filter.None)
+	v, err := g.c.InstanceGroups().ListInstances(withListResponseFields(ctx, instanceGroupInstanceFields), meta.ZonalKey(name, zone), req, filter.None)
 	return v, mc.Observe(err)
 }
 
@@ -24,6 +24,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -49,6 +51,25 @@ const (
 	networkInterfaceIPV6          = "instance/network-interfaces/%s/ipv6s"
 	networkInterfaceAccessConfigs = "instance/network-interfaces/%s/access-configs"
 	networkInterfaceExternalIP    = "instance/network-interfaces/%s/access-configs/%s/external-ip"
+
+	// cloudProviderUninitializedTaint is set on nodes registered with an external
+	// cloud provider until the cloud node controller initializes them.
+	cloudProviderUninitializedTaint = "node.cloudprovider.kubernetes.io/uninitialized"
+
+	// AcceleratorTypeLabelKey is the node label set to the type of the guest
+	// accelerators attached to the instance, e.g. nvidia-tesla-t4.
+	AcceleratorTypeLabelKey = "cloud.google.com/gke-accelerator"
+	// AcceleratorCountLabelKey is the node label set to the number of guest
+	// accelerators of AcceleratorTypeLabelKey attached to the instance.
+	AcceleratorCountLabelKey = "cloud.google.com/gke-accelerator-count"
+
+	// InstanceGroupManagerLabelKey is the node label set to the name of the
+	// managed instance group of the instance, e.g. the group of its node pool.
+	InstanceGroupManagerLabelKey = "cloud.google.com/instance-group-manager"
+
+	// createdByMetadataKey is the instance metadata key set by the managed
+	// instance groups to the URL of the group of the instance.
+	createdByMetadataKey = "created-by"
 )
 
 func newInstancesMetricContext(request, zone string) *metricContext {
@@ -270,12 +291,101 @@ func (g *Cloud) instanceByProviderID(providerID string) (*gceInstance, error) {
 
 // InstanceShutdownByProviderID returns true if the instance is in safe state to detach volumes
 func (g *Cloud) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
-	return false, cloudprovider.NotImplemented
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
+	defer cancel()
+
+	_, zone, name, err := splitProviderID(providerID)
+	if err != nil {
+		return false, err
+	}
+
+	mc := newInstancesMetricContext("get", zone)
+	instance, err := g.c.Instances().Get(timeoutCtx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	mc.Observe(err)
+	if err != nil {
+		if isNotFound(err) {
+			return false, cloudprovider.InstanceNotFound
+		}
+		return false, fmt.Errorf("error while querying for providerID %q: %v", providerID, err)
+	}
+	return isInstanceShutdown(instance), nil
 }
 
 // InstanceShutdown returns true if the instance is in safe state to detach volumes
 func (g *Cloud) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
-	return false, cloudprovider.NotImplemented
+	providerID := node.Spec.ProviderID
+	if providerID == "" {
+		var err error
+		if providerID, err = cloudprovider.GetInstanceProviderID(ctx, g, types.NodeName(node.Name)); err != nil {
+			return false, err
+		}
+	}
+	return g.InstanceShutdownByProviderID(ctx, providerID)
+}
+
+// isInstanceShutdown returns true if the instance is stopped, or is a spot or
+// preemptible instance which received its preemption notice.
+//
+// The preemption notice is sent to the guest as an ACPI G2 soft off signal and
+// through the "instance/preempted" metadata value, which only the instance
+// itself can read. GCE moves the instance to STOPPING at the same time, so the
+// node lifecycle controller reacts within seconds of the notice instead of at
+// the end of the shutdown period, once the instance is TERMINATED.
+func isInstanceShutdown(instance *compute.Instance) bool {
+	switch instance.Status {
+	case "TERMINATED", "STOPPED", "SUSPENDING", "SUSPENDED":
+		return true
+	case "STOPPING":
+		return isPreemptibleInstance(instance)
+	}
+	return false
+}
+
+// isPreemptibleInstance returns true for the spot and the preemptible
+// instances, which GCE may stop at any time.
+func isPreemptibleInstance(instance *compute.Instance) bool {
+	if instance.Scheduling == nil {
+		return false
+	}
+	return instance.Scheduling.Preemptible || instance.Scheduling.ProvisioningModel == "SPOT"
+}
+
+// isNodeUninitialized returns true if the node still waits to be initialized by
+// the cloud node controller, i.e. it is registering.
+func isNodeUninitialized(node *v1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == cloudProviderUninitializedTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNodeCloudNAT raises a warning event on nodes without an external IP whose
+// subnetwork is not served by a Cloud NAT gateway, as they cannot reach the
+// internet, e.g. to pull images from public registries. Failures are only logged
+// so that they never block the node registration.
+func (g *Cloud) checkNodeCloudNAT(node *v1.Node, instance *compute.Instance, region string) {
+	if len(instance.NetworkInterfaces) == 0 {
+		return
+	}
+	nic := instance.NetworkInterfaces[0]
+	if len(nic.AccessConfigs) > 0 || nic.Subnetwork == "" {
+		return
+	}
+	routers, err := g.listRoutersCached(region)
+	if err != nil {
+		klog.Warningf("checkNodeCloudNAT(%v): failed to list routers in region %v: %v", node.Name, region, err)
+		return
+	}
+	if routersHaveCloudNAT(routers, nic.Network, nic.Subnetwork) {
+		return
+	}
+	msg := fmt.Sprintf("Node has no external IP and no Cloud NAT is configured for subnetwork %q, connections to the internet will fail", getNameFromLink(nic.Subnetwork))
+	klog.Warningf("checkNodeCloudNAT(%v): %s", node.Name, msg)
+	if g.eventRecorder != nil {
+		g.eventRecorder.Event(node, v1.EventTypeWarning, "CloudNATMissing", msg)
+	}
 }
 
 func (g *Cloud) nodeAddressesFromInstance(instance *compute.Instance) ([]v1.NodeAddress, error) {
@@ -328,11 +438,14 @@ func (g *Cloud) InstanceExistsByProviderID(ctx context.Context, providerID strin
 	_, err := g.instanceByProviderID(providerID)
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
-			return false, nil
+			_, zone, name, _ := splitProviderID(providerID)
+			missing, err := g.confirmInstanceMissing(providerID, zone, name)
+			return !missing, err
 		}
 		return false, err
 	}
 
+	g.forgetMissingInstance(providerID)
 	return true, nil
 }
 
@@ -389,18 +502,213 @@ func (g *Cloud) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprov
 
 	instanceType = lastComponent(instance.MachineType)
 
+	if g.AlphaFeatureGate.Enabled(AlphaFeatureCloudNATCheck) && isNodeUninitialized(node) {
+		g.checkNodeCloudNAT(node, instance, region)
+	}
+
 	return &cloudprovider.InstanceMetadata{
-		ProviderID:    providerID,
-		InstanceType:  instanceType,
-		NodeAddresses: addresses,
-		Zone:          zone,
-		Region:        region,
+		ProviderID:       providerID,
+		InstanceType:     instanceType,
+		NodeAddresses:    addresses,
+		Zone:             zone,
+		Region:           region,
+		AdditionalLabels: instanceLabels(instance),
 	}, nil
 }
 
+// instanceLabels returns the additional node labels of the instance, nil if
+// none.
+func instanceLabels(instance *compute.Instance) map[string]string {
+	labels := acceleratorLabels(instance)
+	if group := instanceGroupManager(instance); group != "" {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[InstanceGroupManagerLabelKey] = group
+	}
+	return labels
+}
+
+// instanceGroupManager returns the name of the managed instance group of the
+// instance, from its created-by metadata, or "" if it is not in a group.
+func instanceGroupManager(instance *compute.Instance) string {
+	if instance.Metadata == nil {
+		return ""
+	}
+	for _, item := range instance.Metadata.Items {
+		if item == nil || item.Key != createdByMetadataKey || item.Value == nil {
+			continue
+		}
+		if strings.Contains(*item.Value, "/instanceGroupManagers/") {
+			return lastComponent(*item.Value)
+		}
+	}
+	return ""
+}
+
+// InstanceGroupManager returns the name of the managed instance group of the
+// instance with the given providerID, or "" if it is not in a group. The
+// group changes without the node registering again, e.g. when the instance
+// is abandoned by its group. It returns cloudprovider.InstanceNotFound if the
+// instance does not exist.
+func (g *Cloud) InstanceGroupManager(ctx context.Context, providerID string) (string, error) {
+	_, zone, name, err := splitProviderID(providerID)
+	if err != nil {
+		return "", err
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
+	defer cancel()
+	mc := newInstancesMetricContext("get", zone)
+	instance, err := g.c.Instances().Get(timeoutCtx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	mc.Observe(err)
+	if isNotFound(err) {
+		return "", cloudprovider.InstanceNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return instanceGroupManager(instance), nil
+}
+
+// InstanceGroupManagers returns the names of the managed instance groups of
+// the instances with the given providerIDs, by providerID, "" for the
+// instances not in a group. The instances not found are omitted. The
+// instances are listed by zone, so that the periodic checks of all the nodes
+// do not get each instance.
+func (g *Cloud) InstanceGroupManagers(ctx context.Context, providerIDs []string) (map[string]string, error) {
+	instances, err := g.listInstancesByProviderID(ctx, providerIDs, "metadata(items(key,value))")
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]string, len(instances))
+	for providerID, instance := range instances {
+		groups[providerID] = instanceGroupManager(instance)
+	}
+	return groups, nil
+}
+
+// listInstancesByProviderID returns the instances with the given providerIDs,
+// by providerID, with only the given fields and their name. The instances not
+// found are omitted. Each zone is listed once, filtered by the longest common
+// prefix of the names of its instances.
+func (g *Cloud) listInstancesByProviderID(ctx context.Context, providerIDs []string, fields string) (map[string]*compute.Instance, error) {
+	providerIDsByZone := map[string]map[string]string{}
+	for _, providerID := range providerIDs {
+		_, zone, name, err := splitProviderID(providerID)
+		if err != nil {
+			klog.Warningf("Skipping invalid providerID %q: %v", providerID, err)
+			continue
+		}
+		if providerIDsByZone[zone] == nil {
+			providerIDsByZone[zone] = map[string]string{}
+		}
+		providerIDsByZone[zone][canonicalizeInstanceName(name)] = providerID
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
+	defer cancel()
+	found := map[string]*compute.Instance{}
+	for zone, byName := range providerIDsByZone {
+		prefix := ""
+		first := true
+		for name := range byName {
+			if first {
+				prefix, first = name, false
+				continue
+			}
+			for !strings.HasPrefix(name, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		mc := newInstancesMetricContext("list", zone)
+		instances, err := g.c.Instances().List(withListResponseFields(timeoutCtx, "name,"+fields), zone, filter.Regexp("name", regexp.QuoteMeta(prefix)+".*"))
+		if mc.Observe(err) != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			if providerID, ok := byName[instance.Name]; ok {
+				found[providerID] = instance
+			}
+		}
+	}
+	return found, nil
+}
+
+// InstanceLabels returns the GCE labels of the instance with the given
+// providerID, and their fingerprint to set them. It returns
+// cloudprovider.InstanceNotFound if the instance does not exist.
+func (g *Cloud) InstanceLabels(ctx context.Context, providerID string) (map[string]string, string, error) {
+	_, zone, name, err := splitProviderID(providerID)
+	if err != nil {
+		return nil, "", err
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
+	defer cancel()
+	mc := newInstancesMetricContext("get", zone)
+	instance, err := g.c.Instances().Get(timeoutCtx, meta.ZonalKey(canonicalizeInstanceName(name), zone))
+	mc.Observe(err)
+	if isNotFound(err) {
+		return nil, "", cloudprovider.InstanceNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return instance.Labels, instance.LabelFingerprint, nil
+}
+
+// InstancesLabels returns the GCE labels of the instances with the given
+// providerIDs, by providerID. The instances not found are omitted. The
+// instances are listed by zone, so that the periodic checks of all the nodes
+// do not get each instance.
+func (g *Cloud) InstancesLabels(ctx context.Context, providerIDs []string) (map[string]map[string]string, error) {
+	instances, err := g.listInstancesByProviderID(ctx, providerIDs, "labels")
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]map[string]string, len(instances))
+	for providerID, instance := range instances {
+		labels[providerID] = instance.Labels
+	}
+	return labels, nil
+}
+
+// SetInstanceLabels replaces the GCE labels of the instance with the given
+// providerID. fingerprint is the fingerprint of the labels returned by
+// InstanceLabels, the update fails if they changed since.
+func (g *Cloud) SetInstanceLabels(ctx context.Context, providerID string, labels map[string]string, fingerprint string) error {
+	_, zone, name, err := splitProviderID(providerID)
+	if err != nil {
+		return err
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
+	defer cancel()
+	req := &compute.InstancesSetLabelsRequest{Labels: labels, LabelFingerprint: fingerprint}
+	mc := newInstancesMetricContext("set_labels", zone)
+	return mc.Observe(g.doComputeOperation(timeoutCtx, "Instances", "SetLabels", func(projectID string) (*compute.Operation, error) {
+		return g.s.GA.Instances.SetLabels(projectID, zone, canonicalizeInstanceName(name), req).Context(timeoutCtx).Do()
+	}))
+}
+
+// acceleratorLabels returns the node labels describing the guest accelerators
+// of the instance, so that device-aware schedulers do not need to query the
+// compute API. Instances have a single accelerator type in practice; if there
+// are several, the first one is labeled.
+func acceleratorLabels(instance *compute.Instance) map[string]string {
+	for _, accelerator := range instance.GuestAccelerators {
+		if accelerator == nil || accelerator.AcceleratorCount <= 0 {
+			continue
+		}
+		return map[string]string{
+			AcceleratorTypeLabelKey:  lastComponent(accelerator.AcceleratorType),
+			AcceleratorCountLabelKey: strconv.FormatInt(accelerator.AcceleratorCount, 10),
+		}
+	}
+	return nil
+}
+
 // InstanceID returns the cloud provider ID of the node with the specified NodeName.
 func (g *Cloud) InstanceID(ctx context.Context, nodeName types.NodeName) (string, error) {
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	if g.useMetadataServer {
 		// Use metadata, if possible, to fetch ID. See issue #12000
 		if g.isCurrentInstance(instanceName) {
@@ -419,7 +727,7 @@ func (g *Cloud) InstanceID(ctx context.Context, nodeName types.NodeName) (string
 
 // InstanceType returns the type of the specified node with the specified NodeName.
 func (g *Cloud) InstanceType(ctx context.Context, nodeName types.NodeName) (string, error) {
-	instanceName := mapNodeNameToInstanceName(nodeName)
+	instanceName := MapNodeNameToInstanceName(nodeName)
 	if g.useMetadataServer {
 		// Use metadata, if possible, to fetch ID. See issue #12000
 		if g.isCurrentInstance(instanceName) {
@@ -519,7 +827,7 @@ func (g *Cloud) GetAllZonesFromCloudProvider() (sets.String, error) {
 
 	zones := sets.NewString()
 	for _, zone := range g.managedZones {
-		instances, err := g.c.Instances().List(ctx, zone, filter.None)
+		instances, err := g.c.Instances().List(withListResponseFields(ctx, instanceNameFields), zone, filter.None)
 		if err != nil {
 			return sets.NewString(), err
 		}
@@ -546,7 +854,7 @@ func (g *Cloud) ListInstanceNames(project, zone string) (string, error) {
 	ctx, cancel := cloud.ContextWithCallTimeout()
 	defer cancel()
 
-	l, err := g.c.Instances().List(ctx, zone, filter.None)
+	l, err := g.c.Instances().List(withListResponseFields(ctx, instanceNameFields), zone, filter.None)
 	if err != nil {
 		return "", err
 	}
@@ -681,9 +989,19 @@ func (g *Cloud) getFoundInstanceByNames(names []string) ([]*gceInstance, error)
 	found := map[string]*gceInstance{}
 	remaining := len(names)
 
+	// Only the zones of the nodes are listed when their instances are known,
+	// all the managed zones otherwise.
+	zones := sets.NewString()
+	allZones := false
 	nodeInstancePrefix := g.nodeInstancePrefix
 	for _, name := range names {
-		name = canonicalizeInstanceName(name)
+		if inst, ok := g.nodeInstances.get(name); ok {
+			name = inst.name
+			zones.Insert(inst.zone)
+		} else {
+			name = canonicalizeInstanceName(name)
+			allZones = true
+		}
 		if !strings.HasPrefix(name, g.nodeInstancePrefix) {
 			klog.Warningf("Instance %q does not conform to prefix %q, removing filter", name, g.nodeInstancePrefix)
 			nodeInstancePrefix = ""
@@ -691,11 +1009,19 @@ func (g *Cloud) getFoundInstanceByNames(names []string) ([]*gceInstance, error)
 		found[name] = nil
 	}
 
-	for _, zone := range g.managedZones {
+	// The instances of the nodes of the other regions, e.g. the backends of
+	// the load balancers pinned to another region, are only found in the
+	// zones of their providerID.
+	searchZones := append([]string{}, g.managedZones...)
+	searchZones = append(searchZones, zones.Difference(sets.NewString(g.managedZones...)).List()...)
+	for _, zone := range searchZones {
 		if remaining == 0 {
 			break
 		}
-		instances, err := g.c.Instances().List(ctx, zone, filter.Regexp("name", nodeInstancePrefix+".*"))
+		if !allZones && !zones.Has(zone) {
+			continue
+		}
+		instances, err := g.c.Instances().List(withListResponseFields(ctx, gceInstanceFields), zone, filter.Regexp("name", nodeInstancePrefix+".*"))
 		if err != nil {
 			return nil, err
 		}
@@ -711,11 +1037,12 @@ func (g *Cloud) getFoundInstanceByNames(names []string) ([]*gceInstance, error)
 				continue
 			}
 			found[inst.Name] = &gceInstance{
-				Zone:  zone,
-				Name:  inst.Name,
-				ID:    inst.Id,
-				Disks: inst.Disks,
-				Type:  lastComponent(inst.MachineType),
+				Zone:       zone,
+				Name:       inst.Name,
+				ID:         inst.Id,
+				Disks:      inst.Disks,
+				Type:       lastComponent(inst.MachineType),
+				Subnetwork: instanceSubnetwork(inst),
 			}
 			remaining--
 		}
@@ -739,6 +1066,18 @@ func (g *Cloud) getFoundInstanceByNames(names []string) ([]*gceInstance, error)
 
 // Gets the named instance, returning cloudprovider.InstanceNotFound if the instance is not found
 func (g *Cloud) getInstanceByName(name string) (*gceInstance, error) {
+	if inst, ok := g.nodeInstances.get(name); ok {
+		instance, err := g.getInstanceFromProjectInZoneByName(g.projectID, inst.zone, inst.name)
+		if err != nil {
+			if isHTTPErrorCode(err, http.StatusNotFound) {
+				return nil, cloudprovider.InstanceNotFound
+			}
+			klog.Errorf("getInstanceByName: failed to get instance %s of node %s in zone %s; err: %v", inst.name, name, inst.zone, err)
+			return nil, err
+		}
+		return instance, nil
+	}
+
 	// Avoid changing behaviour when not managing multiple zones
 	for _, zone := range g.managedZones {
 		instance, err := g.getInstanceFromProjectInZoneByName(g.projectID, zone, name)
@@ -761,20 +1100,30 @@ func (g *Cloud) getInstanceFromProjectInZoneByName(project, zone, name string) (
 
 	name = canonicalizeInstanceName(name)
 	mc := newInstancesMetricContext("get", zone)
-	res, err := g.c.Instances().Get(ctx, meta.ZonalKey(name, zone))
+	res, err := g.c.Instances().Get(withResponseFields(ctx, gceInstanceFields), meta.ZonalKey(name, zone))
 	mc.Observe(err)
 	if err != nil {
 		return nil, err
 	}
 	return &gceInstance{
-		Zone:  lastComponent(res.Zone),
-		Name:  res.Name,
-		ID:    res.Id,
-		Disks: res.Disks,
-		Type:  lastComponent(res.MachineType),
+		Zone:       lastComponent(res.Zone),
+		Name:       res.Name,
+		ID:         res.Id,
+		Disks:      res.Disks,
+		Type:       lastComponent(res.MachineType),
+		Subnetwork: instanceSubnetwork(res),
 	}, nil
 }
 
+// instanceSubnetwork returns the subnetwork URL of the primary network
+// interface of the instance.
+func instanceSubnetwork(instance *compute.Instance) string {
+	if len(instance.NetworkInterfaces) == 0 {
+		return ""
+	}
+	return instance.NetworkInterfaces[0].Subnetwork
+}
+
 func getInstanceIDViaMetadata() (string, error) {
 	result, err := metadata.Get("instance/hostname")
 	if err != nil {
@@ -847,7 +1196,7 @@ func (g *Cloud) computeHostTags(hosts []*gceInstance) ([]string, error) {
 		filt = filter.Regexp("name", nodeInstancePrefix+".*")
 	}
 	for zone, hostNames := range hostNamesByZone {
-		instances, err := g.c.Instances().List(ctx, zone, filt)
+		instances, err := g.c.Instances().List(withListResponseFields(ctx, instanceTagsFields), zone, filt)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,147 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"k8s.io/klog/v2"
+)
+
+// missingInstanceConfirmation confirms that the instances not found by a get
+// are missing before they are reported missing to the node lifecycle
+// controller, which then deletes their Nodes. A get may transiently not find
+// an instance, e.g. while it is being recreated or when the API is
+// inconsistent, and the Node is not recreated once deleted.
+type missingInstanceConfirmation struct {
+	// confirmations is the number of consecutive checks which must not find
+	// an instance.
+	confirmations int
+	// gracePeriod is the minimum duration between the first and the last of
+	// these checks.
+	gracePeriod time.Duration
+
+	lock sync.Mutex
+	// checks are the consecutive checks which did not find the instances,
+	// by provider ID.
+	checks map[string]*missingInstanceChecks
+}
+
+// missingInstanceChecksTTL is the time after which the checks of an instance
+// are forgotten if it is no longer checked, e.g. once its Node is deleted while
+// the node informer is not set.
+const missingInstanceChecksTTL = time.Hour
+
+// missingInstanceChecks are the consecutive checks which did not find an
+// instance.
+type missingInstanceChecks struct {
+	count int
+	first time.Time
+	last  time.Time
+	// missing is set once the instance is reported missing. It is reported
+	// missing until it is found or its Node is deleted.
+	missing bool
+}
+
+// validateMissingInstanceConfirmation validates the missing instance settings
+// of the cloud config.
+func validateMissingInstanceConfirmation(confirmations int, gracePeriod time.Duration) error {
+	if confirmations < 0 {
+		return fmt.Errorf("missing-instance-confirmations must not be negative: %d", confirmations)
+	}
+	if gracePeriod < 0 {
+		return fmt.Errorf("missing-instance-grace-period must not be negative: %v", gracePeriod)
+	}
+	return nil
+}
+
+// enabled returns true if the instances not found by a get are confirmed
+// missing, instead of being reported missing right away.
+func (m *missingInstanceConfirmation) enabled() bool {
+	return m.confirmations > 1 || m.gracePeriod > 0
+}
+
+// forgetMissingInstance forgets the checks which did not find the instance of
+// providerID, once it is found or its Node is deleted.
+func (g *Cloud) forgetMissingInstance(providerID string) {
+	m := &g.missingInstances
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.checks, providerID)
+}
+
+// confirmInstanceMissing returns true if the instance name of providerID,
+// which a get did not find, is missing. Each check confirms the get with a
+// list of the instances of the zone, served by another endpoint of the API.
+// The instance is missing once it was not found by the configured number of
+// consecutive checks, over the grace period, and stays missing until it is
+// found.
+func (g *Cloud) confirmInstanceMissing(providerID, zone, name string) (bool, error) {
+	m := &g.missingInstances
+	if !m.enabled() {
+		return true, nil
+	}
+
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+	mc := newInstancesMetricContext("list", zone)
+	instances, err := g.c.Instances().List(withListResponseFields(ctx, instanceNameFields), zone, filter.Regexp("name", canonicalizeInstanceName(name)))
+	if mc.Observe(err) != nil {
+		return false, err
+	}
+	if len(instances) > 0 {
+		klog.Warningf("confirmInstanceMissing(%s): Instance %s was not found by a get, but is listed in zone %s", providerID, name, zone)
+		g.forgetMissingInstance(providerID)
+		return false, nil
+	}
+
+	now := g.clock.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.checks == nil {
+		m.checks = map[string]*missingInstanceChecks{}
+	}
+	for id, checks := range m.checks {
+		if now.Sub(checks.last) > missingInstanceChecksTTL {
+			delete(m.checks, id)
+		}
+	}
+	checks, ok := m.checks[providerID]
+	if !ok {
+		checks = &missingInstanceChecks{first: now}
+		m.checks[providerID] = checks
+	}
+	checks.last = now
+	if checks.missing {
+		return true, nil
+	}
+	checks.count++
+	if checks.count < m.confirmations || now.Sub(checks.first) < m.gracePeriod {
+		klog.Infof("confirmInstanceMissing(%s): Instance %s not found by %d consecutive checks since %v, reported missing after %d checks over %v", providerID, name, checks.count, checks.first, m.confirmations, m.gracePeriod)
+		return false, nil
+	}
+	klog.Infof("confirmInstanceMissing(%s): Instance %s not found by %d consecutive checks since %v, reporting it missing", providerID, name, checks.count, checks.first)
+	checks.missing = true
+	return true, nil
+}
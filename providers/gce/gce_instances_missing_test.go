@@ -0,0 +1,95 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ga "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestInstanceExistsByProviderIDConfirmsMissingInstance(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	_, err = createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	gce.clock = fakeClock
+	gce.missingInstances.confirmations = 3
+	gce.missingInstances.gracePeriod = time.Minute
+	missing := fmt.Sprintf("gce://%s/%s/test-node-2", vals.ProjectID, vals.ZoneName)
+
+	exists := func(providerID string) bool {
+		exists, err := gce.InstanceExistsByProviderID(context.TODO(), providerID)
+		require.NoError(t, err)
+		return exists
+	}
+
+	// The instance is reported missing after 3 checks over a minute.
+	assert.True(t, exists(missing))
+	assert.True(t, exists(missing))
+	assert.True(t, exists(missing), "reported missing before the grace period")
+	fakeClock.Step(time.Minute)
+	assert.False(t, exists(missing))
+	// It stays missing until its Node is deleted.
+	assert.False(t, exists(missing))
+	gce.forgetMissingInstance(missing)
+	assert.True(t, exists(missing))
+	// The checks of the instances no longer checked are pruned.
+	fakeClock.Step(missingInstanceChecksTTL + time.Minute)
+	assert.True(t, exists(fmt.Sprintf("gce://%s/%s/test-node-3", vals.ProjectID, vals.ZoneName)))
+	assert.NotContains(t, gce.missingInstances.checks, missing)
+
+	// An instance not found by a get but listed is not missing.
+	existing := fmt.Sprintf("gce://%s/%s/test-node-1", vals.ProjectID, vals.ZoneName)
+	gce.c.(*cloud.MockGCE).MockInstances.GetHook = func(context.Context, *meta.Key, *cloud.MockInstances, ...cloud.Option) (bool, *ga.Instance, error) {
+		return true, nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	for i := 0; i < 3; i++ {
+		fakeClock.Step(time.Minute)
+		assert.True(t, exists(existing))
+	}
+	assert.Empty(t, gce.missingInstances.checks[existing])
+
+	// The instances are reported missing right away by default.
+	gce.missingInstances.confirmations = 0
+	gce.missingInstances.gracePeriod = 0
+	assert.False(t, exists(missing))
+}
+
+func TestValidateMissingInstanceConfirmation(t *testing.T) {
+	assert.NoError(t, validateMissingInstanceConfirmation(0, 0))
+	assert.NoError(t, validateMissingInstanceConfirmation(3, time.Minute))
+	assert.Error(t, validateMissingInstanceConfirmation(-1, 0))
+	assert.Error(t, validateMissingInstanceConfirmation(1, -time.Minute))
+}
@@ -21,11 +21,15 @@ package gce
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -33,6 +37,8 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
 )
 
 func TestInstanceExists(t *testing.T) {
@@ -73,6 +79,84 @@ func TestInstanceExists(t *testing.T) {
 	}
 }
 
+func TestInstanceShutdown(t *testing.T) {
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name        string
+		status      string
+		scheduling  *ga.Scheduling
+		shutdown    bool
+		expectedErr error
+	}{
+		{
+			name:     "running",
+			status:   "RUNNING",
+			shutdown: false,
+		},
+		{
+			name:     "terminated",
+			status:   "TERMINATED",
+			shutdown: true,
+		},
+		{
+			name:     "suspended",
+			status:   "SUSPENDED",
+			shutdown: true,
+		},
+		{
+			name:     "stopping",
+			status:   "STOPPING",
+			shutdown: false,
+		},
+		{
+			name:       "preempted spot",
+			status:     "STOPPING",
+			scheduling: &ga.Scheduling{ProvisioningModel: "SPOT"},
+			shutdown:   true,
+		},
+		{
+			name:       "preempted preemptible",
+			status:     "STOPPING",
+			scheduling: &ga.Scheduling{Preemptible: true},
+			shutdown:   true,
+		},
+		{
+			name:       "running spot",
+			status:     "RUNNING",
+			scheduling: &ga.Scheduling{ProvisioningModel: "SPOT"},
+			shutdown:   false,
+		},
+		{
+			name:        "not found",
+			expectedErr: cloudprovider.InstanceNotFound,
+		},
+	}
+
+	for i, test := range testcases {
+		t.Run(test.name, func(t *testing.T) {
+			name := fmt.Sprintf("shutdown-node-%d", i)
+			if test.status != "" {
+				err := gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &ga.Instance{
+					Name:       name,
+					Zone:       vals.ZoneName,
+					Status:     test.status,
+					Scheduling: test.scheduling,
+				})
+				require.NoError(t, err)
+			}
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/%s", gce.ProjectID(), vals.ZoneName, name)},
+			}
+			shutdown, err := gce.InstanceShutdown(context.TODO(), node)
+			assert.Equal(t, test.expectedErr, err, test.name)
+			assert.Equal(t, test.shutdown, shutdown, test.name)
+		})
+	}
+}
+
 func TestNodeAddresses(t *testing.T) {
 	gce, err := fakeGCECloud(DefaultTestClusterValues())
 	require.NoError(t, err)
@@ -557,3 +641,339 @@ func TestGetZone(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckNodeCloudNAT(t *testing.T) {
+	const (
+		networkURL     = "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/net"
+		subnetworkURL  = "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/subnetworks/subnet"
+		otherSubnetURL = "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/subnetworks/other"
+	)
+	uninitializedNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Spec: v1.NodeSpec{
+			ProviderID: "gce://test-project/us-central1-b/n1",
+			Taints:     []v1.Taint{{Key: cloudProviderUninitializedTaint, Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+	initializedNode := uninitializedNode.DeepCopy()
+	initializedNode.Spec.Taints = nil
+
+	testcases := []struct {
+		name        string
+		node        *v1.Node
+		external    bool
+		nats        []*ga.RouterNat
+		wantWarning bool
+	}{
+		{
+			name:        "private node without NAT",
+			node:        uninitializedNode,
+			wantWarning: true,
+		},
+		{
+			name:     "node with external IP",
+			node:     uninitializedNode,
+			external: true,
+		},
+		{
+			name: "NAT for all subnetworks",
+			node: uninitializedNode,
+			nats: []*ga.RouterNat{{Name: "nat", SourceSubnetworkIpRangesToNat: natAllSubnetworksAllIPRanges}},
+		},
+		{
+			name: "NAT for the node subnetwork",
+			node: uninitializedNode,
+			nats: []*ga.RouterNat{{
+				Name:                          "nat",
+				SourceSubnetworkIpRangesToNat: "LIST_OF_SUBNETWORKS",
+				Subnetworks:                   []*ga.RouterNatSubnetworkToNat{{Name: subnetworkURL, SourceIpRangesToNat: []string{"PRIMARY_IP_RANGE"}}},
+			}},
+		},
+		{
+			name: "NAT for the secondary ranges of the node subnetwork",
+			node: uninitializedNode,
+			nats: []*ga.RouterNat{{
+				Name:                          "nat",
+				SourceSubnetworkIpRangesToNat: "LIST_OF_SUBNETWORKS",
+				Subnetworks:                   []*ga.RouterNatSubnetworkToNat{{Name: subnetworkURL, SourceIpRangesToNat: []string{"LIST_OF_SECONDARY_IP_RANGES"}}},
+			}},
+			wantWarning: true,
+		},
+		{
+			name: "NAT for another subnetwork",
+			node: uninitializedNode,
+			nats: []*ga.RouterNat{{
+				Name:                          "nat",
+				SourceSubnetworkIpRangesToNat: "LIST_OF_SUBNETWORKS",
+				Subnetworks:                   []*ga.RouterNatSubnetworkToNat{{Name: otherSubnetURL, SourceIpRangesToNat: []string{"ALL_IP_RANGES"}}},
+			}},
+			wantWarning: true,
+		},
+		{
+			name: "node already initialized",
+			node: initializedNode,
+		},
+	}
+
+	for _, test := range testcases {
+		t.Run(test.name, func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			gce.AlphaFeatureGate = NewAlphaFeatureGate([]string{AlphaFeatureCloudNATCheck})
+			recorder := record.NewFakeRecorder(10)
+			gce.eventRecorder = recorder
+
+			nic := &ga.NetworkInterface{NetworkIP: "10.1.1.1", Network: networkURL, Subnetwork: subnetworkURL}
+			if test.external {
+				nic.AccessConfigs = []*ga.AccessConfig{{NatIP: "34.1.1.1"}}
+			}
+			mockGCE := gce.c.(*cloud.MockGCE)
+			err = mockGCE.Instances().Insert(context.TODO(), meta.ZonalKey("n1", "us-central1-b"), &ga.Instance{
+				Name:              "n1",
+				MachineType:       "zones/us-central1-b/machineTypes/e2-medium",
+				NetworkInterfaces: []*ga.NetworkInterface{nic},
+			})
+			require.NoError(t, err)
+			err = mockGCE.Routers().Insert(context.TODO(), meta.RegionalKey("router", vals.Region), &ga.Router{
+				Name:    "router",
+				Network: networkURL,
+				Nats:    test.nats,
+			})
+			require.NoError(t, err)
+
+			_, err = gce.InstanceMetadata(context.TODO(), test.node)
+			require.NoError(t, err)
+			select {
+			case event := <-recorder.Events:
+				if !test.wantWarning {
+					t.Errorf("unexpected event %q", event)
+				}
+				assert.Contains(t, event, "CloudNATMissing")
+			default:
+				if test.wantWarning {
+					t.Errorf("expected a CloudNATMissing event")
+				}
+			}
+		})
+	}
+}
+
+func TestInstanceGroupManager(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	createdBy := func(value string) *ga.Metadata {
+		return &ga.Metadata{Items: []*ga.MetadataItems{{Key: "created-by", Value: &value}}}
+	}
+	mockGCE := gce.c.(*cloud.MockGCE)
+	for name, metadata := range map[string]*ga.Metadata{
+		"in-group":     createdBy("projects/123/zones/us-central1-b/instanceGroupManagers/gke-cluster-pool-1-abcd-grp"),
+		"not-in-group": nil,
+		"other":        createdBy("projects/123/zones/us-central1-b/instances/creator"),
+	} {
+		err = mockGCE.Instances().Insert(context.TODO(), meta.ZonalKey(name, "us-central1-b"), &ga.Instance{
+			Name:              name,
+			MachineType:       "zones/us-central1-b/machineTypes/n1-standard-4",
+			NetworkInterfaces: []*ga.NetworkInterface{{NetworkIP: "10.1.1.1"}},
+			Metadata:          metadata,
+		})
+		require.NoError(t, err)
+	}
+
+	group, err := gce.InstanceGroupManager(context.TODO(), "gce://test-project/us-central1-b/in-group")
+	require.NoError(t, err)
+	assert.Equal(t, "gke-cluster-pool-1-abcd-grp", group)
+	for _, name := range []string{"not-in-group", "other"} {
+		group, err = gce.InstanceGroupManager(context.TODO(), "gce://test-project/us-central1-b/"+name)
+		require.NoError(t, err)
+		assert.Empty(t, group, name)
+	}
+	_, err = gce.InstanceGroupManager(context.TODO(), "gce://test-project/us-central1-b/missing")
+	assert.Equal(t, cloudprovider.InstanceNotFound, err)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "in-group"},
+		Spec:       v1.NodeSpec{ProviderID: "gce://test-project/us-central1-b/in-group"},
+	}
+	metadata, err := gce.InstanceMetadata(context.TODO(), node)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{InstanceGroupManagerLabelKey: "gke-cluster-pool-1-abcd-grp"}, metadata.AdditionalLabels)
+}
+
+func TestInstanceGroupManagers(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	createdBy := "projects/123/zones/us-central1-b/instanceGroupManagers/pool-1-grp"
+	mockGCE := gce.c.(*cloud.MockGCE)
+	for _, key := range []*meta.Key{
+		meta.ZonalKey("node-1", "us-central1-b"),
+		meta.ZonalKey("node-2", "us-central1-b"),
+		meta.ZonalKey("node-3", "us-central1-c"),
+	} {
+		instance := &ga.Instance{Name: key.Name}
+		if key.Name != "node-2" {
+			instance.Metadata = &ga.Metadata{Items: []*ga.MetadataItems{{Key: "created-by", Value: &createdBy}}}
+		}
+		require.NoError(t, mockGCE.Instances().Insert(context.TODO(), key, instance))
+	}
+
+	// Each zone is listed once, and the missing instances are omitted.
+	listed := map[string]int{}
+	mockGCE.MockInstances.ListHook = func(_ context.Context, zone string, _ *filter.F, _ *cloud.MockInstances, _ ...cloud.Option) (bool, []*ga.Instance, error) {
+		listed[zone]++
+		return false, nil, nil
+	}
+	groups, err := gce.InstanceGroupManagers(context.TODO(), []string{
+		"gce://test-project/us-central1-b/node-1",
+		"gce://test-project/us-central1-b/node-2",
+		"gce://test-project/us-central1-c/node-3",
+		"gce://test-project/us-central1-c/missing",
+		"invalid",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"gce://test-project/us-central1-b/node-1": "pool-1-grp",
+		"gce://test-project/us-central1-b/node-2": "",
+		"gce://test-project/us-central1-c/node-3": "pool-1-grp",
+	}, groups)
+	assert.Equal(t, map[string]int{"us-central1-b": 1, "us-central1-c": 1}, listed)
+}
+
+func TestInstanceMetadataAcceleratorLabels(t *testing.T) {
+	testcases := []struct {
+		name         string
+		accelerators []*ga.AcceleratorConfig
+		wantLabels   map[string]string
+	}{
+		{
+			name: "no accelerators",
+		},
+		{
+			name: "GPUs",
+			accelerators: []*ga.AcceleratorConfig{{
+				AcceleratorType:  "https://www.googleapis.com/compute/v1/projects/test-project/zones/us-central1-b/acceleratorTypes/nvidia-tesla-t4",
+				AcceleratorCount: 2,
+			}},
+			wantLabels: map[string]string{
+				AcceleratorTypeLabelKey:  "nvidia-tesla-t4",
+				AcceleratorCountLabelKey: "2",
+			},
+		},
+		{
+			name: "first accelerator with a count",
+			accelerators: []*ga.AcceleratorConfig{
+				{AcceleratorType: "zones/us-central1-b/acceleratorTypes/nvidia-l4"},
+				{AcceleratorType: "zones/us-central1-b/acceleratorTypes/nvidia-tesla-a100", AcceleratorCount: 8},
+			},
+			wantLabels: map[string]string{
+				AcceleratorTypeLabelKey:  "nvidia-tesla-a100",
+				AcceleratorCountLabelKey: "8",
+			},
+		},
+	}
+
+	for _, test := range testcases {
+		t.Run(test.name, func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+
+			mockGCE := gce.c.(*cloud.MockGCE)
+			err = mockGCE.Instances().Insert(context.TODO(), meta.ZonalKey("n1", "us-central1-b"), &ga.Instance{
+				Name:              "n1",
+				MachineType:       "zones/us-central1-b/machineTypes/n1-standard-4",
+				NetworkInterfaces: []*ga.NetworkInterface{{NetworkIP: "10.1.1.1"}},
+				GuestAccelerators: test.accelerators,
+			})
+			require.NoError(t, err)
+
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+				Spec:       v1.NodeSpec{ProviderID: "gce://test-project/us-central1-b/n1"},
+			}
+			metadata, err := gce.InstanceMetadata(context.TODO(), node)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantLabels, metadata.AdditionalLabels)
+		})
+	}
+}
+
+func TestInstanceLabels(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	mockGCE := gce.c.(*cloud.MockGCE)
+	err = mockGCE.Instances().Insert(context.TODO(), meta.ZonalKey("labeled", "us-central1-b"), &ga.Instance{
+		Name:             "labeled",
+		Labels:           map[string]string{"team": "billing"},
+		LabelFingerprint: "abcd",
+	})
+	require.NoError(t, err)
+
+	labels, fingerprint, err := gce.InstanceLabels(context.TODO(), "gce://test-project/us-central1-b/labeled")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "billing"}, labels)
+	assert.Equal(t, "abcd", fingerprint)
+	_, _, err = gce.InstanceLabels(context.TODO(), "gce://test-project/us-central1-b/missing")
+	assert.Equal(t, cloudprovider.InstanceNotFound, err)
+
+	var paths []string
+	var req ga.InstancesSetLabelsRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		op := &ga.Operation{Name: "op", Status: "RUNNING", SelfLink: gce.projectsBasePath + "test-project/zones/us-central1-b/operations/op"}
+		if strings.HasSuffix(r.URL.Path, "/wait") {
+			op.Status = "DONE"
+			json.NewEncoder(rw).Encode(op)
+			return
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		json.NewEncoder(rw).Encode(op)
+	}))
+	defer srv.Close()
+	SetFakeComputeEndpoint(gce, srv.URL+"/")
+	// The instances are in the configured project, whatever the project of
+	// the providerID.
+	err = gce.SetInstanceLabels(context.TODO(), "gce://other-project/us-central1-b/labeled", map[string]string{"team": "ads"}, "abcd")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/projects/test-project/zones/us-central1-b/instances/labeled/setLabels",
+		"/projects/test-project/zones/us-central1-b/operations/op/wait",
+	}, paths)
+	assert.Equal(t, map[string]string{"team": "ads"}, req.Labels)
+	assert.Equal(t, "abcd", req.LabelFingerprint)
+}
+
+func TestInstancesLabels(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	mockGCE := gce.c.(*cloud.MockGCE)
+	for _, key := range []*meta.Key{
+		meta.ZonalKey("node-1", "us-central1-b"),
+		meta.ZonalKey("node-2", "us-central1-c"),
+	} {
+		instance := &ga.Instance{Name: key.Name}
+		if key.Name == "node-1" {
+			instance.Labels = map[string]string{"team": "billing"}
+		}
+		require.NoError(t, mockGCE.Instances().Insert(context.TODO(), key, instance))
+	}
+
+	labels, err := gce.InstancesLabels(context.TODO(), []string{
+		"gce://test-project/us-central1-b/node-1",
+		"gce://test-project/us-central1-c/node-2",
+		"gce://test-project/us-central1-c/missing",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"gce://test-project/us-central1-b/node-1": {"team": "billing"},
+		"gce://test-project/us-central1-c/node-2": nil,
+	}, labels)
+}
@@ -0,0 +1,350 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// integrationRunPrefix prefixes the IDs of the integration test runs, see
+// gce_integration_test.go. The resources of a run are tagged with its ID, in
+// their name or in their description.
+const integrationRunPrefix = "ccmit-"
+
+// janitorResource is a resource swept by the integrationJanitor.
+type janitorResource struct {
+	key               *meta.Key
+	name              string
+	description       string
+	creationTimestamp string
+}
+
+// janitorKind lists and deletes the resources of a kind in the region and
+// the zones of the integration tests.
+type janitorKind struct {
+	name   string
+	list   func(ctx context.Context, c cloud.Cloud, region string, zones []string) ([]janitorResource, error)
+	delete func(ctx context.Context, c cloud.Cloud, key *meta.Key) error
+}
+
+// janitorKinds are the kinds of the resources created by the integration
+// tests, the users before the resources they use, in the order they must be
+// deleted.
+var janitorKinds = []janitorKind{
+	{
+		name: "forwarding rule",
+		list: func(ctx context.Context, c cloud.Cloud, region string, _ []string) ([]janitorResource, error) {
+			objs, err := c.ForwardingRules().List(ctx, region, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.RegionalKey(o.Name, region), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.ForwardingRules().Delete(ctx, key)
+		},
+	},
+	{
+		name: "target pool",
+		list: func(ctx context.Context, c cloud.Cloud, region string, _ []string) ([]janitorResource, error) {
+			objs, err := c.TargetPools().List(ctx, region, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.RegionalKey(o.Name, region), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.TargetPools().Delete(ctx, key)
+		},
+	},
+	{
+		name: "backend service",
+		list: func(ctx context.Context, c cloud.Cloud, region string, _ []string) ([]janitorResource, error) {
+			objs, err := c.RegionBackendServices().List(ctx, region, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.RegionalKey(o.Name, region), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.RegionBackendServices().Delete(ctx, key)
+		},
+	},
+	{
+		name: "health check",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, _ []string) ([]janitorResource, error) {
+			objs, err := c.HealthChecks().List(ctx, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.GlobalKey(o.Name), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.HealthChecks().Delete(ctx, key)
+		},
+	},
+	{
+		name: "HTTP health check",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, _ []string) ([]janitorResource, error) {
+			objs, err := c.HttpHealthChecks().List(ctx, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.GlobalKey(o.Name), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.HttpHealthChecks().Delete(ctx, key)
+		},
+	},
+	{
+		name: "instance group",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, zones []string) ([]janitorResource, error) {
+			var rs []janitorResource
+			for _, zone := range zones {
+				objs, err := c.InstanceGroups().List(ctx, zone, filter.None)
+				if err != nil {
+					return rs, err
+				}
+				for _, o := range objs {
+					rs = append(rs, janitorResource{meta.ZonalKey(o.Name, zone), o.Name, o.Description, o.CreationTimestamp})
+				}
+			}
+			return rs, nil
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.InstanceGroups().Delete(ctx, key)
+		},
+	},
+	{
+		name: "route",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, _ []string) ([]janitorResource, error) {
+			objs, err := c.Routes().List(ctx, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.GlobalKey(o.Name), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Routes().Delete(ctx, key)
+		},
+	},
+	{
+		name: "instance",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, zones []string) ([]janitorResource, error) {
+			var rs []janitorResource
+			for _, zone := range zones {
+				objs, err := c.Instances().List(ctx, zone, filter.None)
+				if err != nil {
+					return rs, err
+				}
+				for _, o := range objs {
+					rs = append(rs, janitorResource{meta.ZonalKey(o.Name, zone), o.Name, o.Description, o.CreationTimestamp})
+				}
+			}
+			return rs, nil
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Instances().Delete(ctx, key)
+		},
+	},
+	{
+		name: "firewall",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, _ []string) ([]janitorResource, error) {
+			objs, err := c.Firewalls().List(ctx, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.GlobalKey(o.Name), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Firewalls().Delete(ctx, key)
+		},
+	},
+	{
+		name: "address",
+		list: func(ctx context.Context, c cloud.Cloud, region string, _ []string) ([]janitorResource, error) {
+			objs, err := c.Addresses().List(ctx, region, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.RegionalKey(o.Name, region), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Addresses().Delete(ctx, key)
+		},
+	},
+	{
+		name: "subnetwork",
+		list: func(ctx context.Context, c cloud.Cloud, region string, _ []string) ([]janitorResource, error) {
+			objs, err := c.Subnetworks().List(ctx, region, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.RegionalKey(o.Name, region), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Subnetworks().Delete(ctx, key)
+		},
+	},
+	{
+		name: "network",
+		list: func(ctx context.Context, c cloud.Cloud, _ string, _ []string) ([]janitorResource, error) {
+			objs, err := c.Networks().List(ctx, filter.None)
+			var rs []janitorResource
+			for _, o := range objs {
+				rs = append(rs, janitorResource{meta.GlobalKey(o.Name), o.Name, o.Description, o.CreationTimestamp})
+			}
+			return rs, err
+		},
+		delete: func(ctx context.Context, c cloud.Cloud, key *meta.Key) error {
+			return c.Networks().Delete(ctx, key)
+		},
+	},
+}
+
+// integrationJanitor deletes the resources of the integration test runs
+// whose ID contains marker, in the region and the zones of the tests.
+type integrationJanitor struct {
+	c      cloud.Cloud
+	region string
+	zones  []string
+	marker string
+	// maxAge is the age of the resources beyond which they are deleted,
+	// leaked by a run that did not clean up. The resources of any age are
+	// deleted if zero.
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// sweep deletes the resources tagged with the marker of j older than its
+// maxAge, and returns the kinds and names of the deleted resources. The
+// resources failing to be deleted are reported and left for the next sweep.
+func (j *integrationJanitor) sweep(ctx context.Context) ([]string, error) {
+	var deleted []string
+	var errs []error
+	for _, kind := range janitorKinds {
+		resources, err := kind.list(ctx, j.c, j.region, j.zones)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list the %ss: %w", kind.name, err))
+		}
+		for _, r := range resources {
+			if !j.owns(r) {
+				continue
+			}
+			if err := kind.delete(ctx, j.c, r.key); err != nil && !isNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to delete %s %s: %w", kind.name, r.name, err))
+				continue
+			}
+			deleted = append(deleted, kind.name+" "+r.name)
+		}
+	}
+	return deleted, utilerrors.NewAggregate(errs)
+}
+
+// owns returns true if r is tagged with the marker of j and older than its
+// maxAge. The resources whose age is unknown are left alone.
+func (j *integrationJanitor) owns(r janitorResource) bool {
+	if !strings.Contains(r.name, j.marker) && !strings.Contains(r.description, j.marker) {
+		return false
+	}
+	if j.maxAge == 0 {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339, r.creationTimestamp)
+	return err == nil && j.now().Sub(created) >= j.maxAge
+}
+
+func TestIntegrationJanitorSweep(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	old, recent := now.Add(-4*time.Hour).Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339)
+
+	// The leaked resources of a run, its Service and its cluster.
+	run := integrationRunPrefix + "old"
+	require.NoError(t, gce.c.Networks().Insert(ctx, meta.GlobalKey(run), &compute.Network{Name: run, CreationTimestamp: old}))
+	require.NoError(t, gce.c.Subnetworks().Insert(ctx, meta.RegionalKey(run, vals.Region), &compute.Subnetwork{Name: run, CreationTimestamp: old}))
+	require.NoError(t, gce.c.Instances().Insert(ctx, meta.ZonalKey(run+"-node-0", vals.ZoneName), &compute.Instance{Name: run + "-node-0", CreationTimestamp: old}))
+	require.NoError(t, gce.c.Routes().Insert(ctx, meta.GlobalKey(run+"-route"), &compute.Route{Name: run + "-route", Description: k8sNodeRouteTag, CreationTimestamp: old}))
+	require.NoError(t, gce.c.ForwardingRules().Insert(ctx, meta.RegionalKey("a123", vals.Region), &compute.ForwardingRule{Name: "a123", Description: makeServiceDescription(run + "/svc"), CreationTimestamp: old}))
+	require.NoError(t, gce.c.HealthChecks().Insert(ctx, meta.GlobalKey(makeHealthCheckName("", run, true)), &compute.HealthCheck{Name: makeHealthCheckName("", run, true), CreationTimestamp: old}))
+	// The resources of a run still in progress, of another run of another
+	// cluster and of unknown age are kept.
+	current := integrationRunPrefix + "recent"
+	require.NoError(t, gce.c.Networks().Insert(ctx, meta.GlobalKey(current), &compute.Network{Name: current, CreationTimestamp: recent}))
+	require.NoError(t, gce.c.Firewalls().Insert(ctx, meta.GlobalKey("k8s-fw-a456"), &compute.Firewall{Name: "k8s-fw-a456", Description: makeServiceDescription("default/svc"), CreationTimestamp: old}))
+	require.NoError(t, gce.c.Routes().Insert(ctx, meta.GlobalKey(integrationRunPrefix+"unknown-route"), &compute.Route{Name: integrationRunPrefix + "unknown-route"}))
+
+	j := &integrationJanitor{
+		c:      gce.c,
+		region: vals.Region,
+		zones:  []string{vals.ZoneName},
+		marker: integrationRunPrefix,
+		maxAge: 3 * time.Hour,
+		now:    func() time.Time { return now },
+	}
+	deleted, err := j.sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"forwarding rule a123",
+		"health check " + makeHealthCheckName("", run, true),
+		"route " + run + "-route",
+		"instance " + run + "-node-0",
+		"subnetwork " + run,
+		"network " + run,
+	}, deleted)
+	_, err = gce.c.Networks().Get(ctx, meta.GlobalKey(current))
+	assert.NoError(t, err)
+	_, err = gce.c.Firewalls().Get(ctx, meta.GlobalKey("k8s-fw-a456"))
+	assert.NoError(t, err)
+	_, err = gce.c.Routes().Get(ctx, meta.GlobalKey(integrationRunPrefix+"unknown-route"))
+	assert.NoError(t, err)
+
+	// The resources of a run are all deleted at its end.
+	j.marker, j.maxAge = current, 0
+	deleted, err = j.sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"network " + current}, deleted)
+}
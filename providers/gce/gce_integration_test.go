@@ -0,0 +1,285 @@
+//go:build integration && !providerless
+// +build integration,!providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The integration tests run the load balancer, route and alias range code of
+// the provider against the GCE API of a real project, with the credentials
+// of the application default credentials:
+//
+//	go test -tags integration -run TestIntegration . -integration.project=<project>
+//
+// Each test creates its own VPC, subnetwork and instances, whose names or
+// descriptions are tagged with the random ID of its run, and deletes them at
+// its end. The resources leaked by the runs that did not clean up, e.g. when
+// interrupted, are deleted before the tests once older than
+// -integration.janitor-max-age:
+//
+//	go test -tags integration -run '^$' . -integration.project=<project>
+//
+// The tests are skipped without -integration.project.
+
+package gce
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+var (
+	integrationProject       = flag.String("integration.project", "", "The project the integration tests create their resources in. The integration tests are skipped if unset.")
+	integrationZone          = flag.String("integration.zone", "us-central1-b", "The zone of the instances of the integration tests.")
+	integrationJanitorMaxAge = flag.Duration("integration.janitor-max-age", 3*time.Hour, "The age beyond which the resources leaked by the integration test runs are deleted before the tests.")
+)
+
+// integrationJanitorOnce sweeps the leaked resources once per test binary,
+// before the first run.
+var integrationJanitorOnce sync.Once
+
+const (
+	// integrationRunIDChars are the characters of the random run IDs,
+	// valid in the names of all the GCE resources.
+	integrationRunIDChars = "bcdfghjklmnpqrstvwxz2456789"
+	// integrationSubnetRange and integrationSecondaryRange are the ranges of
+	// the subnetwork of each run, in its own VPC.
+	integrationSubnetRange    = "10.0.0.0/24"
+	integrationSecondaryRange = "10.4.0.0/16"
+)
+
+// integrationHarness is the GCE cloud of an integration test run, managing
+// the VPC of the run as the network of its cluster.
+type integrationHarness struct {
+	gce   *Cloud
+	runID string
+	zone  string
+}
+
+// newIntegrationHarness returns the harness of a new run of the test t, whose
+// resources are deleted at the end of t.
+func newIntegrationHarness(t *testing.T) *integrationHarness {
+	t.Helper()
+	if *integrationProject == "" {
+		t.Skip("-integration.project is not set")
+	}
+	ctx := context.Background()
+	region, err := GetGCERegion(*integrationZone)
+	require.NoError(t, err)
+
+	id := make([]byte, 8)
+	for i := range id {
+		id[i] = integrationRunIDChars[rand.Intn(len(integrationRunIDChars))]
+	}
+	runID := integrationRunPrefix + string(id)
+
+	gce, err := CreateGCECloud(&CloudConfig{
+		ProjectID:          *integrationProject,
+		NetworkProjectID:   *integrationProject,
+		Region:             region,
+		Zone:               *integrationZone,
+		ManagedZones:       []string{*integrationZone},
+		NetworkName:        runID,
+		SubnetworkName:     runID,
+		SecondaryRangeName: runID + "-pods",
+		NodeTags:           []string{runID},
+		NodeInstancePrefix: runID,
+		AlphaFeatureGate:   NewAlphaFeatureGate([]string{}),
+	})
+	require.NoError(t, err)
+	// The run is the cluster, whose ID is usually read from the cluster.
+	gce.ClusterID = fakeClusterID(runID)
+	gce.client = fake.NewSimpleClientset()
+	gce.eventRecorder = &record.FakeRecorder{}
+	gce.nodeInformerSynced = func() bool { return true }
+
+	integrationJanitorOnce.Do(func() {
+		j := &integrationJanitor{c: gce.c, region: region, zones: []string{*integrationZone}, marker: integrationRunPrefix, maxAge: *integrationJanitorMaxAge, now: time.Now}
+		deleted, err := j.sweep(ctx)
+		for _, name := range deleted {
+			t.Logf("Deleted leaked %s", name)
+		}
+		if err != nil {
+			t.Logf("Failed to delete the leaked resources: %v", err)
+		}
+	})
+	t.Cleanup(func() {
+		j := &integrationJanitor{c: gce.c, region: region, zones: []string{*integrationZone}, marker: runID, now: time.Now}
+		if _, err := j.sweep(context.Background()); err != nil {
+			t.Errorf("Failed to delete the resources of run %s: %v", runID, err)
+		}
+	})
+
+	require.NoError(t, gce.c.Networks().Insert(ctx, meta.GlobalKey(runID), &compute.Network{
+		Name:                  runID,
+		AutoCreateSubnetworks: false,
+		ForceSendFields:       []string{"AutoCreateSubnetworks"},
+	}))
+	require.NoError(t, gce.c.Subnetworks().Insert(ctx, meta.RegionalKey(runID, region), &compute.Subnetwork{
+		Name:        runID,
+		Network:     gce.NetworkURL(),
+		IpCidrRange: integrationSubnetRange,
+		SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{
+			{RangeName: runID + "-pods", IpCidrRange: integrationSecondaryRange},
+		},
+	}))
+	return &integrationHarness{gce: gce, runID: runID, zone: *integrationZone}
+}
+
+// createNode creates the instance of the node name of the run, and returns
+// the node.
+func (h *integrationHarness) createNode(t *testing.T, name string) *v1.Node {
+	t.Helper()
+	ctx := context.Background()
+	name = h.runID + "-" + name
+	require.NoError(t, h.gce.c.Instances().Insert(ctx, meta.ZonalKey(name, h.zone), &compute.Instance{
+		Name:         name,
+		MachineType:  fmt.Sprintf("zones/%s/machineTypes/e2-small", h.zone),
+		CanIpForward: true,
+		Tags:         &compute.Tags{Items: []string{h.runID}},
+		Disks: []*compute.AttachedDisk{{
+			Boot:             true,
+			AutoDelete:       true,
+			InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "projects/debian-cloud/global/images/family/debian-12"},
+		}},
+		NetworkInterfaces: []*compute.NetworkInterface{{Network: h.gce.NetworkURL(), Subnetwork: h.gce.SubnetworkURL()}},
+	}))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{v1.LabelTopologyZone: h.zone, v1.LabelTopologyRegion: h.gce.region},
+		},
+		Spec: v1.NodeSpec{ProviderID: fmt.Sprintf("gce://%s/%s/%s", h.gce.projectID, h.zone, name)},
+	}
+	node, err := h.gce.client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return node
+}
+
+// createService creates the LoadBalancer Service name of the run, of type
+// lbType, in the namespace named after the run.
+func (h *integrationHarness) createService(t *testing.T, name string, lbType LoadBalancerType) *v1.Service {
+	t.Helper()
+	svc := fakeLoadbalancerService(string(lbType))
+	svc.Namespace, svc.Name, svc.UID = h.runID, name, types.UID(h.runID+"-"+name)
+	svc, err := h.gce.client.CoreV1().Services(svc.Namespace).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return svc
+}
+
+func TestIntegrationExternalLoadBalancer(t *testing.T) {
+	t.Parallel()
+
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	nodes := []*v1.Node{h.createNode(t, "node-0")}
+	svc := h.createService(t, "elb", "")
+	lbName := h.gce.GetLoadBalancerName(ctx, h.runID, svc)
+
+	status, err := h.gce.EnsureLoadBalancer(ctx, h.runID, svc, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	fwdRule, err := h.gce.GetRegionForwardingRule(lbName, h.gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, status.Ingress[0].IP, fwdRule.IPAddress)
+	pool, err := h.gce.GetTargetPool(lbName, h.gce.region)
+	require.NoError(t, err)
+	assert.Len(t, pool.Instances, 1)
+
+	require.NoError(t, h.gce.EnsureLoadBalancerDeleted(ctx, h.runID, svc))
+	_, err = h.gce.GetRegionForwardingRule(lbName, h.gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule not deleted: %v", err)
+	_, err = h.gce.GetTargetPool(lbName, h.gce.region)
+	assert.True(t, isNotFound(err), "target pool not deleted: %v", err)
+}
+
+func TestIntegrationInternalLoadBalancer(t *testing.T) {
+	t.Parallel()
+
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	nodes := []*v1.Node{h.createNode(t, "node-0")}
+	svc := h.createService(t, "ilb", LBTypeInternal)
+	lbName := h.gce.GetLoadBalancerName(ctx, h.runID, svc)
+
+	status, err := h.gce.EnsureLoadBalancer(ctx, h.runID, svc, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	fwdRule, err := h.gce.GetRegionForwardingRule(lbName, h.gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, string(cloud.SchemeInternal), fwdRule.LoadBalancingScheme)
+	_, ipNet, err := net.ParseCIDR(integrationSubnetRange)
+	require.NoError(t, err)
+	assert.True(t, ipNet.Contains(net.ParseIP(status.Ingress[0].IP)), "IP %s not in the subnetwork range %s", status.Ingress[0].IP, integrationSubnetRange)
+
+	require.NoError(t, h.gce.EnsureLoadBalancerDeleted(ctx, h.runID, svc))
+	_, err = h.gce.GetRegionForwardingRule(lbName, h.gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule not deleted: %v", err)
+}
+
+func TestIntegrationRoutes(t *testing.T) {
+	t.Parallel()
+
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	node := h.createNode(t, "node-0")
+
+	route := &cloudprovider.Route{TargetNode: types.NodeName(node.Name), DestinationCIDR: "10.8.0.0/24"}
+	require.NoError(t, h.gce.CreateRoute(ctx, h.runID, "route-0", route))
+	routes, err := h.gce.ListRoutes(ctx, h.runID)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, route.TargetNode, routes[0].TargetNode)
+	assert.Equal(t, route.DestinationCIDR, routes[0].DestinationCIDR)
+
+	require.NoError(t, h.gce.DeleteRoute(ctx, h.runID, routes[0]))
+	routes, err = h.gce.ListRoutes(ctx, h.runID)
+	require.NoError(t, err)
+	assert.Empty(t, routes)
+}
+
+func TestIntegrationAliasRanges(t *testing.T) {
+	t.Parallel()
+
+	h := newIntegrationHarness(t)
+	node := h.createNode(t, "node-0")
+
+	// The Pod CIDR of the node is allocated from the secondary range of the
+	// subnetwork, as by the cloud CIDR allocator of nodeipam.
+	_, podCIDR, err := net.ParseCIDR("10.4.0.0/24")
+	require.NoError(t, err)
+	require.NoError(t, h.gce.AddAliasToInstanceByProviderID(node.Spec.ProviderID, podCIDR))
+	cidrs, err := h.gce.AliasRangesByProviderID(node.Spec.ProviderID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{podCIDR.String()}, cidrs)
+}
@@ -21,12 +21,14 @@ package gce
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"sort"
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
@@ -34,6 +36,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	cloudprovider "k8s.io/cloud-provider"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
 	netutils "k8s.io/utils/net"
 )
 
@@ -107,10 +110,18 @@ func L7LoadBalancerSrcRanges() []string {
 // GetLoadBalancer is an implementation of LoadBalancer.GetLoadBalancer
 func (g *Cloud) GetLoadBalancer(ctx context.Context, clusterName string, svc *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
-	fwd, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
+	region := g.loadBalancerRegion(svc)
+	fwd, err := g.GetRegionForwardingRule(loadBalancerName, region)
 	if err == nil {
 		status := &v1.LoadBalancerStatus{}
-		status.Ingress = []v1.LoadBalancerIngress{{IP: fwd.IPAddress}}
+		// Dual-stack load balancers expose their IPv6 VIP through a second forwarding rule.
+		var ipv6 string
+		if serviceRequestsIPv6(svc) {
+			if ipv6Fwd, err := g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), region); err == nil {
+				ipv6 = ipv6Fwd.IPAddress
+			}
+		}
+		status.Ingress = makeLoadBalancerIngress(svc, fwd.IPAddress, ipv6)
 
 		return status, true, nil
 	}
@@ -133,9 +144,23 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 	// GCE load balancers do not support services with LoadBalancerClass set. LoadBalancerClass can't be updated for an existing load balancer, so here we don't need to clean any resources.
 	// Check API documentation for .Spec.LoadBalancerClass for details on when this field is allowed to be changed.
 	if svc.Spec.LoadBalancerClass != nil {
-		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, svc.Spec.LoadBalancerClass)
+		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, *svc.Spec.LoadBalancerClass)
+		return nil, cloudprovider.ImplementedElsewhere
+	}
+	if scheme := getSvcScheme(svc); !loadBalancerSchemeEnabled(scheme) {
+		// The load balancer of the previous scheme of the Service, if any, is
+		// deleted before the Service is left to the other controller.
+		if !IsServiceReconcilePaused(svc) {
+			if err := g.EnsureLoadBalancerDeleted(ctx, clusterName, svc); err != nil && !errors.Is(err, cloudprovider.ImplementedElsewhere) {
+				return nil, err
+			}
+		}
+		klog.Infof("Ignoring service %s/%s, the %s load balancers are disabled by --%s.", svc.Namespace, svc.Name, scheme, loadBalancerSchemeFlag(scheme))
 		return nil, cloudprovider.ImplementedElsewhere
 	}
+	if IsServiceReconcilePaused(svc) {
+		return g.ensurePausedLoadBalancer(ctx, clusterName, svc, nodes)
+	}
 
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
 	desiredScheme := getSvcScheme(svc)
@@ -147,7 +172,7 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 	// Services with multiples protocols are not supported by this controller, warn the users and sets
 	// the corresponding Service Status Condition.
 	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-network/1435-mixed-protocol-lb
-	if err := checkMixedProtocol(svc.Spec.Ports); err != nil {
+	if err := checkServiceProtocols(svc); err != nil {
 		if hasLoadBalancerPortsError(svc) {
 			return nil, err
 		}
@@ -165,10 +190,28 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 		}
 		return nil, err
 	}
+	if err := g.validateLoadBalancerRegion(svc, desiredScheme, nodes); err != nil {
+		return nil, err
+	}
+	region := g.loadBalancerRegion(svc)
 
-	klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): ensure %v loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, desiredScheme)
+	klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): ensure %v loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, desiredScheme)
 
-	existingFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
+	// The load balancer is recreated in the region of the Service once its
+	// region changes, whatever its scheme is now, e.g. an internal load
+	// balancer of another region changed to an external one.
+	deleted, err := g.ensureLoadBalancerDeletedInPreviousRegion(clusterName, clusterID, svc, loadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		g.eventRecorder.Eventf(svc, v1.EventTypeNormal, "LoadBalancerRegionChanged", "Deleted the load balancer of the previous region, recreating it in region %s", region)
+	}
+	if err := g.checkpointLoadBalancerRegion(svc); err != nil {
+		return nil, err
+	}
+
+	existingFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, region)
 	if err != nil && !isNotFound(err) {
 		return nil, err
 	}
@@ -178,14 +221,14 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 
 		// If the loadbalancer type changes between INTERNAL and EXTERNAL, the old load balancer should be deleted.
 		if existingScheme != desiredScheme {
-			klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): deleting existing %v loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, existingScheme)
+			klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): deleting existing %v loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, existingScheme)
 			switch existingScheme {
 			case cloud.SchemeInternal:
 				err = g.ensureInternalLoadBalancerDeleted(clusterName, clusterID, svc)
 			default:
 				err = g.ensureExternalLoadBalancerDeleted(clusterName, clusterID, svc)
 			}
-			klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): done deleting existing %v loadbalancer. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, existingScheme, err)
+			klog.V(4).Infof("EnsureLoadBalancer(%v, %v, %v, %v, %v): done deleting existing %v loadbalancer. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, existingScheme, err)
 			if err != nil {
 				return nil, err
 			}
@@ -195,6 +238,8 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 		}
 	}
 
+	nodes = g.lbNodes.stabilize(nodes, lbNodesStabilizationWindow, g.clock.Now())
+	nodes = g.filterNodesInRegion(loadBalancerName, region, nodes)
 	var status *v1.LoadBalancerStatus
 	switch desiredScheme {
 	case cloud.SchemeInternal:
@@ -203,10 +248,11 @@ func (g *Cloud) EnsureLoadBalancer(ctx context.Context, clusterName string, svc
 		status, err = g.ensureExternalLoadBalancer(clusterName, clusterID, svc, existingFwdRule, nodes)
 	}
 	if err != nil {
-		klog.Errorf("Failed to EnsureLoadBalancer(%s, %s, %s, %s, %s), err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, err)
-		return status, err
+		klog.Errorf("Failed to EnsureLoadBalancer(%s, %s, %s, %s, %s), err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, err)
+		return status, g.describeGCEError(loadBalancerName, err)
 	}
-	klog.V(4).Infof("EnsureLoadBalancer(%s, %s, %s, %s, %s): done ensuring loadbalancer.", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region)
+	g.checkpointLoadBalancerResources(svc, loadBalancerName, clusterID)
+	klog.V(4).Infof("EnsureLoadBalancer(%s, %s, %s, %s, %s): done ensuring loadbalancer.", clusterName, svc.Namespace, svc.Name, loadBalancerName, region)
 	return status, err
 }
 
@@ -215,9 +261,17 @@ func (g *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, svc
 	// GCE load balancers do not support services with LoadBalancerClass set. LoadBalancerClass can't be updated for an existing load balancer, so here we don't need to clean any resources.
 	// Check API documentation for .Spec.LoadBalancerClass for details on when this field is allowed to be changed.
 	if svc.Spec.LoadBalancerClass != nil {
-		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, svc.Spec.LoadBalancerClass)
+		klog.Infof("Ignoring service %s/%s using load balancer class %s, it is not supported by this controller.", svc.Namespace, svc.Name, *svc.Spec.LoadBalancerClass)
+		return cloudprovider.ImplementedElsewhere
+	}
+	if scheme := getSvcScheme(svc); !loadBalancerSchemeEnabled(scheme) {
+		klog.Infof("Ignoring service %s/%s, the %s load balancers are disabled by --%s.", svc.Namespace, svc.Name, scheme, loadBalancerSchemeFlag(scheme))
 		return cloudprovider.ImplementedElsewhere
 	}
+	if IsServiceReconcilePaused(svc) {
+		g.reportPausedReconcile(ctx, "UpdateLoadBalancer", clusterName, svc, nodes)
+		return nil
+	}
 
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
 	scheme := getSvcScheme(svc)
@@ -229,7 +283,7 @@ func (g *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, svc
 	// Services with multiples protocols are not supported by this controller, warn the users and sets
 	// the corresponding Service Status Condition, but keep processing the Update to not break upgrades.
 	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-network/1435-mixed-protocol-lb
-	if err := checkMixedProtocol(svc.Spec.Ports); err != nil && !hasLoadBalancerPortsError(svc) {
+	if err := checkServiceProtocols(svc); err != nil && !hasLoadBalancerPortsError(svc) {
 		klog.Warningf("Ignoring update for service %s/%s using different ports protocols", svc.Namespace, svc.Name)
 		g.eventRecorder.Event(svc, v1.EventTypeWarning, v1.LoadBalancerPortsErrorReason, "LoadBalancer with multiple protocols are not supported.")
 		svcApplyStatus := corev1apply.ServiceStatus().WithConditions(
@@ -245,37 +299,96 @@ func (g *Cloud) UpdateLoadBalancer(ctx context.Context, clusterName string, svc
 		}
 	}
 
-	klog.V(4).Infof("UpdateLoadBalancer(%v, %v, %v, %v, %v): updating with %v nodes [node names limited, total number of nodes: %d]", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, loggableNodeNames(nodes), len(nodes))
+	if err := g.validateLoadBalancerRegion(svc, scheme, nodes); err != nil {
+		return err
+	}
+	region := g.loadBalancerRegion(svc)
+
+	klog.V(4).Infof("UpdateLoadBalancer(%v, %v, %v, %v, %v): updating with %v nodes [node names limited, total number of nodes: %d]", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, loggableNodeNames(nodes), len(nodes))
 
+	nodes = g.lbNodes.stabilize(nodes, lbNodesStabilizationWindow, g.clock.Now())
+	nodes = g.filterNodesInRegion(loadBalancerName, region, nodes)
 	switch scheme {
 	case cloud.SchemeInternal:
 		err = g.updateInternalLoadBalancer(clusterName, clusterID, svc, nodes)
 	default:
 		err = g.updateExternalLoadBalancer(clusterName, svc, nodes)
 	}
-	klog.V(4).Infof("UpdateLoadBalancer(%v, %v, %v, %v, %v): done updating. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, err)
-	return err
+	if err == nil {
+		g.checkpointLoadBalancerResources(svc, loadBalancerName, clusterID)
+	}
+	klog.V(4).Infof("UpdateLoadBalancer(%v, %v, %v, %v, %v): done updating. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, err)
+	return g.describeGCEError(loadBalancerName, err)
 }
 
 // EnsureLoadBalancerDeleted is an implementation of LoadBalancer.EnsureLoadBalancerDeleted.
 func (g *Cloud) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, svc *v1.Service) error {
 	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
-	scheme := getSvcScheme(svc)
+	region := g.loadBalancerRegion(svc)
+	// The scheme annotation of the Service may have changed since the load
+	// balancer was ensured, the scheme of the existing one is deleted.
+	scheme, err := g.existingLoadBalancerScheme(loadBalancerName, region, svc)
+	if err != nil {
+		return g.describeGCEError(loadBalancerName, err)
+	}
+	// The load balancers of a disabled scheme are deleted by the controller
+	// implementing them.
+	if !loadBalancerSchemeEnabled(scheme) {
+		klog.Infof("Ignoring deletion of service %s/%s, the %s load balancers are disabled by --%s.", svc.Namespace, svc.Name, scheme, loadBalancerSchemeFlag(scheme))
+		return cloudprovider.ImplementedElsewhere
+	}
+	// The deletion is retried until the reconciliation is resumed, so that
+	// the load balancer resources are not leaked.
+	if IsServiceReconcilePaused(svc) {
+		g.reportPausedReconcile(ctx, "EnsureLoadBalancerDeleted", clusterName, svc, nil)
+		return fmt.Errorf("reconcile of service %s/%s is paused by annotation %s=%s", svc.Namespace, svc.Name, ServiceAnnotationReconcile, ReconcilePaused)
+	}
 	clusterID, err := g.ClusterID.GetID()
 	if err != nil {
 		return err
 	}
 
-	klog.V(4).Infof("EnsureLoadBalancerDeleted(%v, %v, %v, %v, %v): deleting loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region)
+	klog.V(4).Infof("EnsureLoadBalancerDeleted(%v, %v, %v, %v, %v): deleting loadbalancer", clusterName, svc.Namespace, svc.Name, loadBalancerName, region)
 
-	switch scheme {
-	case cloud.SchemeInternal:
-		err = g.ensureInternalLoadBalancerDeleted(clusterName, clusterID, svc)
-	default:
-		err = g.ensureExternalLoadBalancerDeleted(clusterName, clusterID, svc)
+	// Failed deletions stay pending, they are checkpointed if the controller
+	// manager shuts down before a retry succeeds.
+	g.lbCleanups.start(loadBalancerName, clusterName, svc)
+	if err := g.quarantineLoadBalancerIP(loadBalancerName, clusterID, svc); err != nil {
+		return g.describeGCEError(loadBalancerName, err)
+	}
+	// The load balancer of the previous region is deleted too if the region
+	// of the Service changed since it was ensured.
+	if _, err = g.ensureLoadBalancerDeletedInPreviousRegion(clusterName, clusterID, svc, loadBalancerName); err == nil {
+		switch scheme {
+		case cloud.SchemeInternal:
+			err = g.ensureInternalLoadBalancerDeleted(clusterName, clusterID, svc)
+		default:
+			err = g.ensureExternalLoadBalancerDeleted(clusterName, clusterID, svc)
+		}
+	}
+	// The checkpointed resources left over, e.g. named by a previous
+	// version, are deleted last.
+	if err == nil {
+		err = g.ensureCheckpointedResourcesDeleted(svc, loadBalancerName, clusterID)
 	}
-	klog.V(4).Infof("EnsureLoadBalancerDeleted(%v, %v, %v, %v, %v): done deleting loadbalancer. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, g.region, err)
-	return err
+	if err == nil || err == cloudprovider.ImplementedElsewhere {
+		g.lbCleanups.done(loadBalancerName)
+	}
+	klog.V(4).Infof("EnsureLoadBalancerDeleted(%v, %v, %v, %v, %v): done deleting loadbalancer. err: %v", clusterName, svc.Namespace, svc.Name, loadBalancerName, region, err)
+	return g.describeGCEError(loadBalancerName, err)
+}
+
+// existingLoadBalancerScheme returns the scheme of the existing forwarding
+// rule of the load balancer, or the scheme of the Service if it has none.
+func (g *Cloud) existingLoadBalancerScheme(loadBalancerName, region string, svc *v1.Service) (cloud.LbScheme, error) {
+	fwdRule, err := g.GetRegionForwardingRule(loadBalancerName, region)
+	if isNotFound(err) {
+		return getSvcScheme(svc), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cloud.LbScheme(strings.ToUpper(fwdRule.LoadBalancingScheme)), nil
 }
 
 func getSvcScheme(svc *v1.Service) cloud.LbScheme {
@@ -285,6 +398,15 @@ func getSvcScheme(svc *v1.Service) cloud.LbScheme {
 	return cloud.SchemeExternal
 }
 
+// checkServiceProtocols checks if the Service Ports use different protocols,
+// unless they get a forwarding rule per protocol of an external load balancer.
+func checkServiceProtocols(svc *v1.Service) error {
+	if getSvcScheme(svc) == cloud.SchemeExternal && GetLoadBalancerAnnotationForwardingRulePerProtocol(svc) {
+		return nil
+	}
+	return checkMixedProtocol(svc.Spec.Ports)
+}
+
 // checkMixedProtocol checks if the Service Ports uses different protocols,
 // per examples, TCP and UDP.
 func checkMixedProtocol(ports []v1.ServicePort) error {
@@ -314,3 +436,34 @@ func hasLoadBalancerPortsError(service *v1.Service) bool {
 	}
 	return false
 }
+
+// setServiceCondition sets the condition of svc if it changed. The failures
+// are logged and returned, the callers already failing the sync of the load
+// balancer ignore them.
+func (g *Cloud) setServiceCondition(svc *v1.Service, condition metav1.Condition) error {
+	updated := svc.DeepCopy()
+	condition.ObservedGeneration = svc.Generation
+	if existing := apimeta.FindStatusCondition(svc.Status.Conditions, condition.Type); existing != nil &&
+		existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	apimeta.SetStatusCondition(&updated.Status.Conditions, condition)
+	return g.patchServiceCondition(svc, updated, condition.Type)
+}
+
+func (g *Cloud) removeServiceCondition(svc *v1.Service, conditionType string) error {
+	if apimeta.FindStatusCondition(svc.Status.Conditions, conditionType) == nil {
+		return nil
+	}
+	updated := svc.DeepCopy()
+	apimeta.RemoveStatusCondition(&updated.Status.Conditions, conditionType)
+	return g.patchServiceCondition(svc, updated, conditionType)
+}
+
+func (g *Cloud) patchServiceCondition(svc, updated *v1.Service, conditionType string) error {
+	if _, err := servicehelper.PatchService(g.client.CoreV1(), svc, updated); err != nil {
+		klog.Warningf("Failed to update the %s condition of Service %s/%s: %v", conditionType, svc.Namespace, svc.Name, err)
+		return fmt.Errorf("failed to update the %s condition of Service %s/%s: %w", conditionType, svc.Namespace, svc.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// AdoptForwardingRulesFlag is the flag allowing the external load balancers
+// to adopt the forwarding rules created outside of the cluster.
+const AdoptForwardingRulesFlag = "cloud-provider-gce-lb-adopt-forwarding-rules"
+
+// lbAdoptForwardingRules allows ServiceAnnotationLoadBalancerAdoptForwardingRule.
+// It is disabled by default, as anyone allowed to annotate a Service could
+// otherwise take over the forwarding rules of the project not managed by a
+// Service.
+var lbAdoptForwardingRules bool
+
+func init() {
+	flag.BoolVar(&lbAdoptForwardingRules, AdoptForwardingRulesFlag, false, "Allow the external L4 LBs of the Services annotated with "+ServiceAnnotationLoadBalancerAdoptForwardingRule+" to adopt the forwarding rules created outside of the cluster")
+}
+
+// adoptableForwardingRule returns the forwarding rule created outside of the
+// cluster which the external load balancer of the Service adopts, nil if
+// there is none. It is the existing forwarding rule named after the load
+// balancer if no Service manages it, or else the one holding the requested
+// IP. An error is returned if the forwarding rule holding the requested IP
+// cannot be adopted, as the load balancer cannot be created with the IP.
+//
+// The forwarding rules are only adopted with the adoption annotation, once
+// allowed by AdoptForwardingRulesFlag. The forwarding rule named after the
+// load balancer is otherwise updated as before.
+func (g *Cloud) adoptableForwardingRule(svc *v1.Service, loadBalancerName string, existingFwdRule *compute.ForwardingRule, requestedIP, fwdRuleIP string, ports []v1.ServicePort, netTier cloud.NetworkTier) (*compute.ForwardingRule, error) {
+	adopt := GetLoadBalancerAnnotationAdoptForwardingRule(svc)
+	if adopt && !lbAdoptForwardingRules {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "ForwardingRuleAdoptionDisabled", "Annotation %s is ignored, the adoption of forwarding rules is disabled by --%s", ServiceAnnotationLoadBalancerAdoptForwardingRule, AdoptForwardingRulesFlag)
+		adopt = false
+	}
+	if existingFwdRule != nil && forwardingRuleServiceName(existingFwdRule) == "" {
+		if !adopt {
+			return nil, nil
+		}
+		if err := forwardingRuleAdoptable(existingFwdRule, ports, netTier); err != nil {
+			return nil, err
+		}
+		return existingFwdRule, nil
+	}
+	if requestedIP == "" || requestedIP == fwdRuleIP {
+		return nil, nil
+	}
+
+	rules, err := g.ListRegionForwardingRules(g.region)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		// The main forwarding rule of the load balancer is named after it,
+		// the forwarding rules of its other protocols are prefixed by it.
+		if rule.IPAddress != requestedIP || rule.Name == loadBalancerName || strings.HasPrefix(rule.Name, loadBalancerName+"-") {
+			continue
+		}
+		if serviceName := forwardingRuleServiceName(rule); serviceName != "" {
+			return nil, fmt.Errorf("IP %s is used by forwarding rule %s of service %s", requestedIP, rule.Name, serviceName)
+		}
+		if !adopt {
+			if !lbAdoptForwardingRules {
+				return nil, fmt.Errorf("IP %s is used by forwarding rule %s created outside of the cluster, its adoption is disabled by --%s", requestedIP, rule.Name, AdoptForwardingRulesFlag)
+			}
+			return nil, fmt.Errorf("IP %s is used by forwarding rule %s created outside of the cluster, annotate the service with %s=true to adopt it", requestedIP, rule.Name, ServiceAnnotationLoadBalancerAdoptForwardingRule)
+		}
+		if err := forwardingRuleAdoptable(rule, ports, netTier); err != nil {
+			return nil, err
+		}
+		return rule, nil
+	}
+	return nil, nil
+}
+
+// forwardingRuleAdoptable returns an error if the forwarding rule does not
+// forward the ports of the Service the way its external load balancer does,
+// so that its adoption does not take over the traffic of another application.
+func forwardingRuleAdoptable(rule *compute.ForwardingRule, ports []v1.ServicePort, netTier cloud.NetworkTier) error {
+	if rule.LoadBalancingScheme != "" && rule.LoadBalancingScheme != string(cloud.SchemeExternal) {
+		return fmt.Errorf("forwarding rule %s cannot be adopted, its load balancing scheme is %s", rule.Name, rule.LoadBalancingScheme)
+	}
+	if rule.BackendService != "" || (rule.Target != "" && !strings.Contains(rule.Target, "/targetPools/")) {
+		return fmt.Errorf("forwarding rule %s cannot be adopted, it does not target a target pool", rule.Name)
+	}
+	if !strings.EqualFold(rule.IPProtocol, string(ports[0].Protocol)) {
+		return fmt.Errorf("forwarding rule %s cannot be adopted, its protocol %s is not the protocol %s of the service", rule.Name, rule.IPProtocol, ports[0].Protocol)
+	}
+	portRange, err := loadBalancerPortRange(ports)
+	if err != nil {
+		return err
+	}
+	if rule.PortRange != portRange {
+		return fmt.Errorf("forwarding rule %s cannot be adopted, its port range %s is not the port range %s of the service", rule.Name, rule.PortRange, portRange)
+	}
+	if ruleTier := forwardingRuleNetworkTier(rule); ruleTier != netTier {
+		return fmt.Errorf("forwarding rule %s cannot be adopted, its network tier %s is not the network tier %s of the service", rule.Name, ruleTier.ToGCEValue(), netTier.ToGCEValue())
+	}
+	return nil
+}
+
+// forwardingRuleServiceName returns the name of the Service managing the
+// forwarding rule, empty if the forwarding rule was created outside of the
+// cluster.
+func forwardingRuleServiceName(rule *compute.ForwardingRule) string {
+	d := &forwardingRuleDescription{}
+	if err := d.unmarshal(rule.Description); err != nil {
+		return ""
+	}
+	return d.ServiceName
+}
+
+// makeAdoptedServiceDescription returns the description of the forwarding rule
+// of the load balancer of the Service, recording the adopted forwarding rule.
+func makeAdoptedServiceDescription(svc *v1.Service, serviceName, adoptedName string) (string, error) {
+	fields, err := GetServiceAnnotationResourceDescription(svc)
+	if err != nil {
+		return "", err
+	}
+	d := &forwardingRuleDescription{ServiceName: serviceName, AdoptedForwardingRule: adoptedName, CustomFields: fields}
+	return d.marshal()
+}
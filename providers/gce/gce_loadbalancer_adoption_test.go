@@ -0,0 +1,211 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+
+	"k8s.io/client-go/tools/record"
+)
+
+const testAdoptedIP = "1.2.3.4"
+
+func setLBAdoptForwardingRules(t *testing.T, enabled bool) {
+	previous := lbAdoptForwardingRules
+	lbAdoptForwardingRules = enabled
+	t.Cleanup(func() { lbAdoptForwardingRules = previous })
+}
+
+func insertUnmanagedForwardingRule(t *testing.T, gce *Cloud, name string, mutate func(*compute.ForwardingRule)) {
+	rule := &compute.ForwardingRule{
+		Name:       name,
+		IPAddress:  testAdoptedIP,
+		IPProtocol: "TCP",
+		PortRange:  "123-123",
+		Target:     gce.targetPoolURL("tf-pool"),
+	}
+	if mutate != nil {
+		mutate(rule)
+	}
+	require.NoError(t, gce.CreateRegionForwardingRule(rule, gce.region))
+}
+
+func TestEnsureExternalLoadBalancerAdoptsForwardingRuleOfIP(t *testing.T) {
+	setLBAdoptForwardingRules(t, true)
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	insertUnmanagedForwardingRule(t, gce, "tf-rule", nil)
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.LoadBalancerIP = testAdoptedIP
+	svc.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] = "true"
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, testAdoptedIP, status.Ingress[0].IP)
+
+	_, err = gce.GetRegionForwardingRule("tf-rule", gce.region)
+	assert.True(t, isNotFound(err), "the adopted forwarding rule is deleted")
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	rule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, testAdoptedIP, rule.IPAddress)
+	assert.Contains(t, rule.Description, `"kubernetes.io/adopted-forwarding-rule":"tf-rule"`)
+	checkEvent(t, recorder, "Normal ForwardingRuleAdopted", true)
+}
+
+func TestEnsureExternalLoadBalancerAdoptsForwardingRuleOfName(t *testing.T) {
+	setLBAdoptForwardingRules(t, true)
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.eventRecorder = record.NewFakeRecorder(1024)
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.LoadBalancerIP = testAdoptedIP
+	svc.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] = "true"
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	insertUnmanagedForwardingRule(t, gce, lbName, nil)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	existing, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existing, nodes)
+	require.NoError(t, err)
+	rule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, gce.targetPoolURL(lbName), rule.Target, "the forwarding rule targets the target pool of the load balancer")
+	assert.Contains(t, rule.Description, `"kubernetes.io/adopted-forwarding-rule":"`+lbName+`"`)
+}
+
+func TestEnsureExternalLoadBalancerDoesNotAdoptForwardingRule(t *testing.T) {
+	setLBAdoptForwardingRules(t, true)
+
+	for _, tc := range []struct {
+		desc    string
+		adopt   bool
+		mutate  func(*compute.ForwardingRule)
+		wantErr string
+	}{
+		{
+			desc:    "no annotation",
+			wantErr: ServiceAnnotationLoadBalancerAdoptForwardingRule,
+		},
+		{
+			desc:    "other port range",
+			adopt:   true,
+			mutate:  func(r *compute.ForwardingRule) { r.PortRange = "80-80" },
+			wantErr: "cannot be adopted",
+		},
+		{
+			desc:    "other protocol",
+			adopt:   true,
+			mutate:  func(r *compute.ForwardingRule) { r.IPProtocol = "UDP" },
+			wantErr: "cannot be adopted",
+		},
+		{
+			desc:    "backend service",
+			adopt:   true,
+			mutate:  func(r *compute.ForwardingRule) { r.Target, r.BackendService = "", "tf-backend-service" },
+			wantErr: "cannot be adopted",
+		},
+		{
+			desc:  "other service",
+			adopt: true,
+			mutate: func(r *compute.ForwardingRule) {
+				r.Description = `{"kubernetes.io/service-name":"other/svc"}`
+			},
+			wantErr: "of service other/svc",
+		},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			insertUnmanagedForwardingRule(t, gce, "tf-rule", tc.mutate)
+
+			svc := fakeLoadbalancerService("")
+			svc.Spec.LoadBalancerIP = testAdoptedIP
+			if tc.adopt {
+				svc.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] = "true"
+			}
+			_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+			assert.ErrorContains(t, err, tc.wantErr)
+			_, err = gce.GetRegionForwardingRule("tf-rule", gce.region)
+			assert.NoError(t, err, "the forwarding rule is kept")
+		})
+	}
+}
+
+func TestEnsureExternalLoadBalancerAdoptionDisabled(t *testing.T) {
+	setLBAdoptForwardingRules(t, false)
+	vals := DefaultTestClusterValues()
+
+	t.Run("IP", func(t *testing.T) {
+		gce, err := fakeGCECloud(vals)
+		require.NoError(t, err)
+		recorder := record.NewFakeRecorder(1024)
+		gce.eventRecorder = recorder
+		insertUnmanagedForwardingRule(t, gce, "tf-rule", nil)
+
+		svc := fakeLoadbalancerService("")
+		svc.Spec.LoadBalancerIP = testAdoptedIP
+		svc.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] = "true"
+		_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+		assert.ErrorContains(t, err, AdoptForwardingRulesFlag)
+		checkEvent(t, recorder, "Warning ForwardingRuleAdoptionDisabled", true)
+		_, err = gce.GetRegionForwardingRule("tf-rule", gce.region)
+		assert.NoError(t, err, "the forwarding rule is kept")
+	})
+
+	t.Run("name", func(t *testing.T) {
+		gce, err := fakeGCECloud(vals)
+		require.NoError(t, err)
+		gce.eventRecorder = record.NewFakeRecorder(1024)
+
+		svc := fakeLoadbalancerService("")
+		svc.Annotations[ServiceAnnotationLoadBalancerAdoptForwardingRule] = "true"
+		lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+		insertUnmanagedForwardingRule(t, gce, lbName, nil)
+		nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+		require.NoError(t, err)
+		existing, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		require.NoError(t, err)
+
+		_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existing, nodes)
+		require.NoError(t, err)
+		rule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		require.NoError(t, err)
+		assert.NotContains(t, rule.Description, "kubernetes.io/adopted-forwarding-rule", "the forwarding rule is not recorded as adopted")
+	})
+}
@@ -0,0 +1,211 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LBCleanupCheckpointConfigMapName is the name of the ConfigMap, in
+	// UIDNamespace, persisting the load balancer deletions pending at shutdown.
+	LBCleanupCheckpointConfigMapName = "gce-lb-cleanup-checkpoint"
+)
+
+// pendingLBCleanup is a load balancer deletion which has not completed yet.
+type pendingLBCleanup struct {
+	ClusterName string      `json:"clusterName"`
+	Service     *v1.Service `json:"service"`
+}
+
+// lbCleanupTracker tracks the pending load balancer deletions by load
+// balancer name, so that they can be checkpointed at shutdown.
+type lbCleanupTracker struct {
+	lock    sync.Mutex
+	pending map[string]pendingLBCleanup
+}
+
+func (t *lbCleanupTracker) start(loadBalancerName, clusterName string, svc *v1.Service) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending == nil {
+		t.pending = map[string]pendingLBCleanup{}
+	}
+	svc = svc.DeepCopy()
+	svc.ManagedFields = nil
+	svc.Status = v1.ServiceStatus{}
+	t.pending[loadBalancerName] = pendingLBCleanup{ClusterName: clusterName, Service: svc}
+}
+
+func (t *lbCleanupTracker) done(loadBalancerName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.pending, loadBalancerName)
+}
+
+func (t *lbCleanupTracker) snapshot() map[string]pendingLBCleanup {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	pending := make(map[string]pendingLBCleanup, len(t.pending))
+	for name, cleanup := range t.pending {
+		pending[name] = cleanup
+	}
+	return pending
+}
+
+// CheckpointLoadBalancerCleanups persists the load balancer deletions which
+// have not completed, e.g. when the controller manager shuts down during
+// EnsureLoadBalancerDeleted. The checkpointed deletions are resumed at the
+// next startup, so that the load balancer resources are not leaked when the
+// Service is gone by then.
+func (g *Cloud) CheckpointLoadBalancerCleanups(ctx context.Context) error {
+	pending := g.lbCleanups.snapshot()
+	if len(pending) == 0 {
+		return nil
+	}
+	if g.client == nil {
+		return fmt.Errorf("cannot checkpoint %d pending load balancer cleanups before Initialize()", len(pending))
+	}
+
+	data := map[string]string{}
+	for name, cleanup := range pending {
+		b, err := json.Marshal(cleanup)
+		if err != nil {
+			return err
+		}
+		data[name] = string(b)
+	}
+
+	configMaps := g.client.CoreV1().ConfigMaps(UIDNamespace)
+	cm, err := configMaps.Get(ctx, LBCleanupCheckpointConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: LBCleanupCheckpointConfigMapName, Namespace: UIDNamespace},
+			Data:       data,
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else if err == nil {
+		// Keep the entries of a previous checkpoint that were not resumed yet.
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		for name, cleanup := range data {
+			cm.Data[name] = cleanup
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint %d pending load balancer cleanups: %w", len(pending), err)
+	}
+	klog.Infof("Checkpointed %d pending load balancer cleanups to %s/%s", len(pending), UIDNamespace, LBCleanupCheckpointConfigMapName)
+	return nil
+}
+
+// resumeLoadBalancerCleanupsWhenReady resumes the checkpointed load balancer
+// deletions once the cluster ID, needed to delete load balancers, is
+// initialized.
+func (g *Cloud) resumeLoadBalancerCleanupsWhenReady(stop <-chan struct{}) {
+	ctx := wait.ContextForChannel(stop)
+	err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := g.ClusterID.GetID()
+		return err == nil, nil
+	})
+	if err != nil {
+		return
+	}
+	if err := g.resumeLoadBalancerCleanups(ctx); err != nil {
+		klog.Errorf("Failed to resume the checkpointed load balancer cleanups: %v", err)
+	}
+}
+
+// resumeLoadBalancerCleanups deletes the load balancers of the checkpoint.
+// Deletions which fail are kept in the checkpoint, and the checkpoint is
+// removed once all of them succeed.
+func (g *Cloud) resumeLoadBalancerCleanups(ctx context.Context) error {
+	configMaps := g.client.CoreV1().ConfigMaps(UIDNamespace)
+	cm, err := configMaps.Get(ctx, LBCleanupCheckpointConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]string{}
+	for name, data := range cm.Data {
+		var cleanup pendingLBCleanup
+		if err := json.Unmarshal([]byte(data), &cleanup); err != nil || cleanup.Service == nil {
+			klog.Errorf("Dropping invalid checkpointed cleanup of load balancer %q: %v", name, err)
+			continue
+		}
+		svc := cleanup.Service
+		owned, err := g.serviceOwnsLoadBalancer(ctx, svc)
+		if err != nil {
+			klog.Errorf("Failed to get the Service %s/%s of checkpointed cleanup of load balancer %q: %v", svc.Namespace, svc.Name, name, err)
+			failed[name] = data
+			continue
+		}
+		if owned {
+			klog.Infof("Skipping checkpointed cleanup of load balancer %q, Service %s/%s requires it again", name, svc.Namespace, svc.Name)
+			continue
+		}
+		klog.Infof("Resuming checkpointed cleanup of load balancer %q of Service %s/%s", name, svc.Namespace, svc.Name)
+		if err := g.EnsureLoadBalancerDeleted(ctx, cleanup.ClusterName, svc); err != nil && err != cloudprovider.ImplementedElsewhere {
+			klog.Errorf("Failed to resume checkpointed cleanup of load balancer %q: %v", name, err)
+			failed[name] = data
+		}
+	}
+
+	if len(failed) == 0 {
+		err = configMaps.Delete(ctx, LBCleanupCheckpointConfigMapName, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	cm.Data = failed
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// serviceOwnsLoadBalancer returns true if the Service of a checkpointed
+// cleanup still exists and requires a load balancer. Its load balancer is
+// then reconciled by the service controller instead.
+func (g *Cloud) serviceOwnsLoadBalancer(ctx context.Context, svc *v1.Service) (bool, error) {
+	current, err := g.client.CoreV1().Services(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return current.UID == svc.UID && current.DeletionTimestamp == nil && current.Spec.Type == v1.ServiceTypeLoadBalancer, nil
+}
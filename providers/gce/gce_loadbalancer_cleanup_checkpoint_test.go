@@ -0,0 +1,93 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEnsureLoadBalancerDeletedTracksPendingCleanups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	assert.Empty(t, gce.lbCleanups.snapshot(), "completed deletions should not be pending")
+
+	// Nothing is checkpointed without pending deletions.
+	require.NoError(t, gce.CheckpointLoadBalancerCleanups(context.TODO()))
+	_, err = gce.client.CoreV1().ConfigMaps(UIDNamespace).Get(context.TODO(), LBCleanupCheckpointConfigMapName, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "expected no checkpoint, got %v", err)
+}
+
+func TestCheckpointAndResumeLoadBalancerCleanups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	ctx := context.TODO()
+
+	deleted := fakeLoadbalancerService("")
+	deleted.Name, deleted.UID = "deleted", types.UID("deleted-uid")
+	recreated := fakeLoadbalancerService("")
+	recreated.Name, recreated.UID = "recreated", types.UID("recreated-uid")
+	for _, svc := range []*v1.Service{deleted, recreated} {
+		_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+		require.NoError(t, err)
+		// Deletions interrupted by a shutdown.
+		gce.lbCleanups.start(gce.GetLoadBalancerName(ctx, vals.ClusterName, svc), vals.ClusterName, svc)
+	}
+	require.NoError(t, gce.CheckpointLoadBalancerCleanups(ctx))
+	cm, err := gce.client.CoreV1().ConfigMaps(UIDNamespace).Get(ctx, LBCleanupCheckpointConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, cm.Data, 2)
+
+	// The recreated Service requires its load balancer again.
+	_, err = gce.client.CoreV1().Services(recreated.Namespace).Create(ctx, recreated, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	restarted, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	restarted.c = gce.c
+	restarted.client = gce.client
+	require.NoError(t, restarted.resumeLoadBalancerCleanups(ctx))
+
+	_, err = restarted.GetTargetPool(restarted.GetLoadBalancerName(ctx, vals.ClusterName, deleted), restarted.region)
+	assert.True(t, isNotFound(err), "expected the load balancer of the deleted Service to be removed, got %v", err)
+	_, err = restarted.GetTargetPool(restarted.GetLoadBalancerName(ctx, vals.ClusterName, recreated), restarted.region)
+	assert.NoError(t, err, "expected the load balancer of the recreated Service to be kept")
+	_, err = restarted.client.CoreV1().ConfigMaps(UIDNamespace).Get(ctx, LBCleanupCheckpointConfigMapName, metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err), "expected the resumed checkpoint to be removed, got %v", err)
+}
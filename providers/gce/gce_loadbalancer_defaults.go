@@ -0,0 +1,126 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// LoadBalancerDefaults are the cluster-wide settings of the load balancers
+// created for Services. Each setting applies to the Services which do not
+// override it with the corresponding annotation, and its zero value keeps the
+// provider default.
+type LoadBalancerDefaults struct {
+	// NetworkTier is the default of NetworkTierAnnotationKey.
+	NetworkTier cloud.NetworkTier
+	// ILBSubnet is the default of ServiceAnnotationILBSubnet.
+	ILBSubnet string
+	// HealthCheck tunes the health checks of the load balancers.
+	HealthCheck HealthCheckDefaults
+}
+
+// HealthCheckDefaults tunes the health checks of the load balancers. As for
+// the provider defaults, existing health checks configured with larger values
+// keep them, so lowering a default only applies to new health checks. The
+// per-Service annotation settings are enforced on existing health checks.
+type HealthCheckDefaults struct {
+	CheckIntervalSec   int64
+	TimeoutSec         int64
+	HealthyThreshold   int64
+	UnhealthyThreshold int64
+}
+
+// Validate returns an error if the defaults can not be applied.
+func (d LoadBalancerDefaults) Validate() error {
+	switch d.NetworkTier {
+	case "", cloud.NetworkTierStandard, cloud.NetworkTierPremium:
+	default:
+		return fmt.Errorf("unsupported network tier: %q", d.NetworkTier)
+	}
+	hc := d.HealthCheck
+	if hc.CheckIntervalSec < 0 || hc.TimeoutSec < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		return fmt.Errorf("health check settings must not be negative: %+v", hc)
+	}
+	if hc.TimeoutSec > valueOrDefault(hc.CheckIntervalSec, gceHcCheckIntervalSeconds) {
+		return fmt.Errorf("health check timeout %ds is greater than the check interval", hc.TimeoutSec)
+	}
+	return nil
+}
+
+// SetLoadBalancerDefaults sets the defaults applied by the following load
+// balancer syncs. The defaults must be valid.
+func (g *Cloud) SetLoadBalancerDefaults(d LoadBalancerDefaults) {
+	g.lbDefaultsLock.Lock()
+	defer g.lbDefaultsLock.Unlock()
+	g.lbDefaults = d
+}
+
+// LoadBalancerDefaults returns the defaults applied by load balancer syncs.
+func (g *Cloud) LoadBalancerDefaults() LoadBalancerDefaults {
+	g.lbDefaultsLock.RLock()
+	defer g.lbDefaultsLock.RUnlock()
+	return g.lbDefaults
+}
+
+func (d HealthCheckDefaults) applyToHTTPHealthCheck(hc *compute.HttpHealthCheck) {
+	d.apply(&hc.CheckIntervalSec, &hc.TimeoutSec, &hc.HealthyThreshold, &hc.UnhealthyThreshold)
+}
+
+func (d HealthCheckDefaults) applyToHealthCheck(hc *compute.HealthCheck) {
+	d.apply(&hc.CheckIntervalSec, &hc.TimeoutSec, &hc.HealthyThreshold, &hc.UnhealthyThreshold)
+}
+
+// setOnHTTPHealthCheck returns true if the settings of d are set on hc.
+func (d HealthCheckDefaults) setOnHTTPHealthCheck(hc *compute.HttpHealthCheck) bool {
+	return d.setOn(hc.CheckIntervalSec, hc.TimeoutSec, hc.HealthyThreshold, hc.UnhealthyThreshold)
+}
+
+// setOnHealthCheck returns true if the settings of d are set on hc.
+func (d HealthCheckDefaults) setOnHealthCheck(hc *compute.HealthCheck) bool {
+	return d.setOn(hc.CheckIntervalSec, hc.TimeoutSec, hc.HealthyThreshold, hc.UnhealthyThreshold)
+}
+
+// apply sets the settings of d on the settings of a health check, the HTTP
+// health checks and the health checks have the same ones.
+func (d HealthCheckDefaults) apply(checkIntervalSec, timeoutSec, healthyThreshold, unhealthyThreshold *int64) {
+	*checkIntervalSec = valueOrDefault(d.CheckIntervalSec, *checkIntervalSec)
+	*timeoutSec = valueOrDefault(d.TimeoutSec, *timeoutSec)
+	*healthyThreshold = valueOrDefault(d.HealthyThreshold, *healthyThreshold)
+	*unhealthyThreshold = valueOrDefault(d.UnhealthyThreshold, *unhealthyThreshold)
+}
+
+// setOn returns true if the settings of d are the given settings of a health
+// check.
+func (d HealthCheckDefaults) setOn(checkIntervalSec, timeoutSec, healthyThreshold, unhealthyThreshold int64) bool {
+	return valueOrDefault(d.CheckIntervalSec, checkIntervalSec) == checkIntervalSec &&
+		valueOrDefault(d.TimeoutSec, timeoutSec) == timeoutSec &&
+		valueOrDefault(d.HealthyThreshold, healthyThreshold) == healthyThreshold &&
+		valueOrDefault(d.UnhealthyThreshold, unhealthyThreshold) == unhealthyThreshold
+}
+
+func valueOrDefault(v, def int64) int64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,123 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestLoadBalancerDefaultsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		defaults LoadBalancerDefaults
+		wantErr  bool
+	}{
+		{desc: "empty"},
+		{desc: "standard tier", defaults: LoadBalancerDefaults{NetworkTier: cloud.NetworkTierStandard}},
+		{desc: "unknown tier", defaults: LoadBalancerDefaults{NetworkTier: "Unknown-tier"}, wantErr: true},
+		{desc: "health check", defaults: LoadBalancerDefaults{HealthCheck: HealthCheckDefaults{CheckIntervalSec: 5, TimeoutSec: 5}}},
+		{desc: "negative threshold", defaults: LoadBalancerDefaults{HealthCheck: HealthCheckDefaults{UnhealthyThreshold: -1}}, wantErr: true},
+		{desc: "timeout greater than default interval", defaults: LoadBalancerDefaults{HealthCheck: HealthCheckDefaults{TimeoutSec: gceHcCheckIntervalSeconds + 1}}, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.defaults.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() = %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerDefaultsNetworkTier(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+	gce.SetLoadBalancerDefaults(LoadBalancerDefaults{NetworkTier: cloud.NetworkTierStandard})
+
+	svc := fakeLoadbalancerService("")
+	tier, err := gce.getServiceNetworkTier(svc)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.NetworkTierStandard, tier)
+
+	svc.Annotations[NetworkTierAnnotationKey] = string(cloud.NetworkTierPremium)
+	tier, err = gce.getServiceNetworkTier(svc)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.NetworkTierPremium, tier, "annotation should override the default")
+}
+
+func TestLoadBalancerDefaultsHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+	gce.SetLoadBalancerDefaults(LoadBalancerDefaults{HealthCheck: HealthCheckDefaults{CheckIntervalSec: 5, UnhealthyThreshold: 5}})
+
+	httpHC, err := gce.ensureHTTPHealthCheck("external-hc", GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), HealthCheckDefaults{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), httpHC.CheckIntervalSec)
+	assert.Equal(t, gceHcTimeoutSeconds, httpHC.TimeoutSec)
+	assert.Equal(t, gceHcHealthyThreshold, httpHC.HealthyThreshold)
+	assert.Equal(t, int64(5), httpHC.UnhealthyThreshold)
+
+	hc, err := gce.ensureInternalHealthCheck("internal-hc", types.NamespacedName{Name: "svc", Namespace: "default"}, true, GetNodesHealthCheckPath(), GetNodesHealthCheckPort(), false, HealthCheckDefaults{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), hc.CheckIntervalSec)
+	assert.Equal(t, gceHcTimeoutSeconds, hc.TimeoutSec)
+	assert.Equal(t, gceHcHealthyThreshold, hc.HealthyThreshold)
+	assert.Equal(t, int64(5), hc.UnhealthyThreshold)
+}
+
+func TestLoadBalancerDefaultsILBSubnet(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.SetLoadBalancerDefaults(LoadBalancerDefaults{ILBSubnet: "default-subnet"})
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(fwdRule.Subnetwork, "default-subnet"), "unexpected subnet %s in ILB ForwardingRule", fwdRule.Subnetwork)
+
+	svc.Annotations[ServiceAnnotationILBSubnet] = "test-subnet"
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(fwdRule.Subnetwork, "test-subnet"), "annotation should override the default, got subnet %s", fwdRule.Subnetwork)
+}
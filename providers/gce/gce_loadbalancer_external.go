@@ -26,6 +26,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	v1 "k8s.io/api/core/v1"
@@ -64,10 +65,21 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	if hasFinalizer(apiService, ELBRbsFinalizer) {
 		return nil, cloudprovider.ImplementedElsewhere
 	}
+	// The load balancers of the Services opted in to backend services are
+	// implemented with backend services by this controller too.
+	if usesExternalBackendService(apiService) {
+		return g.ensureExternalBackendServiceLoadBalancer(clusterName, clusterID, apiService, existingFwdRule, nodes)
+	}
 	// Skip service handling if it has Regional Backend Service created by Ingress-GCE
 	if existingFwdRule != nil && existingFwdRule.BackendService != "" {
 		return nil, cloudprovider.ImplementedElsewhere
 	}
+	if GetLoadBalancerAnnotationSecurityPolicy(apiService) != "" {
+		g.eventRecorder.Eventf(apiService, v1.EventTypeWarning, "SecurityPolicyNotSupported", "Annotation %s requires annotation %s, the target pools do not support security policies", ServiceAnnotationLoadBalancerSecurityPolicy, ServiceAnnotationLoadBalancerBackendService)
+	}
+	if _, ok := apiService.Annotations[ServiceAnnotationLoadBalancerSessionAffinity]; ok {
+		g.eventRecorder.Eventf(apiService, v1.EventTypeWarning, "SessionAffinityNotSupported", "Annotation %s requires annotation %s, the target pools keep the session affinity of the Service", ServiceAnnotationLoadBalancerSessionAffinity, ServiceAnnotationLoadBalancerBackendService)
+	}
 
 	if len(nodes) == 0 {
 		return nil, fmt.Errorf(errStrLbNoHosts)
@@ -98,16 +110,58 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		klog.Errorf("ensureExternalLoadBalancer(%s): Failed to get the desired network tier: %v.", lbRefStr, err)
 		return nil, err
 	}
+	netTier = externalNetworkTier(apiService, existingFwdRule, netTier)
 	klog.V(4).Infof("ensureExternalLoadBalancer(%s): Desired network tier %q.", lbRefStr, netTier)
+	fwdRuleDesc, err := makeServiceDescriptionWithFields(apiService, serviceName.String())
+	if err != nil {
+		klog.Errorf("ensureExternalLoadBalancer(%s): Failed to get the resource description: %v.", lbRefStr, err)
+		return nil, err
+	}
+
+	// The Services of a group share the IP of an address reserved for the
+	// group, which is then used as a requested IP. The IP of a forwarding
+	// rule of another network tier cannot be reserved in the desired one.
+	sharedIPGroup := GetLoadBalancerAnnotationSharedIP(apiService)
+	unlockSharedIP := func() {}
+	if sharedIPGroup != "" {
+		g.sharedIPLock.RLock()
+		unlockSharedIP = sync.OnceFunc(g.sharedIPLock.RUnlock)
+		defer unlockSharedIP()
+		existingIP := ""
+		if existingFwdRule != nil && forwardingRuleNetworkTier(existingFwdRule) == netTier {
+			existingIP = existingFwdRule.IPAddress
+		}
+		if requestedIP, err = g.ensureSharedIP(sharedIPGroup, clusterID, requestedIP, existingIP, netTier); err != nil {
+			return nil, err
+		}
+	}
+
 	// TODO: distinguish between unspecified and specified network tiers annotation properly in forwardingrule creation
 	// Only delete ForwardingRule when network tier annotation is specified, otherwise leave it only to avoid wrongful
 	// deletion against user intention when network tier annotation is not specified.
+	// The forwarding rules and the address of the other network tier are
+	// recreated in the desired one below, with a new IP.
 	if _, ok := apiService.Annotations[NetworkTierAnnotationKey]; ok {
-		g.deleteWrongNetworkTieredResources(loadBalancerName, lbRefStr, netTier)
+		if err := g.deleteWrongNetworkTieredResources(loadBalancerName, lbRefStr, netTier); err != nil {
+			return nil, err
+		}
+		if existingFwdRule != nil && forwardingRuleNetworkTier(existingFwdRule) != netTier {
+			existingFwdRule = nil
+		}
 	}
 
+	// The main forwarding rule gets the ports of one protocol, the ports of
+	// the other protocols if any get forwarding rules of their own.
+	mainProtocol := ""
+	if existingFwdRule != nil {
+		mainProtocol = existingFwdRule.IPProtocol
+	}
+	portGroups := portsByProtocol(ports, mainProtocol)
+	// The forwarding rules can only share a static IP.
+	sharesIP := len(portGroups) > 1
+
 	// Check if the forwarding rule exists, and if so, what its IP is.
-	fwdRuleExists, fwdRuleNeedsUpdate, fwdRuleIP, err := g.forwardingRuleNeedsUpdate(loadBalancerName, g.region, requestedIP, ports)
+	fwdRuleExists, fwdRuleNeedsUpdate, fwdRuleIP, err := g.forwardingRuleNeedsUpdate(loadBalancerName, g.region, requestedIP, portGroups[0])
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +169,33 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		klog.V(2).Infof("ensureExternalLoadBalancer(%s): Forwarding rule %v doesn't exist.", lbRefStr, loadBalancerName)
 	}
 
+	// A forwarding rule created outside of the cluster with the IP or the
+	// name of the load balancer is replaced by the one of the load balancer.
+	// The IP of a group is reserved for the group, it is not adopted.
+	var adoptedFwdRule *compute.ForwardingRule
+	if sharedIPGroup == "" {
+		if adoptedFwdRule, err = g.adoptableForwardingRule(apiService, loadBalancerName, existingFwdRule, requestedIP, fwdRuleIP, portGroups[0], netTier); err != nil {
+			return nil, err
+		}
+	}
+	if adoptedFwdRule != nil {
+		klog.Infof("ensureExternalLoadBalancer(%s): Adopting forwarding rule %s, IP %s.", lbRefStr, adoptedFwdRule.Name, adoptedFwdRule.IPAddress)
+		if requestedIP == "" {
+			requestedIP = adoptedFwdRule.IPAddress
+		}
+		// An ephemeral IP of the adopted forwarding rule is promoted to a
+		// static IP, so that it is kept once the forwarding rule is replaced.
+		fwdRuleIP = adoptedFwdRule.IPAddress
+		if adoptedFwdRule.Name == loadBalancerName {
+			// The forwarding rule is recreated with the target pool of the
+			// load balancer.
+			fwdRuleNeedsUpdate = true
+		}
+		if fwdRuleDesc, err = makeAdoptedServiceDescription(apiService, serviceName.String(), adoptedFwdRule.Name); err != nil {
+			return nil, err
+		}
+	}
+
 	// Make sure we know which IP address will be used and have properly reserved
 	// it as static before moving forward with the rest of our operations.
 	//
@@ -141,8 +222,18 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	// and key the flag values off of errors returned.
 	isUserOwnedIP := false // if this is set, we never release the IP
 	isSafeToReleaseIP := false
+	// The IP reserved for the lifetime of the Service is only released with
+	// its load balancer.
+	reserveIP := GetLoadBalancerAnnotationReserveIP(apiService) && requestedIP == "" && !sharesIP
+	if GetLoadBalancerAnnotationReserveIP(apiService) && !reserveIP {
+		g.eventRecorder.Eventf(apiService, v1.EventTypeWarning, "ReserveIPIgnored", "Annotation %s is ignored, the IP of the load balancer is requested or shared", ServiceAnnotationLoadBalancerReserveIP)
+	}
 	defer func() {
-		if isUserOwnedIP {
+		if isUserOwnedIP || reserveIP {
+			return
+		}
+		if sharesIP {
+			klog.V(4).Infof("ensureExternalLoadBalancer(%s): Keeping static IP %s shared by the forwarding rules.", lbRefStr, ipAddressToUse)
 			return
 		}
 		if isSafeToReleaseIP {
@@ -171,50 +262,70 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	if !isUserOwnedIP {
 		// If we are not using the user-owned IP, either promote the
 		// emphemeral IP used by the fwd rule, or create a new static IP.
-		ipAddr, existed, err := ensureStaticIP(g, loadBalancerName, serviceName.String(), g.region, fwdRuleIP, netTier)
+		// The forwarding rules sharing the IP are all bound to one address.
+		// The IP of a new load balancer may fall back to the Standard Tier,
+		// in which its forwarding rules are then created.
+		var ipAddr string
+		var existed bool
+		var ipTier cloud.NetworkTier
+		ipAddr, existed, ipTier, err = g.ensureExternalIP(apiService, lbRefStr, fwdRuleIP, netTier, func(netTier cloud.NetworkTier) (string, bool, error) {
+			switch {
+			case sharesIP:
+				return g.ensureForwardingRulesAddress(loadBalancerName, serviceName.String(), fwdRuleIP, netTier)
+			case reserveIP:
+				ipAddr, err := g.ensureReservedIP(loadBalancerName, serviceName, clusterID, fwdRuleIP, netTier)
+				return ipAddr, false, err
+			default:
+				return ensureStaticIP(g, loadBalancerName, serviceName.String(), g.region, fwdRuleIP, netTier)
+			}
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to ensure a static IP for load balancer (%s): %v", lbRefStr, err)
 		}
+		netTier = ipTier
 		klog.Infof("ensureExternalLoadBalancer(%s): Ensured IP address %s (tier: %s).", lbRefStr, ipAddr, netTier)
 		// If the IP was not owned by the user, but it already existed, it
 		// could indicate that the previous update cycle failed. We can use
 		// this IP and try to run through the process again, but we should
-		// not release the IP unless it is explicitly flagged as OK.
-		isSafeToReleaseIP = !existed
+		// not release the IP unless it is explicitly flagged as OK, or the
+		// forwarding rule holds it, e.g. once its reservation is no longer
+		// requested.
+		isSafeToReleaseIP = !existed || (!sharesIP && fwdRuleExists && g.staticIPHeldByForwardingRule(loadBalancerName, serviceName, clusterID, fwdRuleIP))
 		ipAddressToUse = ipAddr
+		if sharesIP && fwdRuleExists && fwdRuleIP != ipAddressToUse {
+			klog.Infof("ensureExternalLoadBalancer(%s): Forwarding rule IP %s differs from the IP %s shared by the forwarding rules, recreating it.", lbRefStr, fwdRuleIP, ipAddressToUse)
+			fwdRuleNeedsUpdate = true
+		}
 	}
 
-	// Deal with the firewall next. The reason we do this here rather than last
-	// is because the forwarding rule is used as the indicator that the load
-	// balancer is fully created - it's what getLoadBalancer checks for.
-	// Check if user specified the allow source range
-	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(apiService)
+	if sharedIPGroup != "" {
+		if err := g.checkSharedIPPorts(loadBalancerName, ipAddressToUse, portGroups); err != nil {
+			return nil, err
+		}
+	}
+
+	existingProtocolRules, err := g.listProtocolForwardingRules(loadBalancerName)
 	if err != nil {
 		return nil, err
 	}
-
-	firewallExists, firewallNeedsUpdate, err := g.firewallNeedsUpdate(loadBalancerName, serviceName.String(), ipAddressToUse, ports, sourceRanges)
+	protocolRules, err := protocolForwardingRules(loadBalancerName, ipAddressToUse, portGroups, existingProtocolRules)
+	if err != nil {
+		return nil, err
+	}
+	protocolRulesNeedUpdate := false
+	for _, rule := range protocolRules {
+		protocolRulesNeedUpdate = protocolRulesNeedUpdate || (rule.exists && rule.needsUpdate)
+	}
+	staleProtocolRulesDeleted, err := g.deleteStaleProtocolForwardingRules(lbRefStr, portGroups, existingProtocolRules)
 	if err != nil {
 		return nil, err
 	}
 
-	if firewallNeedsUpdate {
-		desc := makeFirewallDescription(serviceName.String(), ipAddressToUse)
-		// Unlike forwarding rules and target pools, firewalls can be updated
-		// without needing to be deleted and recreated.
-		if firewallExists {
-			klog.Infof("ensureExternalLoadBalancer(%s): Updating firewall.", lbRefStr)
-			if err := g.updateFirewall(apiService, MakeFirewallName(loadBalancerName), desc, ipAddressToUse, sourceRanges, ports, hosts); err != nil {
-				return nil, err
-			}
-			klog.Infof("ensureExternalLoadBalancer(%s): Updated firewall.", lbRefStr)
-		} else {
-			klog.Infof("ensureExternalLoadBalancer(%s): Creating firewall.", lbRefStr)
-			if err := g.createFirewall(apiService, MakeFirewallName(loadBalancerName), desc, ipAddressToUse, sourceRanges, ports, hosts); err != nil {
-				return nil, err
-			}
-			klog.Infof("ensureExternalLoadBalancer(%s): Created firewall.", lbRefStr)
-		}
+	// Deal with the firewall next. The reason we do this here rather than last
+	// is because the forwarding rule is used as the indicator that the load
+	// balancer is fully created - it's what getLoadBalancer checks for.
+	if err := g.ensureExternalFirewall(apiService, loadBalancerName, lbRefStr, ipAddressToUse, hosts); err != nil {
+		return nil, err
 	}
 
 	tpExists, tpNeedsRecreation, err := g.targetPoolNeedsRecreation(loadBalancerName, g.region, apiService.Spec.SessionAffinity)
@@ -232,8 +343,27 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	if err != nil && !isHTTPErrorCode(err, http.StatusNotFound) {
 		return nil, fmt.Errorf("error checking HTTP health check for load balancer (%s): %v", lbRefStr, err)
 	}
-	if path, healthCheckNodePort := servicehelpers.GetServiceHealthCheckPathPort(apiService); path != "" {
-		klog.V(4).Infof("ensureExternalLoadBalancer(%s): Service needs local traffic health checks on: %d%s.", lbRefStr, healthCheckNodePort, path)
+	if hcType, err := GetLoadBalancerAnnotationHealthCheckType(apiService); err != nil {
+		return nil, err
+	} else if hcType == HealthCheckTypeTCP {
+		g.eventRecorder.Eventf(apiService, v1.EventTypeWarning, "HealthCheckTypeUnsupported", "Annotation %s=%s is ignored, the target pools of the external load balancers only support HTTP health checks", ServiceAnnotationILBHealthCheckType, HealthCheckTypeTCP)
+	}
+	if GetLoadBalancerAnnotationHealthCheck(apiService) != "" {
+		g.eventRecorder.Eventf(apiService, v1.EventTypeWarning, "HealthCheckNotSupported", "Annotation %s requires annotation %s, the target pools only support the HTTP health checks of the provider", ServiceAnnotationLoadBalancerHealthCheck, ServiceAnnotationLoadBalancerBackendService)
+	}
+	if _, err := GetLoadBalancerAnnotationHealthCheckSettings(apiService); err != nil {
+		return nil, err
+	}
+	path, healthCheckNodePort := servicehelpers.GetServiceHealthCheckPathPort(apiService)
+	if path == "" && (hasNodesHealthCheckOverride(apiService) || hasHealthCheckSettingsOverride(apiService)) {
+		// The nodes health check overridden by the Service cannot be shared,
+		// the Service gets its own health check as for local traffic.
+		if path, healthCheckNodePort, err = GetLoadBalancerAnnotationNodesHealthCheck(apiService); err != nil {
+			return nil, err
+		}
+	}
+	if path != "" {
+		klog.V(4).Infof("ensureExternalLoadBalancer(%s): Service needs its own health checks on: %d%s.", lbRefStr, healthCheckNodePort, path)
 		if hcLocalTrafficExisting == nil {
 			// This logic exists to detect a transition for non-OnlyLocal to OnlyLocal service
 			// turn on the tpNeedsRecreation flag to delete/recreate fwdrule/tpool updating the
@@ -255,6 +385,25 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		}
 		hcToCreate = makeHTTPHealthCheck(MakeNodesHealthCheckName(clusterID), GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
 	}
+	// The recreation of the forwarding rule interrupts the traffic, it waits
+	// for the maintenance window of the Service if any.
+	changeDeferred := false
+	if fwdRuleExists && tpExists && (fwdRuleNeedsUpdate || tpNeedsRecreation || protocolRulesNeedUpdate) {
+		if changeDeferred, err = g.deferDisruptiveChange(apiService, "the recreation of the forwarding rule and target pool"); err != nil {
+			return nil, err
+		}
+		if changeDeferred {
+			fwdRuleNeedsUpdate, tpNeedsRecreation = false, false
+			for _, rule := range protocolRules {
+				// The missing forwarding rules are still created.
+				rule.needsUpdate = rule.needsUpdate && !rule.exists
+			}
+			if hcToDelete != nil {
+				// The health check is replaced with the target pool.
+				hcToCreate, hcToDelete = nil, nil
+			}
+		}
+	}
 	// Now we get to some slightly more interesting logic.
 	// First, neither target pools nor forwarding rules can be updated in place -
 	// they have to be deleted and recreated.
@@ -272,14 +421,31 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		}
 		klog.Infof("ensureExternalLoadBalancer(%s): Deleted forwarding rule.", lbRefStr)
 	}
+	for _, rule := range protocolRules {
+		if rule.exists && (rule.needsUpdate || tpNeedsRecreation) {
+			isSafeToReleaseIP = false
+			if err := g.DeleteRegionForwardingRule(rule.name, g.region); err != nil && !isNotFound(err) {
+				return nil, fmt.Errorf("failed to delete existing forwarding rule %s for load balancer (%s) update: %v", rule.name, lbRefStr, err)
+			}
+			rule.needsUpdate = true
+			klog.Infof("ensureExternalLoadBalancer(%s): Deleted forwarding rule %s.", lbRefStr, rule.name)
+		}
+	}
 
-	if err := g.ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation, apiService, loadBalancerName, clusterID, ipAddressToUse, hosts, hcToCreate, hcToDelete); err != nil {
+	if err := g.ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation, apiService, loadBalancerName, clusterID, ipAddressToUse, g.targetPoolHosts(loadBalancerName, hosts), hcToCreate, hcToDelete); err != nil {
 		return nil, err
 	}
 
 	if tpNeedsRecreation || fwdRuleNeedsUpdate {
+		if adoptedFwdRule != nil && adoptedFwdRule.Name != loadBalancerName {
+			isSafeToReleaseIP = false
+			if err := g.DeleteRegionForwardingRule(adoptedFwdRule.Name, g.region); err != nil && !isNotFound(err) {
+				return nil, fmt.Errorf("failed to delete adopted forwarding rule %s for load balancer (%s): %v", adoptedFwdRule.Name, lbRefStr, err)
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Deleted adopted forwarding rule %s.", lbRefStr, adoptedFwdRule.Name)
+		}
 		klog.Infof("ensureExternalLoadBalancer(%s): Creating forwarding rule, IP %s (tier: %s).", lbRefStr, ipAddressToUse, netTier)
-		if err := createForwardingRule(g, loadBalancerName, serviceName.String(), g.region, ipAddressToUse, g.targetPoolURL(loadBalancerName), ports, netTier); err != nil {
+		if err := createForwardingRule(g, loadBalancerName, fwdRuleDesc, g.region, ipAddressToUse, g.targetPoolURL(loadBalancerName), portGroups[0], netTier); err != nil {
 			return nil, fmt.Errorf("failed to create forwarding rule for load balancer (%s): %v", lbRefStr, err)
 		}
 		// End critical section.  It is safe to release the static IP (which
@@ -288,6 +454,37 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 		// preventing it from actually being released.
 		isSafeToReleaseIP = true
 		klog.Infof("ensureExternalLoadBalancer(%s): Created forwarding rule, IP %s.", lbRefStr, ipAddressToUse)
+		if adoptedFwdRule != nil {
+			g.eventRecorder.Eventf(apiService, v1.EventTypeNormal, "ForwardingRuleAdopted", "Adopted forwarding rule %s, IP %s", adoptedFwdRule.Name, ipAddressToUse)
+		}
+	}
+	for _, rule := range protocolRules {
+		if !rule.needsUpdate {
+			continue
+		}
+		klog.Infof("ensureExternalLoadBalancer(%s): Creating forwarding rule %s of the %s ports, IP %s (tier: %s).", lbRefStr, rule.name, rule.ports[0].Protocol, ipAddressToUse, netTier)
+		if err := createForwardingRule(g, rule.name, fwdRuleDesc, g.region, ipAddressToUse, g.targetPoolURL(loadBalancerName), rule.ports, netTier); err != nil {
+			return nil, fmt.Errorf("failed to create forwarding rule %s for load balancer (%s): %v", rule.name, lbRefStr, err)
+		}
+		klog.Infof("ensureExternalLoadBalancer(%s): Created forwarding rule %s, IP %s.", lbRefStr, rule.name, ipAddressToUse)
+	}
+	if staleProtocolRulesDeleted {
+		// The static IP is no longer shared, the main forwarding rule holds it.
+		isSafeToReleaseIP = true
+	}
+	// The forwarding rules use the shared IP, it cannot be released anymore.
+	unlockSharedIP()
+	if existingFwdRule != nil && existingFwdRule.IPAddress != ipAddressToUse {
+		// The previous IP may have been the last reference to a shared address.
+		g.sharedIPLock.Lock()
+		err := g.releaseUnusedSharedIPs(clusterID)
+		g.sharedIPLock.Unlock()
+		if err != nil {
+			klog.Errorf("ensureExternalLoadBalancer(%s): Failed to release the unused shared IPs: %v.", lbRefStr, err)
+		}
+	}
+	if changeDeferred {
+		return nil, errDisruptiveChangeDeferred
 	}
 
 	status := &v1.LoadBalancerStatus{}
@@ -296,12 +493,87 @@ func (g *Cloud) ensureExternalLoadBalancer(clusterName string, clusterID string,
 	return status, nil
 }
 
+// ensureExternalFirewall ensures the firewall allowing the traffic of the
+// external load balancer of svc to the hosts.
+func (g *Cloud) ensureExternalFirewall(svc *v1.Service, loadBalancerName, lbRefStr, ipAddress string, hosts []*gceInstance) error {
+	serviceName := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}.String()
+	ports := svc.Spec.Ports
+	// Check if user specified the allow source range
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	if err != nil {
+		return err
+	}
+	// GCE firewalls cannot mix address families, the IPv6 source ranges of
+	// the dual-stack Services are allowed by the firewall of their IPv6
+	// forwarding rule.
+	ipv4Ranges, err := ipv4SourceRanges(svc)
+	if err != nil {
+		return err
+	}
+	if len(ipv4Ranges) < len(sourceRanges) {
+		if len(ipv4Ranges) == 0 {
+			// Only IPv6 source ranges are allowed.
+			return g.deleteInternalFirewall(svc, loadBalancerName, MakeFirewallName(loadBalancerName))
+		}
+		if sourceRanges, err = utilnet.ParseIPNets(ipv4Ranges...); err != nil {
+			return err
+		}
+	}
+
+	consolidated := false
+	if g.FirewallConsolidationEnabled() {
+		if consolidated, err = g.consolidatedFirewallAllows(svc, sourceRanges.StringSlice(), ipAddress); err != nil {
+			return err
+		}
+	}
+	firewallExists, firewallNeedsUpdate := false, false
+	if consolidated {
+		klog.V(4).Infof("ensureExternalLoadBalancer(%s): Skipping firewall, the traffic is allowed by the consolidated firewall rules.", lbRefStr)
+	} else if GetLoadBalancerAnnotationFirewallMergeAllowed(svc) {
+		desc := makeFirewallDescription(serviceName, ipAddress)
+		if err := g.ensureMergedFirewall(svc, MakeFirewallName(loadBalancerName), desc, ipAddress, sourceRanges, ports, hosts); err != nil {
+			return err
+		}
+	} else {
+		firewallExists, firewallNeedsUpdate, err = g.firewallNeedsUpdate(loadBalancerName, serviceName, ipAddress, ports, sourceRanges)
+		if err != nil {
+			return err
+		}
+	}
+
+	if firewallNeedsUpdate {
+		desc := makeFirewallDescription(serviceName, ipAddress)
+		// Unlike forwarding rules and target pools, firewalls can be updated
+		// without needing to be deleted and recreated.
+		if firewallExists {
+			klog.Infof("ensureExternalLoadBalancer(%s): Updating firewall.", lbRefStr)
+			if err := g.updateFirewall(svc, MakeFirewallName(loadBalancerName), desc, ipAddress, sourceRanges, ports, hosts); err != nil {
+				return err
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Updated firewall.", lbRefStr)
+		} else {
+			klog.Infof("ensureExternalLoadBalancer(%s): Creating firewall.", lbRefStr)
+			if err := g.createFirewall(svc, MakeFirewallName(loadBalancerName), desc, ipAddress, sourceRanges, ports, hosts); err != nil {
+				return err
+			}
+			klog.Infof("ensureExternalLoadBalancer(%s): Created firewall.", lbRefStr)
+		}
+	}
+	if !g.FirewallConsolidationEnabled() {
+		return g.releaseConsolidatedFirewalls(ipAddress)
+	}
+	return nil
+}
+
 // updateExternalLoadBalancer is the external implementation of LoadBalancer.UpdateLoadBalancer.
 func (g *Cloud) updateExternalLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) error {
 	// Skip service update if it uses Regional Backend Services and handled by other controllers
 	if usesL4RBS(service, nil) {
 		return cloudprovider.ImplementedElsewhere
 	}
+	if usesExternalBackendService(service) {
+		return g.updateExternalBackendServiceLoadBalancer(clusterName, service, nodes)
+	}
 
 	hosts, err := g.getInstancesByNames(nodeNames(nodes))
 	if err != nil {
@@ -309,7 +581,7 @@ func (g *Cloud) updateExternalLoadBalancer(clusterName string, service *v1.Servi
 	}
 
 	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, service)
-	return g.updateTargetPool(loadBalancerName, hosts)
+	return g.updateTargetPool(loadBalancerName, g.targetPoolHosts(loadBalancerName, hosts))
 }
 
 // ensureExternalLoadBalancerDeleted is the external implementation of LoadBalancer.EnsureLoadBalancerDeleted
@@ -353,7 +625,10 @@ func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string,
 				g.raiseFirewallChangeNeededEvent(service, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
 				return nil
 			}
-			return err
+			if err != nil || g.FirewallConsolidationEnabled() {
+				return err
+			}
+			return g.releaseConsolidatedFirewalls(loadBalancerIPv4(service))
 		},
 		// Even though we don't hold on to static IPs for load balancers, it's
 		// possible that EnsureLoadBalancer left one around in a failed
@@ -369,6 +644,17 @@ func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string,
 			if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
 				return err
 			}
+			if err := g.deleteProtocolForwardingRules(loadBalancerName); err != nil {
+				return err
+			}
+			// The IP of the deleted forwarding rules may have been the last
+			// reference to a shared address.
+			g.sharedIPLock.Lock()
+			err := g.releaseUnusedSharedIPs(clusterID)
+			g.sharedIPLock.Unlock()
+			if err != nil {
+				return err
+			}
 			klog.Infof("ensureExternalLoadBalancerDeleted(%s): Deleting target pool.", lbRefStr)
 			if err := g.DeleteExternalTargetPoolAndChecks(service, loadBalancerName, g.region, clusterID, hcNames...); err != nil {
 				return err
@@ -379,6 +665,11 @@ func (g *Cloud) ensureExternalLoadBalancerDeleted(clusterName, clusterID string,
 	if errs != nil {
 		return utilerrors.Flatten(errs)
 	}
+	// The backend service of the load balancer is only deleted once its
+	// forwarding rule is.
+	if hasFinalizer(service, ELBBackendServiceFinalizer) {
+		return g.ensureExternalBackendServiceDeleted(service, loadBalancerName, clusterID)
+	}
 	return nil
 }
 
@@ -533,14 +824,27 @@ func (g *Cloud) ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation bool,
 			klog.Infof("ensureTargetPoolAndHealthCheck(%s): Updated target pool (with %d hosts).", lbRefStr, len(hosts)-maxTargetPoolCreateInstances)
 		}
 	} else if tpExists {
+		// The local traffic health check of a service is programmed before
+		// the membership of the target pool changes, so that GCE probes nodes
+		// being drained with the up-to-date check and marks them unhealthy
+		// before they leave the pool. The target pool is still updated if the
+		// health check fails, so that removed nodes do not linger.
+		var hcErr error
+		localHealthCheckFirst := hcToCreate != nil && hcToCreate.Name == loadBalancerName
+		if localHealthCheckFirst {
+			hcErr = g.ensureTargetPoolHealthCheck(svc, loadBalancerName, hcToCreate)
+		}
 		// Ensure hosts are updated even if there is no other changes required on target pool.
 		if err := g.updateTargetPool(loadBalancerName, hosts); err != nil {
 			return fmt.Errorf("failed to update target pool for load balancer (%s): %v", lbRefStr, err)
 		}
 		klog.Infof("ensureTargetPoolAndHealthCheck(%s): Updated target pool (with %d hosts).", lbRefStr, len(hosts))
-		if hcToCreate != nil {
-			if hc, err := g.ensureHTTPHealthCheck(hcToCreate.Name, hcToCreate.RequestPath, int32(hcToCreate.Port)); err != nil || hc == nil {
-				return fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", loadBalancerName, hcToCreate.Port, hcToCreate.RequestPath, err)
+		if hcErr != nil {
+			return hcErr
+		}
+		if hcToCreate != nil && !localHealthCheckFirst {
+			if err := g.ensureTargetPoolHealthCheck(svc, loadBalancerName, hcToCreate); err != nil {
+				return err
 			}
 		}
 	} else {
@@ -550,6 +854,29 @@ func (g *Cloud) ensureTargetPoolAndHealthCheck(tpExists, tpNeedsRecreation bool,
 	return nil
 }
 
+// ensureTargetPoolHealthCheck ensures the health check of the target pool of
+// the given load balancer matches hc.
+func (g *Cloud) ensureTargetPoolHealthCheck(svc *v1.Service, loadBalancerName string, hc *compute.HttpHealthCheck) error {
+	settings, err := targetPoolHealthCheckSettings(svc, loadBalancerName, hc)
+	if err != nil {
+		return err
+	}
+	if existing, err := g.ensureHTTPHealthCheck(hc.Name, hc.RequestPath, int32(hc.Port), settings); err != nil || existing == nil {
+		return fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", loadBalancerName, hc.Port, hc.RequestPath, err)
+	}
+	return nil
+}
+
+// targetPoolHealthCheckSettings returns the settings of the health check hc
+// of the target pool of svc overriding the cluster-wide ones, which only
+// apply to the health check of its own, not to the nodes health check.
+func targetPoolHealthCheckSettings(svc *v1.Service, loadBalancerName string, hc *compute.HttpHealthCheck) (HealthCheckDefaults, error) {
+	if hc.Name != loadBalancerName {
+		return HealthCheckDefaults{}, nil
+	}
+	return GetLoadBalancerAnnotationHealthCheckSettings(svc)
+}
+
 func (g *Cloud) createTargetPoolAndHealthCheck(svc *v1.Service, name, serviceName, ipAddress, region, clusterID string, hosts []*gceInstance, hc *compute.HttpHealthCheck) error {
 	// health check management is coupled with targetPools to prevent leaks. A
 	// target pool is the only thing that requires a health check, so we delete
@@ -567,9 +894,12 @@ func (g *Cloud) createTargetPoolAndHealthCheck(svc *v1.Service, name, serviceNam
 		if err := g.ensureHTTPHealthCheckFirewall(svc, serviceName, ipAddress, region, clusterID, hosts, hc.Name, int32(hc.Port), isNodesHealthCheck); err != nil {
 			return err
 		}
-		var err error
+		settings, err := targetPoolHealthCheckSettings(svc, name, hc)
+		if err != nil {
+			return err
+		}
 		hcRequestPath, hcPort := hc.RequestPath, hc.Port
-		if hc, err = g.ensureHTTPHealthCheck(hc.Name, hc.RequestPath, int32(hc.Port)); err != nil || hc == nil {
+		if hc, err = g.ensureHTTPHealthCheck(hc.Name, hc.RequestPath, int32(hc.Port), settings); err != nil || hc == nil {
 			return fmt.Errorf("failed to ensure health check for %v port %d path %v: %v", name, hcPort, hcRequestPath, err)
 		}
 		hcLinks = append(hcLinks, hc.SelfLink)
@@ -579,10 +909,14 @@ func (g *Cloud) createTargetPoolAndHealthCheck(svc *v1.Service, name, serviceNam
 	for _, host := range hosts {
 		instances = append(instances, host.makeComparableHostPath())
 	}
+	desc, err := makeServiceDescriptionWithFields(svc, serviceName)
+	if err != nil {
+		return err
+	}
 	klog.Infof("Creating targetpool %v with %d healthchecks", name, len(hcLinks))
 	pool := &compute.TargetPool{
 		Name:            name,
-		Description:     fmt.Sprintf(`{"kubernetes.io/service-name":"%s"}`, serviceName),
+		Description:     desc,
 		Instances:       instances,
 		SessionAffinity: translateAffinityType(svc.Spec.SessionAffinity),
 		HealthChecks:    hcLinks,
@@ -716,8 +1050,16 @@ func needToUpdateHTTPHealthChecks(hc, newHC *compute.HttpHealthCheck) bool {
 	return false
 }
 
-func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32) (hc *compute.HttpHealthCheck, err error) {
+// ensureHTTPHealthCheck creates or updates the HTTP health check name, tuned
+// by the cluster-wide defaults overridden by settings. The existing health
+// check keeps its larger values, unless they are set by settings.
+func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32, settings HealthCheckDefaults) (hc *compute.HttpHealthCheck, err error) {
 	newHC := makeHTTPHealthCheck(name, path, port)
+	g.LoadBalancerDefaults().HealthCheck.applyToHTTPHealthCheck(newHC)
+	settings.applyToHTTPHealthCheck(newHC)
+	if newHC.TimeoutSec > newHC.CheckIntervalSec {
+		return nil, fmt.Errorf("timeout %ds of health check %s is greater than its check interval %ds", newHC.TimeoutSec, name, newHC.CheckIntervalSec)
+	}
 	hc, err = g.GetHTTPHealthCheck(name)
 	if hc == nil || err != nil && isHTTPErrorCode(err, http.StatusNotFound) {
 		klog.Infof("Did not find health check %v, creating port %v path %v", name, port, path)
@@ -734,9 +1076,10 @@ func (g *Cloud) ensureHTTPHealthCheck(name, path string, port int32) (hc *comput
 	}
 	// Validate health check fields
 	klog.V(4).Infof("Checking http health check params %s", name)
-	if needToUpdateHTTPHealthChecks(hc, newHC) {
+	if needToUpdateHTTPHealthChecks(hc, newHC) || !settings.setOnHTTPHealthCheck(hc) {
 		klog.Warningf("Health check %v exists but parameters have drifted - updating...", name)
 		mergeHTTPHealthChecks(hc, newHC)
+		settings.applyToHTTPHealthCheck(newHC)
 		if err := g.UpdateHTTPHealthCheck(newHC); err != nil {
 			klog.Warningf("Failed to reconcile http health check %v parameters", name)
 			return nil, err
@@ -764,31 +1107,41 @@ func (g *Cloud) forwardingRuleNeedsUpdate(name, region string, loadBalancerIP st
 		// We never want to end up recreating resources because g api flaked.
 		return true, false, "", fmt.Errorf("error getting load balancer's forwarding rule: %v", err)
 	}
+	needsUpdate, err = fwdRuleNeedsUpdate(fwd, loadBalancerIP, ports)
+	if err != nil {
+		return true, false, "", err
+	}
+	return true, needsUpdate, fwd.IPAddress, nil
+}
+
+// fwdRuleNeedsUpdate returns whether the existing forwarding rule fwd needs
+// to be updated for the given IP and ports.
+func fwdRuleNeedsUpdate(fwd *compute.ForwardingRule, loadBalancerIP string, ports []v1.ServicePort) (bool, error) {
 	// If the user asks for a specific static ip through the Service spec,
 	// check that we're actually using it.
 	// TODO: we report loadbalancer IP through status, so we want to verify if
 	// that matches the forwarding rule as well.
 	if loadBalancerIP != "" && loadBalancerIP != fwd.IPAddress {
 		klog.Infof("LoadBalancer ip for forwarding rule %v was expected to be %v, but was actually %v", fwd.Name, fwd.IPAddress, loadBalancerIP)
-		return true, true, fwd.IPAddress, nil
+		return true, nil
 	}
 	portRange, err := loadBalancerPortRange(ports)
 	if err != nil {
 		// Err on the side of caution in case of errors. Caller should notice the error and retry.
 		// We never want to end up recreating resources because g api flaked.
-		return true, false, "", err
+		return false, err
 	}
 	if portRange != fwd.PortRange {
 		klog.Infof("LoadBalancer port range for forwarding rule %v was expected to be %v, but was actually %v", fwd.Name, fwd.PortRange, portRange)
-		return true, true, fwd.IPAddress, nil
+		return true, nil
 	}
 	// The service controller verified all the protocols match on the ports, just check the first one
 	if string(ports[0].Protocol) != fwd.IPProtocol {
 		klog.Infof("LoadBalancer protocol for forwarding rule %v was expected to be %v, but was actually %v", fwd.Name, fwd.IPProtocol, string(ports[0].Protocol))
-		return true, true, fwd.IPAddress, nil
+		return true, nil
 	}
 
-	return true, false, fwd.IPAddress, nil
+	return false, nil
 }
 
 // Doesn't check whether the hosts have changed, since host updating is handled
@@ -845,6 +1198,10 @@ func hostURLToComparablePath(hostURL string) string {
 	return hostURL[idx:]
 }
 
+// loadBalancerPortRange returns the port range of the forwarding rules of the
+// external load balancers, from the lowest to the highest port. Unlike the
+// list of ports of the internal forwarding rules, a port range is not limited
+// to 5 ports, the ports in between are not allowed by the firewall.
 func loadBalancerPortRange(ports []v1.ServicePort) (string, error) {
 	if len(ports) == 0 {
 		return "", fmt.Errorf("no ports specified for GCE load balancer")
@@ -892,19 +1249,26 @@ func (g *Cloud) firewallNeedsUpdate(name, serviceName, ipAddress string, ports [
 	if fw.Description != makeFirewallDescription(serviceName, ipAddress) {
 		return true, true, nil
 	}
-	if len(fw.Allowed) != 1 || (fw.Allowed[0].IPProtocol != "tcp" && fw.Allowed[0].IPProtocol != "udp") {
+	// The ports of each protocol are allowed by an entry of their own.
+	portGroups := portsByProtocol(ports, "")
+	if len(fw.Allowed) != len(portGroups) {
 		return true, true, nil
 	}
-	// Make sure the allowed ports match.
-	portNums, portRanges, _ := getPortsAndProtocol(ports)
-	// This logic checks if the existing firewall rules contains either enumerated service ports or port ranges.
-	// This is to prevent unnecessary noop updates to the firewall rule when the existing firewall rule is
-	// set up via the previous pattern using enumerated ports instead of port ranges.
-	if !equalStringSets(portNums, fw.Allowed[0].Ports) && !equalStringSets(portRanges, fw.Allowed[0].Ports) {
-		return true, true, nil
+	for i, protocolPorts := range portGroups {
+		allowed := fw.Allowed[i]
+		if allowed.IPProtocol != strings.ToLower(string(protocolPorts[0].Protocol)) {
+			return true, true, nil
+		}
+		// Make sure the allowed ports match.
+		portNums, portRanges, _ := getPortsAndProtocol(protocolPorts)
+		// This logic checks if the existing firewall rules contains either enumerated service ports or port ranges.
+		// This is to prevent unnecessary noop updates to the firewall rule when the existing firewall rule is
+		// set up via the previous pattern using enumerated ports instead of port ranges.
+		if !equalStringSets(portNums, allowed.Ports) && !equalStringSets(portRanges, allowed.Ports) {
+			return true, true, nil
+		}
 	}
 
-	// The service controller already verified that the protocol matches on all ports, no need to check.
 	actualSourceRanges, err := utilnet.ParseIPNets(fw.SourceRanges...)
 	if err != nil {
 		// This really shouldn't happen... GCE has returned something unexpected
@@ -964,12 +1328,11 @@ func (g *Cloud) ensureHTTPHealthCheckFirewall(svc *v1.Service, serviceName, ipAd
 	return nil
 }
 
-func createForwardingRule(s CloudForwardingRuleService, name, serviceName, region, ipAddress, target string, ports []v1.ServicePort, netTier cloud.NetworkTier) error {
+func createForwardingRule(s CloudForwardingRuleService, name, desc, region, ipAddress, target string, ports []v1.ServicePort, netTier cloud.NetworkTier) error {
 	portRange, err := loadBalancerPortRange(ports)
 	if err != nil {
 		return err
 	}
-	desc := makeServiceDescription(serviceName)
 	ipProtocol := string(ports[0].Protocol)
 
 	rule := &compute.ForwardingRule{
@@ -1033,7 +1396,7 @@ func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges ut
 	// GCE considers empty destinationRanges as "all" for ingress firewall-rules.
 	// Concatenate service ports into port ranges. This help to workaround the gce firewall limitation where only
 	// 100 ports or port ranges can be used in a firewall rule.
-	_, portRanges, _ := getPortsAndProtocol(ports)
+	allowed := firewallAllowed(ports)
 
 	// If the node tags to be used for this cluster have been predefined in the
 	// provider config, just use them. Otherwise, invoke computeHostTags method to get the tags.
@@ -1051,17 +1414,7 @@ func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges ut
 		Network:      g.networkURL,
 		SourceRanges: sourceRanges.StringSlice(),
 		TargetTags:   hostTags,
-		Allowed: []*compute.FirewallAllowed{
-			{
-				// TODO: Make this more generic. Currently this method is only
-				// used to create firewall rules for loadbalancers, which have
-				// exactly one protocol, so we can never end up with a list of
-				// mixed TCP and UDP ports. It should be possible to use a
-				// single firewall rule for both a TCP and UDP lb.
-				IPProtocol: strings.ToLower(string(ports[0].Protocol)),
-				Ports:      portRanges,
-			},
-		},
+		Allowed:      allowed,
 	}
 	if destinationIP != "" {
 		firewall.DestinationRanges = []string{destinationIP}
@@ -1070,12 +1423,17 @@ func (g *Cloud) firewallObject(name, desc, destinationIP string, sourceRanges ut
 }
 
 func ensureStaticIP(s CloudAddressService, name, serviceName, region, existingIP string, netTier cloud.NetworkTier) (ipAddress string, existing bool, err error) {
+	return ensureStaticIPWithDescription(s, name, makeServiceDescription(serviceName), region, existingIP, netTier)
+}
+
+// ensureStaticIPWithDescription is ensureStaticIP with the description of the
+// address, which is only set if it is created.
+func ensureStaticIPWithDescription(s CloudAddressService, name, desc, region, existingIP string, netTier cloud.NetworkTier) (ipAddress string, existing bool, err error) {
 	// If the address doesn't exist, this will create it.
 	// If the existingIP exists but is ephemeral, this will promote it to static.
 	// If the address already exists, this will harmlessly return a StatusConflict
 	// and we'll grab the IP before returning.
 	existed := false
-	desc := makeServiceDescription(serviceName)
 
 	var creationErr error
 	addressObj := &compute.Address{
@@ -1118,6 +1476,11 @@ func ensureStaticIP(s CloudAddressService, name, serviceName, region, existingIP
 }
 
 func (g *Cloud) getServiceNetworkTier(svc *v1.Service) (cloud.NetworkTier, error) {
+	if _, ok := svc.Annotations[NetworkTierAnnotationKey]; !ok {
+		if tier := g.LoadBalancerDefaults().NetworkTier; tier != "" {
+			return tier, nil
+		}
+	}
 	tier, err := GetServiceNetworkTier(svc)
 	if err != nil {
 		// Returns an error if the annotation is invalid.
@@ -1126,11 +1489,25 @@ func (g *Cloud) getServiceNetworkTier(svc *v1.Service) (cloud.NetworkTier, error
 	return tier, nil
 }
 
+// forwardingRuleNetworkTier returns the network tier of the forwarding rule,
+// the default network tier if it is not set.
+func forwardingRuleNetworkTier(rule *compute.ForwardingRule) cloud.NetworkTier {
+	if rule.NetworkTier == "" {
+		return cloud.NetworkTierDefault
+	}
+	return cloud.NetworkTierGCEValueToType(rule.NetworkTier)
+}
+
 func (g *Cloud) deleteWrongNetworkTieredResources(lbName, lbRef string, desiredNetTier cloud.NetworkTier) error {
 	logPrefix := fmt.Sprintf("deleteWrongNetworkTieredResources:(%s)", lbRef)
 	if err := deleteFWDRuleWithWrongTier(g, g.region, lbName, logPrefix, desiredNetTier); err != nil {
 		return err
 	}
+	for _, protocol := range externalLoadBalancerProtocols {
+		if err := deleteFWDRuleWithWrongTier(g, g.region, protocolForwardingRuleName(lbName, protocol), logPrefix, desiredNetTier); err != nil {
+			return err
+		}
+	}
 	if err := deleteAddressWithWrongTier(g, g.region, lbName, logPrefix, desiredNetTier); err != nil {
 		return err
 	}
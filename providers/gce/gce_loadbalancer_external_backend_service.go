@@ -0,0 +1,422 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ELBBackendServiceFinalizer is the finalizer of the Services whose
+	// external load balancer is implemented with a backend service by this
+	// controller. It keeps them in this mode until their load balancer is
+	// deleted, as the migration back to a target pool is not supported.
+	ELBBackendServiceFinalizer = "gke.networking.io/l4-netlb-bs-v1"
+)
+
+// usesExternalBackendService returns true if the external load balancer of
+// the Service is implemented with a backend service by this controller. The
+// dual-stack Services always are, the target pools do not serve IPv6.
+func usesExternalBackendService(svc *v1.Service) bool {
+	return GetLoadBalancerAnnotationBackendService(svc) || serviceRequestsIPv6(svc) || hasFinalizer(svc, ELBBackendServiceFinalizer)
+}
+
+// ensureExternalBackendServiceLoadBalancer is the implementation of
+// ensureExternalLoadBalancer for the Services annotated with
+// ServiceAnnotationLoadBalancerBackendService and the dual-stack Services. The
+// load balancer consists of a static IP address, a firewall rule, the
+// instance groups of the nodes shared with the internal load balancers, a
+// health check, a regional backend service and a forwarding rule, plus an
+// IPv6 forwarding rule and its firewalls for the dual-stack Services.
+//
+// The target pool of an existing load balancer is replaced: the backend
+// service is created while the target pool serves, the forwarding rule is
+// then recreated with the backend service and the same IP, and the target
+// pool is deleted once the backends of the backend service are healthy.
+func (g *Cloud) ensureExternalBackendServiceLoadBalancer(clusterName, clusterID string, svc *v1.Service, existingFwdRule *compute.ForwardingRule, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+	nm := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	lbRefStr := fmt.Sprintf("%v(%v)", loadBalancerName, nm)
+
+	// The backend services of the other controllers are not taken over.
+	if existingFwdRule != nil && existingFwdRule.BackendService != "" && getNameFromLink(existingFwdRule.BackendService) != loadBalancerName {
+		klog.V(2).Infof("ensureExternalBackendServiceLoadBalancer(%s): Skipped, forwarding rule uses backend service %s.", lbRefStr, existingFwdRule.BackendService)
+		return nil, cloudprovider.ImplementedElsewhere
+	}
+	if !GetLoadBalancerAnnotationBackendService(svc) && !serviceRequestsIPv6(svc) {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "BackendServiceKept", "Annotation %s was removed, the load balancer keeps its backend service, the migration back to a target pool is not supported", ServiceAnnotationLoadBalancerBackendService)
+	}
+	if group := GetLoadBalancerAnnotationSharedIP(svc); group != "" {
+		return nil, fmt.Errorf("annotation %s is not supported with annotation %s", ServiceAnnotationLoadBalancerSharedIP, ServiceAnnotationLoadBalancerBackendService)
+	}
+	if _, err := GetLoadBalancerAnnotationSessionAffinity(svc); err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf(errStrLbNoHosts)
+	}
+	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
+	portRange, err := loadBalancerPortRange(svc.Spec.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := g.getInstancesByNames(nodeNames(nodes))
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("ensureExternalBackendServiceLoadBalancer(%s, %v, %v, %v)", lbRefStr, g.region, svc.Spec.LoadBalancerIP, loggableNodeNames(nodes))
+
+	netTier, err := g.getServiceNetworkTier(svc)
+	if err != nil {
+		return nil, err
+	}
+	netTier = externalNetworkTier(svc, existingFwdRule, netTier)
+	if err := checkExternalIPFamilies(svc, netTier); err != nil {
+		return nil, err
+	}
+	fwdRuleDesc, err := makeServiceDescriptionWithFields(svc, nm.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := svc.Annotations[NetworkTierAnnotationKey]; ok {
+		if err := g.deleteWrongNetworkTieredResources(loadBalancerName, lbRefStr, netTier); err != nil {
+			return nil, err
+		}
+		if existingFwdRule, err = g.GetRegionForwardingRule(loadBalancerName, g.region); err != nil && !isNotFound(err) {
+			return nil, err
+		}
+	}
+
+	klog.V(2).Infof("ensureExternalBackendServiceLoadBalancer(%s): Attaching %q finalizer", lbRefStr, ELBBackendServiceFinalizer)
+	if err := addFinalizer(svc, g.client.CoreV1(), ELBBackendServiceFinalizer); err != nil {
+		return nil, err
+	}
+
+	// The IP of the forwarding rule is kept as a static IP while the
+	// forwarding rule is recreated, as in ensureExternalLoadBalancer.
+	fwdRuleIP := ""
+	if existingFwdRule != nil {
+		fwdRuleIP = existingFwdRule.IPAddress
+	}
+	requestedIP := svc.Spec.LoadBalancerIP
+	isUserOwnedIP, err := verifyUserRequestedIP(g, g.region, requestedIP, fwdRuleIP, lbRefStr, netTier)
+	if err != nil {
+		return nil, err
+	}
+	ipAddressToUse := requestedIP
+	isSafeToReleaseIP := false
+	reserveIP := GetLoadBalancerAnnotationReserveIP(svc) && requestedIP == ""
+	if GetLoadBalancerAnnotationReserveIP(svc) && !reserveIP {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "ReserveIPIgnored", "Annotation %s is ignored, the IP of the load balancer is requested", ServiceAnnotationLoadBalancerReserveIP)
+	}
+	if !isUserOwnedIP {
+		var ipAddr string
+		var existed bool
+		ipAddr, existed, netTier, err = g.ensureExternalIP(svc, lbRefStr, fwdRuleIP, netTier, func(netTier cloud.NetworkTier) (string, bool, error) {
+			if reserveIP {
+				ipAddr, err := g.ensureReservedIP(loadBalancerName, nm, clusterID, fwdRuleIP, netTier)
+				return ipAddr, true, err
+			}
+			return ensureStaticIP(g, loadBalancerName, nm.String(), g.region, fwdRuleIP, netTier)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to ensure a static IP for load balancer (%s): %v", lbRefStr, err)
+		}
+		isSafeToReleaseIP = !existed || (existingFwdRule != nil && g.staticIPHeldByForwardingRule(loadBalancerName, nm, clusterID, fwdRuleIP))
+		ipAddressToUse = ipAddr
+	}
+	defer func() {
+		if isUserOwnedIP || reserveIP || !isSafeToReleaseIP {
+			return
+		}
+		if err := g.DeleteRegionAddress(loadBalancerName, g.region); err != nil && !isNotFound(err) {
+			klog.Errorf("ensureExternalBackendServiceLoadBalancer(%s): Failed to release static IP %s in region %v: %v.", lbRefStr, ipAddressToUse, g.region, err)
+		}
+	}()
+
+	if err := g.ensureExternalFirewall(svc, loadBalancerName, lbRefStr, ipAddressToUse, hosts); err != nil {
+		return nil, err
+	}
+
+	igLinks, err := g.ensureInternalInstanceGroups(makeInstanceGroupName(clusterID), nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedFwdRule := &compute.ForwardingRule{
+		Name:                loadBalancerName,
+		Description:         fwdRuleDesc,
+		IPAddress:           ipAddressToUse,
+		IPProtocol:          string(protocol),
+		PortRange:           portRange,
+		BackendService:      g.getBackendServiceLink(loadBalancerName, g.region),
+		LoadBalancingScheme: string(cloud.SchemeExternal),
+		NetworkTier:         netTier.ToGCEValue(),
+	}
+	migrating := existingFwdRule != nil && existingFwdRule.BackendService == ""
+	fwdRuleNeedsRecreation := existingFwdRule != nil && !externalBackendServiceForwardingRuleEqual(existingFwdRule, expectedFwdRule)
+	// The recreation of the forwarding rule interrupts the traffic, it waits
+	// for the maintenance window of the Service if any.
+	changeDeferred := false
+	if fwdRuleNeedsRecreation {
+		change := "the recreation of the forwarding rule"
+		if migrating {
+			change = "the migration of the forwarding rule from the target pool to the backend service"
+		}
+		if changeDeferred, err = g.deferDisruptiveChange(svc, change); err != nil {
+			return nil, err
+		}
+	}
+	deleteFwdRule := fwdRuleNeedsRecreation && !changeDeferred
+	if deleteFwdRule && !migrating {
+		// Some changes of the backend service, e.g. the protocol, require
+		// the forwarding rule to be deleted first.
+		isSafeToReleaseIP = false
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
+			return nil, err
+		}
+		if err := g.deleteExternalIPv6ForwardingRuleOfProtocol(loadBalancerName, protocol); err != nil {
+			return nil, err
+		}
+		klog.Infof("ensureExternalBackendServiceLoadBalancer(%s): Deleted forwarding rule.", lbRefStr)
+	}
+
+	// The previous health check of the backend service is deleted once it is
+	// replaced.
+	existingBackendService, err := g.GetRegionBackendService(loadBalancerName, g.region)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	sharedHealthCheck := shareHealthCheck(svc)
+	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
+	var hc *compute.HealthCheck
+	hcPort, err := func() (int32, error) {
+		// Lock the sharedResourceLock to prevent the deletion of the shared
+		// health check before the backend service refers to it.
+		g.sharedResourceLock.Lock()
+		defer g.sharedResourceLock.Unlock()
+
+		var hcPort int32
+		var err error
+		hc, hcPort, err = g.ensureServiceHealthCheck(svc, nm, hcName, sharedHealthCheck)
+		if err != nil {
+			return 0, err
+		}
+		// The backend service is updated with the forwarding rule, unless it
+		// still serves the target pool.
+		if !changeDeferred || migrating {
+			bsDescription := makeBackendServiceDescription(nm, false)
+			if err := g.ensureInternalBackendService(svc, loadBalancerName, bsDescription, backendServiceAffinity(svc), cloud.SchemeExternal, protocol, igLinks, hc.SelfLink, nil); err != nil {
+				return 0, err
+			}
+		}
+		return hcPort, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	hcFirewallName := makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)
+	healthCheckPort := strconv.Itoa(int(hcPort))
+	if err := g.ensureInternalFirewall(svc, hcFirewallName, "", "", L4LoadBalancerSrcRanges(), []string{healthCheckPort}, v1.ProtocolTCP, nodes, ""); err != nil {
+		return nil, err
+	}
+
+	if deleteFwdRule && migrating {
+		// Begin critical section. The static IP is kept until the forwarding
+		// rule of the backend service holds it.
+		isSafeToReleaseIP = false
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
+			return nil, fmt.Errorf("failed to delete the forwarding rule of the target pool of load balancer (%s): %v", lbRefStr, err)
+		}
+		if err := g.deleteProtocolForwardingRules(loadBalancerName); err != nil {
+			return nil, err
+		}
+		klog.Infof("ensureExternalBackendServiceLoadBalancer(%s): Deleted the forwarding rule of the target pool.", lbRefStr)
+	}
+	if deleteFwdRule || existingFwdRule == nil {
+		klog.Infof("ensureExternalBackendServiceLoadBalancer(%s): Creating forwarding rule, IP %s (tier: %s).", lbRefStr, ipAddressToUse, netTier)
+		if err := g.CreateRegionForwardingRule(expectedFwdRule, g.region); err != nil {
+			return nil, fmt.Errorf("failed to create forwarding rule for load balancer (%s): %v", lbRefStr, err)
+		}
+		// End critical section, the forwarding rule holds the IP.
+		isSafeToReleaseIP = true
+		klog.Infof("ensureExternalBackendServiceLoadBalancer(%s): Created forwarding rule, IP %s.", lbRefStr, ipAddressToUse)
+	}
+
+	// The IPv6 forwarding rule is added next to the forwarding rule of the
+	// target pool, before the migration.
+	var ipv6ToUse string
+	if !changeDeferred || migrating {
+		if ipv6ToUse, err = g.ensureExternalIPv6LoadBalancer(svc, nm, loadBalancerName, clusterID, expectedFwdRule, healthCheckPort, sharedHealthCheck, nodes); err != nil {
+			return nil, err
+		}
+	}
+
+	if changeDeferred {
+		return nil, errDisruptiveChangeDeferred
+	}
+	if err := g.ensureExternalTargetPoolReplaced(svc, loadBalancerName, lbRefStr, clusterID); err != nil {
+		return nil, err
+	}
+	if existingBackendService != nil {
+		g.clearPreviousInternalResources(svc, loadBalancerName, clusterID, existingBackendService, loadBalancerName, hc.Name)
+	}
+
+	status := &v1.LoadBalancerStatus{}
+	status.Ingress = makeLoadBalancerIngress(svc, ipAddressToUse, ipv6ToUse)
+	return status, nil
+}
+
+// ensureExternalTargetPoolReplaced deletes the target pool of the load
+// balancer and its health checks once the backend service replacing it
+// serves, i.e. once some of its backends are healthy, or once the target pool
+// has no healthy instance either, e.g. while the Service has no endpoints.
+// The sync is failed until then, so that it is retried.
+func (g *Cloud) ensureExternalTargetPoolReplaced(svc *v1.Service, loadBalancerName, lbRefStr, clusterID string) error {
+	if _, err := g.GetTargetPool(loadBalancerName, g.region); err != nil {
+		return ignoreNotFound(err)
+	}
+	health, err := g.getBackendServiceHealth(loadBalancerName, g.region)
+	if err != nil {
+		return err
+	}
+	if health.HealthyBackends == 0 {
+		tpHealth, err := g.getTargetPoolHealth(context.TODO(), loadBalancerName, true)
+		if err != nil {
+			return err
+		}
+		if tpHealth.HealthyBackends > 0 {
+			return fmt.Errorf("target pool %s of load balancer (%s) is kept until the backends of backend service %s are healthy, %d backends are unhealthy", loadBalancerName, lbRefStr, loadBalancerName, health.Backends)
+		}
+	}
+
+	klog.Infof("ensureExternalBackendServiceLoadBalancer(%s): Deleting target pool, %d of %d backends of the backend service are healthy.", lbRefStr, health.HealthyBackends, health.Backends)
+	// Both health checks are deleted, as for the deletion of the load
+	// balancer, the nodes health check is kept while other target pools use it.
+	if err := g.DeleteExternalTargetPoolAndChecks(svc, loadBalancerName, g.region, clusterID, loadBalancerName, MakeNodesHealthCheckName(clusterID)); err != nil {
+		return err
+	}
+	g.eventRecorder.Eventf(svc, v1.EventTypeNormal, "TargetPoolReplaced", "The load balancer is served by backend service %s, deleted target pool %s", loadBalancerName, loadBalancerName)
+	return nil
+}
+
+// updateExternalBackendServiceLoadBalancer is the implementation of
+// updateExternalLoadBalancer for the Services using a backend service. The
+// target pool is updated too while it is not replaced.
+func (g *Cloud) updateExternalBackendServiceLoadBalancer(clusterName string, svc *v1.Service, nodes []*v1.Node) error {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return err
+	}
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+
+	if _, err := g.GetTargetPool(loadBalancerName, g.region); err == nil {
+		hosts, err := g.getInstancesByNames(nodeNames(nodes))
+		if err != nil {
+			return err
+		}
+		if err := g.updateTargetPool(loadBalancerName, g.targetPoolHosts(loadBalancerName, hosts)); err != nil {
+			return err
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	igLinks, err := g.ensureInternalInstanceGroups(makeInstanceGroupName(clusterID), nodes)
+	if err != nil {
+		return err
+	}
+	// The backend service is created by ensureExternalBackendServiceLoadBalancer.
+	_, err = g.ensureInternalBackendServiceGroups(loadBalancerName, g.region, igLinks)
+	return ignoreNotFound(err)
+}
+
+// ensureExternalBackendServiceDeleted deletes the backend service of the
+// external load balancer, its health checks and the instance groups not used
+// by other load balancers, once the forwarding rule is deleted, and removes
+// the ELBBackendServiceFinalizer finalizer of svc.
+func (g *Cloud) ensureExternalBackendServiceDeleted(svc *v1.Service, loadBalancerName, clusterID string) error {
+	if err := func() error {
+		g.sharedResourceLock.Lock()
+		defer g.sharedResourceLock.Unlock()
+
+		bs, err := g.GetRegionBackendService(loadBalancerName, g.region)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if bs != nil && bs.LoadBalancingScheme != string(cloud.SchemeExternal) {
+			// The load balancer was switched to an internal load balancer,
+			// which uses the backend service and the health checks.
+			return nil
+		}
+		// The IPv6 forwarding rule references the backend service.
+		if err := g.ensureInternalIPv6LoadBalancerDeleted(svc, loadBalancerName, g.region, makeIPv6HealthCheckFirewallName(loadBalancerName, clusterID, shareHealthCheck(svc))); err != nil {
+			return err
+		}
+		if bs != nil {
+			klog.V(2).Infof("ensureExternalBackendServiceDeleted(%v): deleting region backend service", loadBalancerName)
+			if err := g.teardownInternalBackendService(loadBalancerName, g.region); err != nil {
+				return err
+			}
+		}
+		// The Service may have changed since its health check was created,
+		// attempt to delete both to prevent leaking.
+		for _, hcName := range []string{makeHealthCheckName(loadBalancerName, clusterID, false), makeHealthCheckName(loadBalancerName, clusterID, true)} {
+			klog.V(2).Infof("ensureExternalBackendServiceDeleted(%v): deleting health check %v and its firewall", loadBalancerName, hcName)
+			if err := g.teardownInternalHealthCheckAndFirewall(svc, hcName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	igName := makeInstanceGroupName(clusterID)
+	klog.V(2).Infof("ensureExternalBackendServiceDeleted(%v): Attempting delete of instanceGroup %v", loadBalancerName, igName)
+	if err := g.ensureInternalInstanceGroupsDeleted(igName, g.region); err != nil && !isInUsedByError(err) {
+		return err
+	}
+
+	klog.V(2).Infof("ensureExternalBackendServiceDeleted(%v): Removing %q finalizer", loadBalancerName, ELBBackendServiceFinalizer)
+	return removeFinalizer(svc, g.client.CoreV1(), ELBBackendServiceFinalizer)
+}
+
+// externalBackendServiceForwardingRuleEqual returns true if the existing
+// forwarding rule does not need to be recreated for the expected one of a
+// backend service.
+func externalBackendServiceForwardingRuleEqual(existing, expected *compute.ForwardingRule) bool {
+	return existing.Target == "" &&
+		getNameFromLink(existing.BackendService) == getNameFromLink(expected.BackendService) &&
+		existing.IPAddress == expected.IPAddress &&
+		existing.IPProtocol == expected.IPProtocol &&
+		existing.PortRange == expected.PortRange &&
+		cloud.NetworkTierGCEValueToType(existing.NetworkTier) == cloud.NetworkTierGCEValueToType(expected.NetworkTier)
+}
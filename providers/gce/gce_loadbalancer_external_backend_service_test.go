@@ -0,0 +1,553 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// setTargetPoolHealth makes the instances of the target pools healthy or
+// unhealthy, the target pool health is not implemented by the mocks.
+func setTargetPoolHealth(t *testing.T, gce *Cloud, healthy bool) {
+	state := "UNHEALTHY"
+	if healthy {
+		state = "HEALTHY"
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasSuffix(r.URL.Path, "/getHealth"), r.URL.Path)
+		json.NewEncoder(rw).Encode(&compute.TargetPoolInstanceHealth{HealthStatus: []*compute.HealthStatus{{HealthState: state}}})
+	}))
+	t.Cleanup(srv.Close)
+	SetFakeComputeEndpoint(gce, srv.URL+"/")
+}
+
+// setBackendServiceHealth makes the backends of the backend services of the
+// mock healthy or unhealthy.
+func setBackendServiceHealth(gce *Cloud, healthy bool) {
+	state := "UNHEALTHY"
+	if healthy {
+		state = "HEALTHY"
+	}
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockRegionBackendServices.GetHealthHook = func(_ context.Context, _ *meta.Key, _ *compute.ResourceGroupReference, _ *cloud.MockRegionBackendServices, _ ...cloud.Option) (*compute.BackendServiceGroupHealth, error) {
+		return &compute.BackendServiceGroupHealth{HealthStatus: []*compute.HealthStatus{{Instance: "test-node-1", HealthState: state}}}, nil
+	}
+}
+
+func TestEnsureExternalLoadBalancerBackendService(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, lbName, getNameFromLink(fwdRule.BackendService))
+	assert.Empty(t, fwdRule.Target)
+	assert.Equal(t, status.Ingress[0].IP, fwdRule.IPAddress)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, string(cloud.SchemeExternal), bs.LoadBalancingScheme)
+	require.Len(t, bs.Backends, 1)
+	assert.Equal(t, makeInstanceGroupName(vals.ClusterID), getNameFromLink(bs.Backends[0].Group))
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err), "target pool was created: %v", err)
+	_, err = gce.GetFirewall(MakeFirewallName(lbName))
+	assert.NoError(t, err)
+	_, err = gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, true))
+	assert.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, hasFinalizer(svc, ELBBackendServiceFinalizer))
+
+	// The backends follow the nodes.
+	newNodes, err := createAndInsertNodes(gce, []string{"test-node-2"}, vals.SecondaryZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.updateExternalLoadBalancer(vals.ClusterName, svc, append(nodes, newNodes...)))
+	bs, err = gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Len(t, bs.Backends, 2)
+
+	// The backend service is deleted with the load balancer.
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule was not deleted: %v", err)
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.True(t, isNotFound(err), "backend service was not deleted: %v", err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, hasFinalizer(svc, ELBBackendServiceFinalizer))
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceSecurityPolicy(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The regional backend services are not implemented by the mocks, the
+	// security policy set with the compute API is applied to the mock.
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		op := &compute.Operation{Name: "op", Status: "DONE", SelfLink: gce.projectsBasePath + "test-project/regions/us-central1/operations/op"}
+		if strings.HasSuffix(r.URL.Path, "/wait") {
+			json.NewEncoder(rw).Encode(op)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		var ref compute.SecurityPolicyReference
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&ref))
+		bs, err := gce.GetRegionBackendService(lbName, gce.region)
+		assert.NoError(t, err)
+		bs.SecurityPolicy = ref.SecurityPolicy
+		assert.NoError(t, gce.c.RegionBackendServices().Update(context.TODO(), meta.RegionalKey(lbName, gce.region), bs))
+		json.NewEncoder(rw).Encode(op)
+	}))
+	defer srv.Close()
+	SetFakeComputeEndpoint(gce, srv.URL+"/")
+
+	// The policy is attached, switched and detached with the annotation.
+	for _, policy := range []string{"policy", "other-policy", "", ""} {
+		if policy == "" {
+			delete(svc.Annotations, ServiceAnnotationLoadBalancerSecurityPolicy)
+		} else {
+			svc.Annotations[ServiceAnnotationLoadBalancerSecurityPolicy] = policy
+		}
+		existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		if isNotFound(err) {
+			existingFwdRule = nil
+		} else {
+			require.NoError(t, err)
+		}
+		_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+		require.NoError(t, err)
+		bs, err := gce.GetRegionBackendService(lbName, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, policy, getNameFromLink(bs.SecurityPolicy))
+		if policy != "" {
+			assert.Equal(t, gce.getSecurityPolicyLink(policy, gce.region), bs.SecurityPolicy)
+		}
+	}
+	// The policy is only set when it changes.
+	assert.Len(t, paths, 3)
+	assert.Equal(t, "/projects/test-project/regions/us-central1/backendServices/"+lbName+"/setSecurityPolicy", paths[0])
+	// The managed policy is not reported as reverted.
+	close(recorder.Events)
+	for event := range recorder.Events {
+		assert.NotContains(t, event, "BackendServiceSettingsReverted")
+	}
+
+	// The target pools do not support security policies.
+	tpSvc := fakeLoadbalancerService("")
+	tpSvc.Name = "target-pool"
+	tpSvc.Annotations[ServiceAnnotationLoadBalancerSecurityPolicy] = "policy"
+	tpRecorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = tpRecorder
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, tpSvc, nil, nodes)
+	require.NoError(t, err)
+	checkEvent(t, tpRecorder, v1.EventTypeWarning+" SecurityPolicyNotSupported", true)
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceSessionAffinity(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The backend service is updated in place.
+	for _, affinity := range []string{"CLIENT_IP_PORT_PROTO", "CLIENT_IP_PROTO", ""} {
+		want := affinity
+		if affinity == "" {
+			delete(svc.Annotations, ServiceAnnotationLoadBalancerSessionAffinity)
+			want = translateAffinityType(svc.Spec.SessionAffinity)
+		} else {
+			svc.Annotations[ServiceAnnotationLoadBalancerSessionAffinity] = affinity
+		}
+		existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		if isNotFound(err) {
+			existingFwdRule = nil
+		} else {
+			require.NoError(t, err)
+		}
+		_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+		require.NoError(t, err)
+		bs, err := gce.GetRegionBackendService(lbName, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, want, bs.SessionAffinity)
+	}
+
+	// The target pools keep the affinity of the Service.
+	tpSvc := fakeLoadbalancerService("")
+	tpSvc.Name = "target-pool"
+	tpSvc.Annotations[ServiceAnnotationLoadBalancerSessionAffinity] = "CLIENT_IP_PORT_PROTO"
+	tpRecorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = tpRecorder
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, tpSvc, nil, nodes)
+	require.NoError(t, err)
+	checkEvent(t, tpRecorder, v1.EventTypeWarning+" SessionAffinityNotSupported", true)
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceUserHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	userHC := &compute.HealthCheck{
+		Name:           "user-hc",
+		Type:           "TCP",
+		TcpHealthCheck: &compute.TCPHealthCheck{Port: 8080},
+	}
+	require.NoError(t, gce.CreateHealthCheck(userHC))
+	userHC, err = gce.GetHealthCheck(userHC.Name)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	svc.Spec.HealthCheckNodePort = 10101
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetHealthCheck(lbName)
+	require.NoError(t, err)
+
+	// The health check of the provider is deleted once the user health check
+	// replaces it, the health check firewall allows the probes of the latter.
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheck] = userHC.Name
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+	require.NoError(t, err)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{userHC.SelfLink}, bs.HealthChecks)
+	_, err = gce.GetHealthCheck(lbName)
+	assert.True(t, isNotFound(err), "health check of the provider not deleted: %v", err)
+	fw, err := gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8080"}, fw.Allowed[0].Ports)
+
+	// The user health check is kept once its load balancer is deleted.
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	hc, err := gce.GetHealthCheck(userHC.Name)
+	require.NoError(t, err)
+	assert.Equal(t, userHC, hc)
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceMigration(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	ip := status.Ingress[0].IP
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The forwarding rule is migrated with its IP, the target pool is kept
+	// while the backends are unhealthy.
+	setBackendServiceHealth(gce, false)
+	setTargetPoolHealth(t, gce, true)
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	assert.Error(t, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, lbName, getNameFromLink(fwdRule.BackendService))
+	assert.Equal(t, ip, fwdRule.IPAddress)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.NoError(t, err)
+
+	// The target pool and its health check are deleted once the backends
+	// are healthy.
+	setBackendServiceHealth(gce, true)
+	status, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ip, status.Ingress[0].IP)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err), "target pool was not deleted: %v", err)
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.True(t, isNotFound(err), "HTTP health check was not deleted: %v", err)
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "static IP was not released: %v", err)
+
+	// The backend service is kept once the annotation is removed.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerBackendService)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, lbName, getNameFromLink(fwdRule.BackendService))
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceMigrationWithoutHealthyBackends(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The target pool serving nothing either, e.g. while the Service has no
+	// endpoints, is deleted without waiting for healthy backends.
+	setBackendServiceHealth(gce, false)
+	setTargetPoolHealth(t, gce, false)
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err), "target pool was not deleted: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceMigrationDeferred(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The backend service is prepared while the target pool serves outside
+	// of the maintenance window.
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	svc.Annotations[ServiceAnnotationLoadBalancerMaintenanceWindow] = "02:00-04:00"
+	gce.clock = testingclock.NewFakePassiveClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	assert.Equal(t, errDisruptiveChangeDeferred, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, lbName, getNameFromLink(fwdRule.Target))
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.NoError(t, err)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.NoError(t, err)
+}
+
+func TestEnsureExternalLoadBalancerBackendServiceOfOtherController(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerBackendService] = "true"
+	fwdRule := &compute.ForwardingRule{Name: "fr", BackendService: "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/backendServices/k8s2-other"}
+
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, err)
+	assert.False(t, usesExternalBackendService(&v1.Service{}))
+}
+
+func TestEnsureExternalLoadBalancerDualStack(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+	svc := fakeLoadbalancerService("")
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	ip := status.Ingress[0].IP
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// Switching the Service to dual-stack migrates it to a backend service
+	// and adds the IPv6 forwarding rule.
+	setBackendServiceHealth(gce, true)
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8", "2001:db8:1::/48"}
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	status, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ip}, {IP: "2001:db8::1"}}, status.Ingress)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, lbName, getNameFromLink(fwdRule.BackendService))
+	ipv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipVersionIPv6, ipv6FwdRule.IpVersion)
+	assert.Equal(t, fwdRule.BackendService, ipv6FwdRule.BackendService)
+	assert.Equal(t, string(cloud.SchemeExternal), ipv6FwdRule.LoadBalancingScheme)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, fw.SourceRanges)
+	fw, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8:1::/48"}, fw.SourceRanges)
+	_, err = gce.GetFirewall(makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)))
+	assert.NoError(t, err)
+	_, err = gce.GetTargetPool(lbName, gce.region)
+	assert.True(t, isNotFound(err), "target pool was not deleted: %v", err)
+
+	// The IPv6 forwarding rule keeps its address when the ports change.
+	svc.Spec.Ports = []v1.ServicePort{{Name: "testport", Port: int32(8081), Protocol: "TCP"}}
+	status, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ip}, {IP: "2001:db8::1"}}, status.Ingress)
+	ipv6FwdRule, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "8081-8081", ipv6FwdRule.PortRange)
+
+	// Switching back to single-stack removes the IPv6 forwarding rule only,
+	// the finalizer keeps the backend service.
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	svc.Spec.Ports = []v1.ServicePort{{Name: "testport", Port: int32(8081), Protocol: "TCP"}}
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	status, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ip}}, status.Ingress)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err), "IPv6 forwarding rule was not deleted: %v", err)
+	_, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	assert.True(t, isNotFound(err), "IPv6 firewall was not deleted: %v", err)
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.NoError(t, err)
+
+	// The IPv6 forwarding rule is deleted with the load balancer.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err), "IPv6 forwarding rule was not deleted: %v", err)
+	_, err = gce.GetRegionBackendService(lbName, gce.region)
+	assert.True(t, isNotFound(err), "backend service was not deleted: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerUnsupportedIPFamilies(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	for desc, tc := range map[string]struct {
+		ipFamilies []v1.IPFamily
+		netTier    string
+	}{
+		"IPv6 single-stack":      {ipFamilies: []v1.IPFamily{v1.IPv6Protocol}},
+		"dual-stack in standard": {ipFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}, netTier: string(cloud.NetworkTierStandard)},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService("")
+			svc.Name = "svc-" + strings.ReplaceAll(desc, " ", "-")
+			svc.Spec.IPFamilies = tc.ipFamilies
+			if tc.netTier != "" {
+				svc.Annotations[NetworkTierAnnotationKey] = tc.netTier
+			}
+			svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+			assert.Error(t, err)
+			_, err = gce.GetRegionForwardingRule(gce.GetLoadBalancerName(context.TODO(), "", svc), gce.region)
+			assert.True(t, isNotFound(err), "forwarding rule was created: %v", err)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ServiceConditionExternalIPExhausted is the condition of the external
+	// LoadBalancer Services whose static IP could not be reserved, as the
+	// IPs or the address quota of the region are exhausted. Its reason is
+	// IPSpaceExhausted or QuotaExceeded, or StandardTierFallback while the
+	// load balancer uses the Standard Tier IP reserved instead, see
+	// ServiceAnnotationLoadBalancerStandardTierFallback. It is removed once
+	// an IP of the desired network tier is reserved.
+	ServiceConditionExternalIPExhausted = "networking.gke.io/ExternalIPExhausted"
+
+	reasonStandardTierFallback = "StandardTierFallback"
+)
+
+var externalIPExhaustedCount = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "cloudprovider_gce_external_lb_ip_exhausted_total",
+		Help:           "Number of the static IP reservations of the external load balancers which failed as the IPs or the address quota of the region are exhausted",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"network_tier", "reason"},
+)
+
+func init() {
+	legacyregistry.MustRegister(externalIPExhaustedCount)
+}
+
+// addressExhaustionReason returns the condition reason of err if the IPs or
+// the address quota of the region are exhausted, empty otherwise.
+func addressExhaustionReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	code, _, _ := gceErrorCode(err)
+	switch code {
+	case "IP_SPACE_EXHAUSTED":
+		return "IPSpaceExhausted"
+	case "QUOTA_EXCEEDED", "quotaExceeded":
+		return "QuotaExceeded"
+	}
+	return ""
+}
+
+// ensureExternalIP reserves the static IP of the external load balancer of
+// svc in the network tier netTier with reserve, and returns the IP, whether
+// it already existed, and its network tier. The reservations failing as the
+// IPs or the address quota of the region are exhausted are reported by the
+// ServiceConditionExternalIPExhausted condition of svc, and a new Premium
+// Tier IP falls back to the Standard Tier if svc allows it.
+func (g *Cloud) ensureExternalIP(svc *v1.Service, lbRefStr, fwdRuleIP string, netTier cloud.NetworkTier, reserve func(cloud.NetworkTier) (string, bool, error)) (string, bool, cloud.NetworkTier, error) {
+	ipAddr, existed, err := reserve(netTier)
+	reason := addressExhaustionReason(err)
+	if reason == "" {
+		if err == nil && !g.usesStandardTierFallback(svc, netTier) {
+			err = g.removeServiceCondition(svc, ServiceConditionExternalIPExhausted)
+		}
+		return ipAddr, existed, netTier, err
+	}
+	externalIPExhaustedCount.WithLabelValues(string(netTier), reason).Inc()
+	message := fmt.Sprintf("The %s Tier static IP of the load balancer could not be reserved: %v", netTier, err)
+	if !canFallBackToStandardTier(svc, fwdRuleIP, netTier) {
+		g.setServiceCondition(svc, metav1.Condition{
+			Type:    ServiceConditionExternalIPExhausted,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: message,
+		})
+		return "", false, netTier, err
+	}
+
+	klog.Warningf("ensureExternalIP(%s): %s IPs exhausted (%s), falling back to a %s Tier IP.", lbRefStr, netTier, reason, cloud.NetworkTierStandard)
+	// The fallback is reported before the Standard Tier IP is reserved, the
+	// sync is retried in the desired tier if it cannot be.
+	if err := g.setServiceCondition(svc, metav1.Condition{
+		Type:    ServiceConditionExternalIPExhausted,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonStandardTierFallback,
+		Message: fmt.Sprintf("%s, the load balancer falls back to a %s Tier IP", message, cloud.NetworkTierStandard),
+	}); err != nil {
+		return "", false, netTier, err
+	}
+	ipAddr, existed, err = reserve(cloud.NetworkTierStandard)
+	if err != nil {
+		if fallbackReason := addressExhaustionReason(err); fallbackReason != "" {
+			externalIPExhaustedCount.WithLabelValues(string(cloud.NetworkTierStandard), fallbackReason).Inc()
+		}
+		g.setServiceCondition(svc, metav1.Condition{
+			Type:    ServiceConditionExternalIPExhausted,
+			Status:  metav1.ConditionTrue,
+			Reason:  reason,
+			Message: fmt.Sprintf("%s, and the %s Tier one neither: %v", message, cloud.NetworkTierStandard, err),
+		})
+		return "", false, netTier, err
+	}
+	g.eventRecorder.Eventf(svc, v1.EventTypeWarning, reasonStandardTierFallback, "The %s Tier IPs of the region are exhausted (%s), the load balancer uses the %s Tier IP %s", netTier, reason, cloud.NetworkTierStandard, ipAddr)
+	return ipAddr, existed, cloud.NetworkTierStandard, nil
+}
+
+// canFallBackToStandardTier returns true if the new Premium Tier IPv4 load
+// balancer of svc may use a Standard Tier IP instead. The IP of an existing
+// forwarding rule is kept.
+func canFallBackToStandardTier(svc *v1.Service, fwdRuleIP string, netTier cloud.NetworkTier) bool {
+	return netTier == cloud.NetworkTierPremium && fwdRuleIP == "" && GetLoadBalancerAnnotationStandardTierFallback(svc) && !serviceRequestsIPv6(svc)
+}
+
+// usesStandardTierFallback returns true if the load balancer of svc, of the
+// network tier netTier, uses the Standard Tier IP it fell back to: svc desires
+// the Premium Tier and allows the fallback.
+func (g *Cloud) usesStandardTierFallback(svc *v1.Service, netTier cloud.NetworkTier) bool {
+	if netTier != cloud.NetworkTierStandard || !GetLoadBalancerAnnotationStandardTierFallback(svc) {
+		return false
+	}
+	desiredTier, err := g.getServiceNetworkTier(svc)
+	return err == nil && desiredTier == cloud.NetworkTierPremium
+}
+
+// externalNetworkTier returns the network tier of the external load balancer
+// of svc, whose desired network tier is netTier: the Standard Tier while svc
+// allows the fallback and its forwarding rule existingFwdRule uses a Standard
+// Tier IP, rather than recreating it in the Premium Tier on every sync. The
+// tier is decided from the forwarding rule, the conditions of svc are only
+// reported.
+func externalNetworkTier(svc *v1.Service, existingFwdRule *compute.ForwardingRule, netTier cloud.NetworkTier) cloud.NetworkTier {
+	if netTier == cloud.NetworkTierPremium && existingFwdRule != nil && GetLoadBalancerAnnotationStandardTierFallback(svc) &&
+		forwardingRuleNetworkTier(existingFwdRule) == cloud.NetworkTierStandard {
+		return cloud.NetworkTierStandard
+	}
+	return netTier
+}
@@ -0,0 +1,248 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var errStaticAddressesQuota = &googleapi.Error{
+	Code:    403,
+	Message: "QUOTA_EXCEEDED - Quota 'STATIC_ADDRESSES' exceeded.  Limit: 8.0 in region us-central1.",
+}
+
+func TestAddressExhaustionReason(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{desc: "no error"},
+		{desc: "quota of a failed operation", err: errStaticAddressesQuota, want: "QuotaExceeded"},
+		{desc: "formatted quota error", err: fmt.Errorf("failed to reserve: %v", errStaticAddressesQuota), want: "QuotaExceeded"},
+		{desc: "rejected call", err: &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, want: "QuotaExceeded"},
+		{desc: "exhausted IP space", err: &googleapi.Error{Code: 400, Message: "IP_SPACE_EXHAUSTED - IP space of region is exhausted."}, want: "IPSpaceExhausted"},
+		{desc: "other error", err: &googleapi.Error{Code: 400, Message: "RESOURCE_NOT_READY - The resource is not ready."}},
+		{desc: "non GCE error", err: errors.New("timeout")},
+	} {
+		assert.Equal(t, tc.want, addressExhaustionReason(tc.err), tc.desc)
+	}
+}
+
+// exhaustPremiumTierAddresses fails the reservations of the Premium Tier
+// addresses with errStaticAddressesQuota, and returns their count.
+func exhaustPremiumTierAddresses(gce *Cloud) *int {
+	attempts := 0
+	mockGCE := gce.c.(*cloud.MockGCE)
+	insertHook := mockGCE.MockAddresses.InsertHook
+	mockGCE.MockAddresses.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.Address, m *cloud.MockAddresses, options ...cloud.Option) (bool, error) {
+		if obj.NetworkTier == cloud.NetworkTierPremium.ToGCEValue() {
+			attempts++
+			return true, errStaticAddressesQuota
+		}
+		return insertHook(ctx, key, obj, m, options...)
+	}
+	return &attempts
+}
+
+func externalIPExhaustedMetric(t *testing.T, netTier cloud.NetworkTier, reason string) float64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "cloudprovider_gce_external_lb_ip_exhausted_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["network_tier"] == string(netTier) && labels["reason"] == reason {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestEnsureExternalLoadBalancerIPExhausted(t *testing.T) {
+	// The metric of the exhaustions is global.
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	attempts := exhaustPremiumTierAddresses(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	exhausted := externalIPExhaustedMetric(t, cloud.NetworkTierPremium, "QuotaExceeded")
+
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.ErrorContains(t, err, "QUOTA_EXCEEDED")
+	assert.Equal(t, 1, *attempts)
+	assert.Equal(t, exhausted+1, externalIPExhaustedMetric(t, cloud.NetworkTierPremium, "QuotaExceeded"))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	condition := apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionExternalIPExhausted)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "QuotaExceeded", condition.Reason)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule created: %v", err)
+
+	// The condition is removed once the IP is reserved.
+	svc.Annotations[NetworkTierAnnotationKey] = string(cloud.NetworkTierStandard)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionExternalIPExhausted))
+}
+
+func TestEnsureExternalLoadBalancerStandardTierFallback(t *testing.T) {
+	// The metric of the exhaustions is global.
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	attempts := exhaustPremiumTierAddresses(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerStandardTierFallback] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The new load balancer falls back to a Standard Tier IP.
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *attempts)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.NetworkTierStandard, forwardingRuleNetworkTier(fwdRule))
+	assert.Equal(t, status.Ingress[0].IP, fwdRule.IPAddress)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	condition := apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionExternalIPExhausted)
+	require.NotNil(t, condition)
+	assert.Equal(t, reasonStandardTierFallback, condition.Reason)
+	found := false
+	for len(recorder.Events) > 0 {
+		if strings.Contains(<-recorder.Events, reasonStandardTierFallback) {
+			found = true
+		}
+	}
+	assert.True(t, found, "no %s event", reasonStandardTierFallback)
+
+	// The load balancer keeps its Standard Tier IP and the condition.
+	status, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *attempts)
+	assert.Equal(t, fwdRule.IPAddress, status.Ingress[0].IP)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionExternalIPExhausted))
+
+	// The network tier is decided from the forwarding rule, not from the
+	// condition.
+	withoutCondition := svc.DeepCopy()
+	withoutCondition.Status.Conditions = nil
+	assert.Equal(t, cloud.NetworkTierStandard, externalNetworkTier(withoutCondition, fwdRule, cloud.NetworkTierPremium))
+
+	// The fallback is not allowed for the existing load balancers.
+	assert.False(t, canFallBackToStandardTier(svc, fwdRule.IPAddress, cloud.NetworkTierPremium))
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerStandardTierFallback)
+	assert.Equal(t, cloud.NetworkTierPremium, externalNetworkTier(svc, fwdRule, cloud.NetworkTierPremium))
+}
+
+func TestEnsureExternalLoadBalancerStandardTierFallbackNotReported(t *testing.T) {
+	// The metric of the exhaustions is global.
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	exhaustPremiumTierAddresses(gce)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerStandardTierFallback] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	patchErr := errors.New("patch failed")
+	gce.client.(*fake.Clientset).PrependReactor("patch", "services", func(action core.Action) (bool, runtime.Object, error) {
+		return patchErr != nil, nil, patchErr
+	})
+
+	// The Standard Tier IP is not reserved until the fallback is reported.
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.ErrorContains(t, err, patchErr.Error())
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "address reserved: %v", err)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule created: %v", err)
+
+	patchErr = nil
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.NetworkTierStandard, forwardingRuleNetworkTier(fwdRule))
+}
+
+func TestCanFallBackToStandardTier(t *testing.T) {
+	t.Parallel()
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerStandardTierFallback] = "true"
+	assert.True(t, canFallBackToStandardTier(svc, "", cloud.NetworkTierPremium))
+	assert.False(t, canFallBackToStandardTier(svc, "", cloud.NetworkTierStandard))
+	ipv6 := svc.DeepCopy()
+	ipFamilyPolicy := v1.IPFamilyPolicyRequireDualStack
+	ipv6.Spec.IPFamilyPolicy = &ipFamilyPolicy
+	ipv6.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	assert.False(t, canFallBackToStandardTier(ipv6, "", cloud.NetworkTierPremium))
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerStandardTierFallback)
+	assert.False(t, canFallBackToStandardTier(svc, "", cloud.NetworkTierPremium))
+}
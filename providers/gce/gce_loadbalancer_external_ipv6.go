@@ -0,0 +1,120 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// checkExternalIPFamilies returns an error if the IP families of the Service
+// cannot be served by an external load balancer. The IPv6 forwarding rules
+// are only available in the premium network tier, and a load balancer always
+// has an IPv4 forwarding rule.
+func checkExternalIPFamilies(svc *v1.Service, netTier cloud.NetworkTier) error {
+	if isIPv6SingleStackService(svc) {
+		return fmt.Errorf("IPv6 single-stack Services are not supported, external load balancers require the IPv4 family")
+	}
+	if serviceRequestsIPv6(svc) && netTier != cloud.NetworkTierPremium {
+		return fmt.Errorf("the IPv6 family requires the %s network tier, the Service requests the %s network tier", cloud.NetworkTierPremium, netTier)
+	}
+	return nil
+}
+
+// newExternalIPv6ForwardingRule builds the IPv6 counterpart of the IPv4
+// forwarding rule of an external load balancer backed by a backend service.
+// The external IPv6 address is allocated from the subnetwork, which must have
+// an external IPv6 range.
+func newExternalIPv6ForwardingRule(ipv4FwdRule *compute.ForwardingRule, subnetworkURL string) *compute.ForwardingRule {
+	return &compute.ForwardingRule{
+		Name:                makeIPv6ResourceName(ipv4FwdRule.Name),
+		Description:         ipv4FwdRule.Description,
+		BackendService:      ipv4FwdRule.BackendService,
+		PortRange:           ipv4FwdRule.PortRange,
+		IPProtocol:          ipv4FwdRule.IPProtocol,
+		IpVersion:           ipVersionIPv6,
+		LoadBalancingScheme: ipv4FwdRule.LoadBalancingScheme,
+		NetworkTier:         ipv4FwdRule.NetworkTier,
+		Subnetwork:          subnetworkURL,
+	}
+}
+
+// deleteExternalIPv6ForwardingRuleOfProtocol deletes the IPv6 forwarding rule
+// of the load balancer if it does not have the protocol, as the protocol of
+// the backend service cannot change while a forwarding rule of the previous
+// protocol is linked to it.
+func (g *Cloud) deleteExternalIPv6ForwardingRuleOfProtocol(loadBalancerName string, protocol v1.Protocol) error {
+	existing, err := g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), g.region)
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	if existing.IPProtocol == string(protocol) {
+		return nil
+	}
+	klog.V(2).Infof("deleteExternalIPv6ForwardingRuleOfProtocol(%v): deleting IPv6 forwarding rule of protocol %v", loadBalancerName, existing.IPProtocol)
+	return ignoreNotFound(g.DeleteRegionForwardingRule(existing.Name, g.region))
+}
+
+// ensureExternalIPv6LoadBalancer creates the IPv6 forwarding rule and the
+// firewalls of a dual-stack external load balancer next to its IPv4
+// forwarding rule ipv4FwdRule, and deletes them once the Service no longer
+// requests IPv6. The address of an existing forwarding rule is kept when it
+// is recreated. It returns the IPv6 VIP.
+func (g *Cloud) ensureExternalIPv6LoadBalancer(svc *v1.Service, nm types.NamespacedName, loadBalancerName, clusterID string, ipv4FwdRule *compute.ForwardingRule, healthCheckPort string, sharedHealthCheck bool, nodes []*v1.Node) (string, error) {
+	if !serviceRequestsIPv6(svc) {
+		return "", g.ensureInternalIPv6LoadBalancerDeleted(svc, loadBalancerName, g.region, makeIPv6HealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck))
+	}
+
+	expected := newExternalIPv6ForwardingRule(ipv4FwdRule, g.SubnetworkURL())
+	existing, err := g.GetRegionForwardingRule(expected.Name, g.region)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	if existing != nil {
+		expected.IPAddress = existing.IPAddress
+		if !externalBackendServiceForwardingRuleEqual(existing, expected) {
+			klog.V(2).Infof("ensureExternalIPv6LoadBalancer(%v): IPv6 forwarding rule changed, recreating it with IP %v", loadBalancerName, expected.IPAddress)
+			if err := ignoreNotFound(g.DeleteRegionForwardingRule(expected.Name, g.region)); err != nil {
+				return "", err
+			}
+			existing = nil
+		}
+	}
+	if existing == nil {
+		klog.V(2).Infof("ensureExternalIPv6LoadBalancer(%v): creating IPv6 forwarding rule", loadBalancerName)
+		if err := g.CreateRegionForwardingRule(expected, g.region); err != nil {
+			return "", err
+		}
+	}
+	fwdRule, err := g.GetRegionForwardingRule(expected.Name, g.region)
+	if err != nil {
+		return "", err
+	}
+
+	if err := g.ensureIPv6Firewalls(svc, nm, loadBalancerName, clusterID, fwdRule, healthCheckPort, sharedHealthCheck, nodes); err != nil {
+		return "", err
+	}
+	return fwdRule.IPAddress, nil
+}
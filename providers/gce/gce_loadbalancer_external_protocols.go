@@ -0,0 +1,240 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// externalLoadBalancerProtocols are the protocols of the forwarding rules of
+// the external load balancers, see loadBalancerPortRange.
+var externalLoadBalancerProtocols = []v1.Protocol{v1.ProtocolTCP, v1.ProtocolUDP}
+
+// protocolForwardingRule is the forwarding rule of the ports of one of the
+// protocols of an external load balancer other than the protocol of its main
+// forwarding rule, see ServiceAnnotationLoadBalancerForwardingRulePerProtocol.
+type protocolForwardingRule struct {
+	name        string
+	ports       []v1.ServicePort
+	exists      bool
+	needsUpdate bool
+}
+
+// protocolForwardingRuleName returns the name of the forwarding rule of the
+// ports of the given protocol of a load balancer.
+func protocolForwardingRuleName(loadBalancerName string, protocol v1.Protocol) string {
+	return fmt.Sprintf("%s-%s", loadBalancerName, strings.ToLower(string(protocol)))
+}
+
+// portsByProtocol groups the ports by protocol, in the order of the first
+// port of each protocol. The ports of mainProtocol, if any, come first, so
+// that the main forwarding rule keeps its protocol when the ports are
+// reordered.
+func portsByProtocol(ports []v1.ServicePort, mainProtocol string) [][]v1.ServicePort {
+	var groups [][]v1.ServicePort
+	index := map[v1.Protocol]int{}
+	for _, port := range ports {
+		i, ok := index[port.Protocol]
+		if !ok {
+			i = len(groups)
+			index[port.Protocol] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], port)
+	}
+	if i, ok := index[v1.Protocol(mainProtocol)]; ok && i > 0 {
+		groups[0], groups[i] = groups[i], groups[0]
+	}
+	return groups
+}
+
+// listProtocolForwardingRules returns the existing forwarding rules of the
+// protocols of a load balancer other than the main one, by protocol. They are
+// listed whether or not the Service is annotated with
+// ServiceAnnotationLoadBalancerForwardingRulePerProtocol, so that they are
+// deleted once the annotation is removed.
+func (g *Cloud) listProtocolForwardingRules(loadBalancerName string) (map[v1.Protocol]*compute.ForwardingRule, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	names := map[string]v1.Protocol{}
+	for _, protocol := range externalLoadBalancerProtocols {
+		names[protocolForwardingRuleName(loadBalancerName, protocol)] = protocol
+	}
+	mc := newForwardingRuleMetricContext("list", g.region)
+	rules, err := g.c.ForwardingRules().List(ctx, g.region, filter.Regexp("name", loadBalancerName+"-.*"))
+	if err != nil {
+		return nil, mc.Observe(err)
+	}
+	existing := map[v1.Protocol]*compute.ForwardingRule{}
+	for _, rule := range rules {
+		if protocol, ok := names[rule.Name]; ok {
+			existing[protocol] = rule
+		}
+	}
+	return existing, mc.Observe(nil)
+}
+
+// protocolForwardingRules returns the forwarding rules of the ports of the
+// protocols other than the first one, and whether they must be (re)created
+// with the given IP.
+func protocolForwardingRules(loadBalancerName, ipAddress string, portGroups [][]v1.ServicePort, existing map[v1.Protocol]*compute.ForwardingRule) ([]*protocolForwardingRule, error) {
+	var rules []*protocolForwardingRule
+	for _, ports := range portGroups[1:] {
+		rule := &protocolForwardingRule{name: protocolForwardingRuleName(loadBalancerName, ports[0].Protocol), ports: ports, needsUpdate: true}
+		if fwd, ok := existing[ports[0].Protocol]; ok {
+			var err error
+			rule.exists = true
+			if rule.needsUpdate, err = fwdRuleNeedsUpdate(fwd, ipAddress, ports); err != nil {
+				return nil, err
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// deleteStaleProtocolForwardingRules deletes the existing forwarding rules of
+// the protocols without ports other than the ones of the main forwarding
+// rule. It returns true if any forwarding rule was deleted.
+func (g *Cloud) deleteStaleProtocolForwardingRules(lbRefStr string, portGroups [][]v1.ServicePort, existing map[v1.Protocol]*compute.ForwardingRule) (bool, error) {
+	wanted := map[v1.Protocol]bool{}
+	for _, ports := range portGroups[1:] {
+		wanted[ports[0].Protocol] = true
+	}
+	deleted := false
+	for _, protocol := range externalLoadBalancerProtocols {
+		rule, ok := existing[protocol]
+		if !ok || wanted[protocol] {
+			continue
+		}
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(rule.Name, g.region)); err != nil {
+			return deleted, err
+		}
+		klog.Infof("ensureExternalLoadBalancer(%s): Deleted forwarding rule %s of the removed %s ports.", lbRefStr, rule.Name, protocol)
+		deleted = true
+	}
+	return deleted, nil
+}
+
+// deleteProtocolForwardingRules deletes the existing forwarding rules of all
+// the protocols of a load balancer but the main one.
+func (g *Cloud) deleteProtocolForwardingRules(loadBalancerName string) error {
+	existing, err := g.listProtocolForwardingRules(loadBalancerName)
+	if err != nil {
+		return err
+	}
+	for _, rule := range existing {
+		if err := ignoreNotFound(g.DeleteRegionForwardingRule(rule.Name, g.region)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureForwardingRulesAddress reserves the static IP of a load balancer
+// whose ports are spread over several forwarding rules, which all have the IP
+// of the address named after the load balancer. The address is reserved
+// before any forwarding rule is created, and the reservation of its name is
+// atomic: the parallel syncs of the load balancer all get the IP of the first
+// reservation, instead of forwarding rules with different ephemeral IPs. An
+// existing address wins over the IPs of the forwarding rules, which are then
+// recreated with its IP. Otherwise the IP of the main forwarding rule
+// fwdRuleIP, or else of a forwarding rule of another protocol, is kept. It
+// returns the IP and whether the address existed.
+func (g *Cloud) ensureForwardingRulesAddress(loadBalancerName, serviceName, fwdRuleIP string, netTier cloud.NetworkTier) (string, bool, error) {
+	addr, err := g.GetRegionAddress(loadBalancerName, g.region)
+	if err == nil {
+		return addr.Address, true, nil
+	}
+	if !isNotFound(err) {
+		return "", false, err
+	}
+
+	existingIP := fwdRuleIP
+	if existingIP == "" {
+		if existingIP, err = g.protocolForwardingRuleIP(loadBalancerName); err != nil {
+			return "", false, err
+		}
+	}
+	err = g.ReserveRegionAddress(&compute.Address{
+		Name:        loadBalancerName,
+		Description: makeServiceDescription(serviceName),
+		NetworkTier: netTier.ToGCEValue(),
+		Address:     existingIP,
+	}, g.region)
+	existed := false
+	switch {
+	case err == nil:
+	case isHTTPErrorCode(err, http.StatusConflict):
+		// A parallel sync reserved the address first, its IP wins.
+		existed = true
+	case isHTTPErrorCode(err, http.StatusBadRequest) && existingIP != "":
+		// The IP is already static, e.g. promoted under another name.
+		if addr, err = g.GetRegionAddressByIP(g.region, existingIP); err != nil {
+			return "", false, fmt.Errorf("error getting static IP address: %v", err)
+		}
+		return addr.Address, true, nil
+	default:
+		return "", false, fmt.Errorf("error creating gce static IP address: %v", err)
+	}
+	if addr, err = g.GetRegionAddress(loadBalancerName, g.region); err != nil {
+		return "", false, fmt.Errorf("error getting static IP address: %v", err)
+	}
+	return addr.Address, existed, nil
+}
+
+// protocolForwardingRuleIP returns the IP of an existing forwarding rule of a
+// protocol of the load balancer other than the main one, if any.
+func (g *Cloud) protocolForwardingRuleIP(loadBalancerName string) (string, error) {
+	for _, protocol := range externalLoadBalancerProtocols {
+		rule, err := g.GetRegionForwardingRule(protocolForwardingRuleName(loadBalancerName, protocol), g.region)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		return rule.IPAddress, nil
+	}
+	return "", nil
+}
+
+// firewallAllowed returns the protocols and port ranges allowed by the
+// firewall of a load balancer, one entry per protocol of its ports.
+func firewallAllowed(ports []v1.ServicePort) []*compute.FirewallAllowed {
+	var allowed []*compute.FirewallAllowed
+	for _, protocolPorts := range portsByProtocol(ports, "") {
+		_, portRanges, _ := getPortsAndProtocol(protocolPorts)
+		allowed = append(allowed, &compute.FirewallAllowed{
+			IPProtocol: strings.ToLower(string(protocolPorts[0].Protocol)),
+			Ports:      portRanges,
+		})
+	}
+	return allowed
+}
@@ -22,6 +22,7 @@ package gce
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"testing"
@@ -29,11 +30,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	cloudprovider "k8s.io/cloud-provider"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/mock"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/json"
@@ -220,7 +223,7 @@ func TestCreateForwardingRuleWithTier(t *testing.T) {
 			lbName := tc.expectedRule.Name
 			ipAddr := tc.expectedRule.IPAddress
 
-			err = createForwardingRule(s, lbName, serviceName, s.region, ipAddr, target, ports, tc.netTier)
+			err = createForwardingRule(s, lbName, makeServiceDescription(serviceName), s.region, ipAddr, target, ports, tc.netTier)
 			assert.NoError(t, err)
 
 			Rule, err := s.GetRegionForwardingRule(lbName, s.region)
@@ -401,6 +404,56 @@ func TestEnsureExternalLoadBalancer(t *testing.T) {
 	assertExternalLbResources(t, gce, svc, vals, nodeNames)
 }
 
+func TestEnsureExternalLoadBalancerResourceDescription(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	nodeNames := []string{"test-node-1"}
+
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationResourceDescription] = `{"ticket":"OPS-123","owner":"team-a"}`
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	serviceName := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	expected := &forwardingRuleDescription{
+		ServiceName:  serviceName.String(),
+		CustomFields: map[string]string{"ticket": "OPS-123", "owner": "team-a"},
+	}
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	for _, desc := range []string{fwdRule.Description, pool.Description} {
+		d := &forwardingRuleDescription{}
+		require.NoError(t, d.unmarshal(desc))
+		assert.Equal(t, expected, d)
+	}
+
+	// The custom fields are kept when the forwarding rule and the target pool are recreated.
+	svc.Spec.SessionAffinity = v1.ServiceAffinityClientIP
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	pool, err = gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "CLIENT_IP", pool.SessionAffinity)
+	d := &forwardingRuleDescription{}
+	require.NoError(t, d.unmarshal(pool.Description))
+	assert.Equal(t, expected, d)
+
+	// Invalid custom fields are reported.
+	svc.Annotations[ServiceAnnotationResourceDescription] = `{"kubernetes.io/service-name":"other"}`
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	assert.Error(t, err)
+}
+
 func TestUpdateExternalLoadBalancer(t *testing.T) {
 	t.Parallel()
 
@@ -516,7 +569,7 @@ func TestLoadBalancerWrongTierResourceDeletion(t *testing.T) {
 	err = createForwardingRule(
 		gce,
 		lbName,
-		serviceName.String(),
+		makeServiceDescription(serviceName.String()),
 		gce.region,
 		"",
 		gce.targetPoolURL(lbName),
@@ -1219,6 +1272,45 @@ func TestEnsureTargetPoolAndHealthCheck(t *testing.T) {
 	assert.Equal(t, 1, len(pool.Instances))
 }
 
+func TestEnsureTargetPoolAndHealthCheckLocalHealthCheckFirst(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	var ops []string
+	c := gce.c.(*cloud.MockGCE)
+	c.MockHttpHealthChecks.UpdateHook = func(ctx context.Context, key *meta.Key, obj *compute.HttpHealthCheck, m *cloud.MockHttpHealthChecks, options ...cloud.Option) error {
+		ops = append(ops, "update health check")
+		m.Objects[*key] = &cloud.MockHttpHealthChecksObj{Obj: obj}
+		return nil
+	}
+	require.NoError(t, registerTargetPoolRemoveInstanceHook(gce, func(req *compute.TargetPoolsRemoveInstanceRequest) {
+		ops = append(ops, "remove instances")
+	}))
+
+	svc := fakeLoadbalancerService("")
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 30000
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1", "test-node-2"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.Empty(t, ops)
+
+	// Drain a node while the health check changes: the health check is
+	// programmed before the node leaves the target pool.
+	svc.Spec.HealthCheckNodePort = 30001
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update health check", "remove instances"}, ops)
+
+	hc, err := gce.GetHTTPHealthCheck(gce.GetLoadBalancerName(context.TODO(), "", svc))
+	require.NoError(t, err)
+	assert.Equal(t, int64(30001), hc.Port)
+}
+
 func TestCreateAndUpdateFirewallSucceedsOnXPN(t *testing.T) {
 	t.Parallel()
 
@@ -1464,7 +1556,7 @@ func TestExternalLoadBalancerEnsureHttpHealthCheck(t *testing.T) {
 					t.Fatalf("gce.CreateHttpHealthCheck(%#v) = %v; want err = nil", existingHC, err)
 				}
 			}
-			if _, err := gce.ensureHTTPHealthCheck(hcName, hcPath, hcPort); err != nil {
+			if _, err := gce.ensureHTTPHealthCheck(hcName, hcPath, hcPort, HealthCheckDefaults{}); err != nil {
 				t.Fatalf("gce.ensureHttpHealthCheck(%q, %q, %v) = _, %d; want err = nil", hcName, hcPath, hcPort, err)
 			}
 			if hc, err := gce.GetHTTPHealthCheck(hcName); err != nil {
@@ -1688,6 +1780,95 @@ func TestFirewallObject(t *testing.T) {
 	}
 }
 
+func TestTargetPoolHosts(t *testing.T) {
+	t.Parallel()
+
+	hosts := []*gceInstance{
+		{Name: "a-1", Zone: "zone-a"},
+		{Name: "a-2", Zone: "zone-a"},
+		{Name: "a-3", Zone: "zone-a"},
+		{Name: "a-4", Zone: "zone-a"},
+		{Name: "b-2", Zone: "zone-b"},
+		{Name: "b-1", Zone: "zone-b"},
+		{Name: "c-1", Zone: "zone-c"},
+	}
+	for _, tc := range []struct {
+		desc         string
+		maxInstances int
+		strategy     TargetPoolSubsettingStrategy
+		want         []string
+	}{
+		{
+			desc: "no limit",
+			want: []string{"a-1", "a-2", "a-3", "a-4", "b-2", "b-1", "c-1"},
+		},
+		{
+			desc:         "under the limit",
+			maxInstances: 7,
+			want:         []string{"a-1", "a-2", "a-3", "a-4", "b-2", "b-1", "c-1"},
+		},
+		{
+			desc:         "zone-balanced by default",
+			maxInstances: 4,
+			want:         []string{"a-1", "b-1", "c-1", "a-2"},
+		},
+		{
+			desc:         "zone-balanced with exhausted zones",
+			maxInstances: 6,
+			strategy:     TargetPoolSubsettingZoneBalanced,
+			want:         []string{"a-1", "b-1", "c-1", "a-2", "b-2", "a-3"},
+		},
+		{
+			desc:         "alphabetical",
+			maxInstances: 4,
+			strategy:     TargetPoolSubsettingAlphabetical,
+			want:         []string{"a-1", "a-2", "a-3", "a-4"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			gce := &Cloud{maxTargetPoolInstances: tc.maxInstances, targetPoolSubsettingStrategy: tc.strategy}
+			var got []string
+			for _, host := range gce.targetPoolHosts("lb", hosts) {
+				got = append(got, host.Name)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEnsureExternalLoadBalancerTargetPoolSubsetting(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.maxTargetPoolInstances = 2
+
+	nodes, err := createAndInsertNodes(gce, []string{"node-a-1", "node-a-2", "node-a-3"}, vals.ZoneName)
+	require.NoError(t, err)
+	secondaryNodes, err := createAndInsertNodes(gce, []string{"node-c-1"}, vals.SecondaryZoneName)
+	require.NoError(t, err)
+	nodes = append(nodes, secondaryNodes...)
+
+	svc := fakeLoadbalancerService("")
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		fmt.Sprintf("/zones/%s/instances/%s", vals.ZoneName, "node-a-1"),
+		fmt.Sprintf("/zones/%s/instances/%s", vals.SecondaryZoneName, "node-c-1"),
+	}, pool.Instances)
+
+	// Updates keep the same subset.
+	require.NoError(t, gce.updateExternalLoadBalancer(vals.ClusterName, svc, nodes))
+	pool, err = gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Len(t, pool.Instances, 2)
+}
+
 func copyFirewallObj(firewall *compute.Firewall) (*compute.Firewall, error) {
 	// make a copy of the original obj via json marshal and unmarshal
 	jsonObj, err := firewall.MarshalJSON()
@@ -1701,3 +1882,362 @@ func copyFirewallObj(firewall *compute.Firewall) (*compute.Firewall, error) {
 	}
 	return &fw, nil
 }
+
+func TestEnsureExternalLoadBalancerNodesHealthCheckOverride(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort] = "10257"
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHTTPHealthCheck(lbName)
+	require.NoError(t, err, "the overridden health check is not shared")
+	assert.Equal(t, int64(10257), hc.Port)
+	assert.Equal(t, GetNodesHealthCheckPath(), hc.RequestPath)
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.True(t, isNotFound(err), "the shared health check is not created")
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hc.SelfLink}, pool.HealthChecks)
+
+	svc.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort] = "0"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationLoadBalancerNodesHealthCheckPort, "invalid overrides are reported")
+}
+
+func TestEnsureExternalLoadBalancerHealthCheckSettings(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = "20"
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckHealthyThreshold] = "3"
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHTTPHealthCheck(lbName)
+	require.NoError(t, err, "the tuned health check is not shared")
+	assert.Equal(t, int64(20), hc.CheckIntervalSec)
+	assert.Equal(t, gceHcTimeoutSeconds, hc.TimeoutSec)
+	assert.Equal(t, int64(3), hc.HealthyThreshold)
+	assert.Equal(t, gceHcUnhealthyThreshold, hc.UnhealthyThreshold)
+	assert.Equal(t, GetNodesHealthCheckPort(), int32(hc.Port))
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.True(t, isNotFound(err), "the shared health check is not created")
+
+	// The settings are lowered in place.
+	gce.c.(*cloud.MockGCE).MockHttpHealthChecks.UpdateHook = func(ctx context.Context, key *meta.Key, obj *compute.HttpHealthCheck, m *cloud.MockHttpHealthChecks, options ...cloud.Option) error {
+		m.Objects[*key] = &cloud.MockHttpHealthChecksObj{Obj: obj}
+		return nil
+	}
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = "10"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHTTPHealthCheck(lbName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), hc.CheckIntervalSec)
+
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold] = "11"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold, "invalid settings are reported")
+}
+
+func TestEnsureExternalLoadBalancerTCPHealthCheckUnsupported(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationILBHealthCheckType] = string(HealthCheckTypeTCP)
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, "Warning HealthCheckTypeUnsupported", true)
+	_, err = gce.GetHTTPHealthCheck(MakeNodesHealthCheckName(vals.ClusterID))
+	assert.NoError(t, err, "the nodes health check is used")
+
+	svc.Annotations[ServiceAnnotationILBHealthCheckType] = "tcp"
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationILBHealthCheckType, "invalid types are reported")
+}
+
+func TestEnsureExternalLoadBalancerForwardingRulePerProtocol(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerForwardingRulePerProtocol] = "true"
+	svc.Spec.Ports = []v1.ServicePort{
+		{Protocol: v1.ProtocolTCP, Port: 443},
+		{Protocol: v1.ProtocolUDP, Port: 443},
+		{Protocol: v1.ProtocolTCP, Port: 80},
+	}
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	udpName := protocolForwardingRuleName(lbName, v1.ProtocolUDP)
+	tcpName := protocolForwardingRuleName(lbName, v1.ProtocolTCP)
+	// The fake forwarding rules have no scheme, EnsureLoadBalancer would
+	// recreate them.
+	ensure := func() error {
+		existing, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		if err != nil {
+			return err
+		}
+		_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existing, nodes)
+		return err
+	}
+
+	status, err := gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	ip := status.Ingress[0].IP
+
+	fwd, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "TCP", fwd.IPProtocol)
+	assert.Equal(t, "80-443", fwd.PortRange)
+	assert.Equal(t, ip, fwd.IPAddress)
+	udp, err := gce.GetRegionForwardingRule(udpName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "UDP", udp.IPProtocol)
+	assert.Equal(t, "443-443", udp.PortRange)
+	assert.Equal(t, ip, udp.IPAddress, "the forwarding rules share the IP")
+	assert.Equal(t, fwd.Target, udp.Target, "the forwarding rules share the target pool")
+	addr, err := gce.GetRegionAddress(lbName, gce.region)
+	require.NoError(t, err, "the shared IP stays reserved")
+	assert.Equal(t, ip, addr.Address)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []*compute.FirewallAllowed{
+		{IPProtocol: "tcp", Ports: []string{"80", "443"}},
+		{IPProtocol: "udp", Ports: []string{"443"}},
+	}, fw.Allowed)
+
+	// The main forwarding rule keeps its protocol when the ports are reordered.
+	svc.Spec.Ports = []v1.ServicePort{svc.Spec.Ports[1], svc.Spec.Ports[0], svc.Spec.Ports[2]}
+	require.NoError(t, ensure())
+	fwd, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "TCP", fwd.IPProtocol)
+	_, err = gce.GetRegionForwardingRule(tcpName, gce.region)
+	assert.True(t, isNotFound(err), "no forwarding rule is created for the main protocol")
+
+	// The forwarding rule of the removed UDP ports is deleted, and the IP is
+	// held by the main forwarding rule again.
+	svc.Spec.Ports = []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443}}
+	require.NoError(t, ensure())
+	_, err = gce.GetRegionForwardingRule(udpName, gce.region)
+	assert.True(t, isNotFound(err), "the forwarding rule of the removed protocol is deleted")
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "the IP is released once not shared")
+	fwd, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ip, fwd.IPAddress)
+
+	// The forwarding rules of the other protocols are found without getting
+	// them, and deleted once the annotation is removed.
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 443})
+	require.NoError(t, ensure())
+	var got []string
+	gce.c.(*cloud.MockGCE).MockForwardingRules.GetHook = func(_ context.Context, key *meta.Key, _ *cloud.MockForwardingRules, _ ...cloud.Option) (bool, *compute.ForwardingRule, error) {
+		got = append(got, key.Name)
+		return false, nil, nil
+	}
+	require.NoError(t, ensure())
+	assert.NotContains(t, got, udpName)
+	assert.NotContains(t, got, tcpName)
+	gce.c.(*cloud.MockGCE).MockForwardingRules.GetHook = nil
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerForwardingRulePerProtocol)
+	svc.Spec.Ports = []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443}}
+	require.NoError(t, ensure())
+	_, err = gce.GetRegionForwardingRule(udpName, gce.region)
+	assert.True(t, isNotFound(err), "the forwarding rules of the other protocols are deleted without the annotation")
+
+	// All the forwarding rules are deleted with the load balancer.
+	svc.Annotations[ServiceAnnotationLoadBalancerForwardingRulePerProtocol] = "true"
+	svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolUDP, Port: 443})
+	require.NoError(t, ensure())
+	_, err = gce.GetRegionForwardingRule(udpName, gce.region)
+	require.NoError(t, err)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	for _, name := range []string{lbName, udpName} {
+		_, err = gce.GetRegionForwardingRule(name, gce.region)
+		assert.True(t, isNotFound(err), "forwarding rule %s is deleted", name)
+	}
+}
+
+func TestEnsureExternalLoadBalancerForwardingRulesAddress(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	nodeNames := []string{"test-node-1"}
+	newService := func() *v1.Service {
+		svc := fakeLoadbalancerService("")
+		svc.Annotations[ServiceAnnotationLoadBalancerForwardingRulePerProtocol] = "true"
+		svc.Spec.Ports = []v1.ServicePort{
+			{Protocol: v1.ProtocolTCP, Port: 443},
+			{Protocol: v1.ProtocolUDP, Port: 443},
+		}
+		return svc
+	}
+	assertForwardingRulesIP := func(t *testing.T, gce *Cloud, lbName, ip string) {
+		t.Helper()
+		for _, name := range []string{lbName, protocolForwardingRuleName(lbName, v1.ProtocolUDP)} {
+			fwd, err := gce.GetRegionForwardingRule(name, gce.region)
+			require.NoError(t, err)
+			assert.Equal(t, ip, fwd.IPAddress, "forwarding rule %s", name)
+		}
+		addr, err := gce.GetRegionAddress(lbName, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, ip, addr.Address)
+	}
+
+	t.Run("address reserved by a parallel sync", func(t *testing.T) {
+		t.Parallel()
+		gce, err := fakeGCECloud(vals)
+		require.NoError(t, err)
+		svc := newService()
+		lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+		require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: lbName, Address: "1.2.3.50"}, gce.region))
+
+		status, err := createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+		require.NoError(t, err)
+		assert.Equal(t, "1.2.3.50", status.Ingress[0].IP)
+		assertForwardingRulesIP(t, gce, lbName, "1.2.3.50")
+	})
+
+	t.Run("IP of a forwarding rule of another protocol kept", func(t *testing.T) {
+		t.Parallel()
+		gce, err := fakeGCECloud(vals)
+		require.NoError(t, err)
+		svc := newService()
+		lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+		require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{
+			Name:       protocolForwardingRuleName(lbName, v1.ProtocolUDP),
+			IPAddress:  "1.2.3.60",
+			IPProtocol: "UDP",
+			PortRange:  "443-443",
+		}, gce.region))
+
+		status, err := createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+		require.NoError(t, err)
+		assert.Equal(t, "1.2.3.60", status.Ingress[0].IP)
+		assertForwardingRulesIP(t, gce, lbName, "1.2.3.60")
+	})
+
+	t.Run("forwarding rules moved to the address", func(t *testing.T) {
+		t.Parallel()
+		gce, err := fakeGCECloud(vals)
+		require.NoError(t, err)
+		svc := newService()
+		lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+		_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+		require.NoError(t, err)
+
+		require.NoError(t, gce.DeleteRegionAddress(lbName, gce.region))
+		require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: lbName, Address: "1.2.3.70"}, gce.region))
+		existing, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		require.NoError(t, err)
+		nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+		require.NoError(t, err)
+		status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existing, nodes)
+		require.NoError(t, err)
+		assert.Equal(t, "1.2.3.70", status.Ingress[0].IP)
+		assertForwardingRulesIP(t, gce, lbName, "1.2.3.70")
+	})
+}
+
+func TestEnsureExternalLoadBalancerManyPorts(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Spec.Ports = nil
+	for _, port := range []int32{80, 443, 8080, 8443, 9000, 9090, 10250} {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: port})
+	}
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// A single forwarding rule covers all the ports, only the ports of the
+	// Service are allowed by the firewall.
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwd, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "80-10250", fwd.PortRange)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	require.Len(t, fw.Allowed, 1)
+	assert.ElementsMatch(t, []string{"80", "443", "8080", "8443", "9000", "9090", "10250"}, fw.Allowed[0].Ports)
+}
+
+func TestEnsureExternalLoadBalancerSwitchNetworkTier(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerForwardingRulePerProtocol] = "true"
+	svc.Spec.Ports = []v1.ServicePort{
+		{Protocol: v1.ProtocolTCP, Port: 443},
+		{Protocol: v1.ProtocolUDP, Port: 443},
+	}
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	for _, tier := range []cloud.NetworkTier{cloud.NetworkTierStandard, cloud.NetworkTierPremium, cloud.NetworkTierStandard} {
+		svc.Annotations[NetworkTierAnnotationKey] = string(tier)
+		existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		if isNotFound(err) {
+			existingFwdRule = nil
+		} else {
+			require.NoError(t, err)
+		}
+
+		status, err := gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+		require.NoError(t, err, "switching to %s", tier)
+		assert.NotEmpty(t, status.Ingress)
+		// The forwarding rules and the address they share are recreated in
+		// the network tier.
+		for _, name := range []string{lbName, protocolForwardingRuleName(lbName, v1.ProtocolUDP)} {
+			fwdRule, err := gce.GetRegionForwardingRule(name, gce.region)
+			require.NoError(t, err)
+			assert.Equal(t, tier.ToGCEValue(), fwdRule.NetworkTier, "forwarding rule %s", name)
+		}
+		addr, err := gce.GetRegionAddress(lbName, gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, tier.ToGCEValue(), addr.NetworkTier)
+	}
+
+	// A failure to delete the resources of the previous network tier fails
+	// the sync.
+	gce.c.(*cloud.MockGCE).MockForwardingRules.DeleteHook = func(context.Context, *meta.Key, *cloud.MockForwardingRules, ...cloud.Option) (bool, error) {
+		return true, &googleapi.Error{Code: http.StatusInternalServerError}
+	}
+	svc.Annotations[NetworkTierAnnotationKey] = string(cloud.NetworkTierPremium)
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureExternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+	assert.Error(t, err)
+}
@@ -0,0 +1,242 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+)
+
+// LoadBalancerResource is a kind of GCE resource maintained for the L4 load
+// balancers of the Services.
+type LoadBalancerResource string
+
+const (
+	LoadBalancerResourceForwardingRules  LoadBalancerResource = "forwardingRules"
+	LoadBalancerResourceBackendServices  LoadBalancerResource = "backendServices"
+	LoadBalancerResourceTargetPools      LoadBalancerResource = "targetPools"
+	LoadBalancerResourceHealthChecks     LoadBalancerResource = "healthChecks"
+	LoadBalancerResourceHTTPHealthChecks LoadBalancerResource = "httpHealthChecks"
+	LoadBalancerResourceFirewalls        LoadBalancerResource = "firewalls"
+	LoadBalancerResourceInstanceGroups   LoadBalancerResource = "instanceGroups"
+
+	LoadBalancerResourceNetworkEndpointGroups LoadBalancerResource = "networkEndpointGroups"
+
+	LoadBalancerResourceServiceAttachments LoadBalancerResource = "serviceAttachments"
+)
+
+// loadBalancerResourceQuotaMetrics are the GCE quota metrics of the load
+// balancer resources, in the order of the forecast.
+var loadBalancerResourceQuotaMetrics = []struct {
+	resource LoadBalancerResource
+	metric   string
+}{
+	{LoadBalancerResourceForwardingRules, "FORWARDING_RULES"},
+	{LoadBalancerResourceBackendServices, "BACKEND_SERVICES"},
+	{LoadBalancerResourceTargetPools, "TARGET_POOLS"},
+	{LoadBalancerResourceHealthChecks, "HEALTH_CHECKS"},
+	{LoadBalancerResourceHTTPHealthChecks, "HTTP_HEALTH_CHECKS"},
+	{LoadBalancerResourceFirewalls, "FIREWALLS"},
+	{LoadBalancerResourceInstanceGroups, "INSTANCE_GROUPS"},
+	{LoadBalancerResourceNetworkEndpointGroups, "NETWORK_ENDPOINT_GROUPS"},
+}
+
+// LoadBalancerResourceForecast is the number of GCE resources of a kind
+// maintained for the load balancers, and the quota of the resource.
+type LoadBalancerResourceForecast struct {
+	Resource LoadBalancerResource `json:"resource"`
+	// Count is the number of resources maintained for the Services and nodes
+	// of the forecast, the resources shared by several Services are counted
+	// once.
+	Count int `json:"count"`
+	// Quota is nil if neither the region nor the project has a quota for the
+	// resource.
+	Quota *LoadBalancerResourceQuota `json:"quota,omitempty"`
+}
+
+// LoadBalancerResourceQuota is a GCE quota of the region or of the project.
+type LoadBalancerResourceQuota struct {
+	Metric string `json:"metric"`
+	// Region is empty for the quotas of the project.
+	Region string  `json:"region,omitempty"`
+	Limit  float64 `json:"limit"`
+	// Usage is the current usage of the quota, including the resources which
+	// are not maintained by this cluster.
+	Usage float64 `json:"usage"`
+}
+
+// ForecastLoadBalancerResources returns the number of GCE resources of each
+// kind maintained for the load balancers of the given Services and nodes,
+// with the quota of each resource, for capacity planning.
+//
+// The forecast only depends on the Services and nodes, it does not look up
+// the existing GCE resources. The load balancers which the existing
+// forwarding rules hand over to ingress-gce are therefore counted, unless the
+// Service has the annotation or the finalizer of ingress-gce.
+func (g *Cloud) ForecastLoadBalancerResources(ctx context.Context, clusterName string, services []*v1.Service, nodes []*v1.Node) ([]LoadBalancerResourceForecast, error) {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	names := map[LoadBalancerResource]sets.String{}
+	add := func(resource LoadBalancerResource, name string) {
+		if names[resource] == nil {
+			names[resource] = sets.NewString()
+		}
+		names[resource].Insert(name)
+	}
+
+	zones := sets.StringKeySet(splitNodesByZone(g.filterNodesInRegion("", g.region, nodes)))
+	for _, svc := range services {
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer || svc.Spec.LoadBalancerClass != nil {
+			continue
+		}
+		scheme := getSvcScheme(svc)
+		if !loadBalancerSchemeEnabled(scheme) {
+			continue
+		}
+		loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
+		switch scheme {
+		case cloud.SchemeInternal:
+			if g.AlphaFeatureGate.Enabled(AlphaFeatureILBSubsets) || hasFinalizer(svc, ILBFinalizerV2) && !hasFinalizer(svc, ILBFinalizerV1) {
+				continue
+			}
+			g.forecastInternalLoadBalancer(svc, loadBalancerName, clusterID, zones, add)
+		default:
+			if usesL4RBS(svc, nil) || hasFinalizer(svc, ELBRbsFinalizer) {
+				continue
+			}
+			g.forecastExternalLoadBalancer(svc, loadBalancerName, clusterID, add)
+		}
+	}
+
+	quotas, err := g.loadBalancerResourceQuotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var forecast []LoadBalancerResourceForecast
+	for _, m := range loadBalancerResourceQuotaMetrics {
+		forecast = append(forecast, LoadBalancerResourceForecast{
+			Resource: m.resource,
+			Count:    names[m.resource].Len(),
+			Quota:    quotas[m.metric],
+		})
+	}
+	return forecast, nil
+}
+
+// forecastInternalLoadBalancer adds the names of the resources of the internal
+// load balancer of the Service, as ensureInternalLoadBalancer names them.
+func (g *Cloud) forecastInternalLoadBalancer(svc *v1.Service, loadBalancerName, clusterID string, zones sets.String, add func(LoadBalancerResource, string)) {
+	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
+	sharedHealthCheck := shareHealthCheck(svc)
+	subsetting := g.usesILBSubsetting(svc)
+
+	add(LoadBalancerResourceForwardingRules, loadBalancerName)
+	add(LoadBalancerResourceBackendServices, makeBackendServiceName(loadBalancerName, clusterID, shareBackendService(svc) && !subsetting, cloud.SchemeInternal, protocol, backendServiceAffinity(svc)))
+	add(LoadBalancerResourceHealthChecks, makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck))
+	for _, zone := range zones.List() {
+		if subsetting {
+			add(LoadBalancerResourceNetworkEndpointGroups, zone+"/"+loadBalancerName)
+		} else {
+			add(LoadBalancerResourceInstanceGroups, zone+"/"+makeInstanceGroupName(clusterID))
+		}
+	}
+	if sourceRanges, err := ipv4SourceRanges(svc); err == nil && len(sourceRanges) > 0 {
+		add(LoadBalancerResourceFirewalls, MakeFirewallName(loadBalancerName))
+	}
+	add(LoadBalancerResourceFirewalls, makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck))
+
+	if !serviceRequestsIPv6(svc) {
+		return
+	}
+	add(LoadBalancerResourceForwardingRules, makeIPv6ResourceName(loadBalancerName))
+	if sourceRanges, err := ipv6SourceRanges(svc); err == nil && len(sourceRanges) > 0 {
+		add(LoadBalancerResourceFirewalls, MakeFirewallName(makeIPv6ResourceName(loadBalancerName)))
+	}
+	add(LoadBalancerResourceFirewalls, makeIPv6ResourceName(makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)))
+}
+
+// forecastExternalLoadBalancer adds the names of the resources of the external
+// load balancer of the Service, as ensureExternalLoadBalancer names them.
+func (g *Cloud) forecastExternalLoadBalancer(svc *v1.Service, loadBalancerName, clusterID string, add func(LoadBalancerResource, string)) {
+	add(LoadBalancerResourceForwardingRules, loadBalancerName)
+	if GetLoadBalancerAnnotationForwardingRulePerProtocol(svc) {
+		portGroups := portsByProtocol(svc.Spec.Ports, "")
+		for i := 1; i < len(portGroups); i++ {
+			add(LoadBalancerResourceForwardingRules, protocolForwardingRuleName(loadBalancerName, portGroups[i][0].Protocol))
+		}
+	}
+	add(LoadBalancerResourceTargetPools, loadBalancerName)
+
+	hcName := MakeNodesHealthCheckName(clusterID)
+	if path, _ := servicehelpers.GetServiceHealthCheckPathPort(svc); path != "" || hasNodesHealthCheckOverride(svc) {
+		hcName = loadBalancerName
+	}
+	isNodesHealthCheck := hcName != loadBalancerName
+	add(LoadBalancerResourceHTTPHealthChecks, hcName)
+	add(LoadBalancerResourceFirewalls, MakeHealthCheckFirewallName(clusterID, hcName, isNodesHealthCheck))
+
+	if !g.FirewallConsolidationEnabled() {
+		add(LoadBalancerResourceFirewalls, MakeFirewallName(loadBalancerName))
+		return
+	}
+	// The consolidated firewall rules are shared by the load balancers with
+	// the same source ranges and ports.
+	if ranges, err := ipv4SourceRanges(svc); err == nil && len(ranges) > 0 {
+		sort.Strings(ranges)
+		add(LoadBalancerResourceFirewalls, makeConsolidatedFirewallName(clusterID, ranges, consolidatedFirewallAllowed(svc.Spec.Ports)))
+	}
+}
+
+// loadBalancerResourceQuotas returns the quotas of the region, completed by
+// the quotas of the project for the metrics without a regional quota, keyed
+// by metric.
+func (g *Cloud) loadBalancerResourceQuotas(ctx context.Context) (map[string]*LoadBalancerResourceQuota, error) {
+	region, err := g.c.Regions().Get(ctx, meta.GlobalKey(g.region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the quotas of region %s: %w", g.region, err)
+	}
+	project, err := g.c.Projects().Get(ctx, g.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the quotas of project %s: %w", g.projectID, err)
+	}
+
+	quotas := map[string]*LoadBalancerResourceQuota{}
+	addQuotas := func(items []*compute.Quota, region string) {
+		for _, q := range items {
+			if _, ok := quotas[q.Metric]; ok {
+				continue
+			}
+			quotas[q.Metric] = &LoadBalancerResourceQuota{Metric: q.Metric, Region: region, Limit: q.Limit, Usage: q.Usage}
+		}
+	}
+	addQuotas(region.Quotas, g.region)
+	addQuotas(project.Quotas, "")
+	return quotas, nil
+}
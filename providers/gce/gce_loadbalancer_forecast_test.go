@@ -0,0 +1,132 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestForecastLoadBalancerResources(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockRegions.Objects[*meta.GlobalKey(vals.Region)] = &cloud.MockRegionsObj{Obj: &compute.Region{
+		Name:   vals.Region,
+		Quotas: []*compute.Quota{{Metric: "INSTANCE_GROUPS", Limit: 100, Usage: 10}},
+	}}
+	mockGCE.MockProjects.Objects[*meta.GlobalKey(vals.ProjectID)] = &cloud.MockProjectsObj{Obj: &compute.Project{
+		Name: vals.ProjectID,
+		Quotas: []*compute.Quota{
+			{Metric: "FORWARDING_RULES", Limit: 75, Usage: 5},
+			{Metric: "FIREWALLS", Limit: 200, Usage: 20},
+			{Metric: "INSTANCE_GROUPS", Limit: 1000, Usage: 10},
+		},
+	}}
+
+	nodes, err := createAndInsertNodes(gce, []string{"node-b"}, vals.ZoneName)
+	require.NoError(t, err)
+	secondaryNodes, err := createAndInsertNodes(gce, []string{"node-c"}, vals.SecondaryZoneName)
+	require.NoError(t, err)
+	nodes = append(nodes, secondaryNodes...)
+
+	service := func(name, lbType string) *v1.Service {
+		svc := fakeLoadbalancerService(lbType)
+		svc.Name = name
+		svc.UID = types.UID("uid-" + name)
+		return svc
+	}
+	// The internal load balancers share the nodes health check.
+	ilb1 := service("ilb1", string(LBTypeInternal))
+	ilb2 := service("ilb2", string(LBTypeInternal))
+	elb1 := service("elb1", "")
+	// The external load balancer of local traffic has its own health check.
+	elb2 := service("elb2", "")
+	elb2.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	elb2.Spec.HealthCheckNodePort = 30000
+	// The Services implemented elsewhere are not counted.
+	lbClass := service("lb-class", "")
+	class := "other"
+	lbClass.Spec.LoadBalancerClass = &class
+	rbs := service("rbs", "")
+	rbs.Annotations[RBSAnnotationKey] = RBSEnabled
+	clusterIP := service("cluster-ip", "")
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+
+	forecast, err := gce.ForecastLoadBalancerResources(context.Background(), vals.ClusterName, []*v1.Service{ilb1, ilb2, elb1, elb2, lbClass, rbs, clusterIP}, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []LoadBalancerResourceForecast{
+		{Resource: LoadBalancerResourceForwardingRules, Count: 4, Quota: &LoadBalancerResourceQuota{Metric: "FORWARDING_RULES", Limit: 75, Usage: 5}},
+		{Resource: LoadBalancerResourceBackendServices, Count: 2},
+		{Resource: LoadBalancerResourceTargetPools, Count: 2},
+		{Resource: LoadBalancerResourceHealthChecks, Count: 1},
+		{Resource: LoadBalancerResourceHTTPHealthChecks, Count: 2},
+		// The traffic firewalls of the 4 load balancers, and the ones of the
+		// nodes health checks of the internal and external load balancers
+		// and of the health check of elb2.
+		{Resource: LoadBalancerResourceFirewalls, Count: 7, Quota: &LoadBalancerResourceQuota{Metric: "FIREWALLS", Limit: 200, Usage: 20}},
+		// An instance group per zone, the regional quota takes precedence.
+		{Resource: LoadBalancerResourceInstanceGroups, Count: 2, Quota: &LoadBalancerResourceQuota{Metric: "INSTANCE_GROUPS", Region: vals.Region, Limit: 100, Usage: 10}},
+		{Resource: LoadBalancerResourceNetworkEndpointGroups, Count: 0},
+	}, forecast)
+
+	// The internal load balancers with subsetting have their own network
+	// endpoint group per zone instead of the instance groups.
+	ilb2.Annotations[ServiceAnnotationILBSubsetting] = "true"
+	forecast, err = gce.ForecastLoadBalancerResources(context.Background(), vals.ClusterName, []*v1.Service{ilb1, ilb2}, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, LoadBalancerResourceInstanceGroups, forecast[6].Resource)
+	assert.Equal(t, 2, forecast[6].Count)
+	assert.Equal(t, LoadBalancerResourceNetworkEndpointGroups, forecast[7].Resource)
+	assert.Equal(t, 2, forecast[7].Count)
+	delete(ilb2.Annotations, ServiceAnnotationILBSubsetting)
+
+	// The firewalls of the external load balancers are shared by source
+	// ranges once consolidated.
+	gce.EnableFirewallConsolidation()
+	elb2.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	elb3 := service("elb3", "")
+	forecast, err = gce.ForecastLoadBalancerResources(context.Background(), vals.ClusterName, []*v1.Service{elb1, elb2, elb3}, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, LoadBalancerResourceFirewalls, forecast[5].Resource)
+	// The consolidated firewalls of all sources and of 10.0.0.0/8, and the
+	// firewalls of the nodes health check and of the health check of elb2.
+	assert.Equal(t, 4, forecast[5].Count)
+}
+
+func TestForecastLoadBalancerResourcesQuotaError(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+	_, err = gce.ForecastLoadBalancerResources(context.Background(), "", nil, nil)
+	assert.Error(t, err)
+}
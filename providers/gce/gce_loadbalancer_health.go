@@ -0,0 +1,138 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// healthStateHealthy is the health state of the healthy backends of the
+// backend services and target pools.
+const healthStateHealthy = "HEALTHY"
+
+// LoadBalancerHealth is the health of the backends of an L4 load balancer, as
+// reported by its health check.
+type LoadBalancerHealth struct {
+	// HealthyBackends is the number of healthy backends.
+	HealthyBackends int
+	// Backends is the number of backends, the instances of the nodes.
+	Backends int
+}
+
+// GetLoadBalancerHealth returns the health of the backends of the L4 load
+// balancer of the Service, or nil if the load balancer does not exist. The
+// backends of the internal load balancers are those of their backend service,
+// the backends of the external load balancers those of their target pool.
+//
+// The health of a target pool is fetched instance by instance, so the call
+// costs one API call per node for the external load balancers.
+func (g *Cloud) GetLoadBalancerHealth(ctx context.Context, clusterName string, svc *v1.Service) (*LoadBalancerHealth, error) {
+	return g.getLoadBalancerHealth(ctx, clusterName, svc, false)
+}
+
+// GetLoadBalancerHealthSummary returns the health of the backends of the L4
+// load balancer of the Service like GetLoadBalancerHealth, except that the
+// health of a target pool is only fetched until a healthy instance is found:
+// its HealthyBackends is then 0 or 1. The call costs one API call per node
+// only for the external load balancers without any healthy backend.
+func (g *Cloud) GetLoadBalancerHealthSummary(ctx context.Context, clusterName string, svc *v1.Service) (*LoadBalancerHealth, error) {
+	return g.getLoadBalancerHealth(ctx, clusterName, svc, true)
+}
+
+func (g *Cloud) getLoadBalancerHealth(ctx context.Context, clusterName string, svc *v1.Service, untilHealthy bool) (*LoadBalancerHealth, error) {
+	name := g.GetLoadBalancerName(ctx, clusterName, svc)
+	region := g.loadBalancerRegion(svc)
+	fwdRule, err := g.GetRegionForwardingRule(name, region)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case fwdRule.BackendService != "":
+		return g.getBackendServiceHealth(lastComponent(fwdRule.BackendService), region)
+	case fwdRule.Target != "":
+		return g.getTargetPoolHealth(ctx, lastComponent(fwdRule.Target), untilHealthy)
+	}
+	return nil, fmt.Errorf("forwarding rule %s has no backend service nor target", name)
+}
+
+// getBackendServiceHealth returns the health of the backends of the backend
+// service of region, in all its instance groups.
+func (g *Cloud) getBackendServiceHealth(name, region string) (*LoadBalancerHealth, error) {
+	bs, err := g.GetRegionBackendService(name, region)
+	if err != nil {
+		return nil, err
+	}
+	health := &LoadBalancerHealth{}
+	for _, backend := range bs.Backends {
+		groupHealth, err := g.GetRegionalBackendServiceHealth(name, region, backend.Group)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range groupHealth.HealthStatus {
+			health.Backends++
+			if status.HealthState == healthStateHealthy {
+				health.HealthyBackends++
+			}
+		}
+	}
+	return health, nil
+}
+
+// getTargetPoolHealth returns the health of the instances of the target pool,
+// or with untilHealthy the health of its instances until a healthy one.
+func (g *Cloud) getTargetPoolHealth(ctx context.Context, name string, untilHealthy bool) (*LoadBalancerHealth, error) {
+	tp, err := g.GetTargetPool(name, g.region)
+	if err != nil {
+		return nil, err
+	}
+	health := &LoadBalancerHealth{Backends: len(tp.Instances)}
+	for _, instance := range tp.Instances {
+		mc := newTargetPoolMetricContext("get_health", g.region)
+		instanceHealth, err := g.service.TargetPools.GetHealth(g.projectID, g.region, name, &compute.InstanceReference{Instance: instance}).Context(ctx).Do()
+		if mc.Observe(err) != nil {
+			return nil, err
+		}
+		if !hasHealthyStatus(instanceHealth.HealthStatus) {
+			continue
+		}
+		health.HealthyBackends++
+		if untilHealthy {
+			break
+		}
+	}
+	return health, nil
+}
+
+func hasHealthyStatus(statuses []*compute.HealthStatus) bool {
+	for _, status := range statuses {
+		if status.HealthState == healthStateHealthy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetLoadBalancerHealthInternal(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	health, err := gce.GetLoadBalancerHealth(context.TODO(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assert.Nil(t, health, "the health of a missing load balancer")
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1", "test-node-2"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockRegionBackendServices.GetHealthHook = func(_ context.Context, _ *meta.Key, _ *compute.ResourceGroupReference, _ *cloud.MockRegionBackendServices, _ ...cloud.Option) (*compute.BackendServiceGroupHealth, error) {
+		return &compute.BackendServiceGroupHealth{HealthStatus: []*compute.HealthStatus{
+			{Instance: "test-node-1", HealthState: "HEALTHY"},
+			{Instance: "test-node-2", HealthState: "UNHEALTHY"},
+		}}, nil
+	}
+
+	health, err = gce.GetLoadBalancerHealth(context.TODO(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assert.Equal(t, &LoadBalancerHealth{HealthyBackends: 1, Backends: 2}, health)
+}
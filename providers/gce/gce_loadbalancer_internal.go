@@ -23,6 +23,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -70,6 +71,10 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	}
 
 	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	region := g.loadBalancerRegion(svc)
+	if _, err := GetLoadBalancerAnnotationSessionAffinity(svc); err != nil {
+		return nil, err
+	}
 
 	var serviceState L4ILBServiceState
 	// Mark the service InSuccess state as false to begin with.
@@ -90,20 +95,45 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	if protocol != v1.ProtocolTCP && protocol != v1.ProtocolUDP {
 		return nil, fmt.Errorf("Invalid protocol %s, only TCP and UDP are supported", string(protocol))
 	}
+	if isIPv6SingleStackService(svc) {
+		return nil, fmt.Errorf("IPv6 single-stack Services are not supported, internal load balancers require the IPv4 family")
+	}
+	if protocol == v1.ProtocolUDP && !servicehelpers.RequestsOnlyLocalTraffic(svc) {
+		if GetLoadBalancerAnnotationILBPreserveClientIP(svc) {
+			return nil, fmt.Errorf("annotation %s requires externalTrafficPolicy %s, the client IPs of the UDP flows forwarded between nodes are not preserved", ServiceAnnotationILBPreserveClientIP, v1.ServiceExternalTrafficPolicyLocal)
+		}
+		if existingFwdRule == nil {
+			g.eventRecorder.Eventf(svc, v1.EventTypeNormal, "ClientIPNotPreserved", "The client IPs of the UDP flows forwarded to the endpoints of other nodes are not preserved with externalTrafficPolicy %s", v1.ServiceExternalTrafficPolicyCluster)
+		}
+	}
+	if group := GetLoadBalancerAnnotationSharedIP(svc); group != "" {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "SharedIPUnsupported", "Annotation %s=%s is ignored, only the external load balancers share IPs", ServiceAnnotationLoadBalancerSharedIP, group)
+	}
 	scheme := cloud.SchemeInternal
 	options := getILBOptions(svc)
+	if _, ok := svc.Annotations[ServiceAnnotationILBSubnet]; !ok && region == g.region {
+		options.SubnetName = g.LoadBalancerDefaults().ILBSubnet
+	}
 	if g.IsLegacyNetwork() {
 		g.eventRecorder.Event(svc, v1.EventTypeWarning, "ILBOptionsIgnored", "Internal LoadBalancer options are not supported with Legacy Networks.")
 		options = ILBOptions{}
+	} else {
+		// The subnetwork of the cluster is in the region of the cluster.
+		if region != g.region && options.SubnetName == "" {
+			return nil, fmt.Errorf("annotation %s=%s requires a subnetwork of the region, set annotation %s", ServiceAnnotationLoadBalancerRegion, region, ServiceAnnotationILBSubnet)
+		}
+		g.warnUnreachableILBSourceRanges(svc, options.AllowGlobalAccess)
 	}
 
-	sharedBackend := shareBackendService(svc)
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, svc.Spec.SessionAffinity)
-	backendServiceLink := g.getBackendServiceLink(backendServiceName)
+	// The network endpoint groups of a subset of the nodes are dedicated to
+	// the load balancer, so is its backend service.
+	subsetting := g.usesILBSubsetting(svc)
+	sharedBackend := shareBackendService(svc) && !subsetting
+	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, backendServiceAffinity(svc))
+	backendServiceLink := g.getBackendServiceLink(backendServiceName, region)
 
-	// Ensure instance groups exist and nodes are assigned to groups
-	igName := makeInstanceGroupName(clusterID)
-	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	// Ensure instance groups, or network endpoint groups, exist and nodes are assigned to groups
+	backendLinks, err := g.ensureInternalBackendGroups(svc, loadBalancerName, clusterID, subsetting, nodes)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +142,7 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	var existingBackendService *compute.BackendService
 	if existingFwdRule != nil && existingFwdRule.BackendService != "" {
 		existingBSName := getNameFromLink(existingFwdRule.BackendService)
-		if existingBackendService, err = g.GetRegionBackendService(existingBSName, g.region); err != nil && !isNotFound(err) {
+		if existingBackendService, err = g.GetRegionBackendService(existingBSName, region); err != nil && !isNotFound(err) {
 			return nil, err
 		}
 	}
@@ -122,15 +152,10 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	defer g.sharedResourceLock.Unlock()
 
 	// Ensure health check exists before creating the backend service. The health check is shared
-	// if externalTrafficPolicy=Cluster.
-	sharedHealthCheck := !servicehelpers.RequestsOnlyLocalTraffic(svc)
+	// if externalTrafficPolicy=Cluster, unless the Service overrides the nodes health check.
+	sharedHealthCheck := shareHealthCheck(svc)
 	hcName := makeHealthCheckName(loadBalancerName, clusterID, sharedHealthCheck)
-	hcPath, hcPort := GetNodesHealthCheckPath(), GetNodesHealthCheckPort()
-	if !sharedHealthCheck {
-		// Service requires a special health check, retrieve the OnlyLocal port & path
-		hcPath, hcPort = servicehelpers.GetServiceHealthCheckPathPort(svc)
-	}
-	hc, err := g.ensureInternalHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort)
+	hc, hcPort, err := g.ensureServiceHealthCheck(svc, nm, hcName, sharedHealthCheck)
 	if err != nil {
 		return nil, err
 	}
@@ -141,19 +166,35 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	// In order to support existing ILBs that were setup using the wrong subnet - https://github.com/kubernetes/kubernetes/pull/57861,
 	// users will need to specify that subnet with the annotation.
 	if options.SubnetName != "" {
-		subnetworkURL = gceSubnetworkURL("", g.networkProjectID, g.region, options.SubnetName)
+		subnetworkURL = gceSubnetworkURL("", g.networkProjectID, region, options.SubnetName)
 	}
 	// Determine IP which will be used for this LB. If no forwarding rule has been established
 	// or specified in the Service spec, then requestedIP = "".
 	ipToUse := ilbIPToUse(svc, existingFwdRule, subnetworkURL)
+	retainedIP := ""
+	if ipToUse == "" && GetLoadBalancerAnnotationRetainIP(svc) {
+		retained, err := g.getRetainedILBIP(context.TODO(), nm)
+		if err != nil {
+			return nil, err
+		}
+		if retained != nil && retained.Subnetwork == subnetworkURL {
+			klog.V(2).Infof("ensureInternalLoadBalancer(%v): Reusing retained IP %s of Service %s", loadBalancerName, retained.IP, nm)
+			ipToUse, retainedIP = retained.IP, retained.IP
+		}
+	}
 
 	klog.V(2).Infof("ensureInternalLoadBalancer(%v): Using subnet %s for LoadBalancer IP %s", loadBalancerName, options.SubnetName, ipToUse)
 
 	var addrMgr *addressManager
 	// If the network is not a legacy network, use the address manager
 	if !g.IsLegacyNetwork() {
-		addrMgr = newAddressManager(g, nm.String(), g.Region(), subnetworkURL, loadBalancerName, ipToUse, cloud.SchemeInternal)
+		addrMgr = newAddressManager(g, nm.String(), region, subnetworkURL, loadBalancerName, ipToUse, cloud.SchemeInternal)
 		ipToUse, err = addrMgr.HoldAddress()
+		if err != nil && retainedIP != "" {
+			g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "RetainedIPUnavailable", "Failed to reserve the retained IP %s, allocating a new IP: %v", retainedIP, err)
+			addrMgr = newAddressManager(g, nm.String(), region, subnetworkURL, loadBalancerName, "", cloud.SchemeInternal)
+			ipToUse, err = addrMgr.HoldAddress()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -166,7 +207,15 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 		}()
 	}
 
-	fwdRuleDescription := &forwardingRuleDescription{ServiceName: nm.String()}
+	customFields, err := GetServiceAnnotationResourceDescription(svc)
+	if err != nil {
+		return nil, err
+	}
+	preservedBSFields, err := GetServiceAnnotationILBPreservedBackendServiceFields(svc)
+	if err != nil {
+		return nil, err
+	}
+	fwdRuleDescription := &forwardingRuleDescription{ServiceName: nm.String(), CustomFields: customFields}
 	fwdRuleDescriptionString, err := fwdRuleDescription.marshal()
 	if err != nil {
 		return nil, err
@@ -192,8 +241,32 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 		newFwdRule.AllPorts = true
 	}
 
+	newIPv6FwdRule := newInternalIPv6ForwardingRule(newFwdRule)
+	ipv6HCFirewallName := makeIPv6HealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)
+	existingIPv6FwdRule, err := g.deleteStaleInternalIPv6ForwardingRule(svc, loadBalancerName, region, newIPv6FwdRule, ipv6HCFirewallName)
+	if err != nil {
+		return nil, err
+	}
+
+	fwdRuleChanged := existingFwdRule != nil && !forwardingRulesEqual(existingFwdRule, newFwdRule)
+	if existingFwdRule != nil && !fwdRuleChanged {
+		if fwdRuleChanged, err = g.internalForwardingRuleBetaFieldsChanged(existingFwdRule, region, options); err != nil {
+			return nil, err
+		}
+	}
+	// The recreation of the forwarding rule interrupts the traffic, it waits
+	// for the maintenance window of the Service if any. The backend service
+	// is updated with the forwarding rule, as some of its changes, e.g. the
+	// protocol, require the forwarding rule to be deleted first.
+	changeDeferred := false
+	if fwdRuleChanged {
+		if changeDeferred, err = g.deferDisruptiveChange(svc, "the recreation of the forwarding rule"); err != nil {
+			return nil, err
+		}
+		fwdRuleChanged = !changeDeferred
+	}
 	fwdRuleDeleted := false
-	if existingFwdRule != nil && !forwardingRulesEqual(existingFwdRule, newFwdRule) {
+	if fwdRuleChanged {
 		// Delete existing forwarding rule before making changes to the backend service. For example - changing protocol
 		// of backend service without first deleting forwarding rule will throw an error since the linked forwarding
 		// rule would show the old protocol.
@@ -201,27 +274,43 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 			frDiff := cmp.Diff(existingFwdRule, newFwdRule)
 			klogV.Infof("ensureInternalLoadBalancer(%v): forwarding rule changed - Existing - %+v\n, New - %+v\n, Diff(-existing, +new) - %s\n. Deleting existing forwarding rule.", loadBalancerName, existingFwdRule, newFwdRule, frDiff)
 		}
-		if err = ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
+		// The service attachment publishing the forwarding rule, if any, is
+		// recreated with it.
+		if err = g.ensureInternalServiceAttachmentDeleted(loadBalancerName, region); err != nil {
+			return nil, err
+		}
+		if err = ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, region)); err != nil {
 			return nil, err
 		}
 		fwdRuleDeleted = true
 	}
 
-	bsDescription := makeBackendServiceDescription(nm, sharedBackend)
-	err = g.ensureInternalBackendService(backendServiceName, bsDescription, svc.Spec.SessionAffinity, scheme, protocol, igLinks, hc.SelfLink)
-	if err != nil {
-		return nil, err
+	if !changeDeferred {
+		bsDescription := makeBackendServiceDescription(nm, sharedBackend)
+		err = g.ensureInternalBackendService(svc, backendServiceName, bsDescription, backendServiceAffinity(svc), scheme, protocol, backendLinks, hc.SelfLink, preservedBSFields)
+		if err != nil {
+			return nil, err
+		}
+		// The network endpoint groups which are no longer backends, e.g. of
+		// the zones without nodes or once the subsetting is disabled, are
+		// deleted.
+		if existingBackendService != nil {
+			g.recordDroppedNEGs(existingBackendService.Backends, backendLinks)
+		}
+		if err := g.deleteStaleNEGs(loadBalancerName, backendLinks); err != nil {
+			return nil, err
+		}
 	}
 
 	if fwdRuleDeleted || existingFwdRule == nil {
-		// existing rule has been deleted, pass in nil
-		if err := g.ensureInternalForwardingRule(nil, newFwdRule); err != nil {
+		// existing rule has been deleted
+		if err := g.createInternalForwardingRule(svc, newFwdRule, region, fwdRuleDescription, options); err != nil {
 			return nil, err
 		}
 	}
 
 	// Get the most recent forwarding rule for the address.
-	updatedFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, g.region)
+	updatedFwdRule, err := g.GetRegionForwardingRule(loadBalancerName, region)
 	if err != nil {
 		return nil, err
 	}
@@ -232,9 +321,32 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 		return nil, err
 	}
 
+	var ipv6ToUse string
+	if serviceRequestsIPv6(svc) {
+		// The IPv6 resources are created incrementally, which allows Services to
+		// be switched from single-stack to dual-stack without disrupting the
+		// IPv4 VIP.
+		ipv6ToUse, err = g.ensureInternalIPv6LoadBalancer(svc, nm, loadBalancerName, clusterID, region, existingIPv6FwdRule, newIPv6FwdRule, strconv.Itoa(int(hcPort)), sharedHealthCheck, nodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.ensureInternalServiceAttachment(svc, loadBalancerName, region, updatedFwdRule.SelfLink); err != nil {
+		return nil, err
+	}
+
+	if changeDeferred {
+		return nil, errDisruptiveChangeDeferred
+	}
+
 	// Delete the previous internal load balancer resources if necessary
 	if existingBackendService != nil {
-		g.clearPreviousInternalResources(svc, loadBalancerName, existingBackendService, backendServiceName, hcName)
+		g.clearPreviousInternalResources(svc, loadBalancerName, clusterID, existingBackendService, backendServiceName, hc.Name)
+	}
+
+	if err := g.syncRetainedILBIP(context.TODO(), svc, &retainedILBIP{IP: updatedFwdRule.IPAddress, Subnetwork: subnetworkURL}); err != nil {
+		return nil, err
 	}
 
 	serviceState.InSuccess = true
@@ -250,15 +362,15 @@ func (g *Cloud) ensureInternalLoadBalancer(clusterName, clusterID string, svc *v
 	klog.V(6).Infof("Internal Loadbalancer for Service %s ensured, updating its state %v in metrics cache", nm, serviceState)
 
 	status := &v1.LoadBalancerStatus{}
-	status.Ingress = []v1.LoadBalancerIngress{{IP: updatedFwdRule.IPAddress}}
+	status.Ingress = makeLoadBalancerIngress(svc, updatedFwdRule.IPAddress, ipv6ToUse)
 	return status, nil
 }
 
-func (g *Cloud) clearPreviousInternalResources(svc *v1.Service, loadBalancerName string, existingBackendService *compute.BackendService, expectedBSName, expectedHCName string) {
+func (g *Cloud) clearPreviousInternalResources(svc *v1.Service, loadBalancerName, clusterID string, existingBackendService *compute.BackendService, expectedBSName, expectedHCName string) {
 	// If a new backend service was created, delete the old one.
 	if existingBackendService.Name != expectedBSName {
 		klog.V(2).Infof("clearPreviousInternalResources(%v): expected backend service %q does not match previous %q - deleting backend service", loadBalancerName, expectedBSName, existingBackendService.Name)
-		if err := g.teardownInternalBackendService(existingBackendService.Name); err != nil && !isNotFound(err) {
+		if err := g.teardownInternalBackendService(existingBackendService.Name, g.loadBalancerRegion(svc)); err != nil && !isNotFound(err) {
 			klog.Warningf("clearPreviousInternalResources: could not delete old backend service: %v, err: %v", existingBackendService.Name, err)
 		}
 	}
@@ -266,9 +378,21 @@ func (g *Cloud) clearPreviousInternalResources(svc *v1.Service, loadBalancerName
 	// If a new health check was created, delete the old one.
 	if len(existingBackendService.HealthChecks) == 1 {
 		existingHCName := getNameFromLink(existingBackendService.HealthChecks[0])
-		if existingHCName != expectedHCName {
+		if existingHCName != expectedHCName && !g.ownsHealthCheck(existingHCName, svc, clusterID) {
+			// The health check managed outside of the cluster previously
+			// referenced by the Service is left untouched.
+			klog.V(2).Infof("clearPreviousInternalResources(%v): previous health check %q is not owned by the cluster - keeping health check", loadBalancerName, existingHCName)
+		} else if existingHCName != expectedHCName {
 			klog.V(2).Infof("clearPreviousInternalResources(%v): expected health check %q does not match previous %q - deleting health check", loadBalancerName, expectedHCName, existingHCName)
-			if err := g.teardownInternalHealthCheckAndFirewall(svc, existingHCName); err != nil {
+			var err error
+			if makeHealthCheckFirewallNameFromHC(existingHCName) == makeHealthCheckFirewallName(loadBalancerName, clusterID, shareHealthCheck(svc)) {
+				// The firewall of the previous health check allows the probes
+				// of the health check managed outside of the cluster.
+				err = ignoreNotFound(g.DeleteHealthCheck(existingHCName))
+			} else {
+				err = g.teardownInternalHealthCheckAndFirewall(svc, existingHCName)
+			}
+			if err != nil {
 				klog.Warningf("clearPreviousInternalResources: could not delete existing healthcheck: %v, err: %v", existingHCName, err)
 			}
 		}
@@ -277,6 +401,21 @@ func (g *Cloud) clearPreviousInternalResources(svc *v1.Service, loadBalancerName
 	}
 }
 
+// ownsHealthCheck returns true if the health check name was created by the
+// provider for the load balancer of svc: the health check of the Service, or
+// the health check of the nodes shared by the Services of the cluster.
+func (g *Cloud) ownsHealthCheck(name string, svc *v1.Service, clusterID string) bool {
+	if name == makeHealthCheckName("", clusterID, true) {
+		return true
+	}
+	hc, err := g.GetHealthCheck(name)
+	if err != nil {
+		// The health check is deleted, or checked again by the next sync.
+		return isNotFound(err)
+	}
+	return hc.Description == makeHealthCheckDescription(types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}.String())
+}
+
 // updateInternalLoadBalancer is called when the list of nodes has changed. Therefore, only the instance groups
 // and possibly the backend service need to be updated.
 func (g *Cloud) updateInternalLoadBalancer(clusterName, clusterID string, svc *v1.Service, nodes []*v1.Node) error {
@@ -287,8 +426,10 @@ func (g *Cloud) updateInternalLoadBalancer(clusterName, clusterID string, svc *v
 	g.sharedResourceLock.Lock()
 	defer g.sharedResourceLock.Unlock()
 
-	igName := makeInstanceGroupName(clusterID)
-	igLinks, err := g.ensureInternalInstanceGroups(igName, nodes)
+	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
+	region := g.loadBalancerRegion(svc)
+	subsetting := g.usesILBSubsetting(svc)
+	backendLinks, err := g.ensureInternalBackendGroups(svc, loadBalancerName, clusterID, subsetting, nodes)
 	if err != nil {
 		return err
 	}
@@ -296,34 +437,70 @@ func (g *Cloud) updateInternalLoadBalancer(clusterName, clusterID string, svc *v
 	// Generate the backend service name
 	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
 	scheme := cloud.SchemeInternal
-	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, shareBackendService(svc), scheme, protocol, svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, shareBackendService(svc) && !subsetting, scheme, protocol, backendServiceAffinity(svc))
 	// Ensure the backend service has the proper backend/instance-group links
-	return g.ensureInternalBackendServiceGroups(backendServiceName, igLinks)
+	previousBackends, err := g.ensureInternalBackendServiceGroups(backendServiceName, region, backendLinks)
+	if err != nil {
+		return err
+	}
+	g.recordDroppedNEGs(previousBackends, backendLinks)
+	return g.deleteStaleNEGs(loadBalancerName, backendLinks)
+}
+
+// ensureInternalBackendGroups ensures the groups of nodes which are the
+// backends of the internal load balancer, the network endpoint groups of the
+// load balancer with subsetting or else the instance groups of the cluster,
+// and returns their links.
+func (g *Cloud) ensureInternalBackendGroups(svc *v1.Service, loadBalancerName, clusterID string, subsetting bool, nodes []*v1.Node) ([]string, error) {
+	if subsetting {
+		return g.ensureInternalNEGs(svc, loadBalancerName, nodes)
+	}
+	return g.ensureInternalInstanceGroups(makeInstanceGroupName(clusterID), nodes)
 }
 
 func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string, svc *v1.Service) error {
+	return g.ensureInternalLoadBalancerDeletedInRegion(clusterName, clusterID, svc, g.loadBalancerRegion(svc))
+}
+
+// ensureInternalLoadBalancerDeletedInRegion deletes the internal load balancer
+// of svc in region, e.g. the previous region of the Service.
+func (g *Cloud) ensureInternalLoadBalancerDeletedInRegion(clusterName, clusterID string, svc *v1.Service, region string) error {
 	loadBalancerName := g.GetLoadBalancerName(context.TODO(), clusterName, svc)
 	svcNamespacedName := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
 	_, _, protocol := getPortsAndProtocol(svc.Spec.Ports)
 	scheme := cloud.SchemeInternal
-	sharedBackend := shareBackendService(svc)
-	sharedHealthCheck := !servicehelpers.RequestsOnlyLocalTraffic(svc)
+	sharedBackend := shareBackendService(svc) && !g.usesILBSubsetting(svc)
+	sharedHealthCheck := shareHealthCheck(svc)
 
 	g.sharedResourceLock.Lock()
 	defer g.sharedResourceLock.Unlock()
 
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): attempting delete of region internal address", loadBalancerName)
-	ensureAddressDeleted(g, loadBalancerName, g.region)
+	ensureAddressDeleted(g, loadBalancerName, region)
+
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting service attachment", loadBalancerName)
+	if err := g.ensureInternalServiceAttachmentDeleted(loadBalancerName, region); err != nil {
+		return err
+	}
 
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region internal forwarding rule", loadBalancerName)
-	if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, g.region)); err != nil {
+	if err := ignoreNotFound(g.DeleteRegionForwardingRule(loadBalancerName, region)); err != nil {
+		return err
+	}
+
+	// The IPv6 forwarding rule of dual-stack Services references the same backend service.
+	if err := g.ensureInternalIPv6LoadBalancerDeleted(svc, loadBalancerName, region, makeIPv6HealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck)); err != nil {
 		return err
 	}
 
-	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(loadBalancerName, clusterID, sharedBackend, scheme, protocol, backendServiceAffinity(svc))
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting region backend service %v", loadBalancerName, backendServiceName)
-	if err := g.teardownInternalBackendService(backendServiceName); err != nil {
+	if err := g.teardownInternalBackendService(backendServiceName, region); err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): deleting network endpoint groups", loadBalancerName)
+	if err := g.ensureInternalNEGsDeleted(loadBalancerName, region); err != nil {
 		return err
 	}
 
@@ -358,7 +535,7 @@ func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string,
 	// Try deleting instance groups - expect ResourceInuse error if needed by other LBs
 	igName := makeInstanceGroupName(clusterID)
 	klog.V(2).Infof("ensureInternalLoadBalancerDeleted(%v): Attempting delete of instanceGroup %v", loadBalancerName, igName)
-	if err := g.ensureInternalInstanceGroupsDeleted(igName); err != nil && !isInUsedByError(err) {
+	if err := g.ensureInternalInstanceGroupsDeleted(igName, region); err != nil && !isInUsedByError(err) {
 		return err
 	}
 
@@ -373,8 +550,8 @@ func (g *Cloud) ensureInternalLoadBalancerDeleted(clusterName, clusterID string,
 	return nil
 }
 
-func (g *Cloud) teardownInternalBackendService(bsName string) error {
-	if err := g.DeleteRegionBackendService(bsName, g.region); err != nil {
+func (g *Cloud) teardownInternalBackendService(bsName, region string) error {
+	if err := g.DeleteRegionBackendService(bsName, region); err != nil {
 		if isNotFound(err) {
 			klog.V(2).Infof("teardownInternalBackendService(%v): backend service already deleted. err: %v", bsName, err)
 			return nil
@@ -414,6 +591,16 @@ func (g *Cloud) teardownInternalHealthCheckAndFirewall(svc *v1.Service, hcName s
 		return fmt.Errorf("failed to delete health check firewall: %v, err: %v", hcFirewallName, err)
 	}
 	klog.V(2).Infof("teardownInternalHealthCheckAndFirewall(%v): health check firewall deleted", hcFirewallName)
+
+	// The IPv6 health check firewall only exists if a dual-stack Service used the
+	// health check, check for it to avoid raising events for single-stack Services.
+	hcIPv6FirewallName := makeIPv6ResourceName(hcFirewallName)
+	if _, err := g.GetFirewall(hcIPv6FirewallName); isNotFound(err) {
+		return nil
+	}
+	if err := g.deleteInternalFirewall(svc, hcName, hcIPv6FirewallName); err != nil {
+		return fmt.Errorf("failed to delete health check firewall: %v, err: %v", hcIPv6FirewallName, err)
+	}
 	return nil
 }
 
@@ -469,6 +656,13 @@ func (g *Cloud) ensureInternalFirewall(svc *v1.Service, fwName, fwDesc, destinat
 	if destinationIP != "" {
 		expectedFirewall.DestinationRanges = []string{destinationIP}
 	}
+	// Only the firewalls of the traffic have a description, the allowed
+	// entries of the health check firewalls are not merged.
+	if fwDesc != "" && GetLoadBalancerAnnotationFirewallMergeAllowed(svc) {
+		if expectedFirewall.Allowed, expectedFirewall.Description, err = mergeFirewallAllowed(fwDesc, expectedFirewall.Allowed, existingFirewall); err != nil {
+			return err
+		}
+	}
 
 	if existingFirewall == nil {
 		klog.V(2).Infof("ensureInternalFirewall(%v): creating firewall", fwName)
@@ -499,13 +693,21 @@ func (g *Cloud) ensureInternalFirewalls(loadBalancerName, ipAddress, clusterID s
 	// First firewall is for ingress traffic
 	fwDesc := makeFirewallDescription(nm.String(), ipAddress)
 	_, portRanges, protocol := getPortsAndProtocol(svc.Spec.Ports)
-	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	sourceRanges, err := ipv4SourceRanges(svc)
 	if err != nil {
 		return err
 	}
-	err = g.ensureInternalFirewall(svc, MakeFirewallName(loadBalancerName), fwDesc, ipAddress, sourceRanges.StringSlice(), portRanges, protocol, nodes, loadBalancerName)
-	if err != nil {
-		return err
+	if len(sourceRanges) == 0 {
+		// Only IPv6 source ranges are allowed, GCE firewalls cannot mix address
+		// families and an empty source range would allow all sources.
+		if err := g.deleteInternalFirewall(svc, loadBalancerName, MakeFirewallName(loadBalancerName)); err != nil {
+			return err
+		}
+	} else {
+		err = g.ensureInternalFirewall(svc, MakeFirewallName(loadBalancerName), fwDesc, ipAddress, sourceRanges, portRanges, protocol, nodes, loadBalancerName)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Second firewall is for health checking nodes / services
@@ -514,9 +716,125 @@ func (g *Cloud) ensureInternalFirewalls(loadBalancerName, ipAddress, clusterID s
 	return g.ensureInternalFirewall(svc, fwHCName, "", "", hcSrcRanges, []string{healthCheckPort}, v1.ProtocolTCP, nodes, "")
 }
 
-func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32) (*compute.HealthCheck, error) {
+// ensureServiceHealthCheck ensures the health check hcName of the load
+// balancer of svc backed by a backend service, and returns it with the node
+// port it checks. The health check managed outside of the cluster referenced
+// by svc, if any, is returned instead.
+func (g *Cloud) ensureServiceHealthCheck(svc *v1.Service, nm types.NamespacedName, hcName string, sharedHealthCheck bool) (*compute.HealthCheck, int32, error) {
+	hcPath, hcPort, err := GetLoadBalancerAnnotationNodesHealthCheck(svc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if servicehelpers.RequestsOnlyLocalTraffic(svc) {
+		// Service requires a special health check, retrieve the OnlyLocal port & path
+		hcPath, hcPort = servicehelpers.GetServiceHealthCheckPathPort(svc)
+	}
+	if userHCName := GetLoadBalancerAnnotationHealthCheck(svc); userHCName != "" {
+		if hasHealthCheckSettingsOverride(svc) {
+			g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "HealthCheckSettingsIgnored", "The health check settings annotations are ignored, health check %s of annotation %s is managed outside of the cluster", userHCName, ServiceAnnotationLoadBalancerHealthCheck)
+		}
+		return g.getUserHealthCheck(userHCName, hcPort)
+	}
+	hcSettings, err := GetLoadBalancerAnnotationHealthCheckSettings(svc)
+	if err != nil {
+		return nil, 0, err
+	}
+	hcType, err := GetLoadBalancerAnnotationHealthCheckType(svc)
+	if err != nil {
+		return nil, 0, err
+	}
+	hcLogging := GetLoadBalancerAnnotationHealthCheckLogging(svc)
+	if hcLogging && sharedHealthCheck {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "HealthCheckLoggingIgnored", "Annotation %s is ignored, health check %s is shared with other Services", ServiceAnnotationILBHealthCheckLogging, hcName)
+	}
+	var hc *compute.HealthCheck
+	if hcType == HealthCheckTypeTCP {
+		if hcPort, err = tcpHealthCheckPort(svc); err != nil {
+			return nil, 0, err
+		}
+		if hasNodesHealthCheckOverride(svc) || servicehelpers.RequestsOnlyLocalTraffic(svc) {
+			g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "HTTPHealthCheckIgnored", "The HTTP health check path %s is not checked, annotation %s=%s health checks node port %d with TCP", hcPath, ServiceAnnotationILBHealthCheckType, HealthCheckTypeTCP, hcPort)
+		}
+		hc, err = g.ensureInternalTCPHealthCheck(hcName, nm, hcPort, hcLogging, hcSettings)
+	} else {
+		hc, err = g.ensureInternalHealthCheck(hcName, nm, sharedHealthCheck, hcPath, hcPort, hcLogging, hcSettings)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return hc, hcPort, nil
+}
+
+// getUserHealthCheck returns the health check name managed outside of the
+// cluster, without updating it, with the port it checks, defaultPort if it
+// does not specify one.
+func (g *Cloud) getUserHealthCheck(name string, defaultPort int32) (*compute.HealthCheck, int32, error) {
+	hc, err := g.GetHealthCheck(name)
+	if isNotFound(err) {
+		return nil, 0, fmt.Errorf("health check %s of annotation %s does not exist", name, ServiceAnnotationLoadBalancerHealthCheck)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if port := healthCheckPort(hc); port != 0 {
+		return hc, int32(port), nil
+	}
+	return hc, defaultPort, nil
+}
+
+// healthCheckPort returns the port checked by hc, 0 if it is not set.
+func healthCheckPort(hc *compute.HealthCheck) int64 {
+	switch {
+	case hc.HttpHealthCheck != nil:
+		return hc.HttpHealthCheck.Port
+	case hc.HttpsHealthCheck != nil:
+		return hc.HttpsHealthCheck.Port
+	case hc.Http2HealthCheck != nil:
+		return hc.Http2HealthCheck.Port
+	case hc.TcpHealthCheck != nil:
+		return hc.TcpHealthCheck.Port
+	case hc.SslHealthCheck != nil:
+		return hc.SslHealthCheck.Port
+	case hc.GrpcHealthCheck != nil:
+		return hc.GrpcHealthCheck.Port
+	}
+	return 0
+}
+
+// ensureInternalHealthCheck ensures the health check exists with the expected
+// parameters. The logging of the probes is only managed for the health checks
+// which are not shared, as it is set per Service, as are the settings
+// overriding the cluster-wide ones.
+func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedName, shared bool, path string, port int32, logging bool, settings HealthCheckDefaults) (*compute.HealthCheck, error) {
 	klog.V(2).Infof("ensureInternalHealthCheck(%v, %v, %v): checking existing health check", name, path, port)
 	expectedHC := newInternalLBHealthCheck(name, svcName, shared, path, port)
+	if !shared {
+		expectedHC.LogConfig = &compute.HealthCheckLogConfig{Enable: logging, ForceSendFields: []string{"Enable"}}
+	}
+	return g.syncInternalHealthCheck(expectedHC, settings)
+}
+
+// ensureInternalTCPHealthCheck ensures the TCP health check of the node port
+// of a Service exists with the expected parameters. It is never shared, as the
+// node port is specific to the Service.
+func (g *Cloud) ensureInternalTCPHealthCheck(name string, svcName types.NamespacedName, port int32, logging bool, settings HealthCheckDefaults) (*compute.HealthCheck, error) {
+	klog.V(2).Infof("ensureInternalTCPHealthCheck(%v, %v): checking existing health check", name, port)
+	expectedHC := newInternalLBTCPHealthCheck(name, svcName, port)
+	expectedHC.LogConfig = &compute.HealthCheckLogConfig{Enable: logging, ForceSendFields: []string{"Enable"}}
+	return g.syncInternalHealthCheck(expectedHC, settings)
+}
+
+// syncInternalHealthCheck creates or updates the health check expectedHC,
+// tuned by the cluster-wide defaults overridden by the settings of the
+// Service. The existing health check keeps its larger values, unless they are
+// set by the Service.
+func (g *Cloud) syncInternalHealthCheck(expectedHC *compute.HealthCheck, settings HealthCheckDefaults) (*compute.HealthCheck, error) {
+	name := expectedHC.Name
+	g.LoadBalancerDefaults().HealthCheck.applyToHealthCheck(expectedHC)
+	settings.applyToHealthCheck(expectedHC)
+	if expectedHC.TimeoutSec > expectedHC.CheckIntervalSec {
+		return nil, fmt.Errorf("timeout %ds of health check %s is greater than its check interval %ds", expectedHC.TimeoutSec, name, expectedHC.CheckIntervalSec)
+	}
 
 	hc, err := g.GetHealthCheck(name)
 	if err != nil && !isNotFound(err) {
@@ -524,7 +842,7 @@ func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedN
 	}
 
 	if hc == nil {
-		klog.V(2).Infof("ensureInternalHealthCheck: did not find health check %v, creating one with port %v path %v", name, port, path)
+		klog.V(2).Infof("ensureInternalHealthCheck: did not find health check %v, creating one of type %v", name, expectedHC.Type)
 		if err = g.CreateHealthCheck(expectedHC); err != nil {
 			return nil, err
 		}
@@ -537,9 +855,10 @@ func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedN
 		return hc, nil
 	}
 
-	if needToUpdateHealthChecks(hc, expectedHC) {
+	if needToUpdateHealthChecks(hc, expectedHC) || !settings.setOnHealthCheck(hc) {
 		klog.V(2).Infof("ensureInternalHealthCheck: health check %v exists but parameters have drifted - updating...", name)
 		mergeHealthChecks(hc, expectedHC)
+		settings.applyToHealthCheck(expectedHC)
 		if err := g.UpdateHealthCheck(expectedHC); err != nil {
 			klog.Warningf("Failed to reconcile http health check %v parameters", name)
 			return nil, err
@@ -554,6 +873,22 @@ func (g *Cloud) ensureInternalHealthCheck(name string, svcName types.NamespacedN
 }
 
 func (g *Cloud) ensureInternalInstanceGroup(name, zone string, nodes []string) (string, error) {
+	link, err := g.syncInternalInstanceGroup(name, zone, nodes)
+	if err == nil || !isNotFound(err) {
+		return link, err
+	}
+	// The instance group may have been deleted out-of-band after it was
+	// fetched, in which case the 404 is for the group rather than one of its
+	// instances. Recreate it with the desired membership instead of failing
+	// every sync until the next one notices it is missing.
+	if _, getErr := g.GetInstanceGroup(name, zone); !isNotFound(getErr) {
+		return "", err
+	}
+	klog.Warningf("ensureInternalInstanceGroup(%v, %v): instance group was deleted during sync, recreating it", name, zone)
+	return g.syncInternalInstanceGroup(name, zone, nodes)
+}
+
+func (g *Cloud) syncInternalInstanceGroup(name, zone string, nodes []string) (string, error) {
 	klog.V(2).Infof("ensureInternalInstanceGroup(%v, %v): checking group that it contains %v nodes [node names limited, total number of nodes: %d]", name, zone, nodes, len(nodes))
 	ig, err := g.GetInstanceGroup(name, zone)
 	if err != nil && !isNotFound(err) {
@@ -626,7 +961,7 @@ func (g *Cloud) ensureInternalInstanceGroups(name string, nodes []*v1.Node) ([]s
 	klog.V(2).Infof("ensureInternalInstanceGroups(%v): %d nodes over %d zones in region %v", name, len(nodes), len(zonedNodes), g.region)
 
 	var igLinks []string
-	gceZonedNodes := map[string][]string{}
+	gceZonedNodes := map[string][]*gceInstance{}
 	for zone, zNodes := range zonedNodes {
 		// Skip managing instance groups altogether, using any matching the prefix within the zone.
 		if g.AlphaFeatureGate.Enabled(AlphaFeatureSkipIGsManagement) {
@@ -673,16 +1008,70 @@ func (g *Cloud) ensureInternalInstanceGroups(name string, nodes []*v1.Node) ([]s
 				skip.Insert(groupInstances.UnsortedList()...)
 			}
 		}
-		if remaining := names.Difference(skip).UnsortedList(); len(remaining) > 0 {
+		// The nodes of the managed instance groups of the cluster are load
+		// balanced through their group, as an instance can only be in one
+		// load-balanced group.
+		migs, err := g.candidateManagedInstanceGroups(zone)
+		if err != nil {
+			return nil, err
+		}
+		for _, mig := range migs {
+			if mig.InstanceGroup == "" {
+				continue
+			}
+			instances, err := g.ListInstancesInInstanceGroup(path.Base(mig.InstanceGroup), zone, allInstances)
+			if err != nil {
+				return nil, err
+			}
+			groupInstances := sets.NewString()
+			for _, ins := range instances {
+				groupInstances.Insert(path.Base(ins.Instance))
+			}
+			if groupInstances.Len() == 0 || groupInstances.HasAny(skip.UnsortedList()...) {
+				continue
+			}
+			// The nodes of a group with instances which are not nodes, yet,
+			// stay in the group of the provider, as the group would send
+			// traffic to those instances too.
+			if others := groupInstances.Difference(names); others.Len() > 0 {
+				klog.V(2).Infof("ensureInternalInstanceGroups(%v): managed instance group %s has instances which are not nodes, yet: %v", name, mig.Name, others.List())
+				continue
+			}
+			igLinks = append(igLinks, mig.InstanceGroup)
+			skip.Insert(groupInstances.UnsortedList()...)
+		}
+		var remaining []*gceInstance
+		for _, h := range hosts {
+			if !skip.Has(h.Name) {
+				remaining = append(remaining, h)
+			}
+		}
+		if len(remaining) > 0 {
 			gceZonedNodes[zone] = remaining
 		}
 	}
-	for zone, gceNodes := range gceZonedNodes {
-		igLink, err := g.ensureInternalInstanceGroup(name, zone, gceNodes)
-		if err != nil {
+	for zone := range zonedNodes {
+		if g.AlphaFeatureGate.Enabled(AlphaFeatureSkipIGsManagement) {
+			break
+		}
+		groups := map[string][]string{}
+		if gceNodes := gceZonedNodes[zone]; len(gceNodes) > 0 {
+			// The instances of an instance group must be in the same subnetwork.
+			var err error
+			if groups, err = g.instanceGroupsBySubnetwork(name, zone, gceNodes); err != nil {
+				return []string{}, err
+			}
+		}
+		if err := g.emptyStaleSubnetInstanceGroups(name, zone, groups); err != nil {
 			return []string{}, err
 		}
-		igLinks = append(igLinks, igLink)
+		for groupName, groupNodes := range groups {
+			igLink, err := g.ensureInternalInstanceGroup(groupName, zone, groupNodes)
+			if err != nil {
+				return []string{}, err
+			}
+			igLinks = append(igLinks, igLink)
+		}
 	}
 
 	return igLinks, nil
@@ -695,9 +1084,19 @@ func (g *Cloud) candidateExternalInstanceGroups(zone string) ([]*compute.Instanc
 	return g.ListInstanceGroupsWithPrefix(zone, g.externalInstanceGroupsPrefix)
 }
 
-func (g *Cloud) ensureInternalInstanceGroupsDeleted(name string) error {
+// candidateManagedInstanceGroups returns the managed instance groups of zone
+// whose instance groups are the backends of the load balancers of their
+// nodes, none unless a prefix of these groups is configured.
+func (g *Cloud) candidateManagedInstanceGroups(zone string) ([]*compute.InstanceGroupManager, error) {
+	if g.managedInstanceGroupsPrefix == "" {
+		return nil, nil
+	}
+	return g.ListInstanceGroupManagersWithPrefix(zone, g.managedInstanceGroupsPrefix)
+}
+
+func (g *Cloud) ensureInternalInstanceGroupsDeleted(name, region string) error {
 	// List of nodes isn't available here - fetch all zones in region and try deleting this cluster's ig
-	zones, err := g.ListZonesInRegion(g.region)
+	zones, err := g.ListZonesInRegion(region)
 	if err != nil {
 		return err
 	}
@@ -709,14 +1108,30 @@ func (g *Cloud) ensureInternalInstanceGroupsDeleted(name string) error {
 			if err := g.DeleteInstanceGroup(name, z.Name); err != nil && !isNotFoundOrInUse(err) {
 				return err
 			}
+			igs, err := g.ListInstanceGroupsWithPrefix(z.Name, name+"-")
+			if err != nil {
+				return err
+			}
+			for _, ig := range igs {
+				if !isSubnetInstanceGroupName(name, ig.Name) {
+					continue
+				}
+				if err := g.DeleteInstanceGroup(ig.Name, z.Name); err != nil && !isNotFoundOrInUse(err) {
+					return err
+				}
+			}
 		}
 	}
 	return nil
 }
 
-func (g *Cloud) ensureInternalBackendService(name, description string, affinityType v1.ServiceAffinity, scheme cloud.LbScheme, protocol v1.Protocol, igLinks []string, hcLink string) error {
+// ensureInternalBackendService creates or updates the backend service. Guarded
+// settings enabled outside of Kubernetes are kept if listed in preservedFields,
+// and reverted with an event on svc otherwise.
+func (g *Cloud) ensureInternalBackendService(svc *v1.Service, name, description, affinity string, scheme cloud.LbScheme, protocol v1.Protocol, igLinks []string, hcLink string, preservedFields sets.String) error {
 	klog.V(2).Infof("ensureInternalBackendService(%v, %v, %v): checking existing backend service with %d groups", name, scheme, protocol, len(igLinks))
-	bs, err := g.GetRegionBackendService(name, g.region)
+	region := g.loadBalancerRegion(svc)
+	bs, err := g.GetRegionBackendService(name, region)
 	if err != nil && !isNotFound(err) {
 		return err
 	}
@@ -728,63 +1143,155 @@ func (g *Cloud) ensureInternalBackendService(name, description string, affinityT
 		Description:         description,
 		HealthChecks:        []string{hcLink},
 		Backends:            backends,
-		SessionAffinity:     translateAffinityType(affinityType),
+		SessionAffinity:     affinity,
 		LoadBalancingScheme: string(scheme),
 	}
+	if protocol == v1.ProtocolUDP && svc != nil && GetLoadBalancerAnnotationILBPreserveClientIP(svc) {
+		// The flows of the nodes which lost their local endpoints are
+		// diverted to the healthy nodes instead of being kept on them.
+		expectedBS.ConnectionTrackingPolicy = &compute.BackendServiceConnectionTrackingPolicy{
+			ConnectionPersistenceOnUnhealthyBackends: "NEVER_PERSIST",
+		}
+	}
+
+	// The security policy of the external backend services is the one of
+	// ServiceAnnotationLoadBalancerSecurityPolicy. It is attached and detached
+	// with SetSecurityPolicy, the backend service updates do not change it.
+	var managedFields sets.String
+	if scheme == cloud.SchemeExternal && svc != nil {
+		managedFields = sets.NewString("securityPolicy")
+	}
 
 	// Create backend service if none was found
 	if bs == nil {
 		klog.V(2).Infof("ensureInternalBackendService: creating backend service %v", name)
-		err := g.CreateRegionBackendService(expectedBS, g.region)
+		err := g.CreateRegionBackendService(expectedBS, region)
 		if err != nil {
 			return err
 		}
 		klog.V(2).Infof("ensureInternalBackendService: created backend service %v successfully", name)
+		if managedFields.Has("securityPolicy") {
+			return g.ensureRegionBackendServiceSecurityPolicy(svc, name, region, "")
+		}
 		return nil
 	}
 
-	if backendSvcEqual(expectedBS, bs) {
+	if expectedBS.ConnectionTrackingPolicy == nil {
+		// Keep the connection tracking policy set outside of Kubernetes. The
+		// one of ServiceAnnotationILBPreserveClientIP is kept once the
+		// annotation is removed, it is the default of UDP.
+		expectedBS.ConnectionTrackingPolicy = bs.ConnectionTrackingPolicy
+	}
+	if managedFields.Has("securityPolicy") {
+		if err := g.ensureRegionBackendServiceSecurityPolicy(svc, name, region, bs.SecurityPolicy); err != nil {
+			return err
+		}
+	}
+	reverted := reconcileBackendServiceFields(bs, expectedBS, preservedFields, managedFields)
+	if backendSvcEqual(expectedBS, bs) && len(reverted) == 0 {
 		return nil
 	}
 
 	klog.V(2).Infof("ensureInternalBackendService: updating backend service %v", name)
 	// Set fingerprint for optimistic locking
 	expectedBS.Fingerprint = bs.Fingerprint
-	if err := g.UpdateRegionBackendService(expectedBS, g.region); err != nil {
+	if err := g.UpdateRegionBackendService(expectedBS, region); err != nil {
 		return err
 	}
+	if len(reverted) > 0 {
+		klog.Warningf("ensureInternalBackendService: reverted settings %v of backend service %v", reverted, name)
+		g.raiseBackendServiceFieldsRevertedEvent(svc, name, reverted)
+	}
 	klog.V(2).Infof("ensureInternalBackendService: updated backend service %v successfully", name)
-	return nil
+	return g.deleteStaleSubnetInstanceGroups()
+}
+
+// ensureRegionBackendServiceSecurityPolicy attaches the security policy of
+// ServiceAnnotationLoadBalancerSecurityPolicy to the backend service name,
+// whose current security policy link is existing, or detaches it once the
+// annotation is removed.
+func (g *Cloud) ensureRegionBackendServiceSecurityPolicy(svc *v1.Service, name, region, existing string) error {
+	expected := g.getSecurityPolicyLink(GetLoadBalancerAnnotationSecurityPolicy(svc), region)
+	if existing == expected {
+		return nil
+	}
+	klog.V(2).Infof("ensureRegionBackendServiceSecurityPolicy: setting security policy %q of backend service %v", expected, name)
+	return g.SetSecurityPolicyForRegionBackendService(name, region, expected)
 }
 
 // ensureInternalBackendServiceGroups updates backend services if their list of backend instance groups is incorrect.
-func (g *Cloud) ensureInternalBackendServiceGroups(name string, igLinks []string) error {
+// It returns the previous backends of the backend service.
+func (g *Cloud) ensureInternalBackendServiceGroups(name, region string, igLinks []string) ([]*compute.Backend, error) {
 	klog.V(2).Infof("ensureInternalBackendServiceGroups(%v): checking existing backend service's groups", name)
-	bs, err := g.GetRegionBackendService(name, g.region)
+	bs, err := g.GetRegionBackendService(name, region)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	previous := bs.Backends
 	backends := backendsFromGroupLinks(igLinks)
-	if backendsListEqual(bs.Backends, backends) {
-		return nil
+	if backendsListEqual(previous, backends) {
+		return previous, nil
 	}
 
 	// Set the backend service's backends to the updated list.
 	bs.Backends = backends
 
 	klog.V(2).Infof("ensureInternalBackendServiceGroups: updating backend service %v", name)
-	if err := g.UpdateRegionBackendService(bs, g.region); err != nil {
-		return err
+	if err := g.UpdateRegionBackendService(bs, region); err != nil {
+		return nil, err
 	}
 	klog.V(2).Infof("ensureInternalBackendServiceGroups: updated backend service %v successfully", name)
-	return nil
+	if err := g.deleteStaleSubnetInstanceGroups(); err != nil {
+		return nil, err
+	}
+	return previous, nil
 }
 
 func shareBackendService(svc *v1.Service) bool {
 	return GetLoadBalancerAnnotationBackendShare(svc) && !servicehelpers.RequestsOnlyLocalTraffic(svc)
 }
 
+// backendServiceAffinity returns the GCE session affinity of the backend
+// service of svc: the one of ServiceAnnotationLoadBalancerSessionAffinity, or
+// else the one of the Service. The shared backend services are named after
+// it.
+func backendServiceAffinity(svc *v1.Service) string {
+	if affinity, err := GetLoadBalancerAnnotationSessionAffinity(svc); err == nil && affinity != "" {
+		return affinity
+	}
+	return translateAffinityType(svc.Spec.SessionAffinity)
+}
+
+// shareHealthCheck returns true if the Service uses the nodes health check
+// shared by the internal load balancers.
+func shareHealthCheck(svc *v1.Service) bool {
+	return !servicehelpers.RequestsOnlyLocalTraffic(svc) && !hasNodesHealthCheckOverride(svc) && !usesTCPHealthCheck(svc) && GetLoadBalancerAnnotationHealthCheck(svc) == "" && !hasHealthCheckSettingsOverride(svc)
+}
+
+// usesTCPHealthCheck returns true if the Service health checks its node port
+// with TCP instead of the HTTP health checks.
+func usesTCPHealthCheck(svc *v1.Service) bool {
+	hcType, err := GetLoadBalancerAnnotationHealthCheckType(svc)
+	return err == nil && hcType == HealthCheckTypeTCP
+}
+
+// tcpHealthCheckPort returns the node port health checked with TCP, the one
+// of the first TCP port of the Service. A UDP node port cannot be checked
+// with TCP.
+func tcpHealthCheckPort(svc *v1.Service) (int32, error) {
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol != v1.ProtocolTCP {
+			continue
+		}
+		if port.NodePort == 0 {
+			return 0, fmt.Errorf("annotation %s=%s requires the node ports of the Service, they must not be disabled by spec.allocateLoadBalancerNodePorts", ServiceAnnotationILBHealthCheckType, HealthCheckTypeTCP)
+		}
+		return port.NodePort, nil
+	}
+	return 0, fmt.Errorf("annotation %s=%s requires a TCP port, the UDP node ports cannot be health checked with TCP", ServiceAnnotationILBHealthCheckType, HealthCheckTypeTCP)
+}
+
 func backendsFromGroupLinks(igLinks []string) (backends []*compute.Backend) {
 	for _, igLink := range igLinks {
 		backends = append(backends, &compute.Backend{
@@ -815,11 +1322,22 @@ func newInternalLBHealthCheck(name string, svcName types.NamespacedName, shared
 	}
 }
 
+func newInternalLBTCPHealthCheck(name string, svcName types.NamespacedName, port int32) *compute.HealthCheck {
+	return &compute.HealthCheck{
+		Name:               name,
+		CheckIntervalSec:   gceHcCheckIntervalSeconds,
+		TimeoutSec:         gceHcTimeoutSeconds,
+		HealthyThreshold:   gceHcHealthyThreshold,
+		UnhealthyThreshold: gceHcUnhealthyThreshold,
+		TcpHealthCheck:     &compute.TCPHealthCheck{Port: int64(port)},
+		Type:               "TCP",
+		Description:        makeHealthCheckDescription(svcName.String()),
+	}
+}
+
 func firewallRuleEqual(a, b *compute.Firewall) bool {
 	return a.Description == b.Description &&
-		len(a.Allowed) == 1 && len(a.Allowed) == len(b.Allowed) &&
-		a.Allowed[0].IPProtocol == b.Allowed[0].IPProtocol &&
-		equalStringSets(a.Allowed[0].Ports, b.Allowed[0].Ports) &&
+		equalFirewallAllowed(a.Allowed, b.Allowed) &&
 		equalStringSets(a.SourceRanges, b.SourceRanges) &&
 		equalStringSets(a.DestinationRanges, b.DestinationRanges) &&
 		equalStringSets(a.TargetTags, b.TargetTags)
@@ -844,21 +1362,36 @@ func mergeHealthChecks(hc, newHC *compute.HealthCheck) {
 	if hc.HealthyThreshold > newHC.HealthyThreshold {
 		newHC.HealthyThreshold = hc.HealthyThreshold
 	}
+	// Keep the logging of the health checks which do not manage it.
+	if newHC.LogConfig == nil {
+		newHC.LogConfig = hc.LogConfig
+	}
+}
+
+// healthCheckLoggingEnabled returns true if the health check probes are logged.
+func healthCheckLoggingEnabled(hc *compute.HealthCheck) bool {
+	return hc.LogConfig != nil && hc.LogConfig.Enable
 }
 
 // needToUpdateHealthChecks checks whether the healthcheck needs to be updated.
 func needToUpdateHealthChecks(hc, newHC *compute.HealthCheck) bool {
+	if newHC.TcpHealthCheck != nil {
+		if hc.TcpHealthCheck == nil || hc.TcpHealthCheck.Port != newHC.TcpHealthCheck.Port {
+			return true
+		}
+	} else if hc.HttpHealthCheck == nil || newHC.HttpHealthCheck == nil ||
+		hc.HttpHealthCheck.Port != newHC.HttpHealthCheck.Port ||
+		hc.HttpHealthCheck.RequestPath != newHC.HttpHealthCheck.RequestPath {
+		return true
+	}
 	switch {
 	case
-		hc.HttpHealthCheck == nil,
-		newHC.HttpHealthCheck == nil,
-		hc.HttpHealthCheck.Port != newHC.HttpHealthCheck.Port,
-		hc.HttpHealthCheck.RequestPath != newHC.HttpHealthCheck.RequestPath,
 		hc.Description != newHC.Description,
 		hc.CheckIntervalSec < newHC.CheckIntervalSec,
 		hc.TimeoutSec < newHC.TimeoutSec,
 		hc.UnhealthyThreshold < newHC.UnhealthyThreshold,
-		hc.HealthyThreshold < newHC.HealthyThreshold:
+		hc.HealthyThreshold < newHC.HealthyThreshold,
+		newHC.LogConfig != nil && healthCheckLoggingEnabled(hc) != newHC.LogConfig.Enable:
 		return true
 	}
 	return false
@@ -891,7 +1424,20 @@ func backendSvcEqual(a, b *compute.BackendService) bool {
 		a.SessionAffinity == b.SessionAffinity &&
 		a.LoadBalancingScheme == b.LoadBalancingScheme &&
 		equalStringSets(a.HealthChecks, b.HealthChecks) &&
-		backendsListEqual(a.Backends, b.Backends)
+		backendsListEqual(a.Backends, b.Backends) &&
+		connectionTrackingPolicyEqual(a.ConnectionTrackingPolicy, b.ConnectionTrackingPolicy)
+}
+
+// connectionTrackingPolicyEqual compares the connection persistence of the
+// connection tracking policies, unset and DEFAULT_FOR_PROTOCOL being equal.
+func connectionTrackingPolicyEqual(a, b *compute.BackendServiceConnectionTrackingPolicy) bool {
+	persistence := func(p *compute.BackendServiceConnectionTrackingPolicy) string {
+		if p == nil || p.ConnectionPersistenceOnUnhealthyBackends == "" {
+			return "DEFAULT_FOR_PROTOCOL"
+		}
+		return p.ConnectionPersistenceOnUnhealthyBackends
+	}
+	return persistence(a) == persistence(b)
 }
 
 func getPortsAndProtocol(svcPorts []v1.ServicePort) (ports []string, portRanges []string, protocol v1.Protocol) {
@@ -952,8 +1498,17 @@ func getPortRanges(ports []int) (ranges []string) {
 	return ranges
 }
 
-func (g *Cloud) getBackendServiceLink(name string) string {
-	return g.projectsBasePath + strings.Join([]string{g.projectID, "regions", g.region, "backendServices", name}, "/")
+func (g *Cloud) getBackendServiceLink(name, region string) string {
+	return g.projectsBasePath + strings.Join([]string{g.projectID, "regions", region, "backendServices", name}, "/")
+}
+
+// getSecurityPolicyLink returns the link of the security policy name of
+// region, empty if name is.
+func (g *Cloud) getSecurityPolicyLink(name, region string) string {
+	if name == "" {
+		return ""
+	}
+	return g.projectsBasePath + strings.Join([]string{g.projectID, "regions", region, "securityPolicies", name}, "/")
 }
 
 func getNameFromLink(link string) string {
@@ -984,18 +1539,43 @@ func ilbIPToUse(svc *v1.Service, fwdRule *compute.ForwardingRule, requestedSubne
 
 func getILBOptions(svc *v1.Service) ILBOptions {
 	return ILBOptions{AllowGlobalAccess: GetLoadBalancerAnnotationAllowGlobalAccess(svc),
-		SubnetName: GetLoadBalancerAnnotationSubnet(svc),
+		SubnetName:              GetLoadBalancerAnnotationSubnet(svc),
+		AllowPSCPacketInjection: GetLoadBalancerAnnotationAllowPSCPacketInjection(svc),
 	}
 }
 
 type forwardingRuleDescription struct {
 	ServiceName string       `json:"kubernetes.io/service-name"`
 	APIVersion  meta.Version `json:"kubernetes.io/api-version,omitempty"`
+	// AdoptedForwardingRule is the name of the forwarding rule created
+	// outside of the cluster which was replaced by this one.
+	AdoptedForwardingRule string `json:"kubernetes.io/adopted-forwarding-rule,omitempty"`
+	// CustomFields are the fields set through the resource description
+	// annotation, encoded next to the fields above.
+	CustomFields map[string]string `json:"-"`
 }
 
 // marshal the description as a JSON-encoded string.
 func (d *forwardingRuleDescription) marshal() (string, error) {
-	out, err := json.Marshal(d)
+	if len(d.CustomFields) == 0 {
+		out, err := json.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		return string(out), err
+	}
+	fields := map[string]string{}
+	for k, v := range d.CustomFields {
+		fields[k] = v
+	}
+	fields["kubernetes.io/service-name"] = d.ServiceName
+	if d.APIVersion != "" {
+		fields["kubernetes.io/api-version"] = string(d.APIVersion)
+	}
+	if d.AdoptedForwardingRule != "" {
+		fields["kubernetes.io/adopted-forwarding-rule"] = d.AdoptedForwardingRule
+	}
+	out, err := json.Marshal(fields)
 	if err != nil {
 		return "", err
 	}
@@ -1004,7 +1584,23 @@ func (d *forwardingRuleDescription) marshal() (string, error) {
 
 // unmarshal desc JSON-encoded string into this structure.
 func (d *forwardingRuleDescription) unmarshal(desc string) error {
-	return json.Unmarshal([]byte(desc), d)
+	if err := json.Unmarshal([]byte(desc), d); err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(desc), &fields); err != nil {
+		return err
+	}
+	d.CustomFields = nil
+	for k, v := range fields {
+		if s, ok := v.(string); ok && !strings.HasPrefix(k, reservedDescriptionFieldPrefix) {
+			if d.CustomFields == nil {
+				d.CustomFields = map[string]string{}
+			}
+			d.CustomFields[k] = s
+		}
+	}
+	return nil
 }
 
 func getFwdRuleAPIVersion(rule *compute.ForwardingRule) (meta.Version, error) {
@@ -1021,27 +1617,97 @@ func getFwdRuleAPIVersion(rule *compute.ForwardingRule) (meta.Version, error) {
 	return d.APIVersion, nil
 }
 
-func (g *Cloud) ensureInternalForwardingRule(existingFwdRule, newFwdRule *compute.ForwardingRule) (err error) {
+func (g *Cloud) ensureInternalForwardingRule(existingFwdRule, newFwdRule *compute.ForwardingRule, region string) (err error) {
 	if existingFwdRule != nil {
 		if forwardingRulesEqual(existingFwdRule, newFwdRule) {
 			klog.V(4).Infof("existingFwdRule == newFwdRule, no updates needed (existingFwdRule == %+v)", existingFwdRule)
 			return nil
 		}
 		klog.V(2).Infof("ensureInternalLoadBalancer(%v): deleting existing forwarding rule with IP address %v", existingFwdRule.Name, existingFwdRule.IPAddress)
-		if err = ignoreNotFound(g.DeleteRegionForwardingRule(existingFwdRule.Name, g.region)); err != nil {
+		if err = ignoreNotFound(g.DeleteRegionForwardingRule(existingFwdRule.Name, region)); err != nil {
 			return err
 		}
 	}
 	// At this point, the existing rule has been deleted if required.
 	// Create the rule based on the api version determined
 	klog.V(2).Infof("ensureInternalLoadBalancer(%v): creating forwarding rule", newFwdRule.Name)
-	if err = g.CreateRegionForwardingRule(newFwdRule, g.region); err != nil {
+	if err = g.CreateRegionForwardingRule(newFwdRule, region); err != nil {
 		return err
 	}
 	klog.V(2).Infof("ensureInternalLoadBalancer(%v): created forwarding rule", newFwdRule.Name)
 	return nil
 }
 
+// createInternalForwardingRule creates the IPv4 forwarding rule of an internal
+// load balancer. Rules with beta only fields are created with the beta API,
+// and without those fields when the beta API is not available.
+func (g *Cloud) createInternalForwardingRule(svc *v1.Service, newFwdRule *compute.ForwardingRule, region string, description *forwardingRuleDescription, options ILBOptions) error {
+	if !options.AllowPSCPacketInjection {
+		return g.ensureInternalForwardingRule(nil, newFwdRule, region)
+	}
+
+	klog.V(2).Infof("ensureInternalLoadBalancer(%v): creating forwarding rule with the beta API", newFwdRule.Name)
+	version, err := g.callVersioned(meta.VersionBeta, versionedCall{
+		name: "createInternalForwardingRule",
+		ga: func() error {
+			return g.CreateRegionForwardingRule(newFwdRule, region)
+		},
+		beta: func() error {
+			betaFwdRule, err := toBetaForwardingRule(newFwdRule)
+			if err != nil {
+				return err
+			}
+			// The API version is recorded to read the beta fields when the rule is synced.
+			betaDescription := *description
+			betaDescription.APIVersion = meta.VersionBeta
+			if betaFwdRule.Description, err = betaDescription.marshal(); err != nil {
+				return err
+			}
+			betaFwdRule.AllowPscPacketInjection = options.AllowPSCPacketInjection
+			return g.CreateBetaRegionForwardingRule(betaFwdRule, region)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if version == meta.VersionGA {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "BetaAPIUnavailable", "Created forwarding rule %s without Private Service Connect packet injection, the Compute beta API is not available", newFwdRule.Name)
+	}
+	klog.V(2).Infof("ensureInternalLoadBalancer(%v): created forwarding rule with the %s API", newFwdRule.Name, version)
+	return nil
+}
+
+// internalForwardingRuleBetaFieldsChanged returns true if the beta only fields
+// of the existing forwarding rule differ from the options. They are only read
+// when requested by the options or when the rule was created with the beta
+// API, and are considered unchanged when the beta API is not available.
+func (g *Cloud) internalForwardingRuleBetaFieldsChanged(existingFwdRule *compute.ForwardingRule, region string, options ILBOptions) (bool, error) {
+	existingVersion, err := getFwdRuleAPIVersion(existingFwdRule)
+	if err != nil {
+		klog.Warningf("internalForwardingRuleBetaFieldsChanged(%v): %v", existingFwdRule.Name, err)
+	}
+	if !options.AllowPSCPacketInjection && existingVersion == meta.VersionGA {
+		return false, nil
+	}
+
+	changed := false
+	_, err = g.callVersioned(meta.VersionBeta, versionedCall{
+		name: "internalForwardingRuleBetaFieldsChanged",
+		ga: func() error {
+			return nil
+		},
+		beta: func() error {
+			betaFwdRule, err := g.GetBetaRegionForwardingRule(existingFwdRule.Name, region)
+			if err != nil {
+				return err
+			}
+			changed = betaFwdRule.AllowPscPacketInjection != options.AllowPSCPacketInjection
+			return nil
+		},
+	})
+	return changed, err
+}
+
 func forwardingRulesEqual(old, new *compute.ForwardingRule) bool {
 	// basepath could have differences like compute.googleapis.com vs www.googleapis.com, compare resourceIDs
 	oldResourceID, err := cloud.ParseResourceURL(old.BackendService)
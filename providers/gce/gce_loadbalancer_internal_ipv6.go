@@ -0,0 +1,278 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"github.com/google/go-cmp/cmp"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	netutils "k8s.io/utils/net"
+)
+
+const (
+	// ipVersionIPv6 is the forwarding rule IpVersion used for IPv6 VIPs.
+	ipVersionIPv6 = "IPV6"
+)
+
+// L3/4 health checkers reach IPv6 backends from within this known CIDR.
+var l4LbIPv6HealthCheckSrcRanges = []string{"2600:2d00:1:b029::/64"}
+
+// serviceRequestsIPv6 returns true if the Service requests an IPv6 address, i.e. it
+// is either a dual-stack or an IPv6 single-stack Service.
+func serviceRequestsIPv6(svc *v1.Service) bool {
+	for _, family := range svc.Spec.IPFamilies {
+		if family == v1.IPv6Protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// ipv6SourceRanges returns the IPv6 subset of the Service source ranges. If the
+// Service does not restrict the source ranges, all IPv6 sources are allowed.
+func ipv6SourceRanges(svc *v1.Service) ([]string, error) {
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 && svc.Annotations[v1.AnnotationLoadBalancerSourceRangesKey] == "" {
+		return []string{"::/0"}, nil
+	}
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []string
+	for _, cidr := range sourceRanges.StringSlice() {
+		if netutils.IsIPv6CIDRString(cidr) {
+			ranges = append(ranges, cidr)
+		}
+	}
+	return ranges, nil
+}
+
+// isIPv6SingleStackService returns true if the Service only requests IPv6
+// addresses. Internal load balancers always provision an IPv4 VIP, so these
+// Services are rejected.
+func isIPv6SingleStackService(svc *v1.Service) bool {
+	if len(svc.Spec.IPFamilies) == 0 {
+		return false
+	}
+	for _, family := range svc.Spec.IPFamilies {
+		if family == v1.IPv4Protocol {
+			return false
+		}
+	}
+	return true
+}
+
+// makeLoadBalancerIngress returns the ingress of a load balancer with an IPv4
+// and optionally an IPv6 VIP, ordered according to the IP families of the
+// Service so that the primary family comes first.
+func makeLoadBalancerIngress(svc *v1.Service, ipv4, ipv6 string) []v1.LoadBalancerIngress {
+	if ipv6 == "" {
+		return []v1.LoadBalancerIngress{{IP: ipv4}}
+	}
+	ipv6 = ipv6ForwardingRuleIP(ipv6)
+	if len(svc.Spec.IPFamilies) > 0 && svc.Spec.IPFamilies[0] == v1.IPv6Protocol {
+		return []v1.LoadBalancerIngress{{IP: ipv6}, {IP: ipv4}}
+	}
+	return []v1.LoadBalancerIngress{{IP: ipv4}, {IP: ipv6}}
+}
+
+// ipv6ForwardingRuleIP strips the prefix length GCE may report along with the
+// address of IPv6 forwarding rules, e.g. "fd20:1:2:3::/96".
+func ipv6ForwardingRuleIP(address string) string {
+	if ip, _, err := netutils.ParseCIDRSloppy(address); err == nil {
+		return ip.String()
+	}
+	return address
+}
+
+// ipv4SourceRanges returns the IPv4 subset of the Service source ranges. If the
+// Service does not restrict the source ranges, all IPv4 sources are allowed.
+func ipv4SourceRanges(svc *v1.Service) ([]string, error) {
+	sourceRanges, err := servicehelpers.GetLoadBalancerSourceRanges(svc)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []string
+	for _, cidr := range sourceRanges.StringSlice() {
+		if netutils.IsIPv4CIDRString(cidr) {
+			ranges = append(ranges, cidr)
+		}
+	}
+	return ranges, nil
+}
+
+// newInternalIPv6ForwardingRule builds the IPv6 counterpart of the IPv4
+// forwarding rule of an internal load balancer. Both rules point at the same
+// backend service, so adding the IPv6 VIP does not require any change to the
+// IPv4 forwarding rule.
+func newInternalIPv6ForwardingRule(ipv4FwdRule *compute.ForwardingRule) *compute.ForwardingRule {
+	return &compute.ForwardingRule{
+		Name:                makeIPv6ResourceName(ipv4FwdRule.Name),
+		Description:         ipv4FwdRule.Description,
+		BackendService:      ipv4FwdRule.BackendService,
+		Ports:               ipv4FwdRule.Ports,
+		AllPorts:            ipv4FwdRule.AllPorts,
+		IPProtocol:          ipv4FwdRule.IPProtocol,
+		IpVersion:           ipVersionIPv6,
+		LoadBalancingScheme: ipv4FwdRule.LoadBalancingScheme,
+		Subnetwork:          ipv4FwdRule.Subnetwork,
+		Network:             ipv4FwdRule.Network,
+		AllowGlobalAccess:   ipv4FwdRule.AllowGlobalAccess,
+	}
+}
+
+// deleteStaleInternalIPv6ForwardingRule deletes the IPv6 forwarding rule of the
+// load balancer if it has drifted from the desired rule, and tears down all IPv6
+// resources if the Service no longer requests IPv6. It must be called before the
+// backend service is updated, as a backend service cannot change protocol while
+// a forwarding rule with the old protocol is still linked to it. The address of
+// an existing rule is carried over to newIPv6FwdRule, so that the IPv6 VIP is
+// kept when the rule is recreated. The forwarding rule left in place, if any,
+// is returned.
+func (g *Cloud) deleteStaleInternalIPv6ForwardingRule(svc *v1.Service, loadBalancerName, region string, newIPv6FwdRule *compute.ForwardingRule, hcFirewallName string) (*compute.ForwardingRule, error) {
+	existing, err := g.GetRegionForwardingRule(newIPv6FwdRule.Name, region)
+	if err != nil {
+		return nil, ignoreNotFound(err)
+	}
+	if !serviceRequestsIPv6(svc) {
+		klog.V(2).Infof("deleteStaleInternalIPv6ForwardingRule(%v): IPv6 is no longer requested, deleting IPv6 resources", loadBalancerName)
+		return nil, g.teardownInternalIPv6LoadBalancer(svc, loadBalancerName, region, hcFirewallName)
+	}
+
+	newIPv6FwdRule.IPAddress = existing.IPAddress
+	if forwardingRulesEqual(existing, newIPv6FwdRule) {
+		return existing, nil
+	}
+	if klogV := klog.V(2); klogV.Enabled() {
+		frDiff := cmp.Diff(existing, newIPv6FwdRule)
+		klogV.Infof("deleteStaleInternalIPv6ForwardingRule(%v): IPv6 forwarding rule changed - Diff(-existing, +new) - %s\n. Deleting existing forwarding rule.", loadBalancerName, frDiff)
+	}
+	if err := ignoreNotFound(g.DeleteRegionForwardingRule(existing.Name, region)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ensureInternalIPv6LoadBalancer creates the IPv6 forwarding rule and firewalls
+// of a dual-stack internal load balancer next to the IPv4 resources. The
+// forwarding rule is only created when missing, so Services moving from
+// single-stack to dual-stack keep their IPv4 forwarding rule and VIP untouched.
+// It returns the IPv6 VIP.
+func (g *Cloud) ensureInternalIPv6LoadBalancer(svc *v1.Service, nm types.NamespacedName, loadBalancerName, clusterID, region string, existingIPv6FwdRule, newIPv6FwdRule *compute.ForwardingRule, healthCheckPort string, sharedHealthCheck bool, nodes []*v1.Node) (string, error) {
+	if existingIPv6FwdRule == nil {
+		if err := g.ensureInternalForwardingRule(nil, newIPv6FwdRule, region); err != nil {
+			return "", err
+		}
+	}
+	fwdRule, err := g.GetRegionForwardingRule(newIPv6FwdRule.Name, region)
+	if err != nil {
+		return "", err
+	}
+
+	if err := g.ensureIPv6Firewalls(svc, nm, loadBalancerName, clusterID, fwdRule, healthCheckPort, sharedHealthCheck, nodes); err != nil {
+		return "", err
+	}
+	return fwdRule.IPAddress, nil
+}
+
+// ensureIPv6Firewalls ensures the firewalls allowing the IPv6 traffic of the
+// IPv6 forwarding rule fwdRule of a load balancer backed by a backend
+// service, and the probes of its IPv6 health checkers, to the nodes.
+func (g *Cloud) ensureIPv6Firewalls(svc *v1.Service, nm types.NamespacedName, loadBalancerName, clusterID string, fwdRule *compute.ForwardingRule, healthCheckPort string, sharedHealthCheck bool, nodes []*v1.Node) error {
+	sourceRanges, err := ipv6SourceRanges(svc)
+	if err != nil {
+		return err
+	}
+	fwName := MakeFirewallName(fwdRule.Name)
+	if len(sourceRanges) == 0 {
+		// Only IPv4 source ranges are allowed, so no IPv6 traffic firewall is needed.
+		if err := g.deleteInternalFirewall(svc, loadBalancerName, fwName); err != nil {
+			return err
+		}
+	} else {
+		_, portRanges, protocol := getPortsAndProtocol(svc.Spec.Ports)
+		fwDesc := makeFirewallDescription(nm.String(), fwdRule.IPAddress)
+		if err := g.ensureInternalFirewall(svc, fwName, fwDesc, fwdRule.IPAddress, sourceRanges, portRanges, protocol, nodes, ""); err != nil {
+			return err
+		}
+	}
+
+	// GCE firewalls cannot mix IPv4 and IPv6 source ranges, so the IPv6 health
+	// checkers are allowed by a dedicated firewall.
+	fwHCName := makeIPv6ResourceName(makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck))
+	return g.ensureInternalFirewall(svc, fwHCName, "", "", l4LbIPv6HealthCheckSrcRanges, []string{healthCheckPort}, v1.ProtocolTCP, nodes, "")
+}
+
+// ensureInternalIPv6LoadBalancerDeleted removes the IPv6 forwarding rule of an
+// internal load balancer and its firewalls. Nothing is deleted if there is no
+// IPv6 forwarding rule, so single-stack Services never touch the IPv6 firewalls.
+// hcFirewallName is the IPv6 health check firewall, or empty if that firewall
+// is shared with other Services.
+func (g *Cloud) ensureInternalIPv6LoadBalancerDeleted(svc *v1.Service, loadBalancerName, region, hcFirewallName string) error {
+	if _, err := g.GetRegionForwardingRule(makeIPv6ResourceName(loadBalancerName), region); err != nil {
+		return ignoreNotFound(err)
+	}
+	return g.teardownInternalIPv6LoadBalancer(svc, loadBalancerName, region, hcFirewallName)
+}
+
+// teardownInternalIPv6LoadBalancer deletes the IPv6 firewalls before the IPv6
+// forwarding rule, so that a failed teardown is retried on the next sync.
+func (g *Cloud) teardownInternalIPv6LoadBalancer(svc *v1.Service, loadBalancerName, region, hcFirewallName string) error {
+	ipv6FwdRuleName := makeIPv6ResourceName(loadBalancerName)
+	klog.V(2).Infof("teardownInternalIPv6LoadBalancer(%v): deleting IPv6 firewall for traffic", loadBalancerName)
+	if err := g.deleteInternalFirewall(svc, loadBalancerName, MakeFirewallName(ipv6FwdRuleName)); err != nil {
+		return err
+	}
+	if hcFirewallName != "" {
+		klog.V(2).Infof("teardownInternalIPv6LoadBalancer(%v): deleting IPv6 health check firewall %v", loadBalancerName, hcFirewallName)
+		if err := g.deleteInternalFirewall(svc, loadBalancerName, hcFirewallName); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("teardownInternalIPv6LoadBalancer(%v): deleting region internal IPv6 forwarding rule", loadBalancerName)
+	return ignoreNotFound(g.DeleteRegionForwardingRule(ipv6FwdRuleName, region))
+}
+
+// makeIPv6HealthCheckFirewallName returns the name of the IPv6 health check
+// firewall owned by the load balancer, or an empty string if the firewall is
+// shared with other Services and must outlive this load balancer.
+func makeIPv6HealthCheckFirewallName(loadBalancerName, clusterID string, sharedHealthCheck bool) string {
+	if sharedHealthCheck {
+		return ""
+	}
+	return makeIPv6ResourceName(makeHealthCheckFirewallName(loadBalancerName, clusterID, sharedHealthCheck))
+}
+
+// deleteInternalFirewall deletes the firewall, raising an event instead if the
+// cluster is on XPN and lacks the permission to do so.
+func (g *Cloud) deleteInternalFirewall(svc *v1.Service, loadBalancerName, fwName string) error {
+	if err := ignoreNotFound(g.DeleteFirewall(fwName)); err != nil {
+		if isForbidden(err) && g.OnXPN() {
+			klog.V(2).Infof("deleteInternalFirewall(%v): could not delete firewall %v on XPN cluster. Raising event.", loadBalancerName, fwName)
+			g.raiseFirewallChangeNeededEvent(svc, FirewallToGCloudDeleteCmd(fwName, g.NetworkProjectID()))
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxILBSubsetNodesPerZone is the maximum number of nodes per zone in the
+	// network endpoint groups of the internal load balancers which forward
+	// the traffic to the endpoints of the other nodes.
+	maxILBSubsetNodesPerZone = 25
+	// maxNetworkEndpointsPerRequest is the maximum number of endpoints
+	// attached to or detached from a network endpoint group per request.
+	maxNetworkEndpointsPerRequest = 500
+	// networkEndpointTypeGCEVMIP is the type of the network endpoint groups
+	// of the internal passthrough load balancers, whose endpoints are
+	// instances.
+	networkEndpointTypeGCEVMIP = "GCE_VM_IP"
+)
+
+// usesILBSubsetting returns true if the backends of the internal load
+// balancer of svc are zonal network endpoint groups instead of the instance
+// groups of the nodes, see ServiceAnnotationILBSubsetting. The network
+// endpoint groups require a VPC network.
+func (g *Cloud) usesILBSubsetting(svc *v1.Service) bool {
+	if g.IsLegacyNetwork() {
+		return false
+	}
+	if subsetting, ok := GetLoadBalancerAnnotationILBSubsetting(svc); ok {
+		return subsetting
+	}
+	return g.ilbSubsetting
+}
+
+// ensureInternalNEGs ensures the GCE_VM_IP network endpoint groups name of
+// every zone where a K8s node exists, whose endpoints are the nodes of the
+// subset of the load balancer, and returns their links. The nodes are all in
+// the subset of the Services with externalTrafficPolicy=Local, as only the
+// nodes of their endpoints pass the health check. The nodes of the other
+// subnetworks of a zone are in the network endpoint groups of their
+// subnetwork, see makeSubnetInstanceGroupName.
+func (g *Cloud) ensureInternalNEGs(svc *v1.Service, name string, nodes []*v1.Node) ([]string, error) {
+	zonedNodes := splitNodesByZone(nodes)
+	klog.V(2).Infof("ensureInternalNEGs(%v): %d nodes over %d zones in region %v", name, len(nodes), len(zonedNodes), g.region)
+
+	var negLinks []string
+	for zone, zNodes := range zonedNodes {
+		hosts, err := g.getFoundInstanceByNames(nodeNames(zNodes))
+		if err != nil {
+			return nil, err
+		}
+		if !servicehelpers.RequestsOnlyLocalTraffic(svc) {
+			hosts = ilbSubset(name, hosts, maxILBSubsetNodesPerZone)
+		}
+		bySubnet := map[string][]string{}
+		for _, h := range hosts {
+			bySubnet[h.Subnetwork] = append(bySubnet[h.Subnetwork], h.Name)
+		}
+		mainSubnet, _ := matchSubnetwork(g.SubnetworkURL(), bySubnet)
+		for subnet, names := range bySubnet {
+			negName := name
+			if subnet != mainSubnet && len(bySubnet) > 1 {
+				negName = makeSubnetInstanceGroupName(name, subnet)
+			}
+			if subnet == "" {
+				subnet = g.SubnetworkURL()
+			}
+			negLink, err := g.ensureInternalNEG(negName, zone, subnet, names)
+			if err != nil {
+				return nil, err
+			}
+			negLinks = append(negLinks, negLink)
+		}
+	}
+	return negLinks, nil
+}
+
+// ensureInternalNEG ensures the GCE_VM_IP network endpoint group name of the
+// subnetwork subnetURL in zone has the instances as endpoints, and returns
+// its link.
+func (g *Cloud) ensureInternalNEG(name, zone, subnetURL string, instances []string) (string, error) {
+	klog.V(2).Infof("ensureInternalNEG(%v, %v): checking network endpoint group that it contains %v nodes", name, zone, instances)
+	neg, err := g.GetNetworkEndpointGroup(name, zone)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+
+	negInstances := sets.NewString()
+	if neg == nil {
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): creating network endpoint group", name, zone)
+		newNEG := &computebeta.NetworkEndpointGroup{
+			Name:                name,
+			NetworkEndpointType: networkEndpointTypeGCEVMIP,
+			Network:             g.NetworkURL(),
+			Subnetwork:          subnetURL,
+		}
+		if err := g.CreateNetworkEndpointGroup(newNEG, zone); err != nil {
+			return "", err
+		}
+	} else {
+		if neg.NetworkEndpointType != networkEndpointTypeGCEVMIP {
+			return "", fmt.Errorf("network endpoint group %s in zone %s has endpoints of type %s instead of %s", name, zone, neg.NetworkEndpointType, networkEndpointTypeGCEVMIP)
+		}
+		endpoints, err := g.ListNetworkEndpoints(name, zone, false)
+		if err != nil {
+			return "", err
+		}
+		for _, ep := range endpoints {
+			if ep.NetworkEndpoint != nil {
+				negInstances.Insert(path.Base(ep.NetworkEndpoint.Instance))
+			}
+		}
+	}
+
+	gceNodes := sets.NewString(instances...)
+	removeNodes := negInstances.Difference(gceNodes).List()
+	addNodes := gceNodes.Difference(negInstances).List()
+
+	for _, batch := range networkEndpointBatches(removeNodes) {
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): detaching %d nodes", name, zone, len(batch))
+		// Possible we'll receive 404's here if the instance was deleted before getting to this point.
+		if err := g.DetachNetworkEndpoints(name, zone, batch); err != nil && !isNotFound(err) {
+			return "", err
+		}
+	}
+	for _, batch := range networkEndpointBatches(addNodes) {
+		klog.V(2).Infof("ensureInternalNEG(%v, %v): attaching %d nodes", name, zone, len(batch))
+		if err := g.AttachNetworkEndpoints(name, zone, batch); err != nil {
+			return "", err
+		}
+	}
+
+	return g.getNetworkEndpointGroupLink(name, zone), nil
+}
+
+// ensureInternalNEGsDeleted deletes the network endpoint groups of the
+// internal load balancer name in all the zones of region. They must no longer
+// be backends of the backend service.
+func (g *Cloud) ensureInternalNEGsDeleted(name, region string) error {
+	zones, err := g.ListZonesInRegion(region)
+	if err != nil {
+		return err
+	}
+	for _, zone := range zones {
+		negs, err := g.ListNetworkEndpointGroup(zone.Name)
+		if err != nil {
+			return err
+		}
+		for _, neg := range negs {
+			if neg.Name != name && !isSubnetInstanceGroupName(name, neg.Name) {
+				continue
+			}
+			klog.V(2).Infof("ensureInternalNEGsDeleted(%v): deleting network endpoint group %v in zone %v", name, neg.Name, zone.Name)
+			if err := g.DeleteNetworkEndpointGroup(neg.Name, zone.Name); err != nil && !isNotFoundOrInUse(err) {
+				return err
+			}
+		}
+	}
+	// The network endpoint groups recorded to delete are deleted above.
+	for zone, negNames := range g.staleNEGs.list() {
+		for _, negName := range negNames {
+			if negName == name || isSubnetInstanceGroupName(name, negName) {
+				g.staleNEGs.remove(zone, negName)
+			}
+		}
+	}
+	return nil
+}
+
+// recordDroppedNEGs records the network endpoint groups of previous, the
+// previous backends of a backend service, which are not in keepLinks, so that
+// deleteStaleNEGs deletes them.
+func (g *Cloud) recordDroppedNEGs(previous []*compute.Backend, keepLinks []string) {
+	keep := sets.NewString(keepLinks...)
+	for _, b := range previous {
+		if !strings.Contains(b.Group, "/networkEndpointGroups/") || keep.Has(b.Group) {
+			continue
+		}
+		resource, err := cloud.ParseResourceURL(b.Group)
+		if err != nil || resource.Key.Zone == "" {
+			klog.Warningf("recordDroppedNEGs: ignoring backend %q, not a zonal network endpoint group: %v", b.Group, err)
+			continue
+		}
+		g.staleNEGs.add(resource.Key.Zone, resource.Key.Name)
+	}
+}
+
+// deleteStaleNEGs deletes the recorded network endpoint groups of the internal
+// load balancer name. The ones still used by a backend service are deleted by
+// a later sync, the ones of keepLinks, backends again, are no longer deleted.
+func (g *Cloud) deleteStaleNEGs(name string, keepLinks []string) error {
+	keep := sets.NewString(keepLinks...)
+	for zone, negNames := range g.staleNEGs.list() {
+		for _, negName := range negNames {
+			if negName != name && !isSubnetInstanceGroupName(name, negName) {
+				continue
+			}
+			if keep.Has(g.getNetworkEndpointGroupLink(negName, zone)) {
+				g.staleNEGs.remove(zone, negName)
+				continue
+			}
+			err := g.DeleteNetworkEndpointGroup(negName, zone)
+			if isInUsedByError(err) {
+				klog.V(2).Infof("deleteStaleNEGs(%v): network endpoint group %s in zone %s is still used, deleting it later", name, negName, zone)
+				continue
+			}
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			klog.V(2).Infof("deleteStaleNEGs(%v): deleted network endpoint group %s in zone %s", name, negName, zone)
+			g.staleNEGs.remove(zone, negName)
+		}
+	}
+	return nil
+}
+
+// hasNEGBackends returns true if a backend of bs is a network endpoint group.
+func hasNEGBackends(bs *compute.BackendService) bool {
+	for _, b := range bs.Backends {
+		if strings.Contains(b.Group, "/networkEndpointGroups/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ilbSubset returns the n hosts of the subset of the load balancer name, the
+// ones with the highest rendezvous hash of their name. The subsets of the
+// load balancers are spread over the nodes, and only change by the nodes
+// added or removed.
+func ilbSubset(name string, hosts []*gceInstance, n int) []*gceInstance {
+	if len(hosts) <= n {
+		return hosts
+	}
+	scores := make(map[string][]byte, len(hosts))
+	for _, h := range hosts {
+		score := sha1.Sum([]byte(name + "/" + h.Name))
+		scores[h.Name] = score[:]
+	}
+	subset := append([]*gceInstance(nil), hosts...)
+	sort.Slice(subset, func(i, j int) bool {
+		return bytes.Compare(scores[subset[i].Name], scores[subset[j].Name]) > 0
+	})
+	return subset[:n]
+}
+
+// networkEndpointBatches returns the endpoints of the instances by batches
+// of up to maxNetworkEndpointsPerRequest.
+func networkEndpointBatches(instances []string) [][]*computebeta.NetworkEndpoint {
+	var batches [][]*computebeta.NetworkEndpoint
+	for len(instances) > 0 {
+		n := len(instances)
+		if n > maxNetworkEndpointsPerRequest {
+			n = maxNetworkEndpointsPerRequest
+		}
+		batch := make([]*computebeta.NetworkEndpoint, 0, n)
+		for _, instance := range instances[:n] {
+			batch = append(batch, &computebeta.NetworkEndpoint{Instance: instance})
+		}
+		batches = append(batches, batch)
+		instances = instances[n:]
+	}
+	return batches
+}
+
+func (g *Cloud) getNetworkEndpointGroupLink(name, zone string) string {
+	return g.projectsBasePath + strings.Join([]string{g.projectID, "zones", zone, "networkEndpointGroups", name}, "/")
+}
@@ -0,0 +1,281 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	computebeta "google.golang.org/api/compute/v0.beta"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// fakeGCECloudWithNEGEndpoints returns a fake cloud whose network endpoint
+// groups keep their endpoints, as the instances by the key of their group.
+func fakeGCECloudWithNEGEndpoints(t *testing.T, vals TestClusterValues) (*Cloud, map[meta.Key]sets.String) {
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.unsafeSubnetworkURL = testSubnetworkPrefix + "nodes"
+
+	endpoints := map[meta.Key]sets.String{}
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockBetaNetworkEndpointGroups.AttachNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsAttachEndpointsRequest, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) error {
+		assert.LessOrEqual(t, len(req.NetworkEndpoints), maxNetworkEndpointsPerRequest)
+		if endpoints[*key] == nil {
+			endpoints[*key] = sets.NewString()
+		}
+		for _, ep := range req.NetworkEndpoints {
+			endpoints[*key].Insert(ep.Instance)
+		}
+		return nil
+	}
+	mockGCE.MockBetaNetworkEndpointGroups.DetachNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsDetachEndpointsRequest, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) error {
+		for _, ep := range req.NetworkEndpoints {
+			endpoints[*key].Delete(ep.Instance)
+		}
+		return nil
+	}
+	mockGCE.MockBetaNetworkEndpointGroups.ListNetworkEndpointsHook = func(ctx context.Context, key *meta.Key, req *computebeta.NetworkEndpointGroupsListEndpointsRequest, fl *filter.F, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) ([]*computebeta.NetworkEndpointWithHealthStatus, error) {
+		var ret []*computebeta.NetworkEndpointWithHealthStatus
+		for _, instance := range endpoints[*key].List() {
+			ret = append(ret, &computebeta.NetworkEndpointWithHealthStatus{
+				NetworkEndpoint: &computebeta.NetworkEndpoint{Instance: fmt.Sprintf("zones/%s/instances/%s", key.Zone, instance)},
+			})
+		}
+		return ret, nil
+	}
+	return gce, endpoints
+}
+
+func makeTestNodeNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("test-node-%d", i)
+	}
+	return names
+}
+
+func backendGroups(t *testing.T, gce *Cloud, bsName string) sets.String {
+	bs, err := gce.GetRegionBackendService(bsName, gce.region)
+	require.NoError(t, err)
+	groups := sets.NewString()
+	for _, b := range bs.Backends {
+		groups.Insert(b.Group)
+	}
+	return groups
+}
+
+func TestEnsureInternalLoadBalancerNEGSubsetting(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, endpoints := fakeGCECloudWithNEGEndpoints(t, vals)
+	gce.ilbSubsetting = true
+	nodeNames := makeTestNodeNames(maxILBSubsetNodesPerZone + 5)
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendShare] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	negKey := *meta.ZonalKey(lbName, vals.ZoneName)
+
+	// The backends of the load balancer are a subset of the nodes of the
+	// zone, in its network endpoint group, and its backend service is not
+	// shared.
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	neg, err := gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, networkEndpointTypeGCEVMIP, neg.NetworkEndpointType)
+	assert.Equal(t, gce.SubnetworkURL(), neg.Subnetwork)
+	assert.Equal(t, maxILBSubsetNodesPerZone, endpoints[negKey].Len())
+	assert.True(t, sets.NewString(nodeNames...).IsSuperset(endpoints[negKey]))
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, false, cloud.SchemeInternal, v1.ProtocolTCP, backendServiceAffinity(svc))
+	assert.Equal(t, sets.NewString(gce.getNetworkEndpointGroupLink(lbName, vals.ZoneName)), backendGroups(t, gce, bsName))
+	_, err = gce.GetInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName)
+	assert.True(t, isNotFound(err), "instance group created: %v", err)
+
+	// The nodes removed from the subset are replaced.
+	subset := endpoints[negKey].List()
+	var remaining []*v1.Node
+	for _, node := range nodes {
+		if node.Name != subset[0] {
+			remaining = append(remaining, node)
+		}
+	}
+	require.NoError(t, gce.updateInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, remaining))
+	assert.Equal(t, maxILBSubsetNodesPerZone, endpoints[negKey].Len())
+	assert.False(t, endpoints[negKey].Has(subset[0]))
+	assert.Len(t, sets.NewString(subset...).Difference(endpoints[negKey]), 1)
+
+	// All the nodes are backends of a Service with externalTrafficPolicy=Local.
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, sets.NewString(nodeNames...), endpoints[negKey])
+
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+}
+
+func TestEnsureInternalLoadBalancerNEGSubsettingAnnotation(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, endpoints := fakeGCECloudWithNEGEndpoints(t, vals)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBSubsetting] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, false, cloud.SchemeInternal, v1.ProtocolTCP, backendServiceAffinity(svc))
+
+	status, err := gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	negLink := gce.getNetworkEndpointGroupLink(lbName, vals.ZoneName)
+	assert.Equal(t, sets.NewString(negLink), backendGroups(t, gce, bsName))
+	assert.Equal(t, sets.NewString("test-node-1", "test-node-2"), endpoints[*meta.ZonalKey(lbName, vals.ZoneName)])
+
+	// The network endpoint group is replaced by the instance groups without
+	// changing the IP of the load balancer.
+	svc.Annotations[ServiceAnnotationILBSubsetting] = "false"
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	newStatus, err := gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, existingFwdRule, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, status.Ingress[0].IP, newStatus.Ingress[0].IP)
+	ig, err := gce.GetInstanceGroup(makeInstanceGroupName(vals.ClusterID), vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, sets.NewString(ig.SelfLink), backendGroups(t, gce, bsName))
+	_, err = gce.GetNetworkEndpointGroup(lbName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+
+	// The annotation overrides the subsetting of the cluster.
+	gce.ilbSubsetting = true
+	assert.False(t, gce.usesILBSubsetting(svc))
+	delete(svc.Annotations, ServiceAnnotationILBSubsetting)
+	assert.True(t, gce.usesILBSubsetting(svc))
+}
+
+func TestEnsureInternalNEGsZones(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, endpoints := fakeGCECloudWithNEGEndpoints(t, vals)
+	gce.ilbSubsetting = true
+	zoneA, zoneB := vals.ZoneName, vals.SecondaryZoneName
+	gce.c.(*cloud.MockGCE).MockZones.Objects[*meta.GlobalKey(zoneB)] = &cloud.MockZonesObj{
+		Obj: &compute.Zone{Name: zoneB, Region: gce.getRegionLink(vals.Region)},
+	}
+	nodesA, err := createAndInsertNodes(gce, []string{"test-node-a"}, zoneA)
+	require.NoError(t, err)
+	nodesB, err := createAndInsertNodes(gce, []string{"test-node-b"}, zoneB)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, false, cloud.SchemeInternal, v1.ProtocolTCP, backendServiceAffinity(svc))
+
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, append(nodesA, nodesB...))
+	require.NoError(t, err)
+	assert.Equal(t, sets.NewString(gce.getNetworkEndpointGroupLink(lbName, zoneA), gce.getNetworkEndpointGroupLink(lbName, zoneB)), backendGroups(t, gce, bsName))
+	assert.Equal(t, sets.NewString("test-node-b"), endpoints[*meta.ZonalKey(lbName, zoneB)])
+
+	// The network endpoint group of the zone without nodes is deleted once
+	// the backend service no longer uses it, without listing them.
+	mockGCE := gce.c.(*cloud.MockGCE)
+	var lists int
+	mockGCE.MockBetaNetworkEndpointGroups.ListHook = func(ctx context.Context, zone string, fl *filter.F, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) (bool, []*computebeta.NetworkEndpointGroup, error) {
+		lists++
+		return false, nil, nil
+	}
+	mockGCE.MockBetaNetworkEndpointGroups.DeleteHook = func(ctx context.Context, key *meta.Key, m *cloud.MockBetaNetworkEndpointGroups, options ...cloud.Option) (bool, error) {
+		return true, &googleapi.Error{Code: http.StatusBadRequest, Message: "The network endpoint group resource is already being used by backend service"}
+	}
+	require.NoError(t, gce.updateInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nodesA))
+	assert.Equal(t, sets.NewString(gce.getNetworkEndpointGroupLink(lbName, zoneA)), backendGroups(t, gce, bsName))
+	_, err = gce.GetNetworkEndpointGroup(lbName, zoneB)
+	assert.NoError(t, err)
+
+	mockGCE.MockBetaNetworkEndpointGroups.DeleteHook = nil
+	require.NoError(t, gce.updateInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nodesA))
+	_, err = gce.GetNetworkEndpointGroup(lbName, zoneB)
+	assert.True(t, isNotFound(err), "network endpoint group not deleted: %v", err)
+	_, err = gce.GetNetworkEndpointGroup(lbName, zoneA)
+	assert.NoError(t, err)
+	assert.Zero(t, lists)
+	assert.Empty(t, gce.staleNEGs.list())
+}
+
+func TestILBSubset(t *testing.T) {
+	t.Parallel()
+
+	var hosts []*gceInstance
+	for _, name := range makeTestNodeNames(100) {
+		hosts = append(hosts, &gceInstance{Name: name})
+	}
+	subsetNames := func(name string, hosts []*gceInstance) sets.String {
+		subset := ilbSubset(name, hosts, maxILBSubsetNodesPerZone)
+		require.Len(t, subset, maxILBSubsetNodesPerZone)
+		names := sets.NewString()
+		for _, h := range subset {
+			names.Insert(h.Name)
+		}
+		return names
+	}
+
+	subset := subsetNames("lb-1", hosts)
+	assert.Equal(t, subset, subsetNames("lb-1", hosts), "subset is not stable")
+	assert.NotEqual(t, subset, subsetNames("lb-2", hosts), "subsets of load balancers are the same")
+	assert.Len(t, ilbSubset("lb-1", hosts[:10], maxILBSubsetNodesPerZone), 10)
+
+	// Removing a node of the subset only replaces it.
+	removed := subset.List()[0]
+	var remaining []*gceInstance
+	for _, h := range hosts {
+		if h.Name != removed {
+			remaining = append(remaining, h)
+		}
+	}
+	newSubset := subsetNames("lb-1", remaining)
+	assert.False(t, newSubset.Has(removed))
+	assert.Equal(t, subset.Len()-1, subset.Intersection(newSubset).Len())
+}
@@ -0,0 +1,214 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ServiceConditionPSCServiceAttachmentReady is the condition of the
+	// Services annotated with ServiceAnnotationPSCServiceAttachment, true
+	// once their service attachment is published. Its message has the URL of
+	// the service attachment, which the consumers connect to.
+	ServiceConditionPSCServiceAttachmentReady = "networking.gke.io/PSCServiceAttachmentReady"
+
+	pscSubnetworkPurpose = "PRIVATE_SERVICE_CONNECT"
+)
+
+// ensureInternalServiceAttachment publishes the forwarding rule fwdRuleLink
+// of the internal load balancer of svc with the service attachment
+// loadBalancerName while svc is annotated with
+// ServiceAnnotationPSCServiceAttachment, and deletes the service attachment
+// otherwise. The result is reported with the
+// ServiceConditionPSCServiceAttachmentReady condition of svc.
+func (g *Cloud) ensureInternalServiceAttachment(svc *v1.Service, loadBalancerName, region, fwdRuleLink string) error {
+	config, err := GetLoadBalancerAnnotationPSCServiceAttachment(svc)
+	if err == nil && config == nil {
+		// Most Services never had a service attachment, it is only deleted
+		// if found.
+		sa, err := g.GetServiceAttachment(loadBalancerName, region)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if sa != nil {
+			if err := g.ensureInternalServiceAttachmentDeleted(loadBalancerName, region); err != nil {
+				return err
+			}
+		}
+		return g.removeServiceCondition(svc, ServiceConditionPSCServiceAttachmentReady)
+	}
+
+	var sa *compute.ServiceAttachment
+	if err == nil {
+		sa, err = g.syncInternalServiceAttachment(svc, loadBalancerName, region, fwdRuleLink, config)
+	}
+	if err != nil {
+		g.setServiceCondition(svc, metav1.Condition{
+			Type:    ServiceConditionPSCServiceAttachmentReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SyncFailed",
+			Message: err.Error(),
+		})
+		return err
+	}
+	return g.setServiceCondition(svc, metav1.Condition{
+		Type:    ServiceConditionPSCServiceAttachmentReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Published",
+		Message: fmt.Sprintf("Service attachment %s is published, %d consumer endpoints are connected", sa.SelfLink, len(sa.ConnectedEndpoints)),
+	})
+}
+
+// syncInternalServiceAttachment creates, patches or recreates the service
+// attachment loadBalancerName and returns it.
+func (g *Cloud) syncInternalServiceAttachment(svc *v1.Service, loadBalancerName, region, fwdRuleLink string, config *PSCServiceAttachmentConfig) (*compute.ServiceAttachment, error) {
+	natSubnets, err := g.serviceAttachmentNatSubnets(region, config)
+	if err != nil {
+		return nil, err
+	}
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	expected := &compute.ServiceAttachment{
+		Name:                 loadBalancerName,
+		Description:          makeServiceDescription(nm.String()),
+		TargetService:        fwdRuleLink,
+		ConnectionPreference: config.ConnectionPreference,
+		NatSubnets:           natSubnets,
+		ConsumerRejectLists:  config.ConsumerRejectList,
+		EnableProxyProtocol:  config.EnableProxyProtocol,
+	}
+	for _, consumer := range config.ConsumerAcceptList {
+		expected.ConsumerAcceptLists = append(expected.ConsumerAcceptLists, &compute.ServiceAttachmentConsumerProjectLimit{
+			ProjectIdOrNum:  consumer.Project,
+			ConnectionLimit: consumer.ConnectionLimit,
+		})
+	}
+
+	existing, err := g.GetServiceAttachment(loadBalancerName, region)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if existing != nil && (getNameFromLink(existing.TargetService) != getNameFromLink(expected.TargetService) || existing.EnableProxyProtocol != expected.EnableProxyProtocol) {
+		// The target and the PROXY protocol of a service attachment cannot
+		// be patched.
+		klog.V(2).Infof("syncInternalServiceAttachment(%v): target or PROXY protocol changed, recreating the service attachment", loadBalancerName)
+		if err := ignoreNotFound(g.DeleteServiceAttachment(loadBalancerName, region)); err != nil {
+			return nil, err
+		}
+		existing = nil
+	}
+	if existing == nil {
+		klog.V(2).Infof("syncInternalServiceAttachment(%v): creating service attachment of forwarding rule %v", loadBalancerName, fwdRuleLink)
+		if err := g.CreateServiceAttachment(expected, region); err != nil {
+			return nil, err
+		}
+		return g.GetServiceAttachment(loadBalancerName, region)
+	}
+	if serviceAttachmentsEqual(existing, expected) {
+		return existing, nil
+	}
+	klog.V(2).Infof("syncInternalServiceAttachment(%v): updating service attachment", loadBalancerName)
+	expected.Fingerprint = existing.Fingerprint
+	expected.ForceSendFields = []string{"ConsumerAcceptLists", "ConsumerRejectLists"}
+	if err := g.PatchServiceAttachment(expected, region); err != nil {
+		return nil, err
+	}
+	return g.GetServiceAttachment(loadBalancerName, region)
+}
+
+// serviceAttachmentNatSubnets returns the URLs of the NAT subnetworks of the
+// service attachment of config.
+func (g *Cloud) serviceAttachmentNatSubnets(region string, config *PSCServiceAttachmentConfig) ([]string, error) {
+	var natSubnets []string
+	for _, name := range config.NatSubnets {
+		natSubnets = append(natSubnets, gceSubnetworkURL("", g.NetworkProjectID(), region, name))
+	}
+	if len(natSubnets) > 0 {
+		return natSubnets, nil
+	}
+
+	subnets, err := g.ListSubnetworksInProject(g.NetworkProjectID(), region)
+	if err != nil {
+		return nil, err
+	}
+	networkName := getNameFromLink(g.NetworkURL())
+	for _, subnet := range subnets {
+		if subnet.Purpose == pscSubnetworkPurpose && getNameFromLink(subnet.Network) == networkName {
+			natSubnets = append(natSubnets, subnet.SelfLink)
+		}
+	}
+	if len(natSubnets) == 0 {
+		return nil, fmt.Errorf("no %s subnetwork in network %q of region %s, create one or set natSubnets in annotation %q", pscSubnetworkPurpose, networkName, region, ServiceAnnotationPSCServiceAttachment)
+	}
+	sort.Strings(natSubnets)
+	return natSubnets, nil
+}
+
+// serviceAttachmentsEqual returns true if the patchable settings of the
+// service attachments are equal.
+func serviceAttachmentsEqual(existing, expected *compute.ServiceAttachment) bool {
+	return existing.Description == expected.Description &&
+		existing.ConnectionPreference == expected.ConnectionPreference &&
+		equalResourceNames(existing.NatSubnets, expected.NatSubnets) &&
+		equalStringSets(existing.ConsumerRejectLists, expected.ConsumerRejectLists) &&
+		reflect.DeepEqual(consumerLimits(existing.ConsumerAcceptLists), consumerLimits(expected.ConsumerAcceptLists))
+}
+
+// equalResourceNames returns true if the resource URLs a and b name the same
+// resources, regardless of the format of the URLs.
+func equalResourceNames(a, b []string) bool {
+	names := func(links []string) []string {
+		var names []string
+		for _, link := range links {
+			names = append(names, getNameFromLink(link))
+		}
+		return names
+	}
+	return equalStringSets(names(a), names(b))
+}
+
+func consumerLimits(consumers []*compute.ServiceAttachmentConsumerProjectLimit) map[string]int64 {
+	limits := map[string]int64{}
+	for _, c := range consumers {
+		limits[c.ProjectIdOrNum] = c.ConnectionLimit
+	}
+	return limits
+}
+
+// ensureInternalServiceAttachmentDeleted deletes the service attachment
+// loadBalancerName, which must be deleted before its forwarding rule.
+func (g *Cloud) ensureInternalServiceAttachmentDeleted(loadBalancerName, region string) error {
+	if err := g.DeleteServiceAttachment(loadBalancerName, region); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	klog.V(2).Infof("ensureInternalServiceAttachmentDeleted(%v): deleted service attachment", loadBalancerName)
+	return nil
+}
@@ -0,0 +1,205 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeGCECloudWithPSC returns a fake cloud whose network has a
+// PRIVATE_SERVICE_CONNECT subnetwork, psc-nat, and whose mock patches the
+// service attachments.
+func fakeGCECloudWithPSC(t *testing.T) (*Cloud, TestClusterValues) {
+	vals := DefaultTestClusterValues()
+	vals.NetworkURL = "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/test-network"
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.unsafeSubnetworkURL = "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/subnetworks/nodes"
+
+	for _, subnet := range []*compute.Subnetwork{
+		{Name: "nodes", Network: vals.NetworkURL, IpCidrRange: "10.0.0.0/24"},
+		{Name: "psc-nat", Network: vals.NetworkURL, IpCidrRange: "10.1.0.0/24", Purpose: pscSubnetworkPurpose},
+		{Name: "other-psc-nat", Network: "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/other-network", IpCidrRange: "10.2.0.0/24", Purpose: pscSubnetworkPurpose},
+	} {
+		require.NoError(t, gce.c.Subnetworks().Insert(context.TODO(), meta.RegionalKey(subnet.Name, vals.Region), subnet))
+	}
+
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockServiceAttachments.PatchHook = func(_ context.Context, key *meta.Key, sa *compute.ServiceAttachment, m *cloud.MockServiceAttachments, _ ...cloud.Option) error {
+		m.Lock.Lock()
+		defer m.Lock.Unlock()
+		existing, ok := m.Objects[*key]
+		if !ok {
+			return &googleapi.Error{Code: http.StatusNotFound}
+		}
+		patched := existing.ToGA()
+		patched.Description = sa.Description
+		patched.ConnectionPreference = sa.ConnectionPreference
+		patched.NatSubnets = sa.NatSubnets
+		patched.ConsumerAcceptLists = sa.ConsumerAcceptLists
+		patched.ConsumerRejectLists = sa.ConsumerRejectLists
+		m.Objects[*key] = &cloud.MockServiceAttachmentsObj{Obj: patched}
+		return nil
+	}
+	return gce, vals
+}
+
+func serviceAttachmentCondition(t *testing.T, gce *Cloud, svc *v1.Service) *metav1.Condition {
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionPSCServiceAttachmentReady)
+}
+
+func TestEnsureInternalLoadBalancerServiceAttachment(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithPSC(t)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationPSCServiceAttachment] = `{"connectionPreference":"ACCEPT_MANUAL","consumerAcceptList":[{"project":"consumer-1","connectionLimit":5}]}`
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The service attachment is created, with the PRIVATE_SERVICE_CONNECT
+	// subnetwork of the network.
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	sa, err := gce.GetServiceAttachment(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, fwdRule.SelfLink, sa.TargetService)
+	assert.Equal(t, PSCAcceptManual, sa.ConnectionPreference)
+	require.Len(t, sa.NatSubnets, 1)
+	assert.Equal(t, "psc-nat", getNameFromLink(sa.NatSubnets[0]))
+	assert.Equal(t, map[string]int64{"consumer-1": 5}, consumerLimits(sa.ConsumerAcceptLists))
+	condition := serviceAttachmentCondition(t, gce, svc)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Contains(t, condition.Message, sa.SelfLink)
+
+	// The accept list is patched.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationPSCServiceAttachment] = `{"natSubnets":["psc-nat"],"connectionPreference":"ACCEPT_MANUAL","consumerAcceptList":[{"project":"consumer-2"}]}`
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	sa, err = gce.GetServiceAttachment(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"consumer-2": 0}, consumerLimits(sa.ConsumerAcceptLists))
+
+	// An invalid configuration fails the sync and is reported.
+	svc.Annotations[ServiceAnnotationPSCServiceAttachment] = `{"connectionPreference":"ACCEPT_ALL"}`
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	assert.Error(t, err)
+	condition = serviceAttachmentCondition(t, gce, svc)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+
+	// The service attachment is deleted with the annotation.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationPSCServiceAttachment)
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetServiceAttachment(lbName, gce.region)
+	assert.True(t, isNotFound(err), "service attachment was not deleted: %v", err)
+	assert.Nil(t, serviceAttachmentCondition(t, gce, svc))
+}
+
+func TestEnsureInternalLoadBalancerWithoutServiceAttachment(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithPSC(t)
+	deletes := 0
+	gce.c.(*cloud.MockGCE).MockServiceAttachments.DeleteHook = func(_ context.Context, _ *meta.Key, _ *cloud.MockServiceAttachments, _ ...cloud.Option) (bool, error) {
+		deletes++
+		return false, nil
+	}
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The Services which never had a service attachment do not delete one.
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Zero(t, deletes)
+}
+
+func TestEnsureInternalLoadBalancerServiceAttachmentWithoutCondition(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithPSC(t)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationPSCServiceAttachment] = `{}`
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetServiceAttachment(lbName, gce.region)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+
+	// The service attachment is deleted with the annotation even if the
+	// condition of the Service was not recorded.
+	delete(svc.Annotations, ServiceAnnotationPSCServiceAttachment)
+	svc.Status.Conditions = nil
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, fwdRule, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetServiceAttachment(lbName, gce.region)
+	assert.True(t, isNotFound(err), "service attachment was not deleted: %v", err)
+}
+
+func TestEnsureInternalLoadBalancerDeletedServiceAttachment(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithPSC(t)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationPSCServiceAttachment] = `{}`
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.GetServiceAttachment(lbName, gce.region)
+	require.NoError(t, err)
+
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetServiceAttachment(lbName, gce.region)
+	assert.True(t, isNotFound(err), "service attachment was not deleted: %v", err)
+}
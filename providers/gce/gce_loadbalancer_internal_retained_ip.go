@@ -0,0 +1,187 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ILBRetainedIPsConfigMapName is the name of the ConfigMap, in
+	// UIDNamespace, remembering the IPs of the internal load balancers of the
+	// Services annotated with ServiceAnnotationILBRetainIP.
+	ILBRetainedIPsConfigMapName = "gce-ilb-retained-ips"
+)
+
+// retainedILBIP is the IP of the internal load balancer of a Service, reused
+// when the Service is recreated in the same subnetwork.
+type retainedILBIP struct {
+	IP         string `json:"ip"`
+	Subnetwork string `json:"subnetwork"`
+}
+
+// retainedILBIPKey returns the ConfigMap key of the Service. Namespaces and
+// Service names are DNS labels, which cannot contain dots.
+func retainedILBIPKey(nm types.NamespacedName) string {
+	return nm.Namespace + "." + nm.Name
+}
+
+// watchRetainedILBIPs starts the informer of the ConfigMap of the retained
+// IPs, so that the syncs of the internal load balancers get it from
+// retainedILBIPsLister instead of the API server.
+func (g *Cloud) watchRetainedILBIPs(stop <-chan struct{}) {
+	listerWatcher := cache.NewListWatchFromClient(g.client.CoreV1().RESTClient(), "configmaps", UIDNamespace, fields.Everything())
+	indexer, controller := cache.NewIndexerInformer(newSingleObjectListerWatcher(listerWatcher, ILBRetainedIPsConfigMapName), &v1.ConfigMap{}, updateFuncFrequency, cache.ResourceEventHandlerFuncs{}, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	g.retainedILBIPsLister = corelisters.NewConfigMapLister(indexer).ConfigMaps(UIDNamespace)
+	g.retainedILBIPsSynced = controller.HasSynced
+	go controller.Run(stop)
+}
+
+// getRetainedILBIPsConfigMap returns a copy of the ConfigMap of the retained
+// IPs from retainedILBIPsLister, or from the API server until it is synced.
+func (g *Cloud) getRetainedILBIPsConfigMap(ctx context.Context) (*v1.ConfigMap, error) {
+	if g.retainedILBIPsLister == nil || !g.retainedILBIPsSynced() {
+		return g.client.CoreV1().ConfigMaps(UIDNamespace).Get(ctx, ILBRetainedIPsConfigMapName, metav1.GetOptions{})
+	}
+	cm, err := g.retainedILBIPsLister.Get(ILBRetainedIPsConfigMapName)
+	if err != nil {
+		return nil, err
+	}
+	return cm.DeepCopy(), nil
+}
+
+// getRetainedILBIP returns the IP retained for the Service, or nil if none.
+func (g *Cloud) getRetainedILBIP(ctx context.Context, nm types.NamespacedName) (*retainedILBIP, error) {
+	cm, err := g.getRetainedILBIPsConfigMap(ctx)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[retainedILBIPKey(nm)]
+	if !ok {
+		return nil, nil
+	}
+	var retained retainedILBIP
+	if err := json.Unmarshal([]byte(data), &retained); err != nil {
+		klog.Warningf("Ignoring invalid retained IP %q of Service %s: %v", data, nm, err)
+		return nil, nil
+	}
+	return &retained, nil
+}
+
+// syncRetainedILBIP remembers the IP of the internal load balancer of the
+// Service while it is annotated with ServiceAnnotationILBRetainIP, and forgets
+// it once the annotation is removed. The IP is kept when the Service is
+// deleted, so that it is reused if the Service is recreated.
+func (g *Cloud) syncRetainedILBIP(ctx context.Context, svc *v1.Service, retained *retainedILBIP) error {
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	key := retainedILBIPKey(nm)
+	retain := GetLoadBalancerAnnotationRetainIP(svc)
+	var value string
+	if retain {
+		b, err := json.Marshal(retained)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+
+	// The ConfigMap is shared by all the Services, so the lister only tells
+	// whether it needs an update, which is done on the live object.
+	cm, err := g.getRetainedILBIPsConfigMap(ctx)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if !retainedILBIPNeedsUpdate(cm, key, value, retain) {
+		return nil
+	}
+
+	configMaps := g.client.CoreV1().ConfigMaps(UIDNamespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, ILBRetainedIPsConfigMapName, metav1.GetOptions{})
+		create := errors.IsNotFound(err)
+		if create {
+			cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ILBRetainedIPsConfigMapName, Namespace: UIDNamespace}}
+		} else if err != nil {
+			return err
+		}
+		if !retainedILBIPNeedsUpdate(cm, key, value, retain) {
+			return nil
+		}
+		if !retain {
+			delete(cm.Data, key)
+		} else {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[key] = value
+		}
+		if create {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// Retried as a conflict, with the ConfigMap created meanwhile.
+				return errors.NewConflict(v1.Resource("configmaps"), ILBRetainedIPsConfigMapName, err)
+			}
+			return err
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if !retain {
+		if err != nil {
+			return fmt.Errorf("failed to forget the retained IP of Service %s: %w", nm, err)
+		}
+		klog.V(2).Infof("Forgot the retained internal load balancer IP of Service %s", nm)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retain the IP %s of Service %s: %w", retained.IP, nm, err)
+	}
+	klog.V(2).Infof("Retained the internal load balancer IP %s of Service %s", retained.IP, nm)
+	return nil
+}
+
+// retainedILBIPNeedsUpdate returns true if the entry key of cm, which may be
+// nil, is not value, or is present while it should not be retained.
+func retainedILBIPNeedsUpdate(cm *v1.ConfigMap, key, value string, retain bool) bool {
+	var data map[string]string
+	if cm != nil {
+		data = cm.Data
+	}
+	current, ok := data[key]
+	if !retain {
+		return ok
+	}
+	return current != value
+}
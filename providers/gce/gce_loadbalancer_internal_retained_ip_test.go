@@ -0,0 +1,183 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// recreateRetainIPService deletes the internal load balancer and the Service,
+// and creates the Service again without its requested IP.
+func recreateRetainIPService(t *testing.T, gce *Cloud, vals TestClusterValues, svc *v1.Service) *v1.Service {
+	t.Helper()
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	require.NoError(t, gce.client.CoreV1().Services(svc.Namespace).Delete(context.TODO(), svc.Name, metav1.DeleteOptions{}))
+
+	svc = fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBRetainIP] = "true"
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return svc
+}
+
+func TestEnsureInternalLoadBalancerRetainIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodeNames := []string{"test-node-1"}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.LoadBalancerIP = "10.1.2.3"
+	svc.Annotations[ServiceAnnotationILBRetainIP] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.2.3", status.Ingress[0].IP)
+	retained, err := gce.getRetainedILBIP(context.TODO(), nm)
+	require.NoError(t, err)
+	assert.Equal(t, &retainedILBIP{IP: "10.1.2.3", Subnetwork: gce.SubnetworkURL()}, retained)
+
+	// The recreated Service gets the same IP.
+	svc = recreateRetainIPService(t, gce, vals, svc)
+	status, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, "10.1.2.3", status.Ingress[0].IP)
+
+	// Removing the annotation forgets the IP.
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationILBRetainIP)
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	retained, err = gce.getRetainedILBIP(context.TODO(), nm)
+	require.NoError(t, err)
+	assert.Nil(t, retained)
+}
+
+func TestEnsureInternalLoadBalancerRetainedIPUnavailable(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodeNames := []string{"test-node-1"}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.LoadBalancerIP = "10.1.2.3"
+	svc.Annotations[ServiceAnnotationILBRetainIP] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The IP is taken while the Service does not exist.
+	svc = recreateRetainIPService(t, gce, vals, svc)
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{Name: "other", Address: "10.1.2.3", AddressType: string(LBTypeInternal)}, gce.region))
+
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.NotEqual(t, "10.1.2.3", status.Ingress[0].IP)
+	checkEvent(t, recorder, "Warning RetainedIPUnavailable", true)
+	retained, err := gce.getRetainedILBIP(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace})
+	require.NoError(t, err)
+	assert.Equal(t, status.Ingress[0].IP, retained.IP)
+}
+
+func TestGetRetainedILBIPFromLister(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nm := types.NamespacedName{Name: "svc", Namespace: "ns"}
+
+	// The ConfigMap of the lister is used once it is synced, not the one of
+	// the API server.
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ILBRetainedIPsConfigMapName, Namespace: UIDNamespace},
+		Data:       map[string]string{retainedILBIPKey(nm): `{"ip":"10.1.2.3","subnetwork":"subnet"}`},
+	}))
+	gce.retainedILBIPsLister = corelisters.NewConfigMapLister(indexer).ConfigMaps(UIDNamespace)
+	synced := false
+	gce.retainedILBIPsSynced = func() bool { return synced }
+
+	retained, err := gce.getRetainedILBIP(context.TODO(), nm)
+	require.NoError(t, err)
+	assert.Nil(t, retained)
+
+	synced = true
+	retained, err = gce.getRetainedILBIP(context.TODO(), nm)
+	require.NoError(t, err)
+	assert.Equal(t, &retainedILBIP{IP: "10.1.2.3", Subnetwork: "subnet"}, retained)
+}
+
+func TestSyncRetainedILBIPStaleLister(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBRetainIP] = "true"
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	other := types.NamespacedName{Name: "other", Namespace: "ns"}
+	otherIP := `{"ip":"10.1.2.4","subnetwork":"subnet"}`
+
+	// The lister has not seen the IP retained for the other Service yet.
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ILBRetainedIPsConfigMapName, Namespace: UIDNamespace},
+	}))
+	gce.retainedILBIPsLister = corelisters.NewConfigMapLister(indexer).ConfigMaps(UIDNamespace)
+	gce.retainedILBIPsSynced = func() bool { return true }
+	_, err = gce.client.CoreV1().ConfigMaps(UIDNamespace).Create(context.TODO(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ILBRetainedIPsConfigMapName, Namespace: UIDNamespace},
+		Data:       map[string]string{retainedILBIPKey(other): otherIP},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, gce.syncRetainedILBIP(context.TODO(), svc, &retainedILBIP{IP: "10.1.2.3", Subnetwork: "subnet"}))
+	cm, err := gce.client.CoreV1().ConfigMaps(UIDNamespace).Get(context.TODO(), ILBRetainedIPsConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		retainedILBIPKey(other): otherIP,
+		retainedILBIPKey(nm):    `{"ip":"10.1.2.3","subnetwork":"subnet"}`,
+	}, cm.Data)
+}
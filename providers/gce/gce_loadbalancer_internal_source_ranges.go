@@ -0,0 +1,202 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// subnetRangesCacheTTL bounds how long the listed subnet ranges are reused, so
+// that the syncs of the internal load balancers do not list the subnets of
+// every region each time.
+const subnetRangesCacheTTL = 10 * time.Minute
+
+// subnetRangesCache holds the subnet ranges of the network, of the region of
+// the cluster and of all the regions. The zero value is ready to use.
+type subnetRangesCache struct {
+	lock    sync.Mutex
+	entries map[bool]subnetRangesCacheEntry
+}
+
+type subnetRangesCacheEntry struct {
+	ranges []string
+	expiry time.Time
+}
+
+// internalIPv4Ranges are the ranges the VPC networks, and the on-premises or
+// peered networks connected to them, use privately, see
+// https://cloud.google.com/vpc/docs/subnets#valid-ranges.
+var internalIPv4Ranges = []string{
+	// RFC 1918
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	// RFC 6598
+	"100.64.0.0/10",
+	// RFC 6890
+	"192.0.0.0/24",
+	// RFC 5737
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	// RFC 7526
+	"192.88.99.0/24",
+	// RFC 2544
+	"198.18.0.0/15",
+	// RFC 5735
+	"240.0.0.0/4",
+}
+
+// warnUnreachableILBSourceRanges raises an event for the source ranges of the
+// internal load balancer of the Service which cannot reach it. The validation
+// is advisory, the firewall is still created with the ranges of the Service.
+func (g *Cloud) warnUnreachableILBSourceRanges(svc *v1.Service, globalAccess bool) {
+	sourceRanges, err := ipv4SourceRanges(svc)
+	if err != nil || len(sourceRanges) == 0 {
+		return
+	}
+	unreachable, err := g.unreachableILBSourceRanges(sourceRanges, globalAccess)
+	if err != nil {
+		klog.Warningf("Failed to validate the load balancer source ranges of service %s/%s: %v", svc.Namespace, svc.Name, err)
+		return
+	}
+	if len(unreachable) == 0 {
+		return
+	}
+	g.eventRecorder.Eventf(svc, v1.EventTypeWarning, "SourceRangesUnreachable", "Load balancer source ranges %s cannot reach the internal load balancer, they are neither internal ranges nor subnet ranges of network %s", strings.Join(unreachable, ","), g.networkURL)
+}
+
+// unreachableILBSourceRanges returns the source ranges which overlap neither
+// the internal ranges nor the subnet ranges of the network. The internal load
+// balancers are only reachable from the network and the networks connected to
+// it, where the public ranges are only routable as privately used public
+// ranges of the subnets. The subnets of the other regions are only considered
+// with global access.
+func (g *Cloud) unreachableILBSourceRanges(sourceRanges []string, globalAccess bool) ([]string, error) {
+	var candidates []string
+	for _, r := range sourceRanges {
+		if !cidrOverlapsAny(r, internalIPv4Ranges) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	subnetRanges, err := g.networkSubnetRangesCached(globalAccess)
+	if err != nil {
+		return nil, err
+	}
+	var unreachable []string
+	for _, r := range candidates {
+		if !cidrOverlapsAny(r, subnetRanges) {
+			unreachable = append(unreachable, r)
+		}
+	}
+	return unreachable, nil
+}
+
+// networkSubnetRangesCached returns the subnet ranges of the network, listing
+// them at most once per subnetRangesCacheTTL.
+func (g *Cloud) networkSubnetRangesCached(allRegions bool) ([]string, error) {
+	g.subnetRangesCache.lock.Lock()
+	defer g.subnetRangesCache.lock.Unlock()
+
+	if entry, ok := g.subnetRangesCache.entries[allRegions]; ok && time.Now().Before(entry.expiry) {
+		return entry.ranges, nil
+	}
+	ranges, err := g.networkSubnetRanges(allRegions)
+	if err != nil {
+		return nil, err
+	}
+	if g.subnetRangesCache.entries == nil {
+		g.subnetRangesCache.entries = map[bool]subnetRangesCacheEntry{}
+	}
+	g.subnetRangesCache.entries[allRegions] = subnetRangesCacheEntry{ranges: ranges, expiry: time.Now().Add(subnetRangesCacheTTL)}
+	return ranges, nil
+}
+
+// networkSubnetRanges returns the primary and secondary ranges of the subnets
+// of the network in the region of the cluster, or in all the regions.
+func (g *Cloud) networkSubnetRanges(allRegions bool) ([]string, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	regions := []string{g.region}
+	if allRegions {
+		mc := newGenericMetricContext("regions", "list", unusedMetricLabel, unusedMetricLabel, computeV1Version)
+		list, err := g.c.Regions().List(ctx, filter.None)
+		if err = mc.Observe(err); err != nil {
+			return nil, fmt.Errorf("listing regions: %w", err)
+		}
+		regions = nil
+		for _, r := range list {
+			regions = append(regions, r.Name)
+		}
+	}
+
+	var ranges []string
+	for _, region := range regions {
+		mc := newSubnetworkMetricContext("list", region)
+		subnets, err := g.c.Subnetworks().List(ctx, region, filter.None, cloud.ForceProjectID(g.NetworkProjectID()))
+		if err = mc.Observe(err); err != nil {
+			return nil, fmt.Errorf("listing subnetworks of region %s: %w", region, err)
+		}
+		for _, subnet := range subnets {
+			if !resourceURLsEqual(subnet.Network, g.networkURL) {
+				continue
+			}
+			ranges = append(ranges, subnet.IpCidrRange)
+			for _, secondary := range subnet.SecondaryIpRanges {
+				ranges = append(ranges, secondary.IpCidrRange)
+			}
+		}
+	}
+	return ranges, nil
+}
+
+// cidrOverlapsAny returns true if the CIDR overlaps any of the ranges.
+// Unparsable CIDRs are considered overlapping, so that they are not reported.
+func cidrOverlapsAny(cidr string, ranges []string) bool {
+	_, a, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return true
+	}
+	for _, r := range ranges {
+		_, b, err := net.ParseCIDR(r)
+		if err != nil {
+			continue
+		}
+		if a.Contains(b.IP) || b.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func fakeGCECloudWithSubnets(t *testing.T) (*Cloud, TestClusterValues) {
+	vals := DefaultTestClusterValues()
+	vals.NetworkURL = "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/test-network"
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	otherNetwork := "https://www.googleapis.com/compute/v1/projects/test-project/global/networks/other-network"
+	subnets := []struct {
+		region string
+		subnet *compute.Subnetwork
+	}{
+		{vals.Region, &compute.Subnetwork{
+			Name:              "nodes",
+			Network:           vals.NetworkURL,
+			IpCidrRange:       "8.8.0.0/24",
+			SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{{RangeName: "pods", IpCidrRange: "9.9.0.0/24"}},
+		}},
+		{vals.Region, &compute.Subnetwork{Name: "other", Network: otherNetwork, IpCidrRange: "6.6.0.0/24"}},
+		{"europe-west1", &compute.Subnetwork{Name: "remote", Network: vals.NetworkURL, IpCidrRange: "7.7.0.0/24"}},
+	}
+	for _, s := range subnets {
+		require.NoError(t, gce.c.Subnetworks().Insert(context.TODO(), meta.RegionalKey(s.subnet.Name, s.region), s.subnet))
+	}
+	mockGCE := gce.c.(*cloud.MockGCE)
+	for _, region := range []string{vals.Region, "europe-west1"} {
+		mockGCE.MockRegions.Objects[*meta.GlobalKey(region)] = &cloud.MockRegionsObj{Obj: &compute.Region{Name: region}}
+	}
+	return gce, vals
+}
+
+func TestUnreachableILBSourceRanges(t *testing.T) {
+	t.Parallel()
+
+	gce, _ := fakeGCECloudWithSubnets(t)
+	for _, tc := range []struct {
+		desc         string
+		sourceRanges []string
+		globalAccess bool
+		want         []string
+	}{
+		{
+			desc:         "internal ranges",
+			sourceRanges: []string{"10.0.0.0/8", "172.16.1.0/24", "192.168.0.1/32", "100.64.0.0/16", "0.0.0.0/0"},
+		},
+		{
+			desc:         "subnet ranges",
+			sourceRanges: []string{"8.8.0.128/25", "9.9.0.0/16"},
+		},
+		{
+			desc:         "public ranges",
+			sourceRanges: []string{"10.0.0.0/8", "1.1.1.1/32", "8.8.0.0/24"},
+			want:         []string{"1.1.1.1/32"},
+		},
+		{
+			desc:         "subnets of the other networks",
+			sourceRanges: []string{"6.6.0.0/24"},
+			want:         []string{"6.6.0.0/24"},
+		},
+		{
+			desc:         "subnets of the other regions",
+			sourceRanges: []string{"7.7.0.0/24"},
+			want:         []string{"7.7.0.0/24"},
+		},
+		{
+			desc:         "subnets of the other regions with global access",
+			sourceRanges: []string{"7.7.0.0/24"},
+			globalAccess: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := gce.unreachableILBSourceRanges(tc.sourceRanges, tc.globalAccess)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestUnreachableILBSourceRangesCached(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithSubnets(t)
+	lists := 0
+	gce.c.(*cloud.MockGCE).MockSubnetworks.ListHook = func(ctx context.Context, region string, fl *filter.F, m *cloud.MockSubnetworks, options ...cloud.Option) (bool, []*compute.Subnetwork, error) {
+		lists++
+		return false, nil, nil
+	}
+	for i := 0; i < 2; i++ {
+		got, err := gce.unreachableILBSourceRanges([]string{"7.7.0.0/24"}, true)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	}
+	// The subnets of both regions are listed once.
+	assert.Equal(t, 2, lists)
+
+	// The subnets added since are only seen once the cache expires.
+	remote := &compute.Subnetwork{Name: "remote-2", Network: vals.NetworkURL, IpCidrRange: "5.5.0.0/24"}
+	require.NoError(t, gce.c.Subnetworks().Insert(context.TODO(), meta.RegionalKey(remote.Name, "europe-west1"), remote))
+	got, err := gce.unreachableILBSourceRanges([]string{"5.5.0.0/24"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5.5.0.0/24"}, got)
+	gce.subnetRangesCache.entries = nil
+	got, err = gce.unreachableILBSourceRanges([]string{"5.5.0.0/24"}, true)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestEnsureInternalLoadBalancerUnreachableSourceRanges(t *testing.T) {
+	t.Parallel()
+
+	gce, vals := fakeGCECloudWithSubnets(t)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodeNames := []string{"test-node-1"}
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8", "1.1.1.0/24"}
+	svc, err := gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The firewall is still created with all the source ranges.
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fw, err := gce.GetFirewall(MakeFirewallName(gce.GetLoadBalancerName(context.TODO(), "", svc)))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.0.0.0/8", "1.1.1.0/24"}, fw.SourceRanges)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "SourceRangesUnreachable") {
+			events = append(events, e)
+		}
+	}
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0], "1.1.1.0/24")
+	assert.NotContains(t, events[0], "10.0.0.0/8")
+}
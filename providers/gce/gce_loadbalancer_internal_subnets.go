@@ -0,0 +1,243 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// subnetInstanceGroupHashLength is the length of the hash of the
+	// subnetwork URL suffixing the names of the subnetwork instance groups.
+	subnetInstanceGroupHashLength = 8
+	// maxInstanceGroupNameLength is the maximum length of the names of the
+	// GCE resources.
+	maxInstanceGroupNameLength = 63
+)
+
+// makeSubnetInstanceGroupName returns the name of the instance group of the
+// nodes of the subnetwork subnetURL in a zone where the nodes of the cluster
+// span several subnetworks, e.g. k8s-ig--{clusterid}-{subnet}-{hash}. The
+// nodes of the main subnetwork of the zone stay in the instance group igName.
+func makeSubnetInstanceGroupName(igName, subnetURL string) string {
+	hash := sha1.Sum([]byte(subnetURL))
+	suffix := hex.EncodeToString(hash[:])[:subnetInstanceGroupHashLength]
+	subnet := lastComponent(subnetURL)
+	if maxLen := maxInstanceGroupNameLength - len(igName) - len(suffix) - 2; len(subnet) > maxLen {
+		subnet = subnet[:maxLen]
+	}
+	subnet = strings.TrimRight(subnet, "-")
+	if subnet == "" {
+		return igName + "-" + suffix
+	}
+	return igName + "-" + subnet + "-" + suffix
+}
+
+// isSubnetInstanceGroupName returns true if name is the name of a subnetwork
+// instance group of the instance group igName, see makeSubnetInstanceGroupName.
+func isSubnetInstanceGroupName(igName, name string) bool {
+	// The names of the instance groups of the other clusters start with
+	// "k8s-ig--" when igName is the legacy "k8s-ig".
+	if !strings.HasPrefix(name, igName+"-") || strings.HasPrefix(name, igName+"--") {
+		return false
+	}
+	return subnetInstanceGroupSuffixRE.MatchString(name[len(igName):])
+}
+
+var subnetInstanceGroupSuffixRE = regexp.MustCompile(`^(-[a-z0-9-]*)?-[0-9a-f]{8}$`)
+
+// instanceGroupsBySubnetwork returns the names of the instances of hosts in a
+// zone by the name of their instance group. The instances of the main
+// subnetwork of the zone, and all the instances when they are in a single
+// subnetwork, are in the instance group name. The main subnetwork is the one
+// of the existing instance group name, or else the subnetwork of the cluster,
+// or else the subnetwork with the most instances.
+func (g *Cloud) instanceGroupsBySubnetwork(name, zone string, hosts []*gceInstance) (map[string][]string, error) {
+	bySubnet := map[string][]string{}
+	for _, h := range hosts {
+		bySubnet[h.Subnetwork] = append(bySubnet[h.Subnetwork], h.Name)
+	}
+	if len(bySubnet) <= 1 {
+		return map[string][]string{name: instanceNames(hosts)}, nil
+	}
+
+	mainSubnet, err := g.mainInstanceGroupSubnetwork(name, zone, bySubnet)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("instanceGroupsBySubnetwork(%v, %v): nodes span %d subnetworks, main subnetwork %q", name, zone, len(bySubnet), mainSubnet)
+	groups := map[string][]string{}
+	for subnet, names := range bySubnet {
+		if subnet == mainSubnet {
+			groups[name] = names
+			continue
+		}
+		groups[makeSubnetInstanceGroupName(name, subnet)] = names
+	}
+	return groups, nil
+}
+
+// mainInstanceGroupSubnetwork returns the subnetwork of bySubnet whose
+// instances are in the instance group name.
+func (g *Cloud) mainInstanceGroupSubnetwork(name, zone string, bySubnet map[string][]string) (string, error) {
+	ig, err := g.GetInstanceGroup(name, zone)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	if ig != nil && ig.Subnetwork != "" {
+		if subnet, ok := matchSubnetwork(ig.Subnetwork, bySubnet); ok {
+			return subnet, nil
+		}
+	}
+	if subnetURL := g.SubnetworkURL(); subnetURL != "" {
+		if subnet, ok := matchSubnetwork(subnetURL, bySubnet); ok {
+			return subnet, nil
+		}
+	}
+
+	subnets := make([]string, 0, len(bySubnet))
+	for subnet := range bySubnet {
+		subnets = append(subnets, subnet)
+	}
+	sort.Slice(subnets, func(i, j int) bool {
+		if len(bySubnet[subnets[i]]) != len(bySubnet[subnets[j]]) {
+			return len(bySubnet[subnets[i]]) > len(bySubnet[subnets[j]])
+		}
+		return subnets[i] < subnets[j]
+	})
+	return subnets[0], nil
+}
+
+// matchSubnetwork returns the subnetwork of bySubnet with the name of the
+// subnetwork URL subnetURL. The URLs of the subnetworks of the instances and
+// of the configuration may differ by their API version.
+func matchSubnetwork(subnetURL string, bySubnet map[string][]string) (string, bool) {
+	if _, ok := bySubnet[subnetURL]; ok {
+		return subnetURL, true
+	}
+	for subnet := range bySubnet {
+		if subnet != "" && lastComponent(subnet) == lastComponent(subnetURL) {
+			return subnet, true
+		}
+	}
+	return "", false
+}
+
+// emptyStaleSubnetInstanceGroups removes the instances of the subnetwork
+// instance groups of the instance group name in zone which are not in groups,
+// e.g. once the nodes of their subnetwork are gone. They are emptied before
+// the instances are added to their new group, as an instance can only be in
+// one load-balanced instance group, and deleted by
+// deleteStaleSubnetInstanceGroups once the backend services no longer use
+// them.
+func (g *Cloud) emptyStaleSubnetInstanceGroups(name, zone string, groups map[string][]string) error {
+	igs, err := g.ListInstanceGroupsWithPrefix(zone, name+"-")
+	if err != nil {
+		return err
+	}
+	for _, ig := range igs {
+		if _, ok := groups[ig.Name]; ok || !isSubnetInstanceGroupName(name, ig.Name) {
+			continue
+		}
+		klog.V(2).Infof("emptyStaleSubnetInstanceGroups(%v, %v): instance group %s has no subnetwork nodes left, emptying it", name, zone, ig.Name)
+		if _, err := g.syncInternalInstanceGroup(ig.Name, zone, nil); err != nil && !isNotFound(err) {
+			return err
+		}
+		g.staleSubnetInstanceGroups.add(zone, ig.Name)
+	}
+	return nil
+}
+
+// deleteStaleSubnetInstanceGroups deletes the subnetwork instance groups
+// emptied by emptyStaleSubnetInstanceGroups. The groups still used by the
+// backend services of other load balancers are deleted once the last of them
+// is updated.
+func (g *Cloud) deleteStaleSubnetInstanceGroups() error {
+	for zone, names := range g.staleSubnetInstanceGroups.list() {
+		for _, name := range names {
+			err := g.DeleteInstanceGroup(name, zone)
+			if isInUsedByError(err) {
+				klog.V(2).Infof("deleteStaleSubnetInstanceGroups(): instance group %s in zone %s is still used, deleting it later", name, zone)
+				continue
+			}
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			klog.V(2).Infof("deleteStaleSubnetInstanceGroups(): deleted instance group %s in zone %s", name, zone)
+			g.staleSubnetInstanceGroups.remove(zone, name)
+		}
+	}
+	return nil
+}
+
+// staleZonalResources records the names of the zonal resources to delete,
+// e.g. the emptied instance groups, until they are deleted.
+type staleZonalResources struct {
+	lock sync.Mutex
+	// zones are the names of the resources by zone.
+	zones map[string]sets.String
+}
+
+func (s *staleZonalResources) add(zone, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.zones == nil {
+		s.zones = map[string]sets.String{}
+	}
+	if s.zones[zone] == nil {
+		s.zones[zone] = sets.NewString()
+	}
+	s.zones[zone].Insert(name)
+}
+
+func (s *staleZonalResources) remove(zone, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.zones[zone].Delete(name)
+	if s.zones[zone].Len() == 0 {
+		delete(s.zones, zone)
+	}
+}
+
+func (s *staleZonalResources) list() map[string][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	zones := make(map[string][]string, len(s.zones))
+	for zone, names := range s.zones {
+		zones[zone] = names.List()
+	}
+	return zones
+}
+
+func instanceNames(hosts []*gceInstance) []string {
+	names := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		names = append(names, h.Name)
+	}
+	return names
+}
@@ -0,0 +1,197 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const testSubnetworkPrefix = "https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/subnetworks/"
+
+func TestMakeSubnetInstanceGroupName(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		igName string
+		subnet string
+	}{
+		{igName: "k8s-ig--1234567890abcdef", subnet: "nodes-b"},
+		{igName: "k8s-ig", subnet: "nodes-b"},
+		{igName: "k8s-ig--1234567890abcdef", subnet: strings.Repeat("long-subnetwork-name", 3)},
+	} {
+		name := makeSubnetInstanceGroupName(tc.igName, testSubnetworkPrefix+tc.subnet)
+		assert.LessOrEqual(t, len(name), maxInstanceGroupNameLength, name)
+		assert.True(t, isSubnetInstanceGroupName(tc.igName, name), name)
+		assert.NotEqual(t, name, makeSubnetInstanceGroupName(tc.igName, testSubnetworkPrefix+"other"))
+	}
+	assert.False(t, isSubnetInstanceGroupName("k8s-ig", "k8s-ig--1234567890abcdef"))
+	assert.False(t, isSubnetInstanceGroupName("k8s-ig", "k8s-ig--1234567890abcdef-nodes-b-0123abcd"))
+	assert.False(t, isSubnetInstanceGroupName("k8s-ig--1234567890abcdef", "k8s-ig--1234567890abcdef"))
+}
+
+func TestEnsureInternalLoadBalancerMultipleSubnetworks(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.unsafeSubnetworkURL = testSubnetworkPrefix + "nodes-a"
+
+	nodeSubnets := map[string]string{
+		"test-node-1": "nodes-a",
+		"test-node-2": "nodes-a",
+		"test-node-3": "nodes-b",
+		"test-node-4": "nodes-c",
+	}
+	var nodes []*v1.Node
+	for name, subnet := range nodeSubnets {
+		require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{
+			Name:              name,
+			Zone:              vals.ZoneName,
+			Tags:              &compute.Tags{Items: []string{name}},
+			NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetworkPrefix + subnet}},
+		}))
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					v1.LabelHostname:     name,
+					v1.LabelTopologyZone: vals.ZoneName,
+				},
+			},
+		})
+	}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+
+	igName := makeInstanceGroupName(vals.ClusterID)
+	wantGroups := map[string][]string{
+		igName: {"test-node-1", "test-node-2"},
+		makeSubnetInstanceGroupName(igName, testSubnetworkPrefix+"nodes-b"): {"test-node-3"},
+		makeSubnetInstanceGroupName(igName, testSubnetworkPrefix+"nodes-c"): {"test-node-4"},
+	}
+	wantLinks := sets.NewString()
+	for name, wantNodes := range wantGroups {
+		ig, err := gce.GetInstanceGroup(name, vals.ZoneName)
+		require.NoError(t, err)
+		wantLinks.Insert(ig.SelfLink)
+		instances, err := gce.ListInstancesInInstanceGroup(name, vals.ZoneName, allInstances)
+		require.NoError(t, err)
+		gotNodes := sets.NewString()
+		for _, ins := range instances {
+			gotNodes.Insert(lastComponent(ins.Instance))
+		}
+		assert.Equal(t, sets.NewString(wantNodes...), gotNodes, name)
+	}
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	bs, err := gce.GetRegionBackendService(makeBackendServiceName(lbName, vals.ClusterID, shareBackendService(svc), cloud.SchemeInternal, v1.ProtocolTCP, backendServiceAffinity(svc)), gce.region)
+	require.NoError(t, err)
+	gotLinks := sets.NewString()
+	for _, b := range bs.Backends {
+		gotLinks.Insert(b.Group)
+	}
+	assert.Equal(t, wantLinks, gotLinks)
+
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	for name := range wantGroups {
+		_, err := gce.GetInstanceGroup(name, vals.ZoneName)
+		assert.True(t, isNotFound(err), "instance group %s was not deleted: %v", name, err)
+	}
+}
+
+func TestUpdateInternalLoadBalancerDeletesStaleSubnetInstanceGroups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.unsafeSubnetworkURL = testSubnetworkPrefix + "nodes-a"
+
+	var nodes []*v1.Node
+	for name, subnet := range map[string]string{"test-node-1": "nodes-a", "test-node-2": "nodes-b"} {
+		require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{
+			Name:              name,
+			Zone:              vals.ZoneName,
+			Tags:              &compute.Tags{Items: []string{name}},
+			NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetworkPrefix + subnet}},
+		}))
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					v1.LabelHostname:     name,
+					v1.LabelTopologyZone: vals.ZoneName,
+				},
+			},
+		})
+	}
+	var mainNodes []*v1.Node
+	for _, node := range nodes {
+		if node.Name == "test-node-1" {
+			mainNodes = append(mainNodes, node)
+		}
+	}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.ensureInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, nil, nodes)
+	require.NoError(t, err)
+	igName := makeInstanceGroupName(vals.ClusterID)
+	subnetIGName := makeSubnetInstanceGroupName(igName, testSubnetworkPrefix+"nodes-b")
+	_, err = gce.GetInstanceGroup(subnetIGName, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The instance group is still used by the backend service when it is
+	// emptied.
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockInstanceGroups.DeleteHook = func(ctx context.Context, key *meta.Key, m *cloud.MockInstanceGroups, options ...cloud.Option) (bool, error) {
+		bs, err := gce.GetRegionBackendService(makeBackendServiceName(gce.GetLoadBalancerName(context.TODO(), "", svc), vals.ClusterID, shareBackendService(svc), cloud.SchemeInternal, v1.ProtocolTCP, backendServiceAffinity(svc)), gce.region)
+		require.NoError(t, err)
+		for _, b := range bs.Backends {
+			if lastComponent(b.Group) == key.Name {
+				return true, &googleapi.Error{Code: http.StatusBadRequest, Message: "The instance_group resource is already being used by backend service"}
+			}
+		}
+		return false, nil
+	}
+	require.NoError(t, gce.updateInternalLoadBalancer(vals.ClusterName, vals.ClusterID, svc, mainNodes))
+	_, err = gce.GetInstanceGroup(subnetIGName, vals.ZoneName)
+	assert.True(t, isNotFound(err), "the instance group without nodes is deleted: %v", err)
+	assert.Empty(t, gce.staleSubnetInstanceGroups.list())
+}
@@ -21,7 +21,11 @@ package gce
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"path"
 	"reflect"
 	"sort"
 	"strings"
@@ -31,9 +35,12 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/mock"
+	computebeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -75,12 +82,12 @@ func TestEnsureInternalBackendServiceUpdates(t *testing.T) {
 	require.NoError(t, err)
 
 	sharedBackend := shareBackendService(svc)
-	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
-	err = gce.ensureInternalBackendService(bsName, "description", svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, "")
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
+	err = gce.ensureInternalBackendService(svc, bsName, "description", backendServiceAffinity(svc), cloud.SchemeInternal, "TCP", igLinks, "", nil)
 	require.NoError(t, err)
 
 	// Update the Internal Backend Service with a new ServiceAffinity
-	err = gce.ensureInternalBackendService(bsName, "description", v1.ServiceAffinityNone, cloud.SchemeInternal, "TCP", igLinks, "")
+	err = gce.ensureInternalBackendService(svc, bsName, "description", gceAffinityTypeNone, cloud.SchemeInternal, "TCP", igLinks, "", nil)
 	require.NoError(t, err)
 
 	bs, err := gce.GetRegionBackendService(bsName, gce.region)
@@ -88,6 +95,119 @@ func TestEnsureInternalBackendServiceUpdates(t *testing.T) {
 	assert.Equal(t, bs.SessionAffinity, strings.ToUpper(string(v1.ServiceAffinityNone)))
 }
 
+func TestEnsureInternalLoadBalancerSessionAffinity(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBBackendShare] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	for _, tc := range []struct {
+		spec       v1.ServiceAffinity
+		annotation string
+		want       string
+	}{
+		{spec: v1.ServiceAffinityNone, want: gceAffinityTypeNone},
+		{spec: v1.ServiceAffinityNone, annotation: "CLIENT_IP_PORT_PROTO", want: gceAffinityTypeClientIPPortProto},
+		{spec: v1.ServiceAffinityClientIP, annotation: "CLIENT_IP_PROTO", want: gceAffinityTypeClientIPProto},
+		{spec: v1.ServiceAffinityClientIP, want: gceAffinityTypeClientIP},
+	} {
+		svc.Spec.SessionAffinity = tc.spec
+		delete(svc.Annotations, ServiceAnnotationLoadBalancerSessionAffinity)
+		if tc.annotation != "" {
+			svc.Annotations[ServiceAnnotationLoadBalancerSessionAffinity] = tc.annotation
+		}
+		_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+		require.NoError(t, err)
+		fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+		require.NoError(t, err)
+		bs, err := gce.GetRegionBackendService(getNameFromLink(fwdRule.BackendService), gce.region)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, bs.SessionAffinity, "affinity %q, annotation %q", tc.spec, tc.annotation)
+		// The shared backend service of the previous affinity is deleted.
+		bss, err := gce.c.RegionBackendServices().List(context.TODO(), gce.region, filter.None)
+		require.NoError(t, err)
+		assert.Len(t, bss, 1)
+	}
+
+	svc.Annotations[ServiceAnnotationLoadBalancerSessionAffinity] = "GENERATED_COOKIE"
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.Error(t, err)
+}
+
+func TestMakeBackendServiceNameAffinity(t *testing.T) {
+	// The shared backend services of the affinities of the Services keep
+	// the names they had when named after the Kubernetes affinities.
+	for affinity, name := range map[string]string{
+		gceAffinityTypeNone:              "k8s-cluster-internal-tcp-nmv1-" + sha1Prefix(string(v1.ServiceAffinityNone)),
+		gceAffinityTypeClientIP:          "k8s-cluster-internal-tcp-nmv1-" + sha1Prefix(string(v1.ServiceAffinityClientIP)),
+		gceAffinityTypeClientIPPortProto: "k8s-cluster-internal-tcp-nmv1-" + sha1Prefix(gceAffinityTypeClientIPPortProto),
+	} {
+		assert.Equal(t, name, makeBackendServiceName("lb", "cluster", true, cloud.SchemeInternal, v1.ProtocolTCP, affinity), "affinity %q", affinity)
+	}
+	assert.Equal(t, "lb", makeBackendServiceName("lb", "cluster", false, cloud.SchemeInternal, v1.ProtocolTCP, gceAffinityTypeClientIPProto))
+}
+
+func sha1Prefix(value string) string {
+	hash := sha1.Sum([]byte(value))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+func TestEnsureInternalBackendServiceGuardedFields(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBPreservedBackendServiceFields] = "cdn"
+	preserved, err := GetServiceAnnotationILBPreservedBackendServiceFields(svc)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	igLinks, err := gce.ensureInternalInstanceGroups(makeInstanceGroupName(vals.ClusterID), nodes)
+	require.NoError(t, err)
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, shareBackendService(svc), cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
+	err = gce.ensureInternalBackendService(svc, bsName, "description", backendServiceAffinity(svc), cloud.SchemeInternal, "TCP", igLinks, "", preserved)
+	require.NoError(t, err)
+
+	// Enable IAP and CDN outside of Kubernetes.
+	bs, err := gce.GetRegionBackendService(bsName, gce.region)
+	require.NoError(t, err)
+	bs.Iap = &compute.BackendServiceIAP{Enabled: true}
+	bs.EnableCDN = true
+	require.NoError(t, gce.UpdateRegionBackendService(bs, gce.region))
+
+	// IAP is reverted with an event, the allowlisted CDN is kept.
+	err = gce.ensureInternalBackendService(svc, bsName, "description", backendServiceAffinity(svc), cloud.SchemeInternal, "TCP", igLinks, "", preserved)
+	require.NoError(t, err)
+	bs, err = gce.GetRegionBackendService(bsName, gce.region)
+	require.NoError(t, err)
+	assert.Nil(t, bs.Iap)
+	assert.True(t, bs.EnableCDN)
+	checkEvent(t, recorder, "Warning BackendServiceSettingsReverted Reverted settings iap of backend service "+bsName, true)
+
+	// Nothing is left to revert, and CDN survives an update.
+	err = gce.ensureInternalBackendService(svc, bsName, "description", gceAffinityTypeNone, cloud.SchemeInternal, "TCP", igLinks, "", preserved)
+	require.NoError(t, err)
+	bs, err = gce.GetRegionBackendService(bsName, gce.region)
+	require.NoError(t, err)
+	assert.True(t, bs.EnableCDN)
+	assert.Equal(t, strings.ToUpper(string(v1.ServiceAffinityNone)), bs.SessionAffinity)
+	checkEvent(t, recorder, "", false)
+}
+
 func TestEnsureInternalBackendServiceGroups(t *testing.T) {
 	t.Parallel()
 
@@ -122,9 +242,9 @@ func TestEnsureInternalBackendServiceGroups(t *testing.T) {
 			require.NoError(t, err)
 
 			sharedBackend := shareBackendService(svc)
-			bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
+			bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
 
-			err = gce.ensureInternalBackendService(bsName, "description", svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, "")
+			err = gce.ensureInternalBackendService(svc, bsName, "description", backendServiceAffinity(svc), cloud.SchemeInternal, "TCP", igLinks, "", nil)
 			require.NoError(t, err)
 
 			// Update the BackendService with new InstanceGroups
@@ -132,7 +252,7 @@ func TestEnsureInternalBackendServiceGroups(t *testing.T) {
 				tc.mockModifier(gce.c.(*cloud.MockGCE))
 			}
 			newIGLinks := []string{"new-test-ig-1", "new-test-ig-2"}
-			err = gce.ensureInternalBackendServiceGroups(bsName, newIGLinks)
+			_, err = gce.ensureInternalBackendServiceGroups(bsName, gce.region, newIGLinks)
 			if tc.mockModifier != nil {
 				assert.Error(t, err)
 				return
@@ -199,6 +319,39 @@ func TestEnsureMultipleInstanceGroups(t *testing.T) {
 	}
 }
 
+func TestEnsureInternalInstanceGroupRecreatedAfterOutOfBandDeletion(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1", "test-node-2"}
+	_, err = createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	igName := makeInstanceGroupName(vals.ClusterID)
+	_, err = gce.ensureInternalInstanceGroup(igName, vals.ZoneName, nodeNames[:1])
+	require.NoError(t, err)
+	staleIG, err := gce.GetInstanceGroup(igName, vals.ZoneName)
+	require.NoError(t, err)
+
+	// Delete the instance group right after the sync has fetched it.
+	c := gce.c.(*cloud.MockGCE)
+	require.NoError(t, gce.DeleteInstanceGroup(igName, vals.ZoneName))
+	gets := 0
+	c.MockInstanceGroups.GetHook = func(ctx context.Context, key *meta.Key, m *cloud.MockInstanceGroups, options ...cloud.Option) (bool, *compute.InstanceGroup, error) {
+		gets++
+		return gets == 1, staleIG, nil
+	}
+
+	link, err := gce.ensureInternalInstanceGroup(igName, vals.ZoneName, nodeNames)
+	require.NoError(t, err)
+	assert.Equal(t, staleIG.SelfLink, link)
+	instances, err := gce.ListInstancesInInstanceGroup(igName, vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	assert.Len(t, instances, len(nodeNames))
+}
+
 func TestEnsureInternalLoadBalancer(t *testing.T) {
 	t.Parallel()
 
@@ -216,6 +369,53 @@ func TestEnsureInternalLoadBalancer(t *testing.T) {
 	assertInternalLbResources(t, gce, svc, vals, nodeNames)
 }
 
+func TestEnsureInternalLoadBalancerUDPPreserveClientIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	nodeNames := []string{"test-node-1"}
+
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.Ports[0].Protocol = v1.ProtocolUDP
+	svc.Annotations[ServiceAnnotationILBPreserveClientIP] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The client IPs are not preserved with externalTrafficPolicy=Cluster.
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires externalTrafficPolicy Local")
+
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	svc.Spec.HealthCheckNodePort = 30000
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	require.NotNil(t, bs.ConnectionTrackingPolicy)
+	assert.Equal(t, "NEVER_PERSIST", bs.ConnectionTrackingPolicy.ConnectionPersistenceOnUnhealthyBackends)
+	checkEvent(t, recorder, "Normal ClientIPNotPreserved", false)
+
+	// The Services without the annotation are warned at creation.
+	svc2 := fakeLoadbalancerService(string(LBTypeInternal))
+	svc2.Name = "udp-cluster-policy"
+	svc2.UID = "udp-cluster-policy-uid"
+	svc2.Spec.Ports[0].Protocol = v1.ProtocolUDP
+	svc2, err = gce.client.CoreV1().Services(svc2.Namespace).Create(context.TODO(), svc2, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc2, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, "Normal ClientIPNotPreserved", true)
+	bs2, err := gce.GetRegionBackendService(gce.GetLoadBalancerName(context.TODO(), "", svc2), gce.region)
+	require.NoError(t, err)
+	assert.Nil(t, bs2.ConnectionTrackingPolicy)
+}
+
 func TestEnsureInternalLoadBalancerDeprecatedAnnotation(t *testing.T) {
 	t.Parallel()
 
@@ -292,8 +492,8 @@ func TestEnsureInternalLoadBalancerWithExistingResources(t *testing.T) {
 
 	sharedBackend := shareBackendService(svc)
 	bsDescription := makeBackendServiceDescription(nm, sharedBackend)
-	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
-	err = gce.ensureInternalBackendService(bsName, bsDescription, svc.Spec.SessionAffinity, cloud.SchemeInternal, "TCP", igLinks, existingHC.SelfLink)
+	bsName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
+	err = gce.ensureInternalBackendService(svc, bsName, bsDescription, backendServiceAffinity(svc), cloud.SchemeInternal, "TCP", igLinks, existingHC.SelfLink, nil)
 	require.NoError(t, err)
 
 	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
@@ -347,7 +547,7 @@ func TestEnsureInternalLoadBalancerClearPreviousResources(t *testing.T) {
 
 	// Create a backend Service that's missing Description and Backends
 	sharedBackend := shareBackendService(svc)
-	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
 	existingBS := &compute.BackendService{
 		Name:                lbName,
 		Protocol:            "TCP",
@@ -430,7 +630,7 @@ func TestUpdateInternalLoadBalancerBackendServices(t *testing.T) {
 	// BackendService
 	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
 	sharedBackend := shareBackendService(svc)
-	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
 	existingBS := &compute.BackendService{
 		Name:                backendServiceName,
 		Protocol:            "TCP",
@@ -496,7 +696,7 @@ func TestUpdateInternalLoadBalancerNodes(t *testing.T) {
 
 	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
 	sharedBackend := shareBackendService(svc)
-	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(lbName, vals.ClusterID, sharedBackend, cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
 	bs, err := gce.GetRegionBackendService(backendServiceName, gce.region)
 	require.NoError(t, err)
 	assert.Equal(t, 2, len(bs.Backends), "Want two backends referencing two instances groups")
@@ -622,6 +822,132 @@ func TestEnsureInternalLoadBalancerWithSpecialHealthCheck(t *testing.T) {
 	assert.Equal(t, int64(healthCheckNodePort), hc.HttpHealthCheck.Port)
 }
 
+func TestEnsureInternalLoadBalancerHealthCheckSettings(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckInterval] = "4"
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckTimeout] = "2"
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckUnhealthyThreshold] = "2"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nm := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+
+	// The existing health check of the Service keeps none of its larger
+	// values overridden by the Service.
+	existingHC := newInternalLBHealthCheck(lbName, nm, false, GetNodesHealthCheckPath(), GetNodesHealthCheckPort())
+	existingHC.CheckIntervalSec = 30
+	existingHC.UnhealthyThreshold = 5
+	existingHC.HealthyThreshold = 4
+	require.NoError(t, gce.CreateHealthCheck(existingHC))
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(lbName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), hc.CheckIntervalSec)
+	assert.Equal(t, int64(2), hc.TimeoutSec)
+	assert.Equal(t, int64(4), hc.HealthyThreshold, "the larger value not overridden by the Service is kept")
+	assert.Equal(t, int64(2), hc.UnhealthyThreshold)
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	assert.True(t, isNotFound(err), "nodes health check created: %v", err)
+}
+
+func TestEnsureInternalLoadBalancerHealthCheckSettingsTimeout(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	// The timeout exceeds the default check interval.
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheckTimeout] = "10"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, "greater than its check interval")
+}
+
+func TestEnsureInternalLoadBalancerUserHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	nodeNames := []string{"test-node-1"}
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	userHC := &compute.HealthCheck{
+		Name:           "user-hc",
+		Type:           "TCP",
+		TcpHealthCheck: &compute.TCPHealthCheck{Port: 8080},
+	}
+	require.NoError(t, gce.CreateHealthCheck(userHC))
+	userHC, err = gce.GetHealthCheck(userHC.Name)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.HealthCheckNodePort = 10101
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The health check of the provider is replaced by the user health check,
+	// whose port is allowed by the health check firewall.
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheck] = userHC.Name
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	bs, err := gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{userHC.SelfLink}, bs.HealthChecks)
+	_, err = gce.GetHealthCheck(lbName)
+	assert.True(t, isNotFound(err), "health check of the provider not deleted: %v", err)
+	fw, err := gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8080"}, fw.Allowed[0].Ports)
+
+	// The user health check is kept once the Service no longer references it,
+	// and once its load balancer is deleted.
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerHealthCheck)
+	_, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	bs, err = gce.GetRegionBackendService(lbName, gce.region)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(lbName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hc.SelfLink}, bs.HealthChecks)
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheck] = userHC.Name
+	_, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	hc, err = gce.GetHealthCheck(userHC.Name)
+	require.NoError(t, err)
+	assert.Equal(t, userHC, hc)
+}
+
+func TestEnsureInternalLoadBalancerUserHealthCheckNotFound(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerHealthCheck] = "user-hc"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationLoadBalancerHealthCheck)
+	_, err = gce.GetHealthCheck("user-hc")
+	assert.True(t, isNotFound(err), "user health check created: %v", err)
+}
+
 func TestClearPreviousInternalResources(t *testing.T) {
 	// Configure testing environment.
 	vals := DefaultTestClusterValues()
@@ -635,13 +961,13 @@ func TestClearPreviousInternalResources(t *testing.T) {
 	c := gce.c.(*cloud.MockGCE)
 	require.NoError(t, err)
 
-	hc1, err := gce.ensureInternalHealthCheck("hc1", nm, false, "healthz", 12345)
+	hc1, err := gce.ensureInternalHealthCheck("hc1", nm, false, "healthz", 12345, false, HealthCheckDefaults{})
 	require.NoError(t, err)
 
-	hc2, err := gce.ensureInternalHealthCheck("hc2", nm, false, "healthz", 12346)
+	hc2, err := gce.ensureInternalHealthCheck("hc2", nm, false, "healthz", 12346, false, HealthCheckDefaults{})
 	require.NoError(t, err)
 
-	err = gce.ensureInternalBackendService(svc.ObjectMeta.Name, "", svc.Spec.SessionAffinity, cloud.SchemeInternal, v1.ProtocolTCP, []string{}, "")
+	err = gce.ensureInternalBackendService(svc, svc.ObjectMeta.Name, "", backendServiceAffinity(svc), cloud.SchemeInternal, v1.ProtocolTCP, []string{}, "", nil)
 	require.NoError(t, err)
 	backendSvc, err := gce.GetRegionBackendService(svc.ObjectMeta.Name, gce.region)
 	require.NoError(t, err)
@@ -649,7 +975,7 @@ func TestClearPreviousInternalResources(t *testing.T) {
 
 	c.MockRegionBackendServices.DeleteHook = mock.DeleteRegionBackendServicesErrHook
 	c.MockHealthChecks.DeleteHook = mock.DeleteHealthChecksInternalErrHook
-	gce.clearPreviousInternalResources(svc, loadBalancerName, backendSvc, "expectedBSName", "expectedHCName")
+	gce.clearPreviousInternalResources(svc, loadBalancerName, vals.ClusterID, backendSvc, "expectedBSName", "expectedHCName")
 
 	backendSvc, err = gce.GetRegionBackendService(svc.ObjectMeta.Name, gce.region)
 	assert.NoError(t, err)
@@ -663,14 +989,14 @@ func TestClearPreviousInternalResources(t *testing.T) {
 
 	c.MockRegionBackendServices.DeleteHook = mock.DeleteRegionBackendServicesInUseErrHook
 	backendSvc.HealthChecks = []string{hc1.SelfLink}
-	gce.clearPreviousInternalResources(svc, loadBalancerName, backendSvc, "expectedBSName", "expectedHCName")
+	gce.clearPreviousInternalResources(svc, loadBalancerName, vals.ClusterID, backendSvc, "expectedBSName", "expectedHCName")
 
 	hc1, err = gce.GetHealthCheck("hc1")
 	assert.NoError(t, err)
 	assert.NotNil(t, hc1, "HealthCheck should not be deleted when api is mocked out.")
 
 	c.MockHealthChecks.DeleteHook = mock.DeleteHealthChecksInuseErrHook
-	gce.clearPreviousInternalResources(svc, loadBalancerName, backendSvc, "expectedBSName", "expectedHCName")
+	gce.clearPreviousInternalResources(svc, loadBalancerName, vals.ClusterID, backendSvc, "expectedBSName", "expectedHCName")
 
 	hc1, err = gce.GetHealthCheck("hc1")
 	assert.NoError(t, err)
@@ -678,7 +1004,7 @@ func TestClearPreviousInternalResources(t *testing.T) {
 
 	c.MockRegionBackendServices.DeleteHook = nil
 	c.MockHealthChecks.DeleteHook = nil
-	gce.clearPreviousInternalResources(svc, loadBalancerName, backendSvc, "expectedBSName", "expectedHCName")
+	gce.clearPreviousInternalResources(svc, loadBalancerName, vals.ClusterID, backendSvc, "expectedBSName", "expectedHCName")
 
 	backendSvc, err = gce.GetRegionBackendService(svc.ObjectMeta.Name, gce.region)
 	assert.Error(t, err)
@@ -867,6 +1193,51 @@ func TestEnsureLoadBalancerDeletedSucceedsOnXPN(t *testing.T) {
 	checkEvent(t, recorder, FirewallChangeMsg, true)
 }
 
+func TestEnsureInternalInstanceGroupsManagedInstanceGroups(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.managedInstanceGroupsPrefix = "capi-"
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1", "test-node-2", "test-node-3", "test-node-4"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The group of a scaling machine pool has an instance which is not a node
+	// yet, the group of another prefix is not used.
+	for migName, instances := range map[string][]string{
+		"capi-pool":    {"test-node-1", "test-node-2"},
+		"capi-scaling": {"test-node-3", "test-node-booting"},
+		"other-pool":   {"test-node-4"},
+	} {
+		require.NoError(t, gce.CreateInstanceGroup(&compute.InstanceGroup{Name: migName}, vals.ZoneName))
+		require.NoError(t, gce.AddInstancesToInstanceGroup(migName, vals.ZoneName, gce.ToInstanceReferences(vals.ZoneName, instances)))
+		ig, err := gce.GetInstanceGroup(migName, vals.ZoneName)
+		require.NoError(t, err)
+		mig := &compute.InstanceGroupManager{Name: migName, InstanceGroup: ig.SelfLink}
+		require.NoError(t, gce.c.InstanceGroupManagers().Insert(context.TODO(), meta.ZonalKey(migName, vals.ZoneName), mig))
+	}
+
+	igName := makeInstanceGroupName(vals.ClusterID)
+	igLinks, err := gce.ensureInternalInstanceGroups(igName, nodes)
+	require.NoError(t, err)
+	migIG, err := gce.GetInstanceGroup("capi-pool", vals.ZoneName)
+	require.NoError(t, err)
+	ig, err := gce.GetInstanceGroup(igName, vals.ZoneName)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{migIG.SelfLink, ig.SelfLink}, igLinks)
+
+	// Only the nodes of the used managed instance group are not in the group
+	// of the provider.
+	instances, err := gce.ListInstancesInInstanceGroup(igName, vals.ZoneName, allInstances)
+	require.NoError(t, err)
+	var names []string
+	for _, ins := range instances {
+		names = append(names, path.Base(ins.Instance))
+	}
+	assert.ElementsMatch(t, []string{"test-node-3", "test-node-4"}, names)
+}
+
 func TestEnsureInternalInstanceGroupsReuseGroups(t *testing.T) {
 	vals := DefaultTestClusterValues()
 	gce, err := fakeGCECloud(vals)
@@ -906,7 +1277,7 @@ func TestEnsureInternalInstanceGroupsReuseGroups(t *testing.T) {
 	)
 	assert.NoError(t, err)
 
-	backendServiceName := makeBackendServiceName(gce.GetLoadBalancerName(context.TODO(), "", svc), vals.ClusterID, shareBackendService(svc), cloud.SchemeInternal, "TCP", svc.Spec.SessionAffinity)
+	backendServiceName := makeBackendServiceName(gce.GetLoadBalancerName(context.TODO(), "", svc), vals.ClusterID, shareBackendService(svc), cloud.SchemeInternal, "TCP", backendServiceAffinity(svc))
 	bs, err := gce.GetRegionBackendService(backendServiceName, gce.region)
 	require.NoError(t, err)
 	assert.Equal(t, 3, len(bs.Backends), "Want three backends referencing three instances groups")
@@ -963,14 +1334,14 @@ func TestEnsureInternalInstanceGroupsDeleted(t *testing.T) {
 	c.MockZones.ListHook = nil
 	c.MockInstanceGroups.DeleteHook = mock.DeleteInstanceGroupInternalErrHook
 
-	err = gce.ensureInternalInstanceGroupsDeleted(igName)
+	err = gce.ensureInternalInstanceGroupsDeleted(igName, gce.region)
 	assert.Error(t, err, mock.InternalServerError)
 	ig, err = gce.GetInstanceGroup(igName, vals.ZoneName)
 	assert.NoError(t, err)
 	assert.NotNil(t, ig)
 
 	c.MockInstanceGroups.DeleteHook = nil
-	err = gce.ensureInternalInstanceGroupsDeleted(igName)
+	err = gce.ensureInternalInstanceGroupsDeleted(igName, gce.region)
 	assert.NoError(t, err)
 	ig, err = gce.GetInstanceGroup(igName, vals.ZoneName)
 	assert.Error(t, err)
@@ -1135,6 +1506,32 @@ func TestMergeHealthChecks(t *testing.T) {
 	}
 }
 
+func TestCompareHealthChecksLogging(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc        string
+		logConfig   *compute.HealthCheckLogConfig
+		wantLogging bool
+		wantChanged bool
+	}{
+		{"disabled - unset", nil, false, false},
+		{"disabled - unchanged", &compute.HealthCheckLogConfig{Enable: false}, false, false},
+		{"disabled - needs update", &compute.HealthCheckLogConfig{Enable: true}, false, true},
+		{"enabled - unset", nil, true, true},
+		{"enabled - unchanged", &compute.HealthCheckLogConfig{Enable: true}, true, false},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			hc := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
+			hc.LogConfig = tc.logConfig
+			wantHC := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
+			wantHC.LogConfig = &compute.HealthCheckLogConfig{Enable: tc.wantLogging}
+			if gotChanged := needToUpdateHealthChecks(hc, wantHC); gotChanged != tc.wantChanged {
+				t.Errorf("needToUpdateHealthChecks(%#v, %#v) = %t; want changed = %t", hc, wantHC, gotChanged, tc.wantChanged)
+			}
+		})
+	}
+}
+
 func TestCompareHealthChecks(t *testing.T) {
 	t.Parallel()
 	for _, tc := range []struct {
@@ -1155,6 +1552,7 @@ func TestCompareHealthChecks(t *testing.T) {
 		{"timeout does not need update", func(hc *compute.HealthCheck) { hc.TimeoutSec = gceHcTimeoutSeconds + 1 }, false},
 		{"healthy threshold does not need update", func(hc *compute.HealthCheck) { hc.HealthyThreshold = gceHcHealthyThreshold + 1 }, false},
 		{"unhealthy threshold does not need update", func(hc *compute.HealthCheck) { hc.UnhealthyThreshold = gceHcUnhealthyThreshold + 1 }, false},
+		{"unmanaged logging does not need update", func(hc *compute.HealthCheck) { hc.LogConfig = &compute.HealthCheckLogConfig{Enable: true} }, false},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			hc := newInternalLBHealthCheck("hc", types.NamespacedName{Name: "svc", Namespace: "default"}, false, "/", 12345)
@@ -1169,6 +1567,30 @@ func TestCompareHealthChecks(t *testing.T) {
 	}
 }
 
+func TestCompareTCPHealthChecks(t *testing.T) {
+	t.Parallel()
+	nm := types.NamespacedName{Name: "svc", Namespace: "default"}
+	for _, tc := range []struct {
+		desc        string
+		hc          *compute.HealthCheck
+		wantChanged bool
+	}{
+		{"unchanged", newInternalLBTCPHealthCheck("hc", nm, 30123), false},
+		{"port does not match", newInternalLBTCPHealthCheck("hc", nm, 30124), true},
+		{"HTTP health check", newInternalLBHealthCheck("hc", nm, false, "/", 30123), true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			wantHC := newInternalLBTCPHealthCheck("hc", nm, 30123)
+			if gotChanged := needToUpdateHealthChecks(tc.hc, wantHC); gotChanged != tc.wantChanged {
+				t.Errorf("needToUpdateHealthChecks(%#v, %#v) = %t; want changed = %t", tc.hc, wantHC, gotChanged, tc.wantChanged)
+			}
+		})
+	}
+	if !needToUpdateHealthChecks(newInternalLBTCPHealthCheck("hc", nm, 30123), newInternalLBHealthCheck("hc", nm, false, "/", 30123)) {
+		t.Errorf("needToUpdateHealthChecks() = false for a TCP health check switched to HTTP, want true")
+	}
+}
+
 // Test creation of InternalLoadBalancer with ILB Subsets featuregate enabled.
 func TestEnsureInternalLoadBalancerSubsetting(t *testing.T) {
 	t.Parallel()
@@ -1430,28 +1852,341 @@ func TestEnsureInternalLoadBalancerDisableGlobalAccess(t *testing.T) {
 	assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
 }
 
-func TestGlobalAccessChangeScheme(t *testing.T) {
+func TestEnsureInternalLoadBalancerResourceDescription(t *testing.T) {
 	t.Parallel()
 
 	vals := DefaultTestClusterValues()
 	gce, err := fakeGCECloud(vals)
 	require.NoError(t, err)
 
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationResourceDescription] = `{"ticket":"OPS-123"}`
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	d := &forwardingRuleDescription{}
+	require.NoError(t, d.unmarshal(fwdRule.Description))
+	assert.Equal(t, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}.String(), d.ServiceName)
+	assert.Equal(t, map[string]string{"ticket": "OPS-123"}, d.CustomFields)
+
+	version, err := getFwdRuleAPIVersion(fwdRule)
+	require.NoError(t, err)
+	assert.Equal(t, meta.VersionGA, version)
+}
+
+// fakeGCECloudWithIPv6 returns a fake cloud whose forwarding rules created with
+// the IPV6 ip version get an ephemeral IPv6 address, as done by GCE. Each
+// address is only handed out once.
+func fakeGCECloudWithIPv6(t *testing.T, vals TestClusterValues) *Cloud {
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	ipv6Count := 0
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockForwardingRules.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.ForwardingRule, m *cloud.MockForwardingRules, options ...cloud.Option) (bool, error) {
+		if obj.IpVersion == ipVersionIPv6 && obj.IPAddress == "" {
+			ipv6Count++
+			obj.IPAddress = fmt.Sprintf("2001:db8::%d", ipv6Count)
+		}
+		return mock.InsertFwdRuleHook(ctx, key, obj, m, options...)
+	}
+	return gce
+}
+
+func TestEnsureInternalLoadBalancerAddIPv6Family(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+
 	nodeNames := []string{"test-node-1"}
 	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
 	require.NoError(t, err)
 	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
 	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
 	require.NoError(t, err)
-	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
 	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
-	if err != nil {
-		t.Errorf("Unexpected error %v", err)
-	}
-	assert.NotEmpty(t, status.Ingress)
-	// Change service to include the global access annotation
-	svc.Annotations[ServiceAnnotationILBAllowGlobalAccess] = "true"
-	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	ipv4FwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+
+	// Switch the service to dual-stack.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ipv4FwdRule.IPAddress}, {IP: "2001:db8::1"}}, status.Ingress)
+
+	// The IPv4 forwarding rule must be left untouched.
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipv4FwdRule, fwdRule)
+
+	ipv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipVersionIPv6, ipv6FwdRule.IpVersion)
+	assert.Equal(t, fwdRule.BackendService, ipv6FwdRule.BackendService)
+	for _, fwName := range []string{
+		MakeFirewallName(makeIPv6ResourceName(lbName)),
+		makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)),
+	} {
+		fw, err := gce.GetFirewall(fwName)
+		require.NoError(t, err)
+		for _, sourceRange := range fw.SourceRanges {
+			assert.Contains(t, sourceRange, ":")
+		}
+	}
+
+	lbStatus, exists, err := gce.GetLoadBalancer(context.Background(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, status, lbStatus)
+
+	// Resyncing the dual-stack service leaves both forwarding rules alone.
+	resyncStatus, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, status, resyncStatus)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipv4FwdRule, fwdRule)
+	resyncIPv6FwdRule, err := gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ipv6FwdRule, resyncIPv6FwdRule)
+
+	// Changing the ports recreates the IPv6 forwarding rule with the same address.
+	svc.Spec.Ports = []v1.ServicePort{{Name: "testport", Port: int32(8081), Protocol: "TCP"}}
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ipv4FwdRule.IPAddress}, {IP: "2001:db8::1"}}, status.Ingress)
+	ipv6FwdRule, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", ipv6FwdRule.IPAddress)
+	assert.Equal(t, []string{"8081"}, ipv6FwdRule.Ports)
+
+	// Switching back to single-stack removes the IPv6 forwarding rule only.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: ipv4FwdRule.IPAddress}}, status.Ingress)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	assert.True(t, isNotFound(err))
+
+	// Delete the service with both families attached.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	err = gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)))
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerIPv6SourceRanges(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8", "2001:db8:1::/48"}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fw, err := gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, fw.SourceRanges)
+	fw, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8:1::/48"}, fw.SourceRanges)
+
+	// With IPv6 source ranges only, no IPv4 traffic is allowed.
+	svc.Spec.LoadBalancerSourceRanges = []string{"2001:db8:1::/48"}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetFirewall(MakeFirewallName(lbName))
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	require.NoError(t, err)
+
+	// With IPv4 source ranges only, no IPv6 traffic is allowed.
+	svc.Spec.LoadBalancerSourceRanges = []string{"10.0.0.0/8"}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fw, err = gce.GetFirewall(MakeFirewallName(lbName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, fw.SourceRanges)
+	_, err = gce.GetFirewall(MakeFirewallName(makeIPv6ResourceName(lbName)))
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerIPv6LocalTrafficPolicy(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 30123
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	hcFwName := makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fw, err := gce.GetFirewall(hcFwName)
+	require.NoError(t, err)
+	assert.Equal(t, l4LbIPv6HealthCheckSrcRanges, fw.SourceRanges)
+	assert.Equal(t, []string{"30123"}, fw.Allowed[0].Ports)
+	_, err = gce.GetFirewall(makeIPv6ResourceName(makeHealthCheckFirewallName(lbName, vals.ClusterID, true)))
+	assert.True(t, isNotFound(err))
+
+	// The IPv6 health check firewall is owned by the service and removed with IPv6.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetFirewall(hcFwName)
+	assert.True(t, isNotFound(err))
+
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	err = gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(lbName), gce.region)
+	assert.True(t, isNotFound(err))
+	_, err = gce.GetFirewall(hcFwName)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerIPv6FamilyOrder(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol}
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []v1.LoadBalancerIngress{{IP: "2001:db8::1"}, {IP: fwdRule.IPAddress}}, status.Ingress)
+	lbStatus, _, err := gce.GetLoadBalancer(context.Background(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assert.Equal(t, status, lbStatus)
+
+	// IPv6 single-stack services are rejected.
+	ipv6Svc := fakeLoadbalancerService(string(LBTypeInternal))
+	ipv6Svc.Name = "ipv6-only"
+	ipv6Svc.UID = "ipv6-only"
+	ipv6Svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol}
+	ipv6Svc, err = gce.client.CoreV1().Services(ipv6Svc.Namespace).Create(context.TODO(), ipv6Svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, ipv6Svc, nodes)
+	assert.Error(t, err)
+	_, err = gce.GetRegionForwardingRule(gce.GetLoadBalancerName(context.TODO(), "", ipv6Svc), gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestEnsureInternalLoadBalancerSingleStackOnXPN(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce := fakeGCECloudWithIPv6(t, vals)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// Resyncing or deleting a single-stack service never attempts to delete
+	// IPv6 firewalls, which would raise firewall change events on XPN.
+	c := gce.c.(*cloud.MockGCE)
+	c.MockFirewalls.DeleteHook = func(ctx context.Context, key *meta.Key, m *cloud.MockFirewalls, options ...cloud.Option) (bool, error) {
+		if strings.HasSuffix(key.Name, "-ipv6") {
+			t.Errorf("unexpected deletion of firewall %v", key.Name)
+		}
+		return false, nil
+	}
+	gce.onXPN = true
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	checkEvent(t, recorder, FirewallChangeMsg, false)
+
+	// A dual-stack service on XPN raises an event when its IPv6 firewall cannot be removed.
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+	gce.onXPN = false
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	gce.onXPN = true
+	c.MockFirewalls.DeleteHook = mock.DeleteFirewallsUnauthorizedErrHook
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	checkEvent(t, recorder, FirewallChangeMsg, true)
+	_, err = gce.GetRegionForwardingRule(makeIPv6ResourceName(gce.GetLoadBalancerName(context.TODO(), "", svc)), gce.region)
+	assert.True(t, isNotFound(err))
+}
+
+func TestGlobalAccessChangeScheme(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	status, err := createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	assert.NotEmpty(t, status.Ingress)
+	// Change service to include the global access annotation
+	svc.Annotations[ServiceAnnotationILBAllowGlobalAccess] = "true"
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -2086,3 +2821,323 @@ func TestEnsureInternalLoadBalancerAllPorts(t *testing.T) {
 	}
 	assertInternalLbResourcesDeleted(t, gce, svc, vals, true)
 }
+
+func TestEnsureInternalLoadBalancerPSCPacketInjection(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	gce.c.(*cloud.MockGCE).MockBetaForwardingRules.InsertHook = mock.InsertBetaFwdRuleHook
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBAllowPSCPacketInjection] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nodeNames := []string{"test-node-1"}
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetBetaRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.True(t, fwdRule.AllowPscPacketInjection)
+	gaFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	version, err := getFwdRuleAPIVersion(gaFwdRule)
+	require.NoError(t, err)
+	assert.Equal(t, meta.VersionBeta, version)
+
+	// The rule is kept as long as the beta fields match.
+	changed, err := gce.internalForwardingRuleBetaFieldsChanged(gaFwdRule, gce.region, getILBOptions(svc))
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Disabling the packet injection recreates the rule with the GA API.
+	delete(svc.Annotations, ServiceAnnotationILBAllowPSCPacketInjection)
+	_, err = createInternalLoadBalancer(gce, svc, gaFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwdRule, err = gce.GetBetaRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.False(t, fwdRule.AllowPscPacketInjection)
+	gaFwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	version, err = getFwdRuleAPIVersion(gaFwdRule)
+	require.NoError(t, err)
+	assert.Equal(t, meta.VersionGA, version)
+}
+
+func TestEnsureInternalLoadBalancerPSCPacketInjectionBetaUnavailable(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	betaCalls := 0
+	unavailable := &googleapi.Error{Code: http.StatusNotFound, Body: "<html>Not Found</html>"}
+	mockGCE := gce.c.(*cloud.MockGCE)
+	mockGCE.MockBetaForwardingRules.InsertHook = func(context.Context, *meta.Key, *computebeta.ForwardingRule, *cloud.MockBetaForwardingRules, ...cloud.Option) (bool, error) {
+		betaCalls++
+		return true, unavailable
+	}
+	mockGCE.MockBetaForwardingRules.GetHook = func(context.Context, *meta.Key, *cloud.MockBetaForwardingRules, ...cloud.Option) (bool, *computebeta.ForwardingRule, error) {
+		betaCalls++
+		return true, nil, unavailable
+	}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBAllowPSCPacketInjection] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nodeNames := []string{"test-node-1"}
+
+	// The rule is created with the GA API, and is not recreated by the next syncs.
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, "Warning BetaAPIUnavailable", true)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	version, err := getFwdRuleAPIVersion(fwdRule)
+	require.NoError(t, err)
+	assert.Equal(t, meta.VersionGA, version)
+
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, 1, betaCalls, "the unavailable beta API is not called again")
+}
+
+func TestEnsureInternalLoadBalancerHealthCheckLogging(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	svc.Spec.HealthCheckNodePort = 32000
+	svc.Annotations[ServiceAnnotationILBHealthCheckLogging] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	hcName := makeHealthCheckName(lbName, vals.ClusterID, false)
+	nodeNames := []string{"test-node-1"}
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(hcName)
+	require.NoError(t, err)
+	assert.True(t, healthCheckLoggingEnabled(hc))
+
+	// Removing the annotation disables the logging again.
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationILBHealthCheckLogging)
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(hcName)
+	require.NoError(t, err)
+	assert.False(t, healthCheckLoggingEnabled(hc))
+}
+
+func TestEnsureInternalLoadBalancerHealthCheckLoggingShared(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationILBHealthCheckLogging] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	require.NoError(t, err)
+	assert.False(t, healthCheckLoggingEnabled(hc), "the logging of shared health checks is not managed per Service")
+	checkEvent(t, recorder, "Warning HealthCheckLoggingIgnored", true)
+}
+
+func TestEnsureInternalLoadBalancerNodesHealthCheckOverride(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort] = "10257"
+	svc.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPath] = "/ready"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nodeNames := []string{"test-node-1"}
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	require.NoError(t, err, "the overridden health check is not shared")
+	assert.Equal(t, int64(10257), hc.HttpHealthCheck.Port)
+	assert.Equal(t, "/ready", hc.HttpHealthCheck.RequestPath)
+	fw, err := gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10257"}, fw.Allowed[0].Ports)
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	assert.True(t, isNotFound(err), "the shared health check is not created")
+
+	// Removing the annotations switches the Service to the shared health check.
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerNodesHealthCheckPort)
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerNodesHealthCheckPath)
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	require.NoError(t, err)
+	assert.Equal(t, int64(GetNodesHealthCheckPort()), hc.HttpHealthCheck.Port)
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	assert.True(t, isNotFound(err), "the overridden health check is deleted")
+}
+
+func TestEnsureInternalLoadBalancerTCPHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Spec.Ports[0].NodePort = 30123
+	svc.Annotations[ServiceAnnotationILBHealthCheckType] = string(HealthCheckTypeTCP)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nodeNames := []string{"test-node-1"}
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err := gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	require.NoError(t, err, "the TCP health check is not shared")
+	assert.Equal(t, "TCP", hc.Type)
+	require.NotNil(t, hc.TcpHealthCheck)
+	assert.Equal(t, int64(30123), hc.TcpHealthCheck.Port)
+	assert.Nil(t, hc.HttpHealthCheck)
+	fw, err := gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"30123"}, fw.Allowed[0].Ports)
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	assert.True(t, isNotFound(err), "the shared health check is not created")
+
+	// A Service without node ports cannot be health checked with TCP.
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	noNodePorts := svc.DeepCopy()
+	noNodePorts.Spec.Ports[0].NodePort = 0
+	_, err = createInternalLoadBalancer(gce, noNodePorts, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationILBHealthCheckType)
+
+	invalid := svc.DeepCopy()
+	invalid.Annotations[ServiceAnnotationILBHealthCheckType] = "UDP"
+	_, err = createInternalLoadBalancer(gce, invalid, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, ServiceAnnotationILBHealthCheckType, "invalid types are reported")
+
+	// Local traffic is health checked with TCP as well.
+	local := svc.DeepCopy()
+	local.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyTypeLocal
+	local.Spec.HealthCheckNodePort = 32000
+	_, err = createInternalLoadBalancer(gce, local, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	checkEvent(t, recorder, "Warning HTTPHealthCheckIgnored", true)
+	hc, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	require.NoError(t, err)
+	assert.Equal(t, int64(30123), hc.TcpHealthCheck.Port)
+
+	// Removing the annotation switches the Service back to the HTTP health
+	// check.
+	delete(svc.Annotations, ServiceAnnotationILBHealthCheckType)
+	_, err = createInternalLoadBalancer(gce, svc, fwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	hc, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, true))
+	require.NoError(t, err)
+	assert.Equal(t, int64(GetNodesHealthCheckPort()), hc.HttpHealthCheck.Port)
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	assert.True(t, isNotFound(err), "the TCP health check is deleted")
+}
+
+func TestEnsureInternalLoadBalancerDeletedNodesHealthCheckOverride(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerNodesHealthCheckPort] = "10257"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	require.NoError(t, gce.ensureInternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, svc))
+	_, err = gce.GetHealthCheck(makeHealthCheckName(lbName, vals.ClusterID, false))
+	assert.True(t, isNotFound(err), "the overridden health check is deleted")
+	_, err = gce.GetFirewall(makeHealthCheckFirewallName(lbName, vals.ClusterID, false))
+	assert.True(t, isNotFound(err), "the firewall of the overridden health check is deleted")
+}
+
+func TestTCPHealthCheckPort(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		ports   []v1.ServicePort
+		want    int32
+		wantErr bool
+	}{
+		{
+			desc:  "first TCP port",
+			ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, NodePort: 30001}, {Protocol: v1.ProtocolTCP, NodePort: 30002}},
+			want:  30001,
+		},
+		{
+			desc:  "UDP ports are skipped",
+			ports: []v1.ServicePort{{Protocol: v1.ProtocolUDP, NodePort: 30001}, {Protocol: v1.ProtocolTCP, NodePort: 30002}},
+			want:  30002,
+		},
+		{
+			desc:    "UDP only",
+			ports:   []v1.ServicePort{{Protocol: v1.ProtocolUDP, NodePort: 30001}},
+			wantErr: true,
+		},
+		{
+			desc:    "no node ports",
+			ports:   []v1.ServicePort{{Protocol: v1.ProtocolTCP}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService(string(LBTypeInternal))
+			svc.Spec.Ports = tc.ports
+			port, err := tcpHealthCheckPort(svc)
+			if tc.wantErr {
+				assert.ErrorContains(t, err, ServiceAnnotationILBHealthCheckType)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, port)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maintenanceWindowTimeLayout is the layout of the start and end times
+	// of maintenance windows.
+	maintenanceWindowTimeLayout = "15:04"
+
+	// DisruptiveChangeDeferred is the reason of the events reporting a load
+	// balancer change deferred to the maintenance window of the Service.
+	DisruptiveChangeDeferred = "DisruptiveChangeDeferred"
+)
+
+// errDisruptiveChangeDeferred is returned when a disruptive change of a load
+// balancer is deferred to the maintenance window of its Service, so that the
+// Service is synced again until the window starts.
+var errDisruptiveChangeDeferred = errors.New("disruptive load balancer change deferred to the maintenance window")
+
+// maintenanceWindow is a daily or weekly time window, in UTC.
+type maintenanceWindow struct {
+	// days are the days the window starts, or every day if empty.
+	days map[time.Weekday]bool
+	// start and end are the offsets from midnight. The window ends the next
+	// day if end is before start.
+	start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseMaintenanceWindow parses a window formatted as "[DAYS ]HH:MM-HH:MM",
+// with DAYS a comma separated list of weekdays, e.g. "Sat,Sun 02:00-04:00".
+func parseMaintenanceWindow(val string) (*maintenanceWindow, error) {
+	fields := strings.Fields(val)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected [DAYS ]HH:MM-HH:MM", val)
+	}
+	w := &maintenanceWindow{}
+	if len(fields) == 2 {
+		w.days = map[time.Weekday]bool{}
+		for _, day := range strings.Split(fields[0], ",") {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid maintenance window %q, unknown day %q", val, day)
+			}
+			w.days[weekday] = true
+		}
+	}
+	times := strings.Split(fields[len(fields)-1], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected [DAYS ]HH:MM-HH:MM", val)
+	}
+	offsets := make([]time.Duration, len(times))
+	for i, t := range times {
+		parsed, err := time.Parse(maintenanceWindowTimeLayout, t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %v", val, err)
+		}
+		offsets[i] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+	w.start, w.end = offsets[0], offsets[1]
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid maintenance window %q, the window is empty", val)
+	}
+	return w, nil
+}
+
+func (w *maintenanceWindow) startsOn(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// contains returns true if t is inside the window.
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := t.Sub(midnight)
+	if w.start < w.end {
+		return w.startsOn(t.Weekday()) && offset >= w.start && offset < w.end
+	}
+	// The window ends the day after it starts.
+	return (w.startsOn(t.Weekday()) && offset >= w.start) ||
+		(w.startsOn(midnight.AddDate(0, 0, -1).Weekday()) && offset < w.end)
+}
+
+// next returns the next start of the window after t.
+func (w *maintenanceWindow) next(t time.Time) time.Time {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for i := 0; ; i++ {
+		day := midnight.AddDate(0, 0, i)
+		if start := day.Add(w.start); start.After(t) && w.startsOn(day.Weekday()) {
+			return start
+		}
+	}
+}
+
+// deferDisruptiveChange returns true if the given disruptive change of the
+// load balancer of the Service must wait for its maintenance window, and
+// records an event to report it. It returns an error if the maintenance window
+// annotation is invalid.
+func (g *Cloud) deferDisruptiveChange(svc *v1.Service, change string) (bool, error) {
+	val, ok := svc.Annotations[ServiceAnnotationLoadBalancerMaintenanceWindow]
+	if !ok {
+		return false, nil
+	}
+	w, err := parseMaintenanceWindow(val)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse annotation %q: %v", ServiceAnnotationLoadBalancerMaintenanceWindow, err)
+	}
+	now := g.clock.Now()
+	if w.contains(now) {
+		return false, nil
+	}
+	next := w.next(now)
+	klog.V(2).Infof("Deferring %s of Service %s/%s to the maintenance window starting at %v", change, svc.Namespace, svc.Name, next)
+	g.eventRecorder.Eventf(svc, v1.EventTypeNormal, DisruptiveChangeDeferred, "Deferring %s to the maintenance window starting at %s", change, next.Format(time.RFC3339))
+	return true, nil
+}
@@ -0,0 +1,192 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc    string
+		val     string
+		want    *maintenanceWindow
+		wantErr bool
+	}{
+		{
+			desc: "daily",
+			val:  "02:00-04:30",
+			want: &maintenanceWindow{start: 2 * time.Hour, end: 4*time.Hour + 30*time.Minute},
+		},
+		{
+			desc: "weekly",
+			val:  "Sat,sun 23:00-01:00",
+			want: &maintenanceWindow{days: map[time.Weekday]bool{time.Saturday: true, time.Sunday: true}, start: 23 * time.Hour, end: time.Hour},
+		},
+		{desc: "empty", val: "", wantErr: true},
+		{desc: "unknown day", val: "Someday 02:00-04:00", wantErr: true},
+		{desc: "missing end", val: "02:00", wantErr: true},
+		{desc: "invalid time", val: "02:00-25:00", wantErr: true},
+		{desc: "empty window", val: "02:00-02:00", wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseMaintenanceWindow(tc.val)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	t.Parallel()
+
+	// 2024-06-01 is a Saturday.
+	saturday := func(hour, min int) time.Time { return time.Date(2024, 6, 1, hour, min, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		desc     string
+		val      string
+		now      time.Time
+		want     bool
+		wantNext time.Time
+	}{
+		{desc: "daily - before", val: "02:00-04:00", now: saturday(1, 59), want: false, wantNext: saturday(2, 0)},
+		{desc: "daily - inside", val: "02:00-04:00", now: saturday(2, 0), want: true, wantNext: saturday(26, 0)},
+		{desc: "daily - after", val: "02:00-04:00", now: saturday(4, 0), want: false, wantNext: saturday(26, 0)},
+		{desc: "weekly - other day", val: "Mon 02:00-04:00", now: saturday(3, 0), want: false, wantNext: saturday(50, 0)},
+		{desc: "overnight - started the day before", val: "Fri 23:00-01:00", now: saturday(0, 30), want: true, wantNext: saturday(6*24+23, 0)},
+		{desc: "overnight - started today", val: "Sat 23:00-01:00", now: saturday(23, 30), want: true, wantNext: saturday(7*24+23, 0)},
+		{desc: "overnight - other day", val: "Sat 23:00-01:00", now: saturday(0, 30), want: false, wantNext: saturday(23, 0)},
+		{desc: "non-UTC time", val: "02:00-04:00", now: saturday(3, 0).In(time.FixedZone("UTC+5", 5*3600)), want: true, wantNext: saturday(26, 0)},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			w, err := parseMaintenanceWindow(tc.val)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, w.contains(tc.now))
+			assert.Equal(t, tc.wantNext, w.next(tc.now))
+		})
+	}
+}
+
+func TestEnsureExternalLoadBalancerMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	clock := testingclock.NewFakePassiveClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	gce.clock = clock
+	nodeNames := []string{"test-node-1"}
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerMaintenanceWindow] = "02:00-04:00"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The load balancer is created outside of the window.
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	// Changing the ports recreates the forwarding rule, in the window only.
+	svc.Spec.Ports[0].Port = 8080
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorIs(t, err, errDisruptiveChangeDeferred)
+	checkEvent(t, recorder, "Normal DisruptiveChangeDeferred", true)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "123-123", fwdRule.PortRange)
+
+	clock.SetTime(time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC))
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, "8080-8080", fwdRule.PortRange)
+}
+
+func TestEnsureInternalLoadBalancerMaintenanceWindow(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	clock := testingclock.NewFakePassiveClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	gce.clock = clock
+	nodeNames := []string{"test-node-1"}
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerMaintenanceWindow] = "Sun 02:00-04:00"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = createInternalLoadBalancer(gce, svc, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	existingFwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+
+	svc.Spec.Ports[0].Port = 8080
+	_, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorIs(t, err, errDisruptiveChangeDeferred)
+	checkEvent(t, recorder, "Normal DisruptiveChangeDeferred", true)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"123"}, fwdRule.Ports)
+
+	clock.SetTime(time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC))
+	_, err = createInternalLoadBalancer(gce, svc, existingFwdRule, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	fwdRule, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"8080"}, fwdRule.Ports)
+}
+
+func TestDeferDisruptiveChangeInvalidWindow(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerMaintenanceWindow] = "weekends"
+	_, err = gce.deferDisruptiveChange(svc, "test")
+	assert.Error(t, err)
+}
@@ -42,12 +42,23 @@ func makeInstanceGroupName(clusterID string) string {
 	return fmt.Sprintf("%s--%s", prefix, clusterID)
 }
 
-func makeBackendServiceName(loadBalancerName, clusterID string, shared bool, scheme cloud.LbScheme, protocol v1.Protocol, svcAffinity v1.ServiceAffinity) string {
+// makeBackendServiceName returns the name of the backend service of the load
+// balancer. The shared backend services are named after the GCE session
+// affinity.
+func makeBackendServiceName(loadBalancerName, clusterID string, shared bool, scheme cloud.LbScheme, protocol v1.Protocol, affinity string) string {
 	if shared {
 		hash := sha1.New()
 
 		// For every non-nil option, hash its value. Currently, only service affinity is relevant.
-		hash.Write([]byte(string(svcAffinity)))
+		// The affinities of the Services are hashed as the Kubernetes ones,
+		// which the existing backend services are named after.
+		switch affinity {
+		case gceAffinityTypeNone:
+			affinity = string(v1.ServiceAffinityNone)
+		case gceAffinityTypeClientIP:
+			affinity = string(v1.ServiceAffinityClientIP)
+		}
+		hash.Write([]byte(affinity))
 
 		hashed := hex.EncodeToString(hash.Sum(nil))
 		hashed = hashed[:16]
@@ -84,6 +95,12 @@ func makeHealthCheckFirewallName(loadBalancerName, clusterID string, shared bool
 	return loadBalancerName + "-hc"
 }
 
+// makeIPv6ResourceName returns the name of the IPv6 counterpart of a load
+// balancer resource, e.g. the IPv6 forwarding rule of a dual-stack ILB.
+func makeIPv6ResourceName(name string) string {
+	return name + "-ipv6"
+}
+
 func makeBackendServiceDescription(nm types.NamespacedName, shared bool) string {
 	if shared {
 		return ""
@@ -98,6 +115,21 @@ func makeServiceDescription(serviceName string) string {
 	return fmt.Sprintf(`{"kubernetes.io/service-name":"%s"}`, serviceName)
 }
 
+// makeServiceDescriptionWithFields is used to generate descriptions for forwarding
+// rules and target pools, including the custom fields of the resource description
+// annotation of the Service.
+func makeServiceDescriptionWithFields(svc *v1.Service, serviceName string) (string, error) {
+	fields, err := GetServiceAnnotationResourceDescription(svc)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) == 0 {
+		return makeServiceDescription(serviceName), nil
+	}
+	d := &forwardingRuleDescription{ServiceName: serviceName, CustomFields: fields}
+	return d.marshal()
+}
+
 // MakeNodesHealthCheckName returns name of the health check resource used by
 // the GCE load balancers (l4) for performing health checks on nodes.
 func MakeNodesHealthCheckName(clusterID string) string {
@@ -0,0 +1,128 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// nodeFlappingMinNodes is the minimum number of nodes leaving the load
+	// balancers at once for the change to be considered a cluster-wide
+	// flapping of the nodes.
+	nodeFlappingMinNodes = 2
+	// nodeFlappingMinFraction is the minimum fraction of the nodes of the
+	// load balancers leaving them at once for the change to be considered a
+	// cluster-wide flapping of the nodes.
+	nodeFlappingMinFraction = 0.2
+)
+
+// lbNodesStabilizationWindow is the period the nodes of the load balancers
+// are kept for when many of them leave at once, e.g. when a network blip
+// makes them NotReady. Disabled if 0.
+var lbNodesStabilizationWindow time.Duration
+
+func init() {
+	flag.DurationVar(&lbNodesStabilizationWindow, "cloud-provider-gce-lb-nodes-stabilization-window", 0, "Period the nodes of the L4 LBs are kept for when a large fraction of them is removed at once, so that the cluster-wide flapping of the node readiness does not reprogram every LB. Disabled if 0")
+}
+
+// lbNodeStabilizer suppresses the removal of the nodes from the load balancers
+// when many nodes leave at once. The load balancers of the Services are then
+// synced with the nodes they had before, plus the new ones, until the
+// stabilization window ends. The nodes which are still gone are removed at
+// the end of the window, the deleted nodes as soon as they are deleted.
+type lbNodeStabilizer struct {
+	lock sync.Mutex
+	// nodes are the nodes of the load balancers before the window started,
+	// or at the last sync outside of a window.
+	nodes map[string]*v1.Node
+	// until is the end of the stabilization window, zero outside of a
+	// window.
+	until time.Time
+}
+
+// stabilize returns the nodes the load balancers are synced with.
+func (s *lbNodeStabilizer) stabilize(nodes []*v1.Node, window time.Duration, now time.Time) []*v1.Node {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if window <= 0 {
+		s.nodes, s.until = nil, time.Time{}
+		return nodes
+	}
+	if !s.until.IsZero() {
+		if now.Before(s.until) {
+			return s.withStableNodes(nodes)
+		}
+		klog.Infof("The stabilization window of the load balancer nodes ended, syncing the load balancers with %d nodes", len(nodes))
+		s.until = time.Time{}
+		s.nodes = nodeMap(nodes)
+		return nodes
+	}
+
+	current := nodeMap(nodes)
+	removed := 0
+	for name := range s.nodes {
+		if _, ok := current[name]; !ok {
+			removed++
+		}
+	}
+	if removed >= nodeFlappingMinNodes && float64(removed) >= nodeFlappingMinFraction*float64(len(s.nodes)) {
+		s.until = now.Add(window)
+		klog.Warningf("%d of the %d load balancer nodes were removed at once, keeping them in the load balancers until %s", removed, len(s.nodes), s.until.Format(time.RFC3339))
+		return s.withStableNodes(nodes)
+	}
+	s.nodes = current
+	return nodes
+}
+
+// forget drops a deleted node, so that it is not kept in the load balancers
+// until the stabilization window ends.
+func (s *lbNodeStabilizer) forget(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.nodes, name)
+}
+
+// withStableNodes returns the nodes with the nodes the load balancers had
+// before the window started.
+func (s *lbNodeStabilizer) withStableNodes(nodes []*v1.Node) []*v1.Node {
+	current := nodeMap(nodes)
+	// The nodes of the caller are not modified.
+	nodes = nodes[:len(nodes):len(nodes)]
+	for name, node := range s.nodes {
+		if _, ok := current[name]; !ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func nodeMap(nodes []*v1.Node) map[string]*v1.Node {
+	m := make(map[string]*v1.Node, len(nodes))
+	for _, node := range nodes {
+		m[node.Name] = node
+	}
+	return m
+}
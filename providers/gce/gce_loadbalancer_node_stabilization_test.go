@@ -0,0 +1,121 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func testNodes(names ...string) []*v1.Node {
+	var nodes []*v1.Node
+	for _, name := range names {
+		nodes = append(nodes, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return nodes
+}
+
+func testNodeNames(nodes []*v1.Node) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLBNodeStabilizer(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	window := 5 * time.Minute
+	s := &lbNodeStabilizer{}
+	all := testNodes("n0", "n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9")
+
+	assert.Equal(t, testNodeNames(all), testNodeNames(s.stabilize(all, window, start)))
+	// A single node leaving is not a flapping.
+	assert.Equal(t, testNodeNames(all[1:]), testNodeNames(s.stabilize(all[1:], window, start)))
+	// Too few nodes leaving at once.
+	assert.Equal(t, testNodeNames(all[2:]), testNodeNames(s.stabilize(all[2:], window, start)))
+
+	// Half of the nodes leave, they are kept until the window ends.
+	flapping := append(testNodes("n10"), all[6:]...)
+	got := s.stabilize(flapping, window, start.Add(time.Second))
+	assert.Equal(t, []string{"n10", "n2", "n3", "n4", "n5", "n6", "n7", "n8", "n9"}, testNodeNames(got))
+	assert.Len(t, flapping, 5, "the nodes of the caller are not modified")
+	got = s.stabilize(all[2:], window, start.Add(time.Minute))
+	assert.Equal(t, testNodeNames(all[2:]), testNodeNames(got))
+	got = s.stabilize(all[8:], window, start.Add(2*time.Minute))
+	assert.Equal(t, testNodeNames(all[2:]), testNodeNames(got))
+	// The deleted nodes are not kept.
+	s.forget("n2")
+	got = s.stabilize(all[8:], window, start.Add(3*time.Minute))
+	assert.Equal(t, testNodeNames(all[3:]), testNodeNames(got))
+
+	// The nodes still gone at the end of the window are removed.
+	got = s.stabilize(all[8:], window, start.Add(window+time.Second))
+	assert.Equal(t, testNodeNames(all[8:]), testNodeNames(got))
+
+	// Disabled.
+	s = &lbNodeStabilizer{}
+	s.stabilize(all, 0, start)
+	assert.Equal(t, testNodeNames(all[8:]), testNodeNames(s.stabilize(all[8:], 0, start)))
+}
+
+func TestUpdateLoadBalancerStabilizesFlappingNodes(t *testing.T) {
+	previous := lbNodesStabilizationWindow
+	lbNodesStabilizationWindow = 5 * time.Minute
+	t.Cleanup(func() { lbNodesStabilizationWindow = previous })
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	clock := testingclock.NewFakePassiveClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	gce.clock = clock
+
+	nodeNames := []string{"test-node-1", "test-node-2", "test-node-3", "test-node-4"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	_, err = gce.EnsureLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	targetPoolInstances := func() int {
+		pool, err := gce.GetTargetPool(lbName, gce.region)
+		require.NoError(t, err)
+		return len(pool.Instances)
+	}
+	require.Equal(t, 4, targetPoolInstances())
+
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes[:1]))
+	assert.Equal(t, 4, targetPoolInstances(), "the flapping nodes are kept")
+
+	clock.SetTime(clock.Now().Add(lbNodesStabilizationWindow))
+	require.NoError(t, gce.UpdateLoadBalancer(context.TODO(), vals.ClusterName, svc, nodes[:1]))
+	assert.Equal(t, 1, targetPoolInstances(), "the nodes are removed once the window ends")
+}
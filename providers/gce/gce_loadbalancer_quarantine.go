@@ -0,0 +1,196 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// quarantinedAddressPrefix is the name prefix of the addresses keeping
+	// the IPs of the deleted load balancers during the quarantine period.
+	quarantinedAddressPrefix = "k8s-quarantined-"
+	// quarantineGCInterval is the interval of the removal of the addresses
+	// whose quarantine period ended.
+	quarantineGCInterval = time.Hour
+)
+
+// lbQuarantinePeriod is the period the IPs of the deleted load balancers are
+// kept for, so that a Service deleted by accident can be recreated with the
+// same IP. They are released immediately if 0.
+var lbQuarantinePeriod time.Duration
+
+func init() {
+	flag.DurationVar(&lbQuarantinePeriod, "cloud-provider-gce-lb-quarantine-period", 0, "Period the IPs of the deleted L4 LBs are kept for, as reserved addresses, before they are released. Disabled if 0")
+}
+
+// quarantinedAddressDescription is the description of a quarantined address.
+type quarantinedAddressDescription struct {
+	ServiceName string    `json:"kubernetes.io/service-name"`
+	ClusterID   string    `json:"kubernetes.io/cluster-id"`
+	Expiry      time.Time `json:"kubernetes.io/quarantine-expiry"`
+}
+
+func makeQuarantinedAddressName(loadBalancerName string) string {
+	return quarantinedAddressPrefix + loadBalancerName
+}
+
+// quarantineLoadBalancerIP reserves the IP of the load balancer of svc, before
+// it is deleted, as an address kept for lbQuarantinePeriod. The Service can be
+// recreated with the IP in spec.loadBalancerIP meanwhile. The other resources
+// of the load balancer are deleted, they are recreated from the Service.
+func (g *Cloud) quarantineLoadBalancerIP(loadBalancerName, clusterID string, svc *v1.Service) error {
+	if lbQuarantinePeriod <= 0 {
+		return nil
+	}
+	region := g.loadBalancerRegion(svc)
+	fwd, err := g.GetRegionForwardingRule(loadBalancerName, region)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The addresses reserved outside of Kubernetes are kept anyway.
+	existing, err := g.GetRegionAddressByIP(region, fwd.IPAddress)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if existing != nil && existing.Name != loadBalancerName {
+		klog.V(2).Infof("quarantineLoadBalancerIP(%s): IP %s is kept by address %s", loadBalancerName, fwd.IPAddress, existing.Name)
+		return nil
+	}
+	if existing != nil {
+		// The IP cannot be reserved twice, the address of the load balancer is
+		// kept instead of the quarantined one.
+		klog.Warningf("quarantineLoadBalancerIP(%s): IP %s is still reserved by the load balancer, it is not quarantined", loadBalancerName, fwd.IPAddress)
+		return nil
+	}
+
+	nm := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	expiry := time.Now().Add(lbQuarantinePeriod).UTC().Truncate(time.Second)
+	desc, err := json.Marshal(quarantinedAddressDescription{ServiceName: nm.String(), ClusterID: clusterID, Expiry: expiry})
+	if err != nil {
+		return err
+	}
+	addr := &compute.Address{
+		Name:        makeQuarantinedAddressName(loadBalancerName),
+		Description: string(desc),
+		Address:     fwd.IPAddress,
+	}
+	if fwd.LoadBalancingScheme == string(cloud.SchemeInternal) {
+		addr.AddressType = string(cloud.SchemeInternal)
+		addr.Subnetwork = fwd.Subnetwork
+	} else {
+		addr.NetworkTier = fwd.NetworkTier
+	}
+	if err := g.ReserveRegionAddress(addr, region); err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
+		return fmt.Errorf("failed to quarantine the IP %s of load balancer %s: %w", fwd.IPAddress, loadBalancerName, err)
+	}
+	klog.Infof("quarantineLoadBalancerIP(%s): Quarantined IP %s of Service %s as address %s until %s", loadBalancerName, fwd.IPAddress, nm, addr.Name, expiry.Format(time.RFC3339))
+	g.eventRecorder.Eventf(svc, v1.EventTypeNormal, "LoadBalancerIPQuarantined", "IP %s is kept as address %s until %s, recreate the Service with it in spec.loadBalancerIP to restore it", fwd.IPAddress, addr.Name, expiry.Format(time.RFC3339))
+	return nil
+}
+
+// gcQuarantinedAddresses releases the quarantined addresses of the cluster
+// once their quarantine period ended, unless their IP was restored by a load
+// balancer. The addresses of all the regions are listed, as the load
+// balancers pinned to another region are quarantined there.
+func (g *Cloud) gcQuarantinedAddresses(ctx context.Context) error {
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return err
+	}
+	mc := newAddressMetricContext("list", "")
+	all, err := g.c.Addresses().AggregatedList(ctx, filter.Regexp("name", quarantinedAddressPrefix+".*"))
+	mc.Observe(err)
+	if err != nil {
+		return err
+	}
+	var addrs []*compute.Address
+	for _, scoped := range all {
+		addrs = append(addrs, scoped...)
+	}
+
+	now := time.Now()
+	for _, addr := range addrs {
+		if !strings.HasPrefix(addr.Name, quarantinedAddressPrefix) {
+			continue
+		}
+		var desc quarantinedAddressDescription
+		if err := json.Unmarshal([]byte(addr.Description), &desc); err != nil || desc.ClusterID != clusterID {
+			continue
+		}
+		if now.Before(desc.Expiry) {
+			continue
+		}
+		if addr.Status == "IN_USE" {
+			klog.V(2).Infof("gcQuarantinedAddresses: Keeping address %s, its IP %s was restored", addr.Name, addr.Address)
+			continue
+		}
+		resource, err := cloud.ParseResourceURL(addr.SelfLink)
+		if err != nil || resource.Key.Region == "" {
+			klog.Warningf("gcQuarantinedAddresses: Ignoring address %s of unknown region %q", addr.Name, addr.SelfLink)
+			continue
+		}
+		if err := g.DeleteRegionAddress(addr.Name, resource.Key.Region); err != nil && !isNotFound(err) {
+			klog.Errorf("gcQuarantinedAddresses: Failed to release quarantined address %s of Service %s: %v", addr.Name, desc.ServiceName, err)
+			continue
+		}
+		klog.Infof("gcQuarantinedAddresses: Released quarantined address %s, IP %s, of Service %s", addr.Name, addr.Address, desc.ServiceName)
+	}
+	return nil
+}
+
+// runQuarantinedAddressesGC releases the addresses whose quarantine period
+// ended until stop is closed, once the cluster ID is initialized. It also runs
+// while the quarantine is disabled, so that the addresses quarantined before
+// are released.
+func (g *Cloud) runQuarantinedAddressesGC(stop <-chan struct{}) {
+	err := wait.PollUntilContextCancel(wait.ContextForChannel(stop), time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := g.ClusterID.GetID()
+		return err == nil, nil
+	})
+	if err != nil {
+		return
+	}
+	wait.Until(func() {
+		ctx, cancel := cloud.ContextWithCallTimeout()
+		defer cancel()
+		if err := g.gcQuarantinedAddresses(ctx); err != nil {
+			klog.Errorf("Failed to release the quarantined addresses: %v", err)
+		}
+	}, quarantineGCInterval, stop)
+}
@@ -0,0 +1,170 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func setLBQuarantinePeriod(t *testing.T, period time.Duration) {
+	previous := lbQuarantinePeriod
+	lbQuarantinePeriod = period
+	t.Cleanup(func() { lbQuarantinePeriod = previous })
+}
+
+func TestEnsureLoadBalancerDeletedQuarantinesIP(t *testing.T) {
+	setLBQuarantinePeriod(t, 24*time.Hour)
+	vals := DefaultTestClusterValues()
+
+	for name, lbType := range map[string]LoadBalancerType{"External": "", "Internal": LBTypeInternal} {
+		t.Run(name, func(t *testing.T) {
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			recorder := record.NewFakeRecorder(1024)
+			gce.eventRecorder = recorder
+
+			svc := fakeLoadbalancerService(string(lbType))
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			var ip string
+			if lbType == LBTypeInternal {
+				status, err := createInternalLoadBalancer(gce, svc, nil, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+				require.NoError(t, err)
+				ip = status.Ingress[0].IP
+			} else {
+				status, err := createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+				require.NoError(t, err)
+				ip = status.Ingress[0].IP
+			}
+			lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+			require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+			_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+			assert.True(t, isNotFound(err), "the forwarding rule is deleted")
+
+			addr, err := gce.GetRegionAddress(makeQuarantinedAddressName(lbName), gce.region)
+			require.NoError(t, err)
+			assert.Equal(t, ip, addr.Address)
+			if lbType == LBTypeInternal {
+				assert.Equal(t, string(cloud.SchemeInternal), addr.AddressType)
+				assert.Equal(t, gce.SubnetworkURL(), addr.Subnetwork)
+			}
+			var desc quarantinedAddressDescription
+			require.NoError(t, json.Unmarshal([]byte(addr.Description), &desc))
+			assert.Equal(t, svc.Namespace+"/"+svc.Name, desc.ServiceName)
+			assert.Equal(t, vals.ClusterID, desc.ClusterID)
+			assert.WithinDuration(t, time.Now().Add(24*time.Hour), desc.Expiry, time.Minute)
+			checkEvent(t, recorder, "Normal LoadBalancerIPQuarantined", true)
+
+			// The deletion is idempotent.
+			require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+		})
+	}
+}
+
+func TestEnsureLoadBalancerDeletedQuarantineDisabled(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	_, err = createExternalLoadBalancer(gce, svc, []string{"test-node-1"}, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.TODO(), vals.ClusterName, svc))
+	_, err = gce.GetRegionAddress(makeQuarantinedAddressName(lbName), gce.region)
+	assert.True(t, isNotFound(err), "the IP is not quarantined")
+}
+
+func TestGCQuarantinedAddresses(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	insert := func(name, clusterID string, expiry time.Time, status string) {
+		desc, err := json.Marshal(quarantinedAddressDescription{ServiceName: "ns/" + name, ClusterID: clusterID, Expiry: expiry})
+		require.NoError(t, err)
+		addr := &compute.Address{Name: name, Description: string(desc), Status: status}
+		require.NoError(t, gce.c.Addresses().Insert(context.TODO(), meta.RegionalKey(name, gce.region), addr))
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	insert(quarantinedAddressPrefix+"expired", vals.ClusterID, past, "RESERVED")
+	insert(quarantinedAddressPrefix+"not-expired", vals.ClusterID, future, "RESERVED")
+	insert(quarantinedAddressPrefix+"restored", vals.ClusterID, past, "IN_USE")
+	insert(quarantinedAddressPrefix+"other-cluster", "other-cluster-id", past, "RESERVED")
+	require.NoError(t, gce.c.Addresses().Insert(context.TODO(), meta.RegionalKey("user-address", gce.region), &compute.Address{Name: "user-address"}))
+	// The addresses of the load balancers pinned to another region.
+	desc, err := json.Marshal(quarantinedAddressDescription{ServiceName: "ns/pinned", ClusterID: vals.ClusterID, Expiry: past})
+	require.NoError(t, err)
+	require.NoError(t, gce.c.Addresses().Insert(context.TODO(), meta.RegionalKey(quarantinedAddressPrefix+"pinned", "us-west1"), &compute.Address{Name: quarantinedAddressPrefix + "pinned", Description: string(desc), Status: "RESERVED"}))
+
+	require.NoError(t, gce.gcQuarantinedAddresses(context.TODO()))
+
+	for name, kept := range map[string]bool{
+		quarantinedAddressPrefix + "expired":       false,
+		quarantinedAddressPrefix + "not-expired":   true,
+		quarantinedAddressPrefix + "restored":      true,
+		quarantinedAddressPrefix + "other-cluster": true,
+		"user-address": true,
+	} {
+		_, err := gce.GetRegionAddress(name, gce.region)
+		if kept {
+			assert.NoError(t, err, "address %s is kept", name)
+		} else {
+			assert.True(t, isNotFound(err), "address %s is released", name)
+		}
+	}
+	_, err = gce.GetRegionAddress(quarantinedAddressPrefix+"pinned", "us-west1")
+	assert.True(t, isNotFound(err), "the address of another region is released")
+}
+
+func TestQuarantineLoadBalancerIPPinnedRegion(t *testing.T) {
+	setLBQuarantinePeriod(t, 24*time.Hour)
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = "us-west1"
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	require.NoError(t, gce.c.ForwardingRules().Insert(context.TODO(), meta.RegionalKey(lbName, "us-west1"), &compute.ForwardingRule{Name: lbName, IPAddress: "1.2.3.4"}))
+
+	require.NoError(t, gce.quarantineLoadBalancerIP(lbName, vals.ClusterID, svc))
+	addr, err := gce.GetRegionAddress(makeQuarantinedAddressName(lbName), "us-west1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", addr.Address)
+	_, err = gce.GetRegionAddress(makeQuarantinedAddressName(lbName), gce.region)
+	assert.True(t, isNotFound(err), "the IP is quarantined in the region of the load balancer")
+}
@@ -0,0 +1,143 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// eventReasonReconcilePaused is the reason of the events reporting a
+	// load balancer operation skipped because of ServiceAnnotationReconcile.
+	eventReasonReconcilePaused = "ReconcilePaused"
+	// eventReasonLoadBalancerDrift is the reason of the events reporting the
+	// differences between a paused Service and its load balancer.
+	eventReasonLoadBalancerDrift = "LoadBalancerDrift"
+)
+
+// ensurePausedLoadBalancer reports the drift of a Service whose reconciliation
+// is paused, and returns the status of its existing load balancer without
+// changing it. The status of a load balancer which does not exist is not
+// cleared, the caller retries until the reconciliation is resumed.
+func (g *Cloud) ensurePausedLoadBalancer(ctx context.Context, clusterName string, svc *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	g.reportPausedReconcile(ctx, "EnsureLoadBalancer", clusterName, svc, nodes)
+	status, _, err := g.GetLoadBalancer(ctx, clusterName, svc)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil || len(status.Ingress) == 0 {
+		return nil, fmt.Errorf("reconcile of service %s/%s is paused by annotation %s=%s and its load balancer does not exist", svc.Namespace, svc.Name, ServiceAnnotationReconcile, ReconcilePaused)
+	}
+	return status, nil
+}
+
+// reportPausedReconcile records an event for an operation skipped on a paused
+// Service, and an event listing the drift of its load balancer if any.
+func (g *Cloud) reportPausedReconcile(ctx context.Context, operation, clusterName string, svc *v1.Service, nodes []*v1.Node) {
+	klog.Infof("%s(%s, %s, %s): skipped, reconcile is paused by annotation %s=%s", operation, clusterName, svc.Namespace, svc.Name, ServiceAnnotationReconcile, ReconcilePaused)
+	g.eventRecorder.Eventf(svc, v1.EventTypeNormal, eventReasonReconcilePaused, "Skipped %s, reconcile is paused by annotation %s=%s", operation, ServiceAnnotationReconcile, ReconcilePaused)
+
+	drift, err := g.loadBalancerDrift(ctx, clusterName, svc, nodes)
+	if err != nil {
+		klog.Warningf("%s(%s, %s, %s): failed to check the load balancer drift: %v", operation, clusterName, svc.Namespace, svc.Name, err)
+		return
+	}
+	if len(drift) > 0 {
+		g.eventRecorder.Eventf(svc, v1.EventTypeWarning, eventReasonLoadBalancerDrift, "Load balancer differs from the Service while reconcile is paused: %s", strings.Join(drift, "; "))
+	}
+}
+
+// loadBalancerDrift returns the differences between the load balancer of the
+// Service and the one the controllers would reconcile, without changing it,
+// starting with the changes of the resources recorded in
+// ServiceAnnotationLoadBalancerResources. The target pool membership is only
+// checked when nodes are given.
+func (g *Cloud) loadBalancerDrift(ctx context.Context, clusterName string, svc *v1.Service, nodes []*v1.Node) ([]string, error) {
+	loadBalancerName := g.GetLoadBalancerName(ctx, clusterName, svc)
+	clusterID, err := g.ClusterID.GetID()
+	if err != nil {
+		return nil, err
+	}
+	drift, err := g.checkpointedResourcesDrift(svc, loadBalancerName, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	fwd, err := g.GetRegionForwardingRule(loadBalancerName, g.loadBalancerRegion(svc))
+	if isNotFound(err) {
+		return appendDrift(drift, fmt.Sprintf("forwarding rule %s does not exist", loadBalancerName)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	desiredScheme := getSvcScheme(svc)
+	if existingScheme := cloud.LbScheme(strings.ToUpper(fwd.LoadBalancingScheme)); existingScheme != "" && existingScheme != desiredScheme {
+		drift = append(drift, fmt.Sprintf("forwarding rule %s has scheme %s instead of %s", loadBalancerName, existingScheme, desiredScheme))
+		return drift, nil
+	}
+	if desiredScheme == cloud.SchemeInternal || nodes == nil {
+		return drift, nil
+	}
+
+	pool, err := g.GetTargetPool(loadBalancerName, g.region)
+	if isNotFound(err) {
+		return appendDrift(drift, fmt.Sprintf("target pool %s does not exist", loadBalancerName)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := g.getInstancesByNames(nodeNames(nodes))
+	if err != nil {
+		return nil, err
+	}
+	existing := sets.NewString()
+	for _, instance := range pool.Instances {
+		existing.Insert(hostURLToComparablePath(instance))
+	}
+	desired := sets.NewString()
+	for _, host := range g.targetPoolHosts(loadBalancerName, hosts) {
+		desired.Insert(host.makeComparableHostPath())
+	}
+	if missing := desired.Difference(existing); missing.Len() > 0 {
+		drift = append(drift, fmt.Sprintf("target pool %s is missing %d instances", loadBalancerName, missing.Len()))
+	}
+	if extra := existing.Difference(desired); extra.Len() > 0 {
+		drift = append(drift, fmt.Sprintf("target pool %s has %d unexpected instances", loadBalancerName, extra.Len()))
+	}
+	return drift, nil
+}
+
+// appendDrift appends an entry to the drift unless the checkpointed resources
+// already reported it.
+func appendDrift(drift []string, entry string) []string {
+	for _, existing := range drift {
+		if existing == entry {
+			return drift
+		}
+	}
+	return append(drift, entry)
+}
@@ -0,0 +1,165 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// loadBalancerRegion returns the region of the load balancer of svc: the
+// region of ServiceAnnotationLoadBalancerRegion, or else the region of the
+// cluster.
+func (g *Cloud) loadBalancerRegion(svc *v1.Service) string {
+	if svc != nil {
+		if region := GetLoadBalancerAnnotationRegion(svc); region != "" {
+			return region
+		}
+	}
+	return g.region
+}
+
+// validateLoadBalancerRegion returns an error if the load balancer of svc
+// cannot be in the region of ServiceAnnotationLoadBalancerRegion: only the
+// internal load balancers are pinned to another region than the one of the
+// cluster, and the region must have nodes, which are the backends of the load
+// balancer.
+func (g *Cloud) validateLoadBalancerRegion(svc *v1.Service, scheme cloud.LbScheme, nodes []*v1.Node) error {
+	region := GetLoadBalancerAnnotationRegion(svc)
+	if region == "" {
+		return nil
+	}
+	if region != g.region && scheme != cloud.SchemeInternal {
+		return fmt.Errorf("annotation %s=%s is only supported by the internal load balancers, the external load balancers are in region %s", ServiceAnnotationLoadBalancerRegion, region, g.region)
+	}
+	for _, node := range nodes {
+		if getZone(node) != emptyZone && nodeOutsideRegion(node, region) == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("annotation %s=%s: no node in region %s", ServiceAnnotationLoadBalancerRegion, region, region)
+}
+
+// previousLoadBalancerRegion returns the region the load balancer of svc was
+// in before ServiceAnnotationLoadBalancerRegion changed, the one of its
+// resources checkpoint or else the region of the cluster, or "" if it did not
+// change. The checkpoint of the previous region, if any, is returned too.
+func (g *Cloud) previousLoadBalancerRegion(svc *v1.Service) (string, *LoadBalancerResourcesCheckpoint) {
+	previous := g.region
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	if err != nil {
+		klog.Warningf("Ignoring the invalid resources checkpoint of Service %s/%s: %v", svc.Namespace, svc.Name, err)
+		checkpoint = nil
+	}
+	if checkpoint != nil {
+		previous = checkpoint.Region
+	}
+	if previous == g.loadBalancerRegion(svc) {
+		return "", nil
+	}
+	return previous, checkpoint
+}
+
+// ensureLoadBalancerDeletedInPreviousRegion deletes the load balancer of svc
+// in its previous region, if any, see previousLoadBalancerRegion. It returns
+// true if it was deleted. The region of the checkpoint is written by the
+// users too, the load balancer is only deleted if its forwarding rule is the
+// one of svc. The resources of the checkpoint are deleted too, whether the
+// forwarding rule still exists or not, e.g. once a previous deletion failed
+// after deleting it, and whatever the annotations their names depend on are
+// now.
+func (g *Cloud) ensureLoadBalancerDeletedInPreviousRegion(clusterName, clusterID string, svc *v1.Service, loadBalancerName string) (bool, error) {
+	region, checkpoint := g.previousLoadBalancerRegion(svc)
+	if region == "" {
+		return false, nil
+	}
+	fwdRule, err := g.GetRegionForwardingRule(loadBalancerName, region)
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+	switch {
+	case fwdRule != nil:
+		nm := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+		if serviceName := forwardingRuleServiceName(fwdRule); serviceName != nm.String() {
+			klog.Warningf("ensureLoadBalancerDeletedInPreviousRegion(%v): not deleting the load balancer of region %s, its forwarding rule is the one of service %q", loadBalancerName, region, serviceName)
+			return false, nil
+		}
+		klog.V(2).Infof("ensureLoadBalancerDeletedInPreviousRegion(%v): deleting the load balancer of region %s, the Service is in region %s", loadBalancerName, region, g.loadBalancerRegion(svc))
+		// The external load balancers are only in the region of the
+		// cluster, e.g. before the Service was changed to an internal one.
+		if cloud.LbScheme(strings.ToUpper(fwdRule.LoadBalancingScheme)) == cloud.SchemeExternal && region == g.region {
+			err = g.ensureExternalLoadBalancerDeleted(clusterName, clusterID, svc)
+		} else {
+			err = g.ensureInternalLoadBalancerDeletedInRegion(clusterName, clusterID, svc, region)
+		}
+		if err != nil {
+			return false, err
+		}
+	case checkpoint == nil:
+		return false, nil
+	default:
+		klog.V(2).Infof("ensureLoadBalancerDeletedInPreviousRegion(%v): deleting the resources left in region %s, its forwarding rule is already deleted", loadBalancerName, region)
+		// Only the internal load balancers are in another region than
+		// the one of the cluster.
+		if region != g.region {
+			if err := g.ensureInternalLoadBalancerDeletedInRegion(clusterName, clusterID, svc, region); err != nil {
+				return false, err
+			}
+		}
+	}
+	if checkpoint != nil {
+		if err := g.deleteCheckpointedResources(svc, loadBalancerName, clusterID, checkpoint); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkpointLoadBalancerRegion records the region of the load balancer of svc
+// in ServiceAnnotationLoadBalancerResources before the load balancer is
+// ensured in a region the checkpoint does not record, once the load balancer
+// of the previous region is deleted: the load balancer of a region which is
+// not recorded would be leaked once the region changes again. Unlike the
+// other failures of the checkpoint, the failures fail the sync.
+func (g *Cloud) checkpointLoadBalancerRegion(svc *v1.Service) error {
+	if region, _ := g.previousLoadBalancerRegion(svc); region == "" {
+		return nil
+	}
+	b, err := json.Marshal(&LoadBalancerResourcesCheckpoint{Region: g.loadBalancerRegion(svc), Resources: []CheckpointedLoadBalancerResource{}})
+	if err != nil {
+		return err
+	}
+	updated := svc.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[ServiceAnnotationLoadBalancerResources] = string(b)
+	if _, err := servicehelper.PatchService(g.client.CoreV1(), svc, updated); err != nil {
+		return fmt.Errorf("failed to record the region of the load balancer of Service %s/%s in the %s annotation: %w", svc.Namespace, svc.Name, ServiceAnnotationLoadBalancerResources, err)
+	}
+	return nil
+}
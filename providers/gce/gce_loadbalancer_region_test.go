@@ -0,0 +1,367 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	testPinnedRegion = "europe-west1"
+	testPinnedZone   = "europe-west1-b"
+)
+
+// createAndInsertPinnedRegionNodes inserts the nodes of the zones of the
+// region of the cluster and of testPinnedRegion. The instances of the other
+// regions are found by the providerID of their node.
+func createAndInsertPinnedRegionNodes(t *testing.T, gce *Cloud, vals TestClusterValues) []*v1.Node {
+	nodes, err := createAndInsertNodes(gce, []string{"local-node"}, vals.ZoneName)
+	require.NoError(t, err)
+	pinnedNodes, err := createAndInsertNodes(gce, []string{"pinned-node"}, testPinnedZone)
+	require.NoError(t, err)
+	for _, node := range pinnedNodes {
+		node.Spec.ProviderID = fmt.Sprintf("gce://%s/%s/%s", vals.ProjectID, testPinnedZone, node.Name)
+		gce.nodeInstances.update(nil, node)
+	}
+	return append(nodes, pinnedNodes...)
+}
+
+func TestValidateLoadBalancerRegion(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+	for desc, tc := range map[string]struct {
+		region  string
+		scheme  cloud.LbScheme
+		nodes   []*v1.Node
+		wantErr bool
+	}{
+		"no region": {
+			scheme: cloud.SchemeExternal,
+		},
+		"internal in another region": {
+			region: testPinnedRegion,
+			scheme: cloud.SchemeInternal,
+			nodes:  nodes,
+		},
+		"external in the region of the cluster": {
+			region: vals.Region,
+			scheme: cloud.SchemeExternal,
+			nodes:  nodes,
+		},
+		"external in another region": {
+			region:  testPinnedRegion,
+			scheme:  cloud.SchemeExternal,
+			nodes:   nodes,
+			wantErr: true,
+		},
+		"no node in the region": {
+			region:  testPinnedRegion,
+			scheme:  cloud.SchemeInternal,
+			nodes:   nodes[:1],
+			wantErr: true,
+		},
+		"unknown region": {
+			region:  "europe-west",
+			scheme:  cloud.SchemeInternal,
+			nodes:   nodes,
+			wantErr: true,
+		},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			svc := fakeLoadbalancerService("")
+			if tc.region != "" {
+				svc.Annotations[ServiceAnnotationLoadBalancerRegion] = tc.region
+			}
+			err := gce.validateLoadBalancerRegion(svc, tc.scheme, tc.nodes)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEnsureInternalLoadBalancerPinnedRegion(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+	nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = testPinnedRegion
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The subnetwork of the cluster is in another region.
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ServiceAnnotationILBSubnet)
+
+	svc.Annotations[ServiceAnnotationILBSubnet] = "pinned-subnet"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Update(context.TODO(), svc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.NotEmpty(t, status.Ingress)
+
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+	require.NoError(t, err)
+	assert.Equal(t, gceSubnetworkURL("", vals.ProjectID, testPinnedRegion, "pinned-subnet"), fwdRule.Subnetwork)
+	_, err = gce.GetRegionForwardingRule(lbName, vals.Region)
+	assert.True(t, isNotFound(err), "forwarding rule in the region of the cluster: %v", err)
+	bs, err := gce.GetRegionBackendService(getNameFromLink(fwdRule.BackendService), testPinnedRegion)
+	require.NoError(t, err)
+	require.Len(t, bs.Backends, 1, "only the nodes of the region are backends")
+	assert.Contains(t, bs.Backends[0].Group, "/zones/"+testPinnedZone+"/")
+	_, exists, err := gce.GetLoadBalancer(context.Background(), vals.ClusterName, svc)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The load balancer is recreated in the region of the cluster once the
+	// annotation is removed.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, testPinnedRegion, checkpoint.Region)
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerRegion)
+	delete(svc.Annotations, ServiceAnnotationILBSubnet)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+	assert.True(t, isNotFound(err), "forwarding rule in the previous region: %v", err)
+	_, err = gce.GetRegionBackendService(bs.Name, testPinnedRegion)
+	assert.True(t, isNotFound(err), "backend service in the previous region: %v", err)
+	_, err = gce.GetRegionForwardingRule(lbName, vals.Region)
+	assert.NoError(t, err)
+	close(recorder.Events)
+	found := false
+	for event := range recorder.Events {
+		if strings.Contains(event, "LoadBalancerRegionChanged") {
+			found = true
+		}
+	}
+	assert.True(t, found, "no LoadBalancerRegionChanged event")
+}
+
+func TestEnsureLoadBalancerDeletedPinnedRegion(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = testPinnedRegion
+	svc.Annotations[ServiceAnnotationILBSubnet] = "pinned-subnet"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// The annotation changes before the Service is deleted, the load
+	// balancer of the previous region is deleted too.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = "asia-east1"
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	_, err = gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+	assert.True(t, isNotFound(err), "forwarding rule in the previous region: %v", err)
+	_, err = gce.GetRegionBackendService(lbName, testPinnedRegion)
+	assert.True(t, isNotFound(err), "backend service in the previous region: %v", err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, hasFinalizer(svc, ILBFinalizerV1))
+}
+
+func TestLoadBalancerPinnedRegionChangedToExternal(t *testing.T) {
+	t.Parallel()
+
+	for desc, deleted := range map[string]bool{
+		"ensured": false,
+		"deleted": true,
+	} {
+		t.Run(desc, func(t *testing.T) {
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+			svc := fakeLoadbalancerService(string(LBTypeInternal))
+			svc.Annotations[ServiceAnnotationLoadBalancerRegion] = testPinnedRegion
+			svc.Annotations[ServiceAnnotationILBSubnet] = "pinned-subnet"
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+			_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+			require.NoError(t, err)
+
+			// The Service is changed to an external one in the region of
+			// the cluster in the same edit.
+			svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			delete(svc.Annotations, ServiceAnnotationLoadBalancerType)
+			delete(svc.Annotations, ServiceAnnotationLoadBalancerRegion)
+			delete(svc.Annotations, ServiceAnnotationILBSubnet)
+			if deleted {
+				require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+			} else {
+				_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+				require.NoError(t, err)
+				_, err = gce.GetRegionForwardingRule(lbName, vals.Region)
+				assert.NoError(t, err)
+			}
+			_, err = gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+			assert.True(t, isNotFound(err), "forwarding rule in the previous region: %v", err)
+			_, err = gce.GetRegionBackendService(lbName, testPinnedRegion)
+			assert.True(t, isNotFound(err), "backend service in the previous region: %v", err)
+		})
+	}
+}
+
+func TestEnsureLoadBalancerDeletedPreviousRegionOtherService(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{
+		Name:                lbName,
+		LoadBalancingScheme: string(cloud.SchemeInternal),
+		Description:         makeServiceDescription("other/service"),
+	}, testPinnedRegion))
+
+	// The checkpoint written by a user points at the region of the
+	// forwarding rule of another Service, which is kept.
+	b, err := json.Marshal(&LoadBalancerResourcesCheckpoint{Region: testPinnedRegion})
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerResources] = string(b)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	_, err = gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+	assert.NoError(t, err)
+}
+
+func TestEnsureLoadBalancerPreviousRegionForwardingRuleDeleted(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = testPinnedRegion
+	svc.Annotations[ServiceAnnotationILBSubnet] = "pinned-subnet"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// A previous deletion of the load balancer of the previous region
+	// failed once its forwarding rule was deleted.
+	require.NoError(t, gce.DeleteRegionForwardingRule(lbName, testPinnedRegion))
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerRegion)
+	delete(svc.Annotations, ServiceAnnotationILBSubnet)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetRegionBackendService(lbName, testPinnedRegion)
+	assert.True(t, isNotFound(err), "backend service in the previous region: %v", err)
+
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, vals.Region, checkpoint.Region)
+}
+
+func TestEnsureLoadBalancerPreviousRegionCheckpointedNames(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes := createAndInsertPinnedRegionNodes(t, gce, vals)
+
+	svc := fakeLoadbalancerService(string(LBTypeInternal))
+	svc.Annotations[ServiceAnnotationLoadBalancerRegion] = testPinnedRegion
+	svc.Annotations[ServiceAnnotationILBSubnet] = "pinned-subnet"
+	svc.Annotations[ServiceAnnotationILBBackendShare] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, testPinnedRegion)
+	require.NoError(t, err)
+	bsName := getNameFromLink(fwdRule.BackendService)
+	require.NotEqual(t, lbName, bsName)
+
+	// The backend service is no longer shared in the edit changing the
+	// region, the shared one of the previous region is found in the
+	// checkpoint.
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Contains(t, checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceBackendServices, Name: bsName})
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerRegion)
+	delete(svc.Annotations, ServiceAnnotationILBSubnet)
+	delete(svc.Annotations, ServiceAnnotationILBBackendShare)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetRegionBackendService(bsName, testPinnedRegion)
+	assert.True(t, isNotFound(err), "shared backend service in the previous region: %v", err)
+}
@@ -0,0 +1,98 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reservedIPDescription is the description of the static IPs reserved for the
+// load balancers of the Services annotated with
+// ServiceAnnotationLoadBalancerReserveIP, recording their owner.
+type reservedIPDescription struct {
+	ServiceName string `json:"kubernetes.io/service-name"`
+	ClusterID   string `json:"kubernetes.io/cluster-id"`
+	ReservedIP  bool   `json:"kubernetes.io/reserved-ip"`
+}
+
+func makeReservedIPDescription(serviceName, clusterID string) string {
+	b, _ := json.Marshal(&reservedIPDescription{ServiceName: serviceName, ClusterID: clusterID, ReservedIP: true})
+	return string(b)
+}
+
+// reservedIPOwner returns the owner of addr if it is a reserved IP, nil
+// otherwise.
+func reservedIPOwner(addr *compute.Address) *reservedIPDescription {
+	var d reservedIPDescription
+	if err := json.Unmarshal([]byte(addr.Description), &d); err != nil || !d.ReservedIP {
+		return nil
+	}
+	return &d
+}
+
+// ensureReservedIP reserves the static IP name for the load balancer of the
+// Service nm, promoting existingIP if set, and returns its IP. An existing
+// address must be owned by the Service: reserved for it in this cluster, or
+// left by a failed update of its load balancer.
+func (g *Cloud) ensureReservedIP(name string, nm types.NamespacedName, clusterID, existingIP string, netTier cloud.NetworkTier) (string, error) {
+	ipAddr, _, err := ensureStaticIPWithDescription(g, name, makeReservedIPDescription(nm.String(), clusterID), g.region, existingIP, netTier)
+	if err != nil {
+		return "", err
+	}
+	addr, err := g.GetRegionAddressByIP(g.region, ipAddr)
+	if err != nil {
+		return "", err
+	}
+	if addr.Name != name {
+		return "", fmt.Errorf("IP %s is reserved as address %s, which is not released with the load balancer", ipAddr, addr.Name)
+	}
+	if !ownsStaticIP(addr, nm, clusterID) {
+		return "", fmt.Errorf("address %s is not owned by Service %s: %q", name, nm, addr.Description)
+	}
+	return ipAddr, nil
+}
+
+// staticIPHeldByForwardingRule returns true if the static IP name, which
+// already existed, is owned by the Service nm and holds the IP of its
+// forwarding rule, which then keeps the IP once the address is released.
+func (g *Cloud) staticIPHeldByForwardingRule(name string, nm types.NamespacedName, clusterID, fwdRuleIP string) bool {
+	if fwdRuleIP == "" {
+		return false
+	}
+	addr, err := g.GetRegionAddress(name, g.region)
+	if err != nil {
+		return false
+	}
+	return addr.Address == fwdRuleIP && ownsStaticIP(addr, nm, clusterID)
+}
+
+// ownsStaticIP returns true if addr was reserved for the load balancer of the
+// Service nm, for its lifetime or while it was updated.
+func ownsStaticIP(addr *compute.Address, nm types.NamespacedName, clusterID string) bool {
+	if owner := reservedIPOwner(addr); owner != nil {
+		return owner.ServiceName == nm.String() && owner.ClusterID == clusterID
+	}
+	return addr.Description == makeServiceDescription(nm.String())
+}
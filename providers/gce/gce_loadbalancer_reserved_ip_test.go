@@ -0,0 +1,132 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEnsureExternalLoadBalancerReservedIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerReserveIP] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	nm := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+
+	// The IP is reserved for the Service across the updates.
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	ip := status.Ingress[0].IP
+	addr, err := gce.GetRegionAddress(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Equal(t, ip, addr.Address)
+	assert.Equal(t, &reservedIPDescription{ServiceName: nm.String(), ClusterID: vals.ClusterID, ReservedIP: true}, reservedIPOwner(addr))
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ip, status.Ingress[0].IP)
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.NoError(t, err)
+
+	// The IP is demoted to the ephemeral IP of the forwarding rule once the
+	// reservation is no longer requested.
+	delete(svc.Annotations, ServiceAnnotationLoadBalancerReserveIP)
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ip, status.Ingress[0].IP)
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "address not released: %v", err)
+
+	// The IP is released with the load balancer.
+	svc.Annotations[ServiceAnnotationLoadBalancerReserveIP] = "true"
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "address not released: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerReservedIPOwnership(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc.Annotations[ServiceAnnotationLoadBalancerReserveIP] = "true"
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	// The address of the load balancer was reserved in another cluster.
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{
+		Name:        lbName,
+		Description: makeReservedIPDescription(svc.Namespace+"/"+svc.Name, "other-cluster"),
+		NetworkTier: cloud.NetworkTierDefault.ToGCEValue(),
+	}, gce.region))
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.ErrorContains(t, err, "not owned by Service")
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err), "forwarding rule created: %v", err)
+}
+
+func TestEnsureExternalLoadBalancerReleasesLeftoverIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+
+	// A failed update left the static IP of the forwarding rule reserved.
+	require.NoError(t, gce.ReserveRegionAddress(&compute.Address{
+		Name:        lbName,
+		Address:     status.Ingress[0].IP,
+		Description: makeServiceDescription(svc.Namespace + "/" + svc.Name),
+	}, gce.region))
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	_, err = gce.GetRegionAddress(lbName, gce.region)
+	assert.True(t, isNotFound(err), "leftover address not released: %v", err)
+}
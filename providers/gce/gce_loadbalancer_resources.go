@@ -0,0 +1,384 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+)
+
+// lbResourceKinds are the kinds of the resources recorded in
+// ServiceAnnotationLoadBalancerResources, in the order of their deletion: a
+// resource is deleted before the resources it references.
+var lbResourceKinds = []LoadBalancerResource{
+	LoadBalancerResourceServiceAttachments,
+	LoadBalancerResourceForwardingRules,
+	LoadBalancerResourceTargetPools,
+	LoadBalancerResourceBackendServices,
+	LoadBalancerResourceHealthChecks,
+	LoadBalancerResourceHTTPHealthChecks,
+	LoadBalancerResourceFirewalls,
+}
+
+var lbResourceKindDescriptions = map[LoadBalancerResource]string{
+	LoadBalancerResourceServiceAttachments: "service attachment",
+	LoadBalancerResourceForwardingRules:    "forwarding rule",
+	LoadBalancerResourceTargetPools:        "target pool",
+	LoadBalancerResourceBackendServices:    "backend service",
+	LoadBalancerResourceHealthChecks:       "health check",
+	LoadBalancerResourceHTTPHealthChecks:   "HTTP health check",
+	LoadBalancerResourceFirewalls:          "firewall",
+}
+
+// makeLoadBalancerResourceNames returns the names, by kind, of the resources
+// owned by the load balancer loadBalancerName alone. The instance groups and
+// the consolidated firewalls are shared with the other load balancers, and
+// the addresses follow their own retention, so they are not recorded. The
+// shared health checks and backend services are only recorded while the load
+// balancer references them, see referencedLoadBalancerResources.
+func makeLoadBalancerResourceNames(loadBalancerName, clusterID string) map[LoadBalancerResource][]string {
+	fwdRuleNames := []string{loadBalancerName, makeIPv6ResourceName(loadBalancerName)}
+	for _, protocol := range externalLoadBalancerProtocols {
+		fwdRuleNames = append(fwdRuleNames, protocolForwardingRuleName(loadBalancerName, protocol))
+	}
+	hcFirewallName := makeHealthCheckFirewallName(loadBalancerName, clusterID, false)
+	return map[LoadBalancerResource][]string{
+		LoadBalancerResourceServiceAttachments: {loadBalancerName},
+		LoadBalancerResourceForwardingRules:    fwdRuleNames,
+		LoadBalancerResourceTargetPools:        {loadBalancerName},
+		LoadBalancerResourceBackendServices:    {loadBalancerName},
+		LoadBalancerResourceHealthChecks:       {makeHealthCheckName(loadBalancerName, clusterID, false)},
+		LoadBalancerResourceHTTPHealthChecks:   {loadBalancerName},
+		LoadBalancerResourceFirewalls: {
+			MakeFirewallName(loadBalancerName),
+			MakeFirewallName(makeIPv6ResourceName(loadBalancerName)),
+			hcFirewallName,
+			makeIPv6ResourceName(hcFirewallName),
+			MakeHealthCheckFirewallName(clusterID, loadBalancerName, false),
+		},
+	}
+}
+
+// getLoadBalancerResource returns the fingerprint of a resource of a load
+// balancer of region, if its kind has one, and whether it exists.
+func (g *Cloud) getLoadBalancerResource(kind LoadBalancerResource, name, region string) (string, bool, error) {
+	var err error
+	switch kind {
+	case LoadBalancerResourceServiceAttachments:
+		var attachment *compute.ServiceAttachment
+		if attachment, err = g.GetServiceAttachment(name, region); err == nil {
+			return attachment.Fingerprint, true, nil
+		}
+	case LoadBalancerResourceForwardingRules:
+		var fwdRule *compute.ForwardingRule
+		if fwdRule, err = g.GetRegionForwardingRule(name, region); err == nil {
+			return fwdRule.Fingerprint, true, nil
+		}
+	case LoadBalancerResourceTargetPools:
+		_, err = g.GetTargetPool(name, region)
+	case LoadBalancerResourceBackendServices:
+		var bs *compute.BackendService
+		if bs, err = g.GetRegionBackendService(name, region); err == nil {
+			return bs.Fingerprint, true, nil
+		}
+	case LoadBalancerResourceHealthChecks:
+		_, err = g.GetHealthCheck(name)
+	case LoadBalancerResourceHTTPHealthChecks:
+		_, err = g.GetHTTPHealthCheck(name)
+	case LoadBalancerResourceFirewalls:
+		_, err = g.GetFirewall(name)
+	default:
+		return "", false, fmt.Errorf("unknown load balancer resource kind %q", kind)
+	}
+	if err != nil {
+		return "", false, ignoreNotFound(err)
+	}
+	return "", true, nil
+}
+
+// deleteLoadBalancerResource deletes a resource of the load balancer of svc
+// in region if it exists.
+func (g *Cloud) deleteLoadBalancerResource(svc *v1.Service, loadBalancerName, region string, kind LoadBalancerResource, name string) error {
+	switch kind {
+	case LoadBalancerResourceServiceAttachments:
+		return ignoreNotFound(g.DeleteServiceAttachment(name, region))
+	case LoadBalancerResourceForwardingRules:
+		return ignoreNotFound(g.DeleteRegionForwardingRule(name, region))
+	case LoadBalancerResourceTargetPools:
+		return ignoreNotFound(g.DeleteTargetPool(name, region))
+	case LoadBalancerResourceBackendServices:
+		return ignoreNotFound(g.DeleteRegionBackendService(name, region))
+	case LoadBalancerResourceHealthChecks:
+		return ignoreNotFound(g.DeleteHealthCheck(name))
+	case LoadBalancerResourceHTTPHealthChecks:
+		return ignoreNotFound(g.DeleteHTTPHealthCheck(name))
+	case LoadBalancerResourceFirewalls:
+		return g.deleteInternalFirewall(svc, loadBalancerName, name)
+	}
+	return fmt.Errorf("unknown load balancer resource kind %q", kind)
+}
+
+// ownedCheckpointedResources returns the resources of the checkpoint owned by
+// the load balancer loadBalancerName: the ones named after it, and the backend
+// services and health checks shared by the load balancers of the cluster,
+// which are only deleted once no load balancer uses them. The checkpoint is an
+// annotation which the users of the Service can edit, so the resources of the
+// other load balancers it may name are ignored: they are neither recorded
+// again, inspected nor deleted.
+func ownedCheckpointedResources(checkpoint *LoadBalancerResourcesCheckpoint, loadBalancerName, clusterID string) []CheckpointedLoadBalancerResource {
+	var owned []CheckpointedLoadBalancerResource
+	for _, resource := range checkpoint.Resources {
+		if !strings.Contains(resource.Name, loadBalancerName) && !isSharedLoadBalancerResource(resource, clusterID) {
+			klog.Warningf("Ignoring %s %s of the resources checkpoint of load balancer %s, it is not named after the load balancer", resource.Kind, resource.Name, loadBalancerName)
+			continue
+		}
+		owned = append(owned, resource)
+	}
+	return owned
+}
+
+// isSharedLoadBalancerResource returns true if resource is a backend service
+// or a health check shared by the load balancers of the cluster, see
+// makeBackendServiceName and makeHealthCheckName.
+func isSharedLoadBalancerResource(resource CheckpointedLoadBalancerResource, clusterID string) bool {
+	switch resource.Kind {
+	case LoadBalancerResourceBackendServices, LoadBalancerResourceHealthChecks:
+		return strings.HasPrefix(resource.Name, fmt.Sprintf("k8s-%s-", clusterID))
+	}
+	return false
+}
+
+// referencedLoadBalancerResources returns the names, by kind, of the backend
+// service the forwarding rule of the load balancer references and of its
+// health checks, whatever their names depend on, e.g. the annotations of the
+// Service sharing them.
+func (g *Cloud) referencedLoadBalancerResources(loadBalancerName, region string) (map[LoadBalancerResource][]string, error) {
+	fwdRule, err := g.GetRegionForwardingRule(loadBalancerName, region)
+	if err != nil || fwdRule.BackendService == "" {
+		return nil, ignoreNotFound(err)
+	}
+	bs, err := g.GetRegionBackendService(getNameFromLink(fwdRule.BackendService), region)
+	if err != nil {
+		return nil, ignoreNotFound(err)
+	}
+	names := map[LoadBalancerResource][]string{LoadBalancerResourceBackendServices: {bs.Name}}
+	for _, link := range bs.HealthChecks {
+		names[LoadBalancerResourceHealthChecks] = append(names[LoadBalancerResourceHealthChecks], getNameFromLink(link))
+	}
+	return names, nil
+}
+
+// loadBalancerResourcesHash returns the hash of the inputs of the resources
+// of the load balancer of svc: the Service, but for its resources
+// checkpoint, and the region and the cluster ID.
+func loadBalancerResourcesHash(svc *v1.Service, region, clusterID string) (string, error) {
+	annotations := map[string]string{}
+	for k, v := range svc.Annotations {
+		if k != ServiceAnnotationLoadBalancerResources {
+			annotations[k] = v
+		}
+	}
+	b, err := json.Marshal(struct {
+		Spec        v1.ServiceSpec
+		Annotations map[string]string
+		Region      string
+		ClusterID   string
+	}{svc.Spec, annotations, region, clusterID})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// loadBalancerResources returns the existing resources of the load balancer,
+// among the ones it names, the ones it references and the ones of the
+// previous checkpoint, if any. The resources of the checkpoint are kept until
+// the load balancer is deleted, so that the resources named by a previous
+// version of the provider are not leaked.
+func (g *Cloud) loadBalancerResources(loadBalancerName, clusterID, region string, previous *LoadBalancerResourcesCheckpoint) (*LoadBalancerResourcesCheckpoint, error) {
+	names := makeLoadBalancerResourceNames(loadBalancerName, clusterID)
+	referenced, err := g.referencedLoadBalancerResources(loadBalancerName, region)
+	if err != nil {
+		return nil, err
+	}
+	for kind, referencedNames := range referenced {
+		names[kind] = append(names[kind], referencedNames...)
+	}
+	if previous != nil && previous.Region == region {
+		for _, resource := range ownedCheckpointedResources(previous, loadBalancerName, clusterID) {
+			names[resource.Kind] = append(names[resource.Kind], resource.Name)
+		}
+	}
+
+	checkpoint := &LoadBalancerResourcesCheckpoint{Region: region, Resources: []CheckpointedLoadBalancerResource{}}
+	for _, kind := range lbResourceKinds {
+		seen := map[string]bool{}
+		for _, name := range names[kind] {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fingerprint, exists, err := g.getLoadBalancerResource(kind, name, region)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				checkpoint.Resources = append(checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: kind, Name: name, Fingerprint: fingerprint})
+			}
+		}
+	}
+	return checkpoint, nil
+}
+
+// checkpointLoadBalancerResources records the resources of the load balancer
+// of svc in ServiceAnnotationLoadBalancerResources once it is ensured or
+// updated, unless they were already recorded for the same Service and region
+// and did not drift since. The failures are logged, they do not fail the sync
+// of the load balancer.
+func (g *Cloud) checkpointLoadBalancerResources(svc *v1.Service, loadBalancerName, clusterID string) {
+	previous, err := GetLoadBalancerAnnotationResources(svc)
+	if err != nil {
+		klog.Warningf("Ignoring the invalid resources checkpoint of Service %s/%s: %v", svc.Namespace, svc.Name, err)
+		previous = nil
+	}
+	region := g.loadBalancerRegion(svc)
+	hash, err := loadBalancerResourcesHash(svc, region, clusterID)
+	if err != nil {
+		klog.Warningf("Failed to hash Service %s/%s: %v", svc.Namespace, svc.Name, err)
+		return
+	}
+	if previous != nil && previous.Hash == hash {
+		// The syncs of the unchanged Service still change its resources,
+		// e.g. the backends of its backend service when the nodes change.
+		drift, err := g.resourcesDrift(previous, loadBalancerName, clusterID)
+		if err != nil {
+			klog.Warningf("Failed to check the resources of load balancer %s of Service %s/%s: %v", loadBalancerName, svc.Namespace, svc.Name, err)
+			return
+		}
+		if len(drift) == 0 {
+			return
+		}
+		klog.V(2).Infof("checkpointLoadBalancerResources(%v): recording the resources again: %s", loadBalancerName, strings.Join(drift, "; "))
+	}
+	checkpoint, err := g.loadBalancerResources(loadBalancerName, clusterID, region, previous)
+	if err != nil {
+		klog.Warningf("Failed to get the resources of load balancer %s of Service %s/%s: %v", loadBalancerName, svc.Namespace, svc.Name, err)
+		return
+	}
+	checkpoint.Hash = hash
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		klog.Warningf("Failed to marshal the resources of load balancer %s of Service %s/%s: %v", loadBalancerName, svc.Namespace, svc.Name, err)
+		return
+	}
+	if svc.Annotations[ServiceAnnotationLoadBalancerResources] == string(b) {
+		return
+	}
+
+	updated := svc.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[ServiceAnnotationLoadBalancerResources] = string(b)
+	if _, err := servicehelper.PatchService(g.client.CoreV1(), svc, updated); err != nil {
+		klog.Warningf("Failed to update the %s annotation of Service %s/%s: %v", ServiceAnnotationLoadBalancerResources, svc.Namespace, svc.Name, err)
+	}
+}
+
+// ensureCheckpointedResourcesDeleted deletes the resources of the checkpoint
+// of svc which still exist once its load balancer is deleted, in the region
+// of the checkpoint, and removes the checkpoint.
+func (g *Cloud) ensureCheckpointedResourcesDeleted(svc *v1.Service, loadBalancerName, clusterID string) error {
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	if err != nil {
+		klog.Warningf("Ignoring the invalid resources checkpoint of Service %s/%s: %v", svc.Namespace, svc.Name, err)
+		checkpoint = nil
+	}
+	if checkpoint != nil {
+		if err := g.deleteCheckpointedResources(svc, loadBalancerName, clusterID, checkpoint); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := svc.Annotations[ServiceAnnotationLoadBalancerResources]; !ok {
+		return nil
+	}
+	updated := svc.DeepCopy()
+	delete(updated.Annotations, ServiceAnnotationLoadBalancerResources)
+	if _, err := servicehelper.PatchService(g.client.CoreV1(), svc, updated); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteCheckpointedResources deletes the resources of checkpoint owned by the
+// load balancer of svc in the region of the checkpoint. The resources in use
+// by other load balancers are kept.
+func (g *Cloud) deleteCheckpointedResources(svc *v1.Service, loadBalancerName, clusterID string, checkpoint *LoadBalancerResourcesCheckpoint) error {
+	for _, resource := range ownedCheckpointedResources(checkpoint, loadBalancerName, clusterID) {
+		err := g.deleteLoadBalancerResource(svc, loadBalancerName, checkpoint.Region, resource.Kind, resource.Name)
+		if isInUsedByError(err) {
+			klog.V(2).Infof("deleteCheckpointedResources(%v): %s %s is in use, keeping it", loadBalancerName, resource.Kind, resource.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpointedResourcesDrift returns the resources of the checkpoint of svc
+// which were deleted or, for the kinds of resources with a fingerprint,
+// modified since they were recorded.
+func (g *Cloud) checkpointedResourcesDrift(svc *v1.Service, loadBalancerName, clusterID string) ([]string, error) {
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	if err != nil || checkpoint == nil || checkpoint.Region != g.loadBalancerRegion(svc) {
+		return nil, err
+	}
+	return g.resourcesDrift(checkpoint, loadBalancerName, clusterID)
+}
+
+// resourcesDrift returns the resources of checkpoint which were deleted or
+// modified since they were recorded.
+func (g *Cloud) resourcesDrift(checkpoint *LoadBalancerResourcesCheckpoint, loadBalancerName, clusterID string) ([]string, error) {
+	var drift []string
+	for _, resource := range ownedCheckpointedResources(checkpoint, loadBalancerName, clusterID) {
+		fingerprint, exists, err := g.getLoadBalancerResource(resource.Kind, resource.Name, checkpoint.Region)
+		if err != nil {
+			return nil, err
+		}
+		description := lbResourceKindDescriptions[resource.Kind]
+		if !exists {
+			drift = append(drift, fmt.Sprintf("%s %s does not exist", description, resource.Name))
+		} else if fingerprint != resource.Fingerprint {
+			drift = append(drift, fmt.Sprintf("%s %s was modified", description, resource.Name))
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,180 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckpointLoadBalancerResources(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	svc := fakeLoadbalancerService("")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", svc)
+
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err := GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, gce.region, checkpoint.Region)
+	// The shared nodes health check and its firewall are not recorded.
+	assert.Equal(t, []CheckpointedLoadBalancerResource{
+		{Kind: LoadBalancerResourceForwardingRules, Name: lbName},
+		{Kind: LoadBalancerResourceTargetPools, Name: lbName},
+		{Kind: LoadBalancerResourceFirewalls, Name: MakeFirewallName(lbName)},
+	}, checkpoint.Resources)
+
+	// The resources are not recorded again while the Service is unchanged,
+	// only the recorded resources are checked.
+	mockGCE := gce.c.(*cloud.MockGCE)
+	firewallGets := 0
+	mockGCE.MockFirewalls.GetHook = func(_ context.Context, _ *meta.Key, _ *cloud.MockFirewalls, _ ...cloud.Option) (bool, *compute.Firewall, error) {
+		firewallGets++
+		return false, nil, nil
+	}
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	before := firewallGets
+	gce.checkpointLoadBalancerResources(svc, lbName, vals.ClusterID)
+	assert.Equal(t, before+1, firewallGets, "the unchanged resources were recorded again")
+	mockGCE.MockFirewalls.GetHook = nil
+
+	// The resources modified by the syncs of the unchanged Service are
+	// recorded again.
+	fwdRule, err := gce.GetRegionForwardingRule(lbName, gce.region)
+	require.NoError(t, err)
+	require.NoError(t, gce.DeleteRegionForwardingRule(lbName, gce.region))
+	fwdRule.Fingerprint = "modified"
+	require.NoError(t, gce.CreateRegionForwardingRule(fwdRule, gce.region))
+	gce.checkpointLoadBalancerResources(svc, lbName, vals.ClusterID)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err = GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	assert.Contains(t, checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceForwardingRules, Name: lbName, Fingerprint: "modified"})
+
+	// The resources of the checkpoint which are no longer named by the
+	// provider, e.g. recorded by a previous version, are kept in the
+	// checkpoint until the load balancer is deleted. The resources of the
+	// other load balancers are ignored.
+	leftover := "k8s-fw-" + lbName + "-v0"
+	other := "k8s-fw-other"
+	for _, name := range []string{leftover, other} {
+		require.NoError(t, gce.CreateFirewall(&compute.Firewall{Name: name}))
+		checkpoint.Resources = append(checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceFirewalls, Name: name})
+	}
+	checkpoint.Hash = ""
+	b, err := json.Marshal(checkpoint)
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerResources] = string(b)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Update(context.TODO(), svc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.NoError(t, err)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	checkpoint, err = GetLoadBalancerAnnotationResources(svc)
+	require.NoError(t, err)
+	assert.Contains(t, checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceFirewalls, Name: leftover})
+	assert.NotContains(t, checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceFirewalls, Name: other})
+	assert.NotEmpty(t, checkpoint.Hash)
+
+	// The resources of the other load balancers are not deleted either.
+	checkpoint.Resources = append(checkpoint.Resources, CheckpointedLoadBalancerResource{Kind: LoadBalancerResourceFirewalls, Name: other})
+	b, err = json.Marshal(checkpoint)
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerResources] = string(b)
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Update(context.TODO(), svc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	_, err = gce.GetFirewall(leftover)
+	assert.True(t, isNotFound(err), "leftover firewall was not deleted: %v", err)
+	_, err = gce.GetFirewall(other)
+	assert.NoError(t, err, "firewall of another load balancer was deleted")
+	svc, err = gce.client.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, svc.Annotations, ServiceAnnotationLoadBalancerResources)
+}
+
+func TestCheckpointedResourcesDrift(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: "alb", Fingerprint: "b"}, gce.region))
+	require.NoError(t, gce.CreateRegionForwardingRule(&compute.ForwardingRule{Name: "alb-tcp", Fingerprint: "a"}, gce.region))
+
+	for desc, tc := range map[string]struct {
+		annotation string
+		wantDrift  []string
+		wantErr    bool
+	}{
+		"no checkpoint": {},
+		"invalid checkpoint": {
+			annotation: "{",
+			wantErr:    true,
+		},
+		"other region": {
+			annotation: `{"region":"europe-west1","resources":[{"kind":"firewalls","name":"fw"}]}`,
+		},
+		"deleted and modified": {
+			annotation: `{"region":"us-central1","resources":[{"kind":"forwardingRules","name":"alb","fingerprint":"a"},{"kind":"forwardingRules","name":"alb-tcp","fingerprint":"a"},{"kind":"firewalls","name":"k8s-fw-alb"}]}`,
+			wantDrift:  []string{"forwarding rule alb was modified", "firewall k8s-fw-alb does not exist"},
+		},
+		"other load balancers": {
+			annotation: `{"region":"us-central1","resources":[{"kind":"firewalls","name":"k8s-fw-other"}]}`,
+		},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			if tc.annotation != "" {
+				svc.Annotations[ServiceAnnotationLoadBalancerResources] = tc.annotation
+			}
+			drift, err := gce.checkpointedResourcesDrift(svc, "alb", vals.ClusterID)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantDrift, drift)
+		})
+	}
+}
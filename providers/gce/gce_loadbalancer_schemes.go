@@ -0,0 +1,75 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"flag"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+)
+
+const (
+	// InternalLoadBalancersFlag and ExternalLoadBalancersFlag are the flags
+	// enabling the internal and the external L4 LBs.
+	InternalLoadBalancersFlag = "cloud-provider-gce-lb-internal"
+	ExternalLoadBalancersFlag = "cloud-provider-gce-lb-external"
+)
+
+var (
+	// lbInternalEnabled and lbExternalEnabled allow to run the service
+	// controller for a single kind of L4 LBs, when the other kind is
+	// implemented by another controller.
+	lbInternalEnabled = true
+	lbExternalEnabled = true
+)
+
+func init() {
+	flag.BoolVar(&lbInternalEnabled, InternalLoadBalancersFlag, true, "Ensure the internal L4 LBs of the Services, they are left to another controller if false")
+	flag.BoolVar(&lbExternalEnabled, ExternalLoadBalancersFlag, true, "Ensure the external L4 LBs of the Services, they are left to another controller if false")
+}
+
+// InternalLoadBalancersEnabled returns true if the internal L4 LBs are
+// ensured by the provider.
+func InternalLoadBalancersEnabled() bool {
+	return lbInternalEnabled
+}
+
+// ExternalLoadBalancersEnabled returns true if the external L4 LBs are
+// ensured by the provider.
+func ExternalLoadBalancersEnabled() bool {
+	return lbExternalEnabled
+}
+
+// loadBalancerSchemeEnabled returns true if the L4 LBs of the scheme are
+// ensured by the provider.
+func loadBalancerSchemeEnabled(scheme cloud.LbScheme) bool {
+	if scheme == cloud.SchemeInternal {
+		return lbInternalEnabled
+	}
+	return lbExternalEnabled
+}
+
+// loadBalancerSchemeFlag returns the flag enabling the L4 LBs of the scheme.
+func loadBalancerSchemeFlag(scheme cloud.LbScheme) string {
+	if scheme == cloud.SchemeInternal {
+		return InternalLoadBalancersFlag
+	}
+	return ExternalLoadBalancersFlag
+}
@@ -0,0 +1,132 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func setLBInternalEnabled(t *testing.T, enabled bool) {
+	previous := lbInternalEnabled
+	lbInternalEnabled = enabled
+	t.Cleanup(func() { lbInternalEnabled = previous })
+}
+
+func TestEnsureLoadBalancerSchemeDisabled(t *testing.T) {
+	setLBInternalEnabled(t, false)
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The internal load balancers are left to another controller.
+	ilbService := fakeLoadbalancerService(string(LBTypeInternal))
+	ilbService, err = gce.client.CoreV1().Services(ilbService.Namespace).Create(context.TODO(), ilbService, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, ilbService, nodes)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+	err = gce.UpdateLoadBalancer(context.Background(), vals.ClusterName, ilbService, nodes)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+	lbName := gce.GetLoadBalancerName(context.TODO(), "", ilbService)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err), "GetRegionForwardingRule(%s) = %v, want not found", lbName, err)
+
+	// The existing internal load balancers are not deleted either.
+	createInternalLoadBalancer(gce, ilbService, nil, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	err = gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, ilbService)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+	assertInternalLbResources(t, gce, ilbService, vals, nodeNames)
+
+	// The external load balancers are still ensured.
+	elbService := fakeLoadbalancerService("")
+	elbService.Name = "elb"
+	elbService.UID = "elb-uid"
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, elbService, nodes)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, status.Ingress)
+	assertExternalLbResources(t, gce, elbService, vals, nodeNames)
+	assertInternalLbResources(t, gce, ilbService, vals, nodeNames)
+}
+
+func TestEnsureLoadBalancerDeletedSchemeChanged(t *testing.T) {
+	setLBInternalEnabled(t, false)
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	_, err = createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The external load balancer is deleted although the Service is now
+	// internal, its load balancers being left to another controller.
+	svc := fakeLoadbalancerService("")
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerType] = string(LBTypeInternal)
+	require.NoError(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc))
+	assertExternalLbResourcesDeleted(t, gce, svc, vals, true)
+
+	// Without an existing load balancer, the scheme of the Service decides.
+	err = gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, svc)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+}
+
+func TestEnsureLoadBalancerSchemeChangedToDisabled(t *testing.T) {
+	setLBInternalEnabled(t, false)
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	nodeNames := []string{"test-node-1"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The external load balancer is deleted before the Service, now
+	// internal, is left to another controller.
+	svc := fakeLoadbalancerService("")
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerType] = string(LBTypeInternal)
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+	assertExternalLbResourcesDeleted(t, gce, svc, vals, true)
+
+	// A paused Service keeps its load balancer.
+	svc = fakeLoadbalancerService("")
+	svc.Name, svc.UID = "paused", "paused-uid"
+	_, err = createExternalLoadBalancer(gce, svc, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	svc.Annotations[ServiceAnnotationLoadBalancerType] = string(LBTypeInternal)
+	svc.Annotations[ServiceAnnotationReconcile] = ReconcilePaused
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
+	_, err = gce.GetRegionForwardingRule(gce.GetLoadBalancerName(context.TODO(), "", svc), gce.region)
+	assert.NoError(t, err)
+}
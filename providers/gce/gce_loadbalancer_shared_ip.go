@@ -0,0 +1,206 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// sharedAddressPrefix is the name prefix of the addresses shared by the
+// external load balancers of a group of Services.
+const sharedAddressPrefix = "k8s-shared-ip-"
+
+// sharedAddressDescription is the description of a shared address.
+type sharedAddressDescription struct {
+	ClusterID string `json:"kubernetes.io/cluster-id"`
+	Group     string `json:"kubernetes.io/shared-ip-group"`
+}
+
+// makeSharedAddressName returns the name of the address shared by the
+// Services of the group. The group is hashed as it is not a valid name.
+func makeSharedAddressName(clusterID, group string) string {
+	hash := sha256.Sum256([]byte(group))
+	return fmt.Sprintf("%s%s-%x", sharedAddressPrefix, clusterID, hash[:8])
+}
+
+// ensureSharedIP returns the IP of the address shared by the Services of the
+// group, and reserves it if no Service of the group did yet. The address
+// gets the IP requested by the Service if any, or keeps the IP of its
+// existing forwarding rule. The caller must hold sharedIPLock for reading.
+func (g *Cloud) ensureSharedIP(group, clusterID, requestedIP, fwdRuleIP string, netTier cloud.NetworkTier) (string, error) {
+	name := makeSharedAddressName(clusterID, group)
+	addr, err := g.GetRegionAddress(name, g.region)
+	if err != nil && !isNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		if requestedIP != "" && requestedIP != addr.Address {
+			return "", fmt.Errorf("requested IP %q differs from the IP %s shared by group %q", requestedIP, addr.Address, group)
+		}
+		// The network tier of the IP shared by the group only changes
+		// once the address is released by the Services of the group.
+		if addrTier := cloud.NetworkTierGCEValueToType(addr.NetworkTier); addr.NetworkTier != "" && addrTier != netTier {
+			return "", fmt.Errorf("the IP %s shared by group %q belongs to the %s network tier; expected %s", addr.Address, group, addrTier, netTier)
+		}
+		return addr.Address, nil
+	}
+
+	desc, err := json.Marshal(sharedAddressDescription{ClusterID: clusterID, Group: group})
+	if err != nil {
+		return "", err
+	}
+	addr = &compute.Address{
+		Name:        name,
+		Description: string(desc),
+		NetworkTier: netTier.ToGCEValue(),
+		Address:     requestedIP,
+	}
+	if addr.Address == "" {
+		addr.Address = fwdRuleIP
+	}
+	if err := g.ReserveRegionAddress(addr, g.region); err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
+		return "", fmt.Errorf("failed to reserve the IP shared by group %q: %w", group, err)
+	}
+	if addr, err = g.GetRegionAddress(name, g.region); err != nil {
+		return "", err
+	}
+	klog.Infof("ensureSharedIP(%s): Reserved IP %s shared by group %q as address %s", clusterID, addr.Address, group, name)
+	return addr.Address, nil
+}
+
+// checkSharedIPPorts returns an error if the ports of the forwarding rules of
+// the load balancer overlap the ports of the other forwarding rules with the
+// IP, of the same protocol.
+func (g *Cloud) checkSharedIPPorts(loadBalancerName, ipAddress string, portGroups [][]v1.ServicePort) error {
+	rules, err := g.ListRegionForwardingRules(g.region)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		// The main forwarding rule of the load balancer is named after it,
+		// the forwarding rules of its other protocols are prefixed by it.
+		if rule.IPAddress != ipAddress || rule.Name == loadBalancerName || strings.HasPrefix(rule.Name, loadBalancerName+"-") {
+			continue
+		}
+		for _, ports := range portGroups {
+			if !strings.EqualFold(rule.IPProtocol, string(ports[0].Protocol)) {
+				continue
+			}
+			portRange, err := loadBalancerPortRange(ports)
+			if err != nil {
+				return err
+			}
+			for _, rulePorts := range forwardingRulePortRanges(rule) {
+				if portRangesOverlap(portRange, rulePorts) {
+					return fmt.Errorf("ports %s/%s overlap the ports %s of forwarding rule %s sharing IP %s", ports[0].Protocol, portRange, rulePorts, rule.Name, ipAddress)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// forwardingRulePortRanges returns the ports of the forwarding rule as port
+// ranges, e.g. "80-80".
+func forwardingRulePortRanges(rule *compute.ForwardingRule) []string {
+	switch {
+	case rule.AllPorts:
+		return []string{"1-65535"}
+	case rule.PortRange != "":
+		return []string{rule.PortRange}
+	}
+	return rule.Ports
+}
+
+// portRangesOverlap returns true if the port ranges overlap, a range is
+// either a port or "<min>-<max>". The ranges which cannot be parsed are
+// considered overlapping.
+func portRangesOverlap(a, b string) bool {
+	aMin, aMax, aErr := parsePortRange(a)
+	bMin, bMax, bErr := parsePortRange(b)
+	if aErr != nil || bErr != nil {
+		return true
+	}
+	return aMin <= bMax && bMin <= aMax
+}
+
+func parsePortRange(portRange string) (int, int, error) {
+	minPort, maxPort, found := strings.Cut(portRange, "-")
+	if !found {
+		maxPort = minPort
+	}
+	lo, err := strconv.Atoi(minPort)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := strconv.Atoi(maxPort)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lo, hi, nil
+}
+
+// releaseUnusedSharedIPs releases the shared addresses of the cluster which
+// no forwarding rule uses anymore, i.e. once the last Service of their group
+// is deleted or leaves the group. The forwarding rules using the IP of an
+// address are its references. The caller must hold sharedIPLock.
+func (g *Cloud) releaseUnusedSharedIPs(clusterID string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newAddressMetricContext("list", g.region)
+	addrs, err := g.c.Addresses().List(ctx, g.region, filter.Regexp("name", sharedAddressPrefix+clusterID+"-.*"))
+	mc.Observe(err)
+	if err != nil || len(addrs) == 0 {
+		return err
+	}
+	rules, err := g.ListRegionForwardingRules(g.region)
+	if err != nil {
+		return err
+	}
+	used := sets.NewString()
+	for _, rule := range rules {
+		used.Insert(rule.IPAddress)
+	}
+	for _, addr := range addrs {
+		if used.Has(addr.Address) {
+			continue
+		}
+		var desc sharedAddressDescription
+		_ = json.Unmarshal([]byte(addr.Description), &desc)
+		if err := g.DeleteRegionAddress(addr.Name, g.region); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to release the IP %s shared by group %q: %w", addr.Address, desc.Group, err)
+		}
+		klog.Infof("releaseUnusedSharedIPs(%s): Released IP %s shared by group %q, address %s", clusterID, addr.Address, desc.Group, addr.Name)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// sharedIPService returns an external LoadBalancer Service of the group with
+// the given TCP ports.
+func sharedIPService(name, group string, ports ...int32) *v1.Service {
+	svc := fakeLoadbalancerService("")
+	svc.Name = name
+	svc.UID = types.UID("uid-" + name)
+	svc.Annotations[ServiceAnnotationLoadBalancerSharedIP] = group
+	svc.Spec.Ports = nil
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Protocol: v1.ProtocolTCP, Port: port})
+	}
+	return svc
+}
+
+func TestEnsureExternalLoadBalancerSharedIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodeNames := []string{"test-node-1"}
+	addressName := makeSharedAddressName(vals.ClusterID, "frontend")
+
+	web := sharedIPService("web", "frontend", 80, 443)
+	webStatus, err := createExternalLoadBalancer(gce, web, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	addr, err := gce.GetRegionAddress(addressName, vals.Region)
+	require.NoError(t, err)
+	assert.Equal(t, addr.Address, webStatus.Ingress[0].IP)
+
+	// The Services of the group share the IP with other ports.
+	dns := sharedIPService("dns", "frontend", 8053)
+	dnsStatus, err := createExternalLoadBalancer(gce, dns, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, addr.Address, dnsStatus.Ingress[0].IP)
+	fwdRule, err := gce.GetRegionForwardingRule(gce.GetLoadBalancerName(context.TODO(), "", dns), vals.Region)
+	require.NoError(t, err)
+	assert.Equal(t, addr.Address, fwdRule.IPAddress)
+
+	// The port ranges of the forwarding rules cannot overlap, the one of web
+	// is 80-443.
+	proxy := sharedIPService("proxy", "frontend", 200, 110)
+	_, err = createExternalLoadBalancer(gce, proxy, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, "overlap the ports 80-443")
+
+	// The other groups get IPs of their own, the IP is requested as the mock
+	// allocates the same IP to all the addresses.
+	other := sharedIPService("other", "backend", 80)
+	other.Spec.LoadBalancerIP = "1.2.3.50"
+	otherStatus, err := createExternalLoadBalancer(gce, other, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.NotEqual(t, addr.Address, otherStatus.Ingress[0].IP)
+
+	// The address is released with the last Service of the group.
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, web))
+	_, err = gce.GetRegionAddress(addressName, vals.Region)
+	assert.NoError(t, err)
+	require.NoError(t, gce.ensureExternalLoadBalancerDeleted(vals.ClusterName, vals.ClusterID, dns))
+	_, err = gce.GetRegionAddress(addressName, vals.Region)
+	assert.True(t, isNotFound(err), "GetRegionAddress(%s) = %v, want not found", addressName, err)
+	_, err = gce.GetRegionAddress(makeSharedAddressName(vals.ClusterID, "backend"), vals.Region)
+	assert.NoError(t, err)
+}
+
+func TestEnsureExternalLoadBalancerSharedIPRequestedIP(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodeNames := []string{"test-node-1"}
+
+	web := sharedIPService("web", "frontend", 80)
+	web.Spec.LoadBalancerIP = "1.2.3.4"
+	status, err := createExternalLoadBalancer(gce, web, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", status.Ingress[0].IP)
+
+	// The Services of the group cannot request another IP.
+	dns := sharedIPService("dns", "frontend", 53)
+	dns.Spec.LoadBalancerIP = "1.2.3.5"
+	_, err = createExternalLoadBalancer(gce, dns, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, "differs from the IP 1.2.3.4 shared by group")
+}
+
+func TestPortRangesOverlap(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{a: "80-80", b: "80-80", want: true},
+		{a: "80-443", b: "8080-8080", want: false},
+		{a: "80-443", b: "443", want: true},
+		{a: "1-65535", b: "53-53", want: true},
+		{a: "53", b: "54-60", want: false},
+		{a: "invalid", b: "80", want: true},
+	} {
+		assert.Equal(t, tc.want, portRangesOverlap(tc.a, tc.b), "portRangesOverlap(%q, %q)", tc.a, tc.b)
+	}
+}
+
+func TestEnsureExternalLoadBalancerSharedIPNetworkTier(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodeNames := []string{"test-node-1"}
+
+	web := sharedIPService("web", "frontend", 80)
+	web.Annotations[NetworkTierAnnotationKey] = string(cloud.NetworkTierStandard)
+	_, err = createExternalLoadBalancer(gce, web, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+
+	// The IP shared by the group keeps its network tier, the forwarding rule
+	// of the Service is not deleted.
+	web.Annotations[NetworkTierAnnotationKey] = string(cloud.NetworkTierPremium)
+	_, err = createExternalLoadBalancer(gce, web, nodeNames, vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	assert.ErrorContains(t, err, "belongs to the Standard network tier")
+	fwdRule, err := gce.GetRegionForwardingRule(gce.GetLoadBalancerName(context.TODO(), "", web), vals.Region)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.NetworkTierStandard.ToGCEValue(), fwdRule.NetworkTier)
+}
@@ -0,0 +1,101 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/klog/v2"
+)
+
+// TargetPoolSubsettingStrategy selects the instances of a target pool when
+// the nodes exceed the maximum number of instances per target pool.
+type TargetPoolSubsettingStrategy string
+
+const (
+	// TargetPoolSubsettingZoneBalanced selects the instances round-robin
+	// across the zones, so that the traffic is not concentrated on the zones
+	// with the first instance names.
+	TargetPoolSubsettingZoneBalanced TargetPoolSubsettingStrategy = "zone-balanced"
+	// TargetPoolSubsettingAlphabetical selects the first instances in
+	// alphabetical order, as for the instance groups of internal load
+	// balancers.
+	TargetPoolSubsettingAlphabetical TargetPoolSubsettingStrategy = "alphabetical"
+)
+
+func validateTargetPoolSubsetting(maxInstances int, strategy TargetPoolSubsettingStrategy) error {
+	if maxInstances < 0 {
+		return fmt.Errorf("max-target-pool-instances must not be negative: %d", maxInstances)
+	}
+	switch strategy {
+	case "", TargetPoolSubsettingZoneBalanced, TargetPoolSubsettingAlphabetical:
+		return nil
+	default:
+		return fmt.Errorf("unsupported target-pool-subsetting-strategy: %q", strategy)
+	}
+}
+
+// targetPoolHosts returns the hosts to add to a target pool, limited to
+// maxTargetPoolInstances if set. The selection only depends on the hosts, so
+// that the membership does not change across syncs of the same nodes.
+func (g *Cloud) targetPoolHosts(loadBalancerName string, hosts []*gceInstance) []*gceInstance {
+	if g.maxTargetPoolInstances <= 0 || len(hosts) <= g.maxTargetPoolInstances {
+		return hosts
+	}
+	klog.V(2).Infof("targetPoolHosts(%s): Limiting target pool to %d of %d hosts (strategy %q)", loadBalancerName, g.maxTargetPoolInstances, len(hosts), g.targetPoolSubsettingStrategy)
+	if g.targetPoolSubsettingStrategy == TargetPoolSubsettingAlphabetical {
+		return alphabeticalHosts(hosts, g.maxTargetPoolInstances)
+	}
+	return zoneBalancedHosts(hosts, g.maxTargetPoolInstances)
+}
+
+// alphabeticalHosts returns the first limit hosts sorted by name.
+func alphabeticalHosts(hosts []*gceInstance, limit int) []*gceInstance {
+	sorted := append([]*gceInstance(nil), hosts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted[:limit]
+}
+
+// zoneBalancedHosts returns limit hosts taken round-robin across the sorted
+// zones, in alphabetical order within each zone. Zones with fewer hosts are
+// exhausted first and the remaining hosts are spread over the other zones.
+func zoneBalancedHosts(hosts []*gceInstance, limit int) []*gceInstance {
+	byZone := map[string][]*gceInstance{}
+	for _, host := range hosts {
+		byZone[host.Zone] = append(byZone[host.Zone], host)
+	}
+	zones := make([]string, 0, len(byZone))
+	for zone, zoneHosts := range byZone {
+		zones = append(zones, zone)
+		sort.Slice(zoneHosts, func(i, j int) bool { return zoneHosts[i].Name < zoneHosts[j].Name })
+	}
+	sort.Strings(zones)
+
+	selected := make([]*gceInstance, 0, limit)
+	for i := 0; len(selected) < limit; i++ {
+		for _, zone := range zones {
+			if i < len(byZone[zone]) && len(selected) < limit {
+				selected = append(selected, byZone[zone][i])
+			}
+		}
+	}
+	return selected
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 )
 
@@ -361,6 +362,42 @@ func TestCheckMixedProtocol(t *testing.T) {
 	}
 }
 
+func TestCheckServiceProtocols(t *testing.T) {
+	t.Parallel()
+
+	mixedPorts := []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 443}, {Protocol: v1.ProtocolUDP, Port: 443}}
+	for name, tc := range map[string]struct {
+		annotations map[string]string
+		wantErr     bool
+	}{
+		"External": {
+			annotations: map[string]string{},
+			wantErr:     true,
+		},
+		"External with a forwarding rule per protocol": {
+			annotations: map[string]string{ServiceAnnotationLoadBalancerForwardingRulePerProtocol: "true"},
+		},
+		"Internal with a forwarding rule per protocol": {
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerType:                      string(LBTypeInternal),
+				ServiceAnnotationLoadBalancerForwardingRulePerProtocol: "true",
+			},
+			wantErr: true,
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			svc := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       v1.ServiceSpec{Ports: mixedPorts},
+			}
+			err := checkServiceProtocols(svc)
+			assert.Equal(t, tc.wantErr, err != nil, "checkServiceProtocols() = %v", err)
+		})
+	}
+}
+
 func Test_hasLoadBalancerPortsError(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -499,3 +536,57 @@ func TestUpdateLoadBalancerWithLoadBalancerClass(t *testing.T) {
 	err = gce.UpdateLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
 	assert.ErrorIs(t, err, cloudprovider.ImplementedElsewhere)
 }
+
+func TestLoadBalancerReconcilePaused(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(1024)
+	gce.eventRecorder = recorder
+
+	nodeNames := []string{"test-node-1", "test-node-2"}
+	nodes, err := createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+
+	apiService := fakeLoadbalancerService("")
+	apiService.Annotations[ServiceAnnotationReconcile] = ReconcilePaused
+
+	// A paused Service is not given a load balancer.
+	status, err := gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	assert.Error(t, err)
+	assert.Nil(t, status)
+	lbName := gce.GetLoadBalancerName(context.Background(), vals.ClusterName, apiService)
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.True(t, isNotFound(err))
+	assert.Contains(t, <-recorder.Events, eventReasonReconcilePaused)
+	assert.Contains(t, <-recorder.Events, eventReasonLoadBalancerDrift)
+
+	// The existing load balancer is kept as is while paused.
+	_, err = createExternalLoadBalancer(gce, apiService, nodeNames[:1], vals.ClusterName, vals.ClusterID, vals.ZoneName)
+	require.NoError(t, err)
+	status, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes)
+	require.NoError(t, err)
+	assert.NotEmpty(t, status.Ingress)
+	assert.Contains(t, <-recorder.Events, eventReasonReconcilePaused)
+	assert.Contains(t, <-recorder.Events, "target pool "+lbName+" is missing 1 instances")
+
+	require.NoError(t, gce.UpdateLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes))
+	pool, err := gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Len(t, pool.Instances, 1)
+	assert.Contains(t, <-recorder.Events, eventReasonReconcilePaused)
+	assert.Contains(t, <-recorder.Events, eventReasonLoadBalancerDrift)
+
+	assert.Error(t, gce.EnsureLoadBalancerDeleted(context.Background(), vals.ClusterName, apiService))
+	_, err = gce.GetRegionForwardingRule(lbName, gce.region)
+	assert.NoError(t, err)
+
+	// Resuming the reconciliation fixes the drift.
+	delete(apiService.Annotations, ServiceAnnotationReconcile)
+	require.NoError(t, gce.UpdateLoadBalancer(context.Background(), vals.ClusterName, apiService, nodes))
+	pool, err = gce.GetTargetPool(lbName, gce.region)
+	require.NoError(t, err)
+	assert.Len(t, pool.Instances, 2)
+}
@@ -0,0 +1,122 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The annotations of the mutation Events, identifying the mutation.
+	mutationOperationTypeAnnotation = "cloud.google.com/operation-type"
+	mutationTargetLinkAnnotation    = "cloud.google.com/target-link"
+	mutationOperationLinkAnnotation = "cloud.google.com/operation-link"
+
+	// The reasons of the mutation Events.
+	eventReasonCloudResourceCreated        = "CloudResourceCreated"
+	eventReasonCloudResourceUpdated        = "CloudResourceUpdated"
+	eventReasonCloudResourceDeleted        = "CloudResourceDeleted"
+	eventReasonCloudResourceMutationFailed = "CloudResourceMutationFailed"
+)
+
+// mutationEventsObject is the object of the mutation Events. The GCE
+// resources are not Kubernetes objects, the Events are recorded on the
+// kube-system Namespace so that the audit trail is cluster-scoped.
+var mutationEventsObject = &v1.ObjectReference{
+	APIVersion: "v1",
+	Kind:       "Namespace",
+	Name:       metav1.NamespaceSystem,
+}
+
+// mutationEventTransport records an Event for each mutation of a GCE
+// resource, when the operation of the mutation is done. All the mutations of
+// the Compute API return an operation, which k8s-cloud-provider polls until
+// it is done, so the polls are the single place where the mutations of all
+// the resources are seen, once each.
+type mutationEventTransport struct {
+	base  http.RoundTripper
+	cloud *Cloud
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *mutationEventTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	// The responses of the other calls, e.g. lists, are not buffered.
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(req.URL.Path, "/operations/") {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var op compute.Operation
+	if err := json.Unmarshal(body, &op); err == nil && op.Kind == "compute#operation" && op.Status == "DONE" {
+		t.cloud.recordMutation(&op)
+	}
+	return resp, nil
+}
+
+// recordMutation records the Event of a done operation.
+func (g *Cloud) recordMutation(op *compute.Operation) {
+	resource := op.TargetLink
+	if i := strings.Index(resource, "projects/"); i != -1 {
+		resource = resource[i:]
+	}
+	klog.V(2).Infof("GCE %s of %s is done, operation %s", op.OperationType, resource, op.Name)
+	if g.eventRecorder == nil {
+		return
+	}
+
+	annotations := map[string]string{
+		mutationOperationTypeAnnotation: op.OperationType,
+		mutationTargetLinkAnnotation:    op.TargetLink,
+		mutationOperationLinkAnnotation: op.SelfLink,
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		var errs []string
+		for _, e := range op.Error.Errors {
+			errs = append(errs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		g.eventRecorder.AnnotatedEventf(mutationEventsObject, annotations, v1.EventTypeWarning, eventReasonCloudResourceMutationFailed, "GCE %s of %s failed, operation %s: %s", op.OperationType, resource, op.Name, strings.Join(errs, "; "))
+		return
+	}
+
+	reason := eventReasonCloudResourceUpdated
+	switch op.OperationType {
+	case "insert":
+		reason = eventReasonCloudResourceCreated
+	case "delete":
+		reason = eventReasonCloudResourceDeleted
+	}
+	g.eventRecorder.AnnotatedEventf(mutationEventsObject, annotations, v1.EventTypeNormal, reason, "GCE %s of %s, operation %s", op.OperationType, resource, op.Name)
+}
@@ -0,0 +1,118 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMutationEventTransport(t *testing.T) {
+	responses := map[string]interface{}{
+		"/projects/p/global/operations/op-insert": &compute.Operation{
+			Kind:          "compute#operation",
+			Name:          "op-insert",
+			OperationType: "insert",
+			Status:        "DONE",
+			TargetLink:    "https://compute.googleapis.com/compute/v1/projects/p/global/firewalls/k8s-fw-a",
+		},
+		"/projects/p/regions/r/operations/op-delete/wait": &compute.Operation{
+			Kind:          "compute#operation",
+			Name:          "op-delete",
+			OperationType: "delete",
+			Status:        "DONE",
+			TargetLink:    "https://compute.googleapis.com/compute/v1/projects/p/regions/r/forwardingRules/a",
+		},
+		"/projects/p/zones/z/operations/op-add": &compute.Operation{
+			Kind:          "compute#operation",
+			Name:          "op-add",
+			OperationType: "addInstances",
+			Status:        "DONE",
+			TargetLink:    "https://compute.googleapis.com/compute/v1/projects/p/zones/z/instanceGroups/k8s-ig",
+		},
+		"/projects/p/global/operations/op-failed": &compute.Operation{
+			Kind:          "compute#operation",
+			Name:          "op-failed",
+			OperationType: "insert",
+			Status:        "DONE",
+			TargetLink:    "https://compute.googleapis.com/compute/v1/projects/p/global/routes/r",
+			Error:         &compute.OperationError{Errors: []*compute.OperationErrorErrors{{Code: "QUOTA_EXCEEDED", Message: "Quota 'ROUTES' exceeded."}}},
+		},
+		"/projects/p/global/operations/op-running": &compute.Operation{
+			Kind:          "compute#operation",
+			Name:          "op-running",
+			OperationType: "insert",
+			Status:        "RUNNING",
+			TargetLink:    "https://compute.googleapis.com/compute/v1/projects/p/global/firewalls/k8s-fw-b",
+		},
+		"/projects/p/global/firewalls": &compute.FirewallList{Kind: "compute#firewallList"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(responses[r.URL.Path])
+	}))
+	defer server.Close()
+
+	recorder := record.NewFakeRecorder(10)
+	client := &http.Client{Transport: &mutationEventTransport{
+		base:  http.DefaultTransport,
+		cloud: &Cloud{eventRecorder: recorder},
+	}}
+	for _, tc := range []struct {
+		method, path string
+		wantEvent    string
+	}{
+		{http.MethodGet, "/projects/p/global/operations/op-insert", "Normal CloudResourceCreated GCE insert of projects/p/global/firewalls/k8s-fw-a, operation op-insert"},
+		{http.MethodPost, "/projects/p/regions/r/operations/op-delete/wait", "Normal CloudResourceDeleted GCE delete of projects/p/regions/r/forwardingRules/a, operation op-delete"},
+		{http.MethodGet, "/projects/p/zones/z/operations/op-add", "Normal CloudResourceUpdated GCE addInstances of projects/p/zones/z/instanceGroups/k8s-ig, operation op-add"},
+		{http.MethodGet, "/projects/p/global/operations/op-failed", "Warning CloudResourceMutationFailed GCE insert of projects/p/global/routes/r failed, operation op-failed: QUOTA_EXCEEDED: Quota 'ROUTES' exceeded."},
+		{http.MethodGet, "/projects/p/global/operations/op-running", ""},
+		{http.MethodGet, "/projects/p/global/firewalls", ""},
+	} {
+		req, err := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		want, err := json.Marshal(responses[tc.path])
+		require.NoError(t, err)
+		assert.JSONEq(t, string(want), string(body), "%s %s: the response is passed through", tc.method, tc.path)
+
+		select {
+		case event := <-recorder.Events:
+			require.NotEmpty(t, tc.wantEvent, "%s %s: unexpected event %q", tc.method, tc.path, event)
+			op := responses[tc.path].(*compute.Operation)
+			assert.Contains(t, event, tc.wantEvent, "%s %s", tc.method, tc.path)
+			assert.Contains(t, event, mutationOperationTypeAnnotation+":"+op.OperationType, "%s %s", tc.method, tc.path)
+			assert.Contains(t, event, mutationTargetLinkAnnotation+":"+op.TargetLink, "%s %s", tc.method, tc.path)
+		default:
+			assert.Empty(t, tc.wantEvent, "%s %s: no event", tc.method, tc.path)
+		}
+	}
+}
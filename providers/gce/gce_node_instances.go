@@ -0,0 +1,87 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// nodeInstance is the instance of a node, as named by its providerID.
+type nodeInstance struct {
+	zone string
+	name string
+}
+
+// nodeInstanceCache maps the names of the nodes to their instances, and the
+// instances back to their nodes. It is updated by the node informer from the
+// providerIDs of the nodes, which name the instances regardless of the
+// hostnames of the nodes, e.g. FQDNs. The nodes without providerID are
+// resolved by name, in all the managed zones.
+type nodeInstanceCache struct {
+	lock      sync.RWMutex
+	instances map[string]nodeInstance
+	nodes     map[nodeInstance]string
+}
+
+func (c *nodeInstanceCache) update(prevNode, newNode *v1.Node) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if prevNode != nil {
+		if inst, ok := c.instances[prevNode.Name]; ok && c.nodes[inst] == prevNode.Name {
+			delete(c.nodes, inst)
+		}
+		delete(c.instances, prevNode.Name)
+	}
+	if newNode == nil || newNode.Spec.ProviderID == "" {
+		return
+	}
+	_, zone, name, err := splitProviderID(newNode.Spec.ProviderID)
+	if err != nil {
+		return
+	}
+	if c.instances == nil {
+		c.instances = map[string]nodeInstance{}
+		c.nodes = map[nodeInstance]string{}
+	}
+	inst := nodeInstance{zone: zone, name: canonicalizeInstanceName(name)}
+	c.instances[newNode.Name] = inst
+	c.nodes[inst] = newNode.Name
+}
+
+// get returns the instance of the named node, if its providerID is known.
+func (c *nodeInstanceCache) get(nodeName string) (nodeInstance, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	inst, ok := c.instances[nodeName]
+	return inst, ok
+}
+
+// nodeName returns the name of the node of the instance. The instances of
+// the unknown nodes are named like their nodes, see MapNodeNameToInstanceName.
+func (c *nodeInstanceCache) nodeName(zone, instanceName string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if nodeName, ok := c.nodes[nodeInstance{zone: zone, name: instanceName}]; ok {
+		return nodeName
+	}
+	return instanceName
+}
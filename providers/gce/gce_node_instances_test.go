@@ -0,0 +1,128 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestNodeInstanceCache(t *testing.T) {
+	var c nodeInstanceCache
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1.example.com"},
+		Spec:       v1.NodeSpec{ProviderID: "gce://test-project/us-central1-b/instance-1"},
+	}
+	_, ok := c.get(node.Name)
+	assert.False(t, ok, "the cache is empty")
+
+	c.update(nil, node)
+	inst, ok := c.get(node.Name)
+	assert.True(t, ok)
+	assert.Equal(t, nodeInstance{zone: "us-central1-b", name: "instance-1"}, inst)
+	assert.Equal(t, node.Name, c.nodeName("us-central1-b", "instance-1"))
+	assert.Equal(t, "instance-1", c.nodeName("us-central1-c", "instance-1"), "the instances are named per zone")
+
+	moved := node.DeepCopy()
+	moved.Spec.ProviderID = "gce://test-project/us-central1-c/instance-2"
+	c.update(node, moved)
+	inst, _ = c.get(node.Name)
+	assert.Equal(t, nodeInstance{zone: "us-central1-c", name: "instance-2"}, inst)
+	assert.Equal(t, "instance-1", c.nodeName("us-central1-b", "instance-1"), "the previous instance is removed")
+
+	invalid := node.DeepCopy()
+	invalid.Spec.ProviderID = "aws:///us-east-1a/i-123"
+	c.update(moved, invalid)
+	_, ok = c.get(node.Name)
+	assert.False(t, ok, "the nodes with an invalid providerID are not cached")
+
+	c.update(moved, nil)
+	_, ok = c.get(node.Name)
+	assert.False(t, ok, "the deleted nodes are removed")
+	assert.Equal(t, "instance-2", c.nodeName("us-central1-c", "instance-2"))
+}
+
+func TestGetInstancesOfCachedNodes(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	// The hostname of the node does not match the name of its instance.
+	require.NoError(t, gce.InsertInstance(gce.ProjectID(), vals.ZoneName, &compute.Instance{Name: "instance-1", Zone: vals.ZoneName}))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1.example.com"},
+		Spec:       v1.NodeSpec{ProviderID: "gce://test-project/" + vals.ZoneName + "/instance-1"},
+	}
+	_, err = gce.getInstanceByName(node.Name)
+	assert.Equal(t, cloudprovider.InstanceNotFound, err, "the node is resolved by name without providerID")
+
+	gce.nodeInstances.update(nil, node)
+	listedZones := []string{}
+	gce.c.(*cloud.MockGCE).MockInstances.ListHook = func(ctx context.Context, zone string, fl *filter.F, m *cloud.MockInstances, options ...cloud.Option) (bool, []*compute.Instance, error) {
+		listedZones = append(listedZones, zone)
+		return false, nil, nil
+	}
+	hosts, err := gce.getFoundInstanceByNames([]string{node.Name})
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "instance-1", hosts[0].Name)
+	assert.Equal(t, []string{vals.ZoneName}, listedZones, "only the zone of the node is listed")
+
+	instance, err := gce.getInstanceByName(node.Name)
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", instance.Name)
+	assert.Equal(t, vals.ZoneName, instance.Zone)
+}
+
+func TestListRoutesOfFQDNNodes(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	_, err = createAndInsertNodes(gce, []string{"node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1.c.test-project.internal"},
+		Spec:       v1.NodeSpec{ProviderID: "gce://test-project/" + vals.ZoneName + "/node-1"},
+	}
+	route := &cloudprovider.Route{TargetNode: types.NodeName(node.Name), DestinationCIDR: "10.0.1.0/24"}
+	require.NoError(t, gce.CreateRoute(context.TODO(), vals.ClusterName, "hint", route), "the instance of the node is resolved from its FQDN")
+
+	routes, err := gce.ListRoutes(context.TODO(), vals.ClusterName)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, types.NodeName("node-1"), routes[0].TargetNode, "the routes of unknown nodes target the instance name")
+
+	gce.nodeInstances.update(nil, node)
+	routes, err = gce.ListRoutes(context.TODO(), vals.ClusterName)
+	require.NoError(t, err)
+	require.Len(t, routes, 1)
+	assert.Equal(t, route.TargetNode, routes[0].TargetNode)
+}
@@ -0,0 +1,80 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NodeConditionZoneOutsideRegion is the condition set on the nodes whose
+	// zone is outside the region of the cluster. Such nodes are excluded from
+	// the load balancers, whose instance groups and target pools only contain
+	// instances of the region.
+	NodeConditionZoneOutsideRegion v1.NodeConditionType = "ZoneOutsideRegion"
+)
+
+// MultiRegion returns true if the cluster intentionally spans several
+// regions, i.e. the nodes outside the region are expected.
+func (g *Cloud) MultiRegion() bool {
+	return g.multiRegion
+}
+
+// NodeOutsideRegion returns the zone of the node if it is outside the region
+// of the cluster, and "" otherwise, e.g. for the nodes without zone label.
+func (g *Cloud) NodeOutsideRegion(node *v1.Node) string {
+	return nodeOutsideRegion(node, g.region)
+}
+
+// nodeOutsideRegion returns the zone of the node if it is outside region, and
+// "" otherwise, e.g. for the nodes without zone label.
+func nodeOutsideRegion(node *v1.Node, region string) string {
+	zone := getZone(node)
+	if zone == emptyZone {
+		return ""
+	}
+	if zoneRegion, err := GetGCERegion(zone); err == nil && zoneRegion == region {
+		return ""
+	}
+	return zone
+}
+
+// filterNodesInRegion returns the nodes in the region of the load balancer,
+// by default the region of the cluster. The other nodes cannot be added to
+// the load balancers of the region.
+func (g *Cloud) filterNodesInRegion(loadBalancerName, region string, nodes []*v1.Node) []*v1.Node {
+	var filtered []*v1.Node
+	for _, node := range nodes {
+		zone := nodeOutsideRegion(node, region)
+		if zone == "" {
+			filtered = append(filtered, node)
+			continue
+		}
+		// The nodes are reported by the NodeConditionZoneOutsideRegion
+		// condition, unless the cluster spans several regions. The backends
+		// of the regional load balancers are in their region, in
+		// multi-region mode the nodes of another region are load balanced
+		// by the internal load balancers pinned to it with
+		// ServiceAnnotationLoadBalancerRegion.
+		klog.V(2).Infof("filterNodesInRegion(%s): Excluding node %s of zone %s outside region %s (multi-region: %t)", loadBalancerName, node.Name, zone, region, g.multiRegion)
+	}
+	return filtered
+}
@@ -0,0 +1,57 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFilterNodesInRegion(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+
+	node := func(name, zone string) *v1.Node {
+		n := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+		if zone != "" {
+			n.Labels[v1.LabelTopologyZone] = zone
+		}
+		return n
+	}
+	inRegion := node("in-region", vals.ZoneName)
+	outsideRegion := node("outside-region", "europe-west1-b")
+	noZone := node("no-zone", "")
+
+	assert.Empty(t, gce.NodeOutsideRegion(inRegion))
+	assert.Equal(t, "europe-west1-b", gce.NodeOutsideRegion(outsideRegion))
+	assert.Empty(t, gce.NodeOutsideRegion(noZone), "the nodes without zone are not reported")
+	assert.Equal(t, []*v1.Node{inRegion, noZone}, gce.filterNodesInRegion("lb", gce.region, []*v1.Node{inRegion, outsideRegion, noZone}))
+
+	gce.multiRegion = true
+	assert.Equal(t, []*v1.Node{inRegion, noZone}, gce.filterNodesInRegion("lb", gce.region, []*v1.Node{inRegion, outsideRegion, noZone}), "the load balancers only span the region in multi-region mode")
+	assert.Equal(t, []*v1.Node{outsideRegion, noZone}, gce.filterNodesInRegion("lb", "europe-west1", []*v1.Node{inRegion, outsideRegion, noZone}), "the load balancers pinned to another region span its nodes")
+}
@@ -0,0 +1,113 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// gceErrorHints are the troubleshooting hints of the GCE errors of the load
+// balancer resources. They are keyed by the error code of the failed
+// operations, and by the error reason of the rejected API calls.
+var gceErrorHints = map[string]string{
+	"IP_IN_USE_BY_ANOTHER_RESOURCE":       "another forwarding rule uses this address",
+	"IP_SPACE_EXHAUSTED":                  "the subnetwork has no free address, expand its range or release unused addresses",
+	"QUOTA_EXCEEDED":                      "a quota of the project is exhausted, release unused resources or request a quota increase",
+	"RESOURCE_ALREADY_EXISTS":             "a resource with the same name exists, e.g. left over by a previous load balancer",
+	"RESOURCE_IN_USE_BY_ANOTHER_RESOURCE": "the resource is still referenced, e.g. a target pool by a forwarding rule",
+	"RESOURCE_NOT_READY":                  "the resource is being modified by another operation, the sync is retried",
+	"alreadyExists":                       "a resource with the same name exists, e.g. left over by a previous load balancer",
+	"forbidden":                           "the service account of the controller manager lacks an IAM permission",
+	"quotaExceeded":                       "a quota of the project is exhausted, release unused resources or request a quota increase",
+	"rateLimitExceeded":                   "the Compute API rate limit of the project is exceeded, the sync is retried",
+	"resourceInUseByAnotherResource":      "the resource is still referenced, e.g. a target pool by a forwarding rule",
+	"resourceNotReady":                    "the resource is being modified by another operation, the sync is retried",
+}
+
+var (
+	// operationErrorRE matches the errors of failed operations, whose message
+	// is "<code> - <message>", see k8s-cloud-provider's operation.isDone.
+	operationErrorRE = regexp.MustCompile(`googleapi: Error (\d+): ([A-Z][A-Z0-9_]+) - `)
+	// apiErrorReasonRE matches the errors of rejected API calls.
+	apiErrorReasonRE = regexp.MustCompile(`googleapi: Error (\d+): (?s:.*?)Reason: (\w+)`)
+)
+
+// gceErrorCode returns the error code and the HTTP status of a GCE API error,
+// and whether the error is the result of a failed operation. The load
+// balancer methods mostly format the GCE errors into their own errors, so the
+// error message is parsed when no googleapi.Error is wrapped.
+func gceErrorCode(err error) (string, int, bool) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if m := operationErrorRE.FindStringSubmatch(apiErr.Error()); m != nil {
+			return m[2], apiErr.Code, true
+		}
+		for _, item := range apiErr.Errors {
+			if item.Reason != "" {
+				return item.Reason, apiErr.Code, false
+			}
+		}
+		return "", apiErr.Code, false
+	}
+	msg := err.Error()
+	if m := operationErrorRE.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return m[2], status, true
+	}
+	if m := apiErrorReasonRE.FindStringSubmatch(msg); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return m[2], status, false
+	}
+	return "", 0, false
+}
+
+// describeGCEError adds the GCE error code, the HTTP status and a
+// troubleshooting hint to the GCE errors returned by the load balancer
+// methods. The service controller records the returned errors in the events
+// of the Service, so that the load balancer failures are diagnosable without
+// the logs of the controller manager.
+//
+// k8s-cloud-provider does not return the failed operations, so the events
+// point at the operations of the load balancer resources instead of the link
+// of the failed operation.
+func (g *Cloud) describeGCEError(loadBalancerName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	code, status, fromOperation := gceErrorCode(err)
+	if code == "" {
+		return err
+	}
+
+	details := []string{fmt.Sprintf("GCE error %s (HTTP %d)", code, status)}
+	if hint, ok := gceErrorHints[code]; ok {
+		details = append(details, "hint: "+hint)
+	}
+	if fromOperation {
+		details = append(details, fmt.Sprintf("operations: gcloud compute operations list --project %s --filter 'targetLink~%s AND error.errors.code=%s'", g.projectID, loadBalancerName, code))
+	}
+	return fmt.Errorf("%w [%s]", err, strings.Join(details, "; "))
+}
@@ -0,0 +1,105 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestDescribeGCEError(t *testing.T) {
+	t.Parallel()
+
+	gce, err := fakeGCECloud(DefaultTestClusterValues())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{
+			desc: "failed operation",
+			err:  &googleapi.Error{Code: http.StatusBadRequest, Message: "IP_IN_USE_BY_ANOTHER_RESOURCE - IP '1.2.3.4' is already being used by another resource."},
+			want: "googleapi: Error 400: IP_IN_USE_BY_ANOTHER_RESOURCE - IP '1.2.3.4' is already being used by another resource. [GCE error IP_IN_USE_BY_ANOTHER_RESOURCE (HTTP 400); hint: another forwarding rule uses this address; operations: gcloud compute operations list --project test-project --filter 'targetLink~lb AND error.errors.code=IP_IN_USE_BY_ANOTHER_RESOURCE']",
+		},
+		{
+			desc: "failed operation without hint",
+			err:  fmt.Errorf("failed to create: %w", &googleapi.Error{Code: http.StatusBadRequest, Message: "UNKNOWN_CODE - failed."}),
+			want: "failed to create: googleapi: Error 400: UNKNOWN_CODE - failed. [GCE error UNKNOWN_CODE (HTTP 400); operations: gcloud compute operations list --project test-project --filter 'targetLink~lb AND error.errors.code=UNKNOWN_CODE']",
+		},
+		{
+			desc: "rejected API call",
+			err:  &googleapi.Error{Code: http.StatusConflict, Message: "The resource already exists", Errors: []googleapi.ErrorItem{{Reason: "alreadyExists"}}},
+			want: "googleapi: Error 409: The resource already exists\nMore details:\nReason: alreadyExists, Message: \n [GCE error alreadyExists (HTTP 409); hint: a resource with the same name exists, e.g. left over by a previous load balancer]",
+		},
+		{
+			desc: "formatted rejected API call",
+			err:  fmt.Errorf("failed to get: %v", &googleapi.Error{Code: http.StatusForbidden, Message: "Required permission", Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}),
+			want: "failed to get: googleapi: Error 403: Required permission\nMore details:\nReason: forbidden, Message: \n [GCE error forbidden (HTTP 403); hint: the service account of the controller manager lacks an IAM permission]",
+		},
+		{
+			desc: "API error without code",
+			err:  &googleapi.Error{Code: http.StatusInternalServerError, Message: "internal error"},
+			want: "googleapi: Error 500: internal error",
+		},
+		{
+			desc: "not a GCE error",
+			err:  errors.New("failed"),
+			want: "failed",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := gce.describeGCEError("lb", tc.err)
+			assert.EqualError(t, err, tc.want)
+			assert.ErrorIs(t, err, tc.err, "the original error is wrapped")
+		})
+	}
+	assert.NoError(t, gce.describeGCEError("lb", nil))
+}
+
+func TestEnsureLoadBalancerDescribesOperationError(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	nodes, err := createAndInsertNodes(gce, []string{"test-node-1"}, vals.ZoneName)
+	require.NoError(t, err)
+
+	gce.c.(*cloud.MockGCE).MockForwardingRules.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.ForwardingRule, m *cloud.MockForwardingRules, options ...cloud.Option) (bool, error) {
+		return true, &googleapi.Error{Code: http.StatusBadRequest, Message: "IP_IN_USE_BY_ANOTHER_RESOURCE - IP is already being used by another resource."}
+	}
+	svc := fakeLoadbalancerService("")
+	_, err = gce.EnsureLoadBalancer(context.Background(), vals.ClusterName, svc, nodes)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "[GCE error IP_IN_USE_BY_ANOTHER_RESOURCE (HTTP 400); hint: another forwarding rule uses this address;")
+	assert.Contains(t, err.Error(), "targetLink~"+gce.GetLoadBalancerName(context.Background(), vals.ClusterName, svc))
+}
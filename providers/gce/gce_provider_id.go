@@ -0,0 +1,166 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ProviderIDParser splits the providerIDs which are not in the format set by
+// the kubelet, '${ProviderName}://${project-id}/${zone}/${instance-name}', e.g.
+// the providerIDs set by other installers on the nodes of the adopted clusters.
+type ProviderIDParser interface {
+	// ParseProviderID returns the project, zone and name of the instance of
+	// the providerID, or false if the providerID is not in its format.
+	ParseProviderID(providerID string) (project, zone, instance string, ok bool)
+}
+
+// ProviderIDParserFunc is a function implementing ProviderIDParser.
+type ProviderIDParserFunc func(providerID string) (project, zone, instance string, ok bool)
+
+// ParseProviderID calls f, part of the ProviderIDParser interface.
+func (f ProviderIDParserFunc) ParseProviderID(providerID string) (string, string, string, bool) {
+	return f(providerID)
+}
+
+var (
+	providerIDParsersLock sync.RWMutex
+	// providerIDParsers are tried in order on the providerIDs which are not
+	// in the standard format.
+	providerIDParsers = []ProviderIDParser{
+		ProviderIDParserFunc(parseResourcePathProviderID),
+		ProviderIDParserFunc(parsePrefixedProviderID),
+	}
+
+	// providerIDPrefixes are the prefixes accepted instead of
+	// '${ProviderName}://', set by flag.
+	providerIDPrefixes = providerIDPrefixesValue{}
+
+	// resourcePathProviderIDRE matches the providerIDs holding the resource
+	// path of the instance, e.g. 'gce://projects/123/zones/us-central1-a/instances/node-1'.
+	resourcePathProviderIDRE = regexp.MustCompile(`^` + ProviderName + `://projects/([^/]+)/zones/([^/]+)/instances/([^/]+)$`)
+)
+
+func init() {
+	flag.Var(&providerIDPrefixes, "cloud-provider-gce-provider-id-prefixes", "Comma-separated prefixes of the providerIDs of the nodes accepted instead of '"+ProviderName+"://', for the clusters adopted from other installers")
+}
+
+// RegisterProviderIDParser adds a parser for the providerIDs which are not in
+// the standard format, tried after the built-in ones: the resource path of the
+// instance, e.g. 'gce://projects/123/zones/us-central1-a/instances/node-1', and
+// the prefixes of --cloud-provider-gce-provider-id-prefixes.
+func RegisterProviderIDParser(parser ProviderIDParser) {
+	providerIDParsersLock.Lock()
+	defer providerIDParsersLock.Unlock()
+	providerIDParsers = append(providerIDParsers, parser)
+}
+
+// SplitProviderID returns the project, zone and name of the instance of the
+// providerID, in the standard format or in the formats of the
+// ProviderIDParsers.
+func SplitProviderID(providerID string) (project, zone, instance string, err error) {
+	return splitProviderID(providerID)
+}
+
+// parseNonStandardProviderID splits the providerID with the parsers of the
+// non-standard formats.
+func parseNonStandardProviderID(providerID string) (project, zone, instance string, ok bool) {
+	providerIDParsersLock.RLock()
+	parsers := providerIDParsers
+	providerIDParsersLock.RUnlock()
+	for _, parser := range parsers {
+		if project, zone, instance, ok = parser.ParseProviderID(providerID); ok {
+			return project, zone, instance, true
+		}
+	}
+	return "", "", "", false
+}
+
+// parseResourcePathProviderID splits the providerIDs holding the resource
+// path of the instance.
+func parseResourcePathProviderID(providerID string) (project, zone, instance string, ok bool) {
+	matches := resourcePathProviderIDRE.FindStringSubmatch(providerID)
+	if len(matches) != 4 {
+		return "", "", "", false
+	}
+	return matches[1], matches[2], matches[3], true
+}
+
+// parsePrefixedProviderID splits the providerIDs with one of the prefixes of
+// --cloud-provider-gce-provider-id-prefixes, followed by the standard format or
+// the resource path of the instance.
+func parsePrefixedProviderID(providerID string) (project, zone, instance string, ok bool) {
+	for _, prefix := range providerIDPrefixes.prefixes() {
+		if !strings.HasPrefix(providerID, prefix) {
+			continue
+		}
+		providerID = ProviderName + "://" + strings.TrimPrefix(providerID, prefix)
+		if matches := providerIDRE.FindStringSubmatch(providerID); len(matches) == 4 {
+			return matches[1], matches[2], matches[3], true
+		}
+		return parseResourcePathProviderID(providerID)
+	}
+	return "", "", "", false
+}
+
+// providerIDPrefixesValue is a providerID prefixes flag.
+type providerIDPrefixesValue struct {
+	lock sync.RWMutex
+	list []string
+}
+
+// String is the method to format the flag's value, part of the flag.Value interface.
+func (v *providerIDPrefixesValue) String() string {
+	return strings.Join(v.prefixes(), ",")
+}
+
+// Set supports a comma-separated list of prefixes, part of the flag.Value interface.
+func (v *providerIDPrefixesValue) Set(val string) error {
+	var list []string
+	for _, prefix := range strings.Split(val, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if !strings.HasSuffix(prefix, "/") {
+			return fmt.Errorf("providerID prefix %q must end with '/', e.g. 'gcp://'", prefix)
+		}
+		list = append(list, prefix)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.list = list
+	return nil
+}
+
+// Type is the type of the flag, part of the pflag.Value interface.
+func (v *providerIDPrefixesValue) Type() string {
+	return "stringSlice"
+}
+
+func (v *providerIDPrefixesValue) prefixes() []string {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.list
+}
@@ -0,0 +1,110 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitNonStandardProviderID(t *testing.T) {
+	if err := providerIDPrefixes.Set("gcp://,openshift://gce/"); err != nil {
+		t.Fatalf("providerIDPrefixes.Set() = %v", err)
+	}
+	t.Cleanup(func() { providerIDPrefixes.Set("") })
+	savedParsers := providerIDParsers
+	t.Cleanup(func() { providerIDParsers = savedParsers })
+	RegisterProviderIDParser(ProviderIDParserFunc(func(providerID string) (string, string, string, bool) {
+		parts := strings.Split(strings.TrimPrefix(providerID, "custom:"), ":")
+		if !strings.HasPrefix(providerID, "custom:") || len(parts) != 3 {
+			return "", "", "", false
+		}
+		return parts[0], parts[1], parts[2], true
+	}))
+
+	for _, tc := range []struct {
+		desc       string
+		providerID string
+		want       []string
+		fail       bool
+	}{
+		{
+			desc:       "standard",
+			providerID: "gce://project-example/us-central1-a/node-1",
+			want:       []string{"project-example", "us-central1-a", "node-1"},
+		},
+		{
+			desc:       "resource path with project number",
+			providerID: "gce://projects/123456789/zones/us-central1-a/instances/node-1",
+			want:       []string{"123456789", "us-central1-a", "node-1"},
+		},
+		{
+			desc:       "custom scheme",
+			providerID: "gcp://project-example/us-central1-a/node-1",
+			want:       []string{"project-example", "us-central1-a", "node-1"},
+		},
+		{
+			desc:       "custom prefix with resource path",
+			providerID: "openshift://gce/projects/project-example/zones/us-central1-a/instances/node-1",
+			want:       []string{"project-example", "us-central1-a", "node-1"},
+		},
+		{
+			desc:       "registered parser",
+			providerID: "custom:project-example:us-central1-a:node-1",
+			want:       []string{"project-example", "us-central1-a", "node-1"},
+		},
+		{
+			desc:       "unknown prefix",
+			providerID: "aws://project-example/us-central1-a/node-1",
+			fail:       true,
+		},
+		{
+			desc:       "incomplete resource path",
+			providerID: "gce://projects/project-example/zones/us-central1-a/node-1",
+			fail:       true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			project, zone, instance, err := splitProviderID(tc.providerID)
+			if (err != nil) != tc.fail {
+				t.Fatalf("splitProviderID(%q) = %v, want fail %v", tc.providerID, err, tc.fail)
+			}
+			if tc.fail {
+				return
+			}
+			if got := []string{project, zone, instance}; strings.Join(got, "/") != strings.Join(tc.want, "/") {
+				t.Errorf("splitProviderID(%q) = %v, want %v", tc.providerID, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProviderIDPrefixesValue(t *testing.T) {
+	var v providerIDPrefixesValue
+	if err := v.Set("gcp:// , openshift://gce/"); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if got, want := v.String(), "gcp://,openshift://gce/"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if err := v.Set("gcp:"); err == nil {
+		t.Errorf("Set(%q) = nil, want an error", "gcp:")
+	}
+}
@@ -0,0 +1,83 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// gceInstanceFields are the fields of the instances making a gceInstance.
+	gceInstanceFields = "zone,name,id,disks(deviceName),machineType,networkInterfaces(subnetwork)"
+	// instanceTagsFields are the fields of the instances used to compute the
+	// host tags.
+	instanceTagsFields = "name,tags"
+	// instanceNameFields are the fields of the instances only listed by name.
+	instanceNameFields = "name"
+	// instanceGroupInstanceFields are the fields of the instances of an
+	// instance group.
+	instanceGroupInstanceFields = "instance"
+	// routeFields are the fields of the routes making a cloudprovider.Route.
+	routeFields = "name,nextHopInstance,destRange"
+)
+
+// responseFieldsKey is the context key of the fields of the responses of the
+// Compute API calls.
+type responseFieldsKey struct{}
+
+// withResponseFields returns a context under which the Compute API calls only
+// fetch the fields of their response, a partial response selector such as
+// "name,tags". The hot paths reading many resources use it so that the fields
+// they do not use, e.g. the metadata of the instances, are not transferred. It
+// must only be used for the read calls, the operations of the mutations are
+// polled under the context of the call.
+func withResponseFields(ctx context.Context, fields string) context.Context {
+	return context.WithValue(ctx, responseFieldsKey{}, fields)
+}
+
+// withListResponseFields is withResponseFields for the list calls, with the
+// fields of the items of the pages. The token of the next page is always
+// fetched.
+func withListResponseFields(ctx context.Context, itemFields string) context.Context {
+	return withResponseFields(ctx, fmt.Sprintf("items(%s),nextPageToken", itemFields))
+}
+
+// responseFieldsTransport sets the fields parameter of the Compute API
+// requests made under withResponseFields, as k8s-cloud-provider does not
+// expose the parameter of its calls.
+type responseFieldsTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *responseFieldsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields, ok := req.Context().Value(responseFieldsKey{}).(string)
+	if !ok || fields == "" {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it is given.
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	query.Set("fields", fields)
+	req.URL.RawQuery = query.Encode()
+	return t.base.RoundTrip(req)
+}
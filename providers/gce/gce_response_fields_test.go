@@ -0,0 +1,59 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+func TestResponseFieldsTransport(t *testing.T) {
+	t.Parallel()
+
+	var fields []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields = append(fields, r.URL.Query().Get("fields"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"instance-1","items":[{"name":"instance-1"}]}`))
+	}))
+	defer server.Close()
+
+	service, err := compute.NewService(context.Background(),
+		option.WithHTTPClient(&http.Client{Transport: &responseFieldsTransport{base: http.DefaultTransport}}),
+		option.WithEndpoint(server.URL+"/"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = service.Instances.Get("project", "zone", "instance-1").Context(withResponseFields(ctx, gceInstanceFields)).Do()
+	require.NoError(t, err)
+	err = service.Instances.List("project", "zone").Pages(withListResponseFields(ctx, instanceNameFields), func(*compute.InstanceList) error { return nil })
+	require.NoError(t, err)
+	_, err = service.Instances.Get("project", "zone", "instance-1").Context(ctx).Do()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{gceInstanceFields, "items(name),nextPageToken", ""}, fields)
+}
@@ -0,0 +1,118 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// RetryPolicyForwardingRule is the resource of the retry policy of the
+	// creations and deletions of the regional forwarding rules.
+	RetryPolicyForwardingRule = "forwarding-rule"
+	// RetryPolicyAddress is the resource of the retry policy of the
+	// reservations and deletions of the regional addresses.
+	RetryPolicyAddress = "address"
+
+	// retryPolicyInitialBackoff is the delay before the first retry, doubled
+	// at each retry.
+	retryPolicyInitialBackoff = time.Second
+)
+
+// ConfigRetryPolicy is the "retry-policy" section of the cloud config file
+// of a resource, e.g.
+//
+//	[retry-policy "address"]
+//	max-attempts = 3
+//	retryable-error-code = RESOURCE_NOT_READY
+//	retryable-error-code = 503
+//
+// The operations failing with one of the retryable error codes, either a
+// GCE error code or a HTTP status code, are retried up to max-attempts
+// attempts. They are not retried by default, the service controller then
+// retries the sync of the Service instead.
+type ConfigRetryPolicy struct {
+	MaxAttempts        int      `gcfg:"max-attempts"`
+	RetryableErrorCode []string `gcfg:"retryable-error-code"`
+}
+
+// retryPolicy retries the operations of a resource.
+type retryPolicy struct {
+	maxAttempts    int
+	retryableCodes sets.String
+	backoff        time.Duration
+}
+
+// newRetryPolicies validates the retry policies of the cloud config.
+func newRetryPolicies(config map[string]*ConfigRetryPolicy) (map[string]*retryPolicy, error) {
+	policies := map[string]*retryPolicy{}
+	for resource, c := range config {
+		switch resource {
+		case RetryPolicyForwardingRule, RetryPolicyAddress:
+		default:
+			return nil, fmt.Errorf("unsupported retry-policy resource %q, expected %q or %q", resource, RetryPolicyForwardingRule, RetryPolicyAddress)
+		}
+		if c.MaxAttempts < 1 {
+			return nil, fmt.Errorf("max-attempts of retry-policy %q must be positive: %d", resource, c.MaxAttempts)
+		}
+		policies[resource] = &retryPolicy{
+			maxAttempts:    c.MaxAttempts,
+			retryableCodes: sets.NewString(c.RetryableErrorCode...),
+			backoff:        retryPolicyInitialBackoff,
+		}
+	}
+	return policies, nil
+}
+
+// retryable returns true if the error has one of the retryable error codes.
+func (p *retryPolicy) retryable(err error) bool {
+	code, status, _ := gceErrorCode(err)
+	return (code != "" && p.retryableCodes.Has(code)) || (status != 0 && p.retryableCodes.Has(strconv.Itoa(status)))
+}
+
+// withRetryPolicy runs an operation of the resource, retried according to its
+// retry policy if any.
+func (g *Cloud) withRetryPolicy(resource, operation string, fn func() error) error {
+	p := g.retryPolicies[resource]
+	if p == nil || p.maxAttempts <= 1 {
+		return fn()
+	}
+
+	var err error
+	attempt := 0
+	backoff := wait.Backoff{Duration: p.backoff, Factor: 2, Steps: p.maxAttempts}
+	_ = wait.ExponentialBackoff(backoff, func() (bool, error) {
+		attempt++
+		if err = fn(); err == nil || !p.retryable(err) {
+			return true, nil
+		}
+		if attempt < p.maxAttempts {
+			klog.V(2).Infof("Retrying %s of %s (attempt %d of %d): %v", operation, resource, attempt+1, p.maxAttempts, err)
+		}
+		return false, nil
+	})
+	return err
+}
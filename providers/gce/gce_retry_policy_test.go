@@ -0,0 +1,155 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestReadConfigFileRetryPolicy(t *testing.T) {
+	const s = `[Global]
+project-id = my-project
+
+[retry-policy "address"]
+max-attempts = 3
+retryable-error-code = RESOURCE_NOT_READY
+retryable-error-code = 503
+`
+	config, err := readConfig(strings.NewReader(s))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*ConfigRetryPolicy{
+		RetryPolicyAddress: {MaxAttempts: 3, RetryableErrorCode: []string{"RESOURCE_NOT_READY", "503"}},
+	}, config.RetryPolicy)
+}
+
+func TestNewRetryPolicies(t *testing.T) {
+	for desc, tc := range map[string]struct {
+		config    map[string]*ConfigRetryPolicy
+		expectErr bool
+	}{
+		"No policy": {},
+		"Policies": {
+			config: map[string]*ConfigRetryPolicy{
+				RetryPolicyAddress:        {MaxAttempts: 1},
+				RetryPolicyForwardingRule: {MaxAttempts: 5, RetryableErrorCode: []string{"RESOURCE_NOT_READY"}},
+			},
+		},
+		"Unsupported resource": {
+			config:    map[string]*ConfigRetryPolicy{"firewall": {MaxAttempts: 3}},
+			expectErr: true,
+		},
+		"Invalid max attempts": {
+			config:    map[string]*ConfigRetryPolicy{RetryPolicyAddress: {}},
+			expectErr: true,
+		},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			policies, err := newRetryPolicies(tc.config)
+			assert.Equal(t, tc.expectErr, err != nil, err)
+			if !tc.expectErr {
+				assert.Len(t, policies, len(tc.config))
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	notReady := &googleapi.Error{Code: http.StatusBadRequest, Message: "RESOURCE_NOT_READY - The resource is not ready."}
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "backend error"}
+	ipInUse := &googleapi.Error{Code: http.StatusBadRequest, Message: "IP_IN_USE_BY_ANOTHER_RESOURCE - IP is already being used by another resource."}
+
+	for desc, tc := range map[string]struct {
+		errs         []error
+		expectErr    error
+		expectedCall int
+	}{
+		"Success": {
+			expectedCall: 1,
+		},
+		"Retried until success": {
+			errs:         []error{notReady, unavailable},
+			expectedCall: 3,
+		},
+		"Retried up to max attempts": {
+			errs:         []error{notReady, notReady, notReady, notReady},
+			expectErr:    notReady,
+			expectedCall: 3,
+		},
+		"Not retryable error": {
+			errs:         []error{ipInUse},
+			expectErr:    ipInUse,
+			expectedCall: 1,
+		},
+	} {
+		tc := tc
+		t.Run(desc, func(t *testing.T) {
+			t.Parallel()
+
+			vals := DefaultTestClusterValues()
+			gce, err := fakeGCECloud(vals)
+			require.NoError(t, err)
+			gce.retryPolicies = map[string]*retryPolicy{
+				RetryPolicyAddress: {maxAttempts: 3, retryableCodes: sets.NewString("RESOURCE_NOT_READY", "503"), backoff: time.Millisecond},
+			}
+			calls := 0
+			gce.c.(*cloud.MockGCE).MockAddresses.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.Address, m *cloud.MockAddresses, options ...cloud.Option) (bool, error) {
+				calls++
+				if calls <= len(tc.errs) {
+					return true, tc.errs[calls-1]
+				}
+				return false, nil
+			}
+
+			err = gce.ReserveRegionAddress(&compute.Address{Name: "address"}, vals.Region)
+			assert.Equal(t, tc.expectErr, err)
+			assert.Equal(t, tc.expectedCall, calls)
+		})
+	}
+}
+
+func TestRetryPolicyNotConfigured(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	calls := 0
+	gce.c.(*cloud.MockGCE).MockForwardingRules.DeleteHook = func(ctx context.Context, key *meta.Key, m *cloud.MockForwardingRules, options ...cloud.Option) (bool, error) {
+		calls++
+		return true, &googleapi.Error{Code: http.StatusServiceUnavailable}
+	}
+
+	assert.Error(t, gce.DeleteRegionForwardingRule("rule", vals.Region))
+	assert.Equal(t, 1, calls, "the operations are not retried by default")
+}
@@ -0,0 +1,134 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	compute "google.golang.org/api/compute/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Cloud NAT source ranges which cover every subnetwork of the network.
+	natAllSubnetworksAllIPRanges        = "ALL_SUBNETWORKS_ALL_IP_RANGES"
+	natAllSubnetworksAllPrimaryIPRanges = "ALL_SUBNETWORKS_ALL_PRIMARY_IP_RANGES"
+
+	// routerCacheTTL bounds how long listed routers are reused, so that mass
+	// node registrations do not list the routers for every node.
+	routerCacheTTL = 5 * time.Minute
+)
+
+// routerCache holds the routers listed per region. The zero value is ready to use.
+type routerCache struct {
+	lock    sync.Mutex
+	entries map[string]routerCacheEntry
+}
+
+type routerCacheEntry struct {
+	routers []*compute.Router
+	expiry  time.Time
+}
+
+func newRouterMetricContext(request, region string) *metricContext {
+	return newGenericMetricContext("routers", request, region, unusedMetricLabel, computeV1Version)
+}
+
+// ListRouters returns the Cloud Routers of the given region.
+func (g *Cloud) ListRouters(region string) ([]*compute.Router, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newRouterMetricContext("list", region)
+	v, err := g.c.Routers().List(ctx, region, filter.None)
+	return v, mc.Observe(err)
+}
+
+// listRoutersCached returns the routers of the region, listing them at most
+// once per routerCacheTTL.
+func (g *Cloud) listRoutersCached(region string) ([]*compute.Router, error) {
+	g.routerCache.lock.Lock()
+	defer g.routerCache.lock.Unlock()
+
+	if entry, ok := g.routerCache.entries[region]; ok && time.Now().Before(entry.expiry) {
+		return entry.routers, nil
+	}
+	routers, err := g.ListRouters(region)
+	if err != nil {
+		return nil, err
+	}
+	if g.routerCache.entries == nil {
+		g.routerCache.entries = map[string]routerCacheEntry{}
+	}
+	g.routerCache.entries[region] = routerCacheEntry{routers: routers, expiry: time.Now().Add(routerCacheTTL)}
+	return routers, nil
+}
+
+// routersHaveCloudNAT returns true if one of the routers configures a Cloud NAT
+// gateway serving the primary range of the given subnetwork.
+func routersHaveCloudNAT(routers []*compute.Router, networkURL, subnetworkURL string) bool {
+	for _, router := range routers {
+		if !resourceURLsEqual(router.Network, networkURL) {
+			continue
+		}
+		for _, nat := range router.Nats {
+			switch nat.SourceSubnetworkIpRangesToNat {
+			case natAllSubnetworksAllIPRanges, natAllSubnetworksAllPrimaryIPRanges:
+				return true
+			}
+			for _, subnet := range nat.Subnetworks {
+				if resourceURLsEqual(subnet.Name, subnetworkURL) && natsPrimaryRange(subnet) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// natsPrimaryRange returns true if the primary range of the subnetwork, used by
+// the nodes, is translated by the NAT gateway.
+func natsPrimaryRange(subnet *compute.RouterNatSubnetworkToNat) bool {
+	for _, r := range subnet.SourceIpRangesToNat {
+		if r == "ALL_IP_RANGES" || r == "PRIMARY_IP_RANGE" {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceURLsEqual compares two GCE resource URLs, ignoring differences in the
+// API endpoint or version.
+func resourceURLsEqual(a, b string) bool {
+	idA, err := cloud.ParseResourceURL(a)
+	if err != nil {
+		klog.V(4).Infof("resourceURLsEqual(): failed to parse resource URL %q: %v", a, err)
+		return a == b
+	}
+	idB, err := cloud.ParseResourceURL(b)
+	if err != nil {
+		klog.V(4).Infof("resourceURLsEqual(): failed to parse resource URL %q: %v", b, err)
+		return a == b
+	}
+	return idA.Equal(idB)
+}
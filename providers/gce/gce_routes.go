@@ -47,15 +47,17 @@ func (g *Cloud) ListRoutes(ctx context.Context, clusterName string) ([]*cloudpro
 	mc := newRoutesMetricContext("list")
 	prefix := truncateClusterName(clusterName)
 	f := filter.Regexp("name", prefix+"-.*").AndRegexp("network", g.NetworkURL()).AndRegexp("description", k8sNodeRouteTag)
-	routes, err := g.c.Routes().List(timeoutCtx, f)
+	routes, err := g.c.Routes().List(withListResponseFields(timeoutCtx, routeFields), f)
 	if err != nil {
 		return nil, mc.Observe(err)
 	}
 	var croutes []*cloudprovider.Route
 	for _, r := range routes {
+		// The next hop is .../zones/<zone>/instances/<instance>, the routes
+		// target the nodes, which may be named by the FQDN of their instance.
 		target := path.Base(r.NextHopInstance)
-		// TODO: Should we lastComponent(target) this?
-		targetNodeName := types.NodeName(target) // NodeName == Instance Name on GCE
+		zone := path.Base(path.Dir(path.Dir(r.NextHopInstance)))
+		targetNodeName := types.NodeName(g.nodeInstances.nodeName(zone, target))
 		croutes = append(croutes, &cloudprovider.Route{
 			Name:            r.Name,
 			TargetNode:      targetNodeName,
@@ -65,32 +67,55 @@ func (g *Cloud) ListRoutes(ctx context.Context, clusterName string) ([]*cloudpro
 	return croutes, mc.Observe(nil)
 }
 
-// CreateRoute in the cloud environment.
+// CreateRoute in the cloud environment. The routes which fail to be created
+// are backed off, and the NetworkUnavailable condition of their node reports
+// the GCE error.
 func (g *Cloud) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
 	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Hour)
 	defer cancel()
 
-	mc := newRoutesMetricContext("create")
+	// TODO(thockin): generate a unique name for node + route cidr. Don't depend on name hints.
+	routeName := truncateClusterName(clusterName) + "-" + nameHint
+	backoffKey := routeBackoffKey(route.TargetNode, route.DestinationCIDR)
+	if f, ok := g.routeBackoff.pending(backoffKey); ok {
+		g.setRouteFailureCondition(timeoutCtx, route.TargetNode, f.reason, f.message)
+		return fmt.Errorf("creation of route %s backed off until %s after %d failures: %s", routeName, f.retryAt.Format(time.RFC3339), f.count, f.message)
+	}
 
-	targetInstance, err := g.getInstanceByName(mapNodeNameToInstanceName(route.TargetNode))
+	mc := newRoutesMetricContext("create")
+	err := g.runRouteOperation(timeoutCtx, &routeOperation{name: routeName, route: route})
 	if err != nil {
+		reason, message := routeFailureReason(err), routeFailureMessage(routeName, route.DestinationCIDR, err)
+		delay := g.routeBackoff.failed(backoffKey, reason, message)
+		klog.Warningf("Failed to create route %s for node %s, retrying in %v: %v", routeName, route.TargetNode, delay, err)
+		g.setRouteFailureCondition(timeoutCtx, route.TargetNode, reason, message)
 		return mc.Observe(err)
 	}
+	g.routeBackoff.succeeded(backoffKey)
+	return mc.Observe(nil)
+}
+
+// createRoute creates the route routeName to the target node of route, whose
+// instance is taken from the instances of its batch if found there.
+func (g *Cloud) createRoute(ctx context.Context, routeName string, route *cloudprovider.Route, instances map[string]*gceInstance) error {
+	targetInstance, err := g.routeTargetInstance(route, instances)
+	if err != nil {
+		return err
+	}
 	cr := &compute.Route{
-		// TODO(thockin): generate a unique name for node + route cidr. Don't depend on name hints.
-		Name:            truncateClusterName(clusterName) + "-" + nameHint,
+		Name:            routeName,
 		DestRange:       route.DestinationCIDR,
 		NextHopInstance: fmt.Sprintf("zones/%s/instances/%s", targetInstance.Zone, targetInstance.Name),
 		Network:         g.NetworkURL(),
 		Priority:        1000,
 		Description:     k8sNodeRouteTag,
 	}
-	err = g.c.Routes().Insert(timeoutCtx, meta.GlobalKey(cr.Name), cr)
+	err = g.c.Routes().Insert(ctx, meta.GlobalKey(cr.Name), cr)
 	if isHTTPErrorCode(err, http.StatusConflict) {
 		klog.Infof("Route %q already exists.", cr.Name)
 		err = nil
 	}
-	return mc.Observe(err)
+	return err
 }
 
 // DeleteRoute from the cloud environment.
@@ -99,7 +124,11 @@ func (g *Cloud) DeleteRoute(ctx context.Context, clusterName string, route *clou
 	defer cancel()
 
 	mc := newRoutesMetricContext("delete")
-	return mc.Observe(g.c.Routes().Delete(timeoutCtx, meta.GlobalKey(route.Name)))
+	return mc.Observe(g.runRouteOperation(timeoutCtx, &routeOperation{name: route.Name}))
+}
+
+func (g *Cloud) deleteRoute(ctx context.Context, routeName string) error {
+	return g.c.Routes().Delete(ctx, meta.GlobalKey(routeName))
 }
 
 func truncateClusterName(clusterName string) string {
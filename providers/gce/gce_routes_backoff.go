@@ -0,0 +1,212 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// routeCreationInitialBackoff is the delay before retrying the creation
+	// of a route which failed once. The delay doubles at each failure.
+	routeCreationInitialBackoff = 30 * time.Second
+	// routeCreationMaxBackoff caps the delay between the creation attempts of
+	// a route, so that the permanently failing nodes are retried every few
+	// minutes instead of at every route controller sync.
+	routeCreationMaxBackoff = 10 * time.Minute
+
+	// The reasons of the NetworkUnavailable condition of the nodes whose
+	// route could not be created.
+	routeReasonQuotaExceeded  = "RouteQuotaExceeded"
+	routeReasonConflict       = "RouteConflict"
+	routeReasonTargetNotFound = "RouteTargetNotFound"
+	routeReasonFailed         = "RouteCreationFailed"
+)
+
+// routeFailure is the last failed creation of the route of a node.
+type routeFailure struct {
+	count   int
+	retryAt time.Time
+	reason  string
+	message string
+}
+
+// routeCreationBackoff tracks the failed route creations by node and
+// destination CIDR. The route controller retries the creation of the missing
+// routes at every sync, the failing routes are only retried once their
+// back-off expires.
+type routeCreationBackoff struct {
+	lock     sync.Mutex
+	failures map[string]*routeFailure
+	// now is overridden by the tests.
+	now func() time.Time
+}
+
+func routeBackoffKey(nodeName types.NodeName, destinationCIDR string) string {
+	return string(nodeName) + "/" + destinationCIDR
+}
+
+func (b *routeCreationBackoff) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// pending returns the last failure of the route if its back-off has not
+// expired yet.
+func (b *routeCreationBackoff) pending(key string) (routeFailure, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	f, ok := b.failures[key]
+	if !ok || !b.clock().Before(f.retryAt) {
+		return routeFailure{}, false
+	}
+	return *f, true
+}
+
+// failed records a failed creation of the route and returns the delay before
+// the next attempt.
+func (b *routeCreationBackoff) failed(key, reason, message string) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures == nil {
+		b.failures = map[string]*routeFailure{}
+	}
+	now := b.clock()
+	// Forget the routes which have not been retried for long, e.g. of the
+	// deleted nodes.
+	for k, f := range b.failures {
+		if now.Sub(f.retryAt) > 2*routeCreationMaxBackoff {
+			delete(b.failures, k)
+		}
+	}
+	f, ok := b.failures[key]
+	if !ok {
+		f = &routeFailure{}
+		b.failures[key] = f
+	}
+	f.count++
+	delay := routeCreationInitialBackoff
+	for i := 1; i < f.count && delay < routeCreationMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > routeCreationMaxBackoff {
+		delay = routeCreationMaxBackoff
+	}
+	f.retryAt = now.Add(delay)
+	f.reason = reason
+	f.message = message
+	return delay
+}
+
+func (b *routeCreationBackoff) succeeded(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.failures, key)
+}
+
+// routeFailureReason returns the reason of the NetworkUnavailable condition
+// of a node whose route creation failed with err.
+func routeFailureReason(err error) string {
+	if errors.Is(err, cloudprovider.InstanceNotFound) {
+		return routeReasonTargetNotFound
+	}
+	code, _, _ := gceErrorCode(err)
+	switch code {
+	case "QUOTA_EXCEEDED", "quotaExceeded":
+		return routeReasonQuotaExceeded
+	case "RESOURCE_ALREADY_EXISTS", "alreadyExists":
+		return routeReasonConflict
+	}
+	return routeReasonFailed
+}
+
+// routeFailureMessage returns the message of the NetworkUnavailable condition
+// of a node whose route creation failed with err.
+func routeFailureMessage(routeName, destinationCIDR string, err error) string {
+	msg := fmt.Sprintf("GCE route %s for %s could not be created: %v", routeName, destinationCIDR, err)
+	if code, _, _ := gceErrorCode(err); code != "" {
+		if hint, ok := gceErrorHints[code]; ok {
+			msg += " (hint: " + hint + ")"
+		}
+	}
+	return msg
+}
+
+// setRouteFailureCondition sets the NetworkUnavailable condition of the node
+// to the reason of its route creation failure. The route controller only
+// reports a generic NoRouteCreated reason, which it does not overwrite while
+// the condition stays true, so the condition is set again at each skipped
+// attempt.
+func (g *Cloud) setRouteFailureCondition(ctx context.Context, nodeName types.NodeName, reason, message string) {
+	if g.client == nil {
+		return
+	}
+	node, err := g.client.CoreV1().Nodes().Get(ctx, string(nodeName), metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to get node %s to report its route failure: %v", nodeName, err)
+		}
+		return
+	}
+	now := metav1.Now()
+	transitionTime := now
+	for _, c := range node.Status.Conditions {
+		if c.Type != v1.NodeNetworkUnavailable || c.Status != v1.ConditionTrue {
+			continue
+		}
+		if c.Reason == reason && c.Message == message {
+			return
+		}
+		transitionTime = c.LastTransitionTime
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{{
+				Type:               v1.NodeNetworkUnavailable,
+				Status:             v1.ConditionTrue,
+				Reason:             reason,
+				Message:            message,
+				LastHeartbeatTime:  now,
+				LastTransitionTime: transitionTime,
+			}},
+		},
+	})
+	if err != nil {
+		klog.Warningf("Failed to report the route failure of node %s: %v", nodeName, err)
+		return
+	}
+	if _, err := g.client.CoreV1().Nodes().PatchStatus(ctx, string(nodeName), patch); err != nil {
+		klog.Warningf("Failed to report the route failure of node %s: %v", nodeName, err)
+	}
+}
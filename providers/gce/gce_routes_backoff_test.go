@@ -0,0 +1,186 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestRouteFailureReason(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{
+			desc: "quota exceeded operation",
+			err:  &googleapi.Error{Code: http.StatusForbidden, Message: "QUOTA_EXCEEDED - Quota 'ROUTES' exceeded."},
+			want: routeReasonQuotaExceeded,
+		},
+		{
+			desc: "quota exceeded API call",
+			err:  &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}},
+			want: routeReasonQuotaExceeded,
+		},
+		{
+			desc: "conflicting operation",
+			err:  &googleapi.Error{Code: http.StatusBadRequest, Message: "RESOURCE_ALREADY_EXISTS - The route already exists."},
+			want: routeReasonConflict,
+		},
+		{
+			desc: "missing instance",
+			err:  cloudprovider.InstanceNotFound,
+			want: routeReasonTargetNotFound,
+		},
+		{
+			desc: "other error",
+			err:  errors.New("connection reset"),
+			want: routeReasonFailed,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := routeFailureReason(tc.err); got != tc.want {
+				t.Errorf("routeFailureReason(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRouteCreationBackoffDelay(t *testing.T) {
+	now := time.Now()
+	b := routeCreationBackoff{now: func() time.Time { return now }}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, w := range want {
+		if got := b.failed("node/10.0.0.0/24", routeReasonFailed, "failed"); got != w {
+			t.Errorf("failure %d: back-off = %v, want %v", i+1, got, w)
+		}
+	}
+	b.succeeded("node/10.0.0.0/24")
+	if got := b.failed("node/10.0.0.0/24", routeReasonFailed, "failed"); got != routeCreationInitialBackoff {
+		t.Errorf("back-off after success = %v, want %v", got, routeCreationInitialBackoff)
+	}
+}
+
+func TestCreateRouteBackoff(t *testing.T) {
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	if err != nil {
+		t.Fatalf("fakeGCECloud(%v) = %v", vals, err)
+	}
+	now := time.Now()
+	gce.routeBackoff.now = func() time.Time { return now }
+
+	nodeName := "test-node-1"
+	if _, err := createAndInsertNodes(gce, []string{nodeName}, vals.ZoneName); err != nil {
+		t.Fatalf("createAndInsertNodes() = %v", err)
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	if _, err := gce.client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create(%s) = %v", nodeName, err)
+	}
+
+	inserts := 0
+	quotaErr := &googleapi.Error{Code: http.StatusForbidden, Message: "QUOTA_EXCEEDED - Quota 'ROUTES' exceeded. Limit: 250.0 globally."}
+	gce.c.(*cloud.MockGCE).MockRoutes.InsertHook = func(ctx context.Context, key *meta.Key, obj *compute.Route, m *cloud.MockRoutes, options ...cloud.Option) (bool, error) {
+		inserts++
+		if quotaErr != nil {
+			return true, quotaErr
+		}
+		return false, nil
+	}
+
+	route := &cloudprovider.Route{TargetNode: types.NodeName(nodeName), DestinationCIDR: "10.0.1.0/24"}
+	createRoute := func() error {
+		return gce.CreateRoute(context.TODO(), vals.ClusterName, "hint", route)
+	}
+	checkCondition := func(wantReason string) {
+		t.Helper()
+		node, err := gce.client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%s) = %v", nodeName, err)
+		}
+		for _, c := range node.Status.Conditions {
+			if c.Type != v1.NodeNetworkUnavailable {
+				continue
+			}
+			if c.Status != v1.ConditionTrue || c.Reason != wantReason || !strings.Contains(c.Message, "QUOTA_EXCEEDED") {
+				t.Errorf("NetworkUnavailable condition = %+v, want true with reason %s and the GCE error", c, wantReason)
+			}
+			return
+		}
+		t.Errorf("node %s has no NetworkUnavailable condition", nodeName)
+	}
+
+	if err := createRoute(); err == nil {
+		t.Fatalf("CreateRoute() = nil, want the quota error")
+	}
+	checkCondition(routeReasonQuotaExceeded)
+
+	// The route controller overwrites the condition with its generic reason.
+	if err := setNetworkUnavailable(gce, nodeName, "NoRouteCreated"); err != nil {
+		t.Fatal(err)
+	}
+	if err := createRoute(); err == nil {
+		t.Fatalf("CreateRoute() = nil during the back-off, want an error")
+	}
+	if inserts != 1 {
+		t.Errorf("route inserted %d times during the back-off, want 1", inserts)
+	}
+	checkCondition(routeReasonQuotaExceeded)
+
+	quotaErr = nil
+	now = now.Add(routeCreationInitialBackoff)
+	if err := createRoute(); err != nil {
+		t.Fatalf("CreateRoute() = %v after the back-off, want nil", err)
+	}
+	if inserts != 2 {
+		t.Errorf("route inserted %d times after the back-off, want 2", inserts)
+	}
+	if _, ok := gce.routeBackoff.failures[routeBackoffKey(route.TargetNode, route.DestinationCIDR)]; ok {
+		t.Errorf("route back-off not reset after the route creation")
+	}
+}
+
+func setNetworkUnavailable(gce *Cloud, nodeName, reason string) error {
+	node, err := gce.client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeNetworkUnavailable, Status: v1.ConditionTrue, Reason: reason}}
+	if _, err := gce.client.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("UpdateStatus(%s) = %v", nodeName, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// routeBatchWindow is the time the route operations of a batch are
+	// collected for. The route controller creates the routes of all its
+	// nodes at once, so that a short window collects most of them.
+	routeBatchWindow = 100 * time.Millisecond
+	// routeOperationTimeout is the timeout of a route operation, which is not
+	// bound to the context of the calls waiting for it.
+	routeOperationTimeout = 1 * time.Hour
+)
+
+// routeOperations batches the route creations and deletions of the route
+// controller, which calls CreateRoute and DeleteRoute for each of its routes.
+// The creations of a batch look their target instances up with a list per
+// zone instead of a get per route, the calls for a route whose operation is
+// outstanding wait for that operation instead of issuing another one, and the
+// operations are run, and their GCE operations polled, concurrently up to the
+// configured parallelism.
+type routeOperations struct {
+	// parallelism is the maximum number of route operations in flight, no
+	// limit if not positive.
+	parallelism int
+	// window is the time the operations of a batch are collected for.
+	window time.Duration
+	// clock schedules the batches.
+	clock clock.WithDelayedExecution
+
+	lock sync.Mutex
+	// inFlight are the outstanding operations by key.
+	inFlight map[string]*routeOperation
+	// pending are the operations of the next batch.
+	pending []*routeOperation
+	// tokens bounds the operations in flight to the parallelism.
+	tokens chan struct{}
+}
+
+// routeOperation is the creation or the deletion of a route.
+type routeOperation struct {
+	name string
+	// route is the route created, nil for a deletion.
+	route *cloudprovider.Route
+
+	// done is closed once err is set.
+	done chan struct{}
+	err  error
+}
+
+// validateRouteOperationsParallelism validates the route operations
+// parallelism of the cloud config.
+func validateRouteOperationsParallelism(parallelism int) error {
+	if parallelism < 0 {
+		return fmt.Errorf("route-operations-parallelism must not be negative: %d", parallelism)
+	}
+	return nil
+}
+
+func (op *routeOperation) key() string {
+	if op.route == nil {
+		return "delete/" + op.name
+	}
+	return "create/" + op.name
+}
+
+// runRouteOperation runs op in the next batch of route operations, or waits
+// for the outstanding operation of the same route, and returns its error.
+func (g *Cloud) runRouteOperation(ctx context.Context, op *routeOperation) error {
+	r := &g.routeOps
+	r.lock.Lock()
+	if outstanding, ok := r.inFlight[op.key()]; ok {
+		r.lock.Unlock()
+		klog.V(4).Infof("Waiting for the outstanding operation of route %s", op.name)
+		return waitRouteOperation(ctx, outstanding)
+	}
+	if r.inFlight == nil {
+		r.inFlight = map[string]*routeOperation{}
+	}
+	if r.tokens == nil && r.parallelism > 0 {
+		r.tokens = make(chan struct{}, r.parallelism)
+	}
+	op.done = make(chan struct{})
+	r.inFlight[op.key()] = op
+	r.pending = append(r.pending, op)
+	if len(r.pending) == 1 {
+		r.clock.AfterFunc(r.window, g.runRouteBatch)
+	}
+	r.lock.Unlock()
+	return waitRouteOperation(ctx, op)
+}
+
+func waitRouteOperation(ctx context.Context, op *routeOperation) error {
+	select {
+	case <-op.done:
+		return op.err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the operation of route %s: %w", op.name, ctx.Err())
+	}
+}
+
+// runRouteBatch runs the pending route operations.
+func (g *Cloud) runRouteBatch() {
+	r := &g.routeOps
+	r.lock.Lock()
+	batch := r.pending
+	r.pending = nil
+	tokens := r.tokens
+	r.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), routeOperationTimeout)
+	defer cancel()
+	instances := g.routeBatchInstances(batch)
+
+	var wg sync.WaitGroup
+	for _, op := range batch {
+		if tokens != nil {
+			tokens <- struct{}{}
+		}
+		wg.Add(1)
+		go func(op *routeOperation) {
+			defer wg.Done()
+			if op.route == nil {
+				op.err = g.deleteRoute(ctx, op.name)
+			} else {
+				op.err = g.createRoute(ctx, op.name, op.route, instances)
+			}
+			if tokens != nil {
+				<-tokens
+			}
+			r.lock.Lock()
+			delete(r.inFlight, op.key())
+			r.lock.Unlock()
+			close(op.done)
+		}(op)
+	}
+	wg.Wait()
+}
+
+// routeBatchInstances returns the target instances of the route creations of
+// batch by instance name, looked up at once. The creations whose instance is
+// not returned look it up themselves.
+func (g *Cloud) routeBatchInstances(batch []*routeOperation) map[string]*gceInstance {
+	var names []string
+	for _, op := range batch {
+		if op.route != nil {
+			names = append(names, MapNodeNameToInstanceName(op.route.TargetNode))
+		}
+	}
+	if len(names) < 2 {
+		return nil
+	}
+	found, err := g.getFoundInstanceByNames(names)
+	if err != nil {
+		klog.Warningf("Failed to look up the instances of %d routes, looking them up one by one: %v", len(names), err)
+		return nil
+	}
+	instances := make(map[string]*gceInstance, len(found))
+	for _, inst := range found {
+		instances[inst.Name] = inst
+	}
+	return instances
+}
+
+// routeTargetInstance returns the instance of the target node of route, from
+// instances if it was looked up by its batch.
+func (g *Cloud) routeTargetInstance(route *cloudprovider.Route, instances map[string]*gceInstance) (*gceInstance, error) {
+	name := MapNodeNameToInstanceName(route.TargetNode)
+	instanceName := canonicalizeInstanceName(name)
+	if inst, ok := g.nodeInstances.get(name); ok {
+		instanceName = inst.name
+	}
+	if inst, ok := instances[instanceName]; ok {
+		return inst, nil
+	}
+	return g.getInstanceByName(name)
+}
@@ -0,0 +1,198 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ga "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudprovider "k8s.io/cloud-provider"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestCreateRoutesBatched(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	const routes = 10
+	var nodeNames []string
+	for i := 0; i < routes; i++ {
+		nodeNames = append(nodeNames, fmt.Sprintf("test-node-%d", i))
+	}
+	_, err = createAndInsertNodes(gce, nodeNames, vals.ZoneName)
+	require.NoError(t, err)
+	gce.routeOps.parallelism = 3
+	fakeClock := setRouteBatchClock(gce)
+
+	mockGCE := gce.c.(*cloud.MockGCE)
+	var gets, lists int32
+	mockGCE.MockInstances.GetHook = func(context.Context, *meta.Key, *cloud.MockInstances, ...cloud.Option) (bool, *ga.Instance, error) {
+		atomic.AddInt32(&gets, 1)
+		return false, nil, nil
+	}
+	mockGCE.MockInstances.ListHook = func(context.Context, string, *filter.F, *cloud.MockInstances, ...cloud.Option) (bool, []*ga.Instance, error) {
+		atomic.AddInt32(&lists, 1)
+		return false, nil, nil
+	}
+	var inFlight, maxInFlight, inserts int32
+	release := make(chan struct{})
+	mockGCE.MockRoutes.InsertHook = func(context.Context, *meta.Key, *ga.Route, *cloud.MockRoutes, ...cloud.Option) (bool, error) {
+		atomic.AddInt32(&inserts, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		<-release
+		return false, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, routes)
+	for i, nodeName := range nodeNames {
+		wg.Add(1)
+		go func(i int, nodeName string) {
+			defer wg.Done()
+			route := &cloudprovider.Route{TargetNode: types.NodeName(nodeName), DestinationCIDR: fmt.Sprintf("10.0.%d.0/24", i)}
+			errs[i] = gce.CreateRoute(context.TODO(), vals.ClusterName, nodeName, route)
+		}(i, nodeName)
+	}
+	waitForPendingRouteOperations(t, gce, routes)
+
+	// The creation of an outstanding route waits for its operation instead of
+	// issuing another one.
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	route := &cloudprovider.Route{TargetNode: types.NodeName(nodeNames[0]), DestinationCIDR: "10.0.0.0/24"}
+	assert.ErrorIs(t, gce.CreateRoute(ctx, vals.ClusterName, nodeNames[0], route), context.Canceled)
+	waitForPendingRouteOperations(t, gce, routes)
+
+	// The batch runs in the callback of the clock.
+	go fakeClock.Step(routeBatchWindow)
+	require.NoError(t, wait.PollUntilContextTimeout(context.TODO(), time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return atomic.LoadInt32(&inserts) == 3, nil
+	}))
+	close(release)
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(routes), atomic.LoadInt32(&inserts))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&maxInFlight))
+	assert.Zero(t, atomic.LoadInt32(&gets), "the instances of the batch were looked up one by one")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lists))
+
+	listed, err := gce.ListRoutes(context.TODO(), vals.ClusterName)
+	require.NoError(t, err)
+	assert.Len(t, listed, routes)
+	for i, route := range listed {
+		wg.Add(1)
+		go func(i int, route *cloudprovider.Route) {
+			defer wg.Done()
+			errs[i] = gce.DeleteRoute(context.TODO(), vals.ClusterName, route)
+		}(i, route)
+	}
+	waitForPendingRouteOperations(t, gce, routes)
+	fakeClock.Step(routeBatchWindow)
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	listed, err = gce.ListRoutes(context.TODO(), vals.ClusterName)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+}
+
+func TestRouteOperationContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	vals := DefaultTestClusterValues()
+	gce, err := fakeGCECloud(vals)
+	require.NoError(t, err)
+	fakeClock := setRouteBatchClock(gce)
+	var deletes int32
+	gce.c.(*cloud.MockGCE).MockRoutes.DeleteHook = func(context.Context, *meta.Key, *cloud.MockRoutes, ...cloud.Option) (bool, error) {
+		atomic.AddInt32(&deletes, 1)
+		return true, nil
+	}
+	route := &cloudprovider.Route{Name: "route"}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err = gce.DeleteRoute(ctx, vals.ClusterName, route)
+	assert.ErrorIs(t, err, context.Canceled)
+	waitForPendingRouteOperations(t, gce, 1)
+
+	// The next call waits for the outstanding deletion.
+	err = gce.DeleteRoute(ctx, vals.ClusterName, route)
+	assert.ErrorIs(t, err, context.Canceled)
+	waitForPendingRouteOperations(t, gce, 1)
+
+	// The deletion runs although its callers gave up.
+	fakeClock.Step(routeBatchWindow)
+	gce.routeOps.lock.Lock()
+	assert.Empty(t, gce.routeOps.inFlight)
+	gce.routeOps.lock.Unlock()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&deletes))
+}
+
+// setRouteBatchClock makes the route batches of gce run once the returned
+// clock is stepped by the batch window.
+func setRouteBatchClock(gce *Cloud) *testingclock.FakeClock {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	gce.routeOps.window = routeBatchWindow
+	gce.routeOps.clock = fakeClock
+	return fakeClock
+}
+
+// waitForPendingRouteOperations waits for n route operations to be pending.
+func waitForPendingRouteOperations(t *testing.T, gce *Cloud, n int) {
+	t.Helper()
+	err := wait.PollUntilContextTimeout(context.TODO(), time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		gce.routeOps.lock.Lock()
+		defer gce.routeOps.lock.Unlock()
+		return len(gce.routeOps.pending) == n, nil
+	})
+	require.NoError(t, err)
+}
+
+func TestValidateRouteOperationsParallelism(t *testing.T) {
+	assert.NoError(t, validateRouteOperationsParallelism(0))
+	assert.NoError(t, validateRouteOperationsParallelism(200))
+	assert.Error(t, validateRouteOperationsParallelism(-1))
+}
@@ -0,0 +1,68 @@
+//go:build !providerless
+// +build !providerless
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func newServiceAttachmentMetricContext(request, region string) *metricContext {
+	return newGenericMetricContext("serviceattachments", request, region, unusedMetricLabel, computeV1Version)
+}
+
+// GetServiceAttachment returns the ServiceAttachment by name.
+func (g *Cloud) GetServiceAttachment(name, region string) (*compute.ServiceAttachment, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newServiceAttachmentMetricContext("get", region)
+	v, err := g.c.ServiceAttachments().Get(ctx, meta.RegionalKey(name, region))
+	return v, mc.Observe(err)
+}
+
+// CreateServiceAttachment creates the given ServiceAttachment.
+func (g *Cloud) CreateServiceAttachment(sa *compute.ServiceAttachment, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newServiceAttachmentMetricContext("create", region)
+	return mc.Observe(g.c.ServiceAttachments().Insert(ctx, meta.RegionalKey(sa.Name, region), sa))
+}
+
+// PatchServiceAttachment applies the given ServiceAttachment as a patch to
+// the existing ServiceAttachment, whose fingerprint it must carry.
+func (g *Cloud) PatchServiceAttachment(sa *compute.ServiceAttachment, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newServiceAttachmentMetricContext("patch", region)
+	return mc.Observe(g.c.ServiceAttachments().Patch(ctx, meta.RegionalKey(sa.Name, region), sa))
+}
+
+// DeleteServiceAttachment deletes the ServiceAttachment by name.
+func (g *Cloud) DeleteServiceAttachment(name, region string) error {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newServiceAttachmentMetricContext("delete", region)
+	return mc.Observe(g.c.ServiceAttachments().Delete(ctx, meta.RegionalKey(name, region)))
+}
@@ -18,6 +18,7 @@ package gce
 
 import (
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/filter"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 
 	compute "google.golang.org/api/compute/v1"
@@ -37,3 +38,14 @@ func (g *Cloud) GetSubnetwork(region, subnetworkName string) (*compute.Subnetwor
 	subnetwork, err := g.Compute().Subnetworks().Get(ctx, key)
 	return subnetwork, mc.Observe(err)
 }
+
+// ListSubnetworksInProject returns the subnetworks of the region in the
+// project, e.g. the project of a VPC peered with the network of the cluster.
+func (g *Cloud) ListSubnetworksInProject(projectID, region string) ([]*compute.Subnetwork, error) {
+	ctx, cancel := cloud.ContextWithCallTimeout()
+	defer cancel()
+
+	mc := newSubnetworkMetricContext("list", region)
+	subnetworks, err := g.Compute().Subnetworks().List(ctx, region, filter.None, cloud.ForceProjectID(projectID))
+	return subnetworks, mc.Observe(err)
+}
@@ -24,6 +24,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/oauth2/google"
 
@@ -479,6 +480,49 @@ func TestGenerateCloudConfigs(t *testing.T) {
 				return v
 			},
 		},
+		{
+			name: "Target Pool Subsetting",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.MaxTargetPoolInstances = 250
+				v.TargetPoolSubsettingStrategy = "alphabetical"
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.MaxTargetPoolInstances = 250
+				v.TargetPoolSubsettingStrategy = TargetPoolSubsettingAlphabetical
+				return v
+			},
+		},
+		{
+			name: "Missing Instance Confirmation",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.MissingInstanceConfirmations = 3
+				v.MissingInstanceGracePeriod = "5m"
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.MissingInstanceConfirmations = 3
+				v.MissingInstanceGracePeriod = 5 * time.Minute
+				return v
+			},
+		},
+		{
+			name: "Route Operations Parallelism",
+			config: func() ConfigGlobal {
+				v := configBoilerplate
+				v.RouteOperationsParallelism = 50
+				return v
+			},
+			cloud: func() CloudConfig {
+				v := cloudBoilerplate
+				v.RouteOperationsParallelism = 50
+				return v
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -496,6 +540,74 @@ func TestGenerateCloudConfigs(t *testing.T) {
 	}
 }
 
+func TestGenerateCloudConfigInvalidTargetPoolSubsetting(t *testing.T) {
+	for _, global := range []ConfigGlobal{
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", MaxTargetPoolInstances: -1},
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", TargetPoolSubsettingStrategy: "random"},
+	} {
+		if _, err := generateCloudConfig(&ConfigFile{Global: global}); err == nil {
+			t.Errorf("generateCloudConfig(%+v) = nil error, want error", global)
+		}
+	}
+}
+
+func TestGenerateCloudConfigInvalidMissingInstanceConfirmation(t *testing.T) {
+	for _, global := range []ConfigGlobal{
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", MissingInstanceConfirmations: -1},
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", MissingInstanceGracePeriod: "5 minutes"},
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", MissingInstanceGracePeriod: "-5m"},
+	} {
+		if _, err := generateCloudConfig(&ConfigFile{Global: global}); err == nil {
+			t.Errorf("generateCloudConfig(%+v) = nil error, want error", global)
+		}
+	}
+}
+
+func TestGenerateCloudConfigInvalidRouteOperationsParallelism(t *testing.T) {
+	global := ConfigGlobal{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", RouteOperationsParallelism: -1}
+	if _, err := generateCloudConfig(&ConfigFile{Global: global}); err == nil {
+		t.Errorf("generateCloudConfig(%+v) = nil error, want error", global)
+	}
+}
+
+func TestGenerateCloudConfigInvalidXPNFirewalls(t *testing.T) {
+	for _, global := range []ConfigGlobal{
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", XPNCredentialsFile: "/etc/xpn.json"},
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", XPNFirewalls: true, XPNCredentialsFile: "/nonexistent/xpn.json"},
+	} {
+		if _, err := generateCloudConfig(&ConfigFile{Global: global}); err == nil {
+			t.Errorf("generateCloudConfig(%+v) = nil error, want error", global)
+		}
+	}
+}
+
+func TestGenerateCloudConfigInvalidAPIEndpoint(t *testing.T) {
+	for _, global := range []ConfigGlobal{
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", APIEndpoint: "compute.googleapis.com/compute/v1/"},
+		{ProjectID: "project-id", NetworkName: "network-name", LocalZone: "us-central1-a", ContainerAPIEndpoint: "ftp://container.googleapis.com/"},
+	} {
+		if _, err := generateCloudConfig(&ConfigFile{Global: global}); err == nil {
+			t.Errorf("generateCloudConfig(%+v) = nil error, want error", global)
+		}
+	}
+}
+
+func TestVersionedAPIEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		want     string
+	}{
+		{"https://www.googleapis.com/compute/v1/", "https://www.googleapis.com/compute/beta/"},
+		{"https://www.googleapis.com/compute/staging_v1/", "https://www.googleapis.com/compute/staging_beta/"},
+		{"https://www-cp-v1.p.googleapis.com/compute/v1/", "https://www-cp-v1.p.googleapis.com/compute/beta/"},
+		{"https://compute.example.com/", "https://compute.example.com/"},
+	} {
+		if got := versionedAPIEndpoint(tc.endpoint, "beta"); got != tc.want {
+			t.Errorf("versionedAPIEndpoint(%q, beta) = %q, want %q", tc.endpoint, got, tc.want)
+		}
+	}
+}
+
 func TestNewAlphaFeatureGate(t *testing.T) {
 	testCases := []struct {
 		alphaFeatures  []string
@@ -119,11 +119,15 @@ func registerTargetPoolRemoveInstanceHook(gce *Cloud, callback func(*compute.Tar
 }
 
 type gceInstance struct {
-	Zone  string
-	Name  string
-	ID    uint64
+	Zone string
+	Name string
+	ID   uint64
+	// Disks are the attached disks, with their device name only.
 	Disks []*compute.AttachedDisk
 	Type  string
+	// Subnetwork is the subnetwork URL of the primary network interface,
+	// empty on legacy networks.
+	Subnetwork string
 }
 
 var (
@@ -215,10 +219,12 @@ func lastComponent(s string) string {
 	return s
 }
 
-// mapNodeNameToInstanceName maps a k8s NodeName to a GCE Instance Name
-// This is a simple string cast.
-func mapNodeNameToInstanceName(nodeName types.NodeName) string {
-	return string(nodeName)
+// MapNodeNameToInstanceName maps a k8s NodeName to a GCE Instance Name.
+// The nodes are named like their instances, or like the FQDN hostnames of
+// their instances, e.g. 'kubernetes-node-2.c.my-proj.internal', which are
+// reduced to the instance name.
+func MapNodeNameToInstanceName(nodeName types.NodeName) string {
+	return canonicalizeInstanceName(string(nodeName))
 }
 
 // GetGCERegion returns region of the gce zone. Zone names
@@ -238,6 +244,12 @@ func isHTTPErrorCode(err error, code int) bool {
 	return ok && apiErr.Code == code
 }
 
+// IsHTTPErrorCode returns whether err is a GCE API error with the given HTTP
+// status code.
+func IsHTTPErrorCode(err error, code int) bool {
+	return isHTTPErrorCode(err, code)
+}
+
 func isInUsedByError(err error) bool {
 	apiErr, ok := err.(*googleapi.Error)
 	if !ok || apiErr.Code != http.StatusBadRequest {
@@ -249,12 +261,16 @@ func isInUsedByError(err error) bool {
 // splitProviderID splits a provider's id into core components.
 // A providerID is build out of '${ProviderName}://${project-id}/${zone}/${instance-name}'
 // See cloudprovider.GetInstanceProviderID.
+// The providerIDs in other formats are split by the ProviderIDParsers.
 func splitProviderID(providerID string) (project, zone, instance string, err error) {
 	matches := providerIDRE.FindStringSubmatch(providerID)
-	if len(matches) != 4 {
-		return "", "", "", errors.New("error splitting providerID")
+	if len(matches) == 4 {
+		return matches[1], matches[2], matches[3], nil
+	}
+	if project, zone, instance, ok := parseNonStandardProviderID(providerID); ok {
+		return project, zone, instance, nil
 	}
-	return matches[1], matches[2], matches[3], nil
+	return "", "", "", errors.New("error splitting providerID")
 }
 
 func equalStringSets(x, y []string) bool {
@@ -26,6 +26,7 @@ import (
 
 	compute "google.golang.org/api/compute/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	netutils "k8s.io/utils/net"
 )
 
@@ -122,6 +123,18 @@ func TestFirewallToGcloudArgs(t *testing.T) {
 	}
 }
 
+func TestMapNodeNameToInstanceName(t *testing.T) {
+	for nodeName, want := range map[types.NodeName]string{
+		"kubernetes-node-2":                                  "kubernetes-node-2",
+		"kubernetes-node-2.c.my-proj.internal":               "kubernetes-node-2",
+		"kubernetes-node-2.us-central1-b.c.my-proj.internal": "kubernetes-node-2",
+	} {
+		if got := MapNodeNameToInstanceName(nodeName); got != want {
+			t.Errorf("MapNodeNameToInstanceName(%q) = %q, want %q", nodeName, got, want)
+		}
+	}
+}
+
 // TestAddRemoveFinalizer tests the add/remove and hasFinalizer methods.
 func TestAddRemoveFinalizer(t *testing.T) {
 	svc := fakeLoadbalancerService(string(LBTypeInternal))
@@ -24,6 +24,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud"
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	compute "google.golang.org/api/compute/v1"
 )
 
 // gceProjectRouter sends requests to the appropriate project ID.
@@ -34,7 +35,7 @@ type gceProjectRouter struct {
 // ProjectID returns the project ID to be used for the given operation.
 func (r *gceProjectRouter) ProjectID(ctx context.Context, version meta.Version, service string) string {
 	switch service {
-	case "Firewalls", "Routes", "Subnetworks", "Networks":
+	case "Firewalls", "Routes", "Subnetworks", "Networks", "Routers":
 		return r.gce.NetworkProjectID()
 	default:
 		return r.gce.projectID
@@ -69,6 +70,24 @@ func (l *gceRateLimiter) Accept(ctx context.Context, key *cloud.RateLimitKey) er
 // Observe is a no-op func to satisfy cloud.RateLimiter
 func (*gceRateLimiter) Observe(context.Context, error, *cloud.RateLimitKey) {}
 
+// doComputeOperation makes a compute API call which k8s-cloud-provider does not
+// implement with the project router, the rate limiter and the operation polling
+// of the calls it implements. call starts the operation in the project of the
+// service of the call.
+func (g *Cloud) doComputeOperation(ctx context.Context, service, operation string, call func(projectID string) (*compute.Operation, error)) error {
+	projectID := g.s.ProjectRouter.ProjectID(ctx, meta.VersionGA, service)
+	key := &cloud.RateLimitKey{ProjectID: projectID, Operation: operation, Version: meta.VersionGA, Service: service}
+	if err := g.s.RateLimiter.Accept(ctx, key); err != nil {
+		return err
+	}
+	op, err := call(projectID)
+	if err == nil {
+		err = g.s.WaitForCompletion(ctx, op)
+	}
+	g.s.RateLimiter.Observe(ctx, err, key)
+	return err
+}
+
 // CreateGCECloudWithCloud is a helper function to create an instance of Cloud with the
 // given Cloud interface implementation. Typical usage is to use cloud.NewMockGCE to get a
 // handle to a mock Cloud instance and then use that for testing.
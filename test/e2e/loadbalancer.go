@@ -29,11 +29,14 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	"google.golang.org/api/compute/v1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+	e2epodoutput "k8s.io/kubernetes/test/e2e/framework/pod/output"
 	e2eservice "k8s.io/kubernetes/test/e2e/framework/service"
 	admissionapi "k8s.io/pod-security-admission/api"
 )
@@ -248,6 +251,74 @@ var _ = Describe("[cloud-provider-gcp-e2e] LoadBalancer", func() {
 		By("checking the UDP LoadBalancer is closed")
 		testNotReachableUDP(udpIngressIP, svcPort, loadBalancerLagTimeout)
 	})
+
+	f.It("should preserve the client IP of the flows of an internal UDP service with externalTrafficPolicy=Local", f.WithSlow(), func(ctx context.Context) {
+		loadBalancerCreateTimeout := e2eservice.GetServiceLoadBalancerCreationTimeout(ctx, cs)
+
+		serviceName := "udp-client-ip"
+		ns := f.Namespace.Name
+		udpJig := e2eservice.NewTestJig(cs, ns, serviceName)
+
+		By("creating a pod to be part of the UDP service " + serviceName)
+		_, err := udpJig.Run(ctx, nil)
+		framework.ExpectNoError(err)
+
+		By("creating an internal UDP service " + serviceName + " with type=LoadBalancer and externalTrafficPolicy=Local")
+		udpService, err := udpJig.CreateUDPService(ctx, func(s *v1.Service) {
+			s.Spec.Type = v1.ServiceTypeLoadBalancer
+			s.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+			s.Annotations = map[string]string{
+				"networking.gke.io/load-balancer-type":                        "Internal",
+				"networking.gke.io/internal-load-balancer-preserve-client-ip": "true",
+			}
+		})
+		framework.ExpectNoError(err)
+		defer func() {
+			framework.Logf("deleting the UDP service %s/%s", ns, serviceName)
+			if err := cs.CoreV1().Services(ns).Delete(ctx, serviceName, metav1.DeleteOptions{}); err != nil {
+				framework.Logf("failed to delete the UDP service %s/%s: %v", ns, serviceName, err)
+			}
+		}()
+
+		By("waiting for the UDP service to have a load balancer")
+		udpService, err = udpJig.WaitForLoadBalancer(ctx, loadBalancerCreateTimeout)
+		framework.ExpectNoError(err)
+		udpIngressIP := e2eservice.GetIngressPoint(&udpService.Status.LoadBalancer.Ingress[0])
+		svcPort := int(udpService.Spec.Ports[0].Port)
+		framework.Logf("UDP internal load balancer: %s", udpIngressIP)
+
+		By("checking the backend service does not keep the flows on the unhealthy nodes")
+		gceCloud, err := GetGCECloud()
+		framework.ExpectNoError(err, "failed to get GCE cloud provider")
+		bs, err := gceCloud.GetRegionBackendService(cloudprovider.DefaultLoadBalancerName(udpService), gceCloud.Region())
+		framework.ExpectNoError(err)
+		if bs.ConnectionTrackingPolicy == nil || bs.ConnectionTrackingPolicy.ConnectionPersistenceOnUnhealthyBackends != "NEVER_PERSIST" {
+			framework.Failf("backend service %s connection tracking policy is %+v, expected connection persistence NEVER_PERSIST", bs.Name, bs.ConnectionTrackingPolicy)
+		}
+
+		By("checking the client IP of the flows received by the endpoints")
+		execPod := e2epod.CreateExecPodOrFail(ctx, cs, ns, "execpod", nil)
+		clientIPs := []string{execPod.Status.PodIP, execPod.Status.HostIP}
+		cmd := fmt.Sprintf("echo clientip | nc -u -w 2 %s %d", udpIngressIP, svcPort)
+		err = wait.PollUntilContextTimeout(ctx, framework.Poll, loadBalancerCreateTimeout, true, func(ctx context.Context) (bool, error) {
+			stdout, err := e2epodoutput.RunHostCmd(ns, execPod.Name, cmd)
+			if err != nil || stdout == "" {
+				framework.Logf("UDP poke of %s:%d from pod %s failed, retrying: %v", udpIngressIP, svcPort, execPod.Name, err)
+				return false, nil
+			}
+			host, _, err := net.SplitHostPort(strings.TrimSpace(stdout))
+			if err != nil {
+				return false, fmt.Errorf("unexpected clientip response %q: %w", stdout, err)
+			}
+			for _, ip := range clientIPs {
+				if host == ip {
+					return true, nil
+				}
+			}
+			return false, fmt.Errorf("client IP %s received by the endpoint is SNATed, expected one of %v", host, clientIPs)
+		})
+		framework.ExpectNoError(err)
+	})
 })
 
 // Helper functions for loadbalancer tests.